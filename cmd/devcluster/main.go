@@ -0,0 +1,205 @@
+// devcluster sobe um cluster local inteiro num processo só: N nós em portas
+// seguidas, com o ring já montado (CLUSTER_NODES), e opcionalmente grava
+// dados de exemplo. É um playground: Ctrl+C para tudo e, sem -data, apaga os
+// arquivos.
+//
+//	devcluster [-nodes 3] [-port 8081] [-rf 3] [-seed 100] [-data dir] [-env KEY=VALUE]...
+//
+// As variáveis de ambiente do processo valem para todos os nós (ex:
+// HTTP_ROUTER=fast devcluster); NODE_ID, LISTEN_ADDR, CLUSTER_NODES e
+// REPLICATION_FACTOR vêm das flags (e RING_SYNC_ON_START=false), e -env
+// sobrescreve qualquer uma.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"mini-cassandra/pkg/node"
+)
+
+// envFlags junta os -env KEY=VALUE.
+type envFlags map[string]string
+
+func (f envFlags) String() string { return fmt.Sprint(map[string]string(f)) }
+
+func (f envFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want KEY=VALUE, got %q", s)
+	}
+	f[k] = v
+	return nil
+}
+
+func main() {
+	count := flag.Int("nodes", 3, "número de nós")
+	port := flag.Int("port", 8081, "porta do primeiro nó (os outros usam as seguintes)")
+	rf := flag.Int("rf", 3, "fator de replicação (no máximo o número de nós)")
+	seed := flag.Int("seed", 0, "chaves de exemplo gravadas depois do boot (0 não grava)")
+	dataDir := flag.String("data", "", "diretório dos dados dos nós (vazio: temporário, apagado na saída)")
+	extra := envFlags{}
+	flag.Var(extra, "env", "KEY=VALUE para todos os nós (repetível)")
+	flag.Parse()
+
+	if *count < 1 {
+		log.Fatalf("-nodes must be at least 1")
+	}
+	if *rf > *count {
+		*rf = *count
+	}
+	dir, temporary := *dataDir, *dataDir == ""
+	if temporary {
+		tmp, err := os.MkdirTemp("", "devcluster-")
+		if err != nil {
+			log.Fatalf("data dir: %v", err)
+		}
+		dir = tmp
+	}
+
+	hosts := make([]string, *count)
+	members := make([]string, *count)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("localhost:%d", *port+i)
+		members[i] = fmt.Sprintf("node%d=%s", i+1, hosts[i])
+	}
+
+	var nodes []*node.Node
+	stopAll := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, n := range nodes {
+			if err := n.Stop(ctx); err != nil {
+				log.Printf("[DEVCLUSTER] stop %s: %v", n.ID(), err)
+			}
+		}
+		if temporary {
+			os.RemoveAll(dir)
+		}
+	}
+	for i := range hosts {
+		id := fmt.Sprintf("node%d", i+1)
+		vars := map[string]string{
+			"NODE_ID":            id,
+			"LISTEN_ADDR":        fmt.Sprintf(":%d", *port+i),
+			"CLUSTER_NODES":      strings.Join(members, ","),
+			"REPLICATION_FACTOR": fmt.Sprint(*rf),
+			// o ring já é o mesmo em todos: não há o que buscar nos peers
+			// (que ainda nem subiram)
+			"RING_SYNC_ON_START": "false",
+		}
+		for k, v := range extra {
+			vars[k] = v
+		}
+		n, err := node.New(node.Config{
+			Env: func(key string) string {
+				if v, ok := vars[key]; ok {
+					return v
+				}
+				return os.Getenv(key)
+			},
+			DataDir: filepath.Join(dir, id),
+		})
+		if err == nil {
+			err = n.Start()
+		}
+		if err != nil {
+			stopAll()
+			log.Fatalf("%s: %v", id, err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := printTopology(hosts[0], dir); err != nil {
+		log.Printf("[DEVCLUSTER] topology: %v", err)
+	}
+	if *seed > 0 {
+		if err := seedData(hosts, *seed); err != nil {
+			log.Printf("[DEVCLUSTER] seed: %v", err)
+		}
+	}
+	fmt.Printf("\ncurl -X PUT http://%s/v1/kv/user:1 -d alice\ncurl http://%s/v1/kv/user:1\ncurl http://%s/cluster/status\n\nCtrl+C para parar\n",
+		hosts[0], hosts[len(hosts)-1], hosts[0])
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	fmt.Println("\nstopping...")
+	stopAll()
+}
+
+type ownershipReport struct {
+	ReplicationFactor int `json:"replication_factor"`
+	Nodes             []struct {
+		NodeID       string  `json:"node_id"`
+		Host         string  `json:"host"`
+		Tokens       int     `json:"tokens"`
+		PrimaryPct   float64 `json:"primary_pct"`
+		EffectivePct float64 `json:"effective_pct"`
+	} `json:"nodes"`
+}
+
+// printTopology imprime os nós e a posse do ring, como o mcli ownership.
+func printTopology(host, dir string) error {
+	resp, err := http.Get("http://" + host + "/admin/ownership")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/admin/ownership: status=%d", resp.StatusCode)
+	}
+	var rep ownershipReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tHOST\tTOKENS\tPRIMARY\tEFFECTIVE\t")
+	for _, n := range rep.Nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f%%\t%.2f%%\t\n", n.NodeID, n.Host, n.Tokens, n.PrimaryPct, n.EffectivePct)
+	}
+	tw.Flush()
+	fmt.Printf("\nreplication_factor=%d data=%s\n", rep.ReplicationFactor, dir)
+	return nil
+}
+
+var sampleNames = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi"}
+
+// seedData grava count usuários JSON (user:1, user:2...), alternando o
+// coordenador entre os nós.
+func seedData(hosts []string, count int) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for i := 1; i <= count; i++ {
+		name := sampleNames[rand.Intn(len(sampleNames))]
+		body := fmt.Sprintf(`{"id":%d,"name":%q,"age":%d}`, i, name, 18+rand.Intn(60))
+		url := fmt.Sprintf("http://%s/v1/kv/user:%d", hosts[i%len(hosts)], i)
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("PUT user:%d: status=%d", i, resp.StatusCode)
+		}
+	}
+	fmt.Printf("seeded %d keys (user:1..user:%d)\n", count, count)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+)
+
+type balancePlan struct {
+	ReplicationFactor  int     `json:"replication_factor"`
+	Threshold          float64 `json:"threshold"`
+	IdealPct           float64 `json:"ideal_pct"`
+	MaxDeviationBefore float64 `json:"max_deviation_before_pct"`
+	MaxDeviationAfter  float64 `json:"max_deviation_after_pct"`
+	Balanced           bool    `json:"balanced"`
+	Moves              []struct {
+		Token uint32 `json:"token"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	} `json:"moves"`
+	Before []nodeBalance `json:"before"`
+	After  []nodeBalance `json:"after"`
+}
+
+type nodeBalance struct {
+	Node         string  `json:"node"`
+	Tokens       int     `json:"tokens"`
+	EffectivePct float64 `json:"effective_pct"`
+	DeviationPct float64 `json:"deviation_pct"`
+}
+
+// runBalance mostra o plano de balanceamento do ring (dry-run) e, com
+// -execute, pede ao nó que o execute como job.
+func runBalance(host string, args []string) error {
+	fs := flag.NewFlagSet("balance", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 0.1, "desvio relativo da posse ideal tolerado")
+	maxMoves := fs.Int("max-moves", 32, "máximo de move-token do plano")
+	execute := fs.Bool("execute", false, "executa o plano (sem isso é só um dry-run)")
+	fs.Parse(args)
+
+	q := url.Values{}
+	q.Set("threshold", fmt.Sprint(*threshold))
+	q.Set("max_moves", fmt.Sprint(*maxMoves))
+
+	var plan balancePlan
+	var jobID string
+	if *execute {
+		u := url.URL{Scheme: "http", Host: host, Path: "/admin/balance", RawQuery: q.Encode()}
+		resp, err := client.Post(u.String(), "", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("/admin/balance: status=%d %s", resp.StatusCode, body)
+		}
+		var out struct {
+			Job *struct {
+				ID string `json:"id"`
+			} `json:"job"`
+			Plan balancePlan `json:"plan"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return err
+		}
+		plan = out.Plan
+		if out.Job != nil {
+			jobID = out.Job.ID
+		}
+	} else if err := getJSON(host, "/admin/balance", q, &plan); err != nil {
+		return err
+	}
+
+	after := make(map[string]nodeBalance)
+	for _, n := range plan.After {
+		after[n.Node] = n
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tTOKENS\tEFFECTIVE\tDEVIATION\t->\tTOKENS\tEFFECTIVE\tDEVIATION\t")
+	for _, n := range plan.Before {
+		a := after[n.Node]
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%+.2f%%\t\t%d\t%.2f%%\t%+.2f%%\t\n",
+			n.Node, n.Tokens, n.EffectivePct, n.DeviationPct, a.Tokens, a.EffectivePct, a.DeviationPct)
+	}
+	tw.Flush()
+
+	fmt.Printf("\n%d moves, max deviation %.2f%% -> %.2f%% (ideal %.2f%%, threshold %.0f%%)\n",
+		len(plan.Moves), plan.MaxDeviationBefore, plan.MaxDeviationAfter, plan.IdealPct, plan.Threshold*100)
+	for _, mv := range plan.Moves {
+		fmt.Printf("  move-token %d: %s -> %s\n", mv.Token, mv.From, mv.To)
+	}
+	switch {
+	case jobID != "":
+		fmt.Printf("\nrunning as job %s (curl http://%s/admin/jobs/%s)\n", jobID, host, jobID)
+	case len(plan.Moves) > 0:
+		fmt.Println("\ndry-run: use -execute para aplicar")
+	}
+	if !plan.Balanced {
+		return fmt.Errorf("ring still imbalanced beyond %.0f%% after the plan", plan.Threshold*100)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bench: clientes concorrentes fazem PUTs (e, com -read-ratio, GETs) de
+// valores de -size bytes pela API de cliente. No fim imprime a vazão, os
+// percentis de latência e, pela diferença dos contadores do runtime em
+// /admin/memory antes e depois, quantas alocações e bytes cada nó fez por
+// operação do teste (coordenação e réplica). Passe todos os nós em -hosts
+// para o total valer pelo cluster.
+
+type benchMemory struct {
+	Mallocs         uint64 `json:"mallocs"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	NumGC           uint32 `json:"num_gc"`
+}
+
+func runBench(host string, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	hosts := fs.String("hosts", host, "nós que recebem as operações, separados por vírgula")
+	clients := fs.Int("clients", 16, "clientes concorrentes")
+	size := fs.Int("size", 1024, "tamanho dos valores, em bytes")
+	keys := fs.Int("keys", 10000, "chaves distintas")
+	duration := fs.Duration("duration", 10*time.Second, "duração do teste")
+	readRatio := fs.Float64("read-ratio", 0, "fração das operações que são GETs")
+	writeCL := fs.String("write-cl", "", "consistência dos PUTs (vazio = a do nó)")
+	opTimeout := fs.Duration("timeout", 2*time.Second, "prazo de cada operação")
+	fs.Parse(args)
+
+	targets := strings.Split(*hosts, ",")
+	for i := range targets {
+		targets[i] = strings.TrimSpace(targets[i])
+	}
+	before := make([]benchMemory, len(targets))
+	for i, t := range targets {
+		if err := getJSON(t, "/admin/memory", nil, &before[i]); err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+	}
+
+	run := fmt.Sprintf("bench:%d-", time.Now().UnixNano())
+	value := bytes.Repeat([]byte("x"), *size)
+	hc := &http.Client{Timeout: *opTimeout, Transport: &http.Transport{MaxIdleConnsPerHost: *clients}}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		errs      int
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for c := 0; c < *clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(c)))
+			target := targets[c%len(targets)]
+			var mine []time.Duration
+			failed := 0
+			for time.Since(start) < *duration {
+				url := fmt.Sprintf("http://%s/v1/kv/%sk%d", target, run, rng.Intn(*keys))
+				var req *http.Request
+				if rng.Float64() < *readRatio {
+					req, _ = http.NewRequest("GET", url, nil)
+				} else {
+					req, _ = http.NewRequest("PUT", url, bytes.NewReader(value))
+					if *writeCL != "" {
+						req.Header.Set("X-Consistency", *writeCL)
+					}
+				}
+				t0 := time.Now()
+				resp, err := hc.Do(req)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+						err = fmt.Errorf("status=%d", resp.StatusCode)
+					}
+				}
+				if err != nil {
+					failed++
+					continue
+				}
+				mine = append(mine, time.Since(t0))
+			}
+			mu.Lock()
+			latencies = append(latencies, mine...)
+			errs += failed
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	ops := len(latencies)
+	fmt.Printf("%d ops in %s (%.0f ops/s), %d errors, %d-byte values\n", ops, elapsed.Round(time.Millisecond), float64(ops)/elapsed.Seconds(), errs, *size)
+	fmt.Printf("latency p50=%s p99=%s max=%s\n\n", pct(0.5), pct(0.99), pct(1))
+
+	if ops == 0 {
+		return fmt.Errorf("no successful operations")
+	}
+	fmt.Printf("%-22s %12s %14s %6s\n", "node", "allocs/op", "bytes/op", "gcs")
+	var total benchMemory
+	for i, t := range targets {
+		var after benchMemory
+		if err := getJSON(t, "/admin/memory", nil, &after); err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		d := benchMemory{after.Mallocs - before[i].Mallocs, after.TotalAllocBytes - before[i].TotalAllocBytes, after.NumGC - before[i].NumGC}
+		total.Mallocs += d.Mallocs
+		total.TotalAllocBytes += d.TotalAllocBytes
+		total.NumGC += d.NumGC
+		printBenchMemory(t, d, ops)
+	}
+	if len(targets) > 1 {
+		printBenchMemory("total", total, ops)
+	}
+	return nil
+}
+
+func printBenchMemory(name string, d benchMemory, ops int) {
+	fmt.Printf("%-22s %12.0f %14.0f %6d\n", name, float64(d.Mallocs)/float64(ops), float64(d.TotalAllocBytes)/float64(ops), d.NumGC)
+}
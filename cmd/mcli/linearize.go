@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linearize: vários clientes concorrentes fazem PUTs e GETs em poucas chaves
+// de um keyspace próprio, o histórico (início, fim e resultado de cada
+// operação) é guardado e depois checado contra o modelo de um registrador
+// linearizável, chave a chave, no estilo do Porcupine. Um PUT que falha ou
+// estoura o prazo pode ter sido aplicado ou não: ele entra no histórico sem
+// fim; GETs que falham são descartados.
+
+type linOp struct {
+	Client int    `json:"client"`
+	Key    string `json:"key"`
+	Write  bool   `json:"write"`
+	Value  string `json:"value,omitempty"`
+	Found  bool   `json:"found,omitempty"`
+	// Call e Return em ns desde o início do teste; Return -1: sem resposta
+	Call   int64  `json:"call"`
+	Return int64  `json:"return"`
+	Error  string `json:"error,omitempty"`
+}
+
+type linKeyResult struct {
+	Key          string `json:"key"`
+	Ops          int    `json:"ops"`
+	Linearizable bool   `json:"linearizable"`
+	// Unknown: o checker estourou -check-timeout
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+func runLinearize(host string, args []string) error {
+	fs := flag.NewFlagSet("linearize", flag.ExitOnError)
+	hosts := fs.String("hosts", host, "nós que recebem as operações, separados por vírgula")
+	clients := fs.Int("clients", 8, "clientes concorrentes")
+	keys := fs.Int("keys", 4, "chaves disputadas")
+	duration := fs.Duration("duration", 5*time.Second, "duração do teste")
+	readRatio := fs.Float64("read-ratio", 0.5, "fração das operações que são GETs")
+	writeCL := fs.String("write-cl", "QUORUM", "consistência dos PUTs")
+	readCL := fs.String("read-cl", "QUORUM", "consistência dos GETs")
+	opTimeout := fs.Duration("timeout", 2*time.Second, "prazo de cada operação")
+	checkTimeout := fs.Duration("check-timeout", time.Minute, "prazo do checker por chave")
+	out := fs.String("out", "", "grava o histórico em JSON nesse arquivo")
+	fs.Parse(args)
+
+	targets := strings.Split(*hosts, ",")
+	run := fmt.Sprintf("linearize:%d-", time.Now().UnixNano())
+	hc := &http.Client{Timeout: *opTimeout}
+
+	var (
+		mu      sync.Mutex
+		history []linOp
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for c := 0; c < *clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(c)))
+			target := strings.TrimSpace(targets[c%len(targets)])
+			for n := 0; time.Since(start) < *duration; n++ {
+				op := linOp{Client: c, Key: fmt.Sprintf("%sk%d", run, rng.Intn(*keys))}
+				op.Write = rng.Float64() >= *readRatio
+				if op.Write {
+					op.Value = fmt.Sprintf("c%d-%d", c, n)
+				}
+				op.Call = int64(time.Since(start))
+				err := linDo(hc, target, &op, *writeCL, *readCL)
+				op.Return = int64(time.Since(start))
+				if err != nil {
+					if !op.Write {
+						continue
+					}
+					op.Error, op.Return = err.Error(), -1
+				}
+				mu.Lock()
+				history = append(history, op)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if *out != "" {
+		b, _ := json.MarshalIndent(history, "", "  ")
+		if err := os.WriteFile(*out, b, 0644); err != nil {
+			return err
+		}
+	}
+
+	byKey := make(map[string][]linOp)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	names := make([]string, 0, len(byKey))
+	for k := range byKey {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	failed, unknown := 0, 0
+	for _, k := range names {
+		ok, done := checkRegister(byKey[k], *checkTimeout)
+		res := linKeyResult{Key: strings.TrimPrefix(k, run), Ops: len(byKey[k]), Linearizable: ok, Unknown: !done}
+		status := "ok"
+		switch {
+		case res.Unknown:
+			status, unknown = "unknown (check timed out)", unknown+1
+		case !ok:
+			status, failed = "NOT linearizable", failed+1
+		}
+		fmt.Printf("%-6s %6d ops  %s\n", res.Key, res.Ops, status)
+	}
+	errs := 0
+	for _, op := range history {
+		if op.Return < 0 {
+			errs++
+		}
+	}
+	fmt.Printf("\n%d ops, %d writes with unknown outcome, write_cl=%s read_cl=%s\n", len(history), errs, *writeCL, *readCL)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d keys are not linearizable", failed, len(names))
+	}
+	if unknown > 0 {
+		return fmt.Errorf("%d keys could not be checked in %s", unknown, *checkTimeout)
+	}
+	fmt.Println("PASS")
+	return nil
+}
+
+// linDo executa op no nó target e preenche o resultado dos GETs.
+func linDo(hc *http.Client, target string, op *linOp, writeCL, readCL string) error {
+	u := url.URL{Scheme: "http", Host: target, Path: "/v1/kv/" + op.Key}
+	var req *http.Request
+	var err error
+	if op.Write {
+		u.RawQuery = url.Values{"consistency": {writeCL}}.Encode()
+		req, err = http.NewRequest("PUT", u.String(), strings.NewReader(op.Value))
+	} else {
+		u.RawQuery = url.Values{"consistency": {readCL}}.Encode()
+		req, err = http.NewRequest("GET", u.String(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !op.Write && resp.StatusCode == http.StatusNotFound:
+		op.Found = false
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("status=%d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	case !op.Write:
+		op.Found, op.Value = true, string(body)
+	}
+	return nil
+}
+
+// Checker: busca em profundidade (Wing & Gong, com o cache de estados do
+// Porcupine) por uma ordem das operações que respeite o tempo real e em que
+// cada GET veja o último PUT.
+
+type linEntry struct {
+	op         *linOp
+	id         int
+	ret        bool
+	match      *linEntry // chamada -> retorno
+	prev, next *linEntry
+}
+
+type linState struct {
+	value string
+	found bool
+}
+
+type linBits []uint64
+
+func (b linBits) set(i int)   { b[i/64] |= 1 << (uint(i) % 64) }
+func (b linBits) clear(i int) { b[i/64] &^= 1 << (uint(i) % 64) }
+func (b linBits) key() string {
+	var sb strings.Builder
+	for _, w := range b {
+		fmt.Fprintf(&sb, "%x.", w)
+	}
+	return sb.String()
+}
+
+// checkRegister diz se ops (de uma chave) é linearizável; done=false se o
+// prazo acabou antes de uma resposta.
+func checkRegister(ops []linOp, timeout time.Duration) (ok, done bool) {
+	type event struct {
+		t   int64
+		ret bool
+		id  int
+	}
+	events := make([]event, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := op.Return
+		if ret < 0 {
+			// sem resposta: pode ter sido aplicado até o fim do teste
+			ret = 1<<63 - 1
+		}
+		events = append(events, event{op.Call, false, i}, event{ret, true, i})
+	}
+	// no mesmo instante, chamadas antes de retornos
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].t != events[j].t {
+			return events[i].t < events[j].t
+		}
+		return !events[i].ret && events[j].ret
+	})
+
+	head := &linEntry{id: -1}
+	calls := make([]*linEntry, len(ops))
+	prev := head
+	for _, ev := range events {
+		e := &linEntry{op: &ops[ev.id], id: ev.id, ret: ev.ret, prev: prev}
+		prev.next = e
+		prev = e
+		if ev.ret {
+			calls[ev.id].match = e
+		} else {
+			calls[ev.id] = e
+		}
+	}
+
+	lift := func(e *linEntry) {
+		e.prev.next = e.next
+		if e.next != nil {
+			e.next.prev = e.prev
+		}
+		m := e.match
+		m.prev.next = m.next
+		if m.next != nil {
+			m.next.prev = m.prev
+		}
+	}
+	unlift := func(e *linEntry) {
+		m := e.match
+		m.prev.next = m
+		if m.next != nil {
+			m.next.prev = m
+		}
+		e.prev.next = e
+		if e.next != nil {
+			e.next.prev = e
+		}
+	}
+
+	type frame struct {
+		entry *linEntry
+		state linState
+	}
+	var stack []frame
+	linearized := make(linBits, (len(ops)+63)/64)
+	seen := make(map[string]bool)
+	state := linState{}
+	deadline := time.Now().Add(timeout)
+	entry := head.next
+	for steps := 0; head.next != nil; steps++ {
+		if steps%4096 == 0 && time.Now().After(deadline) {
+			return false, false
+		}
+		if !entry.ret {
+			next, legal := state, true
+			if entry.op.Write {
+				next = linState{value: entry.op.Value, found: true}
+			} else {
+				legal = entry.op.Found == state.found && (!state.found || entry.op.Value == state.value)
+			}
+			if legal {
+				linearized.set(entry.id)
+				k := linearized.key() + "|" + fmt.Sprint(next.found) + next.value
+				if !seen[k] {
+					seen[k] = true
+					stack = append(stack, frame{entry, state})
+					state = next
+					lift(entry)
+					entry = head.next
+					continue
+				}
+				linearized.clear(entry.id)
+			}
+			entry = entry.next
+			continue
+		}
+		// retorno de uma operação que não coube em nenhuma posição: volta
+		if len(stack) == 0 {
+			return false, true
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		entry, state = top.entry, top.state
+		linearized.clear(entry.id)
+		unlift(entry)
+		entry = entry.next
+	}
+	return true, true
+}
@@ -0,0 +1,145 @@
+// mcli é a ferramenta de linha de comando para administrar o cluster.
+// Ela conversa com os endpoints /admin de um nó (qualquer um serve); o
+// linearize e o bench também fazem PUTs e GETs pela API de cliente.
+//
+//	mcli [-host localhost:8081] <comando> [flags]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second, Transport: adminAuth{http.DefaultTransport}}
+
+// adminAuth põe o MCLI_ADMIN_TOKEN (o ADMIN_TOKEN dos nós) nas chamadas a
+// /admin/*.
+type adminAuth struct{ base http.RoundTripper }
+
+func (t adminAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := os.Getenv("MCLI_ADMIN_TOKEN")
+	if token == "" || !strings.HasPrefix(req.URL.Path, "/admin/") {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(host string, args []string) error
+}
+
+var commands = []command{
+	{"ownership", "posse do espaço de tokens por nó (-threshold 0.2)", runOwnership},
+	{"balance", "plano de move-token para balancear o ring (-threshold 0.1 -execute)", runBalance},
+	{"linearize", "checa se PUTs e GETs concorrentes são linearizáveis (-clients 8 -duration 5s)", runLinearize},
+	{"bench", "carga de PUTs com latência e alocações por PUT nos nós (-clients 16 -size 1024)", runBench},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "uso: mcli [-host host:port] <comando> [flags]\n\ncomandos:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	defHost := os.Getenv("MCLI_HOST")
+	if defHost == "" {
+		defHost = "localhost:8081"
+	}
+	host := flag.String("host", defHost, "nó do cluster (host:port)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+	}
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(*host, args); err != nil {
+				fmt.Fprintf(os.Stderr, "mcli %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+}
+
+// getJSON faz um GET em host+path e decodifica a resposta em v.
+func getJSON(host, path string, query url.Values, v interface{}) error {
+	u := url.URL{Scheme: "http", Host: host, Path: path, RawQuery: query.Encode()}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: status=%d %s", path, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type ownershipReport struct {
+	ReplicationFactor int     `json:"replication_factor"`
+	Threshold         float64 `json:"threshold"`
+	Balanced          bool    `json:"balanced"`
+	Nodes             []struct {
+		NodeID       string  `json:"node_id"`
+		Host         string  `json:"host"`
+		Tokens       int     `json:"tokens"`
+		PrimaryPct   float64 `json:"primary_pct"`
+		EffectivePct float64 `json:"effective_pct"`
+		IdealPct     float64 `json:"ideal_pct"`
+		DeviationPct float64 `json:"deviation_pct"`
+		Imbalanced   bool    `json:"imbalanced"`
+	} `json:"nodes"`
+}
+
+// runOwnership imprime a posse efetiva de cada nó e sai com erro se algum
+// estiver além do limite de desbalanceamento.
+func runOwnership(host string, args []string) error {
+	fs := flag.NewFlagSet("ownership", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 0.2, "desvio relativo da posse ideal tolerado")
+	fs.Parse(args)
+
+	q := url.Values{}
+	q.Set("threshold", fmt.Sprint(*threshold))
+	var rep ownershipReport
+	if err := getJSON(host, "/admin/ownership", q, &rep); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tHOST\tTOKENS\tPRIMARY\tEFFECTIVE\tIDEAL\tDEVIATION\t")
+	for _, n := range rep.Nodes {
+		flagMark := ""
+		if n.Imbalanced {
+			flagMark = "  <- imbalanced"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f%%\t%.2f%%\t%.2f%%\t%+.2f%%\t%s\n",
+			n.NodeID, n.Host, n.Tokens, n.PrimaryPct, n.EffectivePct, n.IdealPct, n.DeviationPct, flagMark)
+	}
+	tw.Flush()
+	fmt.Printf("\nreplication_factor=%d threshold=%.0f%%\n", rep.ReplicationFactor, rep.Threshold*100)
+
+	if !rep.Balanced {
+		return fmt.Errorf("ring imbalanced beyond %.0f%%", rep.Threshold*100)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package hashring
+
+import "fmt"
+
+// Invariantes do ring, para testes de propriedade e fuzzing (aqui ou em quem
+// usa o pacote): cada função retorna nil ou o primeiro caso que viola a
+// propriedade.
+
+// CheckReplicas confere, em todos os intervalos do anel, que as réplicas são
+// min(rFactor, nós) nós distintos e que a primeira é a dona do token que
+// fecha o intervalo.
+func CheckReplicas(r *Ring, rFactor int) error {
+	want := rFactor
+	if n := len(r.Nodes()); n < want {
+		want = n
+	}
+	for _, rg := range r.Ranges() {
+		replicas := r.ReplicasForToken(rg.End, rFactor)
+		if len(replicas) != want {
+			return fmt.Errorf("token %d: %d replicas, want %d", rg.End, len(replicas), want)
+		}
+		if replicas[0].ID != rg.Owner.ID {
+			return fmt.Errorf("token %d: primary %s, but the token belongs to %s", rg.End, replicas[0].ID, rg.Owner.ID)
+		}
+		seen := make(map[NodeID]bool, len(replicas))
+		for _, n := range replicas {
+			if seen[n.ID] {
+				return fmt.Errorf("token %d: node %s appears twice in the replicas", rg.End, n.ID)
+			}
+			seen[n.ID] = true
+		}
+	}
+	return nil
+}
+
+// CheckStablePlacement confere que passar de before para after, adicionando
+// ou removendo só o nó changed, não move chaves entre os outros nós: as
+// réplicas de cada chave em after são as de before mais changed (num add)
+// ou menos ele e um substituto (num remove).
+func CheckStablePlacement(before, after *Ring, changed NodeID, rFactor int, keys []string) error {
+	for _, key := range keys {
+		old := make(map[NodeID]bool)
+		for _, n := range before.GetReplicasForKey(key, rFactor) {
+			old[n.ID] = true
+		}
+		cur := make(map[NodeID]bool)
+		for _, n := range after.GetReplicasForKey(key, rFactor) {
+			cur[n.ID] = true
+		}
+		// o lado que tem o nó changed pode ter no máximo ele de diferente
+		from, to := old, cur
+		if old[changed] {
+			from, to = cur, old
+		}
+		extra := 0
+		for id := range from {
+			if !to[id] {
+				extra++
+			}
+		}
+		if extra > 1 || (extra == 1 && !to[changed]) {
+			return fmt.Errorf("key %q: replicas moved beyond node %s (before %v, after %v)", key, changed, idSet(old), idSet(cur))
+		}
+	}
+	return nil
+}
+
+// CheckBalance confere que a posse efetiva de cada nó fica a no máximo
+// tolerance (fração relativa) da ideal, min(rFactor, nós)/nós.
+func CheckBalance(r *Ring, rFactor int, tolerance float64) error {
+	owners := r.Ownership(rFactor)
+	if len(owners) == 0 {
+		return nil
+	}
+	rf := rFactor
+	if rf > len(owners) {
+		rf = len(owners)
+	}
+	ideal := float64(rf) / float64(len(owners))
+	for _, o := range owners {
+		if dev := (o.Effective - ideal) / ideal; dev > tolerance || dev < -tolerance {
+			return fmt.Errorf("node %s owns %.2f%% of the token space, ideal %.2f%% (deviation %+.1f%%)", o.Node.ID, o.Effective*100, ideal*100, dev*100)
+		}
+	}
+	return nil
+}
+
+func idSet(s map[NodeID]bool) []NodeID {
+	out := make([]NodeID, 0, len(s))
+	for id := range s {
+		out = append(out, id)
+	}
+	return out
+}
@@ -0,0 +1,22 @@
+// Package hashring é o consistent hashing do mini-cassandra, com vnodes,
+// num pacote público para outros projetos usarem direto:
+//
+//	r := hashring.New(nodes,
+//		hashring.WithVNodes(128),
+//		hashring.WithWeights(map[hashring.NodeID]int{"big": 2}),
+//		hashring.WithStrategy(hashring.GroupStrategy{Group: rackOf}),
+//	)
+//	replicas := r.GetReplicasForKey("user:42", 3)
+//
+// Cada nó ocupa vnodes vezes o seu peso em posições do anel (hash de
+// "<id>#<i>"; SetNodeVNodes dá a um nó um número próprio), e uma chave pertence ao primeiro vnode com token >= hash da
+// chave. As réplicas são escolhidas pela ReplicaStrategy a partir desse
+// vnode. O hash (HashFunc, padrão FNV32a) vale para as chaves e os vnodes:
+// todos os processos que compartilham um ring precisam usar o mesmo.
+//
+// A API exportada (New e as Options, Ring, NodeInfo, TokenRange, Ownership,
+// ReplicaStrategy e as funções Check*) é estável: mudanças nela só entram de
+// forma compatível. Os tokens gerados por FNV32a e pelo nome dos vnodes
+// também não mudam, porque rings já em disco (e clusters em rolling upgrade)
+// dependem deles.
+package hashring
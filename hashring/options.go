@@ -0,0 +1,152 @@
+package hashring
+
+// HashFunc leva uma chave (ou o nome de um vnode, "<id>#<i>") a um token do
+// anel. Tem que ser determinística e igual em todos os processos que
+// compartilham o ring.
+type HashFunc func(key string) uint32
+
+// Option configura um Ring em New.
+type Option func(*Ring)
+
+// DefaultVNodes é o número de vnodes por nó de New sem WithVNodes.
+const DefaultVNodes = 128
+
+// WithVNodes define quantos vnodes cada nó de peso 1 ocupa.
+func WithVNodes(n int) Option {
+	return func(r *Ring) { r.vNodes = n }
+}
+
+// WithHash troca o hash das chaves e dos vnodes (padrão FNV32a).
+func WithHash(h HashFunc) Option {
+	return func(r *Ring) { r.hash = h }
+}
+
+// WithWeights dá a cada nó um número de vnodes proporcional ao peso (nós
+// fora do mapa, ou com peso <= 0, pesam 1): um nó com peso 2 recebe em média
+// o dobro das chaves.
+func WithWeights(w map[NodeID]int) Option {
+	return func(r *Ring) {
+		for id, n := range w {
+			r.weights[id] = n
+		}
+	}
+}
+
+// WithStrategy troca a estratégia de escolha das réplicas (padrão
+// SimpleStrategy).
+func WithStrategy(s ReplicaStrategy) Option {
+	return func(r *Ring) { r.strategy = s }
+}
+
+// New cria um ring com os nós dados.
+func New(nodes []NodeInfo, opts ...Option) *Ring {
+	r := &Ring{
+		vNodes:   DefaultVNodes,
+		hashMap:  make(map[uint32]NodeInfo),
+		hash:     FNV32a,
+		weights:  make(map[NodeID]int),
+		counts:   make(map[NodeID]int),
+		strategy: SimpleStrategy{},
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	for _, n := range nodes {
+		r.addNodeNoLock(n)
+	}
+	r.sortHashes()
+	return r
+}
+
+// Weight retorna o peso de um nó (1 se não tiver).
+func (r *Ring) Weight(id NodeID) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.weight(id)
+}
+
+func (r *Ring) weight(id NodeID) int {
+	if w := r.weights[id]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// SetWeight muda o peso de um nó. Se ele já estiver no ring, os vnodes dele
+// são recalculados (as chaves dos intervalos ganhos ou perdidos mudam de nó).
+func (r *Ring) SetWeight(id NodeID, w int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.weights[id] = w
+	var node NodeInfo
+	found := false
+	kept := r.hashes[:0]
+	for _, h := range r.hashes {
+		if n := r.hashMap[h]; n.ID == id {
+			node, found = n, true
+			delete(r.hashMap, h)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	r.hashes = kept
+	if found {
+		r.addNodeNoLock(node)
+	}
+	r.sortHashes()
+}
+
+// ReplicaStrategy escolhe as réplicas de um token. walk percorre os nós
+// físicos em ordem no anel a partir do token (cada um uma vez; o primeiro é o
+// dono do token) até o callback retornar false. O resultado deve ter até
+// rFactor nós distintos, começando pelo primeiro do walk.
+type ReplicaStrategy interface {
+	Replicas(walk func(fn func(NodeInfo) bool), rFactor int) []NodeInfo
+}
+
+// SimpleStrategy usa os rFactor primeiros nós distintos do anel (como a
+// SimpleStrategy do Cassandra).
+type SimpleStrategy struct{}
+
+// Replicas implementa ReplicaStrategy.
+func (SimpleStrategy) Replicas(walk func(fn func(NodeInfo) bool), rFactor int) []NodeInfo {
+	replicas := make([]NodeInfo, 0, rFactor)
+	walk(func(n NodeInfo) bool {
+		replicas = append(replicas, n)
+		return len(replicas) < rFactor
+	})
+	return replicas
+}
+
+// GroupStrategy espalha as réplicas entre grupos (racks, zonas): anda no
+// anel pegando um nó de cada grupo ainda não usado e, se faltarem grupos,
+// completa com os nós pulados, na ordem do anel (como o
+// NetworkTopologyStrategy do Cassandra faz com os racks).
+type GroupStrategy struct {
+	// Group retorna o grupo de um nó
+	Group func(NodeInfo) string
+}
+
+// Replicas implementa ReplicaStrategy.
+func (s GroupStrategy) Replicas(walk func(fn func(NodeInfo) bool), rFactor int) []NodeInfo {
+	replicas := make([]NodeInfo, 0, rFactor)
+	var skipped []NodeInfo
+	groups := make(map[string]bool)
+	walk(func(n NodeInfo) bool {
+		g := s.Group(n)
+		if groups[g] {
+			skipped = append(skipped, n)
+			return true
+		}
+		groups[g] = true
+		replicas = append(replicas, n)
+		return len(replicas) < rFactor
+	})
+	for _, n := range skipped {
+		if len(replicas) >= rFactor {
+			break
+		}
+		replicas = append(replicas, n)
+	}
+	return replicas
+}
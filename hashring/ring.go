@@ -0,0 +1,489 @@
+package hashring
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// NodeID identifica um nó físico.
+type NodeID string
+
+// NodeInfo é um nó físico do ring.
+type NodeInfo struct {
+	ID   NodeID
+	Host string // host:port
+}
+
+// Ring é o anel de consistent hashing. É seguro para uso concorrente.
+type Ring struct {
+	mu      sync.RWMutex
+	vNodes  int
+	hashes  []uint32            // posições ordenadas no anel
+	hashMap map[uint32]NodeInfo // hash -> nó "físico"
+
+	hash     HashFunc
+	weights  map[NodeID]int
+	counts   map[NodeID]int // vnodes de nós com número próprio (SetNodeVNodes)
+	strategy ReplicaStrategy
+}
+
+// NewRing cria um ring com N virtual nodes por nó, com o hash e a estratégia
+// padrão (FNV-1a e SimpleStrategy). É o New(nodes, WithVNodes(vNodes)).
+func NewRing(nodes []NodeInfo, vNodes int) *Ring {
+	return New(nodes, WithVNodes(vNodes))
+}
+
+// VNodes retorna o número de vnodes por nó.
+func (r *Ring) VNodes() int {
+	return r.vNodes
+}
+
+func (r *Ring) sortHashes() {
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+}
+
+// FNV32a é o hash padrão do ring (FNV-1a de 32 bits).
+func FNV32a(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Hash retorna o token de uma chave com o hash do ring.
+func (r *Ring) Hash(key string) uint32 {
+	return r.hash(key)
+}
+
+// Clone retorna uma cópia independente do ring (para simular mudanças).
+func (r *Ring) Clone() *Ring {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := &Ring{
+		vNodes:   r.vNodes,
+		hashes:   append([]uint32(nil), r.hashes...),
+		hashMap:  make(map[uint32]NodeInfo, len(r.hashMap)),
+		hash:     r.hash,
+		weights:  make(map[NodeID]int, len(r.weights)),
+		counts:   make(map[NodeID]int, len(r.counts)),
+		strategy: r.strategy,
+	}
+	for h, n := range r.hashMap {
+		c.hashMap[h] = n
+	}
+	for id, w := range r.weights {
+		c.weights[id] = w
+	}
+	for id, n := range r.counts {
+		c.counts[id] = n
+	}
+	return c
+}
+
+// Tokens retorna os tokens do anel, ordenados.
+func (r *Ring) Tokens() []uint32 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]uint32(nil), r.hashes...)
+}
+
+// AddNode adiciona um nó ao ring.
+func (r *Ring) AddNode(n NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addNodeNoLock(n)
+	r.sortHashes()
+}
+
+func (r *Ring) addNodeNoLock(n NodeInfo) {
+	for _, h := range r.NodeTokens(n.ID) {
+		// um nó adicionado de novo (ou um token que colide) não duplica a
+		// posição: um RemoveNode depois deixaria a cópia sem dono no anel
+		if _, ok := r.hashMap[h]; !ok {
+			r.hashes = append(r.hashes, h)
+		}
+		r.hashMap[h] = n
+	}
+}
+
+// NodeTokens retorna as posições dos vnodes de um nó (as que AddNode ocupa),
+// esteja ele no ring ou não: vNodes vezes o peso do nó, ou o número dado em
+// SetNodeVNodes.
+func (r *Ring) NodeTokens(id NodeID) []uint32 {
+	out := make([]uint32, r.nodeVNodes(id))
+	for i := range out {
+		out[i] = r.vnodeToken(id, i)
+	}
+	return out
+}
+
+func (r *Ring) vnodeToken(id NodeID, i int) uint32 {
+	return r.hash(fmt.Sprintf("%s#%d", string(id), i))
+}
+
+// NodeVNodes retorna quantos vnodes um nó ocupa quando está no ring.
+func (r *Ring) NodeVNodes(id NodeID) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nodeVNodes(id)
+}
+
+func (r *Ring) nodeVNodes(id NodeID) int {
+	if n := r.counts[id]; n > 0 {
+		return n
+	}
+	return r.vNodes * r.weight(id)
+}
+
+// SetNodeVNodes muda o número de vnodes de um nó (n <= 0 volta a vNodes
+// vezes o peso). Os vnodes são os primeiros n de "<id>#<i>", então se o nó
+// estiver no ring ele só ganha os tokens novos (os livres) ou perde os do
+// fim que ainda são dele: os outros intervalos não mudam de dono. Retorna
+// quantos tokens entraram e saíram do anel.
+func (r *Ring) SetNodeVNodes(id NodeID, n int) (added, removed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.nodeVNodes(id)
+	if n > 0 {
+		r.counts[id] = n
+	} else {
+		delete(r.counts, id)
+	}
+	n = r.nodeVNodes(id)
+
+	var node NodeInfo
+	found := false
+	for _, h := range r.hashes {
+		if nd := r.hashMap[h]; nd.ID == id {
+			node, found = nd, true
+			break
+		}
+	}
+	if !found {
+		return 0, 0
+	}
+	for i := old; i < n; i++ {
+		h := r.vnodeToken(id, i)
+		if _, ok := r.hashMap[h]; ok {
+			continue
+		}
+		r.hashes = append(r.hashes, h)
+		r.hashMap[h] = node
+		added++
+	}
+	if n < old {
+		drop := make(map[uint32]bool, old-n)
+		for i := n; i < old; i++ {
+			if h := r.vnodeToken(id, i); r.hashMap[h].ID == id {
+				drop[h] = true
+			}
+		}
+		kept := r.hashes[:0]
+		for _, h := range r.hashes {
+			if drop[h] {
+				delete(r.hashMap, h)
+				removed++
+				continue
+			}
+			kept = append(kept, h)
+		}
+		r.hashes = kept
+	}
+	r.sortHashes()
+	return added, removed
+}
+
+// SetTokens troca todo o conteúdo do anel pelos tokens dados (ex: o ring
+// aprendido de um seed).
+func (r *Ring) SetTokens(tokens map[uint32]NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashes = make([]uint32, 0, len(tokens))
+	r.hashMap = make(map[uint32]NodeInfo, len(tokens))
+	for h, n := range tokens {
+		r.hashes = append(r.hashes, h)
+		r.hashMap[h] = n
+	}
+	r.sortHashes()
+}
+
+// MoveToken passa um token (vnode) existente para outro nó e retorna o dono
+// anterior. As posições no anel não mudam, só quem responde pelo intervalo.
+func (r *Ring) MoveToken(token uint32, to NodeInfo) (NodeInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from, ok := r.hashMap[token]
+	if !ok {
+		return NodeInfo{}, fmt.Errorf("token %d not in ring", token)
+	}
+	r.hashMap[token] = to
+	return from, nil
+}
+
+// AddToken insere no anel um token que não vem dos vnodes de nenhum nó
+// conhecido (ex: um token aprendido de outro nó do cluster).
+func (r *Ring) AddToken(token uint32, n NodeInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if owner, ok := r.hashMap[token]; ok {
+		return fmt.Errorf("token %d already owned by %s", token, owner.ID)
+	}
+	r.hashes = append(r.hashes, token)
+	r.hashMap[token] = n
+	r.sortHashes()
+	return nil
+}
+
+// SetHost muda o endereço de um nó em todos os tokens dele e retorna
+// quantos tokens mudaram.
+func (r *Ring) SetHost(id NodeID, host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := 0
+	for h, n := range r.hashMap {
+		if n.ID == id && n.Host != host {
+			n.Host = host
+			r.hashMap[h] = n
+			changed++
+		}
+	}
+	return changed
+}
+
+// TokenOwner retorna o nó dono de um token exato do anel.
+func (r *Ring) TokenOwner(token uint32) (NodeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok := r.hashMap[token]
+	return n, ok
+}
+
+// RemoveNode remove um nó (por ID) do ring.
+func (r *Ring) RemoveNode(id NodeID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	newHashes := make([]uint32, 0, len(r.hashes))
+	for _, h := range r.hashes {
+		if r.hashMap[h].ID == id {
+			delete(r.hashMap, h)
+			continue
+		}
+		newHashes = append(newHashes, h)
+	}
+	r.hashes = newHashes
+	r.sortHashes()
+}
+
+// Nodes retorna os nós físicos do ring, ordenados por ID.
+func (r *Ring) Nodes() []NodeInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[NodeID]struct{})
+	nodes := make([]NodeInfo, 0)
+	for _, n := range r.hashMap {
+		if _, ok := seen[n.ID]; ok {
+			continue
+		}
+		seen[n.ID] = struct{}{}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// getNode retorna o nó responsável por um hash de chave (deve ser chamado com lock).
+func (r *Ring) getNode(hash uint32) (NodeInfo, bool) {
+	if len(r.hashes) == 0 {
+		return NodeInfo{}, false
+	}
+	// busca binária do primeiro hash >= keyHash
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+	if idx == len(r.hashes) {
+		idx = 0 // wrap-around
+	}
+	h := r.hashes[idx]
+	n, ok := r.hashMap[h]
+	return n, ok
+}
+
+// GetNodeForKey retorna o nó primário para uma chave.
+func (r *Ring) GetNodeForKey(key string) (NodeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h := r.hash(key)
+	return r.getNode(h)
+}
+
+// GetReplicasForKey retorna até rFactor nós distintos para a chave.
+func (r *Ring) GetReplicasForKey(key string, rFactor int) []NodeInfo {
+	return r.ReplicasForToken(r.hash(key), rFactor)
+}
+
+// ReplicasForToken retorna até rFactor nós distintos responsáveis pelo
+// token, escolhidos pela estratégia do ring.
+func (r *Ring) ReplicasForToken(h uint32, rFactor int) []NodeInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.hashes) == 0 || rFactor <= 0 {
+		return nil
+	}
+	return r.strategy.Replicas(func(fn func(NodeInfo) bool) { r.walkLocked(h, fn) }, rFactor)
+}
+
+// Walk chama fn para cada nó físico do anel, em ordem a partir do token h
+// (cada nó uma vez, na posição do primeiro vnode dele), até fn retornar
+// false ou a volta terminar. fn não pode chamar o ring.
+func (r *Ring) Walk(h uint32, fn func(NodeInfo) bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.walkLocked(h, fn)
+}
+
+func (r *Ring) walkLocked(h uint32, fn func(NodeInfo) bool) {
+	if len(r.hashes) == 0 {
+		return
+	}
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	// anda no máximo uma volta no anel
+	seen := make(map[NodeID]struct{})
+	for steps := 0; steps < len(r.hashes); steps++ {
+		node := r.hashMap[r.hashes[idx]]
+		if _, ok := seen[node.ID]; !ok {
+			seen[node.ID] = struct{}{}
+			if !fn(node) {
+				return
+			}
+		}
+		idx = (idx + 1) % len(r.hashes)
+	}
+}
+
+// HashKey retorna o token de uma chave com o hash padrão (FNV32a). Para um
+// ring com WithHash, use Ring.Hash.
+func HashKey(key string) uint32 {
+	return FNV32a(key)
+}
+
+// TokenRange é o intervalo (Start, End] do anel que termina no token de um
+// vnode; Owner é o nó desse vnode (a réplica primária do intervalo).
+// O primeiro intervalo dá a volta: Start > End.
+type TokenRange struct {
+	Start uint32   `json:"start"`
+	End   uint32   `json:"end"`
+	Owner NodeInfo `json:"-"`
+}
+
+// Contains diz se o token pertence ao intervalo.
+func (t TokenRange) Contains(h uint32) bool {
+	if t.Start < t.End {
+		return h > t.Start && h <= t.End
+	}
+	// intervalo que dá a volta no anel (ou o anel inteiro, com um só token)
+	return h > t.Start || h <= t.End
+}
+
+// Ranges retorna os intervalos de tokens do anel, na ordem dos tokens.
+func (r *Ring) Ranges() []TokenRange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]TokenRange, len(r.hashes))
+	for i, h := range r.hashes {
+		prev := r.hashes[(i+len(r.hashes)-1)%len(r.hashes)]
+		out[i] = TokenRange{Start: prev, End: h, Owner: r.hashMap[h]}
+	}
+	return out
+}
+
+// RangeIndex retorna o índice (em Ranges) do intervalo que contém o token,
+// ou -1 se o anel estiver vazio.
+func (r *Ring) RangeIndex(h uint32) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return -1
+	}
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return idx
+}
+
+// Ownership é a fração do espaço de tokens pela qual um nó responde.
+type Ownership struct {
+	Node NodeInfo
+	// Primary: fração dos tokens em que o nó é a réplica primária.
+	Primary float64
+	// Effective: fração dos tokens em que o nó é uma das rFactor réplicas
+	// (a soma entre os nós dá rFactor, como o "Owns (effective)" do Cassandra).
+	Effective float64
+	Tokens    int
+}
+
+// Ownership calcula, para o layout atual de vnodes, quanto do espaço de
+// tokens cada nó possui. O intervalo (hashes[i-1], hashes[i]] pertence às
+// réplicas que a estratégia escolhe a partir de hashes[i].
+func (r *Ring) Ownership(rFactor int) []Ownership {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byID := make(map[NodeID]*Ownership)
+	var order []NodeID
+	for _, h := range r.hashes {
+		n := r.hashMap[h]
+		o, ok := byID[n.ID]
+		if !ok {
+			o = &Ownership{Node: n}
+			byID[n.ID] = o
+			order = append(order, n.ID)
+		}
+		o.Tokens++
+	}
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	if rFactor < 1 {
+		rFactor = 1
+	}
+
+	const space = float64(1 << 32)
+	for i, h := range r.hashes {
+		// tamanho do intervalo que termina neste token (com wrap-around)
+		prev := r.hashes[(i+len(r.hashes)-1)%len(r.hashes)]
+		size := float64(h - prev)
+		if len(r.hashes) == 1 {
+			size = space
+		}
+		frac := size / space
+
+		// as réplicas do token, pela estratégia do ring; a primeira é a dona
+		byID[r.hashMap[h].ID].Primary += frac
+		for _, n := range r.strategy.Replicas(func(fn func(NodeInfo) bool) { r.walkLocked(h, fn) }, rFactor) {
+			byID[n.ID].Effective += frac
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	out := make([]Ownership, 0, len(order))
+	for _, id := range order {
+		out = append(out, *byID[id])
+	}
+	return out
+}
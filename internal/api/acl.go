@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// ACLRule dá a uma identidade leitura e/ou escrita nas chaves que começam
+// com Prefix. As regras só concedem: o acesso de uma identidade é a união
+// das regras dela e das de "*".
+type ACLRule struct {
+	// Identity: nome de AUTH_TOKENS, ou "*" (qualquer identidade autenticada)
+	Identity string
+	// Prefix: prefixo das chaves ("orders:" = keyspace orders); "" = todas
+	Prefix string
+	Read   bool
+	Write  bool
+}
+
+// ACLConfig é a configuração do controle de acesso da API de cliente.
+type ACLConfig struct {
+	// Tokens: identidade -> token (Authorization: Bearer <token>); vazio
+	// desliga a autenticação
+	Tokens map[string]string
+	Rules  []ACLRule
+}
+
+// ACL autentica as requisições de cliente pelo token e diz o que cada
+// identidade pode ler e gravar.
+type ACL struct {
+	// tokens: sha256 do token -> identidade (a busca não compara o token
+	// byte a byte com os configurados)
+	tokens map[[sha256.Size]byte]string
+	rules  []ACLRule
+}
+
+// aclPerm é o acesso pedido por uma operação.
+type aclPerm int
+
+const (
+	aclRead aclPerm = 1 << iota
+	aclWrite
+)
+
+func (p aclPerm) String() string {
+	switch p {
+	case aclRead:
+		return "read"
+	case aclWrite:
+		return "write"
+	}
+	return "read/write"
+}
+
+// NewACL monta o ACL; nil (sem controle de acesso) sem tokens.
+func NewACL(cfg ACLConfig) (*ACL, error) {
+	if len(cfg.Tokens) == 0 {
+		if len(cfg.Rules) > 0 {
+			return nil, fmt.Errorf("ACL rules without AUTH_TOKENS")
+		}
+		return nil, nil
+	}
+	a := &ACL{tokens: make(map[[sha256.Size]byte]string, len(cfg.Tokens)), rules: cfg.Rules}
+	for identity, token := range cfg.Tokens {
+		if identity == "" || identity == "*" || token == "" {
+			return nil, fmt.Errorf("invalid token for identity %q", identity)
+		}
+		h := sha256.Sum256([]byte(token))
+		if other, dup := a.tokens[h]; dup {
+			return nil, fmt.Errorf("identities %s and %s share a token", other, identity)
+		}
+		a.tokens[h] = identity
+	}
+	for _, rule := range cfg.Rules {
+		if _, ok := cfg.Tokens[rule.Identity]; !ok && rule.Identity != "*" {
+			return nil, fmt.Errorf("rule for unknown identity %q", rule.Identity)
+		}
+		if !rule.Read && !rule.Write {
+			return nil, fmt.Errorf("rule for %s on %q grants nothing", rule.Identity, rule.Prefix)
+		}
+	}
+	return a, nil
+}
+
+// identify retorna a identidade do token Bearer da requisição.
+func (a *ACL) identify(req *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	identity, ok := a.tokens[sha256.Sum256([]byte(token))]
+	return identity, ok
+}
+
+// allows diz se identity tem perm em todas as chaves que começam com prefix
+// (numa chave só, prefix é a própria chave): alguma regra dela cobre um
+// prefixo de prefix.
+func (a *ACL) allows(identity, prefix string, perm aclPerm) bool {
+	var granted aclPerm
+	for _, rule := range a.rules {
+		if (rule.Identity != identity && rule.Identity != "*") || !strings.HasPrefix(prefix, rule.Prefix) {
+			continue
+		}
+		if rule.Read {
+			granted |= aclRead
+		}
+		if rule.Write {
+			granted |= aclWrite
+		}
+	}
+	return granted&perm == perm
+}
+
+// aclGrant é a identidade autenticada de uma requisição, no contexto dela.
+type aclGrant struct {
+	acl      *ACL
+	identity string
+}
+
+type aclGrantKey struct{}
+
+// aclAllows diz se a requisição pode fazer perm nas chaves com prefix (sem
+// ACL, sempre). Para os handlers que não têm a chave na URL (scan, índices,
+// _mdelete).
+func aclAllows(req *http.Request, prefix string, perm aclPerm) bool {
+	g, ok := req.Context().Value(aclGrantKey{}).(aclGrant)
+	return !ok || g.acl.allows(g.identity, prefix, perm)
+}
+
+// aclDeny responde 403 a uma operação sem permissão.
+func aclDeny(w http.ResponseWriter, req *http.Request, prefix string, perm aclPerm) {
+	g, _ := req.Context().Value(aclGrantKey{}).(aclGrant)
+	metrics.Inc("acl.denied")
+	log.Printf("[ACL] denied %s %s: %s has no %s access to %q", req.Method, req.URL.Path, g.identity, perm, prefix)
+	http.Error(w, fmt.Sprintf("forbidden: %s has no %s access to %q", g.identity, perm, prefix), http.StatusForbidden)
+}
+
+// isACLPath diz se path é da API de cliente (a consulta a índice também
+// tem o caminho antigo /index/{name}).
+func isACLPath(path string) bool {
+	return isClientPath(path) || strings.HasPrefix(path, "/index/")
+}
+
+// keyRoutePerms é o acesso que cada rota /kv/{key}/... pede na chave da URL
+// (fora daqui: GET e HEAD leem, o resto grava).
+var keyRoutePerms = map[string]aclPerm{
+	"/getset":  aclRead | aclWrite,
+	"/rename":  aclRead | aclWrite,
+	"/copy":    aclRead,
+	"/history": aclRead,
+	"/set":     aclRead,
+	"/map":     aclRead,
+}
+
+// ACLMiddleware exige nas rotas de cliente um token de AUTH_TOKENS (401 sem
+// ele) e confere as regras nas rotas com a chave na URL (403); o destino
+// ?to= de rename e copy precisa de escrita. Scan, agregação, jobs de scan,
+// índices e _mdelete conferem o prefixo ou as chaves no handler. Sem ACL,
+// não faz nada.
+func ACLMiddleware(acl *ACL) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if acl == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !isACLPath(req.URL.Path) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			identity, ok := acl.identify(req)
+			if !ok {
+				metrics.Inc("acl.unauthorized")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mini-cassandra"`)
+				http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+				return
+			}
+			req = req.WithContext(context.WithValue(req.Context(), aclGrantKey{}, aclGrant{acl: acl, identity: identity}))
+
+			if key := pathKey(req); key != "" {
+				_, suffix, _ := strings.Cut(routeTemplate(req), "{key}")
+				perm, ok := keyRoutePerms[suffix]
+				if !ok {
+					perm = aclWrite
+					if req.Method == http.MethodGet || req.Method == http.MethodHead {
+						perm = aclRead
+					}
+				}
+				if !acl.allows(identity, key, perm) {
+					aclDeny(w, req, key, perm)
+					return
+				}
+				if to := req.URL.Query().Get("to"); to != "" && (suffix == "/rename" || suffix == "/copy") && !acl.allows(identity, to, aclWrite) {
+					aclDeny(w, req, to, aclWrite)
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/internal/cluster"
+)
+
+const defaultImportBatch = 1000
+
+type importRecord struct {
+	Key       string  `json:"key"`
+	Value     *string `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// importError é emitido para cada registro que falhou (e, com
+// ?results=all, importResult para cada um que foi gravado). Replicas são as
+// réplicas que aplicaram o registro: numa falha por falta de confirmações,
+// as que aplicaram ficam com ele mesmo assim.
+type importError struct {
+	Line     int      `json:"line"`
+	Key      string   `json:"key,omitempty"`
+	Error    string   `json:"error"`
+	Replicas []string `json:"replicas,omitempty"`
+}
+
+type importResult struct {
+	Line     int      `json:"line"`
+	Key      string   `json:"key"`
+	OK       bool     `json:"ok"`
+	Replicas []string `json:"replicas"`
+}
+
+// importProgress é emitido ao fim de cada lote (e uma última vez com Done=true).
+type importProgress struct {
+	Processed int  `json:"processed"`
+	Imported  int  `json:"imported"`
+	Failed    int  `json:"failed"`
+	Done      bool `json:"done"`
+}
+
+// HandleImport: POST /admin/import
+// Corpo em NDJSON, uma linha por registro: {"key":..., "value":..., "timestamp":...}.
+// Os registros são agrupados em lotes (?batch=N) e roteados pelo ring.
+// A resposta também é NDJSON: erros por registro (com ?results=all, também
+// o resultado de cada registro gravado) e o progresso após cada lote.
+func HandleImport(r *cluster.Router, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		all := req.URL.Query().Get("results") == "all"
+		batchSize := defaultImportBatch
+		if v := req.URL.Query().Get("batch"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "invalid batch", http.StatusBadRequest)
+				return
+			}
+			batchSize = n
+		}
+
+		// lê o começo do corpo antes de responder: com "Expect: 100-continue"
+		// (curl com arquivos grandes) o servidor fecharia o corpo se a
+		// resposta saísse antes do 100 Continue
+		reader := bufio.NewReader(req.Body)
+		reader.Peek(1)
+
+		// o progresso é escrito enquanto o corpo ainda está sendo lido
+		rc := http.NewResponseController(w)
+		rc.EnableFullDuplex()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+
+		var (
+			progress importProgress
+			batch    []cluster.Record
+			lines    []int
+		)
+
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			for i, res := range r.PutBatch(batch) {
+				if res.Err != nil {
+					progress.Failed++
+					enc.Encode(importError{Line: lines[i], Key: batch[i].Key, Error: res.Err.Error(), Replicas: ackedIDs(res.Acked)})
+					continue
+				}
+				progress.Imported++
+				if all {
+					enc.Encode(importResult{Line: lines[i], Key: batch[i].Key, OK: true, Replicas: ackedIDs(res.Acked)})
+				}
+			}
+			progress.Processed += len(batch)
+			enc.Encode(progress)
+			rc.Flush()
+			log.Printf("[IMPORT] processed=%d imported=%d failed=%d", progress.Processed, progress.Imported, progress.Failed)
+			batch = batch[:0]
+			lines = lines[:0]
+		}
+
+		// uma linha é um registro: o limite é o de um corpo de réplica (a
+		// chave e o valor em JSON), e uma linha maior é descartada sem ir
+		// inteira para a memória
+		max := limits.replicaBodyMax()
+		var buf bytes.Buffer
+		lineNo := 0
+		for {
+			tooLong, err := readLine(reader, &buf, max)
+			if tooLong || buf.Len() > 0 {
+				lineNo++
+			}
+			var rec importRecord
+			line := bytes.TrimSpace(buf.Bytes())
+			if tooLong {
+				progress.Processed++
+				progress.Failed++
+				enc.Encode(importError{Line: lineNo, Error: fmt.Sprintf("line exceeds %d bytes", max)})
+			} else if len(line) == 0 {
+				// linha em branco
+			} else if jerr := json.Unmarshal(line, &rec); jerr != nil {
+				progress.Processed++
+				progress.Failed++
+				enc.Encode(importError{Line: lineNo, Error: "invalid json"})
+			} else if rec.Key == "" || rec.Value == nil {
+				progress.Processed++
+				progress.Failed++
+				enc.Encode(importError{Line: lineNo, Key: rec.Key, Error: "missing key or value"})
+			} else if lerr := errors.Join(limits.checkKey(rec.Key), limits.checkValue(len(*rec.Value))); lerr != nil {
+				progress.Processed++
+				progress.Failed++
+				enc.Encode(importError{Line: lineNo, Key: rec.Key, Error: lerr.Error()})
+			} else {
+				batch = append(batch, cluster.Record{Key: rec.Key, Value: *rec.Value, Timestamp: rec.Timestamp})
+				lines = append(lines, lineNo)
+				if len(batch) >= batchSize {
+					flush()
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("[IMPORT] read error at line %d: %v", lineNo, err)
+					enc.Encode(importError{Line: lineNo, Error: "read error: " + err.Error()})
+				}
+				break
+			}
+		}
+		flush()
+
+		progress.Done = true
+		enc.Encode(progress)
+		log.Printf("[IMPORT] finished: processed=%d imported=%d failed=%d", progress.Processed, progress.Imported, progress.Failed)
+	}
+}
+
+// readLine lê a próxima linha de rd para buf (com o '\n', se houver). Uma
+// linha com mais de max bytes (max > 0) é lida até o fim e descartada, sem
+// ficar na memória: volta com tooLong e buf vazio.
+func readLine(rd *bufio.Reader, buf *bytes.Buffer, max int64) (tooLong bool, err error) {
+	buf.Reset()
+	for {
+		chunk, err := rd.ReadSlice('\n')
+		if !tooLong {
+			n := len(bytes.TrimSuffix(chunk, []byte("\n")))
+			if max > 0 && int64(buf.Len()+n) > max {
+				tooLong = true
+				buf.Reset()
+			} else {
+				buf.Write(chunk)
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return tooLong, err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleAppMetadata: GET /admin/metadata
+// Metadados de aplicação deste nó (os mesmos do meta.app em /cluster/status).
+func HandleAppMetadata(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"node_id": r.NodeID(), "metadata": r.AppMetadata()})
+	}
+}
+
+// HandlePatchAppMetadata: PATCH /admin/metadata
+// Corpo: merge-patch dos metadados ({"maintenance": "true", "zone": null}
+// grava maintenance e remove zone). A mudança fica no arquivo de estado e
+// aparece na próxima consulta ao /cluster/status de qualquer nó.
+func HandlePatchAppMetadata(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(req.Body, 64<<10))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var patch map[string]*string
+		if err := json.Unmarshal(body, &patch); err != nil {
+			http.Error(w, "invalid json (want an object of strings, null removes a key)", http.StatusBadRequest)
+			return
+		}
+		meta, err := r.PatchAppMetadata(patch)
+		if errors.Is(err, cluster.ErrInvalidAppMetadata) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[META] node metadata updated: %d keys changed, %d total", len(patch), len(meta))
+		writeJSON(w, http.StatusOK, map[string]any{"node_id": r.NodeID(), "metadata": meta})
+	}
+}
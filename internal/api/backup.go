@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/backup"
+)
+
+type restoreResponse struct {
+	ID      string `json:"id"`
+	NodeID  string `json:"node_id"`
+	Entries int    `json:"entries"`
+	Applied int    `json:"applied"`
+}
+
+// HandleBackup: POST /admin/backup?type=full|incremental
+// Tira um snapshot do store local e envia para o destino de backup configurado.
+// Com type=incremental envia só os segmentos de WAL novos desde o último
+// backup do nó, encadeados a ele por um manifesto.
+func HandleBackup(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		id := q.Get("id")
+		if id == "" {
+			id = backup.NewSnapshotID()
+		}
+		if err := backup.ValidateName("id", id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch q.Get("type") {
+		case "", "full":
+		case "incremental":
+			man, err := m.Incremental(r.Context(), id)
+			if errors.Is(err, backup.ErrNotFound) || errors.Is(err, backup.ErrIncrementalOrder) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Printf("[BACKUP] incremental %s failed: %v", id, err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			writeJSON(w, http.StatusOK, man)
+			return
+		default:
+			http.Error(w, "invalid type (want full or incremental)", http.StatusBadRequest)
+			return
+		}
+
+		res, err := m.Backup(r.Context(), id)
+		if err != nil {
+			log.Printf("[BACKUP] snapshot %s failed: %v", id, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// HandleListBackups: GET /admin/backups?node=...
+// Lista os snapshots guardados para este nó (ou para o nó informado).
+func HandleListBackups(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		node := r.URL.Query().Get("node")
+		if node == "" {
+			node = m.NodeID()
+		}
+		if err := backup.ValidateName("node", node); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		names, err := m.Target().List(r.Context(), node+"/")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		// só snapshots e manifestos; os segmentos de WAL ficam de fora
+		backups := []string{}
+		for _, name := range names {
+			if strings.HasSuffix(name, ".snapshot.json") || strings.HasSuffix(name, ".incremental.json") {
+				backups = append(backups, name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backups)
+	}
+}
+
+// HandleRestore: POST /admin/restore?id=...&node=...&replace=true
+// Baixa um snapshot do destino de backup e carrega no store local.
+// Por padrão mescla por timestamp; replace=true descarta os dados atuais antes.
+// Se o id for de um backup incremental, a cadeia inteira é restaurada
+// (sempre substituindo os dados atuais).
+//
+// Com ?until=<RFC3339> faz restore point-in-time: carrega o snapshot mais
+// recente até esse instante e reaplica os segmentos de WAL arquivados até ele.
+func HandleRestore(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, target, walLog := m.Store(), m.Target(), m.WAL()
+		q := r.URL.Query()
+		node := q.Get("node")
+		if node == "" {
+			node = m.NodeID()
+		}
+		if err := backup.ValidateName("node", node); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if v := q.Get("until"); v != "" {
+			until, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, "invalid until (want RFC3339)", http.StatusBadRequest)
+				return
+			}
+			if walLog == nil || (walLog.ArchiveDir() == "" && !walLog.RemoteArchive()) {
+				http.Error(w, "wal archiving is disabled (set WAL_ARCHIVE_DIR or WAL_ARCHIVE_REMOTE)", http.StatusBadRequest)
+				return
+			}
+			// arquiva o segmento atual para que as escritas mais recentes entrem no replay
+			if err := walLog.Rotate(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			walLog.WaitArchived()
+
+			res, err := backup.RestorePointInTime(r.Context(), store, target, node, walLog.ArchiveDir(), until)
+			if errors.Is(err, backup.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("[RESTORE] point-in-time restore failed: %v", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		id := q.Get("id")
+		if id == "" {
+			http.Error(w, "missing id", http.StatusBadRequest)
+			return
+		}
+		if err := backup.ValidateName("id", id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		replace := q.Get("replace") == "true"
+
+		// incremental: restaura a cadeia inteira até o snapshot base
+		res, err := m.RestoreIncremental(r.Context(), node, id)
+		if err == nil {
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+		if !errors.Is(err, backup.ErrNotFound) {
+			log.Printf("[RESTORE] incremental %s failed: %v", id, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		name := backup.SnapshotName(node, id)
+		data, err := target.Get(r.Context(), name)
+		if errors.Is(err, backup.ErrNotFound) {
+			http.Error(w, "backup not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("[RESTORE] download %s failed: %v", name, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		snap, err := backup.DecodeSnapshot(bytes.NewReader(data))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		applied := snap.Apply(store, replace)
+		log.Printf("[RESTORE] snapshot %s loaded: entries=%d applied=%d replace=%v", name, len(snap.Entries), applied, replace)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(restoreResponse{
+			ID:      id,
+			NodeID:  node,
+			Entries: len(snap.Entries),
+			Applied: applied,
+		})
+	}
+}
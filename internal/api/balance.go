@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/jobs"
+)
+
+// balanceParams lê threshold (desvio relativo tolerado) e max_moves.
+func balanceParams(req *http.Request) (threshold float64, maxMoves int, ok bool) {
+	q := req.URL.Query()
+	threshold, maxMoves = cluster.DefaultBalanceThreshold, cluster.DefaultBalanceMaxMoves
+	if v := q.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 {
+			return 0, 0, false
+		}
+		threshold = t
+	}
+	if v := q.Get("max_moves"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		maxMoves = n
+	}
+	return threshold, maxMoves, true
+}
+
+// HandleBalancePlan: GET /admin/balance?threshold=0.1&max_moves=32
+// Dry-run do balanceamento: os move-token que aproximariam cada nó da posse
+// ideal e a posse antes e depois deles. Nada é movido.
+func HandleBalancePlan(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		threshold, maxMoves, ok := balanceParams(req)
+		if !ok {
+			http.Error(w, "invalid threshold or max_moves", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, r.PlanBalance(threshold, maxMoves))
+	}
+}
+
+// HandleBalance: POST /admin/balance?threshold=0.1&max_moves=32
+// Calcula o plano e o executa em background (job "balance" em /admin/jobs).
+// Com o ring já balanceado, responde 200 com o plano vazio.
+func HandleBalance(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		threshold, maxMoves, ok := balanceParams(req)
+		if !ok {
+			http.Error(w, "invalid threshold or max_moves", http.StatusBadRequest)
+			return
+		}
+		plan, job, err := r.StartBalance(threshold, maxMoves)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		out := struct {
+			Job  *jobs.Info          `json:"job,omitempty"`
+			Plan cluster.BalancePlan `json:"plan"`
+		}{Plan: plan}
+		status := http.StatusOK
+		if job != nil {
+			info := job.Info()
+			out.Job = &info
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, out)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// CORSConfig diz quais origens de navegador podem chamar a API de cliente.
+type CORSConfig struct {
+	// AllowedOrigins: origens aceitas ("*" = qualquer uma); vazio desliga o CORS
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders são os headers da resposta que o navegador deixa o
+	// JavaScript ler (ETag, versão do valor)
+	ExposedHeaders []string
+	MaxAge         int
+}
+
+// Padrões de métodos e headers do CORS.
+var (
+	DefaultCORSMethods = []string{"GET", "HEAD", "PUT", "PATCH", "DELETE"}
+	DefaultCORSHeaders = []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Consistency", "X-Timeout"}
+	defaultCORSExposed = []string{"ETag", "X-Timestamp", "X-Expires-At", "X-Value-Length", "Deprecation", "Link", "X-Consistency",
+		cluster.CoordinatorHeader, cluster.ReplicasContactedHeader, cluster.ReplicasAckedHeader, cluster.ServedByHeader,
+		cluster.ConsistencyAchievedHeader, cluster.TraceIDHeader}
+)
+
+// CORS responde aos preflights (OPTIONS) e coloca os headers de CORS nas
+// respostas da API de cliente (/v1/* e os caminhos antigos /kv/*). Envolve o
+// router inteiro porque os preflights não casam com as rotas (que só aceitam
+// os métodos delas).
+func CORS(cfg CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	if len(cfg.ExposedHeaders) == 0 {
+		cfg.ExposedHeaders = defaultCORSExposed
+	}
+	anyOrigin := false
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || !isClientPath(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !anyOrigin && !allowed[origin] {
+			// origem não autorizada: segue sem headers de CORS (o navegador bloqueia)
+			next.ServeHTTP(w, req)
+			return
+		}
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", exposed)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// isClientPath diz se o caminho é da API de cliente.
+func isClientPath(path string) bool {
+	return strings.HasPrefix(path, APIVersion+"/") || strings.HasPrefix(path, "/kv/")
+}
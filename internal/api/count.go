@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+)
+
+type nodeCount struct {
+	NodeID  string `json:"node_id"`
+	Primary int    `json:"primary"`
+	Total   int    `json:"total"`
+	Error   string `json:"error,omitempty"`
+}
+
+type clusterCount struct {
+	Keyspace string      `json:"keyspace,omitempty"`
+	Prefix   string      `json:"prefix,omitempty"`
+	Keys     int         `json:"keys"`
+	Partial  bool        `json:"partial"`
+	Nodes    []nodeCount `json:"nodes"`
+}
+
+// HandleClusterCount: GET /admin/count?keyspace=...&prefix=...
+// Pergunta a cada nó quantas chaves ele tem como réplica primária e soma,
+// o que dá o número aproximado de chaves distintas no cluster.
+// partial=true indica que algum nó não respondeu.
+func HandleClusterCount(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		filter := url.Values{}
+		filter.Set("keyspace", q.Get("keyspace"))
+		filter.Set("prefix", q.Get("prefix"))
+
+		ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+		defer cancel()
+		results := r.Broadcast(ctx, "GET", "/internal/count?"+filter.Encode(), nil)
+
+		out := clusterCount{Keyspace: q.Get("keyspace"), Prefix: q.Get("prefix")}
+		for _, res := range results {
+			n := nodeCount{NodeID: string(res.Node.ID)}
+			if res.OK() {
+				err := json.Unmarshal(res.Body, &n)
+				n.NodeID = string(res.Node.ID)
+				if err != nil {
+					n.Error = "invalid response"
+				}
+			} else {
+				n.Error = res.Error()
+			}
+			if n.Error != "" {
+				out.Partial = true
+			} else {
+				out.Keys += n.Primary
+			}
+			out.Nodes = append(out.Nodes, n)
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalCount: GET /internal/count?keyspace=...&prefix=...
+func HandleInternalCount(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		primary, total := r.LocalCount(q.Get("keyspace"), q.Get("prefix"))
+		writeJSON(w, http.StatusOK, nodeCount{
+			NodeID:  string(r.NodeID()),
+			Primary: primary,
+			Total:   total,
+		})
+	}
+}
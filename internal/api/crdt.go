@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// HandleSetAdd: POST /kv/{key}/set/add
+// Corpo: a lista JSON de elementos (texto) a adicionar ao set da chave. Não
+// lê o set antes: adds de coordenadores diferentes convergem sozinhos.
+func HandleSetAdd(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleCRDTWrite("SET ADD", r, hot, limits, func(w http.ResponseWriter, req *http.Request, key string, cl cluster.Consistency, body []byte) error {
+		var elems []string
+		if err := json.Unmarshal(body, &elems); err != nil {
+			http.Error(w, "body must be a JSON array of strings", http.StatusBadRequest)
+			return nil
+		}
+		if err := r.SetAdd(req.Context(), key, elems, cl); err != nil {
+			return err
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "added": len(elems)})
+		return nil
+	})
+}
+
+// HandleSetRemove: POST /kv/{key}/set/remove
+// Corpo: a lista JSON de elementos a remover. Remove os adds que este
+// coordenador leu (com a consistência da escrita); um add concorrente que ele
+// não viu continua no set. removed conta os que estavam presentes.
+func HandleSetRemove(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleCRDTWrite("SET REMOVE", r, hot, limits, func(w http.ResponseWriter, req *http.Request, key string, cl cluster.Consistency, body []byte) error {
+		var elems []string
+		if err := json.Unmarshal(body, &elems); err != nil {
+			http.Error(w, "body must be a JSON array of strings", http.StatusBadRequest)
+			return nil
+		}
+		n, err := r.SetRemove(req.Context(), key, elems, cl)
+		if err != nil {
+			return err
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "removed": n})
+		return nil
+	})
+}
+
+// HandleMapPut: POST /kv/{key}/map/put
+// Corpo: o objeto JSON {"campo": "valor"} com os campos a gravar no map.
+func HandleMapPut(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleCRDTWrite("MAP PUT", r, hot, limits, func(w http.ResponseWriter, req *http.Request, key string, cl cluster.Consistency, body []byte) error {
+		var fields map[string]string
+		if err := json.Unmarshal(body, &fields); err != nil {
+			http.Error(w, "body must be a JSON object of strings", http.StatusBadRequest)
+			return nil
+		}
+		if err := r.MapPut(req.Context(), key, fields, cl); err != nil {
+			return err
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "put": len(fields)})
+		return nil
+	})
+}
+
+// HandleMapRemove: POST /kv/{key}/map/remove
+// Corpo: a lista JSON de campos a remover do map.
+func HandleMapRemove(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleCRDTWrite("MAP REMOVE", r, hot, limits, func(w http.ResponseWriter, req *http.Request, key string, cl cluster.Consistency, body []byte) error {
+		var fields []string
+		if err := json.Unmarshal(body, &fields); err != nil {
+			http.Error(w, "body must be a JSON array of strings", http.StatusBadRequest)
+			return nil
+		}
+		n, err := r.MapRemove(req.Context(), key, fields, cl)
+		if err != nil {
+			return err
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "removed": n})
+		return nil
+	})
+}
+
+// handleCRDTWrite é o comum das escritas em sets e maps: valida a chave, lê
+// o corpo e a consistência e mapeia o erro de op (que já escreveu a resposta
+// quando retorna nil).
+func handleCRDTWrite(name string, r *cluster.Router, hot *hotkeys.Tracker, limits Limits, op func(w http.ResponseWriter, req *http.Request, key string, cl cluster.Consistency, body []byte) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		if err := limits.checkKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, ok := readBody(w, req, limits.MaxValueBytes, "body")
+		if !ok {
+			return
+		}
+		cl, err := parseConsistency(w, req, r.WriteConsistencyFor(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] %s key=%s", name, key)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.crdt", time.Now())
+
+		if err := op(w, req.WithContext(ctx), key, cl, body); err != nil {
+			metrics.Inc("client.crdt.errors")
+			log.Printf("[ERROR] %s key=%s err=%v", name, key, err)
+			writeError(w, err, writeErrorStatus(err))
+		}
+	}
+}
+
+// HandleCRDTGet: GET /kv/{key}/set e GET /kv/{key}/map
+// O set (elements, em ordem) ou o map (fields) da chave; uma chave que não
+// existe é uma coleção vazia. 422 se a chave guarda outro tipo de valor.
+func HandleCRDTGet(r *cluster.Router, hot *hotkeys.Tracker, typ string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		cl, err := parseConsistency(w, req, r.ReadConsistencyFor(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		hot.Record(key, hotkeys.Read)
+		defer metrics.Since("client.crdt_get", time.Now())
+
+		st, _, err := r.CRDT(ctx, key, typ, cl)
+		if err != nil {
+			log.Printf("[ERROR] CRDT GET key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		if typ == kv.CRDTSet {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "elements": st.Elements()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "fields": st.Fields()})
+	}
+}
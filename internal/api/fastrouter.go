@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// FastRouter atende as rotas quentes (GET/PUT/HEAD/DELETE /v1/kv/{key} e as
+// de réplica) sem passar pelo mux, que a cada requisição testa as rotas uma a
+// uma com regexp e copia a requisição duas vezes para guardar as variáveis e a
+// rota no contexto. Aqui o casamento é por mapa e prefixo, sem regexp, e a
+// requisição é copiada uma vez só, com o próprio FastRouter no contexto (de
+// onde pathKey e routeTemplate tiram a chave e a rota); o resto (e métodos
+// sem rota rápida) vai para o fallback. Não é livre de alocações: a cópia e
+// o contexto são duas por requisição (TestFastRouterAllocs). HTTP_ROUTER=fast.
+type FastRouter struct {
+	fallback    http.Handler
+	middlewares []mux.MiddlewareFunc
+	exact       map[string]fastMethods // path -> método -> handler
+	keyed       []fastKeyRoute
+}
+
+type fastMethods map[string]http.Handler
+
+// fastKeyRoute é uma rota prefix + "{key}" (a chave é o último segmento, não
+// vazio e sem "/", como no [^/]+ do mux; "." e ".." ficam com o mux, que
+// limpa o path e redireciona).
+type fastKeyRoute struct {
+	prefix   string
+	template string // prefix + "{key}", montado no registro
+	methods  fastMethods
+}
+
+// fastRouterKey guarda no contexto o FastRouter que casou a requisição. Cada
+// nó tem o seu (vários nós no mesmo processo, ver pkg/node), então ele vai
+// com a requisição em vez de numa variável do pacote. As rotas são
+// registradas no boot e só lidas depois.
+type fastRouterKey struct{}
+
+// fastRouterFrom é o FastRouter que atendeu req, se houver.
+func fastRouterFrom(req *http.Request) *FastRouter {
+	f, _ := req.Context().Value(fastRouterKey{}).(*FastRouter)
+	return f
+}
+
+// NewFastRouter cria o roteador na frente de fallback (o mux). As rotas
+// rápidas passam pelos mesmos middlewares, na mesma ordem do r.Use do mux.
+func NewFastRouter(fallback http.Handler, middlewares ...mux.MiddlewareFunc) *FastRouter {
+	return &FastRouter{fallback: fallback, middlewares: middlewares, exact: make(map[string]fastMethods)}
+}
+
+// wrap aplica os middlewares a h (o primeiro fica por fora, como no mux).
+func (f *FastRouter) wrap(h http.Handler) http.Handler {
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		h = f.middlewares[i](h)
+	}
+	return h
+}
+
+// Handle registra uma rota de path fixo.
+func (f *FastRouter) Handle(method, path string, h http.Handler) {
+	if f.exact[path] == nil {
+		f.exact[path] = make(fastMethods)
+	}
+	f.exact[path][method] = f.wrap(h)
+}
+
+// HandleKey registra a rota prefix + "{key}" (prefix termina em "/").
+func (f *FastRouter) HandleKey(method, prefix string, h http.Handler) {
+	for i := range f.keyed {
+		if f.keyed[i].prefix == prefix {
+			f.keyed[i].methods[method] = f.wrap(h)
+			return
+		}
+	}
+	f.keyed = append(f.keyed, fastKeyRoute{prefix: prefix, template: prefix + "{key}", methods: fastMethods{method: f.wrap(h)}})
+}
+
+// keyRoute retorna a rota {key} em que path cai.
+func (f *FastRouter) keyRoute(path string) (*fastKeyRoute, bool) {
+	for i := range f.keyed {
+		rest, ok := strings.CutPrefix(path, f.keyed[i].prefix)
+		if ok && rest != "" && rest != "." && rest != ".." && !strings.Contains(rest, "/") {
+			return &f.keyed[i], true
+		}
+	}
+	return nil, false
+}
+
+func (f *FastRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	var h http.Handler
+	if methods, ok := f.exact[path]; ok {
+		h = methods[req.Method]
+	} else if kr, ok := f.keyRoute(path); ok {
+		h = kr.methods[req.Method]
+	}
+	if h == nil {
+		f.fallback.ServeHTTP(w, req)
+		return
+	}
+	h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), fastRouterKey{}, f)))
+}
+
+// template é o template da rota rápida de path ("" se não houver).
+func (f *FastRouter) template(path string) string {
+	if _, ok := f.exact[path]; ok {
+		return path
+	}
+	if kr, ok := f.keyRoute(path); ok {
+		return kr.template
+	}
+	return ""
+}
+
+// pathKey é a variável {key} da rota, venha a requisição do mux ou do
+// FastRouter.
+func pathKey(req *http.Request) string {
+	if vars := mux.Vars(req); vars != nil {
+		return vars["key"]
+	}
+	if f := fastRouterFrom(req); f != nil {
+		if _, ok := f.keyRoute(req.URL.Path); ok {
+			return req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
+		}
+	}
+	return ""
+}
+
+// routeTemplate é o template da rota da requisição ("/v1/kv/{key}"), ou ""
+// se ela não casou com nenhuma.
+func routeTemplate(req *http.Request) string {
+	if cur := mux.CurrentRoute(req); cur != nil {
+		t, _ := cur.GetPathTemplate()
+		return t
+	}
+	if f := fastRouterFrom(req); f != nil {
+		return f.template(req.URL.Path)
+	}
+	return ""
+}
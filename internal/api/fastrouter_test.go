@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// routeEcho responde a rota e a chave que os handlers veriam.
+func routeEcho(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(w, "%s %s", routeTemplate(req), pathKey(req))
+}
+
+// hotRoutes monta as rotas quentes no mux, como o pkg/node (as de kv antes,
+// as de réplica depois, e o resto da API atrás), e as mesmas num FastRouter
+// na frente dele. O middleware lê a rota, como o das métricas por rota.
+func hotRoutes() (*mux.Router, *FastRouter) {
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_ = routeTemplate(req)
+			next.ServeHTTP(w, req)
+		})
+	}
+	r := mux.NewRouter()
+	r.Use(mw)
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		r.HandleFunc(APIVersion+"/kv/{key}", routeEcho).Methods(method)
+	}
+	r.HandleFunc("/internal/replica/put", routeEcho).Methods(http.MethodPost)
+	r.HandleFunc("/internal/replica/get", routeEcho).Methods(http.MethodGet)
+	for i := 0; i < 100; i++ {
+		r.HandleFunc(fmt.Sprintf("/admin/route%d/{id}", i), routeEcho).Methods(http.MethodGet)
+	}
+
+	fast := NewFastRouter(r, mw)
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		fast.HandleKey(method, APIVersion+"/kv/", http.HandlerFunc(routeEcho))
+	}
+	fast.Handle(http.MethodPost, "/internal/replica/put", http.HandlerFunc(routeEcho))
+	fast.Handle(http.MethodGet, "/internal/replica/get", http.HandlerFunc(routeEcho))
+	return r, fast
+}
+
+func TestFastRouterMatchesMux(t *testing.T) {
+	r, fast := hotRoutes()
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/v1/kv/user:42"},
+		{http.MethodDelete, "/v1/kv/user:42"},
+		{http.MethodPost, "/internal/replica/put"},
+		{http.MethodGet, "/admin/route7/x"},
+	} {
+		want := httptest.NewRecorder()
+		r.ServeHTTP(want, httptest.NewRequest(tc.method, tc.path, nil))
+		got := httptest.NewRecorder()
+		fast.ServeHTTP(got, httptest.NewRequest(tc.method, tc.path, nil))
+		if got.Body.String() != want.Body.String() {
+			t.Errorf("%s %s: fast router answered %q, mux %q", tc.method, tc.path, got.Body, want.Body)
+		}
+	}
+}
+
+// Dois nós no mesmo processo têm cada um o seu FastRouter: a rota de uma
+// requisição vem do que a atendeu, não do último criado.
+func TestFastRouterPerNode(t *testing.T) {
+	_, first := hotRoutes()
+	other := NewFastRouter(http.NotFoundHandler())
+	other.HandleKey(http.MethodGet, "/other/", http.HandlerFunc(routeEcho))
+
+	w := httptest.NewRecorder()
+	first.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/kv/user:42", nil))
+	if got, want := w.Body.String(), "/v1/kv/{key} user:42"; got != want {
+		t.Errorf("first router: %q, want %q", got, want)
+	}
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other/k1", nil))
+	if got, want := w.Body.String(), "/other/{key} k1"; got != want {
+		t.Errorf("second router: %q, want %q", got, want)
+	}
+}
+
+// fastRouterAllocs são as alocações do FastRouter por requisição numa rota
+// rápida: a cópia da requisição e o contexto com o roteador.
+const fastRouterAllocs = 2
+
+// TestFastRouterAllocs fixa as alocações do roteamento rápido, com um handler
+// e um middleware que leem a rota e a chave sem alocar.
+func TestFastRouterAllocs(t *testing.T) {
+	var template, key string
+	read := func(req *http.Request) {
+		template, key = routeTemplate(req), pathKey(req)
+	}
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			read(req)
+			next.ServeHTTP(w, req)
+		})
+	}
+	fast := NewFastRouter(http.NotFoundHandler(), mw)
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { read(req) })
+	fast.HandleKey(http.MethodGet, APIVersion+"/kv/", h)
+	fast.Handle(http.MethodPost, "/internal/replica/put", h)
+
+	w := &discardWriter{header: make(http.Header)}
+	for _, tc := range []struct{ method, path, template, key string }{
+		{http.MethodGet, "/v1/kv/user:42", "/v1/kv/{key}", "user:42"},
+		{http.MethodPost, "/internal/replica/put", "/internal/replica/put", ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		allocs := testing.AllocsPerRun(1000, func() { fast.ServeHTTP(w, req) })
+		if template != tc.template || key != tc.key {
+			t.Errorf("%s %s: route %q key %q, want %q %q", tc.method, tc.path, template, key, tc.template, tc.key)
+		}
+		if allocs > fastRouterAllocs {
+			t.Errorf("%s %s: %.0f allocs per request, want at most %d", tc.method, tc.path, allocs, fastRouterAllocs)
+		}
+	}
+}
+
+// BenchmarkRouter compara o mux e o FastRouter nas rotas quentes.
+func BenchmarkRouter(b *testing.B) {
+	r, fast := hotRoutes()
+	routers := []struct {
+		name string
+		h    http.Handler
+	}{{"mux", r}, {"fast", fast}}
+	for _, tc := range []struct{ name, method, path string }{
+		{"kv-get", http.MethodGet, "/v1/kv/user:42"},
+		{"kv-put", http.MethodPut, "/v1/kv/user:42"},
+		{"replica-put", http.MethodPost, "/internal/replica/put"},
+		{"replica-get", http.MethodGet, "/internal/replica/get?key=user:42"},
+	} {
+		for _, rt := range routers {
+			b.Run(tc.name+"/"+rt.name, func(b *testing.B) {
+				req := httptest.NewRequest(tc.method, tc.path, nil)
+				w := &discardWriter{header: make(http.Header)}
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					rt.h.ServeHTTP(w, req)
+				}
+				if !strings.HasPrefix(w.last, "/") {
+					b.Fatalf("%s %s: unexpected answer %q", tc.method, tc.path, w.last)
+				}
+			})
+		}
+	}
+}
+
+// discardWriter é um ResponseWriter que não aloca por resposta (o
+// httptest.ResponseRecorder alocaria e esconderia a diferença entre os
+// roteadores).
+type discardWriter struct {
+	header http.Header
+	last   string
+	buf    [64]byte
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+func (w *discardWriter) WriteHeader(int)     {}
+func (w *discardWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[:], p)
+	w.last = string(w.buf[:n])
+	return len(p), nil
+}
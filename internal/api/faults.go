@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// FaultConfig é a latência e a taxa de erros injetadas nas chamadas de
+// réplica que este nó recebe (FAULT_INJECTION), para testar timeouts,
+// hints e read repair com réplicas lentas ou instáveis.
+type FaultConfig struct {
+	Latency LatencyDist
+	// ErrorRate: fração das chamadas que falham (0..1) com ErrorStatus
+	ErrorRate   float64
+	ErrorStatus int
+	// PathPrefix: só as rotas com esse prefixo sofrem as falhas
+	PathPrefix string
+}
+
+// DefaultFaultPathPrefix são as rotas de réplica.
+const DefaultFaultPathPrefix = "/internal/replica/"
+
+// LatencyDist é uma distribuição de latência:
+//
+//	50ms ou fixed:50ms      sempre 50ms
+//	uniform:10ms-200ms      uniforme entre 10ms e 200ms
+//	normal:50ms,20ms        normal com média 50ms e desvio 20ms (>= 0)
+//	exp:30ms                exponencial com média 30ms (cauda longa)
+type LatencyDist struct {
+	Kind string
+	A, B time.Duration
+}
+
+// ParseLatencyDist lê a distribuição de FAULT_LATENCY ("" = sem latência).
+func ParseLatencyDist(spec string) (LatencyDist, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return LatencyDist{}, nil
+	}
+	kind, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, args = "fixed", spec
+	}
+	parse := func(sep string) (time.Duration, time.Duration, error) {
+		a, b, ok := strings.Cut(args, sep)
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid %s latency %q (want two durations separated by %q)", kind, args, sep)
+		}
+		da, err := time.ParseDuration(strings.TrimSpace(a))
+		if err != nil {
+			return 0, 0, err
+		}
+		db, err := time.ParseDuration(strings.TrimSpace(b))
+		return da, db, err
+	}
+	d := LatencyDist{Kind: kind}
+	var err error
+	switch kind {
+	case "fixed", "exp":
+		d.A, err = time.ParseDuration(strings.TrimSpace(args))
+	case "uniform":
+		d.A, d.B, err = parse("-")
+		if err == nil && d.B < d.A {
+			err = fmt.Errorf("invalid uniform latency %q: max below min", args)
+		}
+	case "normal":
+		d.A, d.B, err = parse(",")
+	default:
+		return LatencyDist{}, fmt.Errorf("unknown latency distribution %q (want fixed, uniform, normal or exp)", kind)
+	}
+	if err != nil {
+		return LatencyDist{}, err
+	}
+	if d.A < 0 || d.B < 0 {
+		return LatencyDist{}, fmt.Errorf("negative latency in %q", spec)
+	}
+	return d, nil
+}
+
+// Sample sorteia uma latência da distribuição.
+func (d LatencyDist) Sample() time.Duration {
+	var v float64
+	switch d.Kind {
+	case "fixed":
+		return d.A
+	case "uniform":
+		v = float64(d.A) + rand.Float64()*float64(d.B-d.A)
+	case "normal":
+		v = float64(d.A) + rand.NormFloat64()*float64(d.B)
+	case "exp":
+		v = rand.ExpFloat64() * float64(d.A)
+	}
+	if v < 0 {
+		return 0
+	}
+	return time.Duration(v)
+}
+
+func (d LatencyDist) String() string {
+	switch d.Kind {
+	case "":
+		return "none"
+	case "uniform":
+		return fmt.Sprintf("uniform:%s-%s", d.A, d.B)
+	case "normal":
+		return fmt.Sprintf("normal:%s,%s", d.A, d.B)
+	default:
+		return fmt.Sprintf("%s:%s", d.Kind, d.A)
+	}
+}
+
+// FaultMiddleware atrasa as requisições de cfg.PathPrefix por uma latência
+// sorteada de cfg.Latency e faz uma fração cfg.ErrorRate delas falhar. A
+// espera termina antes se quem chamou desistir (o contexto da requisição é
+// cancelado quando a conexão fecha), como numa réplica lenta de verdade.
+func FaultMiddleware(cfg FaultConfig) func(http.Handler) http.Handler {
+	if cfg.PathPrefix == "" {
+		cfg.PathPrefix = DefaultFaultPathPrefix
+	}
+	if cfg.ErrorStatus == 0 {
+		cfg.ErrorStatus = http.StatusServiceUnavailable
+	}
+	log.Printf("[FAULTS] injecting latency=%s error_rate=%.3f (status %d) on %s*", cfg.Latency, cfg.ErrorRate, cfg.ErrorStatus, cfg.PathPrefix)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !strings.HasPrefix(req.URL.Path, cfg.PathPrefix) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if cfg.Latency.Kind != "" {
+				delay := cfg.Latency.Sample()
+				metrics.Inc("faults.delayed")
+				t := time.NewTimer(delay)
+				select {
+				case <-t.C:
+				case <-req.Context().Done():
+					t.Stop()
+					return
+				}
+			}
+			if cfg.ErrorRate > 0 && rand.Float64() < cfg.ErrorRate {
+				metrics.Inc("faults.errors")
+				http.Error(w, "injected fault", cfg.ErrorStatus)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
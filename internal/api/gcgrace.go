@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+)
+
+type keyspaceGCGrace struct {
+	Keyspace       string `json:"keyspace"`
+	GCGraceSeconds int64  `json:"gc_grace_seconds"`
+	Tombstones     int    `json:"tombstones"`
+}
+
+type gcGraceResponse struct {
+	DefaultSeconds int64             `json:"default_gc_grace_seconds"`
+	Keyspaces      []keyspaceGCGrace `json:"keyspaces"`
+	// nós fora do ar há mais que o menor gc_grace (segundo as filas de hints)
+	NodesPastGrace []string `json:"nodes_past_grace,omitempty"`
+}
+
+// HandleGCGrace: GET /admin/gc-grace
+// gc_grace_seconds efetivo de cada keyspace (configurados e com dados), com
+// quantos tombstones este nó guarda em cada um.
+func HandleGCGrace(r *cluster.Router, store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		g := store.GCGrace()
+		tombstones := store.TombstonesByKeyspace()
+
+		seen := make(map[string]bool)
+		for _, ks := range store.Keyspaces() {
+			seen[ks] = true
+		}
+		for ks := range g.Keyspaces {
+			seen[ks] = true
+		}
+		names := make([]string, 0, len(seen))
+		for ks := range seen {
+			names = append(names, ks)
+		}
+		sort.Strings(names)
+
+		out := gcGraceResponse{DefaultSeconds: int64(g.Default.Seconds())}
+		for _, ks := range names {
+			out.Keyspaces = append(out.Keyspaces, keyspaceGCGrace{
+				Keyspace:       ks,
+				GCGraceSeconds: int64(g.For(ks).Seconds()),
+				Tombstones:     tombstones[ks],
+			})
+		}
+		for _, h := range r.HintStatus() {
+			if h.ExceedsGCGrace {
+				out.NodesPastGrace = append(out.NodesPastGrace, string(h.Node))
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
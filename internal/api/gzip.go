@@ -0,0 +1,159 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultGzipMinBytes é o tamanho mínimo de resposta comprimida: abaixo
+// disso o gzip custa mais CPU do que economiza de banda.
+const DefaultGzipMinBytes = 1024
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// GzipMiddleware comprime com gzip as respostas das rotas de cliente
+// (tudo menos /internal/*) quando o cliente manda Accept-Encoding: gzip e o
+// corpo passa de minBytes. A resposta fica em buffer até chegar a minBytes;
+// respostas em streaming que dão Flush antes disso saem sem compressão.
+// minBytes <= 0 desliga a compressão.
+func GzipMiddleware(minBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if minBytes <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasPrefix(req.URL.Path, "/internal/") || req.Method == http.MethodHead {
+				next.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			gw := &gzipResponseWriter{ResponseWriter: w, min: minBytes}
+			defer gw.close()
+			next.ServeHTTP(gw, req)
+		})
+	}
+}
+
+// acceptsGzip interpreta o Accept-Encoding (gzip ou *, sem q=0).
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	min     int
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if !g.decided {
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < g.min {
+			return len(p), nil
+		}
+		if err := g.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// start envia o header (com ou sem Content-Encoding) e o que estava em buffer.
+func (g *gzipResponseWriter) start(compress bool) error {
+	g.decided = true
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		// o net/http detectaria o tipo pelos bytes já comprimidos
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush implementa http.Flusher (respostas em streaming).
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		if g.status == 0 {
+			g.status = http.StatusOK
+		}
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap deixa o http.ResponseController chegar ao writer original.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		if g.status == 0 {
+			return
+		}
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+		g.gz = nil
+	}
+}
@@ -1,113 +1,816 @@
 package api
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"mini-cassandra/internal/bufpool"
 	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
 	"mini-cassandra/internal/kv"
-
-	"github.com/gorilla/mux"
+	"mini-cassandra/internal/metrics"
 )
 
-func HandlePutDistributed(r *cluster.Router) http.HandlerFunc {
+func HandlePutDistributed(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		key := mux.Vars(req)["key"]
-		body, _ := io.ReadAll(req.Body)
-		value := string(body)
+		key := pathKey(req)
+		if err := limits.checkKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		value, ok := readValue(w, req, limits.MaxValueBytes, "value")
+		if !ok {
+			return
+		}
+		if err := r.CheckContentType(key, req.Header.Get("Content-Type")); err != nil {
+			writeError(w, err, http.StatusUnsupportedMediaType)
+			return
+		}
+		meta, err := requestMeta(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cl, err := parseConsistency(w, req, r.WriteConsistencyFor(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl, err := parseTTL(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
 
 		log.Printf("[API] PUT key=%s", key)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.put", time.Now())
 
-		if err := r.Put(key, value); err != nil {
+		if err := r.PutWith(ctx, key, kv.WithMeta(value, meta), cl, ttl); err != nil {
+			metrics.Inc("client.put.errors")
 			log.Printf("[ERROR] PUT key=%s err=%v", key, err)
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			writeError(w, err, writeErrorStatus(err))
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		writeAck(ctx, w)
+	}
+}
+
+// writeAck responde uma escrita aceita com o relatório de confirmações das
+// réplicas (cluster.WriteAck), ou "OK" se a requisição não passou por
+// réplicas.
+func writeAck(ctx context.Context, w http.ResponseWriter) {
+	if ack, ok := cluster.OutcomeFrom(ctx).WriteAck(); ok {
+		writeJSON(w, http.StatusOK, ack)
+		return
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
 }
 
-func HandleGetDistributed(r *cluster.Router) http.HandlerFunc {
+func HandleGetDistributed(r *cluster.Router, hot *hotkeys.Tracker) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		key := mux.Vars(req)["key"]
+		key := pathKey(req)
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		cl, err := parseConsistency(w, req, r.ReadConsistencyFor(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		log.Printf("[API] GET key=%s", key)
+		hot.Record(key, hotkeys.Read)
+		defer metrics.Since("client.get", time.Now())
 
-		value, ok, err := r.Get(key)
+		e, ok, err := r.GetWith(ctx, key, cl)
 		if err != nil {
+			metrics.Inc("client.get.errors")
 			log.Printf("[ERROR] GET key=%s err=%v", key, err)
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			writeError(w, err, readErrorStatus(err))
 			return
 		}
 		if !ok {
+			metrics.Inc("client.get.not_found")
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 
+		// ETag pela versão (timestamp da escrita): clientes que fazem polling
+		// mandam If-None-Match e recebem 304 sem baixar o valor de novo
+		if e.Timestamp > 0 {
+			etag := versionETag(e.Timestamp)
+			w.Header().Set("ETag", etag)
+			if etagMatches(req.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		if e.Timestamp > 0 {
+			w.Header().Set(cluster.TimestampHeader, strconv.FormatInt(e.Timestamp, 10))
+		}
+		if e.ExpiresAt > 0 {
+			w.Header().Set(cluster.ExpiresAtHeader, strconv.FormatInt(e.ExpiresAt, 10))
+		}
+		value, meta := kv.SplitMeta(e.Value)
+		metrics.Add("client.bytes_out", int64(len(value)))
+		if req.URL.Query().Get("envelope") == "true" {
+			writeJSON(w, http.StatusOK, getEnvelope{
+				Key:         key,
+				Value:       value,
+				ContentType: meta.ContentType,
+				Metadata:    meta.Headers,
+				Timestamp:   e.Timestamp,
+				ExpiresAt:   e.ExpiresAt,
+				Coordinator: string(r.NodeID()),
+				Provenance:  cluster.OutcomeFrom(req.Context()).Provenance(),
+			})
+			return
+		}
+		writeMetaHeaders(w.Header(), meta)
+		writeValue(w, value)
+	}
+}
+
+// getEnvelope é a resposta do GET com ?envelope=true: o valor junto com a
+// versão e a réplica que o serviu, para investigar leituras desatualizadas
+// sem cruzar os logs dos nós.
+type getEnvelope struct {
+	Key         string            `json:"key"`
+	Value       string            `json:"value"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Timestamp   int64             `json:"timestamp"`
+	ExpiresAt   int64             `json:"expires_at,omitempty"`
+	Coordinator string            `json:"coordinator"`
+	cluster.Provenance
+}
+
+// versionETag monta o ETag de uma versão a partir do timestamp da escrita.
+func versionETag(ts int64) string {
+	return `"` + strconv.FormatInt(ts, 36) + `"`
+}
+
+// parseETag é o inverso de versionETag (aceita o prefixo fraco W/).
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(tag[1:len(tag)-1], 36, 64)
+	return ts, err == nil && ts > 0
+}
+
+// deleteIfMatch é o DELETE com If-Match: a chave só é apagada se a versão
+// atual for a do ETag ("*" = qualquer versão, desde que exista), como
+// operação condicional; senão responde 412 com o ETag atual.
+func deleteIfMatch(ctx context.Context, w http.ResponseWriter, r *cluster.Router, key string, cl cluster.Consistency, ifMatch string) {
+	var version int64
+	anyVersion := strings.TrimSpace(ifMatch) == "*"
+	if !anyVersion {
+		var ok bool
+		if version, ok = parseETag(ifMatch); !ok {
+			http.Error(w, "invalid If-Match (use an ETag returned by GET, or *)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cur, err := r.DeleteIf(ctx, key, cl, version, anyVersion)
+	if errors.Is(err, cluster.ErrPreconditionFailed) {
+		if cur.Found && cur.Entry.Timestamp > 0 {
+			w.Header().Set("ETag", versionETag(cur.Entry.Timestamp))
+		}
+		http.Error(w, "precondition failed: current version does not match If-Match", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		metrics.Inc("client.delete.errors")
+		log.Printf("[ERROR] DELETE key=%s If-Match=%s err=%v", key, ifMatch, err)
+		writeError(w, err, writeErrorStatus(err))
+		return
+	}
+	writeAck(ctx, w)
+}
+
+// etagMatches interpreta o If-None-Match (lista de ETags ou "*"); a
+// comparação é fraca, como manda a RFC 9110 para o If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleHeadDistributed: HEAD /kv/{key}
+// Existência da chave (200/404) com o tamanho do valor em Content-Length e
+// X-Value-Length, a versão em X-Timestamp e ETag e os metadados (Content-Type
+// e X-Meta-*), sem transferir o valor (as réplicas respondem com digest
+// reads).
+func HandleHeadDistributed(r *cluster.Router, hot *hotkeys.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		cl, err := parseConsistency(w, req, r.ReadConsistencyFor(key))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		hot.Record(key, hotkeys.Read)
+		defer metrics.Since("client.head", time.Now())
+
+		d, ok, err := r.GetDigest(ctx, key, cl)
+		if err != nil {
+			metrics.Inc("client.head.errors")
+			log.Printf("[ERROR] HEAD key=%s err=%v", key, err)
+			w.WriteHeader(readErrorStatus(err))
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		h := w.Header()
+		writeMetaHeaders(h, d.Meta)
+		h.Set("Content-Length", strconv.Itoa(d.Length))
+		h.Set(cluster.ValueLengthHeader, strconv.Itoa(d.Length))
+		if d.Timestamp > 0 {
+			h.Set(cluster.TimestampHeader, strconv.FormatInt(d.Timestamp, 10))
+			h.Set("ETag", versionETag(d.Timestamp))
+		}
+		if d.ExpiresAt > 0 {
+			h.Set(cluster.ExpiresAtHeader, strconv.FormatInt(d.ExpiresAt, 10))
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(value))
 	}
 }
 
-func HandleDeleteDistributed(r *cluster.Router) http.HandlerFunc {
+func HandleDeleteDistributed(r *cluster.Router, hot *hotkeys.Tracker) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		key := mux.Vars(req)["key"]
+		key := pathKey(req)
+
+		cl, err := parseConsistency(w, req, r.WriteConsistencyFor(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
 
 		log.Printf("[API] DELETE key=%s", key)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.delete", time.Now())
+
+		if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+			deleteIfMatch(ctx, w, r, key, cl, ifMatch)
+			return
+		}
 
-		if err := r.Delete(key); err != nil {
+		existed, err := r.DeleteWith(ctx, key, cl)
+		if err != nil {
+			metrics.Inc("client.delete.errors")
 			log.Printf("[ERROR] DELETE key=%s err=%v", key, err)
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		// o tombstone foi gravado mesmo assim; o 404 só diz que nenhuma das
+		// réplicas que confirmaram tinha a chave
+		if !existed {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		writeAck(ctx, w)
+	}
+}
+
+// HandleInternalCAS: POST /internal/cas
+// Executa uma operação condicional encaminhada por outro coordenador (este
+// nó é o dono da chave). Responde o CASResult; conflito vira 409 e
+// pré-condição falha vira 412 (com a versão atual).
+func HandleInternalCAS(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var in cluster.CASRequest
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		res, err := r.ApplyCAS(req.Context(), in)
+		if errors.Is(err, cluster.ErrPreconditionFailed) {
+			writeJSON(w, http.StatusPreconditionFailed, res)
+			return
+		}
+		if err != nil {
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleGetSet: POST /kv/{key}/getset
+// Grava o corpo como novo valor (com os metadados, como no PUT) e responde
+// o valor anterior (200, com o ETag e os metadados da versão anterior) ou
+// 204 se a chave não existia, atomicamente: nenhuma escrita concorrente fica
+// entre a leitura e a gravação.
+func HandleGetSet(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		if err := limits.checkKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		value, ok := readValue(w, req, limits.MaxValueBytes, "value")
+		if !ok {
+			return
+		}
+		meta, err := requestMeta(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cl, err := parseConsistency(w, req, cluster.Quorum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		ttl, err := parseTTL(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] GETSET key=%s", key)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.getset", time.Now())
 
+		prev, err := r.GetSet(ctx, key, kv.WithMeta(value, meta), cl, ttl)
+		if err != nil {
+			metrics.Inc("client.getset.errors")
+			log.Printf("[ERROR] GETSET key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		if !prev.Found {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if prev.Entry.Timestamp > 0 {
+			w.Header().Set("ETag", versionETag(prev.Entry.Timestamp))
+		}
+		value, meta = kv.SplitMeta(prev.Entry.Value)
+		metrics.Add("client.bytes_out", int64(len(value)))
+		writeMetaHeaders(w.Header(), meta)
+		writeValue(w, value)
+	}
+}
+
+// HandleIncr: POST /kv/{key}/incr?by=N
+// Soma N (padrão 1, pode ser negativo) ao valor inteiro da chave (sem a
+// chave, parte de 0), atomicamente, e responde o valor novo. Um valor que não
+// é inteiro dá 422.
+func HandleIncr(r *cluster.Router, hot *hotkeys.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		by := int64(1)
+		if v := req.URL.Query().Get("by"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid by (integer)", http.StatusBadRequest)
+				return
+			}
+			by = n
+		}
+		cl, err := parseConsistency(w, req, cluster.Quorum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] INCR key=%s by=%d", key, by)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.incr", time.Now())
+
+		n, err := r.Increment(ctx, key, by, cl)
+		if err != nil {
+			metrics.Inc("client.incr.errors")
+			log.Printf("[ERROR] INCR key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		w.Write([]byte(strconv.FormatInt(n, 10)))
 	}
 }
 
-func HandleDebugKeys(store *kv.Store) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		keys := store.Keys()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(keys)
+// HandleExpire: POST /kv/{key}/expire?ttl=N e POST /kv/{key}/persist
+// Troca o TTL de uma chave existente (persist tira o TTL) sem reenviar o
+// valor. Responde 204 com o ETag da versão nova, ou 404 se a chave não
+// existe. persist=true é a rota /persist.
+func HandleExpire(r *cluster.Router, hot *hotkeys.Tracker, persist bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		var ttl time.Duration
+		if !persist {
+			var err error
+			if ttl, err = parseTTL(req); err != nil || ttl == 0 {
+				http.Error(w, "ttl must be a positive number of seconds (use /persist to remove it)", http.StatusBadRequest)
+				return
+			}
+		}
+		cl, err := parseConsistency(w, req, cluster.Quorum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] EXPIRE key=%s ttl=%s", key, ttl)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.expire", time.Now())
+
+		ts, err := r.Expire(ctx, key, ttl, cl)
+		if errors.Is(err, cluster.ErrPreconditionFailed) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			metrics.Inc("client.expire.errors")
+			log.Printf("[ERROR] EXPIRE key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		w.Header().Set("ETag", versionETag(ts))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleRename: POST /kv/{key}/rename?to=novachave[&overwrite=true]
+// Move o valor (com o TTL que resta) para a chave nova e apaga a antiga.
+// Responde 204 com o ETag da chave nova; 404 se a origem não existe, 409 se
+// o destino já existe (sem overwrite) ou se a origem mudou no meio (o destino
+// é desfeito).
+func HandleRename(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleKeyTransfer("RENAME", r.Rename, r, hot, limits)
+}
+
+// HandleCopy: POST /kv/{key}/copy?to=outra[&overwrite=true]
+// Copia o valor (com o TTL que resta) para outra chave sem passar pelo
+// cliente. Mesmas respostas do rename.
+func HandleCopy(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return handleKeyTransfer("COPY", r.Copy, r, hot, limits)
+}
+
+// handleKeyTransfer é o handler comum de rename e copy: op recebe a chave
+// da URL, a de ?to= e o overwrite, e retorna a versão gravada no destino.
+func handleKeyTransfer(name string, op func(ctx context.Context, from, to string, cl cluster.Consistency, overwrite bool) (int64, error), r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	metric := "client." + strings.ToLower(name)
+	return func(w http.ResponseWriter, req *http.Request) {
+		from := pathKey(req)
+		to := req.URL.Query().Get("to")
+		if to == "" {
+			http.Error(w, "missing to", http.StatusBadRequest)
+			return
+		}
+		if err := limits.checkKey(to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to == from {
+			http.Error(w, "to must be a different key", http.StatusBadRequest)
+			return
+		}
+		overwrite := req.URL.Query().Get("overwrite") == "true"
+		cl, err := parseConsistency(w, req, cluster.Quorum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] %s key=%s to=%s overwrite=%v", name, from, to, overwrite)
+		hot.Record(from, hotkeys.Read)
+		hot.Record(to, hotkeys.Write)
+		defer metrics.Since(metric, time.Now())
+
+		ts, err := op(ctx, from, to, cl, overwrite)
+		if err != nil {
+			metrics.Inc(metric + ".errors")
+			log.Printf("[ERROR] %s key=%s to=%s err=%v", name, from, to, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		w.Header().Set("ETag", versionETag(ts))
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-type replicaPutReq struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+// ConsistencyHeader escolhe o nível de consistência de uma operação
+// (ONE, QUORUM, LOCAL_QUORUM ou ALL); a resposta traz o nível usado no
+// mesmo header.
+const ConsistencyHeader = "X-Consistency"
+
+// parseConsistency lê o nível do header X-Consistency ou de ?consistency=
+// (padrão: def, a consistência configurada no nó ou no keyspace da chave) e
+// o ecoa na resposta.
+func parseConsistency(w http.ResponseWriter, req *http.Request, def cluster.Consistency) (cluster.Consistency, error) {
+	cl, err := requestedConsistency(req)
+	if err != nil {
+		return "", err
+	}
+	if cl == "" {
+		cl = def
+	}
+	w.Header().Set(ConsistencyHeader, string(cl))
+	return cl, nil
+}
+
+// requestedConsistency é o nível pedido em X-Consistency ou ?consistency=
+// ("" se a requisição não pediu nenhum).
+func requestedConsistency(req *http.Request) (cluster.Consistency, error) {
+	v := req.Header.Get(ConsistencyHeader)
+	if v == "" {
+		v = req.URL.Query().Get("consistency")
+	}
+	if v == "" {
+		return "", nil
+	}
+	return cluster.ParseConsistency(v)
+}
+
+// HandleConsistency: GET /admin/consistency
+// Os níveis padrão de leitura e escrita do nó e os de cada keyspace
+// (READ_CONSISTENCY_BY_KEYSPACE, WRITE_CONSISTENCY_BY_KEYSPACE).
+func HandleConsistency(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.ConsistencyDefaults())
+	}
+}
+
+// parseTTL lê ?ttl= em segundos (0 ou ausente = a chave não expira).
+func parseTTL(req *http.Request) (time.Duration, error) {
+	v := req.URL.Query().Get("ttl")
+	if v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid ttl %q (seconds)", v)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// writeErrorStatus escolhe o status HTTP de uma escrita que falhou.
+func writeErrorStatus(err error) int {
+	if errors.Is(err, cluster.ErrDraining) || errors.Is(err, cluster.ErrReadOnly) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, cluster.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, cluster.ErrCASConflict) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, cluster.ErrPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	}
+	if errors.Is(err, cluster.ErrNotInteger) || errors.Is(err, cluster.ErrNotJSON) || errors.Is(err, kv.ErrNotCRDT) || errors.Is(err, cluster.ErrSchemaViolation) || errors.Is(err, cluster.ErrLargeObjectTTL) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, cluster.ErrSourceNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, cluster.ErrDestinationExists) || errors.Is(err, cluster.ErrSourceChanged) {
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+// schemaFailure é o corpo de uma escrita recusada pelo schema do keyspace
+// (422), com as violações encontradas.
+type schemaFailure struct {
+	Error string `json:"error"`
+	*cluster.SchemaError
+}
+
+// consistencyFailure é o corpo de uma leitura ou escrita que não atingiu o
+// nível de consistência: Error é unavailable (réplicas vivas insuficientes,
+// 503), timeout (504) ou replica_failure (réplicas vivas que responderam com
+// erro, 502).
+type consistencyFailure struct {
+	Error       string `json:"error"`
+	Operation   string `json:"operation"`
+	Consistency string `json:"consistency"`
+	Required    int    `json:"required"`
+	Alive       int    `json:"alive"`
+	// Received: confirmações (escrita) ou respostas (leitura) que contaram
+	Received int      `json:"received"`
+	Failures []string `json:"failures"`
 }
 
-type replicaDeleteReq struct {
-	Key string `json:"key"`
+// writeError responde err com status: em JSON (consistencyFailure) se for
+// um nível de consistência não atingido, em texto nos outros casos.
+func writeError(w http.ResponseWriter, err error, status int) {
+	var f consistencyFailure
+	var we *cluster.WriteError
+	var re *cluster.ReadError
+	var se *cluster.SchemaError
+	var errs []error
+	switch {
+	case errors.As(err, &se):
+		writeJSON(w, status, schemaFailure{Error: "schema_violation", SchemaError: se})
+		return
+	case errors.As(err, &we):
+		f = consistencyFailure{Operation: "write", Consistency: string(we.Consistency), Required: we.Required, Alive: we.Alive, Received: we.Acks}
+		errs = we.Errs
+	case errors.As(err, &re):
+		f = consistencyFailure{Operation: "read", Consistency: string(re.Consistency), Required: re.Required, Alive: re.Alive, Received: re.Responses}
+		errs = re.Errs
+	default:
+		http.Error(w, err.Error(), status)
+		return
+	}
+	switch status {
+	case http.StatusServiceUnavailable:
+		f.Error = "unavailable"
+	case http.StatusGatewayTimeout:
+		f.Error = "timeout"
+	default:
+		f.Error = "replica_failure"
+	}
+	f.Failures = make([]string, len(errs))
+	for i, e := range errs {
+		f.Failures[i] = e.Error()
+	}
+	writeJSON(w, status, f)
+}
+
+// Paginação de /debug/keys.
+const (
+	defaultDebugKeysLimit = 1000
+	maxDebugKeysLimit     = 10000
+)
+
+type debugKeysResponse struct {
+	Keys       any    `json:"keys"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-func HandleReplicaPut(store *kv.Store) http.HandlerFunc {
+// HandleDebugKeys: GET /debug/keys?limit=N&cursor=...&prefix=...&details=true
+// Chaves locais em ordem, uma página por vez (limit, padrão 1000, máx 10000).
+// next_cursor vem quando há mais chaves e vai no cursor da próxima chamada.
+// Com details=true cada chave vem com tamanho, timestamp e expires_at.
+// match=<regex> e where=campo<op>valor (repetível) filtram no nó, pela chave
+// e por campos do valor JSON, antes de a página ser montada.
+func HandleDebugKeys(store *kv.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req replicaPutReq
-		body, _ := io.ReadAll(r.Body)
+		q := r.URL.Query()
+		limit := defaultDebugKeysLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxDebugKeysLimit {
+				http.Error(w, fmt.Sprintf("invalid limit (1..%d)", maxDebugKeysLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		after := ""
+		if v := q.Get("cursor"); v != "" {
+			b, err := base64.RawURLEncoding.DecodeString(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			after = string(b)
+		}
 
-		if err := json.Unmarshal(body, &req); err != nil {
-			http.Error(w, "invalid json", http.StatusBadRequest)
+		filter, err := kv.ParseFilter(q.Get("match"), q["where"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, more := store.ScanKeysMatching(q.Get("prefix"), after, limit, filter)
+		var out debugKeysResponse
+		if q.Get("details") == "true" {
+			out.Keys = page
+		} else {
+			keys := make([]string, len(page))
+			for i, k := range page {
+				keys[i] = k.Key
+			}
+			out.Keys = keys
+		}
+		if more {
+			out.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].Key))
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+func HandleReplicaPut(store *kv.Store, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readPooledBody(w, r, limits.replicaBodyMax(), "replica put")
+		if !ok {
+			return
+		}
+		// JSON ou o codec combinado no handshake (Content-Type)
+		codec := cluster.CodecFor(r.Header.Get("Content-Type"))
+		req, err := codec.DecodeMutation(body.Bytes(), kv.OpPut)
+		bufpool.Put(body)
+		if err != nil {
+			http.Error(w, "invalid "+codec.Name()+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := limits.checkKey(req.Key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := limits.checkValue(len(kv.ValueBody(req.Value))); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
 			return
 		}
 
 		// 🔥 Log importantíssimo
-		log.Printf("[REPLICA] PUT key=%s value=%s", req.Key, req.Value)
+		log.Printf("[REPLICA] PUT key=%s bytes=%d", req.Key, len(req.Value))
+		hot.Record(req.Key, hotkeys.Write)
+		metrics.Inc("replica.put")
 
-		store.Put(req.Key, req.Value)
+		ts := req.Timestamp
+		if ts <= 0 {
+			ts = kv.Now()
+		}
+		req.Timestamp = ts
+		store.Apply(req)
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}
 }
 
-func HandleReplicaGet(store *kv.Store) http.HandlerFunc {
+func HandleReplicaGet(router *cluster.Router, store *kv.Store, hot *hotkeys.Tracker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
 		if key == "" {
@@ -117,33 +820,155 @@ func HandleReplicaGet(store *kv.Store) http.HandlerFunc {
 
 		// 🔥 Log do GET interno
 		log.Printf("[REPLICA] GET key=%s", key)
+		hot.Record(key, hotkeys.Read)
+		metrics.Inc("replica.get")
 
-		val, ok := store.Get(key)
-		if !ok {
+		e, ok, err := store.CheckedVersion(key)
+		if err != nil {
+			// o coordenador usa outra réplica; esta se repara em background
+			go router.RecoverCorrupt(context.Background(), key)
+			w.Header().Set(cluster.CorruptHeader, "true")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok || e.Deleted {
+			if ok {
+				w.Header().Set(cluster.TombstoneHeader, strconv.FormatInt(e.Timestamp, 10))
+			}
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(val))
+		w.Header().Set(cluster.TimestampHeader, strconv.FormatInt(e.Timestamp, 10))
+		if e.ExpiresAt > 0 {
+			w.Header().Set(cluster.ExpiresAtHeader, strconv.FormatInt(e.ExpiresAt, 10))
+		}
+		if r.URL.Query().Get("digest") == "true" {
+			// digest read: só a versão, o tamanho e os metadados, sem o valor
+			w.Header().Set(cluster.ValueLengthHeader, strconv.Itoa(cluster.ValueLength(e.Value)))
+			if _, meta := kv.SplitMeta(e.Value); !meta.Empty() {
+				b, _ := json.Marshal(meta)
+				w.Header().Set(cluster.MetaHeader, string(b))
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		metrics.Add("replica.bytes_out", int64(len(e.Value)))
+		writeValue(w, e.Value)
 	}
 }
 
-func HandleReplicaDelete(store *kv.Store) http.HandlerFunc {
+// HandleReplicaBatch: POST /internal/replica/batch
+// Corpo: lista de mutações (put/delete) com os timestamps do coordenador;
+// aplica cada uma com last-write-wins e responde quantas foram aplicadas e
+// o erro das recusadas (pelo índice no lote).
+func HandleReplicaBatch(store *kv.Store, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req replicaDeleteReq
-		body, _ := io.ReadAll(r.Body)
+		max := limits.replicaBodyMax()
+		if max > 0 {
+			max += 6 * cluster.ReplicaBatchMaxBytes
+		}
+		body, ok := readPooledBody(w, r, max, "replica batch")
+		if !ok {
+			return
+		}
+		var ms []kv.Mutation
+		err := json.Unmarshal(body.Bytes(), &ms)
+		bufpool.Put(body)
+		if err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		metrics.Add("replica.batch_mutations", int64(len(ms)))
+
+		var out cluster.ReplicaBatchResult
+		for i, m := range ms {
+			if err := errors.Join(limits.checkKey(m.Key), limits.checkValue(len(kv.ValueBody(m.Value)))); err != nil {
+				out.Errors = append(out.Errors, cluster.ReplicaBatchError{Index: i, Error: err.Error()})
+				continue
+			}
+			if m.Op != kv.OpPut && m.Op != kv.OpDelete {
+				out.Errors = append(out.Errors, cluster.ReplicaBatchError{Index: i, Error: fmt.Sprintf("unsupported op %q", m.Op)})
+				continue
+			}
+			if m.Timestamp <= 0 {
+				m.Timestamp = kv.Now()
+			}
+			hot.Record(m.Key, hotkeys.Write)
+			// descartada por ser mais antiga também conta como aplicada
+			store.Apply(m)
+			out.Applied++
+		}
+		log.Printf("[REPLICA] BATCH mutations=%d applied=%d", len(ms), out.Applied)
+		writeJSON(w, http.StatusOK, out)
+	}
+}
 
-		if err := json.Unmarshal(body, &req); err != nil {
+// HandleReplicaCAS: POST /internal/replica/cas
+// Aplica a mutação só se a versão local da chave não for mais nova que a
+// esperada pelo coordenador (atômico no store).
+func HandleReplicaCAS(store *kv.Store, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readPooledBody(w, r, limits.replicaBodyMax(), "replica cas")
+		if !ok {
+			return
+		}
+		var req cluster.ReplicaCASRequest
+		err := json.Unmarshal(body.Bytes(), &req)
+		bufpool.Put(body)
+		if err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
+		m := req.Mutation
+		if err := errors.Join(limits.checkKey(m.Key), limits.checkValue(len(kv.ValueBody(m.Value)))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.Op != kv.OpPut && m.Op != kv.OpDelete {
+			http.Error(w, fmt.Sprintf("unsupported op %q", m.Op), http.StatusBadRequest)
+			return
+		}
+
+		hot.Record(m.Key, hotkeys.Write)
+		metrics.Inc("replica.cas")
+		applied, cur := store.ApplyIf(m, req.Expected)
+		log.Printf("[REPLICA] CAS %s key=%s expected=%d applied=%v", m.Op, m.Key, req.Expected, applied)
+		writeJSON(w, http.StatusOK, cluster.ReplicaCASResult{Applied: applied, Timestamp: cur.Timestamp})
+	}
+}
+
+func HandleReplicaDelete(store *kv.Store, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readPooledBody(w, r, limits.replicaBodyMax(), "replica delete")
+		if !ok {
+			return
+		}
+		codec := cluster.CodecFor(r.Header.Get("Content-Type"))
+		req, err := codec.DecodeMutation(body.Bytes(), kv.OpDelete)
+		bufpool.Put(body)
+		if err != nil {
+			http.Error(w, "invalid "+codec.Name()+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := limits.checkKey(req.Key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// 🔥 Log do DELETE interno
 		log.Printf("[REPLICA] DELETE key=%s", req.Key)
+		hot.Record(req.Key, hotkeys.Write)
+		metrics.Inc("replica.delete")
 
-		store.Delete(req.Key)
+		if req.Timestamp <= 0 {
+			req.Timestamp = kv.Now()
+		}
+		// existed sai da própria aplicação: um delete que perdeu para uma
+		// escrita mais nova não apagou nada
+		_, existed := store.ApplyDelete(req)
 
+		w.Header().Set(cluster.ExistedHeader, strconv.FormatBool(existed))
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}
@@ -152,3 +977,15 @@ func HandleReplicaDelete(store *kv.Store) http.HandlerFunc {
 func NotImplemented(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "not implemented", http.StatusNotImplemented)
 }
+
+// writeJSON codifica v num buffer do pool e responde com ele de uma vez
+// (com Content-Length).
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	json.NewEncoder(buf).Encode(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
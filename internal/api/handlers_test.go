@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/kv"
+)
+
+func TestMain(m *testing.M) {
+	// os handlers logam cada requisição
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// singleNode é um router de um nó só (RF 1): a escrita é aplicada no store
+// local, sem chamadas entre nós.
+func singleNode() *cluster.Router {
+	ring := hashring.New([]hashring.NodeInfo{{ID: "node1", Host: "node1"}})
+	return cluster.NewRouter(kv.NewStore(), "node1", "node1", ring, 1)
+}
+
+// BenchmarkPutHandler mede o PUT do cliente (leitura do corpo, validação e
+// escrita) num nó só.
+func BenchmarkPutHandler(b *testing.B) {
+	h := HandlePutDistributed(singleNode(), hotkeys.New(0.1, 1024, time.Minute), Limits{MaxKeyLength: DefaultMaxKeyLength, MaxValueBytes: DefaultMaxValueBytes})
+	for _, size := range bufpool.BenchSizes {
+		value := strings.Repeat("x", size)
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			bufpool.WithPooling(b, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					req := httptest.NewRequest(http.MethodPut, "/v1/kv/user:42", strings.NewReader(value))
+					req = mux.SetURLVars(req, map[string]string{"key": "user:42"})
+					w := httptest.NewRecorder()
+					h(w, req)
+					if w.Code != http.StatusOK {
+						b.Fatalf("status %d: %s", w.Code, w.Body)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkReplicaPutHandler mede o /internal/replica/put (corpo num buffer
+// do pool, decodificação e aplicação), em cada codec.
+func BenchmarkReplicaPutHandler(b *testing.B) {
+	h := HandleReplicaPut(kv.NewStore(), hotkeys.New(0.1, 1024, time.Minute), Limits{MaxKeyLength: DefaultMaxKeyLength, MaxValueBytes: DefaultMaxValueBytes})
+	for _, contentType := range []string{"application/json", "application/msgpack"} {
+		codec := cluster.CodecFor(contentType)
+		for _, size := range bufpool.BenchSizes {
+			var body bytes.Buffer
+			m := kv.Mutation{Op: kv.OpPut, Key: "user:42", Value: strings.Repeat("x", size), Timestamp: kv.Now(), Writer: "node1"}
+			if err := codec.EncodeMutation(&body, m); err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/%dB", codec.Name(), size), func(b *testing.B) {
+				bufpool.WithPooling(b, func(b *testing.B) {
+					b.ReportAllocs()
+					b.SetBytes(int64(size))
+					for i := 0; i < b.N; i++ {
+						req := httptest.NewRequest(http.MethodPost, "/internal/replica/put", bytes.NewReader(body.Bytes()))
+						req.Header.Set("Content-Type", codec.ContentType())
+						w := httptest.NewRecorder()
+						h(w, req)
+						if w.Code != http.StatusOK {
+							b.Fatalf("status %d: %s", w.Code, w.Body)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// TestReplicaDeleteExisted confere o ExistedHeader: só um delete que apaga um
+// valor vivo responde "true".
+func TestReplicaDeleteExisted(t *testing.T) {
+	store := kv.NewStore()
+	store.PutAt("user:42", "v1", 100)
+	h := HandleReplicaDelete(store, hotkeys.New(0.1, 1024, time.Minute), Limits{MaxKeyLength: DefaultMaxKeyLength, MaxValueBytes: DefaultMaxValueBytes})
+	for _, tc := range []struct {
+		ts   int64
+		want string
+	}{
+		{50, "false"},  // perde para o put mais novo
+		{200, "true"},  // apaga o valor
+		{300, "false"}, // já é um tombstone
+	} {
+		body := fmt.Sprintf(`{"key":"user:42","timestamp":%d}`, tc.ts)
+		req := httptest.NewRequest(http.MethodPost, "/internal/replica/delete", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ts %d: status %d: %s", tc.ts, w.Code, w.Body)
+		}
+		if got := w.Header().Get(cluster.ExistedHeader); got != tc.want {
+			t.Errorf("ts %d: %s = %q, want %q", tc.ts, cluster.ExistedHeader, got, tc.want)
+		}
+	}
+	if _, ok := store.GetEntry("user:42"); ok {
+		t.Fatal("user:42 still live after the delete")
+	}
+}
+
+// TestImportRejectsLongLine: uma linha maior que o corpo de réplica máximo
+// vira um erro dela só, e o import segue na linha seguinte.
+func TestImportRejectsLongLine(t *testing.T) {
+	limits := Limits{MaxKeyLength: 16, MaxValueBytes: 16}
+	h := HandleImport(singleNode(), limits)
+	body := strings.Repeat("x", int(limits.replicaBodyMax())+10<<10) + "\n" + `{"key":"user:1","value":"v1"}` + "\n"
+	req := httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h(w, req)
+
+	out := w.Body.String()
+	if !strings.Contains(out, `{"line":1,"error":"line exceeds`) {
+		t.Errorf("no error for the long line:\n%s", out)
+	}
+	if !strings.Contains(out, `{"processed":2,"imported":1,"failed":1,"done":true}`) {
+		t.Errorf("want 1 imported and 1 failed:\n%s", out)
+	}
+}
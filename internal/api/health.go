@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleReady: GET /health/ready
+// Readiness do nó em JSON (status e motivos). Bootstrap e drain respondem
+// 503; "degraded" (poucos nós alcançáveis, hints ou repairs pendentes demais)
+// responde 200, para um problema no cluster não tirar todos os nós do
+// balanceador de uma vez, ou 503 com failOnDegraded.
+func HandleReady(r *cluster.Router, failOnDegraded bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rd := r.Readiness(req.Context())
+		status := http.StatusOK
+		switch rd.Status {
+		case cluster.ReadyBootstrapping, cluster.ReadyDraining:
+			status = http.StatusServiceUnavailable
+		case cluster.ReadyDegraded:
+			if failOnDegraded {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, rd)
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleHints: GET /admin/hints
+// Filas de hinted handoff deste nó: hints pendentes por réplica (quantos,
+// bytes e a idade do mais velho), desde quando ela está fora, próxima
+// tentativa de replay e hints descartados por exceder a janela máxima.
+func HandleHints(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.HintStatus())
+	}
+}
+
+// HandleReplayHints: POST /admin/hints/replay?node=node3
+// Reenvia já os hints pendentes para node (todos os nós sem node), sem
+// esperar o backoff da próxima tentativa.
+func HandleReplayHints(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		nodes, err := r.ReplayHintsNow(hashring.NodeID(req.URL.Query().Get("node")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if nodes == nil {
+			nodes = []hashring.NodeID{}
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"replaying": nodes})
+	}
+}
+
+// HandleDropHints: POST /admin/hints/drop?node=node3 (ou ?all=true)
+// Descarta os hints pendentes, por exemplo para um nó que não vai voltar: o
+// que eles levariam fica para o repair.
+func HandleDropHints(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		node := q.Get("node")
+		if node == "" && q.Get("all") != "true" {
+			http.Error(w, "node is required (or all=true to drop every queue)", http.StatusBadRequest)
+			return
+		}
+		dropped, err := r.DropHints(hashring.NodeID(node))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dropped": dropped})
+	}
+}
+
+// HandleObservers: GET /admin/observers
+// A cópia assíncrona das escritas para os observadores (OBSERVER_NODES):
+// keyspaces de cada um, escritas na fila, enviadas e guardadas como hint.
+func HandleObservers(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.ObserverStatus())
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+)
+
+type historyVersion struct {
+	cluster.HistoryEntry
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+// HandleHistory: GET /kv/{key}/history
+// Últimas versões da chave guardadas pelas réplicas (KEY_HISTORY_VERSIONS),
+// da mais nova para a mais antiga, com o nó que coordenou cada escrita, as
+// réplicas que a receberam e as que a descartaram por já terem uma versão
+// mais nova. 404 se o histórico está desligado neste nó.
+func HandleHistory(r *cluster.Router, store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		if store.HistoryDepth() == 0 {
+			http.Error(w, "version history is disabled (KEY_HISTORY_VERSIONS=0)", http.StatusNotFound)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		h, err := r.History(ctx, key)
+		if err != nil {
+			log.Printf("[ERROR] HISTORY key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		versions := make([]historyVersion, 0, len(h.Versions))
+		for _, v := range h.Versions {
+			var meta kv.ValueMeta
+			v.Value, meta = kv.SplitMeta(v.Value)
+			versions = append(versions, historyVersion{HistoryEntry: v, ContentType: meta.ContentType, Metadata: meta.Headers, Time: time.UnixMicro(v.Timestamp).UTC()})
+		}
+		out := map[string]interface{}{"key": key, "versions": versions}
+		if len(h.Errors) > 0 {
+			out["errors"] = h.Errors
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleReplicaHistory: GET /internal/replica/history?key=...
+// O histórico local da chave.
+func HandleReplicaHistory(store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		versions := store.History(req.URL.Query().Get("key"))
+		if versions == nil {
+			versions = []kv.HistoryVersion{}
+		}
+		writeJSON(w, http.StatusOK, versions)
+	}
+}
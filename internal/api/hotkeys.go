@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+)
+
+const defaultHotKeys = 10
+
+type nodeHotKeys struct {
+	NodeID string        `json:"node_id"`
+	Keys   []hotkeys.Key `json:"keys"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type hotKeysResponse struct {
+	Nodes []nodeHotKeys `json:"nodes"`
+}
+
+func parseTopN(req *http.Request) (int, error) {
+	v := req.URL.Query().Get("n")
+	if v == "" {
+		return defaultHotKeys, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid n %q", v)
+	}
+	return n, nil
+}
+
+// HandleHotKeys: GET /admin/hotkeys?n=10
+// Lista as n chaves mais acessadas de cada nó do cluster, para achar a
+// partição que está sobrecarregando um nó.
+func HandleHotKeys(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		n, err := parseTopN(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+		defer cancel()
+
+		var out hotKeysResponse
+		path := fmt.Sprintf("/internal/hotkeys?n=%d", n)
+		for _, res := range r.Broadcast(ctx, "GET", path, nil) {
+			node := nodeHotKeys{NodeID: string(res.Node.ID), Keys: []hotkeys.Key{}}
+			if !res.OK() {
+				node.Error = res.Error()
+			} else if err := json.Unmarshal(res.Body, &node); err != nil {
+				node.Error = err.Error()
+			}
+			out.Nodes = append(out.Nodes, node)
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalHotKeys: GET /internal/hotkeys?n=10
+func HandleInternalHotKeys(r *cluster.Router, hot *hotkeys.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		n, err := parseTopN(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, nodeHotKeys{
+			NodeID: string(r.NodeID()),
+			Keys:   hot.Top(n),
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// MetricsPrefix é o prefixo dos nomes em /metrics (o mesmo do StatsD).
+const MetricsPrefix = "mini_cassandra"
+
+// RequestMetricsMiddleware mede cada requisição (de cliente e interna) por
+// rota e método: latência no histograma http.request.duration.seconds e
+// contagem por status em http.requests. A rota é o template do mux
+// ("/v1/kv/{key}"), para as chaves não virarem séries.
+func RequestMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, req)
+		status := rec.status
+		switch {
+		case rec.hijacked:
+			status = 0
+		case status == 0:
+			status = http.StatusOK
+		}
+		code := "hijacked"
+		if status != 0 {
+			code = strconv.Itoa(status)
+		}
+		metrics.Observe("http.request.duration.seconds", metrics.Labels{"route", route, "method", req.Method}, time.Since(start))
+		metrics.CountWith("http.requests", metrics.Labels{"route", route, "method", req.Method, "code", code}, 1)
+	})
+}
+
+// statusRecorder guarda o status escrito pelo handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush implementa http.Flusher (respostas em streaming).
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implementa http.Hijacker (o protocolo interno derruba conexões de
+// peers isolados por uma partição injetada).
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	s.hijacked = true
+	return hj.Hijack()
+}
+
+// HandlePrometheus: GET /metrics
+// Todas as métricas do nó no formato de texto do Prometheus, incluindo os
+// histogramas de latência por rota e por peer com p50/p95/p99.
+func HandlePrometheus() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.Default.WritePrometheus(w, MetricsPrefix)
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Paginação das consultas de índice.
+const (
+	defaultIndexQueryLimit = 100
+	maxIndexQueryLimit     = 1000
+)
+
+type indexNode struct {
+	NodeID string `json:"node_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+func indexNodes(results []cluster.NodeResult) ([]indexNode, bool) {
+	ok := true
+	nodes := make([]indexNode, 0, len(results))
+	for _, res := range results {
+		n := indexNode{NodeID: string(res.Node.ID)}
+		if !res.OK() {
+			n.Error = res.Error()
+			ok = false
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, ok
+}
+
+// HandleCreateIndex: POST /admin/indexes
+// Corpo: a declaração "index <nome> on [<keyspace>] field <campo>" (texto)
+// ou o JSON {"name", "keyspace", "field"}. Cria o índice em todos os nós;
+// cada um indexa os dados locais e passa a manter o índice nas escritas.
+func HandleCreateIndex(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(req.Body, 64<<10))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var def kv.IndexDef
+		if text := strings.TrimSpace(string(body)); strings.HasPrefix(text, "{") {
+			if err := json.Unmarshal(body, &def); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			err = cluster.ValidateIndex(def)
+		} else {
+			def, err = cluster.ParseIndexStatement(text)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[INDEX] creating index %s on keyspace=%q field=%s on all nodes", def.Name, def.Keyspace, def.Field)
+		ctx, cancel := context.WithTimeout(req.Context(), 60*time.Second)
+		defer cancel()
+		nodes, ok := indexNodes(r.CreateIndex(ctx, def))
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusBadGateway
+		}
+		writeJSON(w, status, map[string]interface{}{"index": def, "nodes": nodes})
+	}
+}
+
+// HandleDropIndex: DELETE /admin/indexes/{name}
+// Remove o índice de todos os nós.
+func HandleDropIndex(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		log.Printf("[INDEX] dropping index %s on all nodes", name)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		results := r.DropIndex(ctx, name)
+		found := false
+		for i, res := range results {
+			if res.Status == http.StatusNotFound && res.Err == nil {
+				// o nó não tinha o índice: não é falha
+				results[i].Status = http.StatusOK
+				continue
+			}
+			found = found || res.OK()
+		}
+		nodes, ok := indexNodes(results)
+		status := http.StatusOK
+		switch {
+		case !ok:
+			status = http.StatusBadGateway
+		case !found:
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]interface{}{"index": name, "nodes": nodes})
+	}
+}
+
+// HandleListIndexes: GET /admin/indexes
+// Índices deste nó, com quantos termos e chaves locais cada um tem.
+func HandleListIndexes(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Indexes())
+	}
+}
+
+type indexQueryResponse struct {
+	Index string `json:"index"`
+	Value string `json:"value"`
+	cluster.IndexResult
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// HandleIndexQuery: GET /index/{name}?value=...&limit=N&cursor=...&values=true
+// Chaves com o valor no campo do índice, em ordem e paginadas (limit, padrão
+// 100, máx 1000; next_cursor vem quando há mais). Cada nó consulta o índice
+// local e o coordenador confere cada chave com uma leitura. values=true
+// inclui os valores. partial=true: algum nó não respondeu.
+func HandleIndexQuery(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		q := req.URL.Query()
+		if !q.Has("value") {
+			http.Error(w, "missing value", http.StatusBadRequest)
+			return
+		}
+		limit := defaultIndexQueryLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxIndexQueryLimit {
+				http.Error(w, fmt.Sprintf("invalid limit (1..%d)", maxIndexQueryLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		after := ""
+		if v := q.Get("cursor"); v != "" {
+			b, err := base64.RawURLEncoding.DecodeString(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			after = string(b)
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] INDEX QUERY index=%s value=%q", name, q.Get("value"))
+		defer metrics.Since("client.index_query", time.Now())
+
+		res, err := r.QueryIndex(ctx, name, q.Get("value"), after, limit, q.Get("values") == "true")
+		if errors.Is(err, kv.ErrNoIndex) {
+			http.Error(w, "no such index", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			metrics.Inc("client.index_query.errors")
+			log.Printf("[ERROR] INDEX QUERY index=%s err=%v", name, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		// com ACL, só as chaves que a identidade pode ler
+		matches := res.Matches[:0]
+		for _, m := range res.Matches {
+			if aclAllows(req, m.Key, aclRead) {
+				matches = append(matches, m)
+			}
+		}
+		res.Matches = matches
+		out := indexQueryResponse{Index: name, Value: q.Get("value"), IndexResult: res}
+		if res.More && res.After != "" {
+			out.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(res.After))
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalCreateIndex: POST /internal/indexes
+// Cria o índice localmente (corpo: a definição).
+func HandleInternalCreateIndex(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var def kv.IndexDef
+		if err := json.NewDecoder(req.Body).Decode(&def); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := r.CreateLocalIndex(def); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleInternalDropIndex: DELETE /internal/indexes/{name}
+func HandleInternalDropIndex(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ok, err := r.DropLocalIndex(mux.Vars(req)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "no such index", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleInternalIndexQuery: GET /internal/index/query?name=...&term=...&after=...&limit=N
+// Chaves locais com o termo no índice (a parte deste nó de uma consulta).
+func HandleInternalIndexQuery(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxIndexQueryLimit {
+			limit = defaultIndexQueryLimit
+		}
+		page, err := r.LocalIndexQuery(q.Get("name"), q.Get("term"), q.Get("after"), limit)
+		if errors.Is(err, kv.ErrNoIndex) {
+			http.Error(w, "no such index", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
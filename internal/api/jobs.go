@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/jobs"
+
+	"github.com/gorilla/mux"
+)
+
+// HandleJobs: GET /admin/jobs?kind=repair
+// Lista os jobs em background deste nó (repair, rebalance, bootstrap) com
+// progresso, vazão e ETA, do mais recente para o mais antigo.
+func HandleJobs(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Jobs().List(req.URL.Query().Get("kind")))
+	}
+}
+
+// HandleJob: GET /admin/jobs/{id}
+func HandleJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, ok := r.Jobs().Get(mux.Vars(req)["id"])
+		if !ok {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleCancelJob: POST /admin/jobs/{id}/cancel
+// Cancela o contexto do job; ele termina como "cancelled" assim que o
+// trabalho em andamento (lote, intervalo) perceber.
+func HandleCancelJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		if err := r.Jobs().Cancel(id); err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, jobs.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		job, _ := r.Jobs().Get(id)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleRebalance: GET /admin/rebalance
+// O rebalance em andamento (ou o último): chaves examinadas (done/total),
+// movidas, mantidas e com falha em detail, bytes enviados e ETA.
+func HandleRebalance(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, ok := r.Rebalance()
+		if !ok {
+			http.Error(w, "no rebalance has run on this node", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleCancelRebalance: POST /admin/rebalance/cancel
+func HandleCancelRebalance(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, err := r.CancelRebalance()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleMaintenanceStatus: GET /admin/maintenance
+// A manutenção periódica (MAINTENANCE_INTERVAL): quantas rodadas rodaram ou
+// foram puladas, a última e a próxima, e os jobs da última.
+func HandleMaintenanceStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.MaintenanceStatus())
+	}
+}
+
+// HandleAntiEntropyStatus: GET /admin/antientropy
+// O anti-entropy contínuo (ANTI_ENTROPY_INTERVAL): comparações feitas e
+// puladas, chaves comparadas, divergências achadas e reparadas, e a última.
+func HandleAntiEntropyStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.AntiEntropyStatus())
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"mini-cassandra/internal/bufpool"
+)
+
+// Padrões dos limites de tamanho (MAX_KEY_LENGTH e MAX_VALUE_BYTES).
+const (
+	DefaultMaxKeyLength  = 1024
+	DefaultMaxValueBytes = 16 << 20
+)
+
+// Limits são os tamanhos máximos aceitos nas escritas. Valem na API de
+// cliente e também nos endpoints de réplica, para que um valor grande demais
+// seja recusado antes de ser carregado em memória em todas as réplicas.
+type Limits struct {
+	MaxKeyLength  int
+	MaxValueBytes int64
+}
+
+// checkKey recusa chaves vazias ou maiores que MaxKeyLength.
+func (l Limits) checkKey(key string) error {
+	if key == "" {
+		return errors.New("missing key")
+	}
+	if l.MaxKeyLength > 0 && len(key) > l.MaxKeyLength {
+		return fmt.Errorf("key is %d bytes, max is %d (MAX_KEY_LENGTH)", len(key), l.MaxKeyLength)
+	}
+	return nil
+}
+
+// checkValue recusa valores maiores que MaxValueBytes.
+func (l Limits) checkValue(n int) error {
+	if l.MaxValueBytes > 0 && int64(n) > l.MaxValueBytes {
+		return fmt.Errorf("value is %d bytes, max is %d (MAX_VALUE_BYTES)", n, l.MaxValueBytes)
+	}
+	return nil
+}
+
+// readBody lê o corpo da requisição até max bytes (max <= 0 = sem limite),
+// sem ler além disso: corpos maiores respondem 413 logo pelo Content-Length
+// ou assim que o limite é ultrapassado. Em caso de erro a resposta já foi
+// escrita.
+func readBody(w http.ResponseWriter, req *http.Request, max int64, what string) ([]byte, bool) {
+	var buf bytes.Buffer
+	// o MinRead a mais evita que o ReadFrom realoque só para ver o EOF
+	grow := func(n int) { buf.Grow(n + bytes.MinRead) }
+	if !copyBody(w, req, max, what, &buf, grow) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// readPooledBody é o readBody num buffer do pool, para corpos que só são
+// decodificados (o json.Unmarshal copia as strings): devolva com
+// bufpool.Put depois de usar.
+func readPooledBody(w http.ResponseWriter, req *http.Request, max int64, what string) (*bytes.Buffer, bool) {
+	buf := bufpool.Get()
+	grow := func(n int) { buf.Grow(n + bytes.MinRead) }
+	if !copyBody(w, req, max, what, buf, grow) {
+		bufpool.Put(buf)
+		return nil, false
+	}
+	return buf, true
+}
+
+// maxUnlimitedGrow limita a pré-alocação pelo Content-Length quando não há
+// max: o tamanho é o que o cliente declara, e sem limite um header mentiroso
+// alocaria gigabytes antes de o primeiro byte chegar. Acima disso dst cresce
+// conforme o corpo chega.
+const maxUnlimitedGrow = 1 << 20
+
+// copyBody é o readBody escrevendo em dst; grow, se não for nil, recebe o
+// Content-Length antes da cópia para dst alocar uma vez só (até
+// maxUnlimitedGrow se max <= 0).
+func copyBody(w http.ResponseWriter, req *http.Request, max int64, what string, dst io.Writer, grow func(int)) bool {
+	if max > 0 {
+		if req.ContentLength > max {
+			http.Error(w, fmt.Sprintf("%s is %d bytes, max is %d", what, req.ContentLength, max), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, max)
+	}
+	if grow != nil && req.ContentLength > 0 {
+		n := req.ContentLength
+		if max <= 0 && n > maxUnlimitedGrow {
+			n = maxUnlimitedGrow
+		}
+		grow(int(n))
+	}
+	if _, err := bufpool.Copy(dst, req.Body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("%s exceeds %d bytes", what, max), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "read error: "+err.Error(), http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
+
+// replicaBodyMax é o corpo máximo de /internal/replica/put: o valor e a
+// chave em JSON (no pior caso cada byte vira um escape \u00XX) e os campos.
+func (l Limits) replicaBodyMax() int64 {
+	if l.MaxValueBytes <= 0 {
+		return 0
+	}
+	return 6*(l.MaxValueBytes+int64(l.MaxKeyLength)) + 4096
+}
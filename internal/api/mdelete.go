@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+type multiDeleteRequest struct {
+	Keys   []string `json:"keys"`
+	Prefix string   `json:"prefix"`
+	Limit  int      `json:"limit"`
+}
+
+type deleteResult struct {
+	Key   string `json:"key"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Replicas são as réplicas que gravaram o tombstone (num erro por falta
+	// de confirmações, as que gravaram ficam com ele)
+	Replicas []string `json:"replicas,omitempty"`
+}
+
+type multiDeleteResponse struct {
+	Results []deleteResult `json:"results"`
+	Deleted int            `json:"deleted"`
+	Failed  int            `json:"failed"`
+	// More: no modo prefixo, ainda há chaves com o prefixo (chame de novo)
+	More bool `json:"more,omitempty"`
+	// Partial: algum nó não respondeu na listagem do prefixo
+	Partial bool `json:"partial,omitempty"`
+}
+
+// HandleMultiDelete: POST /kv/_mdelete
+// Corpo {"keys": [...]} ou {"prefix": "users:", "limit": 1000}: apaga as
+// chaves (até 1000 por chamada) com a consistência pedida, agrupando os
+// tombstones por réplica, e responde o resultado de cada chave. No modo
+// prefixo, more=true indica que sobraram chaves.
+func HandleMultiDelete(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		max := int64(0)
+		if limits.MaxKeyLength > 0 {
+			max = int64(cluster.MaxDeleteBatch*(limits.MaxKeyLength+8) + 1024)
+		}
+		body, ok := readBody(w, req, max, "key list")
+		if !ok {
+			return
+		}
+		var in multiDeleteRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if (len(in.Keys) == 0) == (in.Prefix == "") {
+			http.Error(w, "send either keys or a non-empty prefix", http.StatusBadRequest)
+			return
+		}
+		if len(in.Keys) > cluster.MaxDeleteBatch {
+			http.Error(w, "too many keys (max "+strconv.Itoa(cluster.MaxDeleteBatch)+")", http.StatusRequestEntityTooLarge)
+			return
+		}
+		// com ACL, a chamada inteira é recusada se alguma chave (ou o
+		// prefixo) não puder ser gravada
+		if in.Prefix != "" && !aclAllows(req, in.Prefix, aclWrite) {
+			aclDeny(w, req, in.Prefix, aclWrite)
+			return
+		}
+		for _, key := range in.Keys {
+			if !aclAllows(req, key, aclWrite) {
+				aclDeny(w, req, key, aclWrite)
+				return
+			}
+		}
+		// sem nível pedido, cada chave usa o padrão do keyspace dela
+		cl, err := requestedConsistency(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cl != "" {
+			w.Header().Set(ConsistencyHeader, string(cl))
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		defer metrics.Since("client.mdelete", time.Now())
+
+		var out multiDeleteResponse
+		keys := in.Keys
+		if in.Prefix != "" {
+			limit := in.Limit
+			if limit <= 0 || limit > cluster.MaxDeleteBatch {
+				limit = cluster.MaxDeleteBatch
+			}
+			keys, out.More, out.Partial = r.KeysWithPrefix(ctx, in.Prefix, limit)
+		}
+
+		// chaves inválidas falham sozinhas, sem ir para as réplicas
+		out.Results = make([]deleteResult, len(keys))
+		var valid []string
+		var idx []int
+		for i, key := range keys {
+			out.Results[i].Key = key
+			if err := limits.checkKey(key); err != nil {
+				out.Results[i].Error = err.Error()
+				continue
+			}
+			hot.Record(key, hotkeys.Write)
+			valid = append(valid, key)
+			idx = append(idx, i)
+		}
+		for j, res := range r.DeleteBatch(ctx, valid, cl) {
+			out.Results[idx[j]].Replicas = ackedIDs(res.Acked)
+			if res.Err != nil {
+				out.Results[idx[j]].Error = res.Err.Error()
+				continue
+			}
+			out.Results[idx[j]].OK = true
+		}
+		for _, res := range out.Results {
+			if res.OK {
+				out.Deleted++
+			} else {
+				out.Failed++
+			}
+		}
+		metrics.Add("client.mdelete.keys", int64(len(keys)))
+		log.Printf("[API] MDELETE keys=%d prefix=%q deleted=%d failed=%d", len(keys), in.Prefix, out.Deleted, out.Failed)
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+func ackedIDs(ids []hashring.NodeID) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = string(id)
+	}
+	return out
+}
+
+// HandleInternalKeys: GET /internal/keys?prefix=...&after=...&limit=N
+// Chaves locais em ordem (sem tombstones), para listagens do cluster.
+func HandleInternalKeys(store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxDebugKeysLimit {
+			limit = defaultDebugKeysLimit
+		}
+		infos, more := store.ScanKeys(q.Get("prefix"), q.Get("after"), limit)
+		page := cluster.KeyPage{Keys: make([]string, len(infos)), More: more}
+		for i, info := range infos {
+			page.Keys[i] = info.Key
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
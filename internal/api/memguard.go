@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/metrics"
+)
+
+// Padrões do controle de admissão por memória.
+const (
+	DefaultMemoryHighWater  = 0.9
+	DefaultMemoryLargeBytes = 64 << 10
+	memorySampleInterval    = time.Second
+)
+
+// DefaultMemoryBatchPaths são as rotas de lote, recusadas perto do limite
+// qualquer que seja o tamanho do corpo.
+var DefaultMemoryBatchPaths = []string{
+	"/admin/import",
+	"/v1/kv/_mdelete",
+	"/kv/_mdelete",
+	cluster.ReplicaBatchPath,
+	"/internal/stream/apply",
+}
+
+// MemoryGuardConfig diz quando o nó começa a recusar escritas grandes.
+type MemoryGuardConfig struct {
+	// LimitBytes é o teto de memória do processo (0 desliga o guard)
+	LimitBytes int64
+	// HighWater: fração de LimitBytes a partir da qual escritas grandes e
+	// lotes são recusados
+	HighWater float64
+	// LargeBytes: corpos a partir disso (ou sem Content-Length) são grandes
+	LargeBytes int64
+	BatchPaths []string
+}
+
+// MemoryStatus é o estado do guard (GET /admin/memory).
+type MemoryStatus struct {
+	Enabled    bool    `json:"enabled"`
+	LimitBytes int64   `json:"limit_bytes,omitempty"`
+	HighWater  float64 `json:"high_water,omitempty"`
+	LargeBytes int64   `json:"large_bytes,omitempty"`
+	// HeapBytes: heap em uso na última amostra (o store e tudo o mais)
+	HeapBytes int64 `json:"heap_bytes"`
+	// InFlightBytes: corpos das requisições em andamento
+	InFlightBytes    int64      `json:"in_flight_bytes"`
+	InFlightRequests int        `json:"in_flight_requests"`
+	Pressure         bool       `json:"pressure"`
+	Rejected         int64      `json:"rejected"`
+	SampledAt        *time.Time `json:"sampled_at,omitempty"`
+	// contadores do runtime lidos na hora (mcli bench usa a diferença para
+	// calcular as alocações por requisição)
+	Mallocs         uint64 `json:"mallocs"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	NumGC           uint32 `json:"num_gc"`
+}
+
+// MemoryGuard recusa com 503 escritas grandes e lotes quando a memória do
+// processo (heap amostrado mais os corpos em andamento) chega perto de
+// LimitBytes, em vez de deixar o nó morrer por OOM e jogar o tráfego dele
+// nas outras réplicas. Escritas pequenas e leituras continuam.
+type MemoryGuard struct {
+	cfg   MemoryGuardConfig
+	batch map[string]bool
+
+	mu        sync.Mutex
+	heap      int64
+	inflight  int64
+	requests  int
+	rejected  int64
+	sampledAt time.Time
+}
+
+// NewMemoryGuard cria o guard; cfg.LimitBytes <= 0 o deixa desligado.
+func NewMemoryGuard(cfg MemoryGuardConfig) *MemoryGuard {
+	if cfg.HighWater <= 0 || cfg.HighWater > 1 {
+		cfg.HighWater = DefaultMemoryHighWater
+	}
+	if cfg.LargeBytes <= 0 {
+		cfg.LargeBytes = DefaultMemoryLargeBytes
+	}
+	if cfg.BatchPaths == nil {
+		cfg.BatchPaths = DefaultMemoryBatchPaths
+	}
+	g := &MemoryGuard{cfg: cfg, batch: make(map[string]bool)}
+	for _, p := range cfg.BatchPaths {
+		g.batch[p] = true
+	}
+	return g
+}
+
+// Run amostra o heap a cada segundo até ctx terminar.
+func (g *MemoryGuard) Run(ctx context.Context) {
+	if g.cfg.LimitBytes <= 0 {
+		return
+	}
+	log.Printf("[MEMORY] admission control: limit=%dMB high_water=%.2f large_writes>=%dKB", g.cfg.LimitBytes>>20, g.cfg.HighWater, g.cfg.LargeBytes>>10)
+	ticker := time.NewTicker(memorySampleInterval)
+	defer ticker.Stop()
+	var ms runtime.MemStats
+	for {
+		runtime.ReadMemStats(&ms)
+		g.mu.Lock()
+		g.heap = int64(ms.HeapAlloc)
+		g.sampledAt = time.Now()
+		g.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status retorna a última amostra do guard.
+func (g *MemoryGuard) Status() MemoryStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := MemoryStatus{Enabled: g.cfg.LimitBytes > 0, HeapBytes: g.heap, InFlightBytes: g.inflight, InFlightRequests: g.requests, Rejected: g.rejected}
+	if st.Enabled {
+		st.LimitBytes, st.HighWater, st.LargeBytes = g.cfg.LimitBytes, g.cfg.HighWater, g.cfg.LargeBytes
+		st.Pressure = g.overLocked(0)
+	}
+	if !g.sampledAt.IsZero() {
+		t := g.sampledAt.UTC()
+		st.SampledAt = &t
+	}
+	return st
+}
+
+func (g *MemoryGuard) overLocked(size int64) bool {
+	return float64(g.heap+g.inflight+size) >= g.cfg.HighWater*float64(g.cfg.LimitBytes)
+}
+
+// Middleware aplica o guard às escritas (PUT, POST, PATCH).
+func (g *MemoryGuard) Middleware(next http.Handler) http.Handler {
+	if g.cfg.LimitBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut && req.Method != http.MethodPost && req.Method != http.MethodPatch {
+			next.ServeHTTP(w, req)
+			return
+		}
+		size := req.ContentLength
+		large := size < 0 || size >= g.cfg.LargeBytes || g.batch[req.URL.Path]
+		if size < 0 {
+			size = g.cfg.LargeBytes
+		}
+
+		g.mu.Lock()
+		if large && g.overLocked(size) {
+			g.rejected++
+			heap, inflight := g.heap, g.inflight
+			g.mu.Unlock()
+			metrics.Inc("memory.rejected")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("node under memory pressure (heap %dMB + in flight %dMB + this request %dKB, limit %dMB): large writes and batches are rejected, retry later or elsewhere",
+				heap>>20, inflight>>20, size>>10, g.cfg.LimitBytes>>20), http.StatusServiceUnavailable)
+			return
+		}
+		g.inflight += size
+		g.requests++
+		g.mu.Unlock()
+
+		defer func() {
+			g.mu.Lock()
+			g.inflight -= size
+			g.requests--
+			g.mu.Unlock()
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// HandleMemoryStatus: GET /admin/memory
+func HandleMemoryStatus(g *MemoryGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		st := g.Status()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		st.Mallocs, st.TotalAllocBytes, st.NumGC = ms.Mallocs, ms.TotalAlloc, ms.NumGC
+		writeJSON(w, http.StatusOK, st)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"mini-cassandra/internal/kv"
+)
+
+// MetaHeaderPrefix: os headers X-Meta-<nome> de um PUT são guardados com o
+// valor e voltam no GET e no HEAD.
+const MetaHeaderPrefix = "X-Meta-"
+
+// formContentType é o Content-Type que o curl -d manda sem ninguém pedir;
+// não diz nada sobre o valor, então não é guardado.
+const formContentType = "application/x-www-form-urlencoded"
+
+// requestMeta lê os metadados de uma escrita: o Content-Type e os headers
+// X-Meta-*.
+func requestMeta(req *http.Request) (kv.ValueMeta, error) {
+	var meta kv.ValueMeta
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return meta, fmt.Errorf("invalid Content-Type %q", ct)
+		}
+		if mt != formContentType {
+			meta.ContentType = ct
+		}
+	}
+	for name, values := range req.Header {
+		if !strings.HasPrefix(name, MetaHeaderPrefix) || len(name) == len(MetaHeaderPrefix) {
+			continue
+		}
+		if meta.Headers == nil {
+			meta.Headers = make(map[string]string)
+		}
+		meta.Headers[strings.ToLower(name[len(MetaHeaderPrefix):])] = strings.Join(values, ", ")
+	}
+	if n := meta.Size(); !meta.Empty() && n > kv.MaxMetaBytes {
+		return meta, fmt.Errorf("value metadata is %d bytes, max is %d", n, kv.MaxMetaBytes)
+	}
+	return meta, nil
+}
+
+// writeMetaHeaders põe os metadados guardados com o valor na resposta.
+func writeMetaHeaders(h http.Header, meta kv.ValueMeta) {
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	for _, name := range meta.HeaderNames() {
+		h.Set(MetaHeaderPrefix+name, meta.Headers[name])
+	}
+}
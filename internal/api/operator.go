@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// isOperatorPath diz se path é de operação do nó (/admin/* e /debug/*), que
+// nenhum cliente nem peer precisa chamar.
+func isOperatorPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/")
+}
+
+// AdminTokenMiddleware exige o ADMIN_TOKEN (Authorization: Bearer <token>)
+// em /admin/* e /debug/*, em qualquer listener; 401 sem ele. Token vazio
+// desliga a checagem.
+func AdminTokenMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !isOperatorPath(req.URL.Path) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			got, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				metrics.Inc("admin.unauthorized")
+				log.Printf("[ADMIN] rejected %s %s from %s: missing or invalid admin token", req.Method, req.URL.Path, req.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mini-cassandra-admin"`)
+				http.Error(w, "admin endpoints require ADMIN_TOKEN", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// WithoutOperatorPaths responde 404 a /admin/* e /debug/* e passa o resto
+// para next: é o handler do LISTEN_ADDR quando ADMIN_LISTEN_ADDR serve esses
+// caminhos numa porta só de operação.
+func WithoutOperatorPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isOperatorPath(req.URL.Path) {
+			http.NotFound(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// OutcomeMiddleware põe em toda resposta de cliente as decisões do
+// coordenador: X-Coordinator sempre e, nas requisições que passaram por
+// réplicas, as réplicas contatadas e as que confirmaram (ou responderam),
+// a que serviu a versão lida (numa escrita, o coordenador) e o nível de
+// consistência alcançado.
+func OutcomeMiddleware(r *cluster.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasPrefix(req.URL.Path, "/internal/") {
+				next.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Set(cluster.CoordinatorHeader, string(r.NodeID()))
+			ctx, o := cluster.WithOutcome(req.Context())
+			next.ServeHTTP(&outcomeWriter{ResponseWriter: w, outcome: o}, req.WithContext(ctx))
+		})
+	}
+}
+
+// outcomeWriter escreve os headers do Outcome junto com o status: o handler
+// só responde depois de a operação nas réplicas terminar.
+type outcomeWriter struct {
+	http.ResponseWriter
+	outcome *cluster.Outcome
+	written bool
+}
+
+func (o *outcomeWriter) setHeaders() {
+	if o.written {
+		return
+	}
+	o.written = true
+	for k, v := range o.outcome.Headers() {
+		o.Header().Set(k, v)
+	}
+}
+
+func (o *outcomeWriter) WriteHeader(code int) {
+	o.setHeaders()
+	o.ResponseWriter.WriteHeader(code)
+}
+
+func (o *outcomeWriter) Write(p []byte) (int, error) {
+	o.setHeaders()
+	return o.ResponseWriter.Write(p)
+}
+
+// Flush implementa http.Flusher (respostas em streaming).
+func (o *outcomeWriter) Flush() {
+	o.setHeaders()
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
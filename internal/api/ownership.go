@@ -0,0 +1,90 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// defaultImbalanceThreshold: desvio relativo (20%) da posse ideal a partir
+// do qual um nó é marcado como desbalanceado.
+const defaultImbalanceThreshold = 0.2
+
+type nodeOwnership struct {
+	NodeID       string  `json:"node_id"`
+	Host         string  `json:"host"`
+	Tokens       int     `json:"tokens"`
+	PrimaryPct   float64 `json:"primary_pct"`
+	EffectivePct float64 `json:"effective_pct"`
+	IdealPct     float64 `json:"ideal_pct"`
+	DeviationPct float64 `json:"deviation_pct"`
+	Imbalanced   bool    `json:"imbalanced"`
+}
+
+type ownershipResponse struct {
+	ReplicationFactor int             `json:"replication_factor"`
+	Threshold         float64         `json:"threshold"`
+	Balanced          bool            `json:"balanced"`
+	Nodes             []nodeOwnership `json:"nodes"`
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// HandleOwnership: GET /admin/ownership?threshold=0.2
+// Porcentagem efetiva do espaço de tokens de cada nó (com vnodes e fator de
+// replicação). Um nó é marcado como desbalanceado quando a posse efetiva se
+// afasta da ideal (RF/N) mais que threshold (fração relativa).
+func HandleOwnership(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		threshold := defaultImbalanceThreshold
+		if v := req.URL.Query().Get("threshold"); v != "" {
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil || t <= 0 {
+				http.Error(w, "invalid threshold", http.StatusBadRequest)
+				return
+			}
+			threshold = t
+		}
+
+		owners := r.Ownership()
+		rf := r.ReplicationFactor()
+		if rf > len(owners) {
+			rf = len(owners)
+		}
+
+		out := ownershipResponse{
+			ReplicationFactor: r.ReplicationFactor(),
+			Threshold:         threshold,
+			Balanced:          true,
+			Nodes:             []nodeOwnership{},
+		}
+		if len(owners) == 0 {
+			writeJSON(w, http.StatusOK, out)
+			return
+		}
+
+		ideal := float64(rf) / float64(len(owners))
+		for _, o := range owners {
+			dev := (o.Effective - ideal) / ideal
+			node := nodeOwnership{
+				NodeID:       string(o.Node.ID),
+				Host:         o.Node.Host,
+				Tokens:       o.Tokens,
+				PrimaryPct:   round2(o.Primary * 100),
+				EffectivePct: round2(o.Effective * 100),
+				IdealPct:     round2(ideal * 100),
+				DeviationPct: round2(dev * 100),
+				Imbalanced:   math.Abs(dev) > threshold,
+			}
+			if node.Imbalanced {
+				out.Balanced = false
+			}
+			out.Nodes = append(out.Nodes, node)
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"mini-cassandra/internal/cluster"
+)
+
+type partitionRequest struct {
+	Peers []string `json:"peers"`
+}
+
+// HandlePartition: POST /admin/partition (só com ENABLE_PARTITION_API)
+// Corpo: {"peers": ["node2", "node3"]}. Isola este nó dos peers nos dois
+// sentidos, substituindo a partição anterior; uma lista vazia a desfaz.
+// Para testes de integração (split-brain, quorum, hints).
+func HandlePartition(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body partitionRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		status, err := r.SetPartition(body.Peers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(status.Peers) == 0 {
+			log.Printf("[PARTITION] node %s healed", r.NodeID())
+		} else {
+			log.Printf("[PARTITION] node %s dropping traffic to/from %v", r.NodeID(), status.Peers)
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// HandleHealPartition: DELETE /admin/partition
+// Desfaz a partição injetada.
+func HandleHealPartition(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		status, _ := r.SetPartition(nil)
+		log.Printf("[PARTITION] node %s healed", r.NodeID())
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// HandlePartitionStatus: GET /admin/partition
+func HandlePartitionStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Partition())
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/metrics"
+)
+
+// MergePatchContentType é o Content-Type de um JSON merge-patch (RFC 7396).
+const MergePatchContentType = "application/merge-patch+json"
+
+// HandlePatch: PATCH /kv/{key}
+// Corpo: JSON merge-patch (RFC 7396) aplicado ao documento JSON da chave
+// como operação condicional no dono da chave, sem uma escrita concorrente
+// no meio; campos com null são removidos. A chave é criada se não existir
+// (If-Match: * exige que exista; If-Match com ETag, que esteja nessa versão).
+// Responde 200 com o documento gravado e o ETag novo; 412 se o If-Match não
+// bate, 422 se o valor atual não é JSON.
+func HandlePatch(r *cluster.Router, hot *hotkeys.Tracker, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := pathKey(req)
+		if err := limits.checkKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ct := req.Header.Get("Content-Type"); ct != "" {
+			mt, _, _ := mime.ParseMediaType(ct)
+			if mt != MergePatchContentType && mt != "application/json" {
+				http.Error(w, "unsupported Content-Type (use "+MergePatchContentType+")", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		body, ok := readBody(w, req, limits.MaxValueBytes, "patch")
+		if !ok {
+			return
+		}
+		if !json.Valid(body) {
+			http.Error(w, "invalid merge patch: body is not JSON", http.StatusBadRequest)
+			return
+		}
+		var version int64
+		var anyVersion bool
+		if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+			anyVersion = strings.TrimSpace(ifMatch) == "*"
+			if !anyVersion {
+				if version, ok = parseETag(ifMatch); !ok {
+					http.Error(w, "invalid If-Match (use an ETag returned by GET, or *)", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		cl, err := parseConsistency(w, req, cluster.Quorum)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		log.Printf("[API] PATCH key=%s", key)
+		hot.Record(key, hotkeys.Write)
+		defer metrics.Since("client.patch", time.Now())
+
+		doc, res, err := r.MergePatch(ctx, key, body, cl, version, anyVersion)
+		if errors.Is(err, cluster.ErrPreconditionFailed) {
+			if res.Previous.Found && res.Previous.Entry.Timestamp > 0 {
+				w.Header().Set("ETag", versionETag(res.Previous.Entry.Timestamp))
+			}
+			http.Error(w, "precondition failed: current version does not match If-Match", http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			metrics.Inc("client.patch.errors")
+			log.Printf("[ERROR] PATCH key=%s err=%v", key, err)
+			writeError(w, err, writeErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", versionETag(res.Timestamp))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(doc))
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+)
+
+const defaultPlanSample = 20
+
+type planResponse struct {
+	*cluster.Plan
+	MBps         float64  `json:"mbps"`
+	TransferSecs float64  `json:"est_transfer_secs"`
+	Unreachable  []string `json:"unreachable,omitempty"`
+}
+
+// parseTopologyChange lê ?add=node4=host:port, ?remove=node3 e
+// ?vnodes=node2=150 (repetíveis ou separados por vírgula). O host do nó novo
+// é opcional: a posição no anel só depende do ID.
+func parseTopologyChange(req *http.Request) (cluster.TopologyChange, error) {
+	var change cluster.TopologyChange
+	q := req.URL.Query()
+	for _, v := range q["add"] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, host, _ := strings.Cut(part, "=")
+			change.Add = append(change.Add, hashring.NodeInfo{ID: hashring.NodeID(id), Host: host})
+		}
+	}
+	for _, v := range q["remove"] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				change.Remove = append(change.Remove, hashring.NodeID(part))
+			}
+		}
+	}
+	for _, v := range q["vnodes"] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, count, _ := strings.Cut(part, "=")
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return change, fmt.Errorf("invalid vnodes %q (want node=N)", part)
+			}
+			if change.VNodes == nil {
+				change.VNodes = make(map[hashring.NodeID]int)
+			}
+			change.VNodes[hashring.NodeID(id)] = n
+		}
+	}
+	return change, nil
+}
+
+// HandleRebalancePlan: GET /admin/rebalance/plan?add=node4=host:port&remove=node3&vnodes=node2=150
+// Dry-run de uma mudança de topologia: calcula quais trechos do anel mudam
+// de réplicas, quantas chaves e bytes iriam se mover e para/de quais nós,
+// sem mover nada. sample=N lista até N chaves de exemplo.
+func HandleRebalancePlan(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		change, err := parseTopologyChange(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(change.Add) == 0 && len(change.Remove) == 0 && len(change.VNodes) == 0 {
+			http.Error(w, "nothing to plan: use add=, remove= and/or vnodes=", http.StatusBadRequest)
+			return
+		}
+		mbps, ok := parseMBps(req)
+		if !ok {
+			http.Error(w, "invalid mbps", http.StatusBadRequest)
+			return
+		}
+		sample := defaultPlanSample
+		if v := req.URL.Query().Get("sample"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid sample", http.StatusBadRequest)
+				return
+			}
+			sample = n
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+
+		plan, failed, err := r.PlanCluster(ctx, change, sample)
+		if err != nil {
+			status := http.StatusBadRequest
+			if len(failed) > 0 {
+				status = http.StatusBadGateway
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out := planResponse{
+			Plan:         plan,
+			MBps:         mbps,
+			TransferSecs: cluster.EstimateTransfer(plan.Bytes, mbps).Seconds(),
+		}
+		for _, res := range failed {
+			out.Unreachable = append(out.Unreachable, string(res.Node.ID))
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalRebalancePlan: POST /internal/rebalance/plan
+func HandleInternalRebalancePlan(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		change, sample, err := cluster.DecodePlanRequest(body)
+		if err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		plan, err := r.PlanLocal(change, sample)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	}
+}
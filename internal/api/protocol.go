@@ -0,0 +1,172 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/metrics"
+)
+
+// ProtocolMiddleware exige em toda rota /internal/* (menos o handshake) os
+// headers com a versão do protocolo interno e o nome do cluster. Versões que
+// este nó não entende são recusadas com 426 (um nó incompatível no meio de
+// um rolling upgrade falha com um erro claro em vez de gravar payload
+// errado) e nós de outro cluster com 403. Mutações de um coordenador com a
+// época do ring velha são recusadas com 421 (ver cluster.CheckRingEpoch).
+func ProtocolMiddleware(r *cluster.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return protocolHandler(r, next)
+	}
+}
+
+func protocolHandler(r *cluster.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if peer, ok := r.PartitionedFrom(req); ok {
+			dropConnection(w, peer)
+			return
+		}
+		if !strings.HasPrefix(req.URL.Path, "/internal/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// CLUSTER_SECRET: sem ele, qualquer cliente que alcança a porta
+		// gravaria direto nas réplicas por /internal/replica/put
+		if !r.ClusterAuthorized(req) {
+			metrics.Inc("internal.unauthorized")
+			log.Printf("[CLUSTER] rejected %s from %s: missing or invalid %s", req.URL.Path, req.RemoteAddr, cluster.AuthHeader)
+			http.Error(w, "internal endpoints require the cluster secret", http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path == cluster.HandshakePath {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if name, local := req.Header.Get(cluster.ClusterNameHeader), r.ClusterName(); name != local {
+			log.Printf("[CLUSTER] rejected %s from %s: cluster %q, expected %q", req.URL.Path, req.RemoteAddr, name, local)
+			http.Error(w, fmt.Sprintf("cluster name mismatch: request is for cluster %q, this node belongs to %q", name, local), http.StatusForbidden)
+			return
+		}
+		raw := req.Header.Get(cluster.ProtocolHeader)
+		v, err := strconv.Atoi(raw)
+		if raw == "" || err != nil {
+			http.Error(w, fmt.Sprintf("missing %s header: internal requests require protocol %d..%d", cluster.ProtocolHeader, cluster.MinProtocolVersion, cluster.ProtocolVersion), http.StatusUpgradeRequired)
+			return
+		}
+		if v < cluster.MinProtocolVersion || v > cluster.ProtocolVersion {
+			http.Error(w, fmt.Sprintf("unsupported internal protocol %d: this node speaks %d..%d", v, cluster.MinProtocolVersion, cluster.ProtocolVersion), http.StatusUpgradeRequired)
+			return
+		}
+		w.Header().Set(cluster.ProtocolHeader, strconv.Itoa(cluster.ProtocolVersion))
+		w.Header().Set(cluster.RingEpochHeader, strconv.FormatUint(r.RingEpoch(), 10))
+		if err := r.CheckRingEpoch(req); err != nil {
+			http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+			return
+		}
+
+		// corpo comprimido pelo nó que chamou (INTERNODE_COMPRESSION)
+		switch enc := req.Header.Get("Content-Encoding"); enc {
+		case "":
+		case cluster.CompressionGzip:
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			req.Body = zr
+			req.ContentLength = -1
+			req.Header.Del("Content-Encoding")
+		default:
+			http.Error(w, fmt.Sprintf("unsupported Content-Encoding %q", enc), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// respostas grandes (streaming, repair) voltam comprimidas quando a
+		// compressão entre nós está ligada
+		if enc, min := r.InternodeCompression(); enc == cluster.CompressionGzip && acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			gw := &gzipResponseWriter{ResponseWriter: w, min: min}
+			defer gw.close()
+			w = gw
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// dropConnection fecha a conexão sem responder, para o peer isolado por uma
+// partição injetada ver o mesmo erro de rede de um nó fora do ar.
+func dropConnection(w http.ResponseWriter, peer hashring.NodeID) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, fmt.Sprintf("network partition injected: traffic from %s is dropped", peer), http.StatusServiceUnavailable)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+// HandleInternalHandshake: GET /internal/handshake
+// Versões do protocolo interno que este nó entende e seus metadados (chamado
+// no primeiro contato de cada nó com este, e por /cluster/status).
+func HandleInternalHandshake(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, cluster.Handshake{
+			NodeID:             string(r.NodeID()),
+			ClusterName:        r.ClusterName(),
+			Host:               r.SelfHost(),
+			VNodes:             r.VNodes(),
+			ReplicationFactor:  r.ReplicationFactor(),
+			ProtocolVersion:    cluster.ProtocolVersion,
+			MinProtocolVersion: cluster.MinProtocolVersion,
+			Meta:               r.NodeMeta(),
+			Compression:        cluster.SupportedCompression,
+			Codecs:             cluster.SupportedCodecs,
+		})
+	}
+}
+
+// HandleProtocol: GET /admin/protocol
+// Cluster, versão do protocolo interno e época do ring deste nó e o
+// resultado do handshake com cada nó com que ele já falou.
+func HandleProtocol(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"cluster_name":         r.ClusterName(),
+			"protocol_version":     cluster.ProtocolVersion,
+			"min_protocol_version": cluster.MinProtocolVersion,
+			"ring_epoch":           r.RingEpoch(),
+			"peers":                r.PeerProtocols(),
+		})
+	}
+}
+
+// HandleLatency: GET /admin/latency
+// Latência medida deste coordenador para cada nó (média móvel das chamadas
+// de réplica), usada para ordenar as réplicas das leituras.
+func HandleLatency(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"peers": r.PeerLatencies()})
+	}
+}
+
+// HandleClusterStatus: GET /cluster/status
+// Metadados de todos os nós (versão, rack/DC, capacidade, features), quantos
+// nós estão em cada versão e as features já presentes em todo o cluster,
+// para acompanhar um rolling upgrade.
+func HandleClusterStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+		defer cancel()
+		writeJSON(w, http.StatusOK, r.ClusterStatus(ctx))
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+)
+
+type rangeReport struct {
+	Start        uint32   `json:"start"`
+	End          uint32   `json:"end"`
+	Replicas     []string `json:"replicas"`
+	Keys         int      `json:"keys"`
+	Bytes        int64    `json:"bytes"`
+	TransferSecs float64  `json:"est_transfer_secs"`
+}
+
+type nodeDataSize struct {
+	NodeID       string  `json:"node_id"`
+	Bytes        int64   `json:"bytes"`
+	TransferSecs float64 `json:"est_transfer_secs"`
+}
+
+type rangesResponse struct {
+	MBps        float64        `json:"mbps"`
+	TotalBytes  int64          `json:"total_bytes"`
+	Nodes       []nodeDataSize `json:"nodes"`
+	Ranges      []rangeReport  `json:"ranges"`
+	Unreachable []string       `json:"unreachable,omitempty"`
+}
+
+// parseMBps lê ?mbps= (vazão assumida para as estimativas de transferência).
+func parseMBps(req *http.Request) (float64, bool) {
+	v := req.URL.Query().Get("mbps")
+	if v == "" {
+		return cluster.DefaultStreamMBps, true
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0, false
+	}
+	return f, true
+}
+
+// HandleRanges: GET /admin/ranges?mbps=50&empty=true
+// Tamanho aproximado dos dados em cada intervalo de tokens do anel (e por nó),
+// com a estimativa de quanto tempo levaria para transferi-los. Por padrão só
+// lista intervalos com dados; empty=true inclui todos.
+func HandleRanges(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		mbps, ok := parseMBps(req)
+		if !ok {
+			http.Error(w, "invalid mbps", http.StatusBadRequest)
+			return
+		}
+		withEmpty := req.URL.Query().Get("empty") == "true"
+
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		sizes, perNode, failed := r.ClusterRangeSizes(ctx)
+
+		out := rangesResponse{MBps: mbps, Nodes: []nodeDataSize{}, Ranges: []rangeReport{}}
+		for _, res := range failed {
+			out.Unreachable = append(out.Unreachable, string(res.Node.ID))
+		}
+
+		for _, t := range r.Ranges() {
+			s, ok := sizes[t.End]
+			if !ok && !withEmpty {
+				continue
+			}
+			rep := rangeReport{
+				Start:        t.Start,
+				End:          t.End,
+				Replicas:     nodeIDs(r.ReplicasForRange(t)),
+				Keys:         s.Keys,
+				Bytes:        s.Bytes,
+				TransferSecs: cluster.EstimateTransfer(s.Bytes, mbps).Seconds(),
+			}
+			out.TotalBytes += s.Bytes
+			out.Ranges = append(out.Ranges, rep)
+		}
+
+		for _, n := range r.Nodes() {
+			b := perNode[n.ID]
+			out.Nodes = append(out.Nodes, nodeDataSize{
+				NodeID:       string(n.ID),
+				Bytes:        b,
+				TransferSecs: cluster.EstimateTransfer(b, mbps).Seconds(),
+			})
+		}
+		sort.Slice(out.Nodes, func(i, j int) bool { return out.Nodes[i].NodeID < out.Nodes[j].NodeID })
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalRangeSizes: GET /internal/ranges/sizes
+func HandleInternalRangeSizes(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.LocalRangeSizes())
+	}
+}
+
+func nodeIDs(nodes []hashring.NodeInfo) []string {
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		out[i] = string(n.ID)
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleReadOnly: POST /admin/readonly?enabled=true&reason=...
+// Liga (ou desliga, com enabled=false) o modo somente leitura do nó: PUT,
+// DELETE e import de clientes recebem 503 com o motivo, enquanto leituras e
+// o tráfego entre réplicas continuam. Vale só para este nó e até o restart.
+func HandleReadOnly(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		enabled := true
+		if v := q.Get("enabled"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "invalid enabled", http.StatusBadRequest)
+				return
+			}
+			enabled = b
+		}
+		status := r.SetReadOnly(enabled, q.Get("reason"))
+		if enabled {
+			log.Printf("[READONLY] node %s is read-only: reason=%q", r.NodeID(), status.Reason)
+		} else {
+			log.Printf("[READONLY] node %s accepts writes again", r.NodeID())
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// HandleDiskStatus: GET /admin/disk
+// Uso dos diretórios de dados medido pelo guard de disco e se ele colocou o
+// nó em modo somente leitura.
+func HandleDiskStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.DiskStatus())
+	}
+}
+
+// HandleReadOnlyStatus: GET /admin/readonly
+func HandleReadOnlyStatus(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.ReadOnly())
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+
+	"github.com/gorilla/mux"
+)
+
+// HandleRepair: POST /admin/repair?primary=true&incremental=true
+// Inicia em background o repair dos intervalos que este nó replica: as
+// réplicas trocam listas de chave+timestamp e quem estiver desatualizado
+// recebe a versão mais nova. incremental=true só compara o que foi escrito
+// depois do último repair de cada intervalo. Retorna o job (202).
+func HandleRepair(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		job, err := r.StartRepair(q.Get("primary") == "true", q.Get("incremental") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleRepairJobs: GET /admin/repair
+// Atalho para GET /admin/jobs?kind=repair.
+func HandleRepairJobs(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Jobs().List("repair"))
+	}
+}
+
+// HandleRepairJob: GET /admin/repair/{id}
+func HandleRepairJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, ok := r.Jobs().Get(mux.Vars(req)["id"])
+		if !ok || job.Kind != "repair" {
+			http.Error(w, "repair job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// parseTokenRange lê ?start=&end= de um intervalo de tokens.
+func parseTokenRange(req *http.Request) (hashring.TokenRange, bool) {
+	q := req.URL.Query()
+	start, err1 := strconv.ParseUint(q.Get("start"), 10, 32)
+	end, err2 := strconv.ParseUint(q.Get("end"), 10, 32)
+	if err1 != nil || err2 != nil {
+		return hashring.TokenRange{}, false
+	}
+	return hashring.TokenRange{Start: uint32(start), End: uint32(end)}, true
+}
+
+// HandleInternalRepairVersions: GET /internal/repair/versions?start=&end=&since=&keyspace=
+func HandleInternalRepairVersions(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rng, ok := parseTokenRange(req)
+		if !ok {
+			http.Error(w, "invalid range", http.StatusBadRequest)
+			return
+		}
+		var since int64
+		if v := req.URL.Query().Get("since"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+			since = n
+		}
+		writeJSON(w, http.StatusOK, r.LocalVersions(rng, since, req.URL.Query().Get("keyspace")))
+	}
+}
+
+// HandleInternalKeyVersions: POST /internal/repair/versions
+// Corpo: lista de chaves; resposta: [{key, ts}] das que existem aqui (o
+// rebalance só manda a uma réplica as chaves que ela não tem).
+func HandleInternalKeyVersions(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var keys []string
+		if err := json.NewDecoder(req.Body).Decode(&keys); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, r.LocalKeyVersions(keys))
+	}
+}
+
+// HandleInternalRepairFetch: POST /internal/repair/fetch
+// Corpo: lista de chaves; resposta: as entradas locais delas.
+func HandleInternalRepairFetch(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var keys []string
+		if err := json.NewDecoder(req.Body).Decode(&keys); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, r.LocalRecords(keys))
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/jobs"
+)
+
+// HandleSetReplication: PUT /admin/replication/{keyspace}
+// Corpo: {"replication_factor": 3}. Muda o fator de replicação do keyspace
+// em todos os nós e inicia o job de re-replicação (acompanhe em GET
+// /admin/replication/{keyspace}: satisfied=true quando todas as chaves
+// estão nas réplicas do novo fator). 409 se outro job de re-replicação
+// estiver rodando.
+func HandleSetReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			ReplicationFactor int `json:"replication_factor"`
+		}
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&body); err != nil {
+			http.Error(w, `invalid json (want {"replication_factor": N})`, http.StatusBadRequest)
+			return
+		}
+		keyspace := mux.Vars(req)["keyspace"]
+		log.Printf("[REPLICATION] setting replication factor of keyspace %s to %d on all nodes", keyspace, body.ReplicationFactor)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		def, results, job, err := r.SetKeyspaceReplication(ctx, keyspace, body.ReplicationFactor)
+		writeReplicationResult(w, def, results, job, err)
+	}
+}
+
+// HandleResetReplication: DELETE /admin/replication/{keyspace}
+// Volta o keyspace ao REPLICATION_FACTOR em todos os nós (com o job de
+// re-replicação).
+func HandleResetReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		keyspace := mux.Vars(req)["keyspace"]
+		log.Printf("[REPLICATION] resetting replication factor of keyspace %s on all nodes", keyspace)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		def, results, job, err := r.ResetKeyspaceReplication(ctx, keyspace)
+		writeReplicationResult(w, def, results, job, err)
+	}
+}
+
+func writeReplicationResult(w http.ResponseWriter, def cluster.KeyspaceReplication, results []cluster.NodeResult, job jobs.Info, err error) {
+	switch {
+	case errors.Is(err, cluster.ErrInvalidReplication):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, cluster.ErrReplicationRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nodes, ok := indexNodes(results)
+	status := http.StatusAccepted
+	if !ok {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, map[string]interface{}{"replication": def, "nodes": nodes, "job": job})
+}
+
+// HandleListReplication: GET /admin/replication
+// O REPLICATION_FACTOR padrão e os keyspaces com outro fator.
+func HandleListReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"default_replication_factor": r.ReplicationFactor(),
+			"keyspaces":                  r.KeyspaceReplications(false),
+		})
+	}
+}
+
+// HandleGetReplication: GET /admin/replication/{keyspace}
+// Fator de replicação do keyspace e o último job de re-replicação disparado
+// por este nó.
+func HandleGetReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.ReplicationStatus(mux.Vars(req)["keyspace"]))
+	}
+}
+
+// HandleInternalReplication: GET /internal/replication
+// Definições deste nó, com as removidas (a fonte do RunReplicationSync).
+func HandleInternalReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.KeyspaceReplications(true))
+	}
+}
+
+// HandleInternalApplyReplication: POST /internal/replication
+// Aplica uma definição vinda do coordenador que a mudou (ignorada se a deste
+// nó for mais nova).
+func HandleInternalApplyReplication(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var def cluster.KeyspaceReplication
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&def); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		applied, err := r.ApplyLocalReplication(def)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"applied": applied})
+	}
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+// scanFlushEvery é de quantas em quantas chaves o scan empurra a resposta
+// para o cliente.
+const scanFlushEvery = 100
+
+// scanItem é uma linha do scan do cluster.
+type scanItem struct {
+	Key         string            `json:"key"`
+	Value       string            `json:"value"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Timestamp   int64             `json:"ts"`
+	ExpiresAt   int64             `json:"expires_at,omitempty"`
+}
+
+// scanItemFrom é a linha de rec, com os metadados separados do valor.
+func scanItemFrom(rec cluster.Record) scanItem {
+	value, meta := kv.SplitMeta(rec.Value)
+	return scanItem{Key: rec.Key, Value: value, ContentType: meta.ContentType, Metadata: meta.Headers, Timestamp: rec.Timestamp, ExpiresAt: rec.ExpiresAt}
+}
+
+// scanSummary é a última linha do scan.
+type scanSummary struct {
+	Done bool `json:"done"`
+	cluster.ScanStats
+	Error string `json:"error,omitempty"`
+}
+
+// HandleScan: GET /v1/scan?prefix=&limit=&parallel=
+// Scan do cluster inteiro: o coordenador pede a cada nó as chaves dos
+// intervalos dos quais ele é réplica primária (repetindo nas outras réplicas
+// os intervalos que falharem) e responde em NDJSON, uma chave por linha
+// conforme chegam, sem montar o resultado na memória. A última linha é o
+// resumo ({"done":true,...}), com os intervalos que nenhuma réplica entregou;
+// sem ela, a resposta foi cortada.
+func HandleScan(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		opts := cluster.ScanOptions{Prefix: q.Get("prefix")}
+		for name, dst := range map[string]*int{"limit": &opts.Limit, "parallel": &opts.Parallelism} {
+			if v := q.Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					http.Error(w, "invalid "+name, http.StatusBadRequest)
+					return
+				}
+				*dst = n
+			}
+		}
+		if !aclAllows(req, opts.Prefix, aclRead) {
+			aclDeny(w, req, opts.Prefix, aclRead)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		log.Printf("[API] SCAN prefix=%q limit=%d", opts.Prefix, opts.Limit)
+		metrics.Inc("client.scan")
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		n := 0
+		stats, err := r.Scan(ctx, opts, func(rec cluster.Record) error {
+			if err := enc.Encode(scanItemFrom(rec)); err != nil {
+				return err
+			}
+			if n++; n%scanFlushEvery == 0 {
+				rc.Flush()
+			}
+			return nil
+		})
+		sum := scanSummary{Done: true, ScanStats: stats}
+		if err != nil {
+			log.Printf("[ERROR] SCAN prefix=%q err=%v", opts.Prefix, err)
+			sum.Error = err.Error()
+		}
+		enc.Encode(sum)
+	}
+}
+
+// HandleInternalScan: POST /internal/scan
+// Corpo: cluster.ScanRequest. Responde em NDJSON (um cluster.Record por
+// linha) as chaves vivas locais dos intervalos pedidos, em streaming.
+func HandleInternalScan(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var sr cluster.ScanRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		n := 0
+		r.LocalScan(sr, func(rec cluster.Record) error {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			if n++; n%scanFlushEvery == 0 {
+				rc.Flush()
+			}
+			return nil
+		})
+	}
+}
+
+// HandleAggregate: GET /v1/aggregate?prefix=&field=&match=&where=&parallel=
+// COUNT das chaves (com o prefixo e que passam em match/where, como no
+// /debug/keys) e SUM, MIN, MAX e AVG do campo numérico field dos valores
+// JSON, calculados em cada nó sobre os intervalos dos quais ele é réplica
+// primária: só os agregados parciais passam pela rede. Com partial=true,
+// algum intervalo não foi contado (ver failed).
+func HandleAggregate(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		opts := cluster.AggregateOptions{Prefix: q.Get("prefix"), Match: q.Get("match"), Where: q["where"], Field: q.Get("field")}
+		if v := q.Get("parallel"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid parallel", http.StatusBadRequest)
+				return
+			}
+			opts.Parallelism = n
+		}
+		if _, err := kv.ParseFilter(opts.Match, opts.Where); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !aclAllows(req, opts.Prefix, aclRead) {
+			aclDeny(w, req, opts.Prefix, aclRead)
+			return
+		}
+		ctx, cancel, err := requestContext(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		log.Printf("[API] AGGREGATE prefix=%q field=%q", opts.Prefix, opts.Field)
+		metrics.Inc("client.aggregate")
+
+		res, err := r.AggregateCluster(ctx, opts)
+		if err != nil {
+			log.Printf("[ERROR] AGGREGATE prefix=%q err=%v", opts.Prefix, err)
+			http.Error(w, err.Error(), readErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleInternalAggregate: POST /internal/aggregate
+// Corpo: cluster.AggregateRequest; resposta: o agregado parcial local.
+func HandleInternalAggregate(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var ar cluster.AggregateRequest
+		if err := json.NewDecoder(req.Body).Decode(&ar); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		agg, err := r.LocalAggregate(ar)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, agg)
+	}
+}
+
+// scanJobSummary é a última linha dos resultados de um job de scan.
+type scanJobSummary struct {
+	Done      bool `json:"done"`
+	Results   int  `json:"results"`
+	Truncated bool `json:"truncated"`
+}
+
+// HandleStartScanJob: POST /v1/scan/jobs
+// Corpo: cluster.ScanJobSpec ({"prefix","match","where","fields","output",
+// "max_results","parallel"}). Inicia o job em background e responde 202 com
+// ele; acompanhe em GET /v1/scan/jobs/{id} neste mesmo nó.
+func HandleStartScanJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var spec cluster.ScanJobSpec
+		if err := json.NewDecoder(req.Body).Decode(&spec); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if !aclAllows(req, spec.Prefix, aclRead) {
+			aclDeny(w, req, spec.Prefix, aclRead)
+			return
+		}
+		if out := spec.Output + kv.KeyspaceSep; spec.Output != "" && !aclAllows(req, out, aclWrite) {
+			aclDeny(w, req, out, aclWrite)
+			return
+		}
+		id, err := r.StartScanJob(spec)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[API] SCAN JOB %s prefix=%q output=%q", id, spec.Prefix, spec.Output)
+		job, _ := r.ScanJob(id)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// scanJobVisible diz se a requisição pode ver o job: com ACL, precisa de
+// leitura no prefixo que ele varre.
+func scanJobVisible(req *http.Request, job jobs.Info) bool {
+	detail, _ := job.Detail.(cluster.ScanJobDetail)
+	return aclAllows(req, detail.Spec.Prefix, aclRead)
+}
+
+// HandleScanJobs: GET /v1/scan/jobs
+func HandleScanJobs(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		list := r.ScanJobs()
+		visible := list[:0]
+		for _, job := range list {
+			if scanJobVisible(req, job) {
+				visible = append(visible, job)
+			}
+		}
+		writeJSON(w, http.StatusOK, visible)
+	}
+}
+
+// HandleScanJob: GET /v1/scan/jobs/{id}
+// Progresso (intervalos processados/total), contagens em detail e, no fim,
+// o status (done, failed com os intervalos perdidos, cancelled).
+func HandleScanJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, ok := r.ScanJob(mux.Vars(req)["id"])
+		if !ok || !scanJobVisible(req, job) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleCancelScanJob: DELETE /v1/scan/jobs/{id}
+func HandleCancelScanJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		if job, ok := r.ScanJob(id); !ok || !scanJobVisible(req, job) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if err := r.Jobs().Cancel(id); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		job, _ := r.ScanJob(id)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleScanJobResults: GET /v1/scan/jobs/{id}/results
+// Os resultados guardados de um job sem keyspace de saída, em NDJSON como o
+// /v1/scan, com uma linha final de resumo. 409 enquanto o job roda; 404 se o
+// job gravou num keyspace de saída ou os resultados já foram descartados.
+func HandleScanJobResults(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		if job, ok := r.ScanJob(id); ok && !scanJobVisible(req, job) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		recs, err := r.ScanJobResults(id)
+		if err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, cluster.ErrScanJobRunning) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		job, _ := r.ScanJob(id)
+		detail, _ := job.Detail.(cluster.ScanJobDetail)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, rec := range recs {
+			if err := enc.Encode(scanItemFrom(rec)); err != nil {
+				return
+			}
+		}
+		enc.Encode(scanJobSummary{Done: true, Results: len(recs), Truncated: detail.Truncated})
+	}
+}
+
+// HandleInternalScanJob: POST /internal/scanjob
+// Corpo: cluster.ScanJobRequest; resposta: cluster.ScanJobPart.
+func HandleInternalScanJob(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var sr cluster.ScanJobRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		part, err := r.LocalScanJob(req.Context(), sr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, part)
+	}
+}
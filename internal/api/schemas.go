@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleSetSchema: PUT /admin/schemas/{keyspace}
+// Corpo: {"schema": <JSON Schema>, "min_bytes", "max_bytes",
+// "content_types": [...]} (todos opcionais). Registra as restrições do
+// keyspace em todos os nós; a partir daí cada coordenador recusa (422, ou
+// 415 para o Content-Type) as escritas que não passam, antes de replicar.
+func HandleSetSchema(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var def cluster.KeyspaceSchema
+		if err := json.Unmarshal(body, &def); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		def.Keyspace = mux.Vars(req)["keyspace"]
+		if err := cluster.ValidateSchema(def); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[SCHEMA] registering schema of keyspace %s on all nodes", def.Keyspace)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		def, results, err := r.SetSchema(ctx, def)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		nodes, ok := indexNodes(results)
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusBadGateway
+		}
+		writeJSON(w, status, map[string]interface{}{"schema": def, "nodes": nodes})
+	}
+}
+
+// HandleDropSchema: DELETE /admin/schemas/{keyspace}
+// Remove as restrições do keyspace de todos os nós.
+func HandleDropSchema(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		keyspace := mux.Vars(req)["keyspace"]
+		log.Printf("[SCHEMA] dropping schema of keyspace %s on all nodes", keyspace)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		results, err := r.DropSchema(ctx, keyspace)
+		if errors.Is(err, cluster.ErrSchemaNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		nodes, ok := indexNodes(results)
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusBadGateway
+		}
+		writeJSON(w, status, map[string]interface{}{"keyspace": keyspace, "nodes": nodes})
+	}
+}
+
+// HandleListSchemas: GET /admin/schemas
+// Restrições registradas neste nó.
+func HandleListSchemas(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Schemas(false))
+	}
+}
+
+// HandleGetSchema: GET /admin/schemas/{keyspace}
+func HandleGetSchema(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		def, ok := r.Schema(mux.Vars(req)["keyspace"])
+		if !ok {
+			http.Error(w, cluster.ErrSchemaNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, def)
+	}
+}
+
+// HandleInternalSchemas: GET /internal/schemas
+// Definições deste nó, com as removidas (a fonte do RunSchemaSync).
+func HandleInternalSchemas(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Schemas(true))
+	}
+}
+
+// HandleInternalApplySchema: POST /internal/schemas
+// Aplica uma definição vinda do coordenador que a registrou (ignorada se a
+// deste nó for mais nova).
+func HandleInternalApplySchema(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var def cluster.KeyspaceSchema
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&def); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		applied, err := r.ApplyLocalSchema(def)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"applied": applied})
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// HandleListSettings: GET /admin/settings
+// Configurações de runtime deste nó: valor em vigor, valor do env e de onde
+// veio o valor (env ou cluster).
+func HandleListSettings(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"node_id": r.NodeID(), "settings": r.Settings()})
+	}
+}
+
+// HandleSetSetting: PUT /admin/settings/{name}
+// Corpo: {"value": 0.5} (número ou string: "250ms"). Muda a configuração em
+// todos os nós; vale mais que o env até um DELETE.
+func HandleSetSetting(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&body); err != nil || len(body.Value) == 0 {
+			http.Error(w, `invalid json (want {"value": ...})`, http.StatusBadRequest)
+			return
+		}
+		value := string(body.Value)
+		if strings.HasPrefix(value, `"`) {
+			if err := json.Unmarshal(body.Value, &value); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+		}
+		name := mux.Vars(req)["name"]
+		log.Printf("[SETTINGS] setting %s = %s on all nodes", name, value)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		def, results, err := r.SetSetting(ctx, name, value)
+		writeSettingResult(w, def, results, err)
+	}
+}
+
+// HandleResetSetting: DELETE /admin/settings/{name}
+// Volta a configuração ao valor do env de cada nó, em todos os nós.
+func HandleResetSetting(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["name"]
+		log.Printf("[SETTINGS] resetting %s to the env value on all nodes", name)
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		def, results, err := r.ResetSetting(ctx, name)
+		writeSettingResult(w, def, results, err)
+	}
+}
+
+func writeSettingResult(w http.ResponseWriter, def cluster.ClusterSetting, results []cluster.NodeResult, err error) {
+	switch {
+	case errors.Is(err, cluster.ErrUnknownSetting):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, cluster.ErrInvalidSetting):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nodes, ok := indexNodes(results)
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, map[string]interface{}{"setting": def, "nodes": nodes})
+}
+
+// HandleInternalSettings: GET /internal/settings
+// Definições do cluster deste nó, com os resets (a fonte do RunSettingsSync).
+func HandleInternalSettings(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.ClusterSettings())
+	}
+}
+
+// HandleInternalApplySetting: POST /internal/settings
+// Aplica uma definição vinda do coordenador que a mudou (ignorada se a deste
+// nó for mais nova).
+func HandleInternalApplySetting(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var def cluster.ClusterSetting
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&def); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		applied, err := r.ApplyLocalSetting(def)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"applied": applied})
+	}
+}
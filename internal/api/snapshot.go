@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"mini-cassandra/internal/backup"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+)
+
+type snapshotPrepareReq struct {
+	ID    string `json:"id"`
+	Fence int64  `json:"fence"`
+}
+
+type snapshotCommitReq struct {
+	ID string `json:"id"`
+}
+
+// clusterSnapshotNode é o resultado de um nó no snapshot coordenado.
+type clusterSnapshotNode struct {
+	NodeID  string `json:"node_id"`
+	Name    string `json:"name,omitempty"`
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+	Error   string `json:"error,omitempty"`
+}
+
+// clusterSnapshotManifest é gravado pelo coordenador em _cluster/<id>.manifest.json.
+type clusterSnapshotManifest struct {
+	ID          string                `json:"id"`
+	Fence       int64                 `json:"fence"`
+	Coordinator string                `json:"coordinator"`
+	CreatedAt   time.Time             `json:"created_at"`
+	Complete    bool                  `json:"complete"`
+	Nodes       []clusterSnapshotNode `json:"nodes"`
+}
+
+// HandleClusterSnapshot: POST /admin/snapshot?id=...
+// Snapshot coordenado de todos os nós em duas fases:
+//  1. prepare: cada nó congela as escritas e registra o mesmo fence (timestamp de corte);
+//  2. commit: com todos congelados, cada nó tira o snapshot com o ID comum,
+//     libera as escritas e envia o snapshot para o seu destino.
+//
+// Se algum nó falhar no prepare, todos recebem abort e nada é gravado.
+func HandleClusterSnapshot(r *cluster.Router, m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			id = backup.NewSnapshotID()
+		}
+		if err := backup.ValidateName("id", id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fence := kv.Now()
+
+		log.Printf("[SNAPSHOT] cluster snapshot %s: preparing fence=%d", id, fence)
+
+		prepareBody, _ := json.Marshal(snapshotPrepareReq{ID: id, Fence: fence})
+		prepareCtx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+		prepared := r.Broadcast(prepareCtx, "POST", "/internal/snapshot/prepare", prepareBody)
+		cancel()
+
+		commitBody, _ := json.Marshal(snapshotCommitReq{ID: id})
+
+		manifest := clusterSnapshotManifest{
+			ID:          id,
+			Fence:       fence,
+			Coordinator: string(r.NodeID()),
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		for _, res := range prepared {
+			if !res.OK() {
+				log.Printf("[SNAPSHOT] cluster snapshot %s: prepare failed on %s: %s", id, res.Node.ID, res.Error())
+				r.Broadcast(context.Background(), "POST", "/internal/snapshot/abort", commitBody)
+				for _, res := range prepared {
+					manifest.Nodes = append(manifest.Nodes, clusterSnapshotNode{NodeID: string(res.Node.ID), Error: res.Error()})
+				}
+				writeJSON(w, http.StatusBadGateway, manifest)
+				return
+			}
+		}
+
+		committed := r.Broadcast(req.Context(), "POST", "/internal/snapshot/commit", commitBody)
+		manifest.Complete = true
+		for _, res := range committed {
+			node := clusterSnapshotNode{NodeID: string(res.Node.ID)}
+			if !res.OK() {
+				manifest.Complete = false
+				node.Error = res.Error()
+			} else {
+				var out backup.Result
+				json.Unmarshal(res.Body, &out)
+				node.Name, node.Entries, node.Bytes = out.Name, out.Entries, out.Bytes
+			}
+			manifest.Nodes = append(manifest.Nodes, node)
+		}
+
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(manifest)
+		if err := m.Target().Put(req.Context(), "_cluster/"+id+".manifest.json", buf.Bytes()); err != nil {
+			log.Printf("[SNAPSHOT] cluster snapshot %s: manifest upload failed: %v", id, err)
+		}
+
+		log.Printf("[SNAPSHOT] cluster snapshot %s finished: nodes=%d complete=%v", id, len(manifest.Nodes), manifest.Complete)
+
+		status := http.StatusOK
+		if !manifest.Complete {
+			status = http.StatusBadGateway
+		}
+		writeJSON(w, status, manifest)
+	}
+}
+
+// HandleSnapshotPrepare: POST /internal/snapshot/prepare
+func HandleSnapshotPrepare(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotPrepareReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := m.Prepare(req.ID, req.Fence); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleSnapshotCommit: POST /internal/snapshot/commit
+func HandleSnapshotCommit(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotCommitReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		res, err := m.Commit(r.Context(), req.ID)
+		if err != nil {
+			log.Printf("[SNAPSHOT] commit %s failed: %v", req.ID, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleSnapshotAbort: POST /internal/snapshot/abort
+func HandleSnapshotAbort(m *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotCommitReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		m.Abort(req.ID)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hll"
+	"mini-cassandra/internal/kv"
+)
+
+type localStats struct {
+	NodeID                  string `json:"node_id"`
+	Keys                    int    `json:"keys"`
+	ApproxUniqueKeysWritten uint64 `json:"approx_unique_keys_written"`
+
+	ReadRepair cluster.ReadRepairStats `json:"read_repair"`
+	TTLSweeper kv.SweepStats           `json:"ttl_sweeper"`
+}
+
+type clusterStats struct {
+	ApproxUniqueKeys uint64   `json:"approx_unique_keys"`
+	NodesMerged      int      `json:"nodes_merged"`
+	Unreachable      []string `json:"unreachable,omitempty"`
+}
+
+type statsResponse struct {
+	Local   localStats   `json:"local"`
+	Cluster clusterStats `json:"cluster"`
+}
+
+type hllResponse struct {
+	NodeID    string `json:"node_id"`
+	Registers []byte `json:"registers"`
+}
+
+// HandleStats: GET /admin/stats
+// Estatísticas do nó e do cluster. O número aproximado de chaves distintas
+// do cluster vem da união dos sketches HyperLogLog de cada nó (custo fixo,
+// sem varrer chaves); ele conta chaves já gravadas, inclusive as apagadas.
+func HandleStats(r *cluster.Router, store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		out := statsResponse{
+			Local: localStats{
+				NodeID:                  string(r.NodeID()),
+				Keys:                    store.Len(),
+				ApproxUniqueKeysWritten: store.WrittenKeys().Estimate(),
+				ReadRepair:              r.ReadRepairStats(),
+				TTLSweeper:              store.SweepStats(),
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+		defer cancel()
+
+		merged := hll.New()
+		for _, res := range r.Broadcast(ctx, "GET", "/internal/stats/hll", nil) {
+			var h hllResponse
+			if !res.OK() || json.Unmarshal(res.Body, &h) != nil || merged.MergeRegisters(h.Registers) != nil {
+				out.Cluster.Unreachable = append(out.Cluster.Unreachable, string(res.Node.ID))
+				continue
+			}
+			out.Cluster.NodesMerged++
+		}
+		out.Cluster.ApproxUniqueKeys = merged.Estimate()
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleInternalHLL: GET /internal/stats/hll
+// Devolve os registradores do sketch de chaves gravadas deste nó.
+func HandleInternalHLL(r *cluster.Router, store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, hllResponse{
+			NodeID:    string(r.NodeID()),
+			Registers: store.WrittenKeys().Registers(),
+		})
+	}
+}
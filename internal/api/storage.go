@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/storage"
+)
+
+// HandleFlush: POST /admin/flush?keyspace=a,b
+// Grava em disco o checkpoint dos keyspaces informados (ou de todos).
+func HandleFlush(e *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var keyspaces []string
+		if v := r.URL.Query().Get("keyspace"); v != "" {
+			keyspaces = strings.Split(v, ",")
+		}
+
+		results, err := e.Flush(keyspaces)
+		if err != nil {
+			log.Printf("[FLUSH] failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// HandleCompact: POST /admin/compact?keyspace=...
+// Compactação major sob demanda (de todos os keyspaces ou de um só),
+// respondendo com os bytes recuperados em disco.
+func HandleCompact(e *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		// os checkpoints são por keyspace: não há arquivo por faixa de tokens pra reescrever
+		if q.Get("range") != "" || q.Get("start_token") != "" || q.Get("end_token") != "" {
+			http.Error(w, "token-range compaction is not supported: data files are per keyspace", http.StatusBadRequest)
+			return
+		}
+
+		res, err := e.Compact(q.Get("keyspace"))
+		if err != nil {
+			log.Printf("[COMPACT] failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleStorageStats: GET /admin/storage
+// Uso de disco do nó: arquivos e bytes por keyspace, tamanho do WAL,
+// compactação pendente, bytes compactados e write amplification.
+func HandleStorageStats(e *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, e.Stats())
+	}
+}
+
+// HandleScrub: POST /admin/scrub?max_future=1h
+// Relê todos os dados locais conferindo checksums e timestamps; checkpoints e
+// registros do WAL corrompidos vão para a quarentena e são regravados (o
+// checkpoint a partir da memória) ou descartados (o registro do WAL).
+func HandleScrub(e *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxFuture := storage.DefaultScrubMaxFuture
+		if v := r.URL.Query().Get("max_future"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "invalid max_future", http.StatusBadRequest)
+				return
+			}
+			maxFuture = d
+		}
+
+		res, err := e.Scrub(maxFuture)
+		if err != nil {
+			log.Printf("[SCRUB] failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+type drainResponse struct {
+	Draining        bool                  `json:"draining"`
+	InflightAtDrain int                   `json:"inflight_at_drain"`
+	Flushed         []storage.FlushResult `json:"flushed"`
+}
+
+// HandleDrain: POST /admin/drain
+// Para de aceitar escritas de clientes, espera as replicações em andamento
+// terminarem e faz flush de todos os keyspaces. O processo continua no ar
+// (leituras e tráfego de réplica seguem funcionando) até ser reiniciado.
+func HandleDrain(r *cluster.Router, e *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Printf("[DRAIN] draining node %s", r.NodeID())
+
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		inflight, err := r.Drain(ctx)
+		if err != nil {
+			log.Printf("[DRAIN] in-flight writes did not finish: %v", err)
+			http.Error(w, "in-flight writes did not finish: "+err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+
+		flushed, err := e.Flush(nil)
+		if err != nil {
+			log.Printf("[DRAIN] flush failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("[DRAIN] node %s drained: inflight=%d keyspaces=%d", r.NodeID(), inflight, len(flushed))
+		writeJSON(w, http.StatusOK, drainResponse{Draining: true, InflightAtDrain: inflight, Flushed: flushed})
+	}
+}
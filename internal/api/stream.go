@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/metrics"
+)
+
+// readValue lê o corpo de uma escrita direto para a string do valor (com os
+// limites do readBody): com Content-Length o valor é alocado uma vez só, sem
+// o []byte intermediário do io.ReadAll e a cópia da conversão para string.
+func readValue(w http.ResponseWriter, req *http.Request, max int64, what string) (string, bool) {
+	var sb strings.Builder
+	if !copyBody(w, req, max, what, &sb, sb.Grow) {
+		return "", false
+	}
+	metrics.Add("client.bytes_in", int64(sb.Len()))
+	return sb.String(), true
+}
+
+// writeValue responde 200 com o valor, em pedaços de bufpool.CopySize (num
+// buffer do pool), para não copiar o valor inteiro para um []byte a cada
+// resposta.
+func writeValue(w http.ResponseWriter, value string) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.WriteHeader(http.StatusOK)
+	buf := bufpool.GetCopy()
+	defer bufpool.PutCopy(buf)
+	for len(value) > 0 {
+		n := copy(*buf, value)
+		if _, err := w.Write((*buf)[:n]); err != nil {
+			// cliente foi embora
+			return
+		}
+		value = value[n:]
+	}
+}
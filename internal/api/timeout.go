@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// TimeoutHeader leva o prazo que o cliente dá para a operação (duração Go,
+// ex: "250ms", "5s"); também aceito em ?timeout=.
+const TimeoutHeader = "X-Timeout"
+
+// requestContext retorna o contexto da operação: o da requisição, com o
+// prazo pedido pelo cliente (limitado ao máximo do nó), se houver.
+func requestContext(r *cluster.Router, req *http.Request) (context.Context, context.CancelFunc, error) {
+	v := req.Header.Get(TimeoutHeader)
+	if v == "" {
+		v = req.URL.Query().Get("timeout")
+	}
+	if v == "" {
+		ctx, cancel := context.WithCancel(req.Context())
+		return ctx, cancel, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return nil, nil, fmt.Errorf("invalid timeout %q (use a duration like 500ms or 5s)", v)
+	}
+	if max := r.MaxRequestTimeout(); d > max {
+		d = max
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	return ctx, cancel, nil
+}
+
+// readErrorStatus é o status de uma leitura que falhou: 504 se acabou o
+// prazo, 503 se não havia réplicas vivas para o nível, 502 nos outros casos.
+func readErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, cluster.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/cluster"
+)
+
+type tokenInfo struct {
+	Token uint32 `json:"token"`
+	Start uint32 `json:"start"`
+	Owner string `json:"owner"`
+}
+
+// HandleTokens: GET /admin/tokens?node=node2
+// Lista os tokens (vnodes) do anel e seus donos, opcionalmente de um nó só.
+func HandleTokens(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		node := req.URL.Query().Get("node")
+		out := []tokenInfo{}
+		for _, t := range r.Ranges() {
+			if node != "" && string(t.Owner.ID) != node {
+				continue
+			}
+			out = append(out, tokenInfo{Token: t.End, Start: t.Start, Owner: string(t.Owner.ID)})
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleMoveToken: POST /admin/move-token?token=123456&to=node2
+// Move um token (vnode) para outro nó em runtime, transferindo os dados do
+// intervalo, para corrigir hot spots sem decommission/re-add.
+func HandleMoveToken(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		token, err := strconv.ParseUint(q.Get("token"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusBadRequest)
+			return
+		}
+		to := q.Get("to")
+		if to == "" {
+			http.Error(w, "missing to", http.StatusBadRequest)
+			return
+		}
+
+		res, err := r.MoveToken(req.Context(), uint32(token), hashring.NodeID(to))
+		if err != nil {
+			log.Printf("[MOVE] token %d to %s failed: %v", token, to, err)
+			if res == nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusBadGateway, struct {
+				*cluster.MoveResult
+				Error string `json:"error"`
+			}{res, err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleInternalRingToken: POST /internal/ring/token
+func HandleInternalRingToken(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body cluster.TokenOwnerRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		node := hashring.NodeInfo{ID: body.Node, Host: body.Host}
+		if err := r.SetTokenOwner(body.Token, node); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.AdoptRingEpoch(req)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleInternalRing: GET /internal/ring
+// O ring deste nó (dono de cada token e endereço de cada nó), lido no boot
+// pelos nós que reiniciam (RING_SYNC_ON_START).
+func HandleInternalRing(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.RingSnapshot())
+	}
+}
+
+// HandleInternalRingJoin: POST /internal/ring/join
+// Um nó novo (SEEDS) se anunciando: entra no ring deste nó.
+func HandleInternalRingJoin(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var node hashring.NodeInfo
+		if err := json.NewDecoder(req.Body).Decode(&node); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := r.AddMember(node); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.AdoptRingEpoch(req)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleInternalStreamRange: POST /internal/stream/range
+// Envia as entradas locais de um intervalo de tokens para outro nó.
+func HandleInternalStreamRange(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body cluster.StreamRangeRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Target.Host == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		stats, err := r.StreamRangeTo(req.Context(), body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Printf("[STREAM] range (%d,%d] sent to %s: keys=%d bytes=%d", body.Start, body.End, body.Target.ID, stats.Keys, stats.Bytes)
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// HandleInternalStreamApply: POST /internal/stream/apply
+// Recebe um lote de entradas (com timestamps) vindas de streaming.
+func HandleInternalStreamApply(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var records []cluster.Record
+		if err := json.NewDecoder(req.Body).Decode(&records); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"applied": r.ApplyStream(records)})
+	}
+}
+
+// HandleInternalCleanup: POST /internal/cleanup
+// Apaga as chaves locais das quais este nó não é mais réplica.
+func HandleInternalCleanup(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]int{"removed": r.CleanupLocal()})
+	}
+}
+
+// HandleInternalRingReplace: POST /internal/ring/replace
+// Passa todos os tokens de um nó morto para o nó que o substituiu.
+func HandleInternalRingReplace(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body cluster.ReplaceRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Dead == "" || body.Node.Host == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		n, err := r.ReplaceTokens(body.Dead, body.Node)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.AdoptRingEpoch(req)
+		writeJSON(w, http.StatusOK, map[string]int{"tokens": n})
+	}
+}
+
+// HandleVNodes: GET /admin/vnodes
+// Número de vnodes e de tokens de cada nó do ring.
+func HandleVNodes(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"default_vnodes": r.VNodes(), "nodes": r.NodeVNodes()})
+	}
+}
+
+// HandleSetVNodes: POST /admin/vnodes
+// Corpo: {"node": "node2", "vnodes": 150} (sem node, este nó). Muda o número
+// de vnodes do nó em runtime, enviando os trechos ganhos ou perdidos para as
+// novas réplicas (job "vnodes" em /admin/jobs). 409 se outra mudança de
+// vnodes estiver rodando.
+func HandleSetVNodes(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Node   string `json:"node"`
+			VNodes int    `json:"vnodes"`
+		}
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&body); err != nil {
+			http.Error(w, `invalid json (want {"node": "...", "vnodes": N})`, http.StatusBadRequest)
+			return
+		}
+		node := hashring.NodeID(body.Node)
+		if node == "" {
+			node = r.NodeID()
+		}
+		job, err := r.StartVNodesChange(node, body.VNodes)
+		switch {
+		case errors.Is(err, cluster.ErrInvalidVNodes):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, cluster.ErrVNodesRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[VNODES] changing vnodes of %s to %d (%s)", node, body.VNodes, job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// HandleInternalRingVNodes: POST /internal/ring/vnodes
+// Muda localmente o número de vnodes de um nó (passo 2 do job "vnodes").
+func HandleInternalRingVNodes(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body cluster.VNodesRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Node == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := r.SetNodeVNodes(body.Node, body.VNodes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.AdoptRingEpoch(req)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandlePendingRanges: GET /admin/pending-ranges
+// Os intervalos pendentes deste nó: a mudança de topologia em andamento,
+// quantos trechos têm réplicas pendentes e quais nós recebem as escritas
+// deles além das réplicas atuais.
+func HandlePendingRanges(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		info, ok := r.PendingRangesStatus()
+		if !ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"pending": nil})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"pending": info})
+	}
+}
+
+// HandleInternalSetPendingRanges: POST /internal/ring/pending
+// O ring que uma mudança de topologia em andamento vai deixar, anunciado
+// pelo coordenador dela antes do streaming.
+func HandleInternalSetPendingRanges(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body cluster.PendingRanges
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := r.SetPendingRanges(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// HandleInternalClearPendingRanges: DELETE /internal/ring/pending?id=...
+// Fim da mudança: as escritas voltam a ir só para as réplicas do ring.
+func HandleInternalClearPendingRanges(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]bool{"cleared": r.ClearPendingRanges(req.URL.Query().Get("id"))})
+	}
+}
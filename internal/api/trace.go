@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// TraceMiddleware liga o tracing nas requisições de cliente com
+// ?trace=true: o trace vai no contexto (o coordenador registra nele a linha
+// do tempo das réplicas), o ID volta no header X-MC-Trace-Id e, no fim, o
+// trace fica guardado em GET /admin/traces/{id}.
+func TraceMiddleware(r *cluster.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Query().Get("trace") != "true" || strings.HasPrefix(req.URL.Path, "/internal/") {
+				next.ServeHTTP(w, req)
+				return
+			}
+			route := routeTemplate(req)
+			if route == "" {
+				route = req.URL.Path
+			}
+			ctx, tr := r.StartTrace(req.Context(), req.Method+" "+route, pathKey(req))
+			w.Header().Set(cluster.TraceIDHeader, tr.ID)
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, req.WithContext(ctx))
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			r.FinishTrace(tr, rec.status)
+			log.Printf("[TRACE] %s %s status=%d trace=%s", req.Method, req.URL.Path, rec.status, tr.ID)
+		})
+	}
+}
+
+// HandleTraces: GET /admin/traces
+// Os últimos traces coordenados por este nó, do mais novo para o mais antigo.
+func HandleTraces(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		traces := r.Traces()
+		out := make([]*cluster.Trace, len(traces))
+		for i, t := range traces {
+			out[i] = t.Snapshot()
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// HandleTrace: GET /admin/traces/{id}
+func HandleTrace(r *cluster.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		t, ok := r.Trace(mux.Vars(req)["id"])
+		if !ok {
+			http.Error(w, "trace not found (only the coordinator keeps it, and only the last 100)", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, t.Snapshot())
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+)
+
+// truncateTokenTTL é quanto tempo o token de confirmação do truncate vale.
+const truncateTokenTTL = 60 * time.Second
+
+type truncateReq struct {
+	Keyspace string `json:"keyspace"`
+}
+
+type truncatePending struct {
+	keyspace string
+	expires  time.Time
+}
+
+type truncateNode struct {
+	NodeID string `json:"node_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleTruncate: POST /admin/truncate?keyspace=...&confirm=...
+// Apaga todos os dados de um keyspace em todos os nós. A primeira chamada
+// (sem confirm) só devolve um token de confirmação; o truncate acontece
+// quando a chamada é repetida com ?confirm=<token> dentro de 60s.
+func HandleTruncate(r *cluster.Router) http.HandlerFunc {
+	var (
+		mu      sync.Mutex
+		pending = make(map[string]truncatePending)
+	)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		keyspace := q.Get("keyspace")
+		if keyspace == "" {
+			http.Error(w, "missing keyspace", http.StatusBadRequest)
+			return
+		}
+
+		token := q.Get("confirm")
+		if token == "" {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			token = hex.EncodeToString(buf)
+
+			mu.Lock()
+			now := time.Now()
+			for t, p := range pending {
+				if now.After(p.expires) {
+					delete(pending, t)
+				}
+			}
+			pending[token] = truncatePending{keyspace: keyspace, expires: now.Add(truncateTokenTTL)}
+			mu.Unlock()
+
+			writeJSON(w, http.StatusAccepted, map[string]interface{}{
+				"keyspace":        keyspace,
+				"confirm_token":   token,
+				"expires_seconds": int(truncateTokenTTL.Seconds()),
+			})
+			return
+		}
+
+		mu.Lock()
+		p, ok := pending[token]
+		delete(pending, token)
+		mu.Unlock()
+		if !ok || time.Now().After(p.expires) || p.keyspace != keyspace {
+			http.Error(w, "invalid or expired confirm token", http.StatusForbidden)
+			return
+		}
+
+		log.Printf("[TRUNCATE] truncating keyspace=%s on all nodes", keyspace)
+
+		body, _ := json.Marshal(truncateReq{Keyspace: keyspace})
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		results := r.Broadcast(ctx, "POST", "/internal/truncate", body)
+
+		status := http.StatusOK
+		nodes := make([]truncateNode, 0, len(results))
+		for _, res := range results {
+			n := truncateNode{NodeID: string(res.Node.ID)}
+			if !res.OK() {
+				n.Error = res.Error()
+				status = http.StatusBadGateway
+				log.Printf("[TRUNCATE] keyspace=%s failed on %s: %s", keyspace, res.Node.ID, n.Error)
+			}
+			nodes = append(nodes, n)
+		}
+
+		writeJSON(w, status, map[string]interface{}{
+			"keyspace": keyspace,
+			"nodes":    nodes,
+		})
+	}
+}
+
+// HandleInternalTruncate: POST /internal/truncate
+// Apaga localmente todas as chaves do keyspace.
+func HandleInternalTruncate(store *kv.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req truncateReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Keyspace == "" {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[REPLICA] TRUNCATE keyspace=%s", req.Keyspace)
+		store.ClearKeyspace(req.Keyspace)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import "net/http"
+
+// APIVersion é o prefixo atual da API de cliente (/v1/kv/{key}). Mudanças
+// incompatíveis entram num prefixo novo, sem quebrar quem usa este.
+const APIVersion = "/v1"
+
+// LegacyPath envolve um handler servido também no caminho antigo, sem
+// versão (/kv/{key}): a resposta é a mesma da v1, com os headers Deprecation
+// e Link apontando para o caminho versionado.
+func LegacyPath(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+APIVersion+req.URL.EscapedPath()+`>; rel="successor-version"`)
+		h(w, req)
+	}
+}
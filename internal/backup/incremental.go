@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/wal"
+)
+
+// IncrementalManifest descreve um backup incremental: os segmentos de WAL
+// fechados desde o backup anterior (Parent), encadeados até um snapshot
+// completo (Base). No restore a cadeia é percorrida de volta até o Base.
+type IncrementalManifest struct {
+	ID        string    `json:"id"`
+	NodeID    string    `json:"node_id"`
+	Base      string    `json:"base"`
+	Parent    string    `json:"parent"`
+	CreatedAt time.Time `json:"created_at"`
+	Segments  []string  `json:"segments"`
+	// WALSeq é o primeiro segmento que ainda não entrou na cadeia.
+	WALSeq uint64 `json:"wal_seq"`
+}
+
+// ErrIncrementalOrder: o ID de um incremental tem que vir depois (em ordem de
+// string, como os de NewSnapshotID) do backup anterior. Dois incrementais no
+// mesmo segundo teriam o mesmo ID, e o manifesto apontaria para si mesmo.
+var ErrIncrementalOrder = errors.New("incremental id must sort after the previous backup")
+
+// IncrementalName monta o nome do manifesto de um backup incremental.
+func IncrementalName(nodeID, id string) string {
+	return path.Join(nodeID, id+".incremental.json")
+}
+
+func segmentObjectName(nodeID, segment string) string {
+	return path.Join(nodeID, "wal", segment)
+}
+
+// WALArchive prepara o arquivamento remoto do WAL de um nó no target: os
+// segmentos vão para <nó>/wal/<segmento>, os mesmos objetos dos backups
+// incrementais. Retorna a função de envio e os segmentos que o target já tem
+// (para wal.Log.SetRemoteArchive).
+func WALArchive(ctx context.Context, target Target, nodeID string) (upload func(name string, data []byte) error, uploaded []string, err error) {
+	names, err := target.List(ctx, segmentObjectName(nodeID, "")+"/")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		uploaded = append(uploaded, path.Base(name))
+	}
+	upload = func(name string, data []byte) error {
+		return target.Put(context.Background(), segmentObjectName(nodeID, name), data)
+	}
+	return upload, uploaded, nil
+}
+
+// backupPoint é o último ponto da cadeia de backups de um nó.
+type backupPoint struct {
+	ID     string
+	Base   string
+	WALSeq uint64
+}
+
+// latestBackup acha o backup mais recente (completo ou incremental) do nó.
+// IDs padrão são ordenáveis por data, então vale o maior ID.
+func (m *Manager) latestBackup(ctx context.Context) (*backupPoint, error) {
+	names, err := m.target.List(ctx, m.nodeID+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	latest, latestName := "", ""
+	for _, name := range names {
+		base := path.Base(name)
+		var id string
+		switch {
+		case strings.HasSuffix(base, ".snapshot.json"):
+			id = strings.TrimSuffix(base, ".snapshot.json")
+		case strings.HasSuffix(base, ".incremental.json"):
+			id = strings.TrimSuffix(base, ".incremental.json")
+		default:
+			continue
+		}
+		if id > latest {
+			latest, latestName = id, name
+		}
+	}
+	if latestName == "" {
+		return nil, fmt.Errorf("%w: no full backup for node %s to chain onto", ErrNotFound, m.nodeID)
+	}
+
+	data, err := m.target.Get(ctx, latestName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(latestName, ".incremental.json") {
+		var man IncrementalManifest
+		if err := json.Unmarshal(data, &man); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", latestName, err)
+		}
+		return &backupPoint{ID: man.ID, Base: man.Base, WALSeq: man.WALSeq}, nil
+	}
+	snap, err := DecodeSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &backupPoint{ID: snap.ID, Base: snap.ID, WALSeq: snap.WALSeq}, nil
+}
+
+// Incremental envia só os segmentos de WAL fechados desde o último backup
+// do nó, mais um manifesto encadeado a ele.
+func (m *Manager) Incremental(ctx context.Context, id string) (*IncrementalManifest, error) {
+	if err := ValidateName("backup id", id); err != nil {
+		return nil, err
+	}
+	if m.wal == nil {
+		return nil, fmt.Errorf("incremental backups need the WAL (set WAL_DIR)")
+	}
+
+	parent, err := m.latestBackup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if parent.WALSeq == 0 {
+		return nil, fmt.Errorf("backup %s has no WAL position; take a full backup first", parent.ID)
+	}
+	if id <= parent.ID {
+		return nil, fmt.Errorf("%w: %s is not after %s", ErrIncrementalOrder, id, parent.ID)
+	}
+
+	if err := m.rotateWAL(); err != nil {
+		return nil, err
+	}
+	closed, current, err := m.wal.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	man := &IncrementalManifest{
+		ID:        id,
+		NodeID:    m.nodeID,
+		Base:      parent.Base,
+		Parent:    parent.ID,
+		CreatedAt: time.Now().UTC(),
+		Segments:  []string{},
+		WALSeq:    current,
+	}
+
+	next := parent.WALSeq
+	for _, seg := range closed {
+		if seg.Seq < parent.WALSeq {
+			continue
+		}
+		if seg.Seq != next {
+			return nil, fmt.Errorf("wal segment %d missing since backup %s; take a full backup", next, parent.ID)
+		}
+		data, err := os.ReadFile(seg.Path)
+		if err != nil {
+			return nil, err
+		}
+		name := segmentObjectName(m.nodeID, seg.Name())
+		if err := m.target.Put(ctx, name, data); err != nil {
+			return nil, fmt.Errorf("upload %s: %w", name, err)
+		}
+		man.Segments = append(man.Segments, name)
+		next++
+	}
+
+	data, _ := json.Marshal(man)
+	name := IncrementalName(m.nodeID, id)
+	if err := m.target.Put(ctx, name, data); err != nil {
+		return nil, fmt.Errorf("upload %s: %w", name, err)
+	}
+
+	log.Printf("[BACKUP] incremental %s uploaded: parent=%s base=%s segments=%d", name, man.Parent, man.Base, len(man.Segments))
+	return man, nil
+}
+
+// IncrementalRestoreResult resume um restore de uma cadeia incremental.
+type IncrementalRestoreResult struct {
+	ID       string   `json:"id"`
+	Base     string   `json:"base"`
+	Chain    []string `json:"chain"`
+	Entries  int      `json:"entries"`
+	Segments int      `json:"segments"`
+	Replayed int      `json:"replayed"`
+}
+
+// RestoreIncremental carrega o snapshot base da cadeia que termina em id e
+// reaplica, em ordem, os segmentos de cada incremental. O store é substituído.
+func (m *Manager) RestoreIncremental(ctx context.Context, nodeID, id string) (*IncrementalRestoreResult, error) {
+	if err := ValidateName("node", nodeID); err != nil {
+		return nil, err
+	}
+	if err := ValidateName("backup id", id); err != nil {
+		return nil, err
+	}
+	// sobe a cadeia do incremental pedido até o snapshot base
+	var chain []*IncrementalManifest
+	seen := make(map[string]bool)
+	cur := id
+	for {
+		if seen[cur] {
+			// manifesto gravado antes de ErrIncrementalOrder, com o pai igual
+			return nil, fmt.Errorf("incremental chain of %s loops at %s", id, cur)
+		}
+		seen[cur] = true
+		data, err := m.target.Get(ctx, IncrementalName(nodeID, cur))
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var man IncrementalManifest
+		if err := json.Unmarshal(data, &man); err != nil {
+			return nil, fmt.Errorf("decode incremental %s: %w", cur, err)
+		}
+		chain = append([]*IncrementalManifest{&man}, chain...)
+		cur = man.Parent
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%w: incremental %s", ErrNotFound, id)
+	}
+
+	base := chain[0].Base
+	data, err := m.target.Get(ctx, SnapshotName(nodeID, base))
+	if err != nil {
+		return nil, fmt.Errorf("base snapshot %s: %w", base, err)
+	}
+	snap, err := DecodeSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &IncrementalRestoreResult{ID: id, Base: base, Entries: len(snap.Entries)}
+	snap.Apply(m.store, true)
+
+	snapTS := snap.CreatedAt.UnixMicro()
+	for _, man := range chain {
+		res.Chain = append(res.Chain, man.ID)
+		for _, name := range man.Segments {
+			seg, err := m.target.Get(ctx, name)
+			if err != nil {
+				return res, fmt.Errorf("segment %s: %w", name, err)
+			}
+			_, err = wal.ReadSegment(bytes.NewReader(seg), name, func(mut kv.Mutation) {
+				// um clear anterior ao snapshot já está refletido nele
+				if mut.Op == kv.OpClear && mut.Timestamp <= snapTS {
+					return
+				}
+				if m.store.Apply(mut) {
+					res.Replayed++
+				}
+			})
+			if err != nil {
+				return res, fmt.Errorf("segment %s: %w", name, err)
+			}
+			res.Segments++
+		}
+	}
+
+	log.Printf("[RESTORE] incremental chain %v restored onto base %s: replayed=%d", res.Chain, base, res.Replayed)
+	return res, nil
+}
@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/wal"
+)
+
+// maxFenceHold limita quanto tempo um nó fica com as escritas congeladas
+// esperando o commit de um snapshot coordenado.
+const maxFenceHold = 10 * time.Second
+
+// Result descreve um snapshot enviado ao destino.
+type Result struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+	Fence   int64  `json:"fence,omitempty"`
+}
+
+type pendingFence struct {
+	fence   int64
+	release func()
+	timer   *time.Timer
+}
+
+// Manager concentra as operações de backup de um nó: snapshot local,
+// envio ao destino e a parte do nó no snapshot coordenado do cluster.
+type Manager struct {
+	store  *kv.Store
+	target Target
+	wal    *wal.Log
+	nodeID string
+
+	mu      sync.Mutex
+	pending map[string]*pendingFence
+}
+
+func NewManager(store *kv.Store, target Target, walLog *wal.Log, nodeID string) *Manager {
+	return &Manager{
+		store:   store,
+		target:  target,
+		wal:     walLog,
+		nodeID:  nodeID,
+		pending: make(map[string]*pendingFence),
+	}
+}
+
+func (m *Manager) Store() *kv.Store { return m.store }
+func (m *Manager) Target() Target   { return m.target }
+func (m *Manager) WAL() *wal.Log    { return m.wal }
+func (m *Manager) NodeID() string   { return m.nodeID }
+
+// Backup tira um snapshot do store e envia para o destino.
+// Antes do snapshot o WAL é rotacionado, para que o segmento com as escritas
+// anteriores fique arquivado (base do restore point-in-time).
+func (m *Manager) Backup(ctx context.Context, id string) (*Result, error) {
+	if err := ValidateName("backup id", id); err != nil {
+		return nil, err
+	}
+	if err := m.rotateWAL(); err != nil {
+		return nil, err
+	}
+	snap := TakeSnapshot(m.store, id, m.nodeID)
+	snap.WALSeq = m.walSeq()
+	return m.upload(ctx, snap)
+}
+
+// walSeq retorna o segmento atual do WAL (0 se o WAL estiver desligado).
+func (m *Manager) walSeq() uint64 {
+	if m.wal == nil {
+		return 0
+	}
+	_, seq, err := m.wal.Segments()
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+func (m *Manager) rotateWAL() error {
+	if m.wal == nil {
+		return nil
+	}
+	if err := m.wal.Rotate(); err != nil {
+		return fmt.Errorf("wal rotate: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) upload(ctx context.Context, snap *Snapshot) (*Result, error) {
+	if err := ValidateName("backup id", snap.ID); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := snap.Encode(&buf); err != nil {
+		return nil, err
+	}
+
+	name := SnapshotName(m.nodeID, snap.ID)
+	if err := m.target.Put(ctx, name, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("upload %s: %w", name, err)
+	}
+
+	log.Printf("[BACKUP] snapshot %s uploaded: entries=%d bytes=%d", name, len(snap.Entries), buf.Len())
+	return &Result{
+		ID:      snap.ID,
+		Name:    name,
+		Entries: len(snap.Entries),
+		Bytes:   buf.Len(),
+		Fence:   snap.Fence,
+	}, nil
+}
+
+// Prepare é a primeira fase do snapshot coordenado: congela as escritas
+// locais até o Commit (ou Abort, ou maxFenceHold) e registra o fence.
+func (m *Manager) Prepare(id string, fence int64) error {
+	if err := ValidateName("snapshot id", id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if _, ok := m.pending[id]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("snapshot %s already prepared", id)
+	}
+	m.mu.Unlock()
+
+	release := m.store.Freeze()
+	p := &pendingFence{fence: fence, release: release}
+	p.timer = time.AfterFunc(maxFenceHold, func() {
+		log.Printf("[SNAPSHOT] fence for %s expired without commit, releasing writes", id)
+		m.Abort(id)
+	})
+
+	m.mu.Lock()
+	m.pending[id] = p
+	m.mu.Unlock()
+
+	log.Printf("[SNAPSHOT] prepared %s fence=%d (writes frozen)", id, fence)
+	return nil
+}
+
+// Commit é a segunda fase: tira o snapshot com as escritas ainda congeladas,
+// libera as escritas e só então envia o snapshot para o destino.
+func (m *Manager) Commit(ctx context.Context, id string) (*Result, error) {
+	m.mu.Lock()
+	p, ok := m.pending[id]
+	delete(m.pending, id)
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s not prepared (or fence expired)", id)
+	}
+	p.timer.Stop()
+
+	err := m.rotateWAL()
+	snap := TakeSnapshot(m.store, id, m.nodeID)
+	snap.Fence = p.fence
+	snap.WALSeq = m.walSeq()
+	p.release()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.upload(ctx, snap)
+}
+
+// Abort desfaz o Prepare, liberando as escritas.
+func (m *Manager) Abort(id string) {
+	m.mu.Lock()
+	p, ok := m.pending[id]
+	delete(m.pending, id)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	p.timer.Stop()
+	p.release()
+	log.Printf("[SNAPSHOT] aborted %s", id)
+}
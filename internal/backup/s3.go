@@ -0,0 +1,290 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configura um destino compatível com S3 (AWS, MinIO, Ceph...).
+type S3Config struct {
+	Endpoint     string // ex: https://s3.us-east-1.amazonaws.com ou http://minio:9000
+	Region       string
+	Bucket       string
+	Prefix       string // prefixo aplicado a todos os objetos
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3Target fala a API REST do S3 diretamente (path-style), assinando com SigV4.
+type S3Target struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func NewS3Target(cfg S3Config) (*S3Target, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 target requires endpoint and bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &S3Target{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+	}, nil
+}
+
+func (t *S3Target) objectKey(name string) string {
+	if t.cfg.Prefix == "" {
+		return name
+	}
+	return path.Join(t.cfg.Prefix, name)
+}
+
+func (t *S3Target) Put(ctx context.Context, name string, data []byte) error {
+	resp, err := t.do(ctx, http.MethodPut, t.objectKey(name), nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError("s3 PUT", name, resp.StatusCode, errorBody(resp.Body))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (t *S3Target) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := t.do(ctx, http.MethodGet, t.objectKey(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, statusError("s3 GET", name, resp.StatusCode, errorBody(resp.Body))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (t *S3Target) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := t.objectKey(prefix)
+	if t.cfg.Prefix != "" && prefix == "" {
+		fullPrefix += "/"
+	}
+
+	var names []string
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", fullPrefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		resp, err := t.do(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			body := errorBody(resp.Body)
+			resp.Body.Close()
+			return nil, statusError("s3 LIST", prefix, resp.StatusCode, body)
+		}
+		var res listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 LIST decode: %w", err)
+		}
+
+		for _, c := range res.Contents {
+			name := c.Key
+			if t.cfg.Prefix != "" {
+				name = strings.TrimPrefix(name, t.cfg.Prefix+"/")
+			}
+			names = append(names, name)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// do monta e assina (AWS Signature V4) uma requisição para o bucket.
+func (t *S3Target) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(t.cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	u.Path = "/" + t.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	t.sign(req, sha256Hex(body), time.Now().UTC())
+	return t.client.Do(req)
+}
+
+// sign adiciona os headers x-amz-* e o Authorization (AWS Signature V4).
+// A URL do req já deve estar com path e query no formato canônico.
+func (t *S3Target) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if t.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", t.cfg.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if t.cfg.SessionToken != "" {
+		headers["x-amz-security-token"] = t.cfg.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + t.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonRequest)),
+	}, "\n")
+
+	kDate := hmacSHA256([]byte("AWS4"+t.cfg.SecretKey), date)
+	kRegion := hmacSHA256(kDate, t.cfg.Region)
+	kService := hmacSHA256(kRegion, "s3")
+	kSigning := hmacSHA256(kService, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(kSigning, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3Escape aplica o URI-encoding exigido pelo SigV4 (só unreserved fica como está).
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// errorBody lê o começo do corpo de uma resposta de erro.
+func errorBody(r io.Reader) string {
+	b, _ := io.ReadAll(io.LimitReader(r, 512))
+	return strings.TrimSpace(string(b))
+}
+
+func statusError(op, name string, status int, body string) error {
+	if body == "" {
+		return fmt.Errorf("%s %s: status=%d", op, name, status)
+	}
+	return fmt.Errorf("%s %s: status=%d body=%s", op, name, status, body)
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound é retornado quando o objeto pedido não existe no destino.
+var ErrNotFound = errors.New("backup object not found")
+
+// ErrInvalidName: ID de backup ou de nó que não pode entrar no nome de um
+// objeto (vazio, com ".." ou com caracteres fora de [A-Za-z0-9._-]): como
+// os nomes viram caminhos no LocalTarget, "../" sairia de BACKUP_DIR.
+var ErrInvalidName = errors.New("invalid backup name")
+
+// ValidateName confere um ID de backup ou de nó (what diz qual, na
+// mensagem de erro).
+func ValidateName(what, s string) error {
+	if s == "" || strings.Contains(s, "..") {
+		return fmt.Errorf("%w: %s %q", ErrInvalidName, what, s)
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return fmt.Errorf("%w: %s %q (use letters, digits, '.', '_' and '-')", ErrInvalidName, what, s)
+		}
+	}
+	return nil
+}
+
+// Target é o destino onde os backups são guardados.
+// Os nomes usam "/" como separador, independente do destino.
+type Target interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// SnapshotName monta o nome do objeto de um snapshot de um nó.
+func SnapshotName(nodeID, id string) string {
+	return path.Join(nodeID, id+".snapshot.json")
+}
+
+// LocalTarget guarda backups num diretório do disco local.
+type LocalTarget struct {
+	dir string
+}
+
+func NewLocalTarget(dir string) *LocalTarget {
+	return &LocalTarget{dir: dir}
+}
+
+// path é o arquivo de name, que não pode sair do diretório do destino.
+func (t *LocalTarget) path(name string) (string, error) {
+	p := filepath.Join(t.dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(t.dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside the backup directory", ErrInvalidName, name)
+	}
+	return p, nil
+}
+
+func (t *LocalTarget) Put(ctx context.Context, name string, data []byte) error {
+	p, err := t.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// grava num arquivo temporário e renomeia, pra nunca deixar backup pela metade
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (t *LocalTarget) Get(ctx context.Context, name string) ([]byte, error) {
+	p, err := t.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (t *LocalTarget) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(t.dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(t.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
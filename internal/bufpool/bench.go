@@ -0,0 +1,24 @@
+package bufpool
+
+import "testing"
+
+// BenchSizes são os tamanhos de valor dos benchmarks do caminho de escrita
+// (internal/api e internal/cluster).
+var BenchSizes = []int{128, 4 << 10, 64 << 10}
+
+// WithPooling roda fn como dois sub-benchmarks, "pooled" e "unpooled", com o
+// reuso dos buffers ligado e desligado (SetPooling). O reuso volta ligado no
+// fim de cada um.
+func WithPooling(b *testing.B, fn func(b *testing.B)) {
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			SetPooling(pooled)
+			defer SetPooling(true)
+			fn(b)
+		})
+	}
+}
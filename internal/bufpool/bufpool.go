@@ -0,0 +1,123 @@
+// Package bufpool guarda buffers reutilizáveis (sync.Pool) para o caminho
+// quente das escritas: corpos de requisição, codificação JSON e os payloads
+// entre nós. Buffers maiores que MaxPooled não voltam ao pool, para um valor
+// enorme não ficar preso na memória.
+package bufpool
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxPooled é a maior capacidade de um buffer devolvido ao pool.
+const MaxPooled = 1 << 20
+
+// CopySize é o tamanho dos buffers de cópia (CopyBuffer).
+const CopySize = 32 << 10
+
+// unpooled desliga o reuso (SetPooling).
+var unpooled atomic.Bool
+
+// SetPooling liga ou desliga o reuso dos buffers no processo todo: desligado,
+// Get e GetCopy sempre alocam e Put e PutCopy descartam. Existe para os
+// benchmarks medirem o mesmo caminho com e sem pool.
+func SetPooling(on bool) {
+	unpooled.Store(!on)
+}
+
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+var copyBuffers = sync.Pool{New: func() any {
+	b := make([]byte, CopySize)
+	return &b
+}}
+
+// Get retorna um buffer vazio do pool.
+func Get() *bytes.Buffer {
+	if unpooled.Load() {
+		return new(bytes.Buffer)
+	}
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// Put devolve b ao pool; quem chama não pode mais usar b nem os bytes dele.
+func Put(b *bytes.Buffer) {
+	if b == nil || b.Cap() > MaxPooled || unpooled.Load() {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
+// GetCopy retorna um buffer de CopySize bytes para io.CopyBuffer e afins.
+func GetCopy() *[]byte {
+	if unpooled.Load() {
+		b := make([]byte, CopySize)
+		return &b
+	}
+	return copyBuffers.Get().(*[]byte)
+}
+
+// PutCopy devolve um buffer de GetCopy.
+func PutCopy(b *[]byte) {
+	if unpooled.Load() {
+		return
+	}
+	copyBuffers.Put(b)
+}
+
+// Copy é o io.Copy com um buffer do pool (o io.Copy aloca 32KB por chamada
+// quando dst e src não se copiam sozinhos).
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := GetCopy()
+	defer PutCopy(b)
+	return io.CopyBuffer(dst, src, *b)
+}
+
+// Shared é um buffer do pool lido por várias requisições ao mesmo tempo (o
+// corpo de uma escrita enviado a cada réplica). Ele volta ao pool quando o
+// dono chama Release e todos os leitores foram fechados: o transporte HTTP
+// pode fechar o corpo depois de a chamada retornar.
+type Shared struct {
+	buf  *bytes.Buffer
+	refs atomic.Int32
+}
+
+// NewShared passa buf para um Shared; a referência do dono é liberada por
+// Release.
+func NewShared(buf *bytes.Buffer) *Shared {
+	s := &Shared{buf: buf}
+	s.refs.Store(1)
+	return s
+}
+
+// Len é o tamanho do conteúdo.
+func (s *Shared) Len() int {
+	return s.buf.Len()
+}
+
+// Reader retorna um leitor do conteúdo; Close o libera (uma vez só).
+func (s *Shared) Reader() io.ReadCloser {
+	s.refs.Add(1)
+	return &sharedReader{Reader: bytes.NewReader(s.buf.Bytes()), s: s}
+}
+
+// Release libera a referência do dono.
+func (s *Shared) Release() {
+	if s.refs.Add(-1) == 0 {
+		Put(s.buf)
+	}
+}
+
+type sharedReader struct {
+	*bytes.Reader
+	s    *Shared
+	once sync.Once
+}
+
+func (r *sharedReader) Close() error {
+	r.once.Do(r.s.Release)
+	return nil
+}
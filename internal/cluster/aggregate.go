@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// AggregatePath é o endpoint interno que calcula o agregado parcial de um
+// conjunto de intervalos de tokens no nó.
+const AggregatePath = "/internal/aggregate"
+
+// AggregateRequest é o corpo de POST /internal/aggregate: as chaves vivas
+// dos intervalos com o prefixo e que passam no filtro (match/where, como no
+// /debug/keys) entram no COUNT; as que têm o campo numérico Field entram
+// também em SUM, MIN e MAX.
+type AggregateRequest struct {
+	Prefix string                `json:"prefix,omitempty"`
+	Match  string                `json:"match,omitempty"`
+	Where  []string              `json:"where,omitempty"`
+	Field  string                `json:"field,omitempty"`
+	Ranges []hashring.TokenRange `json:"ranges"`
+}
+
+// Aggregate é um agregado parcial (de um nó) ou total (somado no
+// coordenador). Min e Max ficam nil sem nenhum valor numérico.
+type Aggregate struct {
+	Count int64 `json:"count"`
+	// Values: chaves com o campo numérico (as que entram em Sum/Min/Max)
+	Values int64    `json:"values"`
+	Sum    float64  `json:"sum"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+}
+
+func (a *Aggregate) add(v float64) {
+	a.Values++
+	a.Sum += v
+	if a.Min == nil || v < *a.Min {
+		a.Min = &v
+	}
+	if a.Max == nil || v > *a.Max {
+		m := v
+		a.Max = &m
+	}
+}
+
+// merge soma o agregado parcial o em a.
+func (a *Aggregate) merge(o Aggregate) {
+	a.Count += o.Count
+	a.Values += o.Values
+	a.Sum += o.Sum
+	if o.Min != nil && (a.Min == nil || *o.Min < *a.Min) {
+		m := *o.Min
+		a.Min = &m
+	}
+	if o.Max != nil && (a.Max == nil || *o.Max > *a.Max) {
+		m := *o.Max
+		a.Max = &m
+	}
+}
+
+// AggregateOptions são os parâmetros de um agregado do cluster.
+type AggregateOptions struct {
+	Prefix      string
+	Match       string
+	Where       []string
+	Field       string
+	Parallelism int
+}
+
+// AggregateResult é o agregado do cluster, com a média e o resumo da
+// distribuição entre os nós.
+type AggregateResult struct {
+	Field string `json:"field,omitempty"`
+	Aggregate
+	Avg *float64 `json:"avg,omitempty"`
+	// Ranges, Requests, Retried e Failed como no resumo do scan; com Failed,
+	// o resultado é parcial (Partial)
+	Ranges   int                `json:"ranges"`
+	Requests int                `json:"requests"`
+	Retried  int                `json:"retried"`
+	Partial  bool               `json:"partial"`
+	Failed   []ScanRangeFailure `json:"failed"`
+}
+
+// AggregateCluster calcula COUNT, SUM, MIN e MAX sobre o cluster inteiro sem
+// trazer os valores: cada nó agrega localmente os intervalos dos quais é
+// réplica primária (ver fanOutRanges) e só o agregado parcial volta ao
+// coordenador. Um nó que falhar tem os intervalos dele agregados na réplica
+// seguinte; como o parcial só vale inteiro, nada é contado duas vezes.
+func (r *Router) AggregateCluster(ctx context.Context, opts AggregateOptions) (AggregateResult, error) {
+	defer metrics.Since("scan.aggregate", time.Now())
+	if _, err := kv.ParseFilter(opts.Match, opts.Where); err != nil {
+		return AggregateResult{}, err
+	}
+	var (
+		mu  sync.Mutex
+		agg Aggregate
+	)
+	fo, err := r.fanOutRanges(ctx, opts.Parallelism, func(ctx context.Context, node hashring.NodeInfo, ranges []hashring.TokenRange) error {
+		part, err := r.aggregateFrom(ctx, node, AggregateRequest{Prefix: opts.Prefix, Match: opts.Match, Where: opts.Where, Field: opts.Field, Ranges: ranges})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		agg.merge(part)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return AggregateResult{}, err
+	}
+	res := AggregateResult{
+		Field:     opts.Field,
+		Aggregate: agg,
+		Ranges:    fo.ranges,
+		Requests:  fo.requests,
+		Retried:   fo.retried,
+		Partial:   len(fo.failed) > 0,
+		Failed:    fo.failed,
+	}
+	if agg.Values > 0 {
+		avg := agg.Sum / float64(agg.Values)
+		res.Avg = &avg
+	}
+	return res, nil
+}
+
+func (r *Router) aggregateFrom(ctx context.Context, node hashring.NodeInfo, ar AggregateRequest) (Aggregate, error) {
+	if r.isLocal(node) {
+		return r.LocalAggregate(ar)
+	}
+	body, _ := json.Marshal(ar)
+	res := r.call(ctx, node, "POST", AggregatePath, body)
+	if !res.OK() {
+		return Aggregate{}, fmt.Errorf("aggregate on %s: %s", node.ID, res.Error())
+	}
+	var out Aggregate
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return Aggregate{}, fmt.Errorf("aggregate on %s: %w", node.ID, err)
+	}
+	return out, nil
+}
+
+// LocalAggregate calcula o agregado parcial de ar sobre o store local.
+func (r *Router) LocalAggregate(ar AggregateRequest) (Aggregate, error) {
+	filter, err := kv.ParseFilter(ar.Match, ar.Where)
+	if err != nil {
+		return Aggregate{}, err
+	}
+	ranges := newTokenRangeSet(ar.Ranges)
+	now := kv.Now()
+	var agg Aggregate
+	r.localStore.IterateVersions(ar.Prefix, "", func(key string, e kv.Entry) bool {
+		if !strings.HasPrefix(key, ar.Prefix) {
+			return false
+		}
+		if e.Deleted || e.Expired(now) || !ranges.contains(r.ring.Hash(key)) || !filter.Match(key, e) {
+			return true
+		}
+		agg.Count++
+		if ar.Field != "" {
+			if v, ok := kv.NumberField(e.Value, ar.Field); ok && !math.IsInf(v, 0) {
+				agg.add(v)
+			}
+		}
+		return true
+	})
+	return agg, nil
+}
@@ -0,0 +1,248 @@
+package cluster
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/metrics"
+)
+
+// Padrões do anti-entropy contínuo (ANTI_ENTROPY_SPLITS e
+// ANTI_ENTROPY_MAX_KB_PER_SEC).
+const (
+	DefaultAntiEntropySplits      = 16
+	DefaultAntiEntropyBytesPerSec = 1 << 20
+)
+
+// AntiEntropyPolicy diz de quanto em quanto tempo o nó compara, com as outras
+// réplicas, um pedaço sorteado de um dos intervalos dos quais ele é réplica,
+// corrigindo as diferenças na hora (como o repair, só que aos poucos e sem
+// parar).
+type AntiEntropyPolicy struct {
+	// Interval entre duas comparações (0 desliga)
+	Interval time.Duration
+	// Splits: em quantos pedaços cada intervalo do ring é dividido; uma
+	// comparação cobre um pedaço
+	Splits int
+	// BytesPerSec limita, na média, o volume de reparos enviados: depois de
+	// uma comparação que reparou muito, a próxima espera mais (0 = sem
+	// limite)
+	BytesPerSec int64
+}
+
+// AntiEntropyStatus é o estado do anti-entropy contínuo (GET /admin/antientropy).
+type AntiEntropyStatus struct {
+	Enabled        bool   `json:"enabled"`
+	Interval       string `json:"interval,omitempty"`
+	Splits         int    `json:"splits,omitempty"`
+	MaxBytesPerSec int64  `json:"max_bytes_per_sec,omitempty"`
+	Rounds         int64  `json:"rounds"`
+	Skipped        int64  `json:"skipped"`
+	// Keys comparadas, Mismatches (chave desatualizada numa réplica) e
+	// Repaired (versões enviadas), desde o início
+	Keys       int64 `json:"keys"`
+	Mismatches int64 `json:"mismatches"`
+	Repaired   int64 `json:"repaired"`
+	Bytes      int64 `json:"bytes"`
+	Errors     int64 `json:"errors"`
+	// EntropyRatio: divergências por chave comparada
+	EntropyRatio float64              `json:"entropy_ratio"`
+	LastRun      *time.Time           `json:"last_run,omitempty"`
+	LastRange    *hashring.TokenRange `json:"last_range,omitempty"`
+	// LastMismatches: divergências encontradas na última comparação
+	LastMismatches int    `json:"last_mismatches"`
+	LastError      string `json:"last_error,omitempty"`
+	LastSkip       string `json:"last_skip,omitempty"`
+}
+
+type antiEntropyState struct {
+	mu     sync.Mutex
+	status AntiEntropyStatus
+	// bytesPerSec é o limite em vigor (rateSet: definido por
+	// SetAntiEntropyMaxRate, que vale mais que o BytesPerSec da política)
+	bytesPerSec int64
+	rateSet     bool
+}
+
+// SetAntiEntropyMaxRate troca o limite de bytes/s dos reparos do
+// anti-entropy contínuo (0 = sem limite); vale a partir da próxima
+// comparação, mesmo com o anti-entropy já rodando.
+func (r *Router) SetAntiEntropyMaxRate(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	r.antiEntropy.mu.Lock()
+	defer r.antiEntropy.mu.Unlock()
+	r.antiEntropy.bytesPerSec, r.antiEntropy.rateSet = bytesPerSec, true
+	r.antiEntropy.status.MaxBytesPerSec = bytesPerSec
+}
+
+// AntiEntropyMaxRate retorna o limite de bytes/s em vigor no anti-entropy.
+func (r *Router) AntiEntropyMaxRate() int64 {
+	r.antiEntropy.mu.Lock()
+	defer r.antiEntropy.mu.Unlock()
+	return r.antiEntropy.bytesPerSec
+}
+
+// AntiEntropyStatus retorna o estado do anti-entropy contínuo.
+func (r *Router) AntiEntropyStatus() AntiEntropyStatus {
+	r.antiEntropy.mu.Lock()
+	defer r.antiEntropy.mu.Unlock()
+	st := r.antiEntropy.status
+	if st.LastRange != nil {
+		rng := *st.LastRange
+		st.LastRange = &rng
+	}
+	return st
+}
+
+// RunAntiEntropy compara pedaços sorteados do ring até ctx terminar: a cada
+// p.Interval, sorteia um intervalo do qual este nó é réplica e um dos
+// p.Splits pedaços dele, e repara esse pedaço com as outras réplicas (todas
+// as versões, não só as escritas depois do último repair). Assim a
+// divergência que escapa dos hints e do read repair (hints expirados, chaves
+// que ninguém lê) é achada e corrigida sem esperar um repair manual, a um
+// custo baixo e constante. O que foi achado fica em /admin/antientropy e nas
+// métricas antientropy.*.
+func (r *Router) RunAntiEntropy(ctx context.Context, p AntiEntropyPolicy) {
+	if p.Interval <= 0 {
+		return
+	}
+	if p.Splits < 1 {
+		p.Splits = 1
+	}
+	r.antiEntropy.mu.Lock()
+	if !r.antiEntropy.rateSet {
+		r.antiEntropy.bytesPerSec = p.BytesPerSec
+	}
+	rate := r.antiEntropy.bytesPerSec
+	r.antiEntropy.status = AntiEntropyStatus{Enabled: true, Interval: p.Interval.String(), Splits: p.Splits, MaxBytesPerSec: rate}
+	r.antiEntropy.mu.Unlock()
+	log.Printf("[ANTIENTROPY] every %s, 1/%d of a range per round, max %d bytes/s", p.Interval, p.Splits, rate)
+
+	wait := p.Interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = p.Interval
+
+		if skip := r.antiEntropyBlocked(); skip != "" {
+			r.antiEntropy.mu.Lock()
+			r.antiEntropy.status.Skipped++
+			r.antiEntropy.status.LastSkip = skip
+			r.antiEntropy.mu.Unlock()
+			metrics.Inc("antientropy.skipped")
+			continue
+		}
+		rng, replicas, ok := r.pickAntiEntropyRange(p.Splits)
+		if !ok {
+			continue
+		}
+		started := time.Now()
+		res := r.repairRange(ctx, rng, replicas, 0)
+		bytes := res.bytes
+		if rate := r.AntiEntropyMaxRate(); rate > 0 {
+			if pace := time.Duration(float64(bytes) / float64(rate) * float64(time.Second)); pace > wait {
+				wait = pace
+			}
+		}
+
+		metrics.Inc("antientropy.rounds")
+		metrics.Add("antientropy.keys", int64(res.keys))
+		metrics.Add("antientropy.mismatches", int64(res.mismatches))
+		metrics.Add("antientropy.repaired", int64(res.repaired))
+		metrics.Add("antientropy.bytes", bytes)
+		metrics.Add("antientropy.errors", int64(len(res.errs)))
+		if res.mismatches > 0 {
+			log.Printf("[ANTIENTROPY] range (%d,%d]: %d keys, %d mismatches, %d repaired", rng.Start, rng.End, res.keys, res.mismatches, res.repaired)
+		}
+
+		r.antiEntropy.mu.Lock()
+		st := &r.antiEntropy.status
+		st.Rounds++
+		st.Keys += int64(res.keys)
+		st.Mismatches += int64(res.mismatches)
+		st.Repaired += int64(res.repaired)
+		st.Bytes += bytes
+		st.Errors += int64(len(res.errs))
+		if st.Keys > 0 {
+			st.EntropyRatio = float64(st.Mismatches) / float64(st.Keys)
+		}
+		last := started.UTC()
+		st.LastRun = &last
+		st.LastRange = &hashring.TokenRange{Start: rng.Start, End: rng.End}
+		st.LastMismatches = res.mismatches
+		st.LastError, st.LastSkip = "", ""
+		if len(res.errs) > 0 {
+			st.LastError = res.errs[0].Error()
+		}
+		r.antiEntropy.mu.Unlock()
+	}
+}
+
+// antiEntropyBlocked diz por que a comparação tem que esperar ("" se pode
+// rodar).
+func (r *Router) antiEntropyBlocked() string {
+	r.gate.mu.Lock()
+	bootstrapping, draining := r.gate.bootstrapping, r.gate.draining
+	r.gate.mu.Unlock()
+	switch {
+	case bootstrapping:
+		return "node is bootstrapping"
+	case draining:
+		return "node is draining"
+	case r.coordinatorOnly:
+		return "coordinator-only node"
+	}
+	// um repair já está comparando tudo
+	if _, ok := r.jobs.Running(repairKind); ok {
+		return "repair running"
+	}
+	return ""
+}
+
+// pickAntiEntropyRange sorteia um pedaço (1/splits) de um dos intervalos dos
+// quais este nó é réplica, com as réplicas do intervalo.
+func (r *Router) pickAntiEntropyRange(splits int) (hashring.TokenRange, []hashring.NodeInfo, bool) {
+	var candidates []hashring.TokenRange
+	for _, t := range r.ring.Ranges() {
+		for _, n := range r.repairReplicasForRange(t) {
+			if r.isLocal(n) {
+				candidates = append(candidates, t)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return hashring.TokenRange{}, nil, false
+	}
+	t := candidates[rand.Intn(len(candidates))]
+	replicas := r.repairReplicasForRange(t)
+	if len(replicas) < 2 {
+		// sem outra réplica não há com quem comparar
+		return hashring.TokenRange{}, nil, false
+	}
+
+	// largura do intervalo (Start == End é o anel inteiro, com um só token)
+	width := uint64(t.End - t.Start)
+	if width == 0 {
+		width = 1 << 32
+	}
+	piece := width / uint64(splits)
+	if piece == 0 {
+		return t, replicas, true
+	}
+	k := uint64(rand.Intn(splits))
+	sub := hashring.TokenRange{Start: t.Start + uint32(k*piece), End: t.Start + uint32((k+1)*piece)}
+	if k == uint64(splits)-1 {
+		sub.End = t.End
+	}
+	return sub, replicas, true
+}
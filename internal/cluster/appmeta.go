@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Metadados de aplicação: pares chave/valor pequenos que o operador pendura
+// num nó (zona, hash do build, flag de manutenção). Vão no NodeMeta do
+// handshake, então aparecem para o cluster inteiro em /cluster/status. Os de
+// NODE_METADATA são a base; as mudanças feitas em runtime (PATCH
+// /admin/metadata) ficam num arquivo de estado e sobrevivem a restarts.
+
+// Limites dos metadados de aplicação de um nó.
+const (
+	MaxAppMetadataKeys     = 32
+	MaxAppMetadataKeyLen   = 64
+	MaxAppMetadataValueLen = 256
+)
+
+// ErrInvalidAppMetadata: chave, valor ou quantidade fora dos limites.
+var ErrInvalidAppMetadata = errors.New("invalid node metadata")
+
+var appMetadataKeyRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+type appMetadata struct {
+	mu   sync.RWMutex
+	path string
+	// base: NODE_METADATA; overrides: o merge-patch acumulado das mudanças em
+	// runtime (nil = chave removida), o que vai para o arquivo
+	base      map[string]string
+	overrides map[string]*string
+}
+
+// LoadAppMetadata define os metadados de base (NODE_METADATA) e carrega de
+// path as mudanças feitas em runtime ("" = sem persistência).
+func (r *Router) LoadAppMetadata(base map[string]string, path string) error {
+	if _, err := mergeAppMetadata(base, nil); err != nil {
+		return err
+	}
+	m := &r.appMeta
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.path, m.base, m.overrides = path, base, nil
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.overrides); err != nil {
+		return fmt.Errorf("node metadata state %s: %w", path, err)
+	}
+	if _, err := mergeAppMetadata(base, m.overrides); err != nil {
+		return fmt.Errorf("node metadata state %s: %w", path, err)
+	}
+	return nil
+}
+
+// AppMetadata retorna os metadados de aplicação atuais deste nó.
+func (r *Router) AppMetadata() map[string]string {
+	m := &r.appMeta
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out, _ := mergeAppMetadata(m.base, m.overrides)
+	return out
+}
+
+// PatchAppMetadata aplica patch (valor nil remove a chave) e grava as
+// mudanças. Retorna os metadados resultantes.
+func (r *Router) PatchAppMetadata(patch map[string]*string) (map[string]string, error) {
+	m := &r.appMeta
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	overrides := make(map[string]*string, len(m.overrides)+len(patch))
+	for k, v := range m.overrides {
+		overrides[k] = v
+	}
+	for k, v := range patch {
+		overrides[k] = v
+	}
+	out, err := mergeAppMetadata(m.base, overrides)
+	if err != nil {
+		return nil, err
+	}
+	// remover uma chave que não está na base não precisa ficar no arquivo
+	for k, v := range overrides {
+		if _, inBase := m.base[k]; v == nil && !inBase {
+			delete(overrides, k)
+		}
+	}
+	if err := saveAppMetadata(m.path, overrides); err != nil {
+		return nil, err
+	}
+	m.overrides = overrides
+	return out, nil
+}
+
+// mergeAppMetadata aplica overrides sobre base e confere os limites.
+func mergeAppMetadata(base map[string]string, overrides map[string]*string) (map[string]string, error) {
+	out := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = *v
+		}
+	}
+	if len(out) > MaxAppMetadataKeys {
+		return nil, fmt.Errorf("%w: %d keys, max is %d", ErrInvalidAppMetadata, len(out), MaxAppMetadataKeys)
+	}
+	for k, v := range out {
+		if len(k) > MaxAppMetadataKeyLen || !appMetadataKeyRe.MatchString(k) {
+			return nil, fmt.Errorf("%w: key %q (want [a-z0-9._-], up to %d chars)", ErrInvalidAppMetadata, k, MaxAppMetadataKeyLen)
+		}
+		if len(v) > MaxAppMetadataValueLen {
+			return nil, fmt.Errorf("%w: value of %q is %d bytes, max is %d", ErrInvalidAppMetadata, k, len(v), MaxAppMetadataValueLen)
+		}
+	}
+	return out, nil
+}
+
+func saveAppMetadata(path string, overrides map[string]*string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+)
+
+// Balanceamento automático do ring: depois de vários move-token, joins e
+// replaces o ring pode ficar torto (um nó com bem mais posse efetiva que
+// os outros). PlanBalance simula, num clone do ring, uma sequência de
+// move-token que aproxima cada nó da posse ideal (RF/N); StartBalance
+// executa o plano como job, um MoveToken por vez.
+
+// Padrões do balanceamento (GET/POST /admin/balance).
+const (
+	DefaultBalanceThreshold = 0.1
+	DefaultBalanceMaxMoves  = 32
+)
+
+// balanceKind é o job que executa um plano de balanceamento.
+const balanceKind = "balance"
+
+// NodeBalance é a posse de um nó antes ou depois do plano.
+type NodeBalance struct {
+	Node   string `json:"node"`
+	Tokens int    `json:"tokens"`
+	// EffectivePct: porcentagem do espaço de tokens em que o nó é réplica
+	EffectivePct float64 `json:"effective_pct"`
+	// DeviationPct: desvio relativo da posse ideal
+	DeviationPct float64 `json:"deviation_pct"`
+}
+
+// BalanceMove é um move-token do plano.
+type BalanceMove struct {
+	Token uint32 `json:"token"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// BalancePlan é o resultado de PlanBalance: nada é movido.
+type BalancePlan struct {
+	ReplicationFactor int     `json:"replication_factor"`
+	Threshold         float64 `json:"threshold"`
+	IdealPct          float64 `json:"ideal_pct"`
+	// MaxDeviationPct antes e depois dos moves (em valor absoluto)
+	MaxDeviationBefore float64       `json:"max_deviation_before_pct"`
+	MaxDeviationAfter  float64       `json:"max_deviation_after_pct"`
+	Balanced           bool          `json:"balanced"`
+	Moves              []BalanceMove `json:"moves"`
+	Before             []NodeBalance `json:"before"`
+	After              []NodeBalance `json:"after"`
+}
+
+// BalanceDetail é o detail do job balance.
+type BalanceDetail struct {
+	Moved  int    `json:"moved"`
+	Keys   int    `json:"keys_streamed"`
+	Failed string `json:"failed,omitempty"`
+}
+
+// balanceView mede a posse de cada nó de um ring, com o fator de replicação
+// padrão (o balance iguala a posse dos tokens, igual para todo keyspace).
+type balanceView struct {
+	nodes  []hashring.Ownership
+	ideal  float64
+	maxDev float64
+	sqDev  float64
+}
+
+func (r *Router) viewBalance(ring *hashring.Ring) balanceView {
+	owners := ring.Ownership(r.replicationFactor)
+	v := balanceView{nodes: owners}
+	if len(owners) == 0 {
+		return v
+	}
+	rf := r.replicationFactor
+	if rf > len(owners) {
+		rf = len(owners)
+	}
+	v.ideal = float64(rf) / float64(len(owners))
+	for _, o := range owners {
+		dev := (o.Effective - v.ideal) / v.ideal
+		v.sqDev += dev * dev
+		if math.Abs(dev) > v.maxDev {
+			v.maxDev = math.Abs(dev)
+		}
+	}
+	return v
+}
+
+func (v balanceView) report() []NodeBalance {
+	out := make([]NodeBalance, 0, len(v.nodes))
+	for _, o := range v.nodes {
+		out = append(out, NodeBalance{
+			Node:         string(o.Node.ID),
+			Tokens:       o.Tokens,
+			EffectivePct: round2(o.Effective * 100),
+			DeviationPct: round2((o.Effective - v.ideal) / v.ideal * 100),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// PlanBalance propõe até maxMoves move-token que reduzem o desvio da posse
+// ideal até nenhum nó passar de threshold (fração relativa). A cada passo
+// testa passar cada token do nó com mais posse para o nó com menos e fica
+// com o move que mais reduz a soma dos quadrados dos desvios; para quando
+// nenhum move melhora. Nós sem tokens (coordenadores puros) não entram.
+func (r *Router) PlanBalance(threshold float64, maxMoves int) BalancePlan {
+	ring := r.ring.Clone()
+	cur := r.viewBalance(ring)
+	plan := BalancePlan{
+		ReplicationFactor:  r.replicationFactor,
+		Threshold:          threshold,
+		IdealPct:           round2(cur.ideal * 100),
+		MaxDeviationBefore: round2(cur.maxDev * 100),
+		Moves:              []BalanceMove{},
+		Before:             cur.report(),
+	}
+
+	for len(plan.Moves) < maxMoves && cur.maxDev > threshold && len(cur.nodes) > 1 {
+		over, under := cur.nodes[0], cur.nodes[0]
+		for _, o := range cur.nodes {
+			if o.Effective > over.Effective {
+				over = o
+			}
+			if o.Effective < under.Effective {
+				under = o
+			}
+		}
+		if over.Tokens <= 1 {
+			break
+		}
+
+		best := cur
+		var bestToken uint32
+		found := false
+		for _, rng := range ring.Ranges() {
+			if rng.Owner.ID != over.Node.ID {
+				continue
+			}
+			try := ring.Clone()
+			if _, err := try.MoveToken(rng.End, under.Node); err != nil {
+				continue
+			}
+			if v := r.viewBalance(try); v.sqDev < best.sqDev {
+				best, bestToken, found = v, rng.End, true
+			}
+		}
+		if !found {
+			break
+		}
+		ring.MoveToken(bestToken, under.Node)
+		plan.Moves = append(plan.Moves, BalanceMove{Token: bestToken, From: string(over.Node.ID), To: string(under.Node.ID)})
+		cur = best
+	}
+
+	plan.MaxDeviationAfter = round2(cur.maxDev * 100)
+	plan.Balanced = cur.maxDev <= threshold
+	plan.After = cur.report()
+	return plan
+}
+
+// StartBalance calcula o plano e o executa em background (job balance em
+// /admin/jobs), um MoveToken por vez: cada um transfere os dados do trecho
+// antes de mudar o ring em todos os nós. Para no primeiro move que falhar
+// (os anteriores ficam feitos). Sem moves no plano, nenhum job é criado.
+func (r *Router) StartBalance(threshold float64, maxMoves int) (BalancePlan, *jobs.Job, error) {
+	if running, ok := r.jobs.Running(balanceKind); ok {
+		return BalancePlan{}, nil, fmt.Errorf("balance %s already running", running.ID())
+	}
+	plan := r.PlanBalance(threshold, maxMoves)
+	if len(plan.Moves) == 0 {
+		return plan, nil, nil
+	}
+	log.Printf("[BALANCE] %d token moves planned (max deviation %.2f%% -> %.2f%%)", len(plan.Moves), plan.MaxDeviationBefore, plan.MaxDeviationAfter)
+	moves := plan.Moves
+	job := r.jobs.Start(balanceKind, "tokens", func(ctx context.Context, job *jobs.Job) error {
+		job.SetTotal(int64(len(moves)))
+		var detail BalanceDetail
+		defer func() { job.SetDetail(detail) }()
+		for _, mv := range moves {
+			res, err := r.MoveToken(ctx, mv.Token, hashring.NodeID(mv.To))
+			if err != nil {
+				detail.Failed = fmt.Sprintf("token %d to %s: %v", mv.Token, mv.To, err)
+				return fmt.Errorf("moving token %d to %s: %w", mv.Token, mv.To, err)
+			}
+			detail.Moved++
+			detail.Keys += res.Streamed.Keys + res.CatchUp.Keys
+			job.Add(1, res.Streamed.Bytes+res.CatchUp.Bytes)
+			job.SetDetail(detail)
+		}
+		log.Printf("[BALANCE] %d tokens moved", detail.Moved)
+		return nil
+	})
+	return plan, job, nil
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"mini-cassandra/internal/kv"
+)
+
+// Record é uma escrita de um lote (import em massa).
+// Timestamp zero significa "agora". No streaming e no repair, Deleted marca
+// um tombstone.
+type Record struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"ts"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// PutBatch grava um lote de registros passando cada um pelo ring; as
+// escritas para a mesma réplica vão juntas (RPC multi-chave). Um valor acima
+// do limite dos objetos grandes sai do lote e vai em chunks (putLarge).
+// Retorna um resultado por registro, na mesma ordem do lote; um registro
+// que não passa no schema do keyspace falha sozinho (*SchemaError), sem ir
+// às réplicas.
+func (r *Router) PutBatch(records []Record) []BatchResult {
+	if err := r.checkWritable(); err != nil {
+		return batchFailed(len(records), err)
+	}
+	results := make([]BatchResult, len(records))
+	ms := make([]kv.Mutation, 0, len(records))
+	idx := make([]int, 0, len(records))
+	for i, rec := range records {
+		if err := r.ValidateValue(rec.Key, rec.Value); err != nil {
+			results[i].Err = err
+			continue
+		}
+		ts := rec.Timestamp
+		if ts <= 0 {
+			ts = kv.Now()
+		}
+		m := kv.Mutation{Op: kv.OpPut, Key: rec.Key, Value: rec.Value, Timestamp: ts, ExpiresAt: rec.ExpiresAt}
+		if r.shouldChunk(rec.Key, rec.Value) {
+			results[i].Err = r.putLarge(context.Background(), m, r.WriteConsistencyFor(rec.Key))
+			continue
+		}
+		ms = append(ms, m)
+		idx = append(idx, i)
+	}
+	if len(ms) == len(records) {
+		return r.replicateBatchDefault(context.Background(), ms)
+	}
+	for j, res := range r.replicateBatchDefault(context.Background(), ms) {
+		results[idx[j]] = res
+	}
+	return results
+}
+
+// replicateBatchDefault é o replicateBatch com a consistência padrão de
+// escrita de cada chave: as mutações são agrupadas pelo nível do keyspace e
+// cada grupo vai num replicateBatch.
+func (r *Router) replicateBatchDefault(ctx context.Context, ms []kv.Mutation) []BatchResult {
+	return r.byWriteConsistency(ms, func(sub []kv.Mutation, _ []int, cl Consistency) []BatchResult {
+		return r.replicateBatch(ctx, sub, cl)
+	})
+}
+
+// byWriteConsistency agrupa ms pela consistência padrão de escrita de cada
+// chave e chama send uma vez por nível, com as mutações do grupo e as
+// posições delas em ms. Os resultados voltam na ordem de ms.
+func (r *Router) byWriteConsistency(ms []kv.Mutation, send func(sub []kv.Mutation, idx []int, cl Consistency) []BatchResult) []BatchResult {
+	groups := make(map[Consistency][]int)
+	var order []Consistency
+	for i, m := range ms {
+		cl := r.WriteConsistencyFor(m.Key)
+		if _, ok := groups[cl]; !ok {
+			order = append(order, cl)
+		}
+		groups[cl] = append(groups[cl], i)
+	}
+	results := make([]BatchResult, len(ms))
+	for _, cl := range order {
+		idx := groups[cl]
+		sub := ms
+		if len(idx) < len(ms) {
+			sub = make([]kv.Mutation, len(idx))
+			for j, i := range idx {
+				sub[j] = ms[i]
+			}
+		}
+		for j, res := range send(sub, idx, cl) {
+			results[idx[j]] = res
+		}
+	}
+	return results
+}
+
+// MaxDeleteBatch é o máximo de chaves de um DeleteBatch (POST /kv/_mdelete).
+const MaxDeleteBatch = 1000
+
+// DeleteBatch apaga as chaves exigindo cl em cada uma (vazio = o padrão de
+// escrita do keyspace de cada chave); os tombstones para a mesma réplica vão
+// juntos (RPC multi-chave). Retorna um resultado por chave, na mesma ordem.
+func (r *Router) DeleteBatch(ctx context.Context, keys []string, cl Consistency) []BatchResult {
+	if err := r.checkWritable(); err != nil {
+		return batchFailed(len(keys), err)
+	}
+	ts := kv.Now()
+	ms := make([]kv.Mutation, len(keys))
+	for i, key := range keys {
+		ms[i] = kv.Mutation{Op: kv.OpDelete, Key: key, Timestamp: ts}
+	}
+	if cl == "" {
+		return r.replicateBatchDefault(ctx, ms)
+	}
+	return r.replicateBatch(ctx, ms, cl)
+}
+
+// KeyPage é a resposta de /internal/keys: chaves locais em ordem.
+type KeyPage struct {
+	Keys []string `json:"keys"`
+	More bool     `json:"more"`
+}
+
+// KeysWithPrefix lista, em ordem, até limit chaves do cluster com o prefixo
+// (juntando as listas locais de todos os nós) e diz se há mais. partial
+// indica que algum nó não respondeu (as chaves dele costumam vir por outras
+// réplicas).
+func (r *Router) KeysWithPrefix(ctx context.Context, prefix string, limit int) (keys []string, more, partial bool) {
+	q := url.Values{}
+	q.Set("prefix", prefix)
+	q.Set("limit", strconv.Itoa(limit))
+	seen := make(map[string]struct{})
+	for _, res := range r.Broadcast(ctx, "GET", "/internal/keys?"+q.Encode(), nil) {
+		var page KeyPage
+		if !res.OK() || json.Unmarshal(res.Body, &page) != nil {
+			partial = true
+			continue
+		}
+		more = more || page.More
+		for _, k := range page.Keys {
+			seen[k] = struct{}{}
+		}
+	}
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys, more = keys[:limit], true
+	}
+	return keys, more, partial
+}
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"mini-cassandra/hashring"
+)
+
+// NodeResult é a resposta de um nó a uma chamada em broadcast.
+type NodeResult struct {
+	Node   hashring.NodeInfo
+	Status int
+	Body   []byte
+	Err    error
+}
+
+// OK diz se o nó respondeu com sucesso.
+func (res NodeResult) OK() bool {
+	return res.Err == nil && res.Status < 300
+}
+
+// Error descreve a falha do nó (ou "" se deu certo).
+func (res NodeResult) Error() string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	if res.Status >= 300 {
+		return fmt.Sprintf("status=%d body=%s", res.Status, bytes.TrimSpace(res.Body))
+	}
+	return ""
+}
+
+// Nodes retorna todos os nós do ring.
+func (r *Router) Nodes() []hashring.NodeInfo {
+	return r.ring.Nodes()
+}
+
+// NodeID retorna o ID deste nó.
+func (r *Router) NodeID() hashring.NodeID {
+	return r.nodeID
+}
+
+// SelfHost retorna o endereço (host:port) que este nó anuncia.
+func (r *Router) SelfHost() string {
+	return r.selfHost
+}
+
+// VNodes retorna o número de vnodes por nó do ring.
+func (r *Router) VNodes() int {
+	return r.ring.VNodes()
+}
+
+// Broadcast envia a mesma requisição interna para todos os nós do ring
+// (inclusive este, via HTTP) em paralelo. Os resultados seguem a ordem de Nodes().
+func (r *Router) Broadcast(ctx context.Context, method, path string, body []byte) []NodeResult {
+	nodes := r.ring.Nodes()
+	results := make([]NodeResult, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node hashring.NodeInfo) {
+			defer wg.Done()
+			results[i] = r.call(ctx, node, method, path, body)
+		}(i, node)
+	}
+	wg.Wait()
+	return results
+}
+
+// call faz uma requisição interna para um nó.
+func (r *Router) call(ctx context.Context, node hashring.NodeInfo, method, path string, body []byte) NodeResult {
+	res := NodeResult{Node: node}
+
+	url := fmt.Sprintf("http://%s%s", node.Host, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.adminClient.Do(req)
+	if err != nil {
+		res.Err = fmt.Errorf("%s %s on %s failed: %w", method, path, node.Host, err)
+		return res
+	}
+	defer resp.Body.Close()
+
+	res.Status = resp.StatusCode
+	res.Body, res.Err = io.ReadAll(resp.Body)
+	return res
+}
@@ -0,0 +1,452 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Compare-and-set leve (sem Paxos): as operações condicionais de uma chave
+// são executadas, uma de cada vez, pelo dono dela — a primeira réplica viva
+// na ordem do ring (os outros coordenadores encaminham por /internal/cas).
+// O dono lê a versão mais nova num quorum, monta a mutação a partir dela e a
+// envia às réplicas com a condição "sua versão não é mais nova que a que eu
+// li" (/internal/replica/cas, atômica em cada réplica). Se um quorum aceita,
+// a operação vale; se uma escrita comum concorrente chegou antes em alguma
+// réplica, o dono relê e tenta de novo.
+const (
+	ReplicaCASPath = "/internal/replica/cas"
+	CASPath        = "/internal/cas"
+
+	// casProtocolVersion é a primeira versão do protocolo com ReplicaCASPath
+	casProtocolVersion = 3
+
+	casAttempts = 3
+	casLocks    = 256
+)
+
+var (
+	// ErrCASConflict: escritas concorrentes na chave impediram a operação
+	// condicional depois de casAttempts tentativas.
+	ErrCASConflict = errors.New("concurrent write on key, conditional operation not applied")
+
+	// ErrPreconditionFailed: a versão atual da chave não é a esperada.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrNotInteger: o valor atual não é um inteiro (incr).
+	ErrNotInteger = errors.New("value is not an integer")
+)
+
+const (
+	// OpIncr é a operação condicional que soma By ao valor inteiro da chave.
+	OpIncr = "incr"
+	// OpExpire troca o TTL da chave (TTL=0 tira o TTL) mantendo o valor.
+	OpExpire = "expire"
+	// OpMergePatch aplica Value (JSON merge-patch) ao documento da chave.
+	OpMergePatch = "merge_patch"
+)
+
+// ReplicaCASRequest é o corpo de /internal/replica/cas: a réplica aplica
+// Mutation só se a versão dela da chave não for mais nova que Expected
+// (timestamp da versão lida pelo coordenador, 0 = nenhuma).
+type ReplicaCASRequest struct {
+	Mutation kv.Mutation `json:"mutation"`
+	Expected int64       `json:"expected"`
+}
+
+// ReplicaCASResult é a resposta de /internal/replica/cas (Timestamp é o da
+// versão da réplica quando ela recusa).
+type ReplicaCASResult struct {
+	Applied   bool  `json:"applied"`
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// Version é a versão de uma chave vista por uma operação condicional.
+type Version struct {
+	Entry kv.Entry `json:"entry"`
+	Found bool     `json:"found"`
+}
+
+// CASOp descreve uma operação condicional (serializável, para ser
+// encaminhada ao dono da chave).
+type CASOp struct {
+	Op    string `json:"op"` // kv.OpPut, kv.OpDelete, OpIncr, OpExpire ou OpMergePatch
+	Value string `json:"value,omitempty"`
+	By    int64  `json:"by,omitempty"`
+	// TTL em microssegundos (0 = não expira)
+	TTL int64 `json:"ttl_us,omitempty"`
+	// Condição (If-Match): IfVersion exige que a versão atual tenha esse
+	// timestamp; IfExists só que a chave exista; IfNotExists que ela não
+	// exista (não pode ser combinado com os outros dois)
+	IfVersion   int64 `json:"if_version,omitempty"`
+	IfExists    bool  `json:"if_exists,omitempty"`
+	IfNotExists bool  `json:"if_not_exists,omitempty"`
+}
+
+// CASResult é o resultado de uma operação condicional aplicada: a versão
+// anterior da chave e o timestamp da mutação gravada (resposta de
+// /internal/cas; num 412 só Previous, a versão atual).
+type CASResult struct {
+	Previous  Version `json:"previous"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+}
+
+// CASRequest é o corpo de /internal/cas.
+type CASRequest struct {
+	Key         string      `json:"key"`
+	Consistency Consistency `json:"consistency"`
+	Op          CASOp       `json:"op"`
+}
+
+// mutation monta a mutação de op (o timestamp é definido no applyCAS).
+func (op CASOp) mutation(cur Version) (kv.Mutation, error) {
+	if (op.IfExists || op.IfVersion != 0) && !cur.Found {
+		return kv.Mutation{}, ErrPreconditionFailed
+	}
+	if op.IfVersion != 0 && cur.Entry.Timestamp != op.IfVersion {
+		return kv.Mutation{}, ErrPreconditionFailed
+	}
+	if op.IfNotExists && cur.Found {
+		return kv.Mutation{}, ErrPreconditionFailed
+	}
+	switch op.Op {
+	case kv.OpPut:
+		m := kv.Mutation{Op: kv.OpPut, Value: op.Value}
+		if op.TTL > 0 {
+			m.ExpiresAt = kv.Now() + op.TTL
+		}
+		return m, nil
+	case kv.OpDelete:
+		return kv.Mutation{Op: kv.OpDelete}, nil
+	case OpIncr:
+		n, err := incremented(cur, op.By)
+		if err != nil {
+			return kv.Mutation{}, err
+		}
+		// o incremento mantém o TTL e os metadados que a chave já tinha
+		return kv.Mutation{Op: kv.OpPut, Value: kv.WithMeta(strconv.FormatInt(n, 10), cur.meta()), ExpiresAt: cur.Entry.ExpiresAt}, nil
+	case OpExpire:
+		if !cur.Found {
+			return kv.Mutation{}, ErrPreconditionFailed
+		}
+		if _, large := parseManifest(cur.Entry.Value); large {
+			return kv.Mutation{}, ErrLargeObjectTTL
+		}
+		m := kv.Mutation{Op: kv.OpPut, Value: cur.Entry.Value}
+		if op.TTL > 0 {
+			m.ExpiresAt = kv.Now() + op.TTL
+		}
+		return m, nil
+	case OpMergePatch:
+		doc, err := mergePatch(cur.target(), []byte(op.Value))
+		if err != nil {
+			return kv.Mutation{}, err
+		}
+		return kv.Mutation{Op: kv.OpPut, Value: kv.WithMeta(string(doc), cur.meta()), ExpiresAt: cur.Entry.ExpiresAt}, nil
+	}
+	return kv.Mutation{}, fmt.Errorf("unsupported conditional op %q", op.Op)
+}
+
+type casState struct {
+	locks [casLocks]sync.Mutex
+}
+
+func (c *casState) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &c.locks[h.Sum32()%casLocks]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// casConsistency é o nível das duas fases de uma operação condicional: no
+// mínimo QUORUM, para a leitura ver a última escrita condicional aceita.
+func casConsistency(cl Consistency) Consistency {
+	if cl == One {
+		return Quorum
+	}
+	return cl
+}
+
+// CompareAndSet executa op na chave como operação condicional, no dono da
+// chave (encaminhando se for outro nó). Com ErrPreconditionFailed,
+// Previous é a versão atual.
+func (r *Router) CompareAndSet(ctx context.Context, key string, cl Consistency, op CASOp) (CASResult, error) {
+	if err := r.checkWritable(); err != nil {
+		return CASResult{}, err
+	}
+	replicas := r.replicasForKey(key)
+	if len(replicas) == 0 {
+		return CASResult{}, fmt.Errorf("no replicas for key")
+	}
+	for _, node := range replicas {
+		if !r.isLocal(node) && !r.supportsCAS(ctx, node) {
+			continue
+		}
+		if r.isLocal(node) {
+			return r.applyCAS(ctx, key, cl, op, replicas)
+		}
+		res, err := r.forwardCAS(ctx, node, CASRequest{Key: key, Consistency: cl, Op: op})
+		var fwd *casForwardError
+		if errors.As(err, &fwd) && ctx.Err() == nil {
+			// dono fora do ar: o próximo da ordem assume
+			log.Printf("[CAS] key=%s owner %s unreachable: %v", key, node.ID, err)
+			continue
+		}
+		return res, err
+	}
+	return CASResult{}, fmt.Errorf("no replica able to run conditional writes (internal protocol >= %d)", casProtocolVersion)
+}
+
+// ApplyCAS executa uma operação encaminhada por outro coordenador (este nó
+// é o dono da chave).
+func (r *Router) ApplyCAS(ctx context.Context, req CASRequest) (CASResult, error) {
+	if err := r.checkWritable(); err != nil {
+		return CASResult{}, err
+	}
+	replicas := r.replicasForKey(req.Key)
+	if len(replicas) == 0 {
+		return CASResult{}, fmt.Errorf("no replicas for key")
+	}
+	return r.applyCAS(ctx, req.Key, req.Consistency, req.Op, replicas)
+}
+
+func (r *Router) applyCAS(ctx context.Context, key string, cl Consistency, op CASOp, replicas []hashring.NodeInfo) (CASResult, error) {
+	cl = casConsistency(cl)
+	unlock := r.cas.lock(key)
+	defer unlock()
+
+	for attempt := 1; ; attempt++ {
+		cur, err := r.newestVersion(ctx, key, false, cl, replicas)
+		if err != nil {
+			return CASResult{}, err
+		}
+		prev := Version{Entry: cur.entry, Found: cur.found}
+		m, err := op.mutation(prev)
+		if err != nil {
+			return CASResult{Previous: prev}, err
+		}
+		if _, large := parseManifest(m.Value); m.Op == kv.OpPut && !large {
+			// o valor novo (incremento, merge-patch...) só existe aqui; o de
+			// um manifesto foi validado antes dos chunks (casPut)
+			if err := r.ValidateValue(key, m.Value); err != nil {
+				return CASResult{Previous: prev}, err
+			}
+		}
+		m.Key = key
+		m.Writer = string(r.nodeID)
+		// a mutação precisa ganhar da versão lida no last-write-wins
+		m.Timestamp = kv.Now()
+		if m.Timestamp <= cur.entry.Timestamp {
+			m.Timestamp = cur.entry.Timestamp + 1
+		}
+
+		err = r.replicateCAS(ctx, m, cur.entry.Timestamp, cl, replicas)
+		if err == nil {
+			metrics.Inc("cas.applied")
+			r.observe(m)
+			return CASResult{Previous: prev, Timestamp: m.Timestamp}, nil
+		}
+		if !errors.Is(err, ErrCASConflict) || attempt >= casAttempts {
+			if errors.Is(err, ErrCASConflict) {
+				metrics.Inc("cas.conflicts")
+			}
+			return CASResult{}, err
+		}
+		log.Printf("[CAS] key=%s conflict on attempt %d, retrying", key, attempt)
+		select {
+		case <-ctx.Done():
+			return CASResult{}, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+// casForwardError: o dono da chave não respondeu (a operação não chegou a
+// ser executada por ele, ou não se sabe).
+type casForwardError struct{ err error }
+
+func (e *casForwardError) Error() string { return e.err.Error() }
+func (e *casForwardError) Unwrap() error { return e.err }
+
+// forwardCAS encaminha a operação ao dono da chave. Os erros de aplicação
+// voltam pelo status (409 conflito, 412 pré-condição, 422 valor não inteiro
+// ou não JSON).
+func (r *Router) forwardCAS(ctx context.Context, owner hashring.NodeInfo, req CASRequest) (CASResult, error) {
+	body, _ := json.Marshal(req)
+	out := r.call(ctx, owner, "POST", CASPath, body)
+	if out.Err != nil {
+		return CASResult{}, &casForwardError{fmt.Errorf("forward CAS to %s: %w", owner.Host, out.Err)}
+	}
+	msg := strings.TrimSpace(string(out.Body))
+	switch {
+	case out.Status == http.StatusConflict:
+		return CASResult{}, fmt.Errorf("%w (owner %s)", ErrCASConflict, owner.ID)
+	case out.Status == http.StatusUnprocessableEntity:
+		if msg == ErrNotJSON.Error() {
+			return CASResult{}, ErrNotJSON
+		}
+		if msg == ErrLargeObjectTTL.Error() {
+			return CASResult{}, ErrLargeObjectTTL
+		}
+		var se SchemaError
+		if json.Unmarshal(out.Body, &se) == nil && len(se.Errors) > 0 {
+			return CASResult{}, &se
+		}
+		return CASResult{}, ErrNotInteger
+	case out.Status == http.StatusPreconditionFailed:
+		var res CASResult
+		json.Unmarshal(out.Body, &res)
+		return res, ErrPreconditionFailed
+	case out.Status >= 300:
+		return CASResult{}, fmt.Errorf("CAS on owner %s: status=%d: %s", owner.ID, out.Status, msg)
+	}
+	var res CASResult
+	if err := json.Unmarshal(out.Body, &res); err != nil {
+		return CASResult{}, fmt.Errorf("CAS on owner %s: %w", owner.ID, err)
+	}
+	return res, nil
+}
+
+// supportsCAS diz se o nó entende ReplicaCASPath.
+func (r *Router) supportsCAS(ctx context.Context, node hashring.NodeInfo) bool {
+	p, err := r.protocol.negotiate(ctx, node.Host)
+	return err == nil && p.Negotiated >= casProtocolVersion
+}
+
+// replicateCAS envia a mutação condicional às réplicas. Réplicas fora do ar
+// só ganham hint se a operação for aceita.
+func (r *Router) replicateCAS(ctx context.Context, m kv.Mutation, expected int64, cl Consistency, replicas []hashring.NodeInfo) error {
+	if err := r.gate.enter(); err != nil {
+		return err
+	}
+	defer r.gate.leave()
+
+	counts, need, err := r.acksFor(ctx, cl, replicas)
+	if err != nil {
+		return err
+	}
+	applied := make([]bool, len(replicas))
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, node := range replicas {
+		if r.isLocal(node) {
+			applied[i], _ = r.localStore.ApplyIf(m, expected)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, node hashring.NodeInfo) {
+			defer wg.Done()
+			applied[i], errs[i] = r.sendCAS(ctx, node, ReplicaCASRequest{Mutation: m, Expected: expected})
+		}(i, node)
+	}
+	wg.Wait()
+
+	acks, conflicts := 0, 0
+	var failed []error
+	for i := range replicas {
+		switch {
+		case errs[i] != nil:
+			failed = append(failed, errs[i])
+		case applied[i] && counts[i]:
+			acks++
+		case !applied[i]:
+			conflicts++
+		}
+	}
+	if acks < need {
+		if conflicts > 0 {
+			return ErrCASConflict
+		}
+		metrics.Inc("writes.unavailable")
+		return &WriteError{Consistency: cl, Required: need, Acks: acks, Alive: liveCount(counts, errs), Errs: failed}
+	}
+	for i, node := range replicas {
+		if errs[i] != nil {
+			metrics.Inc("replication.failures")
+			r.storeHint(node, Hint{Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Delete: m.Op == kv.OpDelete})
+		}
+	}
+	return nil
+}
+
+// sendCAS envia uma mutação condicional a uma réplica remota.
+func (r *Router) sendCAS(ctx context.Context, node hashring.NodeInfo, req ReplicaCASRequest) (bool, error) {
+	ctx, cancel := r.replicaContext(ctx)
+	defer cancel()
+	body, _ := json.Marshal(req)
+	res := r.call(ctx, node, "POST", ReplicaCASPath, body)
+	if !res.OK() {
+		return false, fmt.Errorf("remote CAS to %s: %s", node.Host, res.Error())
+	}
+	var out ReplicaCASResult
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return false, fmt.Errorf("remote CAS to %s: %w", node.Host, err)
+	}
+	return out.Applied, nil
+}
+
+// GetSet grava value na chave e retorna a versão anterior, como uma
+// operação condicional (nenhuma escrita concorrente fica entre as duas).
+func (r *Router) GetSet(ctx context.Context, key, value string, cl Consistency, ttl time.Duration) (Version, error) {
+	res, err := r.casPut(ctx, key, cl, CASOp{Op: kv.OpPut, Value: value, TTL: ttl.Microseconds()})
+	return res.Previous, err
+}
+
+// DeleteIf apaga a chave só se a versão atual satisfizer a condição de op
+// (IfVersion/IfExists); senão retorna ErrPreconditionFailed com a versão
+// atual.
+func (r *Router) DeleteIf(ctx context.Context, key string, cl Consistency, ifVersion int64, ifExists bool) (Version, error) {
+	res, err := r.CompareAndSet(ctx, key, cl, CASOp{Op: kv.OpDelete, IfVersion: ifVersion, IfExists: ifExists})
+	return res.Previous, err
+}
+
+// incremented é o valor de cur somado a by (chave inexistente vale 0).
+func incremented(cur Version, by int64) (int64, error) {
+	var n int64
+	if cur.Found {
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(kv.ValueBody(cur.Entry.Value)), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (by > 0 && n > math.MaxInt64-by) || (by < 0 && n < math.MinInt64-by) {
+		return 0, fmt.Errorf("increment overflows int64")
+	}
+	return n + by, nil
+}
+
+// Increment soma by ao valor inteiro da chave (inexistente = 0), como
+// operação condicional, e retorna o valor novo.
+func (r *Router) Increment(ctx context.Context, key string, by int64, cl Consistency) (int64, error) {
+	res, err := r.CompareAndSet(ctx, key, cl, CASOp{Op: OpIncr, By: by})
+	if err != nil {
+		return 0, err
+	}
+	return incremented(res.Previous, by)
+}
+
+// Expire troca o TTL de uma chave existente sem o cliente reenviar o valor
+// (ttl=0 tira o TTL, o "persist"): o dono regrava o valor atual com o novo
+// ExpiresAt, replicado como qualquer mutação. Retorna a versão gravada;
+// ErrPreconditionFailed se a chave não existe.
+func (r *Router) Expire(ctx context.Context, key string, ttl time.Duration, cl Consistency) (int64, error) {
+	res, err := r.CompareAndSet(ctx, key, cl, CASOp{Op: OpExpire, TTL: ttl.Microseconds()})
+	if err != nil {
+		return 0, err
+	}
+	return res.Timestamp, nil
+}
@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/msgpack"
+)
+
+// Codecs dos corpos de /internal/replica/put e /internal/replica/delete.
+// Todo nó entende os de SupportedCodecs e os anuncia no handshake; o
+// coordenador usa o INTERNODE_CODEC configurado só com os nós que o
+// anunciaram (os outros, e os nós antigos, recebem JSON).
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+
+	msgpackContentType = "application/msgpack"
+)
+
+// SupportedCodecs são os codecs que este nó aceita.
+var SupportedCodecs = []string{CodecJSON, CodecMsgpack}
+
+// Codec codifica as mutações enviadas às réplicas. É escrito à mão para cada
+// formato, sem reflexão.
+type Codec interface {
+	Name() string
+	ContentType() string
+	// EncodeMutation escreve m (put ou delete) em buf
+	EncodeMutation(buf *bytes.Buffer, m kv.Mutation) error
+	// DecodeMutation lê o corpo de uma chamada de réplica; op é o da rota
+	// (o corpo não diz se é put ou delete)
+	DecodeMutation(data []byte, op string) (kv.Mutation, error)
+}
+
+var codecs = [...]Codec{jsonCodec{}, msgpackCodec{}}
+
+// ParseCodec valida o valor de INTERNODE_CODEC.
+func ParseCodec(s string) (string, error) {
+	switch s {
+	case "", CodecJSON:
+		return CodecJSON, nil
+	case CodecMsgpack:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown internode codec %q (use json or msgpack)", s)
+	}
+}
+
+// codecIndex é a posição do codec name em codecs (o JSON se não existir).
+func codecIndex(name string) int {
+	for i, c := range codecs {
+		if c.Name() == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// CodecFor escolhe o codec de um corpo recebido pelo Content-Type (JSON se
+// não houver, como mandam os nós antigos).
+func CodecFor(contentType string) Codec {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil && t == msgpackContentType {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+// SetInternodeCodec escolhe o codec das mutações enviadas às réplicas.
+func (r *Router) SetInternodeCodec(name string) {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.codec = name
+	t.peers = make(map[string]*PeerProtocol)
+}
+
+// InternodeCodec retorna o codec configurado.
+func (r *Router) InternodeCodec() string {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.codec
+}
+
+// peerCodec escolhe o codec a usar com um nó que aceita accepted.
+func (t *protocolTransport) peerCodec(accepted []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range accepted {
+		if c == t.codec {
+			return c
+		}
+	}
+	return CodecJSON
+}
+
+// codecFor é o codec combinado com host (JSON se o handshake falhar: a
+// chamada vai falhar do mesmo jeito no RoundTrip).
+func (t *protocolTransport) codecFor(host string) int {
+	t.mu.Lock()
+	p, ok := t.peers[host]
+	t.mu.Unlock()
+	if !ok || p.Error != "" {
+		return 0
+	}
+	return codecIndex(p.Codec)
+}
+
+// jsonCodec é o formato original: replicaPutRequest ou replicaDeleteRequest.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return CodecJSON }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) EncodeMutation(buf *bytes.Buffer, m kv.Mutation) error {
+	enc := json.NewEncoder(buf)
+	if m.Op == kv.OpDelete {
+		return enc.Encode(replicaDeleteRequest{Key: m.Key, Timestamp: m.Timestamp, Writer: m.Writer})
+	}
+	return enc.Encode(replicaPutRequest{Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Writer: m.Writer})
+}
+
+func (jsonCodec) DecodeMutation(data []byte, op string) (kv.Mutation, error) {
+	var req replicaPutRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return kv.Mutation{}, err
+	}
+	m := kv.Mutation{Op: op, Key: req.Key, Timestamp: req.Timestamp, Writer: req.Writer}
+	if op != kv.OpDelete {
+		m.Value, m.ExpiresAt = req.Value, req.ExpiresAt
+	}
+	return m, nil
+}
+
+// msgpackCodec manda um mapa com as mesmas chaves do JSON: o valor vai sem
+// escapes nem base64, e os números em binário.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string        { return CodecMsgpack }
+func (msgpackCodec) ContentType() string { return msgpackContentType }
+
+func (msgpackCodec) EncodeMutation(buf *bytes.Buffer, m kv.Mutation) error {
+	n := 2
+	if m.Op != kv.OpDelete {
+		n++
+		if m.ExpiresAt != 0 {
+			n++
+		}
+	}
+	if m.Writer != "" {
+		n++
+	}
+	// o valor é escrito direto na capacidade do buffer
+	buf.Grow(len(m.Key) + len(m.Value) + len(m.Writer) + 64)
+	b := buf.AvailableBuffer()
+	b = msgpack.AppendMapHeader(b, n)
+	b = msgpack.AppendString(msgpack.AppendString(b, "key"), m.Key)
+	if m.Op != kv.OpDelete {
+		b = msgpack.AppendString(msgpack.AppendString(b, "value"), m.Value)
+		if m.ExpiresAt != 0 {
+			b = msgpack.AppendInt(msgpack.AppendString(b, "expires_at"), m.ExpiresAt)
+		}
+	}
+	b = msgpack.AppendInt(msgpack.AppendString(b, "timestamp"), m.Timestamp)
+	if m.Writer != "" {
+		b = msgpack.AppendString(msgpack.AppendString(b, "writer"), m.Writer)
+	}
+	buf.Write(b)
+	return nil
+}
+
+func (msgpackCodec) DecodeMutation(data []byte, op string) (kv.Mutation, error) {
+	m := kv.Mutation{Op: op}
+	rd := msgpack.NewReader(data)
+	n, err := rd.ReadMapHeader()
+	if err != nil {
+		return m, err
+	}
+	for i := 0; i < n; i++ {
+		field, err := rd.ReadStringBytes()
+		if err != nil {
+			return m, err
+		}
+		switch string(field) {
+		case "key":
+			m.Key, err = rd.ReadString()
+		case "value":
+			m.Value, err = rd.ReadString()
+		case "timestamp":
+			m.Timestamp, err = rd.ReadInt()
+		case "expires_at":
+			m.ExpiresAt, err = rd.ReadInt()
+		case "writer":
+			m.Writer, err = rd.ReadString()
+		default:
+			err = rd.Skip()
+		}
+		if err != nil {
+			return m, fmt.Errorf("field %q: %w", field, err)
+		}
+	}
+	if op == kv.OpDelete {
+		m.Value, m.ExpiresAt = "", 0
+	}
+	return m, nil
+}
@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/metrics"
+)
+
+// Compressão dos corpos das chamadas internas (replica put, streaming,
+// repair). Todo nó sabe descomprimir os encodings de SupportedCompression e
+// os anuncia no handshake; o coordenador só comprime para os nós que
+// anunciaram o encoding configurado em INTERNODE_COMPRESSION e só corpos a
+// partir de INTERNODE_COMPRESSION_MIN_BYTES.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+
+	DefaultCompressionMinBytes = 4096
+)
+
+// SupportedCompression são os encodings que este nó aceita em corpos de
+// requisições internas.
+var SupportedCompression = []string{CompressionGzip}
+
+// ParseCompression valida o valor de INTERNODE_COMPRESSION.
+func ParseCompression(s string) (string, error) {
+	switch s {
+	case "", CompressionNone:
+		return "", nil
+	case CompressionGzip:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown internode compression %q (use none or gzip)", s)
+	}
+}
+
+// SetInternodeCompression liga a compressão dos corpos enviados aos outros
+// nós (encoding "" desliga) a partir de minBytes.
+func (r *Router) SetInternodeCompression(encoding string, minBytes int) {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.compression = encoding
+	t.compressMin = minBytes
+	t.peers = make(map[string]*PeerProtocol)
+}
+
+// InternodeCompression retorna o encoding e o tamanho mínimo configurados
+// (usados também para as respostas internas).
+func (r *Router) InternodeCompression() (string, int) {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.compression, t.compressMin
+}
+
+// peerCompression escolhe o encoding a usar com um nó que aceita accepted.
+func (t *protocolTransport) peerCompression(accepted []string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, enc := range accepted {
+		if enc == t.compression {
+			return enc
+		}
+	}
+	return ""
+}
+
+// gzipWriters reaproveita os gzip.Writer (cada um aloca centenas de KB de
+// estado).
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// compressBody comprime o corpo da requisição (já clonada) com encoding, se
+// ele tiver pelo menos o tamanho mínimo.
+func (t *protocolTransport) compressBody(req *http.Request, encoding string) error {
+	t.mu.Lock()
+	min := t.compressMin
+	t.mu.Unlock()
+	if encoding != CompressionGzip || req.Body == nil || req.Body == http.NoBody || req.ContentLength < int64(min) {
+		return nil
+	}
+	pooled := bufpool.Get()
+	defer bufpool.Put(pooled)
+	_, err := pooled.ReadFrom(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	raw := pooled.Bytes()
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(&buf)
+	zw.Write(raw)
+	err = zw.Close()
+	gzipWriters.Put(zw)
+	if err != nil {
+		return err
+	}
+	if buf.Len() >= len(raw) {
+		// não compensou (dados já comprimidos): manda como veio
+		req.Body = io.NopCloser(bytes.NewReader(bytes.Clone(raw)))
+		return nil
+	}
+	compressed := buf.Bytes()
+	metrics.Add("internode.compression.raw_bytes", int64(len(raw)))
+	metrics.Add("internode.compression.sent_bytes", int64(len(compressed)))
+	req.Body = io.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(compressed)), nil }
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", CompressionGzip)
+	return nil
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"mini-cassandra/hashring"
+)
+
+// Consistency é o nível de consistência de uma operação: quantas réplicas
+// precisam confirmar uma escrita (ou responder a uma leitura) para que ela
+// seja aceita. LOCAL_QUORUM é o quorum só das réplicas do datacenter do
+// coordenador (DATACENTER), as dos outros datacenters não contam.
+type Consistency string
+
+const (
+	One         Consistency = "ONE"
+	Quorum      Consistency = "QUORUM"
+	LocalQuorum Consistency = "LOCAL_QUORUM"
+	All         Consistency = "ALL"
+)
+
+// DefaultWriteConsistency mantém o comportamento original (todas as réplicas),
+// e DefaultReadConsistency o das leituras (a primeira réplica que responder).
+const (
+	DefaultWriteConsistency = All
+	DefaultReadConsistency  = One
+)
+
+// ParseConsistency lê um nível de consistência (sem diferenciar maiúsculas).
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(strings.ToUpper(strings.TrimSpace(s))); c {
+	case One, Quorum, LocalQuorum, All:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid consistency level %q (ONE, QUORUM, LOCAL_QUORUM or ALL)", s)
+}
+
+// Required retorna quantas das n réplicas precisam confirmar (em
+// LOCAL_QUORUM, n são as réplicas do datacenter local).
+func (c Consistency) Required(n int) int {
+	switch c {
+	case One:
+		return 1
+	case Quorum, LocalQuorum:
+		return n/2 + 1
+	default:
+		return n
+	}
+}
+
+// ErrUnavailable é a falha de um nível de consistência por falta de réplicas
+// vivas: errors.Is acha um WriteError ou ReadError em que as réplicas
+// alcançadas (mesmo as que responderam com erro) não bastavam para o nível,
+// ao contrário de réplicas vivas que deram erro ou estouraram o prazo.
+var ErrUnavailable = errors.New("not enough live replicas for the consistency level")
+
+// WriteError é retornado quando uma escrita não teve confirmações suficientes.
+// As réplicas que falharam recebem um hint mesmo assim.
+type WriteError struct {
+	Consistency Consistency
+	Required    int
+	Acks        int
+	// Alive: réplicas que contam para o nível e foram alcançadas
+	Alive int
+	Errs  []error
+}
+
+func (e *WriteError) Error() string {
+	if e.Unavailable() {
+		return fmt.Sprintf("%s write unavailable: needs %d replicas, %d alive: %v", e.Consistency, e.Required, e.Alive, e.Errs)
+	}
+	return fmt.Sprintf("%s write needs %d acks, got %d: %v", e.Consistency, e.Required, e.Acks, e.Errs)
+}
+
+// Unavailable diz se faltaram réplicas vivas (e não confirmações de réplicas
+// vivas).
+func (e *WriteError) Unavailable() bool {
+	return e.Alive < e.Required
+}
+
+func (e *WriteError) Is(target error) bool {
+	return target == ErrUnavailable && e.Unavailable()
+}
+
+// Unwrap expõe as falhas das réplicas (errors.Is acha, por exemplo, um
+// context.DeadlineExceeded de uma escrita que estourou o prazo).
+func (e *WriteError) Unwrap() []error {
+	return e.Errs
+}
+
+// ReadError é retornado quando uma leitura não teve respostas suficientes.
+type ReadError struct {
+	Consistency Consistency
+	Required    int
+	Responses   int
+	// Alive: réplicas que contam para o nível e foram alcançadas
+	Alive int
+	Errs  []error
+}
+
+func (e *ReadError) Error() string {
+	if e.Unavailable() {
+		return fmt.Sprintf("%s read unavailable: needs %d replicas, %d alive: %v", e.Consistency, e.Required, e.Alive, e.Errs)
+	}
+	return fmt.Sprintf("%s read needs %d responses, got %d: %v", e.Consistency, e.Required, e.Responses, e.Errs)
+}
+
+// Unavailable diz se faltaram réplicas vivas.
+func (e *ReadError) Unavailable() bool {
+	return e.Alive < e.Required
+}
+
+func (e *ReadError) Is(target error) bool {
+	return target == ErrUnavailable && e.Unavailable()
+}
+
+func (e *ReadError) Unwrap() []error {
+	return e.Errs
+}
+
+// acksFor diz quais das réplicas contam para cl e quantas confirmações são
+// necessárias. Em LOCAL_QUORUM só contam as do datacenter deste nó (o dos
+// outros vem do handshake).
+func (r *Router) acksFor(ctx context.Context, cl Consistency, replicas []hashring.NodeInfo) ([]bool, int, error) {
+	counts := make([]bool, len(replicas))
+	n := 0
+	for i, node := range replicas {
+		if cl != LocalQuorum || r.inLocalDatacenter(ctx, node) {
+			counts[i] = true
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, 0, fmt.Errorf("%s: no replicas in datacenter %q", cl, r.meta.Datacenter)
+	}
+	return counts, cl.Required(n), nil
+}
+
+// liveCount conta as réplicas que contam para o nível (counts) e foram
+// alcançadas: errs[i] nil ou um erro que não é de conexão. Uma réplica que
+// respondeu com erro, ou estourou o prazo, está viva.
+func liveCount(counts []bool, errs []error) int {
+	n := 0
+	for i, c := range counts {
+		if c && !unreachable(errs[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+// unreachable diz se err é uma falha ao conectar na réplica.
+func unreachable(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// inLocalDatacenter diz se node está no datacenter deste nó (um nó sem
+// handshake não conta).
+func (r *Router) inLocalDatacenter(ctx context.Context, node hashring.NodeInfo) bool {
+	if r.isLocal(node) {
+		return true
+	}
+	p, err := r.protocol.negotiate(ctx, node.Host)
+	return err == nil && p.Datacenter == r.meta.Datacenter
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// CorruptHeader vem no 500 de /internal/replica/get quando a versão local da
+// chave falhou no checksum (a réplica se repara sozinha em seguida).
+const CorruptHeader = "X-Corrupt"
+
+// RecoverCorrupt busca key nas outras réplicas depois que a versão local
+// falhou no checksum e troca a cópia local pela mais nova entre as que
+// responderam (um tombstone também vale; se nenhuma tiver a chave, a cópia
+// corrompida é removida). Sem nenhuma outra réplica respondendo, a cópia
+// local fica como está e a leitura falha.
+func (r *Router) RecoverCorrupt(ctx context.Context, key string) (replicaRead, error) {
+	metrics.Inc("read.corrupt")
+	log.Printf("[CORRUPT] key=%s failed checksum validation on node %s; fetching it from other replicas", key, r.nodeID)
+
+	var newest replicaRead
+	answered := 0
+	for _, node := range r.orderForRead(r.replicasForKey(key)) {
+		if r.isLocal(node) {
+			continue
+		}
+		rr, err := r.readReplica(ctx, node, key, false)
+		if err != nil {
+			log.Printf("[CORRUPT] key=%s: replica %s failed: %v", key, node.ID, err)
+			continue
+		}
+		answered++
+		if rr.newer(newest) {
+			newest = rr
+		}
+	}
+	if answered == 0 {
+		metrics.Inc("read.corrupt_unrecovered")
+		log.Printf("[CORRUPT] key=%s could not be recovered: no other replica answered", key)
+		return replicaRead{}, fmt.Errorf("key=%s: %w and no other replica answered", key, kv.ErrCorrupt)
+	}
+
+	e := newest.entry
+	e.Deleted = newest.tombstone
+	r.localStore.Restore(key, e, !newest.missing())
+	metrics.Inc("read.corrupt_repaired")
+	switch {
+	case newest.missing():
+		log.Printf("[CORRUPT] key=%s not found on %d other replicas; dropped the corrupt local copy", key, answered)
+	case newest.tombstone:
+		log.Printf("[CORRUPT] key=%s restored as a tombstone (ts=%d) from %d replicas", key, e.Timestamp, answered)
+	default:
+		log.Printf("[CORRUPT] key=%s restored from %d replicas (ts=%d)", key, answered, e.Timestamp)
+	}
+	return newest, nil
+}
@@ -0,0 +1,28 @@
+package cluster
+
+import (
+	"strings"
+
+	"mini-cassandra/internal/kv"
+)
+
+// LocalCount conta as chaves do store local que batem com o filtro
+// (keyspace e/ou prefixo, vazios = tudo). primary conta só as chaves das
+// quais este nó é a réplica primária: somando primary de todos os nós
+// cada chave entra uma vez só, mesmo com replicação.
+func (r *Router) LocalCount(keyspace, prefix string) (primary, total int) {
+	r.localStore.Iterate(prefix, "", func(key, _ string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if keyspace != "" && kv.KeyspaceOf(key) != keyspace {
+			return true
+		}
+		total++
+		if node, ok := r.ring.GetNodeForKey(key); ok && r.isLocal(node) {
+			primary++
+		}
+		return true
+	})
+	return primary, total
+}
@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"context"
+
+	"mini-cassandra/internal/kv"
+)
+
+// Sets e maps CRDT (ver kv/crdt.go). Um add grava só a parte nova do estado
+// (as réplicas combinam com o que têm), sem ler nada antes; o remove e o put
+// de um campo leem o estado com a mesma consistência da escrita para saber
+// quais tags apagar. Nas leituras com mais de uma réplica, estados
+// diferentes são combinados como uma estratégia de merge.
+
+// mergeCRDT é a MergeFunc dos CRDTs (usada em qualquer keyspace).
+func mergeCRDT(_ context.Context, _ string, versions []kv.Entry) (string, error) {
+	out, err := kv.ParseCRDT(versions[0].Value, kv.CRDTSet)
+	if err != nil {
+		if out, err = kv.ParseCRDT(versions[0].Value, kv.CRDTMap); err != nil {
+			return "", err
+		}
+	}
+	for _, v := range versions[1:] {
+		st, err := kv.ParseCRDT(v.Value, out.Type)
+		if err != nil {
+			// versão mais antiga com outro valor: o CRDT a sobrescreveu
+			continue
+		}
+		out, _ = kv.MergeCRDT(out, st)
+	}
+	return out.Encode(), nil
+}
+
+// CRDT lê o estado do CRDT typ em key exigindo cl. found=false: a chave não
+// existe (um estado vazio); kv.ErrNotCRDT se ela guarda outro tipo de valor.
+func (r *Router) CRDT(ctx context.Context, key, typ string, cl Consistency) (st kv.CRDTState, found bool, err error) {
+	e, ok, err := r.GetWith(ctx, key, cl)
+	if err != nil {
+		return kv.CRDTState{}, false, err
+	}
+	if !ok {
+		return kv.NewCRDT(typ), false, nil
+	}
+	st, err = kv.ParseCRDT(e.Value, typ)
+	return st, err == nil, err
+}
+
+// writeCRDT grava o delta nas réplicas (que o combinam com o estado delas).
+func (r *Router) writeCRDT(ctx context.Context, key string, delta kv.CRDTState, ts int64, cl Consistency) error {
+	if err := r.checkWritable(); err != nil {
+		return err
+	}
+	_, err := r.replicate(ctx, kv.Mutation{Op: kv.OpPut, Key: key, Value: delta.Encode(), Timestamp: ts}, cl)
+	return err
+}
+
+// SetAdd adiciona elementos ao set em key.
+func (r *Router) SetAdd(ctx context.Context, key string, elems []string, cl Consistency) error {
+	ts := kv.Now()
+	delta := kv.NewCRDT(kv.CRDTSet)
+	for _, elem := range elems {
+		delta.Add(elem, kv.NewTag(string(r.nodeID), ts), "")
+	}
+	return r.writeCRDT(ctx, key, delta, ts, cl)
+}
+
+// SetRemove remove elementos do set em key (os adds que o coordenador viu;
+// um add concorrente sobrevive). Retorna quantos estavam no set.
+func (r *Router) SetRemove(ctx context.Context, key string, elems []string, cl Consistency) (int, error) {
+	return r.removeCRDT(ctx, key, kv.CRDTSet, elems, cl)
+}
+
+// MapPut grava campos do map em key; os valores anteriores vistos desses
+// campos são removidos.
+func (r *Router) MapPut(ctx context.Context, key string, fields map[string]string, cl Consistency) error {
+	cur, _, err := r.CRDT(ctx, key, kv.CRDTMap, cl)
+	if err != nil {
+		return err
+	}
+	ts := kv.Now()
+	delta := kv.NewCRDT(kv.CRDTMap)
+	names := make([]string, 0, len(fields))
+	for field, v := range fields {
+		delta.Add(field, kv.NewTag(string(r.nodeID), ts), v)
+		names = append(names, field)
+	}
+	delta.Remove(cur, names)
+	return r.writeCRDT(ctx, key, delta, ts, cl)
+}
+
+// MapRemove remove campos do map em key. Retorna quantos existiam.
+func (r *Router) MapRemove(ctx context.Context, key string, fields []string, cl Consistency) (int, error) {
+	return r.removeCRDT(ctx, key, kv.CRDTMap, fields, cl)
+}
+
+func (r *Router) removeCRDT(ctx context.Context, key, typ string, elems []string, cl Consistency) (int, error) {
+	cur, found, err := r.CRDT(ctx, key, typ, cl)
+	if err != nil || !found {
+		return 0, err
+	}
+	delta := kv.NewCRDT(typ)
+	n := delta.Remove(cur, elems)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, r.writeCRDT(ctx, key, delta, kv.Now(), cl)
+}
@@ -0,0 +1,150 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// Limites padrão do guard de disco (percentual usado do filesystem).
+const (
+	DefaultDiskMaxUsedPercent = 95
+	DefaultDiskResumePercent  = 90
+	DefaultDiskCheckInterval  = 10 * time.Second
+)
+
+// diskGuardReason prefixa o motivo do modo somente leitura ligado pelo guard
+// (para ele só desligar o que ele mesmo ligou).
+const diskGuardReason = "disk usage guardrail"
+
+// DiskGuardPolicy diz quando o nó entra e sai do modo somente leitura por
+// falta de espaço nos diretórios de dados.
+type DiskGuardPolicy struct {
+	Paths []string
+	// MaxUsedPercent: acima disso o nó vira somente leitura (0 desliga)
+	MaxUsedPercent float64
+	// ResumePercent: abaixo disso volta a aceitar escritas
+	ResumePercent float64
+	Interval      time.Duration
+}
+
+// DiskUsage é o uso do filesystem de um diretório de dados.
+type DiskUsage struct {
+	Path        string  `json:"path"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	UsedPercent float64 `json:"used_percent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// DiskStatus é o estado do guard de disco (GET /admin/disk).
+type DiskStatus struct {
+	Enabled        bool        `json:"enabled"`
+	MaxUsedPercent float64     `json:"max_used_percent,omitempty"`
+	ResumePercent  float64     `json:"resume_percent,omitempty"`
+	Tripped        bool        `json:"tripped"`
+	Paths          []DiskUsage `json:"paths"`
+	CheckedAt      *time.Time  `json:"checked_at,omitempty"`
+}
+
+type diskGuard struct {
+	mu     sync.Mutex
+	status DiskStatus
+}
+
+// DiskStatus retorna a última medição do guard de disco.
+func (r *Router) DiskStatus() DiskStatus {
+	r.disk.mu.Lock()
+	defer r.disk.mu.Unlock()
+	st := r.disk.status
+	st.Paths = append([]DiskUsage(nil), st.Paths...)
+	// o modo somente leitura pode ter sido desligado à mão
+	st.Tripped = st.Tripped && strings.HasPrefix(r.ReadOnly().Reason, diskGuardReason)
+	if st.Paths == nil {
+		st.Paths = []DiskUsage{}
+	}
+	return st
+}
+
+// RunDiskGuard mede o uso de disco de p.Paths a cada p.Interval (usage é a
+// função que mede um diretório) e liga o modo somente leitura quando algum
+// passa de p.MaxUsedPercent, antes de o disco encher no meio de uma
+// escrita; desliga quando todos voltam abaixo de p.ResumePercent. Um modo
+// somente leitura ligado pelo operador não é desligado pelo guard.
+func (r *Router) RunDiskGuard(ctx context.Context, p DiskGuardPolicy, usage func(path string) (total, free uint64, err error)) {
+	if p.MaxUsedPercent <= 0 || len(p.Paths) == 0 {
+		return
+	}
+	if p.ResumePercent <= 0 || p.ResumePercent > p.MaxUsedPercent {
+		p.ResumePercent = p.MaxUsedPercent
+	}
+	if p.Interval <= 0 {
+		p.Interval = DefaultDiskCheckInterval
+	}
+	r.disk.mu.Lock()
+	r.disk.status = DiskStatus{Enabled: true, MaxUsedPercent: p.MaxUsedPercent, ResumePercent: p.ResumePercent}
+	r.disk.mu.Unlock()
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		r.checkDisk(p, usage)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Router) checkDisk(p DiskGuardPolicy, usage func(path string) (total, free uint64, err error)) {
+	paths := make([]DiskUsage, 0, len(p.Paths))
+	var worst *DiskUsage
+	for _, path := range p.Paths {
+		u := DiskUsage{Path: path}
+		total, free, err := usage(path)
+		if err != nil {
+			u.Error = err.Error()
+		} else if total > 0 {
+			u.TotalBytes, u.FreeBytes = total, free
+			u.UsedPercent = float64(total-free) * 100 / float64(total)
+		}
+		paths = append(paths, u)
+		if u.Error == "" && (worst == nil || u.UsedPercent > worst.UsedPercent) {
+			worst = &paths[len(paths)-1]
+		}
+	}
+	now := time.Now().UTC()
+
+	r.disk.mu.Lock()
+	defer r.disk.mu.Unlock()
+	r.disk.status.Paths, r.disk.status.CheckedAt = paths, &now
+	if worst == nil {
+		return
+	}
+
+	ro := r.ReadOnly()
+	ours := ro.Enabled && strings.HasPrefix(ro.Reason, diskGuardReason)
+	switch {
+	case worst.UsedPercent >= p.MaxUsedPercent && !ro.Enabled:
+		reason := fmt.Sprintf("%s: %s is %.1f%% full (limit %g%%)", diskGuardReason, worst.Path, worst.UsedPercent, p.MaxUsedPercent)
+		r.SetReadOnly(true, reason)
+		r.disk.status.Tripped = true
+		metrics.Inc("disk.readonly_trips")
+		log.Printf("[DISK] ALERT %s; node %s is now read-only", reason, r.nodeID)
+	case worst.UsedPercent >= p.MaxUsedPercent:
+		// já somente leitura (pelo guard ou pelo operador)
+		if ours {
+			log.Printf("[DISK] ALERT %s still %.1f%% full", worst.Path, worst.UsedPercent)
+		}
+	case ours && worst.UsedPercent < p.ResumePercent:
+		r.SetReadOnly(false, "")
+		r.disk.status.Tripped = false
+		log.Printf("[DISK] disk usage back to %.1f%% (below %g%%); node %s accepts writes again", worst.UsedPercent, p.ResumePercent, r.nodeID)
+	}
+}
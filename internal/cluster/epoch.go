@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/metrics"
+)
+
+// Época do ring: um contador que o coordenador de cada mudança de topologia
+// (move-token, vnodes, join, replace) incrementa antes de anunciá-la. Toda
+// chamada interna leva a época de quem chama em RingEpochHeader, e toda
+// resposta a de quem responde. Uma réplica recusa (421) mutações com época
+// menor que a dela: o coordenador está com o ring velho e pode estar
+// escrevendo nos nós errados. Quem vê uma época maior que a sua (na resposta
+// ou numa chamada recebida) busca o ring do nó que a mandou.
+const RingEpochHeader = "X-MC-Ring-Epoch"
+
+// ringEpochSyncInterval é o intervalo mínimo entre duas buscas do ring
+// disparadas por uma época mais nova.
+const ringEpochSyncInterval = time.Second
+
+// ErrStaleRingEpoch: a réplica recusou a mutação porque o ring deste
+// coordenador é mais velho que o dela.
+var ErrStaleRingEpoch = errors.New("stale ring epoch")
+
+// epochCheckedPaths são as mutações recusadas com época velha (leituras,
+// streaming e repair não mudam de réplica por causa do ring de quem chama).
+var epochCheckedPaths = map[string]bool{
+	"/internal/replica/put":    true,
+	"/internal/replica/delete": true,
+	ReplicaCASPath:             true,
+	ReplicaBatchPath:           true,
+}
+
+type epochState struct {
+	value atomic.Uint64
+	// wake recebe o host de um nó com época mais nova (RunRingEpochSync)
+	wake chan string
+	// lastLog: última recusa registrada no log (unix nano)
+	lastLog atomic.Int64
+}
+
+// noteNewer pede a busca do ring em host se a época raw for maior que a
+// deste nó.
+func (s *epochState) noteNewer(host, raw string) {
+	if s == nil || raw == "" {
+		return
+	}
+	e, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || e <= s.value.Load() {
+		return
+	}
+	select {
+	case s.wake <- host:
+	default:
+	}
+}
+
+// RingEpoch retorna a época do ring deste nó.
+func (r *Router) RingEpoch() uint64 {
+	return r.epoch.value.Load()
+}
+
+// adoptRingEpochLocked passa a época local para e se ela for maior (chamar
+// com topo.mu). Retorna se mudou.
+func (r *Router) adoptRingEpochLocked(e uint64) bool {
+	for {
+		cur := r.epoch.value.Load()
+		if e <= cur {
+			return false
+		}
+		if r.epoch.value.CompareAndSwap(cur, e) {
+			return true
+		}
+	}
+}
+
+// advanceRingEpoch incrementa a época local e grava o estado do ring. O
+// coordenador de uma mudança de topologia chama antes do Broadcast dela, que
+// leva a época nova a todos os nós.
+func (r *Router) advanceRingEpoch() uint64 {
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	e := r.epoch.value.Add(1)
+	if err := r.saveRingStateLocked(); err != nil {
+		log.Printf("[RING] saving ring epoch %d failed: %v", e, err)
+	}
+	log.Printf("[RING] ring epoch advanced to %d", e)
+	return e
+}
+
+// AdoptRingEpoch aplica a época de quem anunciou uma mudança de topologia
+// (os handlers de /internal/ring/*, depois de aplicar a mudança).
+func (r *Router) AdoptRingEpoch(req *http.Request) {
+	e, err := strconv.ParseUint(req.Header.Get(RingEpochHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	if r.adoptRingEpochLocked(e) {
+		if err := r.saveRingStateLocked(); err != nil {
+			log.Printf("[RING] saving ring epoch %d failed: %v", e, err)
+		}
+	}
+}
+
+// CheckRingEpoch confere a época de uma chamada interna recebida: erro
+// (ErrStaleRingEpoch) para uma mutação de um coordenador com época menor que
+// a deste nó. Uma época maior dispara a busca do ring em quem chamou.
+// Chamadas sem o header (nós antigos) passam.
+func (r *Router) CheckRingEpoch(req *http.Request) error {
+	raw := req.Header.Get(RingEpochHeader)
+	if raw == "" {
+		return nil
+	}
+	remote, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil
+	}
+	local := r.epoch.value.Load()
+	from := hashring.NodeID(req.Header.Get(NodeHeader))
+	switch {
+	case remote > local && !strings.HasPrefix(req.URL.Path, RingPath):
+		// este nó é que está com o ring velho (as mudanças em /internal/ring/*
+		// trazem a época junto)
+		if n, ok := r.nodeByID(from); ok {
+			r.epoch.noteNewer(n.Host, raw)
+		}
+	case remote+1 == local && r.pending.active() != nil:
+		// a mudança da época nova ainda está sendo anunciada: quem está uma
+		// época atrás recebeu os intervalos pendentes dela e já escreve
+		// também nos donos novos
+	case remote < local && epochCheckedPaths[req.URL.Path]:
+		metrics.Inc("ring.epoch.rejected")
+		now := time.Now().UnixNano()
+		if last := r.epoch.lastLog.Load(); now-last > int64(time.Second) && r.epoch.lastLog.CompareAndSwap(last, now) {
+			log.Printf("[RING] rejecting %s from %s: ring epoch %d, this node is at %d", req.URL.Path, from, remote, local)
+		}
+		return fmt.Errorf("%w: request has ring epoch %d, %s is at %d", ErrStaleRingEpoch, remote, r.nodeID, local)
+	}
+	return nil
+}
+
+// RunRingEpochSync busca o ring de um nó com época maior que a deste (visto
+// numa resposta ou numa chamada recebida) até ctx terminar, no máximo uma vez
+// por ringEpochSyncInterval.
+func (r *Router) RunRingEpochSync(ctx context.Context) {
+	var last time.Time
+	for {
+		var host string
+		select {
+		case <-ctx.Done():
+			return
+		case host = <-r.epoch.wake:
+		}
+		if wait := ringEpochSyncInterval - time.Since(last); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		last = time.Now()
+
+		// o nó que mandou a época nova primeiro, depois os outros
+		peers := []hashring.NodeInfo{{ID: hashring.NodeID(host), Host: host}}
+		for _, n := range r.ring.Nodes() {
+			if !r.isLocal(n) && n.Host != host {
+				peers = append(peers, n)
+			}
+		}
+		cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		snap, found := r.fetchRing(cctx, peers)
+		cancel()
+		if !found || snap.Epoch <= r.RingEpoch() {
+			continue
+		}
+		log.Printf("[RING] %s has ring epoch %d, this node %d: syncing the ring", snap.Node, snap.Epoch, r.RingEpoch())
+		metrics.Inc("ring.epoch.syncs")
+		if _, err := r.reconcileWith(snap); err != nil {
+			log.Printf("[RING] ring sync with %s failed: %v", snap.Node, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDraining é retornado para escritas de cliente depois de um drain.
+var ErrDraining = errors.New("node is draining: client writes are not accepted")
+
+// ErrReadOnly é retornado para escritas de cliente com o nó em modo
+// somente leitura (o erro devolvido inclui o motivo informado).
+var ErrReadOnly = errors.New("node is read-only: client writes are not accepted")
+
+// writeGate controla a entrada de escritas coordenadas por este nó e conta
+// quantas ainda estão em andamento (replicando para os outros nós).
+type writeGate struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+
+	// readOnly recusa as escritas de cliente (leituras, tráfego de réplica,
+	// hints e rebalance continuam)
+	readOnly      bool
+	readOnlyWhy   string
+	readOnlySince time.Time
+
+	// bootstrapping: o nó ainda está recebendo os dados dele (SEEDS ou
+	// REPLACE_NODE) e não deve receber tráfego de cliente
+	bootstrapping bool
+}
+
+// ReadOnlyStatus é o estado do modo somente leitura (GET /admin/readonly).
+type ReadOnlyStatus struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+func (g *writeGate) enter() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return ErrDraining
+	}
+	g.inflight++
+	return nil
+}
+
+func (g *writeGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inflight--
+}
+
+func (g *writeGate) pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.inflight
+}
+
+// Drain para de aceitar escritas de cliente e espera as que estão em
+// andamento terminarem (ou o ctx expirar). Retorna quantas estavam em
+// andamento no momento do drain.
+func (r *Router) Drain(ctx context.Context) (int, error) {
+	r.gate.mu.Lock()
+	r.gate.draining = true
+	waiting := r.gate.inflight
+	r.gate.mu.Unlock()
+
+	for r.gate.pending() > 0 {
+		select {
+		case <-ctx.Done():
+			return waiting, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return waiting, nil
+}
+
+// SetReadOnly liga ou desliga o modo somente leitura deste nó.
+func (r *Router) SetReadOnly(enabled bool, reason string) ReadOnlyStatus {
+	r.gate.mu.Lock()
+	if enabled {
+		if !r.gate.readOnly {
+			r.gate.readOnlySince = time.Now().UTC()
+		}
+		r.gate.readOnlyWhy = reason
+	} else {
+		r.gate.readOnlyWhy = ""
+	}
+	r.gate.readOnly = enabled
+	r.gate.mu.Unlock()
+	return r.ReadOnly()
+}
+
+// ReadOnly retorna o estado do modo somente leitura.
+func (r *Router) ReadOnly() ReadOnlyStatus {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	if !r.gate.readOnly {
+		return ReadOnlyStatus{}
+	}
+	since := r.gate.readOnlySince
+	return ReadOnlyStatus{Enabled: true, Reason: r.gate.readOnlyWhy, Since: &since}
+}
+
+// checkWritable recusa escritas de cliente com o nó em modo somente leitura.
+func (r *Router) checkWritable() error {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	if !r.gate.readOnly {
+		return nil
+	}
+	if r.gate.readOnlyWhy != "" {
+		return fmt.Errorf("%w (%s)", ErrReadOnly, r.gate.readOnlyWhy)
+	}
+	return ErrReadOnly
+}
+
+// SetBootstrapping marca o nó como em bootstrap até o job de entrada (ou de
+// substituição) terminar.
+func (r *Router) SetBootstrapping(on bool) {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	r.gate.bootstrapping = on
+}
+
+// Draining diz se o nó já recebeu um drain.
+func (r *Router) Draining() bool {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	return r.gate.draining
+}
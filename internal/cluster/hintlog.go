@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mini-cassandra/hashring"
+)
+
+// Filas de hints em disco: um arquivo NDJSON por nó de destino
+// (<dir>/<node>.hints), com um hint por linha. Hints novos são anexados ao
+// fim; o replay consome da frente em memória e só reescreve o arquivo quando
+// para (fila vazia, falha ou shutdown). Depois de um crash, os hints já
+// entregues desde a última reescrita são reenviados — sem problema, já que o
+// replay é idempotente (last-write-wins).
+const hintFileExt = ".hints"
+
+func hintPath(dir string, id hashring.NodeID) string {
+	return filepath.Join(dir, url.PathEscape(string(id))+hintFileExt)
+}
+
+// LoadHints liga a persistência dos hints em dir e carrega as filas que
+// ficaram pendentes antes do restart (descartando os hints expirados).
+// dir vazio mantém os hints só em memória.
+func (r *Router) LoadHints(dir string) error {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	r.hints.dir = dir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+hintFileExt))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, path := range files {
+		name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), hintFileExt))
+		if err != nil {
+			continue
+		}
+		node, ok := r.nodeByID(hashring.NodeID(name))
+		if !ok {
+			log.Printf("[HINTS] ignoring hints for unknown node %s (%s)", name, path)
+			continue
+		}
+		hints, err := readHints(path)
+		if err != nil {
+			return fmt.Errorf("load hints %s: %w", path, err)
+		}
+
+		q := r.hintQueueLocked(node)
+		for _, h := range hints {
+			if r.hints.policy.expired(h, now) {
+				q.expired++
+				continue
+			}
+			q.hints = append(q.hints, h)
+			q.bytes += h.size
+		}
+		if len(q.hints) > 0 && q.hints[0].Created > 0 {
+			// a janela máxima continua contando do hint mais antigo
+			q.downSince = time.UnixMicro(q.hints[0].Created)
+		}
+		// reescreve sem os expirados e abre para anexar
+		if err := r.rewriteHintsLocked(node.ID, q); err != nil {
+			return err
+		}
+		log.Printf("[HINTS] loaded %d hints for %s (%d expired)", len(q.hints), node.ID, q.expired)
+	}
+	return nil
+}
+
+// readHints lê um arquivo de hints. Uma linha final incompleta (crash no meio
+// de uma escrita) é ignorada.
+func readHints(path string) ([]Hint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Hint
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[HINTS] %s: ignoring incomplete last hint", path)
+			}
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var h Hint
+		if err := json.Unmarshal(line, &h); err != nil {
+			log.Printf("[HINTS] %s: skipping corrupt hint: %v", path, err)
+			continue
+		}
+		h.size = int64(len(line))
+		out = append(out, h)
+	}
+}
+
+// openHintFileLocked abre (ou cria) o arquivo da fila para anexar.
+func (r *Router) openHintFileLocked(id hashring.NodeID, q *hintQueue) error {
+	if q.file != nil || r.hints.dir == "" {
+		return nil
+	}
+	f, err := os.OpenFile(hintPath(r.hints.dir, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.file = f
+	return nil
+}
+
+// appendHintLocked grava uma linha no fim da fila em disco (se houver).
+func (r *Router) appendHintLocked(id hashring.NodeID, q *hintQueue, line []byte) error {
+	if r.hints.dir == "" {
+		return nil
+	}
+	if err := r.openHintFileLocked(id, q); err != nil {
+		return err
+	}
+	_, err := q.file.Write(line)
+	return err
+}
+
+// truncateHintsLocked esvazia a fila em disco (chamar com a fila vazia).
+func (r *Router) truncateHintsLocked(id hashring.NodeID, q *hintQueue) {
+	if q.file == nil {
+		return
+	}
+	if err := q.file.Truncate(0); err != nil {
+		log.Printf("[HINTS] truncating hints for %s failed: %v", id, err)
+	}
+}
+
+// compactHintsLocked regrava a fila em disco depois do replay, só logando falhas.
+func (r *Router) compactHintsLocked(id hashring.NodeID, q *hintQueue) {
+	if err := r.rewriteHintsLocked(id, q); err != nil {
+		log.Printf("[HINTS] compacting hints for %s failed: %v", id, err)
+	}
+}
+
+// rewriteHintsLocked regrava a fila em disco com os hints ainda pendentes.
+func (r *Router) rewriteHintsLocked(id hashring.NodeID, q *hintQueue) error {
+	if r.hints.dir == "" {
+		return nil
+	}
+	if q.file != nil {
+		q.file.Close()
+		q.file = nil
+	}
+	path := hintPath(r.hints.dir, id)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, h := range q.hints {
+		line, _ := json.Marshal(h)
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := r.openHintFileLocked(id, q); err != nil {
+		log.Printf("[HINTS] reopening hints for %s failed: %v", id, err)
+		return err
+	}
+	return nil
+}
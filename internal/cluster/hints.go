@@ -0,0 +1,469 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Hinted handoff: escritas que não chegaram numa réplica ficam guardadas no
+// coordenador (hints) e são reenviadas quando o nó volta. O replay é limitado
+// a uma taxa por nó e recua exponencialmente enquanto o nó continua fora.
+// Com LoadHints, as filas também ficam em disco (ver hintlog.go).
+const (
+	DefaultHintReplayRate = 100.0
+	DefaultMaxHintWindow  = 3 * time.Hour
+	DefaultHintMaxBytes   = 128 << 20
+	DefaultHintTTL        = 24 * time.Hour
+
+	hintReplayInterval = time.Second
+	hintMinBackoff     = time.Second
+	hintMaxBackoff     = time.Minute
+)
+
+// Hint é uma mutação pendente para uma réplica.
+type Hint struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Timestamp int64  `json:"ts"`
+	Delete    bool   `json:"delete,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	// Created é quando o hint foi gerado (Unix em microssegundos), para a expiração.
+	Created int64 `json:"created"`
+
+	size int64 // bytes ocupados na fila (linha no arquivo)
+}
+
+// mutation é a escrita que o hint reaplica na réplica.
+func (h Hint) mutation() kv.Mutation {
+	m := kv.Mutation{Op: kv.OpPut, Key: h.Key, Value: h.Value, Timestamp: h.Timestamp, ExpiresAt: h.ExpiresAt}
+	if h.Delete {
+		m = kv.Mutation{Op: kv.OpDelete, Key: h.Key, Timestamp: h.Timestamp}
+	}
+	return m
+}
+
+// HintPolicy controla o replay e os limites das filas de hints.
+type HintPolicy struct {
+	// Rate é quantos hints por segundo são reenviados a cada nó (<= 0 = sem limite).
+	Rate float64
+	// Window: um nó fora do ar há mais que isso para de acumular hints.
+	Window time.Duration
+	// MaxBytes limita o tamanho da fila de cada nó (0 = sem limite).
+	MaxBytes int64
+	// TTL descarta hints mais velhos que isso sem reenviá-los (0 = nunca).
+	TTL time.Duration
+}
+
+// DefaultHintPolicy é a política usada quando SetHintPolicy não é chamado.
+func DefaultHintPolicy() HintPolicy {
+	return HintPolicy{
+		Rate:     DefaultHintReplayRate,
+		Window:   DefaultMaxHintWindow,
+		MaxBytes: DefaultHintMaxBytes,
+		TTL:      DefaultHintTTL,
+	}
+}
+
+// HintStatus resume a fila de hints de um nó (GET /admin/hints).
+type HintStatus struct {
+	Node        hashring.NodeID `json:"node"`
+	Pending     int             `json:"pending"`
+	Bytes       int64           `json:"bytes"`
+	DownSince   *time.Time      `json:"down_since,omitempty"`
+	NextAttempt *time.Time      `json:"next_attempt,omitempty"`
+	Replaying   bool            `json:"replaying"`
+	Replayed    int64           `json:"replayed"`
+	Dropped     int64           `json:"dropped"`
+	Expired     int64           `json:"expired"`
+	LastError   string          `json:"last_error,omitempty"`
+	// Oldest é quando o hint pendente mais velho foi gerado
+	Oldest    *time.Time `json:"oldest,omitempty"`
+	OldestAge string     `json:"oldest_age,omitempty"`
+	// Purged: hints descartados pelo operador (POST /admin/hints/drop)
+	Purged int64 `json:"purged"`
+	// ExceedsGCGrace: o nó está fora há mais que o menor gc_grace; ao voltar
+	// ele precisa de um repair completo antes de servir
+	ExceedsGCGrace bool `json:"exceeds_gc_grace,omitempty"`
+}
+
+type hintQueue struct {
+	node        hashring.NodeInfo
+	hints       []Hint
+	bytes       int64
+	file        *os.File // fila em disco (nil sem LoadHints)
+	downSince   time.Time
+	nextAttempt time.Time
+	backoff     time.Duration
+	replaying   bool
+	replayed    int64
+	dropped     int64
+	expired     int64
+	purged      int64
+	lastError   string
+	gcWarned    bool
+	// epoch muda a cada drop: o replay em andamento não tira da fila os
+	// hints que não são mais os que ele enviou
+	epoch int
+}
+
+type hintStore struct {
+	mu     sync.Mutex
+	queues map[hashring.NodeID]*hintQueue
+	policy HintPolicy
+	dir    string // diretório das filas em disco ("" = só memória)
+}
+
+// SetHintPolicy configura o replay e os limites das filas de hints. As
+// escritas que um nó perde fora da janela ou com a fila cheia ficam para o
+// repair.
+func (r *Router) SetHintPolicy(p HintPolicy) {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	r.hints.policy = p
+}
+
+func (p HintPolicy) expired(h Hint, now time.Time) bool {
+	return p.TTL > 0 && h.Created > 0 && now.Sub(time.UnixMicro(h.Created)) > p.TTL
+}
+
+func (r *Router) hintQueueLocked(node hashring.NodeInfo) *hintQueue {
+	if r.hints.queues == nil {
+		r.hints.queues = make(map[hashring.NodeID]*hintQueue)
+	}
+	q, ok := r.hints.queues[node.ID]
+	if !ok {
+		q = &hintQueue{}
+		r.hints.queues[node.ID] = q
+	}
+	q.node = node
+	return q
+}
+
+// storeHint guarda uma mutação que não chegou em node.
+func (r *Router) storeHint(node hashring.NodeInfo, h Hint) {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	q := r.hintQueueLocked(node)
+	p := r.hints.policy
+	now := time.Now()
+	if q.downSince.IsZero() {
+		q.downSince = now
+	}
+	if p.Window > 0 && now.Sub(q.downSince) > p.Window {
+		if q.dropped == 0 {
+			log.Printf("[HINTS] %s down for more than %s, no longer storing hints", node.ID, p.Window)
+		}
+		q.dropped++
+		metrics.Inc("hints.dropped")
+		return
+	}
+
+	h.Created = now.UnixMicro()
+	line, _ := json.Marshal(h)
+	line = append(line, '\n')
+	h.size = int64(len(line))
+	if p.MaxBytes > 0 && q.bytes+h.size > p.MaxBytes {
+		if q.dropped == 0 {
+			log.Printf("[HINTS] hint queue for %s is full (%d bytes), dropping hints", node.ID, q.bytes)
+		}
+		q.dropped++
+		metrics.Inc("hints.dropped")
+		return
+	}
+	if err := r.appendHintLocked(node.ID, q, line); err != nil {
+		log.Printf("[HINTS] persisting hint for %s failed: %v", node.ID, err)
+		q.dropped++
+		metrics.Inc("hints.dropped")
+		return
+	}
+	q.hints = append(q.hints, h)
+	q.bytes += h.size
+	metrics.Inc("hints.stored")
+}
+
+// noteUp registra que node respondeu: encerra a contagem da janela de hints
+// e antecipa o replay do que estiver pendente.
+func (r *Router) noteUp(node hashring.NodeInfo) {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	q, ok := r.hints.queues[node.ID]
+	if !ok {
+		return
+	}
+	q.downSince = time.Time{}
+	q.gcWarned = false
+	if !q.replaying {
+		q.backoff = 0
+		q.nextAttempt = time.Time{}
+	}
+}
+
+// HintStatus retorna o estado das filas de hints, ordenado por nó.
+func (r *Router) HintStatus() []HintStatus {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	out := make([]HintStatus, 0, len(r.hints.queues))
+	for id, q := range r.hints.queues {
+		s := HintStatus{
+			Node:      id,
+			Pending:   len(q.hints),
+			Bytes:     q.bytes,
+			Replaying: q.replaying,
+			Replayed:  q.replayed,
+			Dropped:   q.dropped,
+			Expired:   q.expired,
+			Purged:    q.purged,
+			LastError: q.lastError,
+		}
+		if len(q.hints) > 0 && q.hints[0].Created > 0 {
+			created := time.UnixMicro(q.hints[0].Created)
+			t := created.UTC()
+			s.Oldest = &t
+			s.OldestAge = time.Since(created).Truncate(time.Second).String()
+		}
+		if !q.downSince.IsZero() {
+			t := q.downSince.UTC()
+			s.DownSince = &t
+		}
+		if len(q.hints) > 0 && !q.nextAttempt.IsZero() {
+			t := q.nextAttempt.UTC()
+			s.NextAttempt = &t
+		}
+		s.ExceedsGCGrace = q.gcWarned
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
+
+// ErrNoHints: nenhuma fila de hints para o nó pedido.
+var ErrNoHints = errors.New("no hints queued for node")
+
+// hintQueuesLocked são as filas de id, ou todas com id vazio.
+func (r *Router) hintQueuesLocked(id hashring.NodeID) (map[hashring.NodeID]*hintQueue, error) {
+	if id == "" {
+		return r.hints.queues, nil
+	}
+	q, ok := r.hints.queues[id]
+	if !ok {
+		return nil, ErrNoHints
+	}
+	return map[hashring.NodeID]*hintQueue{id: q}, nil
+}
+
+// ReplayHintsNow zera o backoff das filas de id (todas com id vazio): o
+// replay recomeça no próximo ciclo, sem esperar a próxima tentativa, por
+// exemplo logo depois que o operador trouxe um nó de volta.
+func (r *Router) ReplayHintsNow(id hashring.NodeID) ([]hashring.NodeID, error) {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	queues, err := r.hintQueuesLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	var out []hashring.NodeID
+	for nid, q := range queues {
+		if len(q.hints) == 0 {
+			continue
+		}
+		q.backoff = 0
+		q.nextAttempt = time.Time{}
+		out = append(out, nid)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// DropHints descarta os hints pendentes para id (todos com id vazio) e
+// retorna quantos foram removidos de cada fila. O que eles levariam à
+// réplica fica para o repair.
+func (r *Router) DropHints(id hashring.NodeID) (map[hashring.NodeID]int, error) {
+	r.hints.mu.Lock()
+	defer r.hints.mu.Unlock()
+	queues, err := r.hintQueuesLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[hashring.NodeID]int)
+	for nid, q := range queues {
+		n := len(q.hints)
+		if n == 0 {
+			continue
+		}
+		q.hints = nil
+		q.bytes = 0
+		q.purged += int64(n)
+		q.epoch++
+		q.backoff = 0
+		q.nextAttempt = time.Time{}
+		r.truncateHintsLocked(nid, q)
+		metrics.Add("hints.purged", int64(n))
+		log.Printf("[HINTS] dropped %d pending hints for %s", n, nid)
+		out[nid] = n
+	}
+	return out, nil
+}
+
+// RunHintReplay reenvia periodicamente os hints pendentes até ctx terminar.
+// Cada nó tem seu próprio replay, então um nó lento não atrasa os outros.
+func (r *Router) RunHintReplay(ctx context.Context) {
+	ticker := time.NewTicker(hintReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		grace := r.localStore.GCGrace().Min()
+		r.hints.mu.Lock()
+		for id, q := range r.hints.queues {
+			if !q.downSince.IsZero() && !q.gcWarned && now.Sub(q.downSince) > grace {
+				q.gcWarned = true
+				log.Printf("[WARN] %s has been down for more than gc_grace (%s): run a full repair on it before it serves again, or deleted data may come back", id, grace)
+			}
+			if q.replaying || len(q.hints) == 0 || now.Before(q.nextAttempt) {
+				continue
+			}
+			q.replaying = true
+			go r.replayHints(ctx, id)
+		}
+		r.hints.mu.Unlock()
+	}
+}
+
+// replayHints envia os hints de um nó em ordem, em lotes (RPC multi-chave),
+// respeitando a taxa. Na primeira falha para e agenda a próxima tentativa
+// com backoff exponencial.
+func (r *Router) replayHints(ctx context.Context, id hashring.NodeID) {
+	r.hints.mu.Lock()
+	q := r.hints.queues[id]
+	p := r.hints.policy
+	r.hints.mu.Unlock()
+	// lotes de até um segundo de hints na taxa configurada
+	batch := ReplicaBatchMaxItems
+	if p.Rate > 0 && p.Rate < float64(batch) {
+		batch = int(p.Rate)
+		if batch < 1 {
+			batch = 1
+		}
+	}
+
+	sent, removed := 0, 0
+	for {
+		r.hints.mu.Lock()
+		for len(q.hints) > 0 && p.expired(q.hints[0], time.Now()) {
+			q.popLocked()
+			q.expired++
+			metrics.Inc("hints.expired")
+			removed++
+		}
+		if len(q.hints) == 0 {
+			q.replaying = false
+			q.backoff = 0
+			q.downSince = time.Time{}
+			q.gcWarned = false
+			q.lastError = ""
+			if removed > 0 {
+				r.truncateHintsLocked(id, q)
+			}
+			r.hints.mu.Unlock()
+			if sent > 0 {
+				log.Printf("[HINTS] replayed %d hints to %s", sent, id)
+			}
+			return
+		}
+		// só storeHint mexe na fila fora daqui, e só no fim (append)
+		n := len(q.hints)
+		if n > batch {
+			n = batch
+		}
+		ms := make([]kv.Mutation, n)
+		for i, h := range q.hints[:n] {
+			ms[i] = h.mutation()
+		}
+		node := q.node
+		epoch := q.epoch
+		r.hints.mu.Unlock()
+
+		errs := r.sendMutations(ctx, node, ms)
+
+		r.hints.mu.Lock()
+		if q.epoch != epoch {
+			// a fila foi descartada durante o envio
+			q.replaying = false
+			r.hints.mu.Unlock()
+			return
+		}
+		var err error
+		ok := 0
+		for ok < n && errs[ok] == nil {
+			q.popLocked()
+			ok++
+		}
+		if ok > 0 {
+			removed += ok
+			sent += ok
+			q.replayed += int64(ok)
+			metrics.Add("hints.replayed", int64(ok))
+			q.lastError = ""
+		}
+		if ok < n {
+			err = errs[ok]
+		}
+		if err != nil {
+			q.replaying = false
+			q.backoff *= 2
+			if q.backoff < hintMinBackoff {
+				q.backoff = hintMinBackoff
+			}
+			if q.backoff > hintMaxBackoff {
+				q.backoff = hintMaxBackoff
+			}
+			q.nextAttempt = time.Now().Add(q.backoff)
+			q.lastError = err.Error()
+			backoff := q.backoff
+			if removed > 0 {
+				// compacta o arquivo para não reenviar de novo o que já foi
+				r.compactHintsLocked(id, q)
+			}
+			r.hints.mu.Unlock()
+			if sent > 0 {
+				log.Printf("[HINTS] replayed %d hints to %s before failing: %v (retry in %s)", sent, id, err, backoff)
+			}
+			return
+		}
+		r.hints.mu.Unlock()
+
+		if p.Rate > 0 {
+			pause := time.Duration(float64(ok) / p.Rate * float64(time.Second))
+			select {
+			case <-ctx.Done():
+				r.hints.mu.Lock()
+				q.replaying = false
+				r.compactHintsLocked(id, q)
+				r.hints.mu.Unlock()
+				return
+			case <-time.After(pause):
+			}
+		}
+	}
+}
+
+func (q *hintQueue) popLocked() {
+	q.bytes -= q.hints[0].size
+	q.hints[0] = Hint{}
+	q.hints = q.hints[1:]
+}
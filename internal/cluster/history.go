@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+)
+
+// ReplicaHistoryPath retorna o histórico local de uma chave (?key=).
+const ReplicaHistoryPath = "/internal/replica/history"
+
+// HistoryEntry é uma versão do histórico de uma chave, com as réplicas que a
+// receberam e as que a descartaram (já tinham uma versão mais nova).
+type HistoryEntry struct {
+	Timestamp   int64    `json:"ts"`
+	Value       string   `json:"value,omitempty"`
+	Deleted     bool     `json:"deleted,omitempty"`
+	ExpiresAt   int64    `json:"expires_at,omitempty"`
+	Writer      string   `json:"writer,omitempty"`
+	Replicas    []string `json:"replicas"`
+	DiscardedBy []string `json:"discarded_by,omitempty"`
+}
+
+// KeyHistory é o histórico de uma chave juntado das réplicas.
+type KeyHistory struct {
+	Versions []HistoryEntry    `json:"versions"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// History junta o histórico de versões de key de todas as réplicas, da
+// versão mais nova para a mais antiga. Réplicas que falharem ficam em Errors.
+func (r *Router) History(ctx context.Context, key string) (KeyHistory, error) {
+	replicas := r.replicasForKey(key)
+	if len(replicas) == 0 {
+		return KeyHistory{}, fmt.Errorf("no replicas for key")
+	}
+	histories := make([][]kv.HistoryVersion, len(replicas))
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, node := range replicas {
+		wg.Add(1)
+		go func(i int, node hashring.NodeInfo) {
+			defer wg.Done()
+			histories[i], errs[i] = r.replicaHistory(ctx, node, key)
+		}(i, node)
+	}
+	wg.Wait()
+
+	type versionID struct {
+		ts      int64
+		deleted bool
+		value   string
+	}
+	var out KeyHistory
+	byID := make(map[versionID]int)
+	for i, node := range replicas {
+		if errs[i] != nil {
+			if out.Errors == nil {
+				out.Errors = make(map[string]string)
+			}
+			out.Errors[string(node.ID)] = errs[i].Error()
+			continue
+		}
+		for _, v := range histories[i] {
+			id := versionID{v.Timestamp, v.Deleted, v.Value}
+			j, ok := byID[id]
+			if !ok {
+				j = len(out.Versions)
+				byID[id] = j
+				out.Versions = append(out.Versions, HistoryEntry{Timestamp: v.Timestamp, Value: v.Value, Deleted: v.Deleted, ExpiresAt: v.ExpiresAt})
+			}
+			ver := &out.Versions[j]
+			if ver.Writer == "" {
+				ver.Writer = v.Writer
+			}
+			ver.Replicas = append(ver.Replicas, string(node.ID))
+			if v.Discarded {
+				ver.DiscardedBy = append(ver.DiscardedBy, string(node.ID))
+			}
+		}
+	}
+	sort.SliceStable(out.Versions, func(i, j int) bool { return out.Versions[i].Timestamp > out.Versions[j].Timestamp })
+	if out.Versions == nil {
+		out.Versions = []HistoryEntry{}
+	}
+	return out, nil
+}
+
+func (r *Router) replicaHistory(ctx context.Context, node hashring.NodeInfo, key string) ([]kv.HistoryVersion, error) {
+	if r.isLocal(node) {
+		return r.localStore.History(key), nil
+	}
+	res := r.call(ctx, node, "GET", ReplicaHistoryPath+"?key="+url.QueryEscape(key), nil)
+	if !res.OK() {
+		return nil, fmt.Errorf("%s", res.Error())
+	}
+	var versions []kv.HistoryVersion
+	if err := json.Unmarshal(res.Body, &versions); err != nil {
+		return nil, fmt.Errorf("history from %s: %w", node.ID, err)
+	}
+	return versions, nil
+}
@@ -0,0 +1,349 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Objetos grandes: um valor acima de LargeObjectConfig.Threshold é gravado
+// em chunks, cada um numa chave derivada (espalhadas pelo ring, cada uma com
+// as suas réplicas), e a chave guarda só um manifesto. A leitura junta os
+// chunks de volta. Assim um valor enorme não concentra tráfego e memória nas
+// mesmas RF réplicas.
+
+// DefaultLargeObjectChunkSize é o tamanho padrão de cada chunk.
+const DefaultLargeObjectChunkSize = 1 << 20
+
+const (
+	// largeObjectPrefix marca um valor que é o manifesto de um objeto grande;
+	// valores de cliente que começam com ele são sempre gravados em chunks,
+	// para nunca serem confundidos com um manifesto
+	largeObjectPrefix = "\x00mc-large-object\x00"
+	// largeObjectParallelism é quantos chunks são gravados ou lidos ao mesmo
+	// tempo por requisição
+	largeObjectParallelism = 4
+	largeObjectReleaseTime = time.Minute
+	// largeObjectTTLSlack é quanto os chunks de um put condicional com TTL
+	// duram além do TTL (ver casPut)
+	largeObjectTTLSlack = time.Minute
+)
+
+// ErrIncompleteObject: faltou (ou veio corrompido) algum chunk de um objeto
+// grande na leitura.
+var ErrIncompleteObject = errors.New("large object incomplete")
+
+// ErrLargeObjectTTL: expire/persist numa chave que guarda um objeto grande.
+// Regravar só o manifesto deixaria os chunks com o TTL antigo; para mudar o
+// TTL, regrave o valor.
+var ErrLargeObjectTTL = errors.New("cannot change the ttl of a large object, rewrite the value")
+
+// LargeObjectConfig diz quando um valor vira objeto grande.
+type LargeObjectConfig struct {
+	// Threshold: valores maiores que isso vão em chunks (0 desliga)
+	Threshold int `json:"threshold"`
+	ChunkSize int `json:"chunk_size"`
+}
+
+// largeManifest é o que fica na chave de um objeto grande. ID é o timestamp
+// da escrita, que entra nas chaves dos chunks: cada versão tem os seus.
+type largeManifest struct {
+	Size      int    `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	ID        string `json:"id"`
+	CRC32     uint32 `json:"crc32"`
+}
+
+// SetLargeObjects configura os objetos grandes. Ligue só depois de todos os
+// nós entenderem manifestos: um nó antigo devolveria o manifesto cru.
+func (r *Router) SetLargeObjects(cfg LargeObjectConfig) {
+	if cfg.Threshold < 0 {
+		cfg.Threshold = 0
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultLargeObjectChunkSize
+	}
+	r.large = cfg
+}
+
+// LargeObjects retorna a configuração dos objetos grandes.
+func (r *Router) LargeObjects() LargeObjectConfig {
+	return r.large
+}
+
+func parseManifest(v string) (largeManifest, bool) {
+	raw, ok := strings.CutPrefix(kv.ValueBody(v), largeObjectPrefix)
+	if !ok {
+		return largeManifest{}, false
+	}
+	var m largeManifest
+	if err := json.Unmarshal([]byte(raw), &m); err != nil || m.ChunkSize <= 0 {
+		return largeManifest{}, false
+	}
+	return m, true
+}
+
+// ValueLength é o tamanho do valor guardado em v para o cliente: o do objeto
+// inteiro se v for um manifesto, sem os metadados (kv.ValueMeta).
+func ValueLength(v string) int {
+	if m, ok := parseManifest(v); ok {
+		return m.Size
+	}
+	return len(kv.ValueBody(v))
+}
+
+// chunkKey é a chave do chunk i da versão id de key. Fica no keyspace de key
+// e tem tamanho fixo, qualquer que seja o da chave. O resto é um hash de
+// tudo: chaves que só diferem no fim (como o índice) cairiam em tokens
+// vizinhos com o FNV do ring, e os chunks nas mesmas réplicas.
+func chunkKey(key, id string, i int) string {
+	ks := ""
+	if i := strings.Index(key, kv.KeyspaceSep); i > 0 {
+		ks = key[:i+1]
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", key, id, i)))
+	return fmt.Sprintf("%s\x00chunk\x00%x", ks, sum[:16])
+}
+
+// shouldChunk diz se value vai em chunks. Keyspaces com estratégia de merge
+// e CRDTs ficam de fora: o merge precisa dos valores, não de manifestos.
+func (r *Router) shouldChunk(key, value string) bool {
+	if kv.IsCRDT(value) {
+		return false
+	}
+	if strings.HasPrefix(kv.ValueBody(value), largeObjectPrefix) {
+		return true
+	}
+	if r.large.Threshold <= 0 || len(value) <= r.large.Threshold {
+		return false
+	}
+	_, merged := r.merge[kv.KeyspaceOf(key)]
+	return !merged
+}
+
+// withoutOutcome tira o Outcome de ctx: as operações dos chunks não entram
+// nos headers de roteamento (vale o manifesto).
+func withoutOutcome(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outcomeKey{}, (*Outcome)(nil))
+}
+
+// forChunks roda fn para cada chunk, largeObjectParallelism por vez, e para
+// no primeiro erro.
+func forChunks(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, largeObjectParallelism)
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() { first = err; cancel() })
+			}
+		}(i)
+	}
+	wg.Wait()
+	return first
+}
+
+// putLarge grava m.Value em chunks, cada um exigindo cl, e depois o
+// manifesto (com os metadados do valor, que não entram nos chunks). Se algum
+// chunk falhar a escrita falha (e os chunks já gravados são apagados); a
+// versão anterior continua valendo.
+func (r *Router) putLarge(ctx context.Context, m kv.Mutation, cl Consistency) error {
+	m, _, err := r.writeChunks(ctx, m, cl)
+	if err != nil {
+		return err
+	}
+	if _, err := r.replicate(ctx, m, cl); err != nil {
+		// o manifesto pode ter ficado em alguma réplica: os chunks ficam (se
+		// for substituído, releaseSuperseded os apaga)
+		return err
+	}
+	metrics.Inc("large_objects.writes")
+	return nil
+}
+
+// writeChunks grava os chunks de m.Value exigindo cl e retorna m com o
+// manifesto no lugar do valor, pronto para ser gravado na chave.
+func (r *Router) writeChunks(ctx context.Context, m kv.Mutation, cl Consistency) (kv.Mutation, largeManifest, error) {
+	value, meta := kv.SplitMeta(m.Value)
+	size := r.large.ChunkSize
+	if size <= 0 {
+		size = DefaultLargeObjectChunkSize
+	}
+	man := largeManifest{Size: len(value), ChunkSize: size, Chunks: (len(value) + size - 1) / size, ID: strconv.FormatInt(m.Timestamp, 36)}
+	man.CRC32 = crcString(0, value)
+
+	tr := traceFrom(ctx)
+	tr.add(r.nodeID, "large object", 0, "key=%s: %d bytes in %d chunks", m.Key, man.Size, man.Chunks)
+	err := forChunks(withoutOutcome(ctx), man.Chunks, func(ctx context.Context, i int) error {
+		part := value[i*size : min((i+1)*size, len(value))]
+		c := kv.Mutation{Op: kv.OpPut, Key: chunkKey(m.Key, man.ID, i), Value: part, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Writer: m.Writer}
+		if _, err := r.replicate(ctx, c, cl); err != nil {
+			return fmt.Errorf("chunk %d/%d: %w", i+1, man.Chunks, err)
+		}
+		return nil
+	})
+	if err != nil {
+		metrics.Inc("large_objects.write_errors")
+		go r.releaseChunks(m.Key, man)
+		return m, man, err
+	}
+	metrics.Add("large_objects.chunks_written", int64(man.Chunks))
+
+	raw, _ := json.Marshal(man)
+	m.Value = kv.WithMeta(largeObjectPrefix+string(raw), meta)
+	return m, man, nil
+}
+
+// casPut é o CompareAndSet de um put (op.Op == kv.OpPut) que passa o valor
+// para chunks quando shouldChunk manda: os chunks são gravados antes, e a
+// operação condicional grava só o manifesto. Se a condição falhar, os chunks
+// são apagados; nos outros erros eles ficam, como no putLarge.
+func (r *Router) casPut(ctx context.Context, key string, cl Consistency, op CASOp) (CASResult, error) {
+	if !r.shouldChunk(key, op.Value) {
+		return r.CompareAndSet(ctx, key, cl, op)
+	}
+	if err := r.checkWritable(); err != nil {
+		return CASResult{}, err
+	}
+	if err := r.ValidateValue(key, op.Value); err != nil {
+		return CASResult{}, err
+	}
+	m := kv.Mutation{Op: kv.OpPut, Key: key, Value: op.Value, Timestamp: kv.Now(), Writer: string(r.nodeID)}
+	if op.TTL > 0 {
+		// o manifesto expira a partir do instante em que o dono da chave o
+		// grava, um pouco depois: os chunks duram um pouco mais
+		m.ExpiresAt = m.Timestamp + op.TTL + largeObjectTTLSlack.Microseconds()
+	}
+	m, man, err := r.writeChunks(ctx, m, casConsistency(cl))
+	if err != nil {
+		return CASResult{}, err
+	}
+	op.Value = m.Value
+	res, err := r.CompareAndSet(ctx, key, cl, op)
+	if errors.Is(err, ErrPreconditionFailed) {
+		go r.releaseChunks(key, man)
+	}
+	if err == nil {
+		metrics.Inc("large_objects.writes")
+	}
+	return res, err
+}
+
+// readLarge junta os chunks do objeto cujo manifesto está em e, lendo cada
+// um com cl.
+func (r *Router) readLarge(ctx context.Context, key string, e kv.Entry, man largeManifest, cl Consistency) (kv.Entry, error) {
+	parts := make([]string, man.Chunks)
+	err := forChunks(withoutOutcome(ctx), man.Chunks, func(ctx context.Context, i int) error {
+		c, _, ok, err := r.read(ctx, chunkKey(key, man.ID, i), false, cl)
+		if err != nil {
+			return err
+		}
+		want := min(man.ChunkSize, man.Size-i*man.ChunkSize)
+		if !ok || len(c.Value) != want {
+			return fmt.Errorf("key=%s chunk %d/%d: %w", key, i+1, man.Chunks, ErrIncompleteObject)
+		}
+		parts[i] = c.Value
+		return nil
+	})
+	if err != nil {
+		metrics.Inc("large_objects.read_errors")
+		return kv.Entry{}, err
+	}
+
+	var sb strings.Builder
+	sb.Grow(man.Size)
+	var crc uint32
+	for _, p := range parts {
+		sb.WriteString(p)
+		crc = crcString(crc, p)
+	}
+	if crc != man.CRC32 {
+		metrics.Inc("large_objects.read_errors")
+		return kv.Entry{}, fmt.Errorf("key=%s checksum mismatch: %w", key, ErrIncompleteObject)
+	}
+	metrics.Inc("large_objects.reads")
+	_, meta := kv.SplitMeta(e.Value)
+	e.Value = kv.WithMeta(sb.String(), meta)
+	return e, nil
+}
+
+// crcString continua o CRC-32 crc com s, em pedaços (sem converter o valor
+// inteiro para []byte).
+func crcString(crc uint32, s string) uint32 {
+	const step = 32 << 10
+	for len(s) > 0 {
+		n := min(len(s), step)
+		crc = crc32.Update(crc, crc32.IEEETable, []byte(s[:n]))
+		s = s[n:]
+	}
+	return crc
+}
+
+// releaseSuperseded é o hook do store (kv.Store.SetReplaceHook): quando uma
+// escrita substitui o manifesto de um objeto grande, a primeira réplica da
+// chave apaga os chunks da versão antiga em background. As outras réplicas
+// não fazem nada, para não repetir o trabalho; se a primeira estiver fora do
+// ar os chunks ficam. Um manifesto regravado com a mesma versão (mesmo ID)
+// ainda usa os chunks: eles ficam.
+func (r *Router) releaseSuperseded(key string, prev kv.Entry, m kv.Mutation) {
+	man, ok := parseManifest(prev.Value)
+	if !ok {
+		return
+	}
+	if next, ok := parseManifest(m.Value); ok && next.ID == man.ID {
+		return
+	}
+	replicas := r.replicasForKey(key)
+	if len(replicas) == 0 || replicas[0].ID != r.nodeID {
+		return
+	}
+	go r.releaseChunks(key, man)
+}
+
+// releaseChunks grava tombstones nos chunks de man (com uma confirmação: as
+// réplicas que falharem ganham hint).
+func (r *Router) releaseChunks(key string, man largeManifest) {
+	ctx, cancel := context.WithTimeout(context.Background(), largeObjectReleaseTime)
+	defer cancel()
+	ts := kv.Now()
+	var failed atomic.Int64
+	forChunks(ctx, man.Chunks, func(ctx context.Context, i int) error {
+		if _, err := r.replicate(ctx, kv.Mutation{Op: kv.OpDelete, Key: chunkKey(key, man.ID, i), Timestamp: ts}, One); err != nil {
+			// segue com os outros chunks
+			failed.Add(1)
+		}
+		return nil
+	})
+	if n := failed.Load(); n > 0 {
+		log.Printf("[LARGE] releasing chunks of key=%s version=%s: %d of %d failed", key, man.ID, n, man.Chunks)
+	}
+	metrics.Add("large_objects.chunks_released", int64(man.Chunks)-failed.Load())
+	log.Printf("[LARGE] released chunks of key=%s version=%s (%d chunks)", key, man.ID, man.Chunks)
+}
@@ -0,0 +1,356 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/metrics"
+)
+
+// Latência por nó: o coordenador mede cada GET e PUT/DELETE de réplica e
+// guarda uma média móvel exponencial por nó. As leituras tentam a réplica
+// local primeiro e depois as remotas da mais rápida para a mais lenta, em vez
+// de seguir a ordem do ring.
+const (
+	// latencyAlpha é o peso da amostra nova na média móvel
+	latencyAlpha = 0.2
+
+	// latencyFailurePenalty é a amostra registrada numa falha (o timeout das
+	// chamadas de réplica), para o nó ir para o fim da fila
+	latencyFailurePenalty = 2 * time.Second
+
+	// latencyStale: uma média sem amostras há mais que isso é ignorada, para
+	// que um nó que ficou lento (ou caiu) volte a ser medido
+	latencyStale = time.Minute
+
+	// errorAlpha é o peso de cada chamada na taxa de erro (média móvel de
+	// 1 para falha e 0 para sucesso)
+	errorAlpha = 0.1
+
+	// slowPeerMinSamples: chamadas medidas antes de um nó poder ser rebaixado
+	slowPeerMinSamples = 20
+
+	// slowPeerCheckInterval é de quanto em quanto tempo as notas são
+	// reavaliadas
+	slowPeerCheckInterval = time.Second
+)
+
+// Rebaixamento de nós lentos: um nó remoto cronicamente lento (média bem
+// acima da mediana dos outros) ou falhando vai para o fim da fila das
+// leituras, depois até da ordem por latência, em vez de voltar para a frente
+// a cada vez que a média fica velha. Ele continua recebendo as escritas, que
+// seguem medindo o nó; quando as medidas melhoram por um tempo, ele volta a
+// ser ordenado pela latência.
+
+// SlowPeerPolicy diz quando um nó é rebaixado para última opção das leituras.
+type SlowPeerPolicy struct {
+	// LatencyFactor: rebaixa o nó com média acima de LatencyFactor vezes a
+	// mediana das médias dos outros nós (0 desliga o critério)
+	LatencyFactor float64
+	// MinLatency: médias abaixo disso nunca rebaixam um nó
+	MinLatency time.Duration
+	// ErrorRate: rebaixa o nó com taxa de erro (falhas de rede e timeouts)
+	// acima disso, de 0 a 1 (0 desliga o critério)
+	ErrorRate float64
+	// DemoteAfter: por quanto tempo o nó precisa continuar lento para ser
+	// rebaixado
+	DemoteAfter time.Duration
+	// RecoverAfter: por quanto tempo um nó rebaixado precisa ficar bem
+	// (média abaixo de metade do LatencyFactor e taxa de erro abaixo de
+	// metade do ErrorRate) para voltar
+	RecoverAfter time.Duration
+}
+
+// DefaultSlowPeerPolicy é a política de quem não configura uma.
+func DefaultSlowPeerPolicy() SlowPeerPolicy {
+	return SlowPeerPolicy{
+		LatencyFactor: 3,
+		MinLatency:    20 * time.Millisecond,
+		ErrorRate:     0.2,
+		DemoteAfter:   10 * time.Second,
+		RecoverAfter:  30 * time.Second,
+	}
+}
+
+// PeerLatency é a latência medida para um nó.
+type PeerLatency struct {
+	NodeID    hashring.NodeID `json:"node_id"`
+	Host      string          `json:"host"`
+	EWMAMs    float64         `json:"ewma_ms"`
+	Samples   int64           `json:"samples"`
+	Failures  int64           `json:"failures"`
+	Stale     bool            `json:"stale,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// ErrorRate é a média móvel das falhas (0 a 1)
+	ErrorRate float64 `json:"error_rate"`
+	// Demoted: o nó é a última opção das leituras desde DemotedAt, por
+	// DemotedReason
+	Demoted       bool       `json:"demoted"`
+	DemotedAt     *time.Time `json:"demoted_at,omitempty"`
+	DemotedReason string     `json:"demoted_reason,omitempty"`
+	// Demotions: quantas vezes o nó foi rebaixado
+	Demotions int64 `json:"demotions"`
+}
+
+type peerLatency struct {
+	node      hashring.NodeID
+	ewma      time.Duration
+	errRate   float64
+	samples   int64
+	failures  int64
+	updatedAt time.Time
+
+	// slowSince: desde quando o nó está lento (zero = não está); okSince:
+	// desde quando o nó rebaixado está bem
+	slowSince time.Time
+	okSince   time.Time
+	demotedAt time.Time // zero = não rebaixado
+	reason    string
+	demotions int64
+}
+
+type latencyTracker struct {
+	mu     sync.Mutex
+	peers  map[string]*peerLatency // por host
+	policy SlowPeerPolicy
+}
+
+// SetSlowPeerPolicy troca a política de rebaixamento de nós lentos. Chame no
+// boot.
+func (r *Router) SetSlowPeerPolicy(p SlowPeerPolicy) {
+	r.latency.mu.Lock()
+	defer r.latency.mu.Unlock()
+	r.latency.policy = p
+}
+
+// observeLatency registra uma chamada a node que levou d (err != nil conta
+// como latencyFailurePenalty, se d for menor).
+func (r *Router) observeLatency(node hashring.NodeInfo, d time.Duration, err error) {
+	t := &r.latency
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.peers == nil {
+		t.peers = make(map[string]*peerLatency)
+	}
+	p, ok := t.peers[node.Host]
+	if !ok {
+		p = &peerLatency{}
+		t.peers[node.Host] = p
+	}
+	p.node = node.ID
+	failed := 0.0
+	if err != nil {
+		p.failures++
+		failed = 1
+		if d < latencyFailurePenalty {
+			d = latencyFailurePenalty
+		}
+	}
+	if p.samples == 0 || time.Since(p.updatedAt) > latencyStale {
+		p.ewma = d
+		p.errRate = failed
+	} else {
+		p.ewma = time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(p.ewma))
+		p.errRate = errorAlpha*failed + (1-errorAlpha)*p.errRate
+	}
+	p.samples++
+	p.updatedAt = time.Now()
+}
+
+// expectedLatency retorna a média do nó (0 se não houver uma recente).
+func (r *Router) expectedLatency(node hashring.NodeInfo, now time.Time) time.Duration {
+	p, ok := r.latency.peers[node.Host]
+	if !ok || now.Sub(p.updatedAt) > latencyStale {
+		return 0
+	}
+	return p.ewma
+}
+
+// demoted diz se o nó está rebaixado para última opção das leituras.
+func (r *Router) demoted(node hashring.NodeInfo) bool {
+	p, ok := r.latency.peers[node.Host]
+	return ok && !p.demotedAt.IsZero()
+}
+
+// orderForRead ordena as réplicas de uma leitura: a local primeiro, depois
+// as remotas pela latência esperada, com as rebaixadas por último. Nós sem
+// medida recente vão na frente (para serem medidos) e empates mantêm a ordem
+// do ring.
+func (r *Router) orderForRead(replicas []hashring.NodeInfo) []hashring.NodeInfo {
+	out := make([]hashring.NodeInfo, len(replicas))
+	copy(out, replicas)
+	if len(out) < 2 {
+		return out
+	}
+
+	now := time.Now()
+	expected := make(map[hashring.NodeID]time.Duration, len(out))
+	demoted := make(map[hashring.NodeID]bool)
+	r.latency.mu.Lock()
+	for _, n := range out {
+		expected[n.ID] = r.expectedLatency(n, now)
+		if r.demoted(n) {
+			demoted[n.ID] = true
+		}
+	}
+	r.latency.mu.Unlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		li, lj := r.isLocal(out[i]), r.isLocal(out[j])
+		if li != lj {
+			return li
+		}
+		if di, dj := demoted[out[i].ID], demoted[out[j].ID]; di != dj {
+			return dj
+		}
+		return expected[out[i].ID] < expected[out[j].ID]
+	})
+	return out
+}
+
+// RunSlowPeerDetector reavalia as notas dos nós a cada segundo até ctx
+// terminar, rebaixando e devolvendo nós conforme a SlowPeerPolicy.
+func (r *Router) RunSlowPeerDetector(ctx context.Context) {
+	ticker := time.NewTicker(slowPeerCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.checkSlowPeers(now)
+		}
+	}
+}
+
+// checkSlowPeers rebaixa os nós lentos há DemoteAfter e devolve os que estão
+// bem há RecoverAfter (ou sem medidas recentes: não há o que comparar).
+func (r *Router) checkSlowPeers(now time.Time) {
+	t := &r.latency
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pol := t.policy
+
+	// médias recentes com amostras suficientes, para a mediana
+	fresh := make(map[string]time.Duration, len(t.peers))
+	for host, p := range t.peers {
+		if p.samples >= slowPeerMinSamples && now.Sub(p.updatedAt) <= latencyStale {
+			fresh[host] = p.ewma
+		}
+	}
+	for host, p := range t.peers {
+		ewma, measured := fresh[host]
+		median := medianLatencyExcept(fresh, host)
+		if p.demotedAt.IsZero() {
+			reason := ""
+			if measured {
+				reason = pol.slowReason(ewma, p.errRate, median)
+			}
+			if reason == "" {
+				p.slowSince = time.Time{}
+				continue
+			}
+			if p.slowSince.IsZero() {
+				p.slowSince = now
+			}
+			if now.Sub(p.slowSince) >= pol.DemoteAfter {
+				p.demotedAt, p.okSince, p.reason = now, time.Time{}, reason
+				p.demotions++
+				metrics.Inc("peers.demoted")
+				log.Printf("[LATENCY] demoting %s to last choice for reads: %s", p.node, reason)
+			}
+			continue
+		}
+
+		if measured && !pol.recovered(ewma, p.errRate, median) {
+			p.okSince = time.Time{}
+			continue
+		}
+		if p.okSince.IsZero() {
+			p.okSince = now
+		}
+		if !measured || now.Sub(p.okSince) >= pol.RecoverAfter {
+			why := fmt.Sprintf("latency %s, error rate %.0f%%", ewma.Round(time.Millisecond), p.errRate*100)
+			if !measured {
+				why = "no recent measurements"
+			}
+			log.Printf("[LATENCY] %s recovered its rank for reads after %s (%s)", p.node, now.Sub(p.demotedAt).Round(time.Second), why)
+			metrics.Inc("peers.recovered")
+			p.demotedAt, p.slowSince, p.okSince, p.reason = time.Time{}, time.Time{}, time.Time{}, ""
+		}
+	}
+}
+
+// slowReason diz por que um nó com média ewma e taxa de erro errRate está
+// lento ("" se não estiver); median é a mediana dos outros nós (0 se não
+// houver).
+func (p SlowPeerPolicy) slowReason(ewma time.Duration, errRate float64, median time.Duration) string {
+	if p.ErrorRate > 0 && errRate > p.ErrorRate {
+		return fmt.Sprintf("error rate %.0f%% above %.0f%%", errRate*100, p.ErrorRate*100)
+	}
+	if p.LatencyFactor > 0 && median > 0 && ewma > p.MinLatency && float64(ewma) > p.LatencyFactor*float64(median) {
+		return fmt.Sprintf("latency %s above %.1fx the median of the other nodes (%s)", ewma.Round(time.Millisecond), p.LatencyFactor, median.Round(time.Millisecond))
+	}
+	return ""
+}
+
+// recovered diz se um nó rebaixado já está bem: os mesmos critérios com
+// metade dos limites, para ele não ficar indo e voltando na fronteira.
+func (p SlowPeerPolicy) recovered(ewma time.Duration, errRate float64, median time.Duration) bool {
+	if p.ErrorRate > 0 && errRate > p.ErrorRate/2 {
+		return false
+	}
+	if p.LatencyFactor > 0 && median > 0 && ewma > p.MinLatency && float64(ewma) > p.LatencyFactor/2*float64(median) {
+		return false
+	}
+	return true
+}
+
+// medianLatencyExcept é a mediana das médias de fresh sem a de host (0 se
+// não sobrar nenhuma).
+func medianLatencyExcept(fresh map[string]time.Duration, host string) time.Duration {
+	others := make([]time.Duration, 0, len(fresh))
+	for h, d := range fresh {
+		if h != host {
+			others = append(others, d)
+		}
+	}
+	if len(others) == 0 {
+		return 0
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+	return others[len(others)/2]
+}
+
+// PeerLatencies retorna a latência medida para cada nó, ordenada por nó.
+func (r *Router) PeerLatencies() []PeerLatency {
+	r.latency.mu.Lock()
+	defer r.latency.mu.Unlock()
+	now := time.Now()
+	out := make([]PeerLatency, 0, len(r.latency.peers))
+	for host, p := range r.latency.peers {
+		pl := PeerLatency{
+			NodeID:    p.node,
+			Host:      host,
+			EWMAMs:    float64(p.ewma) / float64(time.Millisecond),
+			Samples:   p.samples,
+			Failures:  p.failures,
+			Stale:     now.Sub(p.updatedAt) > latencyStale,
+			UpdatedAt: p.updatedAt,
+			ErrorRate: p.errRate,
+			Demotions: p.demotions,
+		}
+		if !p.demotedAt.IsZero() {
+			at := p.demotedAt
+			pl.Demoted, pl.DemotedAt, pl.DemotedReason = true, &at, p.reason
+		}
+		out = append(out, pl)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
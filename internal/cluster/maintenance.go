@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/metrics"
+)
+
+// Modos do repair da manutenção periódica (MAINTENANCE_REPAIR).
+const (
+	MaintenanceRepairIncremental = "incremental"
+	MaintenanceRepairFull        = "full"
+	MaintenanceRepairOff         = "off"
+)
+
+// maintenancePoll é de quanto em quanto tempo a manutenção confere se o
+// rebalance da rodada já terminou.
+const maintenancePoll = time.Second
+
+// MaintenancePolicy diz de quanto em quanto tempo o nó repete, sozinho, o
+// rebalance (chaves que não são mais dele) e um repair leve (só os
+// intervalos em que ele é réplica primária), e em que horários.
+type MaintenancePolicy struct {
+	// Interval entre o início de duas rodadas (0 desliga)
+	Interval time.Duration
+	// Windows são as janelas fora do pico, no horário local; vazio = qualquer
+	// hora. Uma rodada que cairia fora delas espera a próxima janela abrir
+	Windows []TimeWindow
+	// Rebalance: a rodada confere a posse de cada chave local e move as que
+	// saíram deste nó
+	Rebalance bool
+	// Repair: incremental, full ou off
+	Repair string
+}
+
+// TimeWindow é uma janela diária [Start, End) em minutos desde a meia-noite;
+// End menor que Start passa da meia-noite.
+type TimeWindow struct {
+	Start, End int
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// contains diz se o minuto do dia m está na janela.
+func (w TimeWindow) contains(m int) bool {
+	if w.Start <= w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// ParseTimeWindows lê MAINTENANCE_WINDOWS: "01:00-05:00,13:30-14:00".
+func ParseTimeWindows(s string) ([]TimeWindow, error) {
+	var out []TimeWindow
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		var h1, m1, h2, m2 int
+		if _, err := fmt.Sscanf(p, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil ||
+			h1 < 0 || h1 > 23 || h2 < 0 || h2 > 24 || m1 < 0 || m1 > 59 || m2 < 0 || m2 > 59 {
+			return nil, fmt.Errorf("invalid window %q (want HH:MM-HH:MM)", p)
+		}
+		w := TimeWindow{Start: h1*60 + m1, End: (h2*60 + m2) % (24 * 60)}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("empty window %q", p)
+		}
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+// ParseMaintenanceRepair valida o valor de MAINTENANCE_REPAIR.
+func ParseMaintenanceRepair(s string) (string, error) {
+	switch s {
+	case "", MaintenanceRepairIncremental:
+		return MaintenanceRepairIncremental, nil
+	case MaintenanceRepairFull, MaintenanceRepairOff:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid maintenance repair %q (incremental, full or off)", s)
+}
+
+// MaintenanceStatus é o estado da manutenção periódica (GET /admin/maintenance).
+type MaintenanceStatus struct {
+	Enabled   bool       `json:"enabled"`
+	Interval  string     `json:"interval,omitempty"`
+	Windows   []string   `json:"windows,omitempty"`
+	Rebalance bool       `json:"rebalance"`
+	Repair    string     `json:"repair,omitempty"`
+	Runs      int64      `json:"runs"`
+	Skipped   int64      `json:"skipped"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	// jobs disparados pela última rodada (ver /admin/jobs)
+	LastJobs []string `json:"last_jobs,omitempty"`
+	// LastSkip: por que a última rodada não rodou (bootstrap, drain...)
+	LastSkip string `json:"last_skip,omitempty"`
+}
+
+type maintenanceState struct {
+	mu     sync.Mutex
+	status MaintenanceStatus
+}
+
+// MaintenanceStatus retorna o estado da manutenção periódica.
+func (r *Router) MaintenanceStatus() MaintenanceStatus {
+	r.maintenance.mu.Lock()
+	defer r.maintenance.mu.Unlock()
+	st := r.maintenance.status
+	st.Windows = append([]string(nil), st.Windows...)
+	st.LastJobs = append([]string(nil), st.LastJobs...)
+	return st
+}
+
+// RunMaintenance roda a manutenção periódica de p até ctx terminar: a cada
+// p.Interval (dentro das janelas), um rebalance e depois um repair dos
+// intervalos primários, para que réplicas que perderam escritas (hints
+// expirados, nós fora do ar por muito tempo) sejam corrigidas aos poucos, e
+// não só no boot. A primeira rodada é um intervalo depois do início.
+func (r *Router) RunMaintenance(ctx context.Context, p MaintenancePolicy) {
+	if p.Interval <= 0 || (!p.Rebalance && p.Repair == MaintenanceRepairOff) {
+		return
+	}
+	st := MaintenanceStatus{Enabled: true, Interval: p.Interval.String(), Rebalance: p.Rebalance, Repair: p.Repair}
+	for _, w := range p.Windows {
+		st.Windows = append(st.Windows, w.String())
+	}
+	log.Printf("[MAINTENANCE] every %s (windows %v): rebalance=%v repair=%s", p.Interval, st.Windows, p.Rebalance, p.Repair)
+
+	next := nextInWindows(time.Now().Add(p.Interval), p.Windows)
+	for {
+		n := next.UTC()
+		st.NextRun = &n
+		r.maintenance.mu.Lock()
+		r.maintenance.status = st
+		r.maintenance.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		started := time.Now()
+		jobIDs, skip := r.maintenanceRound(ctx, p)
+		r.maintenance.mu.Lock()
+		st = r.maintenance.status
+		if skip != "" {
+			st.Skipped++
+			st.LastSkip = skip
+			metrics.Inc("maintenance.skipped")
+			log.Printf("[MAINTENANCE] round skipped: %s", skip)
+		} else {
+			st.Runs++
+			st.LastSkip = ""
+			st.LastJobs = jobIDs
+			last := started.UTC()
+			st.LastRun = &last
+			metrics.Inc("maintenance.runs")
+		}
+		r.maintenance.mu.Unlock()
+		next = nextInWindows(started.Add(p.Interval), p.Windows)
+	}
+}
+
+// maintenanceRound roda uma rodada: o rebalance (esperando ele terminar, para
+// o repair não comparar chaves que ainda estão saindo) e então o repair.
+// Retorna os jobs disparados, ou o motivo de não ter rodado.
+func (r *Router) maintenanceRound(ctx context.Context, p MaintenancePolicy) (jobIDs []string, skip string) {
+	r.gate.mu.Lock()
+	bootstrapping, draining := r.gate.bootstrapping, r.gate.draining
+	r.gate.mu.Unlock()
+	switch {
+	case bootstrapping:
+		return nil, "node is bootstrapping"
+	case draining:
+		return nil, "node is draining"
+	case r.coordinatorOnly:
+		// sem tokens não há dados para mover nem reparar
+		return nil, "coordinator-only node"
+	}
+
+	if p.Rebalance {
+		job := r.StartRebalance(p.Interval)
+		jobIDs = append(jobIDs, job.ID())
+		for job.Info().Status == jobs.Running {
+			select {
+			case <-ctx.Done():
+				return jobIDs, ""
+			case <-time.After(maintenancePoll):
+			}
+		}
+	}
+	if p.Repair != MaintenanceRepairOff {
+		info, err := r.StartRepair(true, p.Repair == MaintenanceRepairIncremental)
+		if err != nil {
+			// um repair manual ainda rodando: fica para a próxima rodada
+			log.Printf("[MAINTENANCE] repair not started: %v", err)
+		} else {
+			jobIDs = append(jobIDs, info.ID)
+		}
+	}
+	return jobIDs, ""
+}
+
+// nextInWindows é o primeiro instante a partir de t dentro de alguma das
+// janelas (t mesmo, sem janelas).
+func nextInWindows(t time.Time, windows []TimeWindow) time.Time {
+	if len(windows) == 0 {
+		return t
+	}
+	best := time.Time{}
+	for _, w := range windows {
+		m := t.Hour()*60 + t.Minute()
+		if w.contains(m) {
+			return t
+		}
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		start := midnight.Add(time.Duration(w.Start) * time.Minute)
+		if !start.After(t) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if best.IsZero() || start.Before(best) {
+			best = start
+		}
+	}
+	return best
+}
@@ -0,0 +1,254 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Resolução de conflitos por keyspace: quando as réplicas lidas têm valores
+// diferentes, o padrão é o last-write-wins (a versão de maior timestamp). Um
+// keyspace pode trocar isso por uma função de merge, que recebe todas as
+// versões vivas e produz o valor lido; se ele não for o da versão mais nova,
+// o coordenador o grava de volta nas réplicas com um timestamp maior, para
+// que o repair (que continua em LWW) não volte a separar as versões.
+
+// MergeLWW é a estratégia padrão (sem merge).
+const MergeLWW = "lww"
+
+const (
+	mergeWebhookTimeout = 2 * time.Second
+	maxMergedValueBytes = 16 << 20
+)
+
+// MergeFunc combina as versões vivas de uma chave (a mais nova primeiro) num
+// valor. Um erro faz a leitura cair no last-write-wins.
+type MergeFunc func(ctx context.Context, key string, versions []kv.Entry) (string, error)
+
+var (
+	mergeFuncsMu sync.RWMutex
+	mergeFuncs   = map[string]MergeFunc{
+		"max":   mergeMax,
+		"union": mergeUnion,
+	}
+)
+
+// RegisterMergeFunc registra uma estratégia de merge escrita em Go, para ser
+// usada pelo nome em MERGE_STRATEGY_BY_KEYSPACE. Chamar antes de
+// SetMergeStrategies (num init, por exemplo).
+func RegisterMergeFunc(name string, fn MergeFunc) {
+	mergeFuncsMu.Lock()
+	defer mergeFuncsMu.Unlock()
+	mergeFuncs[name] = fn
+}
+
+type mergeStrategy struct {
+	name string
+	fn   MergeFunc
+}
+
+// SetMergeStrategies configura a estratégia de cada keyspace (keyspace ->
+// "lww", "max", "union", "webhook:<url>" ou uma registrada com
+// RegisterMergeFunc). Chamar antes de servir requisições.
+func (r *Router) SetMergeStrategies(byKeyspace map[string]string) error {
+	strategies := make(map[string]mergeStrategy)
+	for ks, name := range byKeyspace {
+		if name == MergeLWW {
+			continue
+		}
+		if url, ok := strings.CutPrefix(name, "webhook:"); ok {
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				return fmt.Errorf("keyspace %s: invalid webhook url %q", ks, url)
+			}
+			strategies[ks] = mergeStrategy{name: name, fn: mergeWebhook(url)}
+			continue
+		}
+		mergeFuncsMu.RLock()
+		fn, ok := mergeFuncs[name]
+		mergeFuncsMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("keyspace %s: unknown merge strategy %q", ks, name)
+		}
+		strategies[ks] = mergeStrategy{name: name, fn: fn}
+	}
+	r.merge = strategies
+	return nil
+}
+
+// MergeStrategies retorna a estratégia de cada keyspace que não usa LWW.
+func (r *Router) MergeStrategies() map[string]string {
+	out := make(map[string]string, len(r.merge))
+	for ks, s := range r.merge {
+		out[ks] = s.name
+	}
+	return out
+}
+
+// mergeVersions combina as respostas das réplicas pela estratégia do keyspace
+// da chave. ok=false: não há merge a fazer (LWW, a versão mais nova é um
+// tombstone ou só uma versão viva) ou ele falhou; vale newest.
+func (r *Router) mergeVersions(ctx context.Context, key string, newest replicaRead, reads []replicaRead) (kv.Entry, bool) {
+	if !newest.found {
+		return kv.Entry{}, false
+	}
+	s, ok := r.merge[kv.KeyspaceOf(key)]
+	if kv.IsCRDT(newest.entry.Value) {
+		// sets e maps CRDT se combinam em qualquer keyspace
+		s, ok = mergeStrategy{name: "crdt", fn: mergeCRDT}, true
+	}
+	if !ok {
+		return kv.Entry{}, false
+	}
+	// só entram as versões mais novas que o último delete visto
+	var deletedAt int64
+	for _, rr := range reads {
+		if rr.tombstone && rr.entry.Timestamp > deletedAt {
+			deletedAt = rr.entry.Timestamp
+		}
+	}
+	seen := make(map[int64]bool)
+	var versions []kv.Entry
+	for _, rr := range reads {
+		if rr.found && rr.entry.Timestamp > deletedAt && !seen[rr.entry.Timestamp] {
+			seen[rr.entry.Timestamp] = true
+			versions = append(versions, rr.entry)
+		}
+	}
+	if len(versions) < 2 {
+		return kv.Entry{}, false
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Timestamp > versions[j].Timestamp })
+
+	metrics.Inc("read.merges")
+	// as estratégias combinam os valores do cliente; o resultado fica com
+	// os metadados da versão mais nova
+	_, meta := kv.SplitMeta(newest.entry.Value)
+	for i := range versions {
+		versions[i].Value = kv.ValueBody(versions[i].Value)
+	}
+	value, err := s.fn(ctx, key, versions)
+	if err != nil {
+		metrics.Inc("read.merge_errors")
+		log.Printf("[MERGE] key=%s strategy=%s failed, using the newest version: %v", key, s.name, err)
+		return kv.Entry{}, false
+	}
+	value = kv.WithMeta(value, meta)
+	if value == newest.entry.Value {
+		return newest.entry, true
+	}
+	merged := newest.entry
+	merged.Value = value
+	merged.Timestamp = kv.Now()
+	if merged.Timestamp <= newest.entry.Timestamp {
+		merged.Timestamp = newest.entry.Timestamp + 1
+	}
+	go r.writeMerged(key, merged, s.name, len(versions))
+	return merged, true
+}
+
+// writeMerged grava o valor combinado em todas as réplicas (em background,
+// com uma confirmação: as que falharem ganham hint).
+func (r *Router) writeMerged(key string, e kv.Entry, strategy string, versions int) {
+	ctx, cancel := context.WithTimeout(context.Background(), readRepairTimeout)
+	defer cancel()
+	m := kv.Mutation{Op: kv.OpPut, Key: key, Value: e.Value, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt}
+	if _, err := r.replicate(ctx, m, One); err != nil {
+		log.Printf("[MERGE] key=%s: writing the merged value failed: %v", key, err)
+		return
+	}
+	metrics.Inc("read.merges_written")
+	log.Printf("[MERGE] key=%s merged %d versions with %s (ts=%d)", key, versions, strategy, e.Timestamp)
+}
+
+// mergeMax: o maior valor numérico.
+func mergeMax(_ context.Context, _ string, versions []kv.Entry) (string, error) {
+	best, max := "", 0.0
+	for i, v := range versions {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.Value), 64)
+		if err != nil {
+			return "", fmt.Errorf("value %q is not a number", v.Value)
+		}
+		if i == 0 || f > max {
+			best, max = v.Value, f
+		}
+	}
+	return best, nil
+}
+
+// mergeUnion: a união das listas JSON, com os elementos da versão mais nova
+// primeiro e sem repetições.
+func mergeUnion(_ context.Context, _ string, versions []kv.Entry) (string, error) {
+	seen := make(map[string]bool)
+	out := []interface{}{}
+	for _, v := range versions {
+		var list []interface{}
+		dec := json.NewDecoder(strings.NewReader(v.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&list); err != nil {
+			return "", fmt.Errorf("value is not a JSON array: %w", err)
+		}
+		for _, item := range list {
+			b, _ := json.Marshal(item)
+			if !seen[string(b)] {
+				seen[string(b)] = true
+				out = append(out, item)
+			}
+		}
+	}
+	b, err := json.Marshal(out)
+	return string(b), err
+}
+
+type mergeWebhookVersion struct {
+	Value     string `json:"value"`
+	Timestamp int64  `json:"ts"`
+}
+
+// mergeWebhook faz o merge num serviço externo: POST com {"key", "versions"}
+// (a mais nova primeiro); o corpo de um 200 é o valor combinado.
+func mergeWebhook(url string) MergeFunc {
+	client := &http.Client{Timeout: mergeWebhookTimeout}
+	return func(ctx context.Context, key string, versions []kv.Entry) (string, error) {
+		req := struct {
+			Key      string                `json:"key"`
+			Versions []mergeWebhookVersion `json:"versions"`
+		}{Key: key}
+		for _, v := range versions {
+			req.Versions = append(req.Versions, mergeWebhookVersion{Value: v.Value, Timestamp: v.Timestamp})
+		}
+		body, _ := json.Marshal(req)
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(io.LimitReader(resp.Body, maxMergedValueBytes+1))
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("webhook status=%d", resp.StatusCode)
+		}
+		if len(out) > maxMergedValueBytes {
+			return "", fmt.Errorf("webhook returned more than %d bytes", maxMergedValueBytes)
+		}
+		return string(out), nil
+	}
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"mini-cassandra/internal/kv"
+)
+
+// ErrNotJSON: o valor atual da chave não é um documento JSON (merge-patch).
+var ErrNotJSON = errors.New("value is not a JSON document")
+
+// mergePatch aplica patch (JSON merge-patch, RFC 7396) sobre o documento
+// target; target nil = chave inexistente.
+func mergePatch(target []byte, patch []byte) ([]byte, error) {
+	var doc interface{}
+	if target != nil {
+		if err := decodeJSON(target, &doc); err != nil {
+			return nil, ErrNotJSON
+		}
+	}
+	var p interface{}
+	if err := decodeJSON(patch, &p); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return json.Marshal(mergeValue(doc, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		// patch que não é objeto substitui o documento inteiro
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergeValue(t[k], v)
+	}
+	return t
+}
+
+// decodeJSON é o json.Unmarshal mantendo os números como vieram (sem passar
+// por float64) e recusando lixo depois do documento.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON document")
+	}
+	return nil
+}
+
+// MergePatch aplica um JSON merge-patch ao documento da chave como operação
+// condicional (lê, aplica e grava no dono da chave, sem escritas concorrentes
+// no meio), mantendo o TTL. Chave inexistente é criada a partir do patch.
+// ifVersion/ifExists são as condições do If-Match. Retorna o documento
+// gravado e o resultado da operação (com ErrPreconditionFailed,
+// res.Previous é a versão atual).
+func (r *Router) MergePatch(ctx context.Context, key string, patch []byte, cl Consistency, ifVersion int64, ifExists bool) (string, CASResult, error) {
+	res, err := r.CompareAndSet(ctx, key, cl, CASOp{Op: OpMergePatch, Value: string(patch), IfVersion: ifVersion, IfExists: ifExists})
+	if err != nil {
+		return "", res, err
+	}
+	doc, err := mergePatch(res.Previous.target(), patch)
+	if err != nil {
+		return "", res, err
+	}
+	return string(doc), res, nil
+}
+
+// target é o documento da versão para o merge-patch (nil se não existe).
+func (v Version) target() []byte {
+	if !v.Found {
+		return nil
+	}
+	return []byte(kv.ValueBody(v.Entry.Value))
+}
+
+// meta são os metadados guardados com a versão (vazios se não existe).
+func (v Version) meta() kv.ValueMeta {
+	if !v.Found {
+		return kv.ValueMeta{}
+	}
+	_, meta := kv.SplitMeta(v.Entry.Value)
+	return meta
+}
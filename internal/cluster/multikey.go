@@ -0,0 +1,280 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// RPC multi-chave: várias mutações para o mesmo nó numa requisição só
+// (/internal/replica/batch), usada pelo import em lote, pelo rebalance e
+// pelo replay de hints. Só existe a partir da versão 2 do protocolo interno;
+// com nós mais antigos as mutações vão uma a uma.
+const (
+	ReplicaBatchPath = "/internal/replica/batch"
+
+	// batchProtocolVersion é a primeira versão do protocolo com ReplicaBatchPath
+	batchProtocolVersion = 2
+
+	// ReplicaBatchMaxItems e ReplicaBatchMaxBytes limitam cada requisição
+	// (uma mutação maior que o limite de bytes vai sozinha)
+	ReplicaBatchMaxItems = 500
+	ReplicaBatchMaxBytes = 4 << 20
+
+	replicaBatchTimeout = 10 * time.Second
+)
+
+// ReplicaBatchError é a falha de uma mutação do lote (Index no lote enviado).
+type ReplicaBatchError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ReplicaBatchResult é a resposta de /internal/replica/batch. Mutações
+// descartadas por serem mais antigas que a versão local contam como aplicadas.
+type ReplicaBatchResult struct {
+	Applied int                 `json:"applied"`
+	Errors  []ReplicaBatchError `json:"errors,omitempty"`
+}
+
+// supportsBatch diz se o nó entende /internal/replica/batch.
+func (r *Router) supportsBatch(ctx context.Context, node hashring.NodeInfo) bool {
+	p, err := r.protocol.negotiate(ctx, node.Host)
+	return err == nil && p.Negotiated >= batchProtocolVersion
+}
+
+// batchEnd retorna o fim do próximo lote de ms a partir de start.
+func batchEnd(ms []kv.Mutation, start int) int {
+	end, size := start, 0
+	for end < len(ms) && end-start < ReplicaBatchMaxItems {
+		n := len(ms[end].Key) + len(ms[end].Value)
+		if end > start && size+n > ReplicaBatchMaxBytes {
+			break
+		}
+		size += n
+		end++
+	}
+	return end
+}
+
+// sendMutations aplica as mutações numa réplica remota, em lotes, e retorna
+// um erro por mutação (nil quando deu certo), na mesma ordem.
+func (r *Router) sendMutations(ctx context.Context, node hashring.NodeInfo, ms []kv.Mutation) []error {
+	errs := make([]error, len(ms))
+	if !r.supportsBatch(ctx, node) {
+		for i, m := range ms {
+			_, errs[i] = r.sendMutation(ctx, node, m)
+		}
+		return errs
+	}
+
+	for start := 0; start < len(ms); {
+		end := batchEnd(ms, start)
+		if err := r.sendMutationBatch(ctx, node, ms[start:end], errs[start:end]); err != nil {
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+		}
+		start = end
+	}
+	return errs
+}
+
+// sendMutationBatch envia um lote; falhas de mutações isoladas vão em errs,
+// e uma falha do lote inteiro é retornada.
+func (r *Router) sendMutationBatch(ctx context.Context, node hashring.NodeInfo, ms []kv.Mutation, errs []error) error {
+	ctx, cancel := context.WithTimeout(ctx, replicaBatchTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(ms)
+	res := r.call(ctx, node, "POST", ReplicaBatchPath, body)
+	if !res.OK() {
+		return fmt.Errorf("replica batch to %s: %s", node.Host, res.Error())
+	}
+	var out ReplicaBatchResult
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return fmt.Errorf("replica batch to %s: %w", node.Host, err)
+	}
+	for _, e := range out.Errors {
+		if e.Index >= 0 && e.Index < len(errs) {
+			errs[e.Index] = fmt.Errorf("remote %s to %s: %s", ms[e.Index].Op, node.Host, e.Error)
+		}
+	}
+	return nil
+}
+
+// nodeBatch são as mutações de um lote destinadas a um nó.
+type nodeBatch struct {
+	node hashring.NodeInfo
+	idx  []int
+	errs []error
+	// counts diz se a réplica conta para o nível de consistência de cada
+	// mutação (LOCAL_QUORUM)
+	counts []bool
+}
+
+// BatchResult é o resultado de uma mutação de um lote: Err é nil quando ela
+// teve as confirmações exigidas, e Acked são as réplicas que a aplicaram
+// (as outras ganharam hint).
+type BatchResult struct {
+	Err   error
+	Acked []hashring.NodeID
+}
+
+// batchFailed é o resultado de um lote que falhou inteiro com err.
+func batchFailed(n int, err error) []BatchResult {
+	out := make([]BatchResult, n)
+	for i := range out {
+		out[i].Err = err
+	}
+	return out
+}
+
+// replicateBatch é o replicate de várias mutações de uma vez: as de cada
+// réplica remota vão juntas (sendMutations) e cada mutação precisa das
+// confirmações de cl. Retorna um resultado por mutação, na mesma ordem.
+func (r *Router) replicateBatch(ctx context.Context, ms []kv.Mutation, cl Consistency) []BatchResult {
+	results := r.replicateBatchSkip(ctx, ms, cl, nil)
+	// as que chegaram a ser enviadas vão para os observadores, como no
+	// replicate
+	for i, res := range results {
+		var werr *WriteError
+		if res.Err == nil || errors.As(res.Err, &werr) {
+			r.observe(ms[i])
+		}
+	}
+	return results
+}
+
+// replicateBatchSkip é o replicateBatch sem mandar ms[i] às réplicas em que
+// held(i, réplica) é true: elas já têm a mutação e contam como confirmadas.
+func (r *Router) replicateBatchSkip(ctx context.Context, ms []kv.Mutation, cl Consistency, held func(i int, node hashring.NodeID) bool) []BatchResult {
+	if err := r.gate.enter(); err != nil {
+		return batchFailed(len(ms), err)
+	}
+	defer r.gate.leave()
+
+	errs := make([]error, len(ms))
+	acked := make([][]hashring.NodeID, len(ms))
+
+	replicas := make([][]hashring.NodeInfo, len(ms))
+	counts := make([][]bool, len(ms))
+	need := make([]int, len(ms))
+	acks := make([]int, len(ms))
+	alive := make([]int, len(ms))
+	failed := make([][]error, len(ms))
+	byNode := make(map[hashring.NodeID]*nodeBatch)
+	var batches []*nodeBatch
+	for i, m := range ms {
+		replicas[i] = r.replicasForKey(m.Key)
+		if len(replicas[i]) == 0 {
+			errs[i] = fmt.Errorf("no replicas for key")
+			continue
+		}
+		var err error
+		if counts[i], need[i], err = r.acksFor(ctx, cl, replicas[i]); err != nil {
+			errs[i] = err
+			continue
+		}
+		// réplicas pendentes recebem a mutação sem contar para o nível de
+		// consistência (como em replicate)
+		if pending := r.pendingReplicas(m.Key, replicas[i]); len(pending) > 0 {
+			metrics.Add("pending_ranges.writes", int64(len(pending)))
+			replicas[i] = append(replicas[i], pending...)
+			counts[i] = append(counts[i], make([]bool, len(pending))...)
+		}
+		for k, node := range replicas[i] {
+			if r.isLocal(node) {
+				r.localStore.Apply(m)
+				acked[i] = append(acked[i], node.ID)
+				if counts[i][k] {
+					acks[i]++
+					alive[i]++
+				}
+				continue
+			}
+			if held != nil && held(i, node.ID) {
+				acked[i] = append(acked[i], node.ID)
+				if counts[i][k] {
+					acks[i]++
+					alive[i]++
+				}
+				continue
+			}
+			nb, ok := byNode[node.ID]
+			if !ok {
+				nb = &nodeBatch{node: node}
+				byNode[node.ID] = nb
+				batches = append(batches, nb)
+			}
+			nb.idx = append(nb.idx, i)
+			nb.counts = append(nb.counts, counts[i][k])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, nb := range batches {
+		wg.Add(1)
+		go func(nb *nodeBatch) {
+			defer wg.Done()
+			sub := make([]kv.Mutation, len(nb.idx))
+			for j, i := range nb.idx {
+				sub[j] = ms[i]
+			}
+			nb.errs = r.sendMutations(ctx, nb.node, sub)
+		}(nb)
+	}
+	wg.Wait()
+
+	for _, nb := range batches {
+		up := false
+		for j, i := range nb.idx {
+			if nb.counts[j] && !unreachable(nb.errs[j]) {
+				alive[i]++
+			}
+			if nb.errs[j] == nil {
+				acked[i] = append(acked[i], nb.node.ID)
+				if nb.counts[j] {
+					acks[i]++
+				}
+				up = true
+				continue
+			}
+			failed[i] = append(failed[i], nb.errs[j])
+			metrics.Inc("replication.failures")
+			m := ms[i]
+			r.storeHint(nb.node, Hint{Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Delete: m.Op == kv.OpDelete})
+		}
+		if up {
+			r.noteUp(nb.node)
+		}
+	}
+
+	partial := 0
+	results := make([]BatchResult, len(ms))
+	for i := range ms {
+		results[i].Acked = acked[i]
+		if errs[i] != nil {
+			results[i].Err = errs[i]
+			continue
+		}
+		if acks[i] < need[i] {
+			metrics.Inc("writes.unavailable")
+			results[i].Err = &WriteError{Consistency: cl, Required: need[i], Acks: acks[i], Alive: alive[i], Errs: failed[i]}
+		} else if len(failed[i]) > 0 {
+			partial++
+		}
+	}
+	if partial > 0 {
+		log.Printf("[WRITE] batch of %d accepted at %s with %d writes missing replicas (hinted)", len(ms), cl, partial)
+	}
+	return results
+}
@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// BuildVersion é a versão do binário, definida no build com
+// -ldflags "-X mini-cassandra/internal/cluster.BuildVersion=v1.2.3".
+var BuildVersion = "dev"
+
+// supportedFeatures são as funcionalidades que este binário implementa;
+// durante um rolling upgrade, /cluster/status mostra quais já estão em
+// todos os nós.
+var supportedFeatures = []string{
+	"cas",
+	"consistency-levels",
+	"gc-grace",
+	"hinted-handoff",
+	"incremental-repair",
+	"jobs",
+	"read-repair",
+	"tombstones",
+	"ttl",
+}
+
+// NodeMeta descreve um nó para os outros: anunciada no handshake do
+// protocolo interno e agregada em /cluster/status.
+type NodeMeta struct {
+	Version       string `json:"version"`
+	Datacenter    string `json:"datacenter,omitempty"`
+	Rack          string `json:"rack,omitempty"`
+	CapacityBytes int64  `json:"capacity_bytes,omitempty"`
+	Keys          int    `json:"keys"`
+	// CoordinatorOnly: o nó não tem tokens, só coordena requisições
+	CoordinatorOnly bool     `json:"coordinator_only,omitempty"`
+	Features        []string `json:"features"`
+	StartedAt       int64    `json:"started_at"`
+	// Observer: o nó não tem tokens e guarda a cópia assíncrona das escritas
+	Observer bool `json:"observer,omitempty"`
+	// App são os metadados de aplicação do nó (ver appmeta.go)
+	App map[string]string `json:"app,omitempty"`
+}
+
+// SetNodeMeta define datacenter, rack e capacidade anunciados por este nó
+// (versão, features e número de chaves são preenchidos pelo próprio nó).
+func (r *Router) SetNodeMeta(dc, rack string, capacityBytes int64) {
+	r.meta = NodeMeta{
+		Version:       BuildVersion,
+		Datacenter:    dc,
+		Rack:          rack,
+		CapacityBytes: capacityBytes,
+		Features:      supportedFeatures,
+		StartedAt:     time.Now().Unix(),
+	}
+}
+
+// NodeMeta retorna os metadados atuais deste nó.
+func (r *Router) NodeMeta() NodeMeta {
+	m := r.meta
+	if m.Version == "" {
+		m.Version = BuildVersion
+		m.Features = supportedFeatures
+	}
+	m.Keys = r.localStore.Len()
+	m.CoordinatorOnly = r.coordinatorOnly
+	m.Observer = r.observers.self
+	if app := r.AppMetadata(); len(app) > 0 {
+		m.App = app
+	}
+	return m
+}
+
+// SetCoordinatorOnly marca o nó como coordenador puro (proxy): ele não está
+// no ring, não guarda dados e só encaminha as requisições dos clientes para
+// as réplicas.
+func (r *Router) SetCoordinatorOnly(v bool) {
+	r.coordinatorOnly = v
+}
+
+// CoordinatorOnly diz se o nó é um coordenador puro (sem tokens).
+func (r *Router) CoordinatorOnly() bool {
+	return r.coordinatorOnly
+}
+
+// NodeStatus é a visão de um nó em /cluster/status.
+type NodeStatus struct {
+	ID              string    `json:"id"`
+	Host            string    `json:"host"`
+	Up              bool      `json:"up"`
+	Error           string    `json:"error,omitempty"`
+	ProtocolVersion int       `json:"protocol_version,omitempty"`
+	Meta            *NodeMeta `json:"meta,omitempty"`
+}
+
+// ClusterStatus agrega os nós: quantos estão em cada versão e as features
+// presentes em todos os nós que responderam.
+type ClusterStatus struct {
+	ClusterName    string         `json:"cluster_name"`
+	Nodes          []NodeStatus   `json:"nodes"`
+	Up             int            `json:"up"`
+	Down           int            `json:"down"`
+	Versions       map[string]int `json:"versions"`
+	CommonFeatures []string       `json:"common_features"`
+	// Mixed indica nós em versões diferentes (rolling upgrade em andamento)
+	Mixed bool `json:"mixed"`
+}
+
+// ClusterStatus pergunta a todos os nós do ring seus metadados (pelo
+// handshake do protocolo interno).
+func (r *Router) ClusterStatus(ctx context.Context) ClusterStatus {
+	out := ClusterStatus{ClusterName: r.ClusterName(), Versions: make(map[string]int)}
+	featureCount := make(map[string]int)
+
+	for _, res := range r.Broadcast(ctx, "GET", HandshakePath, nil) {
+		st := NodeStatus{ID: string(res.Node.ID), Host: res.Node.Host}
+		var hs Handshake
+		switch {
+		case !res.OK():
+			st.Error = res.Error()
+		case json.Unmarshal(res.Body, &hs) != nil:
+			st.Error = "invalid handshake response"
+		case hs.ClusterName != out.ClusterName:
+			st.Error = "belongs to cluster " + hs.ClusterName
+		default:
+			st.Up = true
+			st.ProtocolVersion = hs.ProtocolVersion
+			meta := hs.Meta
+			st.Meta = &meta
+			out.Versions[meta.Version]++
+			for _, f := range meta.Features {
+				featureCount[f]++
+			}
+		}
+		if st.Up {
+			out.Up++
+		} else {
+			out.Down++
+		}
+		out.Nodes = append(out.Nodes, st)
+	}
+
+	if r.coordinatorOnly || r.observers.self {
+		// o coordenador (ou observador) não está no ring: entra na lista, mas
+		// não nas contas de versão e features dos nós de dados
+		meta := r.NodeMeta()
+		out.Nodes = append(out.Nodes, NodeStatus{ID: string(r.nodeID), Host: r.selfHost, Up: true, ProtocolVersion: ProtocolVersion, Meta: &meta})
+	}
+
+	out.CommonFeatures = []string{}
+	for f, n := range featureCount {
+		if n == out.Up {
+			out.CommonFeatures = append(out.CommonFeatures, f)
+		}
+	}
+	sort.Strings(out.CommonFeatures)
+	out.Mixed = len(out.Versions) > 1
+	return out
+}
@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Nós observadores: sem tokens, fora do ring e das contas de consistência,
+// recebem do coordenador de cada escrita uma cópia assíncrona (de todos os
+// keyspaces ou só de alguns). Servem para leituras analíticas e backups sem
+// pesar nas réplicas: um observador lento ou fora do ar nunca atrasa nem
+// recusa uma escrita, só acumula hints.
+
+// DefaultObserverQueue é quantas escritas podem esperar o envio a cada
+// observador; com a fila cheia, a cópia vira hint.
+const DefaultObserverQueue = 10000
+
+// errObserverQueueFull é o motivo do hint de uma cópia que não coube na fila.
+var errObserverQueueFull = errors.New("observer queue full")
+
+// Observer é um nó observador e os keyspaces que ele recebe.
+type Observer struct {
+	Node hashring.NodeInfo
+	// Keyspaces copiados (vazio = todos)
+	Keyspaces []string
+}
+
+// ObserverStatus é o estado da cópia para um observador (GET /admin/observers).
+type ObserverStatus struct {
+	Node      string   `json:"node"`
+	Host      string   `json:"host"`
+	Keyspaces []string `json:"keyspaces,omitempty"`
+	// Queued: escritas esperando o envio
+	Queued int   `json:"queued"`
+	Sent   int64 `json:"sent"`
+	// Hinted: cópias que falharam (ou não couberam na fila) e ficaram como
+	// hint, reenviadas pelo replay de hints quando o observador voltar
+	Hinted    int64  `json:"hinted"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// observerFeed é a fila de cópias de um observador.
+type observerFeed struct {
+	Observer
+	keyspaces map[string]bool
+	queue     chan kv.Mutation
+
+	mu        sync.Mutex
+	sent      int64
+	hinted    int64
+	lastError string
+}
+
+func (f *observerFeed) wants(key string) bool {
+	return len(f.keyspaces) == 0 || f.keyspaces[kv.KeyspaceOf(key)]
+}
+
+type observerState struct {
+	// feeds: definidos antes de servir (SetObservers), só leitura depois
+	feeds []*observerFeed
+	// self: este nó é um observador
+	self bool
+}
+
+// SetObservers define os observadores que recebem a cópia das escritas
+// coordenadas por este nó, com filas de queue escritas cada (0 =
+// DefaultObserverQueue). Chamar antes de servir requisições; o envio roda em
+// RunObserverFeeds.
+func (r *Router) SetObservers(obs []Observer, queue int) {
+	if queue <= 0 {
+		queue = DefaultObserverQueue
+	}
+	r.observers.feeds = nil
+	for _, o := range obs {
+		f := &observerFeed{Observer: o, queue: make(chan kv.Mutation, queue)}
+		if len(o.Keyspaces) > 0 {
+			f.keyspaces = make(map[string]bool, len(o.Keyspaces))
+			for _, ks := range o.Keyspaces {
+				f.keyspaces[ks] = true
+			}
+		}
+		r.observers.feeds = append(r.observers.feeds, f)
+	}
+}
+
+// SetObserver marca este nó como observador: ele não está no ring, guarda a
+// cópia que os coordenadores mandam e responde as leituras ONE com ela (as
+// outras consistências vão às réplicas, como num coordenador puro).
+func (r *Router) SetObserver(v bool) {
+	r.observers.self = v
+}
+
+// Observer diz se este nó é um observador.
+func (r *Router) Observer() bool {
+	return r.observers.self
+}
+
+// observe enfileira a cópia de m para os observadores que recebem o keyspace
+// dela. Não bloqueia: com a fila cheia, a cópia vira hint.
+func (r *Router) observe(m kv.Mutation) {
+	for _, f := range r.observers.feeds {
+		if !f.wants(m.Key) {
+			continue
+		}
+		if r.isLocal(f.Node) {
+			r.localStore.Apply(m)
+			continue
+		}
+		select {
+		case f.queue <- m:
+		default:
+			r.observerFailed(f, []kv.Mutation{m}, errObserverQueueFull)
+		}
+	}
+}
+
+// RunObserverFeeds envia as cópias enfileiradas a cada observador até ctx
+// terminar, em lotes (RPC multi-chave) com o que já estiver na fila.
+func (r *Router) RunObserverFeeds(ctx context.Context) {
+	if len(r.observers.feeds) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, f := range r.observers.feeds {
+		log.Printf("[OBSERVER] copying writes to %s (%s), keyspaces %v", f.Node.ID, f.Node.Host, f.Keyspaces)
+		wg.Add(1)
+		go func(f *observerFeed) {
+			defer wg.Done()
+			r.runObserverFeed(ctx, f)
+		}(f)
+	}
+	wg.Wait()
+}
+
+func (r *Router) runObserverFeed(ctx context.Context, f *observerFeed) {
+	for {
+		var m kv.Mutation
+		select {
+		case <-ctx.Done():
+			return
+		case m = <-f.queue:
+		}
+		batch := []kv.Mutation{m}
+	drain:
+		for len(batch) < ReplicaBatchMaxItems {
+			select {
+			case m = <-f.queue:
+				batch = append(batch, m)
+			default:
+				break drain
+			}
+		}
+
+		cctx, cancel := r.replicaContext(ctx)
+		errs := r.sendMutations(cctx, f.Node, batch)
+		cancel()
+		var failed []kv.Mutation
+		var err error
+		for i, e := range errs {
+			if e != nil {
+				failed = append(failed, batch[i])
+				err = e
+			}
+		}
+		if sent := len(batch) - len(failed); sent > 0 {
+			f.mu.Lock()
+			f.sent += int64(sent)
+			if len(failed) == 0 {
+				f.lastError = ""
+			}
+			f.mu.Unlock()
+			metrics.Add("observer.sent", int64(sent))
+			r.noteUp(f.Node)
+		}
+		if len(failed) > 0 {
+			r.observerFailed(f, failed, err)
+		}
+	}
+}
+
+// observerFailed guarda como hint as cópias que não chegaram ao observador.
+func (r *Router) observerFailed(f *observerFeed, ms []kv.Mutation, err error) {
+	f.mu.Lock()
+	first := f.lastError == ""
+	f.hinted += int64(len(ms))
+	f.lastError = err.Error()
+	f.mu.Unlock()
+	if first {
+		log.Printf("[OBSERVER] copy to %s failed, storing hints: %v", f.Node.ID, err)
+	}
+	metrics.Add("observer.hinted", int64(len(ms)))
+	for _, m := range ms {
+		r.storeHint(f.Node, Hint{Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Delete: m.Op == kv.OpDelete})
+	}
+}
+
+// ObserverStatus retorna o estado da cópia para cada observador.
+func (r *Router) ObserverStatus() []ObserverStatus {
+	out := make([]ObserverStatus, 0, len(r.observers.feeds))
+	for _, f := range r.observers.feeds {
+		f.mu.Lock()
+		out = append(out, ObserverStatus{
+			Node:      string(f.Node.ID),
+			Host:      f.Node.Host,
+			Keyspaces: f.Keyspaces,
+			Queued:    len(f.queue),
+			Sent:      f.sent,
+			Hinted:    f.hinted,
+			LastError: f.lastError,
+		})
+		f.mu.Unlock()
+	}
+	return out
+}
+
+// readObserved é a leitura ONE de um observador: a cópia local.
+func (r *Router) readObserved(ctx context.Context, key string, digest bool) (kv.Entry, int, bool, error) {
+	self := hashring.NodeInfo{ID: r.nodeID, Host: r.selfHost}
+	rr, err := r.readReplica(ctx, self, key, digest)
+	if err != nil {
+		return kv.Entry{}, 0, false, err
+	}
+	outcome := outcomeFrom(ctx)
+	outcome.record(One, []hashring.NodeID{r.nodeID}, []hashring.NodeID{r.nodeID}, r.nodeID, true)
+	outcome.recordRead(true)
+	metrics.Inc("observer.reads")
+	if !rr.found {
+		return kv.Entry{}, 0, false, nil
+	}
+	return rr.entry, rr.length, true, nil
+}
@@ -0,0 +1,250 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mini-cassandra/hashring"
+)
+
+// Headers com as decisões do coordenador numa requisição de cliente (ver
+// api.OutcomeMiddleware), para clientes e testes de carga conferirem o
+// roteamento sem ler os logs.
+const (
+	CoordinatorHeader       = "X-Coordinator"
+	ReplicasContactedHeader = "X-Replicas-Contacted"
+	ReplicasAckedHeader     = "X-Replicas-Acked"
+	ServedByHeader          = "X-Served-By"
+	// ServedLocalHeader diz, numa leitura, se a versão devolvida veio do
+	// próprio coordenador ("true") ou de uma réplica remota
+	ServedLocalHeader = "X-Served-Local"
+	// ConsistencyAchievedHeader é o nível que as confirmações recebidas
+	// satisfazem (o pedido volta em X-Consistency)
+	ConsistencyAchievedHeader = "X-Consistency-Achieved"
+	// ReplicasFailedHeader lista, numa escrita, as réplicas que não
+	// confirmaram (e ficaram com um hint)
+	ReplicasFailedHeader = "X-Replicas-Failed"
+)
+
+// Outcome é o que o coordenador decidiu na última operação de réplicas de uma
+// requisição (numa leitura seguida de escrita, como o getset, vale a
+// escrita).
+type Outcome struct {
+	mu        sync.Mutex
+	requested Consistency
+	achieved  Consistency
+	contacted []hashring.NodeID
+	acked     []hashring.NodeID
+	servedBy  hashring.NodeID
+	// read: a operação foi uma leitura; servedLocal, se servedBy é o
+	// coordenador
+	read        bool
+	servedLocal bool
+	// numa escrita: as confirmações exigidas, as que contaram para o nível
+	// e as réplicas que falharam
+	write    bool
+	required int
+	acks     int
+	failed   []ReplicaFailure
+}
+
+// ReplicaFailure é uma réplica que não confirmou uma escrita.
+type ReplicaFailure struct {
+	Node  string `json:"node"`
+	Error string `json:"error"`
+}
+
+type outcomeKey struct{}
+
+// WithOutcome coloca em ctx um Outcome a ser preenchido pelas leituras e
+// escritas da requisição.
+func WithOutcome(ctx context.Context) (context.Context, *Outcome) {
+	o := &Outcome{}
+	return context.WithValue(ctx, outcomeKey{}, o), o
+}
+
+func outcomeFrom(ctx context.Context) *Outcome {
+	o, _ := ctx.Value(outcomeKey{}).(*Outcome)
+	return o
+}
+
+// OutcomeFrom retorna o Outcome colocado em ctx por WithOutcome (nil se não
+// houver).
+func OutcomeFrom(ctx context.Context) *Outcome {
+	return outcomeFrom(ctx)
+}
+
+// record guarda uma operação: as réplicas contatadas, as que confirmaram (ou
+// responderam, numa leitura) e a que serviu a versão devolvida.
+func (o *Outcome) record(cl Consistency, contacted, acked []hashring.NodeID, servedBy hashring.NodeID, ok bool) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requested = cl
+	o.contacted = contacted
+	o.acked = acked
+	o.servedBy = servedBy
+	o.achieved = achievedConsistency(cl, len(acked), len(contacted), ok)
+	o.read, o.servedLocal = false, false
+	o.write, o.required, o.acks, o.failed = false, 0, 0, nil
+}
+
+// recordWrite marca a última operação como escrita, com as confirmações
+// exigidas (need), as que contaram (acks) e as réplicas que falharam.
+func (o *Outcome) recordWrite(need, acks int, failed []ReplicaFailure) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.write = true
+	o.required, o.acks, o.failed = need, acks, failed
+}
+
+// recordRead marca a última operação como leitura: local diz se a versão
+// devolvida foi a da réplica local.
+func (o *Outcome) recordRead(local bool) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.read = true
+	o.servedLocal = local
+}
+
+// Provenance é a origem de uma leitura, no envelope JSON do GET
+// (?envelope=true): o mesmo que os headers da decisão.
+type Provenance struct {
+	ServedBy            string   `json:"served_by,omitempty"`
+	ServedLocal         bool     `json:"served_local"`
+	ReplicasContacted   []string `json:"replicas_contacted"`
+	ReplicasResponded   []string `json:"replicas_responded"`
+	Consistency         string   `json:"consistency,omitempty"`
+	ConsistencyAchieved string   `json:"consistency_achieved,omitempty"`
+}
+
+// Provenance retorna a origem da última operação (vazia sem Outcome).
+func (o *Outcome) Provenance() Provenance {
+	if o == nil {
+		return Provenance{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids := func(list []hashring.NodeID) []string {
+		out := make([]string, len(list))
+		for i, id := range list {
+			out[i] = string(id)
+		}
+		return out
+	}
+	return Provenance{
+		ServedBy:            string(o.servedBy),
+		ServedLocal:         o.servedLocal,
+		ReplicasContacted:   ids(o.contacted),
+		ReplicasResponded:   ids(o.acked),
+		Consistency:         string(o.requested),
+		ConsistencyAchieved: string(o.achieved),
+	}
+}
+
+// WriteAck é o relatório de confirmações de uma escrita aceita, no corpo do
+// PUT e do DELETE: um cliente em ONE vê assim que está gravando com menos
+// réplicas do que o RF (Degraded) sem esperar uma escrita falhar.
+type WriteAck struct {
+	Consistency         string           `json:"consistency"`
+	ConsistencyAchieved string           `json:"consistency_achieved"`
+	Required            int              `json:"required"`
+	Acks                int              `json:"acks"`
+	Replicas            int              `json:"replicas"`
+	Acked               []string         `json:"acked"`
+	Failed              []ReplicaFailure `json:"failed"`
+	// Degraded: alguma réplica não confirmou (ficou com um hint)
+	Degraded bool `json:"degraded"`
+}
+
+// WriteAck retorna o relatório da última operação, se ela foi uma escrita
+// (ok false sem Outcome, ou se a requisição não escreveu em réplicas, como
+// um CAS encaminhado a outro coordenador).
+func (o *Outcome) WriteAck() (WriteAck, bool) {
+	if o == nil {
+		return WriteAck{}, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.write {
+		return WriteAck{}, false
+	}
+	acked := make([]string, len(o.acked))
+	for i, id := range o.acked {
+		acked[i] = string(id)
+	}
+	return WriteAck{
+		Consistency:         string(o.requested),
+		ConsistencyAchieved: string(o.achieved),
+		Required:            o.required,
+		Acks:                o.acks,
+		Replicas:            len(o.contacted),
+		Acked:               acked,
+		Failed:              append([]ReplicaFailure{}, o.failed...),
+		Degraded:            len(o.failed) > 0,
+	}, true
+}
+
+// achievedConsistency é o nível mais forte que acks confirmações de n
+// réplicas satisfazem ("NONE" se nenhum). Um LOCAL_QUORUM aceito que não
+// chega ao quorum global fica como LOCAL_QUORUM.
+func achievedConsistency(requested Consistency, acks, n int, ok bool) Consistency {
+	switch {
+	case n > 0 && acks >= n:
+		return All
+	case acks >= Quorum.Required(n):
+		return Quorum
+	case ok && requested == LocalQuorum:
+		return LocalQuorum
+	case acks >= 1:
+		return One
+	}
+	return "NONE"
+}
+
+// Headers retorna os headers da decisão (vazio se a requisição não passou
+// por réplicas).
+func (o *Outcome) Headers() map[string]string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.requested == "" {
+		return nil
+	}
+	h := map[string]string{
+		ReplicasContactedHeader:   joinNodeIDs(o.contacted),
+		ReplicasAckedHeader:       joinNodeIDs(o.acked),
+		ConsistencyAchievedHeader: string(o.achieved),
+	}
+	if o.write && len(o.failed) > 0 {
+		ids := make([]string, len(o.failed))
+		for i, f := range o.failed {
+			ids[i] = f.Node
+		}
+		h[ReplicasFailedHeader] = strings.Join(ids, ",")
+	}
+	if o.servedBy != "" {
+		h[ServedByHeader] = string(o.servedBy)
+		if o.read {
+			h[ServedLocalHeader] = strconv.FormatBool(o.servedLocal)
+		}
+	}
+	return h
+}
+
+func joinNodeIDs(ids []hashring.NodeID) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = string(id)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,15 @@
+package cluster
+
+import "mini-cassandra/hashring"
+
+// ReplicationFactor retorna o fator de replicação configurado.
+func (r *Router) ReplicationFactor() int {
+	return r.replicationFactor
+}
+
+// Ownership calcula a posse do espaço de tokens de cada nó com o layout
+// atual de vnodes e o fator de replicação padrão do cluster (keyspaces com
+// outro fator não entram na conta).
+func (r *Router) Ownership() []hashring.Ownership {
+	return r.ring.Ownership(r.replicationFactor)
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/metrics"
+)
+
+// Partição de rede injetada (só para testes, ver /admin/partition): o nó
+// deixa de falar com os peers escolhidos nos dois sentidos. As chamadas que
+// ele faz para eles falham no transporte como se o nó estivesse fora do ar
+// (e viram hints), e as que chegam deles são derrubadas sem resposta. Os
+// peers são reconhecidos pelo NodeHeader, que todo nó manda nas chamadas
+// internas.
+
+// NodeHeader leva o NODE_ID de quem fez a chamada entre nós.
+const NodeHeader = "X-MC-Node"
+
+// ErrPartitioned é o erro das chamadas para um peer isolado por uma partição
+// injetada.
+type ErrPartitioned struct {
+	Node hashring.NodeID
+}
+
+func (e *ErrPartitioned) Error() string {
+	return fmt.Sprintf("network partition injected: traffic to %s is dropped", e.Node)
+}
+
+// PartitionStatus são os peers isolados deste nó (GET /admin/partition).
+type PartitionStatus struct {
+	Peers []string `json:"peers"`
+}
+
+type partitionState struct {
+	mu    sync.RWMutex
+	nodes map[hashring.NodeID]bool
+	hosts map[string]hashring.NodeID
+}
+
+// droppedHost retorna o peer isolado que responde em host, se houver.
+func (p *partitionState) droppedHost(host string) (hashring.NodeID, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	id, ok := p.hosts[host]
+	return id, ok
+}
+
+func (p *partitionState) droppedNode(id hashring.NodeID) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.nodes[id]
+}
+
+// SetPartition isola este nó dos peers (substitui a partição atual; uma
+// lista vazia a desfaz). Os peers têm que estar no ring.
+func (r *Router) SetPartition(peers []string) (PartitionStatus, error) {
+	byID := make(map[hashring.NodeID]hashring.NodeInfo)
+	for _, n := range r.ring.Nodes() {
+		byID[n.ID] = n
+	}
+	nodes := make(map[hashring.NodeID]bool, len(peers))
+	hosts := make(map[string]hashring.NodeID, len(peers))
+	for _, p := range peers {
+		id := hashring.NodeID(p)
+		if id == r.nodeID {
+			return PartitionStatus{}, fmt.Errorf("cannot partition node %s from itself", p)
+		}
+		n, ok := byID[id]
+		if !ok {
+			return PartitionStatus{}, fmt.Errorf("unknown node %q", p)
+		}
+		nodes[id] = true
+		hosts[n.Host] = id
+	}
+
+	st := &r.partition
+	st.mu.Lock()
+	st.nodes, st.hosts = nodes, hosts
+	st.mu.Unlock()
+	// o próximo contato com cada peer refaz o handshake
+	r.protocol.mu.Lock()
+	r.protocol.peers = make(map[string]*PeerProtocol)
+	r.protocol.mu.Unlock()
+	return r.Partition(), nil
+}
+
+// Partition retorna os peers isolados deste nó.
+func (r *Router) Partition() PartitionStatus {
+	st := &r.partition
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := PartitionStatus{Peers: make([]string, 0, len(st.nodes))}
+	for id := range st.nodes {
+		out.Peers = append(out.Peers, string(id))
+	}
+	sort.Strings(out.Peers)
+	return out
+}
+
+// PartitionedFrom diz se a requisição veio de um peer isolado (pelo
+// NodeHeader) e deve ser derrubada.
+func (r *Router) PartitionedFrom(req *http.Request) (hashring.NodeID, bool) {
+	id := hashring.NodeID(req.Header.Get(NodeHeader))
+	if id == "" || !r.partition.droppedNode(id) {
+		return "", false
+	}
+	metrics.Inc("partition.dropped_in")
+	return id, true
+}
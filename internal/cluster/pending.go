@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/metrics"
+)
+
+// PendingRangesPath recebe (POST) e remove (DELETE ?id=) os intervalos
+// pendentes de uma mudança de topologia.
+const PendingRangesPath = "/internal/ring/pending"
+
+// DefaultPendingRangesTTL é por quanto tempo os nós mantêm os intervalos
+// pendentes de uma mudança cujo coordenador não os removeu (caiu no meio).
+const DefaultPendingRangesTTL = 30 * time.Minute
+
+// PendingRanges é o ring que uma mudança de topologia em andamento vai
+// deixar (como os pending ranges do Cassandra). Enquanto ele existe, as
+// escritas também vão para os nós que passam a ser réplica de cada chave, e
+// o que for escrito durante o streaming já está no novo dono quando a
+// mudança termina (sem depender só do reenvio final).
+type PendingRanges struct {
+	// ID identifica a mudança (o job, ou o move-token)
+	ID          string                     `json:"id"`
+	Coordinator hashring.NodeID            `json:"coordinator"`
+	Tokens      map[uint32]hashring.NodeID `json:"tokens"`
+	Hosts       map[hashring.NodeID]string `json:"hosts"`
+	ExpiresAt   time.Time                  `json:"expires_at"`
+}
+
+// PendingRangesInfo resume os intervalos pendentes deste nó (GET
+// /admin/pending-ranges).
+type PendingRangesInfo struct {
+	ID          string    `json:"id"`
+	Coordinator string    `json:"coordinator"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Ranges: trechos do anel com réplicas pendentes
+	Ranges int `json:"ranges"`
+	// Nodes: nós que são réplica pendente de algum trecho
+	Nodes []string `json:"nodes"`
+}
+
+type pendingRing struct {
+	def  PendingRanges
+	ring *hashring.Ring
+}
+
+type pendingState struct {
+	cur atomic.Pointer[pendingRing]
+}
+
+// active retorna o ring pendente, ou nil sem nenhum (ou se expirou).
+func (s *pendingState) active() *pendingRing {
+	p := s.cur.Load()
+	if p == nil {
+		return nil
+	}
+	if time.Now().After(p.def.ExpiresAt) {
+		if s.cur.CompareAndSwap(p, nil) {
+			log.Printf("[PENDING] pending ranges of %s expired", p.def.ID)
+		}
+		return nil
+	}
+	return p
+}
+
+// pendingReplicas retorna as réplicas da chave no ring pendente que não
+// estão em current (as réplicas no ring atual).
+func (r *Router) pendingReplicas(key string, current []hashring.NodeInfo) []hashring.NodeInfo {
+	p := r.pending.active()
+	if p == nil {
+		return nil
+	}
+	var out []hashring.NodeInfo
+	for _, n := range p.ring.GetReplicasForKey(key, r.rfFor(key)) {
+		found := false
+		for _, c := range current {
+			if c.ID == n.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// isPendingReplica diz se este nó é réplica pendente da chave (o cleanup e o
+// rebalance não a tiram daqui).
+func (r *Router) isPendingReplica(key string) bool {
+	for _, n := range r.pendingReplicas(key, nil) {
+		if r.isLocal(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPendingRanges passa a mandar as escritas também para as réplicas do
+// ring pendente (substitui o de outra mudança).
+func (r *Router) SetPendingRanges(p PendingRanges) error {
+	if p.ID == "" || len(p.Tokens) == 0 {
+		return fmt.Errorf("pending ranges need id and tokens")
+	}
+	tokens := make(map[uint32]hashring.NodeInfo, len(p.Tokens))
+	for t, id := range p.Tokens {
+		host := p.Hosts[id]
+		if id == r.nodeID {
+			host = r.selfHost
+		}
+		if host == "" {
+			return fmt.Errorf("pending ranges: no host for node %s", id)
+		}
+		tokens[t] = hashring.NodeInfo{ID: id, Host: host}
+	}
+	ring := r.ring.Clone()
+	ring.SetTokens(tokens)
+	if p.ExpiresAt.IsZero() {
+		p.ExpiresAt = time.Now().Add(DefaultPendingRangesTTL)
+	}
+	if old := r.pending.cur.Swap(&pendingRing{def: p, ring: ring}); old == nil || old.def.ID != p.ID {
+		log.Printf("[PENDING] pending ranges of %s (coordinator %s) until %s", p.ID, p.Coordinator, p.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ClearPendingRanges remove os intervalos pendentes da mudança id (vazio:
+// os de qualquer uma). Retorna se havia algum.
+func (r *Router) ClearPendingRanges(id string) bool {
+	p := r.pending.cur.Load()
+	if p == nil || (id != "" && p.def.ID != id) {
+		return false
+	}
+	if !r.pending.cur.CompareAndSwap(p, nil) {
+		return false
+	}
+	log.Printf("[PENDING] pending ranges of %s cleared", p.def.ID)
+	return true
+}
+
+// PendingRangesStatus retorna os intervalos pendentes deste nó, se houver.
+func (r *Router) PendingRangesStatus() (PendingRangesInfo, bool) {
+	p := r.pending.active()
+	if p == nil {
+		return PendingRangesInfo{}, false
+	}
+	info := PendingRangesInfo{ID: p.def.ID, Coordinator: string(p.def.Coordinator), ExpiresAt: p.def.ExpiresAt, Nodes: []string{}}
+	seen := make(map[string]bool)
+	for _, mv := range r.rangeMoves(r.ring, p.ring) {
+		info.Ranges++
+		for _, id := range diffNodes(mv.After, mv.Before) {
+			if !seen[id] {
+				seen[id] = true
+				info.Nodes = append(info.Nodes, id)
+			}
+		}
+	}
+	sort.Strings(info.Nodes)
+	return info, true
+}
+
+// announcePending anuncia a todos os nós (e aplica aqui) o ring que a
+// mudança id vai deixar. Um nó que não recebe só deixa de mandar as escritas
+// para os donos novos (o reenvio final continua cobrindo). A função
+// retornada remove os intervalos pendentes em todos os nós: chamar quando a
+// mudança terminar (ou falhar).
+func (r *Router) announcePending(ctx context.Context, id string, after *hashring.Ring) func() {
+	p := PendingRanges{
+		ID:          id,
+		Coordinator: r.nodeID,
+		Tokens:      make(map[uint32]hashring.NodeID),
+		Hosts:       make(map[hashring.NodeID]string),
+		ExpiresAt:   time.Now().Add(DefaultPendingRangesTTL).UTC(),
+	}
+	for _, t := range after.Ranges() {
+		p.Tokens[t.End] = t.Owner.ID
+		p.Hosts[t.Owner.ID] = t.Owner.Host
+	}
+	if err := r.SetPendingRanges(p); err != nil {
+		log.Printf("[PENDING] %s: %v", id, err)
+		return func() {}
+	}
+	metrics.Inc("pending_ranges.announced")
+	body, _ := json.Marshal(p)
+	for _, nr := range r.Broadcast(ctx, "POST", PendingRangesPath, body) {
+		if !nr.OK() {
+			log.Printf("[PENDING] %s: pending ranges not applied on %s: %s", id, nr.Node.ID, nr.Error())
+		}
+	}
+	return func() {
+		// a mudança pode ter sido cancelada: remove com um prazo próprio
+		cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		r.ClearPendingRanges(id)
+		for _, nr := range r.Broadcast(cctx, "DELETE", PendingRangesPath+"?id="+url.QueryEscape(id), nil) {
+			if !nr.OK() {
+				log.Printf("[PENDING] %s: clearing pending ranges on %s failed (they expire at %s): %s",
+					id, nr.Node.ID, p.ExpiresAt.Format(time.RFC3339), nr.Error())
+			}
+		}
+	}
+}
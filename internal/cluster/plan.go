@@ -0,0 +1,396 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"mini-cassandra/hashring"
+)
+
+// TopologyChange descreve nós a adicionar e/ou remover do ring, e nós que
+// passam a ter outro número de vnodes.
+type TopologyChange struct {
+	Add    []hashring.NodeInfo     `json:"add,omitempty"`
+	Remove []hashring.NodeID       `json:"remove,omitempty"`
+	VNodes map[hashring.NodeID]int `json:"vnodes,omitempty"`
+}
+
+// RangeMove é um trecho do anel cujo conjunto de réplicas muda. Before e
+// After têm as réplicas do maior fator de replicação (maxReplicationFactor),
+// em ordem: as chaves de um keyspace com fator rf ficam nas rf primeiras.
+type RangeMove struct {
+	Start  uint32   `json:"start"`
+	End    uint32   `json:"end"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+	Keys   int      `json:"keys"`
+	Bytes  int64    `json:"bytes"`
+}
+
+// NodeMove resume o que um nó recebe e deixa de guardar.
+type NodeMove struct {
+	NodeID   string `json:"node_id"`
+	KeysIn   int    `json:"keys_in"`
+	BytesIn  int64  `json:"bytes_in"`
+	KeysOut  int    `json:"keys_out"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// Plan é o resultado de um rebalance simulado: nada é movido.
+type Plan struct {
+	Change TopologyChange `json:"change"`
+	// TokenFraction é a fração do espaço de tokens que muda de réplicas.
+	TokenFraction float64     `json:"token_fraction"`
+	Keys          int         `json:"keys"`
+	Bytes         int64       `json:"bytes"`
+	Nodes         []NodeMove  `json:"nodes"`
+	Ranges        []RangeMove `json:"ranges"`
+	SampleKeys    []string    `json:"sample_keys,omitempty"`
+}
+
+// applyChange monta o ring resultante da mudança (uma cópia do atual).
+func (r *Router) applyChange(change TopologyChange) (*hashring.Ring, error) {
+	after := r.ring.Clone()
+	current := make(map[hashring.NodeID]bool)
+	for _, n := range r.ring.Nodes() {
+		current[n.ID] = true
+	}
+	for _, n := range change.Add {
+		if n.ID == "" {
+			return nil, fmt.Errorf("node to add has empty id")
+		}
+		if current[n.ID] {
+			return nil, fmt.Errorf("node %s is already in the ring", n.ID)
+		}
+		current[n.ID] = true
+		after.AddNode(n)
+	}
+	for _, id := range change.Remove {
+		if !current[id] {
+			return nil, fmt.Errorf("node %s is not in the ring", id)
+		}
+		delete(current, id)
+		after.RemoveNode(id)
+	}
+	for id, n := range change.VNodes {
+		if !current[id] {
+			return nil, fmt.Errorf("node %s is not in the ring", id)
+		}
+		if n < 1 || n > MaxNodeVNodes {
+			return nil, fmt.Errorf("vnodes of %s must be between 1 and %d", id, MaxNodeVNodes)
+		}
+		after.SetNodeVNodes(id, n)
+	}
+	if len(current) == 0 {
+		return nil, fmt.Errorf("change would leave the ring empty")
+	}
+	return after, nil
+}
+
+// subRanges divide o anel nos tokens dos dois rings: dentro de cada trecho
+// as réplicas antes e depois são constantes.
+func subRanges(before, after *hashring.Ring) []uint32 {
+	seen := make(map[uint32]struct{})
+	var tokens []uint32
+	for _, ring := range []*hashring.Ring{before, after} {
+		for _, t := range ring.Tokens() {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i] < tokens[j] })
+	return tokens
+}
+
+// tokenIndex retorna o trecho (índice em tokens) que contém h.
+func tokenIndex(tokens []uint32, h uint32) int {
+	idx := sort.Search(len(tokens), func(i int) bool { return tokens[i] >= h })
+	if idx == len(tokens) {
+		idx = 0
+	}
+	return idx
+}
+
+func sameNodes(a, b []hashring.NodeInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[hashring.NodeID]struct{}, len(a))
+	for _, n := range a {
+		set[n.ID] = struct{}{}
+	}
+	for _, n := range b {
+		if _, ok := set[n.ID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// planRanges calcula só a partir dos rings quais trechos mudam de réplicas
+// (com o maior fator de replicação entre os keyspaces). moves é indexado pelo
+// trecho (índice em tokens).
+func (r *Router) planRanges(before, after *hashring.Ring) (tokens []uint32, moves map[int]*RangeMove, fraction float64) {
+	rfs := r.replicationFactors()
+	rf := rfs[len(rfs)-1]
+	tokens = subRanges(before, after)
+	moves = make(map[int]*RangeMove)
+	for i, end := range tokens {
+		b := before.ReplicasForToken(end, rf)
+		a := after.ReplicasForToken(end, rf)
+		// a ordem muda as réplicas dos fatores menores: compara cada um
+		same := true
+		for _, f := range rfs {
+			if !sameNodes(b[:min(f, len(b))], a[:min(f, len(a))]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			continue
+		}
+		start := tokens[(i+len(tokens)-1)%len(tokens)]
+		size := float64(end - start)
+		if len(tokens) == 1 {
+			size = float64(1 << 32)
+		}
+		fraction += size / float64(1<<32)
+		moves[i] = &RangeMove{Start: start, End: end, Before: nodeIDStrings(b), After: nodeIDStrings(a)}
+	}
+	return tokens, moves, fraction
+}
+
+// replicasFor corta ids nas réplicas de uma chave com fator rf.
+func replicasFor(ids []string, rf int) []string {
+	return ids[:min(rf, len(ids))]
+}
+
+// streamFor diz quais chaves do trecho o nó id passa a guardar, dados os
+// fatores de replicação em uso (rfs, em ordem): as de fator maior que a
+// posição dele em After e até a posição em Before (sem limite se não era
+// réplica). need é o menor desses fatores (0: nenhuma chave).
+func (mv RangeMove) streamFor(id string, rfs []int) (q StreamRangeRequest, need int) {
+	a := indexOf(mv.After, id)
+	b := indexOf(mv.Before, id)
+	for _, rf := range rfs {
+		if rf > a && (b < 0 || rf <= b) {
+			need = rf
+			break
+		}
+	}
+	if a < 0 || need == 0 {
+		return StreamRangeRequest{}, 0
+	}
+	q = StreamRangeRequest{Start: mv.Start, End: mv.End, MinRF: a + 1}
+	if b >= 0 {
+		q.MaxRF = b
+	}
+	return q, need
+}
+
+func indexOf(ids []string, id string) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// rangeMoves retorna os trechos que mudam de réplicas entre os dois rings,
+// ordenados pelo token final.
+func (r *Router) rangeMoves(before, after *hashring.Ring) []RangeMove {
+	_, byIndex, _ := r.planRanges(before, after)
+	moves := make([]RangeMove, 0, len(byIndex))
+	for _, mv := range byIndex {
+		moves = append(moves, *mv)
+	}
+	sort.Slice(moves, func(i, j int) bool { return moves[i].End < moves[j].End })
+	return moves
+}
+
+func nodeIDStrings(nodes []hashring.NodeInfo) []string {
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		out[i] = string(n.ID)
+	}
+	return out
+}
+
+func diffNodes(a, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, id := range b {
+		set[id] = struct{}{}
+	}
+	var out []string
+	for _, id := range a {
+		if _, ok := set[id]; !ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// PlanLocal simula a mudança sobre as chaves das quais este nó é a réplica
+// primária (assim, somando os nós, cada chave conta uma vez). Guarda até
+// sample chaves de exemplo.
+func (r *Router) PlanLocal(change TopologyChange, sample int) (*Plan, error) {
+	after, err := r.applyChange(change)
+	if err != nil {
+		return nil, err
+	}
+	tokens, moves, fraction := r.planRanges(r.ring, after)
+
+	plan := &Plan{Change: change, TokenFraction: fraction}
+	nodes := make(map[string]*NodeMove)
+	node := func(id string) *NodeMove {
+		if nodes[id] == nil {
+			nodes[id] = &NodeMove{NodeID: id}
+		}
+		return nodes[id]
+	}
+
+	for key, e := range r.localStore.Entries() {
+		h := r.ring.Hash(key)
+		if primary, ok := r.ring.GetNodeForKey(key); !ok || !r.isLocal(primary) {
+			continue
+		}
+		mv, ok := moves[tokenIndex(tokens, h)]
+		if !ok {
+			continue
+		}
+		rf := r.rfFor(key)
+		before, after := replicasFor(mv.Before, rf), replicasFor(mv.After, rf)
+		in, out := diffNodes(after, before), diffNodes(before, after)
+		if len(in) == 0 && len(out) == 0 {
+			// o trecho só muda de réplicas além do fator desta chave
+			continue
+		}
+		size := EntrySize(key, e)
+		mv.Keys++
+		mv.Bytes += size
+		plan.Keys++
+		plan.Bytes += size
+		for _, id := range in {
+			n := node(id)
+			n.KeysIn++
+			n.BytesIn += size
+		}
+		for _, id := range out {
+			n := node(id)
+			n.KeysOut++
+			n.BytesOut += size
+		}
+		if len(plan.SampleKeys) < sample {
+			plan.SampleKeys = append(plan.SampleKeys, key)
+		}
+	}
+
+	for _, mv := range moves {
+		plan.Ranges = append(plan.Ranges, *mv)
+	}
+	for _, n := range nodes {
+		plan.Nodes = append(plan.Nodes, *n)
+	}
+	plan.sort()
+	return plan, nil
+}
+
+func (p *Plan) sort() {
+	sort.Slice(p.Ranges, func(i, j int) bool { return p.Ranges[i].End < p.Ranges[j].End })
+	sort.Slice(p.Nodes, func(i, j int) bool { return p.Nodes[i].NodeID < p.Nodes[j].NodeID })
+}
+
+// merge soma ao plano os números de outro nó (mesma mudança, mesmos trechos).
+func (p *Plan) merge(o *Plan, sample int) {
+	p.Keys += o.Keys
+	p.Bytes += o.Bytes
+
+	ranges := make(map[uint32]*RangeMove, len(p.Ranges))
+	for i := range p.Ranges {
+		ranges[p.Ranges[i].End] = &p.Ranges[i]
+	}
+	for _, mv := range o.Ranges {
+		if cur, ok := ranges[mv.End]; ok {
+			cur.Keys += mv.Keys
+			cur.Bytes += mv.Bytes
+		}
+	}
+
+	nodes := make(map[string]int, len(p.Nodes))
+	for i := range p.Nodes {
+		nodes[p.Nodes[i].NodeID] = i
+	}
+	for _, n := range o.Nodes {
+		i, ok := nodes[n.NodeID]
+		if !ok {
+			nodes[n.NodeID] = len(p.Nodes)
+			p.Nodes = append(p.Nodes, n)
+			continue
+		}
+		cur := &p.Nodes[i]
+		cur.KeysIn += n.KeysIn
+		cur.BytesIn += n.BytesIn
+		cur.KeysOut += n.KeysOut
+		cur.BytesOut += n.BytesOut
+	}
+
+	for _, k := range o.SampleKeys {
+		if len(p.SampleKeys) >= sample {
+			break
+		}
+		p.SampleKeys = append(p.SampleKeys, k)
+	}
+}
+
+// planRequest é o corpo de /internal/rebalance/plan.
+type planRequest struct {
+	Change TopologyChange `json:"change"`
+	Sample int            `json:"sample"`
+}
+
+// PlanCluster simula a mudança em todos os nós e junta os resultados.
+func (r *Router) PlanCluster(ctx context.Context, change TopologyChange, sample int) (*Plan, []NodeResult, error) {
+	if _, err := r.applyChange(change); err != nil {
+		return nil, nil, err
+	}
+	body, _ := json.Marshal(planRequest{Change: change, Sample: sample})
+
+	var plan *Plan
+	var failed []NodeResult
+	for _, res := range r.Broadcast(ctx, "POST", "/internal/rebalance/plan", body) {
+		var local Plan
+		if res.OK() {
+			if err := json.Unmarshal(res.Body, &local); err != nil {
+				res.Err = err
+			}
+		}
+		if !res.OK() {
+			failed = append(failed, res)
+			continue
+		}
+		if plan == nil {
+			plan = &local
+			continue
+		}
+		plan.merge(&local, sample)
+	}
+	if plan == nil {
+		return nil, failed, fmt.Errorf("no node answered the plan request")
+	}
+	plan.sort()
+	return plan, failed, nil
+}
+
+// DecodePlanRequest lê o corpo de /internal/rebalance/plan.
+func DecodePlanRequest(body []byte) (TopologyChange, int, error) {
+	var req planRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return TopologyChange{}, 0, err
+	}
+	return req.Change, req.Sample, nil
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Fases de um bootstrap registradas no progresso.
+const (
+	phaseStreaming = "streaming" // enviando os trechos; Done marca os concluídos
+	phaseCatchUp   = "catch_up"  // ring já trocado, falta o reenvio final
+)
+
+// streamProgress registra em disco quais trechos de um bootstrap já foram
+// recebidos, para que um nó reiniciado continue de onde parou em vez de
+// buscar tudo de novo. Os trechos são identificados pelo token final, que
+// não muda enquanto o ring não é trocado.
+type streamProgress struct {
+	mu   sync.Mutex
+	path string
+
+	Op    string          `json:"op"`
+	Phase string          `json:"phase"`
+	Done  map[uint32]bool `json:"done"`
+}
+
+// loadProgress lê o progresso de op em path. Um arquivo de outra operação
+// (ou inexistente) recomeça do zero. path vazio desliga a persistência.
+func loadProgress(path, op string) (*streamProgress, error) {
+	p := &streamProgress{path: path, Op: op, Phase: phaseStreaming, Done: make(map[uint32]bool)}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved streamProgress
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("stream progress %s: %w", path, err)
+	}
+	if saved.Op != op {
+		return p, nil
+	}
+	p.Phase = saved.Phase
+	if saved.Done != nil {
+		p.Done = saved.Done
+	}
+	return p, nil
+}
+
+func (p *streamProgress) isDone(end uint32) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Done[end]
+}
+
+func (p *streamProgress) markDone(end uint32) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Done[end] = true
+	return p.saveLocked()
+}
+
+func (p *streamProgress) setPhase(phase string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Phase = phase
+	return p.saveLocked()
+}
+
+func (p *streamProgress) saveLocked() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// finish apaga o progresso de uma operação concluída.
+func (p *streamProgress) finish() error {
+	if p.path == "" {
+		return nil
+	}
+	err := os.Remove(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,345 @@
+package cluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// Versão do protocolo interno (/internal/*). Toda requisição entre nós leva
+// a versão no header ProtocolHeader; o nó que recebe recusa (426) versões
+// fora de [MinProtocolVersion, ProtocolVersion]. No primeiro contato com um
+// nó o coordenador faz o handshake (/internal/handshake) e passa a usar a
+// maior versão que os dois entendem, então num rolling upgrade um nó novo
+// continua falando com os antigos enquanto o MinProtocolVersion deles permitir.
+//
+// Ao mudar o formato de algum payload interno, incremente ProtocolVersion
+// (e MinProtocolVersion quando o formato antigo deixar de ser aceito).
+const (
+	// 2: /internal/replica/batch (RPC multi-chave)
+	// 3: /internal/replica/cas (escritas condicionais)
+	ProtocolVersion    = 3
+	MinProtocolVersion = 1
+
+	ProtocolHeader    = "X-MC-Protocol"
+	ClusterNameHeader = "X-MC-Cluster"
+	HandshakePath     = "/internal/handshake"
+
+	// AuthHeader leva o CLUSTER_SECRET em toda chamada interna
+	AuthHeader = "X-MC-Auth"
+
+	// DefaultClusterName é o CLUSTER_NAME de quem não configura um.
+	DefaultClusterName = "mini-cassandra"
+
+	handshakeTimeout  = 2 * time.Second
+	handshakeErrorTTL = 5 * time.Second
+)
+
+// Handshake é a resposta de /internal/handshake.
+type Handshake struct {
+	NodeID      string `json:"node_id"`
+	ClusterName string `json:"cluster_name"`
+	// Host, VNodes e ReplicationFactor são o endereço anunciado e a
+	// configuração do ring do nó (conferidos no boot, ver ValidateTopology)
+	Host               string `json:"host,omitempty"`
+	VNodes             int    `json:"vnodes,omitempty"`
+	ReplicationFactor  int    `json:"replication_factor,omitempty"`
+	ProtocolVersion    int    `json:"protocol_version"`
+	MinProtocolVersion int    `json:"min_protocol_version"`
+	// Meta são versão, rack/DC, capacidade e features do nó
+	Meta NodeMeta `json:"meta"`
+	// Compression são os encodings aceitos nos corpos das chamadas internas
+	Compression []string `json:"compression,omitempty"`
+	// Codecs são os formatos aceitos nas mutações de réplica (sem o campo:
+	// só JSON)
+	Codecs []string `json:"codecs,omitempty"`
+}
+
+// PeerProtocol é o resultado do handshake com um nó (GET /admin/protocol).
+type PeerProtocol struct {
+	Host       string `json:"host"`
+	NodeID     string `json:"node_id,omitempty"`
+	Cluster    string `json:"cluster,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	Negotiated int    `json:"negotiated,omitempty"`
+	// Datacenter é o DATACENTER anunciado pelo nó (usado em LOCAL_QUORUM)
+	Datacenter string `json:"datacenter,omitempty"`
+	// Compression é o encoding usado nos corpos enviados ao nó ("" = nenhum)
+	Compression string `json:"compression,omitempty"`
+	// Codec é o formato das mutações enviadas ao nó
+	Codec     string    `json:"codec,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// ErrIncompatibleProtocol é retornado para chamadas a um nó cuja versão do
+// protocolo interno não é compatível com a deste.
+type ErrIncompatibleProtocol struct {
+	Host   string
+	Reason string
+}
+
+func (e *ErrIncompatibleProtocol) Error() string {
+	return fmt.Sprintf("incompatible internal protocol with %s: %s", e.Host, e.Reason)
+}
+
+// NegotiateVersion escolhe a versão a usar com um nó que fala
+// [peerMin, peerMax], ou retorna erro se não houver versão em comum.
+func NegotiateVersion(peerMin, peerMax int) (int, error) {
+	v := ProtocolVersion
+	if peerMax < v {
+		v = peerMax
+	}
+	if v < MinProtocolVersion || v < peerMin {
+		return 0, fmt.Errorf("peer speaks versions %d..%d, this node %d..%d", peerMin, peerMax, MinProtocolVersion, ProtocolVersion)
+	}
+	return v, nil
+}
+
+// protocolTransport coloca a versão negociada e o nome do cluster em toda
+// requisição interna, fazendo o handshake no primeiro contato com cada host.
+// Um nó de outro cluster (CLUSTER_NAME diferente) é recusado no handshake,
+// antes de qualquer dado ser trocado.
+type protocolTransport struct {
+	base http.RoundTripper
+	node string
+	// partition: peers isolados por uma partição injetada
+	partition *partitionState
+	// epoch: época do ring deste nó, enviada em toda chamada
+	epoch *epochState
+
+	mu          sync.Mutex
+	cluster     string
+	secret      string
+	compression string
+	compressMin int
+	codec       string
+	peers       map[string]*PeerProtocol
+}
+
+func newProtocolTransport(base http.RoundTripper, node string) *protocolTransport {
+	return &protocolTransport{base: base, node: node, partition: &partitionState{}, cluster: DefaultClusterName, codec: CodecJSON, peers: make(map[string]*PeerProtocol)}
+}
+
+func (t *protocolTransport) clusterName() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cluster
+}
+
+func (t *protocolTransport) clusterSecret() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.secret
+}
+
+// setAuth põe o CLUSTER_SECRET (se houver) na requisição interna.
+func (t *protocolTransport) setAuth(req *http.Request) {
+	if secret := t.clusterSecret(); secret != "" {
+		req.Header.Set(AuthHeader, secret)
+	}
+}
+
+// SetClusterName define o CLUSTER_NAME deste nó: chamadas internas só são
+// trocadas com nós do mesmo cluster.
+func (r *Router) SetClusterName(name string) {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cluster = name
+	t.peers = make(map[string]*PeerProtocol)
+}
+
+// ClusterName retorna o CLUSTER_NAME deste nó.
+func (r *Router) ClusterName() string {
+	return r.protocol.clusterName()
+}
+
+// SetClusterSecret define o CLUSTER_SECRET: este nó manda o segredo em toda
+// chamada interna e só aceita /internal/* de quem manda o mesmo. Vazio
+// desliga a checagem.
+func (r *Router) SetClusterSecret(secret string) {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secret = secret
+	t.peers = make(map[string]*PeerProtocol)
+}
+
+// ClusterAuthorized diz se req (uma chamada /internal/*) traz o
+// CLUSTER_SECRET deste nó; sem segredo configurado, sempre.
+func (r *Router) ClusterAuthorized(req *http.Request) bool {
+	secret := r.protocol.clusterSecret()
+	if secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get(AuthHeader)), []byte(secret)) == 1
+}
+
+// SetTransport troca o transporte por baixo das chamadas entre nós (o padrão
+// é o http.DefaultTransport). O handshake, a compressão e a partição injetada
+// continuam por cima dele. Chame no boot, antes da primeira chamada.
+func (r *Router) SetTransport(base http.RoundTripper) {
+	r.protocol.base = base
+}
+
+func (t *protocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if id, ok := t.partition.droppedHost(host); ok {
+		metrics.Inc("partition.dropped_out")
+		return nil, &ErrPartitioned{Node: id}
+	}
+	peer := PeerProtocol{Negotiated: ProtocolVersion}
+	if req.URL.Path != HandshakePath {
+		p, err := t.negotiate(req.Context(), host)
+		if err != nil {
+			return nil, err
+		}
+		peer = p
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(ProtocolHeader, strconv.Itoa(peer.Negotiated))
+	req.Header.Set(ClusterNameHeader, t.clusterName())
+	req.Header.Set(NodeHeader, t.node)
+	if t.epoch != nil {
+		req.Header.Set(RingEpochHeader, strconv.FormatUint(t.epoch.value.Load(), 10))
+	}
+	t.setAuth(req)
+	if err := t.compressBody(req, peer.Compression); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	// latência até os headers da resposta, por operação e peer (/metrics)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.Observe("internode.request.duration.seconds", metrics.Labels{"op", req.URL.Path, "peer", host}, time.Since(start))
+	metrics.CountWith("internode.requests", metrics.Labels{"op", req.URL.Path, "peer", host, "code", code}, 1)
+	if err != nil {
+		// o nó pode estar reiniciando (talvez com outra versão)
+		t.forget(host)
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUpgradeRequired || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+		t.forget(host)
+	}
+	// o nó que respondeu tem um ring mais novo que o deste
+	t.epoch.noteNewer(host, resp.Header.Get(RingEpochHeader))
+	return resp, nil
+}
+
+func (t *protocolTransport) forget(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, host)
+}
+
+// negotiate retorna o que foi combinado com host (versão e compressão),
+// fazendo o handshake se ainda não houver nada (falhas de compatibilidade
+// ficam em cache por alguns segundos).
+func (t *protocolTransport) negotiate(ctx context.Context, host string) (PeerProtocol, error) {
+	t.mu.Lock()
+	p, ok := t.peers[host]
+	t.mu.Unlock()
+	if ok {
+		if p.Error == "" {
+			return *p, nil
+		}
+		if time.Since(p.CheckedAt) < handshakeErrorTTL {
+			return PeerProtocol{}, &ErrIncompatibleProtocol{Host: host, Reason: p.Error}
+		}
+	}
+
+	p, err := t.handshake(ctx, host)
+	if err != nil {
+		// falha de rede: não guarda, tenta de novo na próxima chamada
+		return PeerProtocol{}, err
+	}
+	t.mu.Lock()
+	t.peers[host] = p
+	t.mu.Unlock()
+	if p.Error != "" {
+		return PeerProtocol{}, &ErrIncompatibleProtocol{Host: host, Reason: p.Error}
+	}
+	return *p, nil
+}
+
+func (t *protocolTransport) handshake(ctx context.Context, host string) (*PeerProtocol, error) {
+	// o handshake cabe no prazo da chamada que o disparou, se houver
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+host+HandshakePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ProtocolHeader, strconv.Itoa(ProtocolVersion))
+	req.Header.Set(ClusterNameHeader, t.clusterName())
+	req.Header.Set(NodeHeader, t.node)
+	t.setAuth(req)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", host, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	p := &PeerProtocol{Host: host, CheckedAt: time.Now().UTC()}
+	if resp.StatusCode == http.StatusNotFound {
+		p.Error = "node does not support protocol negotiation (older version?)"
+		return p, nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		p.Error = "node rejected the cluster secret (CLUSTER_SECRET differs?)"
+		log.Printf("[CLUSTER] refusing %s: %s", host, p.Error)
+		return p, nil
+	}
+	var hs Handshake
+	if resp.StatusCode >= 300 || json.Unmarshal(body, &hs) != nil {
+		return nil, fmt.Errorf("handshake with %s: status=%d", host, resp.StatusCode)
+	}
+	p.NodeID, p.Cluster, p.Version = hs.NodeID, hs.ClusterName, hs.ProtocolVersion
+	p.Datacenter = hs.Meta.Datacenter
+	if local := t.clusterName(); hs.ClusterName != local {
+		p.Error = fmt.Sprintf("node %s belongs to cluster %q, this node to %q", hs.NodeID, hs.ClusterName, local)
+		log.Printf("[CLUSTER] refusing %s: %s", host, p.Error)
+		return p, nil
+	}
+	v, err := NegotiateVersion(hs.MinProtocolVersion, hs.ProtocolVersion)
+	if err != nil {
+		p.Error = err.Error()
+		return p, nil
+	}
+	p.Negotiated = v
+	p.Compression = t.peerCompression(hs.Compression)
+	p.Codec = t.peerCodec(hs.Codecs)
+	return p, nil
+}
+
+// PeerProtocols retorna os handshakes feitos com os outros nós, por host.
+func (r *Router) PeerProtocols() []PeerProtocol {
+	t := r.protocol
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]PeerProtocol, 0, len(t.peers))
+	for _, p := range t.peers {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+)
+
+// RangeSize é a quantidade aproximada de dados locais num intervalo de tokens.
+type RangeSize struct {
+	Start   uint32 `json:"start"`
+	End     uint32 `json:"end"`
+	Primary string `json:"primary"`
+	Keys    int    `json:"keys"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// EntrySize estima quantos bytes uma entrada ocupa (chave + valor + timestamp),
+// que é também o que ela custa para ser transferida entre nós.
+func EntrySize(key string, e kv.Entry) int64 {
+	return int64(len(key) + len(e.Value) + 8)
+}
+
+// Ranges retorna os intervalos de tokens do anel.
+func (r *Router) Ranges() []hashring.TokenRange {
+	return r.ring.Ranges()
+}
+
+// ReplicasForRange retorna as réplicas responsáveis por um intervalo com o
+// fator de replicação padrão (keyspaces com outro fator usam mais ou menos
+// delas, ver repairReplicasForRange).
+func (r *Router) ReplicasForRange(t hashring.TokenRange) []hashring.NodeInfo {
+	return r.ring.ReplicasForToken(t.End, r.replicationFactor)
+}
+
+// LocalRangeSizes agrupa os dados do store local por intervalo de tokens.
+// Só os intervalos com ao menos uma chave aparecem no resultado.
+func (r *Router) LocalRangeSizes() []RangeSize {
+	ranges := r.ring.Ranges()
+	sizes := make([]RangeSize, len(ranges))
+	for key, e := range r.localStore.Entries() {
+		i := r.ring.RangeIndex(r.ring.Hash(key))
+		if i < 0 {
+			continue
+		}
+		sizes[i].Keys++
+		sizes[i].Bytes += EntrySize(key, e)
+	}
+
+	out := make([]RangeSize, 0)
+	for i, s := range sizes {
+		if s.Keys == 0 {
+			continue
+		}
+		s.Start, s.End, s.Primary = ranges[i].Start, ranges[i].End, string(ranges[i].Owner.ID)
+		out = append(out, s)
+	}
+	return out
+}
+
+// DefaultStreamMBps é a vazão assumida (MB/s) para estimar a duração de
+// transferências de dados entre nós.
+const DefaultStreamMBps = 50.0
+
+// EstimateTransfer estima quanto tempo leva para transferir bytes a mbps MB/s.
+func EstimateTransfer(bytes int64, mbps float64) time.Duration {
+	if mbps <= 0 {
+		mbps = DefaultStreamMBps
+	}
+	return time.Duration(float64(bytes) / (mbps * 1e6) * float64(time.Second))
+}
+
+// ClusterRangeSizes junta os tamanhos por intervalo informados por todos os
+// nós, indexados pelo token final do intervalo. Como cada réplica guarda uma
+// cópia, o tamanho de um intervalo é o maior entre as réplicas (a que está
+// mais completa). failed lista os nós que não responderam.
+func (r *Router) ClusterRangeSizes(ctx context.Context) (sizes map[uint32]RangeSize, perNode map[hashring.NodeID]int64, failed []NodeResult) {
+	sizes = make(map[uint32]RangeSize)
+	perNode = make(map[hashring.NodeID]int64)
+	for _, res := range r.Broadcast(ctx, "GET", "/internal/ranges/sizes", nil) {
+		var local []RangeSize
+		if res.OK() {
+			if err := json.Unmarshal(res.Body, &local); err != nil {
+				res.Err = err
+			}
+		}
+		if !res.OK() {
+			failed = append(failed, res)
+			continue
+		}
+		for _, s := range local {
+			perNode[res.Node.ID] += s.Bytes
+			if cur, ok := sizes[s.End]; !ok || s.Bytes > cur.Bytes {
+				sizes[s.End] = s
+			}
+		}
+	}
+	return sizes, perNode, failed
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+)
+
+const readinessProbeTimeout = time.Second
+
+// Estados de GET /health/ready.
+const (
+	ReadyOK            = "ok"
+	ReadyDegraded      = "degraded"
+	ReadyBootstrapping = "bootstrapping"
+	ReadyDraining      = "draining"
+)
+
+// DefaultReadyMaxPendingHints é quantos hints pendentes (somando todas as
+// filas) o nó aceita antes de se declarar degradado.
+const DefaultReadyMaxPendingHints = 10000
+
+// ReadinessPolicy são os limites a partir dos quais o nó se declara
+// degradado em /health/ready.
+type ReadinessPolicy struct {
+	// MaxPendingHints: hints pendentes acima disso degradam (0 = ignora)
+	MaxPendingHints int
+	// RepairMaxAge: intervalos deste nó sem repair há mais que isso contam
+	// como repair pendente (0 = ignora)
+	RepairMaxAge time.Duration
+	// MaxUnrepairedRanges: repairs pendentes acima disso degradam
+	MaxUnrepairedRanges int
+}
+
+// PeerReachability é o resultado da sonda a um nó do ring.
+type PeerReachability struct {
+	ID    string `json:"id"`
+	Host  string `json:"host"`
+	Up    bool   `json:"up"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness é o corpo de /health/ready: o estado e, se não for "ok", os
+// motivos.
+type Readiness struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Reachable: nós do ring que responderam (este incluído); o esperado é
+	// min(replication_factor, nós)
+	Reachable         int                `json:"reachable"`
+	Expected          int                `json:"expected"`
+	ReplicationFactor int                `json:"replication_factor"`
+	Peers             []PeerReachability `json:"peers"`
+	PendingHints      int                `json:"pending_hints"`
+	UnrepairedRanges  int                `json:"unrepaired_ranges,omitempty"`
+}
+
+// SetReadinessPolicy troca os limites de /health/ready.
+func (r *Router) SetReadinessPolicy(p ReadinessPolicy) {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	r.readiness = p
+}
+
+// Readiness sonda os nós do ring (pelo handshake do protocolo interno) e
+// junta o estado local: bootstrap e drain vêm antes de tudo; depois, o nó
+// fica degradado se alcança menos nós que o fator de replicação (escritas
+// em ALL, ou QUORUM com mais uma falha, não fecham) ou se hints e repairs
+// pendentes passam dos limites da política.
+func (r *Router) Readiness(ctx context.Context) Readiness {
+	r.gate.mu.Lock()
+	policy := r.readiness
+	r.gate.mu.Unlock()
+
+	nodes := r.ring.Nodes()
+	out := Readiness{Status: ReadyOK, ReplicationFactor: r.replicationFactor, Peers: make([]PeerReachability, len(nodes))}
+	out.Expected = r.replicationFactor
+	if len(nodes) < out.Expected {
+		out.Expected = len(nodes)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, readinessProbeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		p := PeerReachability{ID: string(node.ID), Host: node.Host, Up: true}
+		if r.isLocal(node) {
+			out.Peers[i] = p
+			continue
+		}
+		wg.Add(1)
+		go func(i int, node hashring.NodeInfo, p PeerReachability) {
+			defer wg.Done()
+			if nr := r.call(cctx, node, "GET", HandshakePath, nil); !nr.OK() {
+				p.Up, p.Error = false, nr.Error()
+			}
+			out.Peers[i] = p
+		}(i, node, p)
+	}
+	wg.Wait()
+	for _, p := range out.Peers {
+		if p.Up {
+			out.Reachable++
+		}
+	}
+	if out.Reachable < out.Expected {
+		out.Reasons = append(out.Reasons, fmt.Sprintf("only %d of %d replicas reachable", out.Reachable, out.Expected))
+	}
+
+	for _, h := range r.HintStatus() {
+		out.PendingHints += h.Pending
+	}
+	if policy.MaxPendingHints > 0 && out.PendingHints > policy.MaxPendingHints {
+		out.Reasons = append(out.Reasons, fmt.Sprintf("%d pending hints (limit %d)", out.PendingHints, policy.MaxPendingHints))
+	}
+
+	if policy.RepairMaxAge > 0 {
+		cutoff := time.Now().Add(-policy.RepairMaxAge).UnixMicro()
+		for _, t := range r.ring.Ranges() {
+			replicas := r.ReplicasForRange(t)
+			for _, n := range replicas {
+				if r.isLocal(n) {
+					if r.repairedAt(t, replicas) < cutoff {
+						out.UnrepairedRanges++
+					}
+					break
+				}
+			}
+		}
+		if out.UnrepairedRanges > policy.MaxUnrepairedRanges {
+			out.Reasons = append(out.Reasons, fmt.Sprintf("%d ranges not repaired in %s (limit %d)", out.UnrepairedRanges, policy.RepairMaxAge, policy.MaxUnrepairedRanges))
+		}
+	}
+
+	r.gate.mu.Lock()
+	draining, bootstrapping := r.gate.draining, r.gate.bootstrapping
+	r.gate.mu.Unlock()
+	switch {
+	case draining:
+		out.Status = ReadyDraining
+		out.Reasons = append([]string{"node is draining"}, out.Reasons...)
+	case bootstrapping:
+		out.Status = ReadyBootstrapping
+		out.Reasons = append([]string{"node is bootstrapping"}, out.Reasons...)
+	case len(out.Reasons) > 0:
+		out.Status = ReadyDegraded
+	}
+	return out
+}
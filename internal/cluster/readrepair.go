@@ -0,0 +1,146 @@
+package cluster
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Read repair probabilístico: uma fração das leituras (read_repair_chance)
+// compara, em background, a versão da chave em todas as réplicas e envia a
+// mais nova às que estiverem atrasadas. Assim o custo de reparo fica diluído
+// no tráfego normal, mesmo com leituras que só consultam uma réplica.
+const (
+	DefaultReadRepairChance = 0.1
+
+	readRepairTimeout = 5 * time.Second
+)
+
+// ReadRepairStats são os contadores do read repair deste coordenador.
+type ReadRepairStats struct {
+	Chance     float64 `json:"chance"`
+	Checks     int64   `json:"checks"`
+	Mismatches int64   `json:"mismatches"`
+	Repaired   int64   `json:"repaired"`
+	Errors     int64   `json:"errors"`
+}
+
+type readRepairState struct {
+	chance                                 atomic.Uint64 // bits do float64
+	checks, mismatches, repaired, failures atomic.Int64
+}
+
+// SetReadRepairChance define a fração das leituras que disparam read repair
+// (0 desliga, 1 repara em toda leitura). Pode mudar em runtime
+// (read_repair_chance em /admin/settings).
+func (r *Router) SetReadRepairChance(p float64) {
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+	r.readRepair.chance.Store(math.Float64bits(p))
+}
+
+// ReadRepairChance retorna a fração atual das leituras com read repair.
+func (r *Router) ReadRepairChance() float64 {
+	return math.Float64frombits(r.readRepair.chance.Load())
+}
+
+// ReadRepairStats retorna os contadores do read repair.
+func (r *Router) ReadRepairStats() ReadRepairStats {
+	return ReadRepairStats{
+		Chance:     r.ReadRepairChance(),
+		Checks:     r.readRepair.checks.Load(),
+		Mismatches: r.readRepair.mismatches.Load(),
+		Repaired:   r.readRepair.repaired.Load(),
+		Errors:     r.readRepair.failures.Load(),
+	}
+}
+
+// maybeReadRepair sorteia se esta leitura dispara um read repair.
+func (r *Router) maybeReadRepair(key string, replicas []hashring.NodeInfo) {
+	chance := r.ReadRepairChance()
+	if len(replicas) < 2 || chance <= 0 || rand.Float64() >= chance {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), readRepairTimeout)
+		defer cancel()
+		r.readRepairKey(ctx, key, replicas)
+	}()
+}
+
+// readRepairKey busca a versão da chave em cada réplica e envia a mais nova
+// para as que estão sem ela ou com uma versão mais antiga (um tombstone mais
+// novo também é propagado). Num keyspace com estratégia de merge, versões
+// vivas diferentes são combinadas e o resultado é gravado em todas.
+func (r *Router) readRepairKey(ctx context.Context, key string, replicas []hashring.NodeInfo) {
+	r.readRepair.checks.Add(1)
+	metrics.Inc("read_repair.checks")
+
+	var newest *Record
+	var reads []replicaRead
+	versions := make([]int64, len(replicas))
+	alive := make([]bool, len(replicas))
+	for i, node := range replicas {
+		recs, err := r.recordsFrom(ctx, node, []string{key})
+		if err != nil {
+			r.readRepair.failures.Add(1)
+			continue
+		}
+		alive[i] = true
+		for _, rec := range recs {
+			rec := rec
+			versions[i] = rec.Timestamp
+			reads = append(reads, replicaRead{
+				entry:     kv.Entry{Value: rec.Value, Timestamp: rec.Timestamp, ExpiresAt: rec.ExpiresAt},
+				found:     !rec.Deleted,
+				tombstone: rec.Deleted,
+			})
+			if newest == nil || rec.Timestamp > newest.Timestamp || rec.Timestamp == newest.Timestamp && rec.Deleted && !newest.Deleted {
+				newest = &rec
+			}
+		}
+	}
+	if newest == nil {
+		return
+	}
+	if !newest.Deleted {
+		cur := replicaRead{entry: kv.Entry{Value: newest.Value, Timestamp: newest.Timestamp, ExpiresAt: newest.ExpiresAt}, found: true}
+		if e, ok := r.mergeVersions(ctx, key, cur, reads); ok && e.Timestamp != newest.Timestamp {
+			// o merge grava o resultado em todas as réplicas
+			r.readRepair.mismatches.Add(1)
+			metrics.Inc("read_repair.mismatches")
+			return
+		}
+	}
+
+	mismatch := false
+	for i, node := range replicas {
+		if !alive[i] || versions[i] >= newest.Timestamp {
+			continue
+		}
+		mismatch = true
+		if err := r.sendRecords(ctx, node, []Record{*newest}); err != nil {
+			r.readRepair.failures.Add(1)
+			log.Printf("[READ-REPAIR] key=%s to %s failed: %v", key, node.ID, err)
+			continue
+		}
+		r.readRepair.repaired.Add(1)
+		metrics.Inc("read_repair.repaired")
+		log.Printf("[READ-REPAIR] key=%s repaired on %s (ts=%d)", key, node.ID, newest.Timestamp)
+	}
+	if mismatch {
+		r.readRepair.mismatches.Add(1)
+		metrics.Inc("read_repair.mismatches")
+	}
+}
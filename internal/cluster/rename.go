@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+var (
+	// ErrSourceNotFound: a chave de origem do rename/copy não existe.
+	ErrSourceNotFound = errors.New("source key not found")
+	// ErrDestinationExists: a chave de destino já existe e não foi pedido
+	// overwrite.
+	ErrDestinationExists = errors.New("destination key already exists")
+	// ErrSourceChanged: a origem foi gravada ou apagada durante o rename; o
+	// destino foi desfeito.
+	ErrSourceChanged = errors.New("source key changed during rename")
+)
+
+// remainingTTL é o TTL (em microssegundos) que sobra de e; ok=false se já
+// expirou. 0 = não expira.
+func remainingTTL(e kv.Entry) (int64, bool) {
+	if e.ExpiresAt == 0 {
+		return 0, true
+	}
+	left := e.ExpiresAt - kv.Now()
+	return left, left > 0
+}
+
+// copyKey grava em to o valor atual de from, com o TTL que ainda resta, como
+// operação condicional no dono de to (sem overwrite, só se to não existir).
+// Um valor acima do limite dos objetos grandes vai em chunks, como no Put.
+// Retorna a versão lida da origem e o resultado da gravação do destino.
+func (r *Router) copyKey(ctx context.Context, from, to string, cl Consistency, overwrite bool) (kv.Entry, CASResult, error) {
+	if from == to {
+		return kv.Entry{}, CASResult{}, fmt.Errorf("source and destination are the same key")
+	}
+	src, ok, err := r.GetWith(ctx, from, casConsistency(cl))
+	if err != nil {
+		return kv.Entry{}, CASResult{}, fmt.Errorf("read %s: %w", from, err)
+	}
+	ttl, live := remainingTTL(src)
+	if !ok || !live {
+		return kv.Entry{}, CASResult{}, ErrSourceNotFound
+	}
+	// um objeto grande chega inteiro e é dividido de novo nos chunks de to
+	res, err := r.casPut(ctx, to, cl, CASOp{Op: kv.OpPut, Value: src.Value, TTL: ttl, IfNotExists: !overwrite})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return src, res, ErrDestinationExists
+	}
+	if err != nil {
+		return src, res, fmt.Errorf("write %s: %w", to, err)
+	}
+	return src, res, nil
+}
+
+// Copy grava em to uma cópia do valor (e do TTL restante) de from. O valor
+// vai das réplicas de from para as de to passando só pelo coordenador, sem
+// trafegar pelo cliente. Retorna a versão gravada em to.
+func (r *Router) Copy(ctx context.Context, from, to string, cl Consistency, overwrite bool) (int64, error) {
+	_, res, err := r.copyKey(ctx, from, to, cl, overwrite)
+	if err != nil {
+		return 0, err
+	}
+	metrics.Inc("copy.ok")
+	return res.Timestamp, nil
+}
+
+// Rename move from para to: copia o valor para as réplicas de to e depois
+// grava o tombstone de from, só se from ainda estiver na versão copiada. Não
+// é atômico entre as duas chaves — por um instante as duas existem — mas
+// nunca perde o valor: se a origem mudou no meio, o destino volta ao que era
+// (ErrSourceChanged); se o tombstone falhar por outro motivo, o erro diz que
+// o destino ficou gravado e a origem também.
+func (r *Router) Rename(ctx context.Context, from, to string, cl Consistency, overwrite bool) (int64, error) {
+	src, res, err := r.copyKey(ctx, from, to, cl, overwrite)
+	if err != nil {
+		return 0, err
+	}
+	// sem timestamp (réplica antiga), basta a origem ainda existir
+	_, err = r.DeleteIf(ctx, from, cl, src.Timestamp, src.Timestamp == 0)
+	if err == nil {
+		metrics.Inc("rename.ok")
+		return res.Timestamp, nil
+	}
+	metrics.Inc("rename.errors")
+	if !errors.Is(err, ErrPreconditionFailed) {
+		return 0, fmt.Errorf("%s written but %s not deleted (both exist): %w", to, from, err)
+	}
+	if rerr := r.undoCopy(ctx, to, cl, res); rerr != nil {
+		log.Printf("[RENAME] %s -> %s: source changed and rollback failed: %v", from, to, rerr)
+		return 0, fmt.Errorf("%w; rollback of %s failed: %v", ErrSourceChanged, to, rerr)
+	}
+	return 0, ErrSourceChanged
+}
+
+// undoCopy volta to ao que era antes da cópia (o valor anterior, ou apagado
+// se não existia), só se ninguém o gravou depois.
+func (r *Router) undoCopy(ctx context.Context, to string, cl Consistency, written CASResult) error {
+	op := CASOp{Op: kv.OpDelete, IfVersion: written.Timestamp}
+	if prev := written.Previous; prev.Found {
+		ttl, live := remainingTTL(prev.Entry)
+		if live {
+			op = CASOp{Op: kv.OpPut, Value: prev.Entry.Value, TTL: ttl, IfVersion: written.Timestamp}
+		}
+	}
+	_, err := r.CompareAndSet(ctx, to, cl, op)
+	return err
+}
@@ -0,0 +1,451 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/kv"
+)
+
+// KeyVersion é a chave com o timestamp da versão que uma réplica tem; é o
+// que as réplicas trocam no repair para descobrir divergências sem mandar
+// os valores.
+type KeyVersion struct {
+	Key       string `json:"key"`
+	Timestamp int64  `json:"ts"`
+}
+
+// RepairDetail é o que um job de repair expõe além do progresso em intervalos.
+type RepairDetail struct {
+	PrimaryOnly bool `json:"primary_only"`
+	Incremental bool `json:"incremental"`
+	// RangesIncremental: intervalos em que só as escritas depois do último
+	// repair foram comparadas
+	RangesIncremental int      `json:"ranges_incremental"`
+	Keys              int      `json:"keys"`
+	Mismatches        int      `json:"mismatches"`
+	Repaired          int      `json:"repaired"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+// repairKind é o tipo dos jobs de repair no gerenciador de jobs.
+const repairKind = "repair"
+
+// maxRepairErrors limita quantos erros ficam guardados num job.
+const maxRepairErrors = 20
+
+// repairMarkerSlack recua o marcador de repair em relação ao início do job,
+// cobrindo escritas que estavam a caminho e diferença de relógio entre nós.
+const repairMarkerSlack = time.Minute
+
+// repairMarker diz até que timestamp um intervalo já foi reparado, e com quais
+// réplicas (se as réplicas mudarem, o marcador não vale mais).
+type repairMarker struct {
+	RepairedAt int64    `json:"repaired_at"`
+	Replicas   []string `json:"replicas"`
+}
+
+type repairState struct {
+	Ranges map[uint32]repairMarker `json:"ranges"`
+}
+
+type repairs struct {
+	mu      sync.Mutex
+	path    string
+	markers map[uint32]repairMarker
+}
+
+// LoadRepairState lê os marcadores de repair gravados em path (vazio
+// desliga a persistência; arquivo inexistente não é erro).
+func (r *Router) LoadRepairState(path string) error {
+	r.repairs.mu.Lock()
+	defer r.repairs.mu.Unlock()
+	r.repairs.path = path
+	r.repairs.markers = make(map[uint32]repairMarker)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st repairState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("repair state %s: %w", path, err)
+	}
+	if st.Ranges != nil {
+		r.repairs.markers = st.Ranges
+	}
+	return nil
+}
+
+// saveRepairStateLocked grava os marcadores (chamar com repairs.mu).
+func (r *Router) saveRepairStateLocked() error {
+	if r.repairs.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(repairState{Ranges: r.repairs.markers})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.repairs.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.repairs.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.repairs.path)
+}
+
+// repairedAt retorna até quando o intervalo já foi reparado com as réplicas
+// atuais (0 se nunca foi).
+func (r *Router) repairedAt(rng hashring.TokenRange, replicas []hashring.NodeInfo) int64 {
+	r.repairs.mu.Lock()
+	defer r.repairs.mu.Unlock()
+	m, ok := r.repairs.markers[rng.End]
+	if !ok || !sameIDs(m.Replicas, nodeIDStrings(replicas)) {
+		return 0
+	}
+	return m.RepairedAt
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalVersions lista as versões locais (inclusive tombstones) das chaves de
+// um intervalo escritas depois de since (0 = todas), todas do mesmo instante
+// (snapshot do store). Com keyspace, só as chaves dele.
+func (r *Router) LocalVersions(rng hashring.TokenRange, since int64, keyspace string) []KeyVersion {
+	out := make([]KeyVersion, 0)
+	snap := r.localStore.Snapshot()
+	defer snap.Close()
+	// as chaves de um keyspace (fora o padrão) são contíguas
+	var prefix string
+	if keyspace != "" && keyspace != kv.DefaultKeyspace {
+		prefix = keyspace + kv.KeyspaceSep
+	}
+	snap.Iterate(prefix, "", func(key string, e kv.Entry) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if keyspace != "" && kv.KeyspaceOf(key) != keyspace {
+			return true
+		}
+		if e.Timestamp > since && rng.Contains(r.ring.Hash(key)) {
+			out = append(out, KeyVersion{Key: key, Timestamp: e.Timestamp})
+		}
+		return true
+	})
+	return out
+}
+
+// LocalKeyVersions retorna o timestamp local das chaves pedidas que existem
+// (inclusive tombstones).
+func (r *Router) LocalKeyVersions(keys []string) []KeyVersion {
+	out := make([]KeyVersion, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := r.localStore.Version(key); ok {
+			out = append(out, KeyVersion{Key: key, Timestamp: e.Timestamp})
+		}
+	}
+	return out
+}
+
+// LocalRecords retorna as entradas locais das chaves pedidas (as que existem,
+// inclusive tombstones).
+func (r *Router) LocalRecords(keys []string) []Record {
+	out := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := r.localStore.Version(key); ok {
+			out = append(out, Record{Key: key, Value: e.Value, Timestamp: e.Timestamp, Deleted: e.Deleted, ExpiresAt: e.ExpiresAt})
+		}
+	}
+	return out
+}
+
+func (r *Router) versionsFrom(ctx context.Context, node hashring.NodeInfo, rng hashring.TokenRange, since int64, keyspace string) ([]KeyVersion, error) {
+	if r.isLocal(node) {
+		return r.LocalVersions(rng, since, keyspace), nil
+	}
+	q := url.Values{}
+	q.Set("start", fmt.Sprint(rng.Start))
+	q.Set("end", fmt.Sprint(rng.End))
+	q.Set("since", fmt.Sprint(since))
+	if keyspace != "" {
+		q.Set("keyspace", keyspace)
+	}
+	res := r.call(ctx, node, "GET", "/internal/repair/versions?"+q.Encode(), nil)
+	if !res.OK() {
+		return nil, fmt.Errorf("versions from %s: %s", node.ID, res.Error())
+	}
+	var out []KeyVersion
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return nil, fmt.Errorf("versions from %s: %w", node.ID, err)
+	}
+	return out, nil
+}
+
+// keyVersionsFrom pergunta a node a versão de cada uma das keys.
+func (r *Router) keyVersionsFrom(ctx context.Context, node hashring.NodeInfo, keys []string) ([]KeyVersion, error) {
+	if r.isLocal(node) {
+		return r.LocalKeyVersions(keys), nil
+	}
+	body, _ := json.Marshal(keys)
+	res := r.call(ctx, node, "POST", "/internal/repair/versions", body)
+	if !res.OK() {
+		return nil, fmt.Errorf("key versions from %s: %s", node.ID, res.Error())
+	}
+	var out []KeyVersion
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return nil, fmt.Errorf("key versions from %s: %w", node.ID, err)
+	}
+	return out, nil
+}
+
+func (r *Router) recordsFrom(ctx context.Context, node hashring.NodeInfo, keys []string) ([]Record, error) {
+	if r.isLocal(node) {
+		return r.LocalRecords(keys), nil
+	}
+	body, _ := json.Marshal(keys)
+	res := r.call(ctx, node, "POST", "/internal/repair/fetch", body)
+	if !res.OK() {
+		return nil, fmt.Errorf("fetch from %s: %s", node.ID, res.Error())
+	}
+	var out []Record
+	if err := json.Unmarshal(res.Body, &out); err != nil {
+		return nil, fmt.Errorf("fetch from %s: %w", node.ID, err)
+	}
+	return out, nil
+}
+
+func (r *Router) sendRecords(ctx context.Context, node hashring.NodeInfo, records []Record) error {
+	if r.isLocal(node) {
+		r.ApplyStream(records)
+		return nil
+	}
+	for len(records) > 0 {
+		n := streamBatchSize
+		if n > len(records) {
+			n = len(records)
+		}
+		body, _ := json.Marshal(records[:n])
+		records = records[n:]
+		res := r.call(ctx, node, "POST", "/internal/stream/apply", body)
+		if !res.OK() {
+			return fmt.Errorf("send to %s: %s", node.ID, res.Error())
+		}
+	}
+	return nil
+}
+
+// rangeRepair é o resultado do repair de um intervalo.
+type rangeRepair struct {
+	keys, mismatches, repaired int
+	// bytes: chaves e valores enviados às réplicas desatualizadas
+	bytes int64
+	errs  []error
+}
+
+// repairRange compara as versões das réplicas de um intervalo (só as escritas
+// depois de since) e envia para cada réplica desatualizada a versão mais nova
+// das chaves que ela não tem. Tombstones entram como versões: um delete mais
+// novo que a escrita se propaga para as réplicas que ainda têm o valor.
+// Cada chave só vai para as primeiras réplicas, até o fator de replicação do
+// keyspace dela.
+func (r *Router) repairRange(ctx context.Context, rng hashring.TokenRange, replicas []hashring.NodeInfo, since int64) rangeRepair {
+	return r.repairRangeKeyspace(ctx, rng, replicas, since, "")
+}
+
+// repairRangeKeyspace é o repairRange só das chaves de keyspace ("" = todas).
+func (r *Router) repairRangeKeyspace(ctx context.Context, rng hashring.TokenRange, replicas []hashring.NodeInfo, since int64, keyspace string) rangeRepair {
+	var out rangeRepair
+
+	type newest struct {
+		ts     int64
+		holder int
+	}
+	latest := make(map[string]newest)
+	versions := make([]map[string]int64, len(replicas))
+	alive := make([]bool, len(replicas))
+	for i, node := range replicas {
+		list, err := r.versionsFrom(ctx, node, rng, since, keyspace)
+		if err != nil {
+			out.errs = append(out.errs, err)
+			continue
+		}
+		alive[i] = true
+		versions[i] = make(map[string]int64, len(list))
+		for _, v := range list {
+			versions[i][v.Key] = v.Timestamp
+			if cur, ok := latest[v.Key]; !ok || v.Timestamp > cur.ts {
+				latest[v.Key] = newest{ts: v.Timestamp, holder: i}
+			}
+		}
+	}
+	out.keys = len(latest)
+
+	// needs[holder][target] = chaves que target precisa receber de holder
+	needs := make(map[int]map[int][]string)
+	for key, n := range latest {
+		targets := len(replicas)
+		if rf := r.rfFor(key); rf < targets {
+			targets = rf
+		}
+		for i := range replicas[:targets] {
+			if !alive[i] {
+				continue
+			}
+			if ts, ok := versions[i][key]; ok && ts >= n.ts {
+				continue
+			}
+			out.mismatches++
+			if needs[n.holder] == nil {
+				needs[n.holder] = make(map[int][]string)
+			}
+			needs[n.holder][i] = append(needs[n.holder][i], key)
+		}
+	}
+
+	for holder, targets := range needs {
+		for target, keys := range targets {
+			sort.Strings(keys)
+			records, err := r.recordsFrom(ctx, replicas[holder], keys)
+			if err != nil {
+				out.errs = append(out.errs, err)
+				continue
+			}
+			if err := r.sendRecords(ctx, replicas[target], records); err != nil {
+				out.errs = append(out.errs, err)
+				continue
+			}
+			out.repaired += len(records)
+			for _, rec := range records {
+				out.bytes += int64(len(rec.Key) + len(rec.Value))
+			}
+		}
+	}
+	return out
+}
+
+// StartRepair inicia em background o repair de todos os intervalos dos quais
+// este nó é réplica (ou só dos que ele é réplica primária, com primaryOnly, o
+// que evita repetir trabalho quando o repair roda em todos os nós).
+// Cada intervalo reparado sem erros ganha um marcador; com incremental, só
+// as escritas posteriores ao marcador são comparadas, então o custo acompanha
+// o volume de escritas desde o último repair. Só um repair roda por vez.
+func (r *Router) StartRepair(primaryOnly, incremental bool) (jobs.Info, error) {
+	r.repairs.mu.Lock()
+	defer r.repairs.mu.Unlock()
+	if running, ok := r.jobs.Running(repairKind); ok {
+		return jobs.Info{}, fmt.Errorf("repair %s is already running", running.ID())
+	}
+
+	var ranges []hashring.TokenRange
+	for _, t := range r.ring.Ranges() {
+		replicas := r.repairReplicasForRange(t)
+		if len(replicas) == 0 {
+			continue
+		}
+		if primaryOnly && !r.isLocal(replicas[0]) {
+			continue
+		}
+		for _, n := range replicas {
+			if r.isLocal(n) {
+				ranges = append(ranges, t)
+				break
+			}
+		}
+	}
+
+	detail := RepairDetail{PrimaryOnly: primaryOnly, Incremental: incremental}
+	job := r.jobs.Start(repairKind, "ranges", func(ctx context.Context, job *jobs.Job) error {
+		return r.runRepair(ctx, job, ranges, detail)
+	})
+	job.SetTotal(int64(len(ranges)))
+	job.SetDetail(detail)
+	return job.Info(), nil
+}
+
+func (r *Router) runRepair(ctx context.Context, job *jobs.Job, ranges []hashring.TokenRange, detail RepairDetail) error {
+	log.Printf("[REPAIR] %s started: ranges=%d primary_only=%v incremental=%v", job.ID(), len(ranges), detail.PrimaryOnly, detail.Incremental)
+	marker := time.Now().Add(-repairMarkerSlack).UnixMicro()
+
+	done := 0
+	for _, rng := range ranges {
+		if err := ctx.Err(); err != nil {
+			r.saveRepairState()
+			return err
+		}
+		replicas := r.repairReplicasForRange(rng)
+		var since int64
+		if detail.Incremental {
+			since = r.repairedAt(rng, replicas)
+		}
+		res := r.repairRange(ctx, rng, replicas, since)
+
+		if len(res.errs) == 0 && marker > since {
+			r.repairs.mu.Lock()
+			if r.repairs.markers == nil {
+				r.repairs.markers = make(map[uint32]repairMarker)
+			}
+			r.repairs.markers[rng.End] = repairMarker{RepairedAt: marker, Replicas: nodeIDStrings(replicas)}
+			r.repairs.mu.Unlock()
+		}
+		if since > 0 {
+			detail.RangesIncremental++
+		}
+		detail.Keys += res.keys
+		detail.Mismatches += res.mismatches
+		detail.Repaired += res.repaired
+		for _, err := range res.errs {
+			if len(detail.Errors) < maxRepairErrors {
+				detail.Errors = append(detail.Errors, fmt.Sprintf("range (%d,%d]: %v", rng.Start, rng.End, err))
+			}
+		}
+		d := detail
+		d.Errors = append([]string(nil), detail.Errors...)
+		job.SetDetail(d)
+		job.Add(1, 0)
+		done++
+	}
+	r.saveRepairState()
+
+	log.Printf("[REPAIR] %s finished: ranges=%d keys=%d mismatches=%d repaired=%d errors=%d",
+		job.ID(), done, detail.Keys, detail.Mismatches, detail.Repaired, len(detail.Errors))
+	if len(detail.Errors) > 0 {
+		return fmt.Errorf("%d ranges could not be fully repaired", len(detail.Errors))
+	}
+	return nil
+}
+
+func (r *Router) saveRepairState() {
+	r.repairs.mu.Lock()
+	defer r.repairs.mu.Unlock()
+	if err := r.saveRepairStateLocked(); err != nil {
+		log.Printf("[REPAIR] saving repair state failed: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+)
+
+// ReplaceRequest é o corpo de /internal/ring/replace.
+type ReplaceRequest struct {
+	Dead hashring.NodeID   `json:"dead"`
+	Node hashring.NodeInfo `json:"node"`
+}
+
+// ReplaceResult descreve a substituição de um nó morto.
+type ReplaceResult struct {
+	Dead     string      `json:"dead"`
+	Node     string      `json:"node"`
+	Tokens   int         `json:"tokens"`
+	Ranges   int         `json:"ranges"`
+	Resumed  int         `json:"resumed"`
+	Streamed StreamStats `json:"streamed"`
+	CatchUp  StreamStats `json:"catch_up"`
+	// Unavailable: trechos que nenhuma réplica viva conseguiu enviar
+	// (ex: RF=1); os dados deles só voltam com restore de backup.
+	Unavailable []string `json:"unavailable,omitempty"`
+	Duration    string   `json:"duration"`
+}
+
+// ReplaceNode assume os tokens exatos do nó morto dead (mantendo o layout do
+// anel) e busca os dados dele nas réplicas sobreviventes:
+//  1. envia para este nó, a partir das outras réplicas, cada trecho do qual
+//     dead era réplica;
+//  2. passa os tokens de dead para este nó aqui e em todos os nós vivos;
+//  3. reenvia os trechos (pega escritas feitas durante o passo 1).
+//
+// O progresso fica em disco: se o nó reiniciar no meio, os trechos já
+// recebidos não são buscados de novo, e se o ring já tiver sido trocado só
+// falta o reenvio final.
+func (r *Router) ReplaceNode(ctx context.Context, dead hashring.NodeID, job *jobs.Job) (*ReplaceResult, error) {
+	r.topo.moveMu.Lock()
+	defer r.topo.moveMu.Unlock()
+	start := time.Now()
+
+	r.topo.mu.Lock()
+	progressPath := r.topo.progressPath
+	r.topo.mu.Unlock()
+	progress, err := loadProgress(progressPath, "replace:"+string(dead))
+	if err != nil {
+		return nil, err
+	}
+
+	self := hashring.NodeInfo{ID: r.nodeID, Host: r.selfHost}
+	res := &ReplaceResult{Dead: string(dead), Node: string(self.ID)}
+
+	// before: ring com os tokens em dead; after: com os tokens neste nó
+	before, after := r.ring.Clone(), r.ring.Clone()
+	for _, t := range r.ring.Ranges() {
+		if t.Owner.ID == dead {
+			after.MoveToken(t.End, self)
+			res.Tokens++
+		}
+	}
+	switched := false
+	if res.Tokens == 0 {
+		if progress.Phase != phaseCatchUp {
+			if _, ok := r.nodeByID(self.ID); ok {
+				log.Printf("[REPLACE] %s has no tokens left: already replaced", dead)
+				return res, nil
+			}
+			return nil, fmt.Errorf("node %s not in ring", dead)
+		}
+		// o ring já foi trocado antes do restart: refaz o before para
+		// calcular os trechos do reenvio final
+		for _, t := range r.ring.Ranges() {
+			if t.Owner.ID == self.ID {
+				before.MoveToken(t.End, hashring.NodeInfo{ID: dead})
+				res.Tokens++
+			}
+		}
+		switched = true
+	}
+
+	moves := r.rangeMoves(before, after)
+	res.Ranges = len(moves)
+	// as escritas para os tokens de dead também vêm para cá até o fim
+	defer r.announcePending(ctx, "replace-"+string(dead), after)()
+	// progresso do job: os trechos do streaming e depois os do reenvio final
+	job.SetTotal(int64(2 * len(moves)))
+	for _, mv := range moves {
+		if progress.isDone(mv.End) {
+			res.Resumed++
+		}
+	}
+
+	if !switched {
+		log.Printf("[REPLACE] %s taking over %d tokens of %s: streaming %d ranges (%d already done)",
+			self.ID, res.Tokens, dead, len(moves), res.Resumed)
+
+		streamed, failed := r.streamMoves(ctx, moves, after, dead, progress, job)
+		res.Streamed = streamed
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		for _, err := range failed {
+			log.Printf("[REPLACE] %v", err)
+			res.Unavailable = append(res.Unavailable, err.Error())
+		}
+
+		if err := progress.setPhase(phaseCatchUp); err != nil {
+			return res, fmt.Errorf("saving progress: %w", err)
+		}
+		if _, err := r.ReplaceTokens(dead, self); err != nil {
+			return res, fmt.Errorf("local ring change: %w", err)
+		}
+	} else {
+		log.Printf("[REPLACE] resuming replacement of %s after ring change", dead)
+		job.Add(int64(len(moves)), 0)
+	}
+
+	body, _ := json.Marshal(ReplaceRequest{Dead: dead, Node: self})
+	r.advanceRingEpoch()
+	for _, nr := range r.Broadcast(ctx, "POST", "/internal/ring/replace", body) {
+		if !nr.OK() {
+			return res, fmt.Errorf("ring change not applied on %s: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	catchUp, _ := r.streamMoves(ctx, moves, after, dead, nil, job)
+	res.CatchUp = catchUp
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if err := progress.finish(); err != nil {
+		log.Printf("[REPLACE] removing progress file failed: %v", err)
+	}
+
+	res.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("[REPLACE] %s replaced %s: streamed=%d keys catch_up=%d unavailable=%d in %s",
+		self.ID, dead, res.Streamed.Keys, res.CatchUp.Keys, len(res.Unavailable), res.Duration)
+	return res, nil
+}
+
+// StartReplace roda o ReplaceNode como job em background ("bootstrap").
+func (r *Router) StartReplace(dead hashring.NodeID) *jobs.Job {
+	r.SetBootstrapping(true)
+	return r.jobs.Start("bootstrap", "ranges", func(ctx context.Context, job *jobs.Job) error {
+		res, err := r.ReplaceNode(ctx, dead, job)
+		if res != nil {
+			job.SetDetail(*res)
+		}
+		// se falhar, o nó fica fora da readiness até um restart refazer o
+		// bootstrap
+		if err == nil {
+			r.SetBootstrapping(false)
+		}
+		return err
+	})
+}
@@ -0,0 +1,431 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Fator de replicação por keyspace: o REPLICATION_FACTOR é o padrão e um
+// keyspace pode ter outro, mudado em runtime (PUT
+// /admin/replication/{keyspace}). As definições se propagam como
+// os schemas (a mais nova ganha, broadcast e RunReplicationSync) e cada
+// mudança roda um job de re-replicação que copia as chaves do keyspace para
+// as réplicas novas (e, numa redução, tira as cópias que sobraram).
+
+// ReplicationPath é o endpoint interno dos fatores de replicação por
+// keyspace: GET lista as definições do nó (com as removidas), POST aplica
+// uma.
+const ReplicationPath = "/internal/replication"
+
+// DefaultReplicationSyncInterval é de quanto em quanto tempo o nó busca as
+// definições dos outros.
+const DefaultReplicationSyncInterval = 30 * time.Second
+
+// replicationKind é o tipo dos jobs de re-replicação no gerenciador de jobs.
+const replicationKind = "replication"
+
+// ErrInvalidReplication: fator de replicação ou keyspace inválido.
+var ErrInvalidReplication = errors.New("invalid replication factor")
+
+// ErrReplicationRunning: já há um job de re-replicação rodando neste nó.
+var ErrReplicationRunning = errors.New("a re-replication is already running")
+
+// KeyspaceReplication é o fator de replicação de um keyspace. Deleted volta
+// o keyspace ao REPLICATION_FACTOR.
+type KeyspaceReplication struct {
+	Keyspace          string `json:"keyspace"`
+	ReplicationFactor int    `json:"replication_factor,omitempty"`
+	UpdatedAt         int64  `json:"updated_at"`
+	Deleted           bool   `json:"deleted,omitempty"`
+}
+
+// ReplicationDetail é o que um job de re-replicação expõe além do progresso
+// em intervalos.
+type ReplicationDetail struct {
+	Keyspace string `json:"keyspace"`
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	// Keys comparadas e Copied (versões enviadas às réplicas que não as
+	// tinham); Cleaned: cópias removidas depois de uma redução
+	Keys    int      `json:"keys"`
+	Copied  int      `json:"copied"`
+	Cleaned int      `json:"cleaned,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+	// Satisfied: todas as chaves do keyspace estão nas To réplicas
+	Satisfied   bool       `json:"satisfied"`
+	SatisfiedAt *time.Time `json:"satisfied_at,omitempty"`
+}
+
+// KeyspaceReplicationStatus é o fator de replicação de um keyspace como este
+// nó o vê, com o último job de re-replicação disparado daqui.
+type KeyspaceReplicationStatus struct {
+	Keyspace          string     `json:"keyspace"`
+	ReplicationFactor int        `json:"replication_factor"`
+	UpdatedAt         int64      `json:"updated_at"`
+	LastJob           *jobs.Info `json:"last_job,omitempty"`
+}
+
+// replicationState são as definições deste nó, gravadas em path para
+// voltarem no boot; lastJob é o último job de cada keyspace.
+type replicationState struct {
+	mu      sync.RWMutex
+	path    string
+	defs    map[string]KeyspaceReplication
+	lastJob map[string]string
+}
+
+// checkReplication confere a definição e normaliza o keyspace.
+func checkReplication(def KeyspaceReplication) (KeyspaceReplication, error) {
+	if def.Keyspace == "" {
+		def.Keyspace = kv.DefaultKeyspace
+	}
+	if strings.Contains(def.Keyspace, kv.KeyspaceSep) {
+		return def, fmt.Errorf("%w: keyspace %q", ErrInvalidReplication, def.Keyspace)
+	}
+	if def.Deleted {
+		def.ReplicationFactor = 0
+	} else if def.ReplicationFactor < 1 {
+		return def, fmt.Errorf("%w: %d (want >= 1)", ErrInvalidReplication, def.ReplicationFactor)
+	}
+	return def, nil
+}
+
+// LoadReplication recria as definições gravadas em path (e passa a gravar
+// nele).
+func (r *Router) LoadReplication(path string) error {
+	s := &r.replication
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.defs = make(map[string]KeyspaceReplication)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var defs []KeyspaceReplication
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("replication state %s: %w", path, err)
+	}
+	for _, def := range defs {
+		def, err := checkReplication(def)
+		if err != nil {
+			return fmt.Errorf("replication state %s: %w", path, err)
+		}
+		s.defs[def.Keyspace] = def
+		if !def.Deleted {
+			log.Printf("[REPLICATION] keyspace %s uses replication factor %d", def.Keyspace, def.ReplicationFactor)
+		}
+	}
+	return nil
+}
+
+// ApplyLocalReplication aplica a definição neste nó se ela for mais nova que
+// a atual do keyspace; false se foi ignorada. Vale na hora para as leituras
+// e escritas coordenadas aqui.
+func (r *Router) ApplyLocalReplication(def KeyspaceReplication) (bool, error) {
+	def, err := checkReplication(def)
+	if err != nil {
+		return false, err
+	}
+	s := &r.replication
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.defs == nil {
+		s.defs = make(map[string]KeyspaceReplication)
+	}
+	if cur, ok := s.defs[def.Keyspace]; ok && cur.UpdatedAt >= def.UpdatedAt {
+		return false, nil
+	}
+	s.defs[def.Keyspace] = def
+	if def.Deleted {
+		log.Printf("[REPLICATION] keyspace %s back to the default replication factor %d on node %s", def.Keyspace, r.replicationFactor, r.nodeID)
+	} else {
+		log.Printf("[REPLICATION] keyspace %s now uses replication factor %d on node %s", def.Keyspace, def.ReplicationFactor, r.nodeID)
+	}
+	return true, r.saveReplicationLocked()
+}
+
+func (r *Router) saveReplicationLocked() error {
+	s := &r.replication
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.defsLocked(true), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *replicationState) defsLocked(deleted bool) []KeyspaceReplication {
+	defs := make([]KeyspaceReplication, 0, len(s.defs))
+	for _, def := range s.defs {
+		if def.Deleted && !deleted {
+			continue
+		}
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Keyspace < defs[j].Keyspace })
+	return defs
+}
+
+// KeyspaceReplications retorna as definições deste nó (deleted=true inclui
+// as removidas, para a sincronização).
+func (r *Router) KeyspaceReplications(deleted bool) []KeyspaceReplication {
+	r.replication.mu.RLock()
+	defer r.replication.mu.RUnlock()
+	return r.replication.defsLocked(deleted)
+}
+
+// KeyspaceReplicationFactor retorna o fator de replicação de um keyspace.
+func (r *Router) KeyspaceReplicationFactor(keyspace string) int {
+	r.replication.mu.RLock()
+	defer r.replication.mu.RUnlock()
+	if def, ok := r.replication.defs[keyspace]; ok && !def.Deleted {
+		return def.ReplicationFactor
+	}
+	return r.replicationFactor
+}
+
+// ReplicationStatus retorna o fator de replicação do keyspace e o último job
+// de re-replicação dele disparado por este nó.
+func (r *Router) ReplicationStatus(keyspace string) KeyspaceReplicationStatus {
+	if keyspace == "" {
+		keyspace = kv.DefaultKeyspace
+	}
+	st := KeyspaceReplicationStatus{Keyspace: keyspace, ReplicationFactor: r.KeyspaceReplicationFactor(keyspace)}
+	r.replication.mu.RLock()
+	st.UpdatedAt = r.replication.defs[keyspace].UpdatedAt
+	id := r.replication.lastJob[keyspace]
+	r.replication.mu.RUnlock()
+	if info, ok := r.jobs.Get(id); ok {
+		st.LastJob = &info
+	}
+	return st
+}
+
+// rfFor é o fator de replicação do keyspace da chave.
+func (r *Router) rfFor(key string) int {
+	r.replication.mu.RLock()
+	defer r.replication.mu.RUnlock()
+	if len(r.replication.defs) == 0 {
+		return r.replicationFactor
+	}
+	if def, ok := r.replication.defs[kv.KeyspaceOf(key)]; ok && !def.Deleted {
+		return def.ReplicationFactor
+	}
+	return r.replicationFactor
+}
+
+// replicasForKey retorna as réplicas da chave, com o fator de replicação do
+// keyspace dela.
+func (r *Router) replicasForKey(key string) []hashring.NodeInfo {
+	return r.ring.GetReplicasForKey(key, r.rfFor(key))
+}
+
+// maxReplicationFactor é o maior fator de replicação entre o padrão e o dos
+// keyspaces: o repair e o anti-entropy comparam essas réplicas de cada
+// intervalo, e cada chave só vai para as do keyspace dela.
+func (r *Router) maxReplicationFactor() int {
+	r.replication.mu.RLock()
+	defer r.replication.mu.RUnlock()
+	rf := r.replicationFactor
+	for _, def := range r.replication.defs {
+		if !def.Deleted && def.ReplicationFactor > rf {
+			rf = def.ReplicationFactor
+		}
+	}
+	return rf
+}
+
+// replicationFactors são os fatores de replicação em uso (o padrão e o de
+// cada keyspace), sem repetição e em ordem crescente.
+func (r *Router) replicationFactors() []int {
+	r.replication.mu.RLock()
+	defer r.replication.mu.RUnlock()
+	seen := map[int]bool{r.replicationFactor: true}
+	rfs := []int{r.replicationFactor}
+	for _, def := range r.replication.defs {
+		if !def.Deleted && !seen[def.ReplicationFactor] {
+			seen[def.ReplicationFactor] = true
+			rfs = append(rfs, def.ReplicationFactor)
+		}
+	}
+	sort.Ints(rfs)
+	return rfs
+}
+
+// repairReplicasForRange são as réplicas de um intervalo que o repair e o
+// anti-entropy comparam.
+func (r *Router) repairReplicasForRange(t hashring.TokenRange) []hashring.NodeInfo {
+	return r.ring.ReplicasForToken(t.End, r.maxReplicationFactor())
+}
+
+// SetKeyspaceReplication muda o fator de replicação do keyspace em todos os
+// nós do ring e inicia, neste nó, o job que leva as chaves do keyspace às
+// réplicas do novo fator. Repetir com o mesmo fator roda o job de novo (por
+// exemplo, depois de um job com erros).
+func (r *Router) SetKeyspaceReplication(ctx context.Context, keyspace string, rf int) (KeyspaceReplication, []NodeResult, jobs.Info, error) {
+	if n := len(r.ring.Nodes()); rf > n {
+		return KeyspaceReplication{}, nil, jobs.Info{}, fmt.Errorf("%w: %d, the ring has %d nodes", ErrInvalidReplication, rf, n)
+	}
+	return r.publishReplication(ctx, KeyspaceReplication{Keyspace: keyspace, ReplicationFactor: rf, UpdatedAt: kv.Now()})
+}
+
+// ResetKeyspaceReplication volta o keyspace ao REPLICATION_FACTOR em todos
+// os nós (com o job de re-replicação).
+func (r *Router) ResetKeyspaceReplication(ctx context.Context, keyspace string) (KeyspaceReplication, []NodeResult, jobs.Info, error) {
+	return r.publishReplication(ctx, KeyspaceReplication{Keyspace: keyspace, UpdatedAt: kv.Now(), Deleted: true})
+}
+
+func (r *Router) publishReplication(ctx context.Context, def KeyspaceReplication) (KeyspaceReplication, []NodeResult, jobs.Info, error) {
+	def, err := checkReplication(def)
+	if err != nil {
+		return def, nil, jobs.Info{}, err
+	}
+	if running, ok := r.jobs.Running(replicationKind); ok {
+		return def, nil, jobs.Info{}, fmt.Errorf("%w (%s)", ErrReplicationRunning, running.ID())
+	}
+	from := r.KeyspaceReplicationFactor(def.Keyspace)
+	if _, err := r.ApplyLocalReplication(def); err != nil {
+		return def, nil, jobs.Info{}, err
+	}
+	body, _ := json.Marshal(def)
+	results := r.Broadcast(ctx, "POST", ReplicationPath, body)
+	return def, results, r.startReReplication(def.Keyspace, from, r.KeyspaceReplicationFactor(def.Keyspace)), nil
+}
+
+// startReReplication inicia o job que compara, intervalo a intervalo, as
+// chaves do keyspace nas to réplicas de cada intervalo e copia a versão mais
+// nova para as que não a têm. Numa redução, o job termina com o cleanup em
+// todos os nós, que remove as cópias que ficaram fora das réplicas.
+func (r *Router) startReReplication(keyspace string, from, to int) jobs.Info {
+	ranges := r.ring.Ranges()
+	detail := ReplicationDetail{Keyspace: keyspace, From: from, To: to}
+	job := r.jobs.Start(replicationKind, "ranges", func(ctx context.Context, job *jobs.Job) error {
+		return r.runReReplication(ctx, job, ranges, detail)
+	})
+	job.SetTotal(int64(len(ranges)))
+	job.SetDetail(detail)
+	r.replication.mu.Lock()
+	if r.replication.lastJob == nil {
+		r.replication.lastJob = make(map[string]string)
+	}
+	r.replication.lastJob[keyspace] = job.ID()
+	r.replication.mu.Unlock()
+	return job.Info()
+}
+
+func (r *Router) runReReplication(ctx context.Context, job *jobs.Job, ranges []hashring.TokenRange, detail ReplicationDetail) error {
+	log.Printf("[REPLICATION] %s started: keyspace %s from replication factor %d to %d, %d ranges", job.ID(), detail.Keyspace, detail.From, detail.To, len(ranges))
+	for _, rng := range ranges {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res := r.repairRangeKeyspace(ctx, rng, r.ring.ReplicasForToken(rng.End, detail.To), 0, detail.Keyspace)
+		detail.Keys += res.keys
+		detail.Copied += res.repaired
+		for _, err := range res.errs {
+			if len(detail.Errors) < maxRepairErrors {
+				detail.Errors = append(detail.Errors, fmt.Sprintf("range (%d,%d]: %v", rng.Start, rng.End, err))
+			}
+		}
+		d := detail
+		d.Errors = append([]string(nil), detail.Errors...)
+		job.SetDetail(d)
+		job.Add(1, res.bytes)
+	}
+	metrics.Add("replication.copied", int64(detail.Copied))
+	if len(detail.Errors) > 0 {
+		log.Printf("[REPLICATION] %s finished with errors: keyspace %s keys=%d copied=%d errors=%d (repeat the change to retry)",
+			job.ID(), detail.Keyspace, detail.Keys, detail.Copied, len(detail.Errors))
+		return fmt.Errorf("%d ranges could not be re-replicated", len(detail.Errors))
+	}
+
+	if detail.To < detail.From {
+		for _, nr := range r.Broadcast(ctx, "POST", "/internal/cleanup", nil) {
+			var out struct {
+				Removed int `json:"removed"`
+			}
+			if nr.OK() && json.Unmarshal(nr.Body, &out) == nil {
+				detail.Cleaned += out.Removed
+			} else {
+				log.Printf("[REPLICATION] cleanup on %s failed: %s", nr.Node.ID, nr.Error())
+			}
+		}
+	}
+	now := time.Now().UTC()
+	detail.Satisfied, detail.SatisfiedAt = true, &now
+	job.SetDetail(detail)
+	log.Printf("[REPLICATION] %s finished: keyspace %s fully replicated with replication factor %d (keys=%d copied=%d cleaned=%d)",
+		job.ID(), detail.Keyspace, detail.To, detail.Keys, detail.Copied, detail.Cleaned)
+	return nil
+}
+
+// SyncReplication busca as definições dos nós do ring e aplica as mais
+// novas.
+func (r *Router) SyncReplication(ctx context.Context) int {
+	applied := 0
+	for _, res := range r.Broadcast(ctx, "GET", ReplicationPath, nil) {
+		if r.isLocal(res.Node) || !res.OK() {
+			continue
+		}
+		var defs []KeyspaceReplication
+		if err := json.Unmarshal(res.Body, &defs); err != nil {
+			continue
+		}
+		for _, def := range defs {
+			if ok, err := r.ApplyLocalReplication(def); err != nil {
+				log.Printf("[REPLICATION] ignoring replication of keyspace %q from %s: %v", def.Keyspace, res.Node.ID, err)
+			} else if ok {
+				applied++
+			}
+		}
+	}
+	return applied
+}
+
+// RunReplicationSync roda SyncReplication a cada interval até ctx terminar
+// (a primeira logo no boot).
+func (r *Router) RunReplicationSync(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReplicationSyncInterval
+	}
+	for {
+		cctx, cancel := context.WithTimeout(ctx, interval)
+		r.SyncReplication(cctx)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
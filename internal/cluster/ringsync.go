@@ -0,0 +1,214 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"mini-cassandra/hashring"
+)
+
+// RingPath retorna o ring atual do nó (dono e endereço de cada token).
+const RingPath = "/internal/ring"
+
+const ringSyncTimeout = 2 * time.Second
+
+// RingSnapshot é o ring de um nó: o dono de cada token, o endereço e o
+// número de vnodes de cada nó, e a época do ring (ver epoch.go).
+type RingSnapshot struct {
+	Node   hashring.NodeID            `json:"node"`
+	Epoch  uint64                     `json:"epoch"`
+	Tokens map[uint32]hashring.NodeID `json:"tokens"`
+	Hosts  map[hashring.NodeID]string `json:"hosts"`
+	VNodes map[hashring.NodeID]int    `json:"vnodes,omitempty"`
+}
+
+// RingReconcileResult resume o que ReconcileRing mudou no ring local.
+type RingReconcileResult struct {
+	From string `json:"from"`
+	// Moved: tokens que o peer atribui a outro dono
+	Moved int `json:"moved"`
+	// Added: tokens que o peer conhece e este nó não (nós que faltam em
+	// CLUSTER_NODES)
+	Added int `json:"added"`
+	// Hosts: nós com outro endereço no peer
+	Hosts []string `json:"hosts,omitempty"`
+	// VNodes: nós com outro número de vnodes no peer
+	VNodes []string `json:"vnodes,omitempty"`
+	// LocalOnly: tokens deste ring que o peer não tem (mantidos)
+	LocalOnly int `json:"local_only"`
+	// Epoch: época do ring deste nó depois da reconciliação
+	Epoch uint64 `json:"epoch"`
+	// Stale: o peer tinha uma época menor e o ring dele foi ignorado
+	Stale bool `json:"stale,omitempty"`
+}
+
+// RingSnapshot retorna o ring deste nó.
+func (r *Router) RingSnapshot() RingSnapshot {
+	snap := RingSnapshot{
+		Node:   r.nodeID,
+		Epoch:  r.RingEpoch(),
+		Tokens: make(map[uint32]hashring.NodeID),
+		Hosts:  make(map[hashring.NodeID]string),
+		VNodes: make(map[hashring.NodeID]int),
+	}
+	for _, t := range r.ring.Ranges() {
+		snap.Tokens[t.End] = t.Owner.ID
+		snap.Hosts[t.Owner.ID] = t.Owner.Host
+	}
+	for id := range snap.Hosts {
+		snap.VNodes[id] = r.ring.NodeVNodes(id)
+	}
+	return snap
+}
+
+// fetchRing busca o ring no primeiro dos nós que responder.
+func (r *Router) fetchRing(ctx context.Context, nodes []hashring.NodeInfo) (RingSnapshot, bool) {
+	for _, node := range nodes {
+		cctx, cancel := context.WithTimeout(ctx, ringSyncTimeout)
+		nr := r.call(cctx, node, "GET", RingPath, nil)
+		cancel()
+		if !nr.OK() {
+			log.Printf("[RING] ring from %s unavailable: %s", node.ID, nr.Error())
+			continue
+		}
+		var snap RingSnapshot
+		if err := json.Unmarshal(nr.Body, &snap); err != nil {
+			log.Printf("[RING] invalid ring from %s: %v", node.ID, err)
+			continue
+		}
+		return snap, true
+	}
+	return RingSnapshot{}, false
+}
+
+// ReconcileRing busca o ring no primeiro peer que responder e o aplica ao
+// ring local, para um nó reiniciado com um CLUSTER_NODES desatualizado não
+// rotear pela topologia antiga. O peer vence nos donos dos tokens (move-token
+// e REPLACE_NODE feitos enquanto este nó estava fora), nos endereços dos
+// outros nós e no número de vnodes de cada nó; tokens que só ele conhece são adicionados. Tokens que só este
+// nó conhece são mantidos (o peer pode ser o desatualizado, e mudanças de
+// CLUSTER_NODES só entram num restart). Um peer com época do ring menor que
+// a deste nó é o desatualizado e o ring dele é ignorado. Donos e tokens novos
+// são gravados no estado do ring; endereços são reaprendidos a cada boot.
+// found=false: nenhum peer respondeu e o ring local fica como está.
+func (r *Router) ReconcileRing(ctx context.Context) (res RingReconcileResult, found bool, err error) {
+	var peers []hashring.NodeInfo
+	for _, n := range r.ring.Nodes() {
+		if !r.isLocal(n) {
+			peers = append(peers, n)
+		}
+	}
+	snap, found := r.fetchRing(ctx, peers)
+	if !found {
+		return res, false, nil
+	}
+	res, err = r.reconcileWith(snap)
+	return res, true, err
+}
+
+// reconcileWith aplica o ring de um peer ao ring local (ver ReconcileRing).
+func (r *Router) reconcileWith(snap RingSnapshot) (res RingReconcileResult, err error) {
+	res.From = string(snap.Node)
+
+	r.topo.moveMu.Lock()
+	defer r.topo.moveMu.Unlock()
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+
+	if local := r.RingEpoch(); snap.Epoch < local {
+		log.Printf("[RING] ignoring ring of %s: epoch %d, this node is at %d", snap.Node, snap.Epoch, local)
+		res.Epoch, res.Stale = local, true
+		return res, nil
+	}
+
+	hostOf := func(id hashring.NodeID) string {
+		if id == r.nodeID {
+			return r.selfHost
+		}
+		return snap.Hosts[id]
+	}
+	for id, host := range snap.Hosts {
+		if id == r.nodeID || host == "" {
+			continue
+		}
+		if n, ok := r.nodeByID(id); ok && n.Host != host {
+			log.Printf("[RING] node %s moved from %s to %s (per %s)", id, n.Host, host, snap.Node)
+			r.ring.SetHost(id, host)
+			res.Hosts = append(res.Hosts, string(id))
+		}
+	}
+	sort.Strings(res.Hosts)
+
+	// vnodes antes dos tokens: os que o nó ganhou ou perdeu entram ou saem
+	// do anel aqui, e não como tokens avulsos
+	for id, n := range snap.VNodes {
+		if _, ok := r.nodeByID(id); !ok || n < 1 || n > MaxNodeVNodes || r.ring.NodeVNodes(id) == n {
+			continue
+		}
+		log.Printf("[RING] node %s has %d vnodes (per %s), not %d", id, n, snap.Node, r.ring.NodeVNodes(id))
+		r.setVNodesLocked(id, n)
+		res.VNodes = append(res.VNodes, string(id))
+	}
+	sort.Strings(res.VNodes)
+
+	tokens := make([]uint32, 0, len(snap.Tokens))
+	for t := range snap.Tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i] < tokens[j] })
+	for _, token := range tokens {
+		id := snap.Tokens[token]
+		node := hashring.NodeInfo{ID: id, Host: hostOf(id)}
+		if node.Host == "" {
+			continue
+		}
+		owner, ok := r.ring.TokenOwner(token)
+		if !ok {
+			if err := r.ring.AddToken(token, node); err != nil {
+				return res, err
+			}
+			if r.topo.added == nil {
+				r.topo.added = make(map[uint32]hashring.NodeID)
+			}
+			r.topo.added[token] = id
+			if r.topo.hosts == nil {
+				r.topo.hosts = make(map[hashring.NodeID]string)
+			}
+			r.topo.hosts[id] = node.Host
+			r.ringChanged()
+			res.Added++
+			continue
+		}
+		if owner.ID != id {
+			if err := r.setOwnerLocked(token, node); err != nil {
+				return res, err
+			}
+			res.Moved++
+		}
+	}
+	for _, t := range r.ring.Tokens() {
+		if _, ok := snap.Tokens[t]; !ok {
+			res.LocalOnly++
+		}
+	}
+
+	if res.LocalOnly > 0 {
+		log.Printf("[RING] %d local tokens unknown to %s (kept: its CLUSTER_NODES may be the outdated one)", res.LocalOnly, snap.Node)
+	}
+	epochChanged := r.adoptRingEpochLocked(snap.Epoch)
+	res.Epoch = r.RingEpoch()
+	if res.Moved == 0 && res.Added == 0 && len(res.Hosts) == 0 && len(res.VNodes) == 0 && !epochChanged {
+		log.Printf("[RING] ring matches %s", snap.Node)
+		return res, nil
+	}
+	log.Printf("[RING] reconciled with %s: %d tokens moved, %d added, %d hosts and %d vnode counts changed (epoch %d)",
+		snap.Node, res.Moved, res.Added, len(res.Hosts), len(res.VNodes), res.Epoch)
+	if err := r.saveRingStateLocked(); err != nil {
+		return res, fmt.Errorf("saving ring state: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,221 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/metrics"
+)
+
+// Padrões da reação a mudanças do ring (RING_CHANGE_DEBOUNCE e
+// RING_CHANGE_MAX_DELAY).
+const (
+	DefaultRingChangeDebounce = 10 * time.Second
+	DefaultRingChangeMaxDelay = time.Minute
+)
+
+// ringChangeKind é o job que acompanha uma mudança do ring (/admin/jobs).
+const ringChangeKind = "ring-change"
+
+// ringChangeRebalanceTimeout é o prazo do rebalance disparado por uma
+// mudança do ring.
+const ringChangeRebalanceTimeout = 5 * time.Minute
+
+// RingWatchPolicy diz como o nó reage às mudanças do ring (nó entrando,
+// token movido, nó substituído, ring reconciliado): depois que o ring fica
+// Debounce sem mudar (ou MaxDelay depois da primeira mudança, numa
+// sequência que não para, como um rolling de vários nós), ele busca os
+// trechos que passaram a ser dele e roda o rebalance para os que deixaram de
+// ser.
+type RingWatchPolicy struct {
+	// Debounce sem mudanças antes de reagir (0 desliga)
+	Debounce time.Duration
+	// MaxDelay: espera máxima desde a primeira mudança ainda não tratada
+	MaxDelay time.Duration
+}
+
+// RingChangeDetail é o detail do job ring-change.
+type RingChangeDetail struct {
+	// Events: mudanças do ring juntadas nesta rodada
+	Events int `json:"events"`
+	// Ranges: trechos dos quais este nó passou a ser réplica
+	Ranges int `json:"ranges"`
+	// Keys comparadas com as réplicas anteriores e Fetched, as que faltavam
+	// aqui (ou estavam mais velhas) e foram recebidas
+	Keys    int `json:"keys"`
+	Fetched int `json:"fetched"`
+	Failed  int `json:"failed"`
+	// Rebalance: o job de rebalance disparado no fim
+	Rebalance string `json:"rebalance,omitempty"`
+}
+
+// ringWatch junta as notificações de mudança do ring para o RunRingWatch.
+type ringWatch struct {
+	wake chan struct{}
+	// events: notificações desde a última rodada (protegido por topo.mu)
+	events int
+}
+
+// ringChanged avisa o RunRingWatch que o ring mudou (chamar com topo.mu).
+// Não bloqueia: várias mudanças seguidas viram uma rodada só.
+func (r *Router) ringChanged() {
+	r.watch.events++
+	metrics.Inc("ring.changes")
+	select {
+	case r.watch.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunRingWatch reage às mudanças do ring até ctx terminar, para os dados
+// começarem a andar assim que um nó entra ou muda de tokens (e não só no
+// próximo boot ou rebalance manual). Cada rodada, um job ring-change:
+//  1. compara o ring com o da rodada anterior e, para cada trecho do qual
+//     este nó passou a ser réplica, busca nas réplicas anteriores as chaves
+//     que faltam aqui (comparando versões, como o repair: o que o
+//     coordenador da mudança já enviou não é enviado de novo);
+//  2. dispara o rebalance, que manda para os novos donos as chaves que
+//     deixaram de ser deste nó.
+//
+// Enquanto este nó coordena uma mudança de topologia (move-token, join,
+// replace), está em bootstrap ou em drain, a rodada espera: a própria
+// operação já transfere os dados dela.
+func (r *Router) RunRingWatch(ctx context.Context, p RingWatchPolicy) {
+	if p.Debounce <= 0 {
+		return
+	}
+	if p.MaxDelay < p.Debounce {
+		p.MaxDelay = p.Debounce
+	}
+	// as mudanças do boot (seeds, RING_SYNC_ON_START) já são tratadas pelo
+	// rebalance do boot
+	r.topo.mu.Lock()
+	base := r.ring.Clone()
+	r.watch.events = 0
+	r.topo.mu.Unlock()
+	select {
+	case <-r.watch.wake:
+	default:
+	}
+
+	var first time.Time
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.watch.wake:
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			wait := p.Debounce
+			if limit := first.Add(p.MaxDelay).Sub(now); limit < wait {
+				wait = limit
+			}
+			timer.Stop()
+			timer.Reset(wait)
+		case <-timer.C:
+			if reason := r.ringWatchBlocked(); reason != "" {
+				log.Printf("[RING] ring change handling postponed: %s", reason)
+				timer.Reset(p.Debounce)
+				continue
+			}
+			first = time.Time{}
+			r.topo.mu.Lock()
+			after := r.ring.Clone()
+			events := r.watch.events
+			r.watch.events = 0
+			r.topo.mu.Unlock()
+
+			r.startRingChange(base, after, events)
+			base = after
+		}
+	}
+}
+
+// ringWatchBlocked diz por que a rodada tem que esperar ("" se pode rodar).
+func (r *Router) ringWatchBlocked() string {
+	r.gate.mu.Lock()
+	bootstrapping, draining := r.gate.bootstrapping, r.gate.draining
+	r.gate.mu.Unlock()
+	switch {
+	case bootstrapping:
+		return "node is bootstrapping"
+	case draining:
+		return "node is draining"
+	}
+	if !r.topo.moveMu.TryLock() {
+		return "topology change in progress on this node"
+	}
+	r.topo.moveMu.Unlock()
+	if _, ok := r.jobs.Running(ringChangeKind); ok {
+		return "previous ring change still running"
+	}
+	return ""
+}
+
+// startRingChange inicia o job ring-change da mudança before -> after.
+func (r *Router) startRingChange(before, after *hashring.Ring, events int) {
+	var incoming []RangeMove
+	rfs := r.replicationFactors()
+	for _, mv := range r.rangeMoves(before, after) {
+		if _, need := mv.streamFor(string(r.nodeID), rfs); need > 0 {
+			incoming = append(incoming, mv)
+		}
+	}
+	log.Printf("[RING] %d ring changes settled: %d new ranges for this node", events, len(incoming))
+	metrics.Inc("ring.change_runs")
+
+	r.jobs.Start(ringChangeKind, "ranges", func(jctx context.Context, job *jobs.Job) error {
+		detail := RingChangeDetail{Events: events, Ranges: len(incoming)}
+		job.SetTotal(int64(len(incoming)))
+		defer func() { job.SetDetail(detail) }()
+
+		// só as réplicas anteriores que continuam no ring (um nó substituído
+		// não responde mais)
+		nodes := make(map[string]hashring.NodeInfo)
+		for _, n := range after.Nodes() {
+			nodes[string(n.ID)] = n
+		}
+		self := hashring.NodeInfo{ID: r.nodeID, Host: r.selfHost}
+		var errs []error
+		for _, mv := range incoming {
+			if err := jctx.Err(); err != nil {
+				return err
+			}
+			replicas := []hashring.NodeInfo{self}
+			for _, id := range mv.Before {
+				if n, ok := nodes[id]; ok {
+					replicas = append(replicas, n)
+				}
+			}
+			res := r.repairRange(jctx, hashring.TokenRange{Start: mv.Start, End: mv.End}, replicas, 0)
+			detail.Keys += res.keys
+			detail.Fetched += res.repaired
+			if len(res.errs) > 0 {
+				detail.Failed++
+				errs = append(errs, res.errs...)
+			}
+			job.Add(1, 0)
+			job.SetDetail(detail)
+		}
+
+		if !r.coordinatorOnly {
+			detail.Rebalance = r.StartRebalance(ringChangeRebalanceTimeout).ID()
+		}
+		if len(errs) > 0 {
+			for _, err := range errs {
+				log.Printf("[RING] ring change: %v", err)
+			}
+			return fmt.Errorf("%d of %d new ranges not fully fetched (first error: %v)", detail.Failed, len(incoming), errs[0])
+		}
+		return nil
+	})
+}
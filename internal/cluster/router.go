@@ -1,18 +1,25 @@
 package cluster
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"mini-cassandra/internal/hashring"
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/jobs"
 	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
 )
 
 type Router struct {
@@ -21,23 +28,88 @@ type Router struct {
 	selfHost          string
 	ring              *hashring.Ring
 	httpClient        *http.Client
+	adminClient       *http.Client // chamadas administrativas (broadcast), sem o timeout curto
 	replicationFactor int
+	gate              writeGate
+	readiness         ReadinessPolicy // protegido por gate.mu
+	topo              topology
+	repairs           repairs
+	hints             hintStore
+	traces            traceStore
+	readRepair        readRepairState
+	latency           latencyTracker
+	cas               casState
+	disk              diskGuard
+	maintenance       maintenanceState
+	watch             ringWatch
+	antiEntropy       antiEntropyState
+	scanJobs          scanJobStore
+	observers         observerState
+	indexes           indexState
+	schemas           schemaState
+	merge             map[string]mergeStrategy // keyspace -> estratégia (sem = LWW)
+	large             LargeObjectConfig
+	writeCL           Consistency
+	readCL            Consistency
+	writeCLByKeyspace map[string]Consistency
+	readCLByKeyspace  map[string]Consistency
+	replicaTimeout    atomic.Int64 // time.Duration
+	maxRequestTimeout time.Duration
+	protocol          *protocolTransport
+	partition         partitionState
+	epoch             epochState
+	pending           pendingState
+	meta              NodeMeta
+	appMeta           appMetadata
+	settings          settingsState
+	replication       replicationState
+	coordinatorOnly   bool
+	jobs              *jobs.Manager
 }
 
 func NewRouter(local *kv.Store, nodeID hashring.NodeID, selfHost string, ring *hashring.Ring, replicationFactor int) *Router {
 	if replicationFactor < 1 {
 		replicationFactor = 1
 	}
-	return &Router{
+	// todas as chamadas entre nós passam pelo transporte que negocia a
+	// versão do protocolo interno
+	protocol := newProtocolTransport(http.DefaultTransport, string(nodeID))
+	r := &Router{
 		localStore: local,
 		nodeID:     nodeID,
 		selfHost:   selfHost,
 		ring:       ring,
+		// sem Timeout no cliente: o prazo de cada chamada de réplica vem do
+		// contexto (ver timeout.go)
 		httpClient: &http.Client{
-			Timeout: 2 * time.Second,
+			Transport: protocol,
 		},
+		adminClient: &http.Client{
+			Timeout:   5 * time.Minute,
+			Transport: protocol,
+		},
+		protocol:          protocol,
 		replicationFactor: replicationFactor,
+		writeCL:           DefaultWriteConsistency,
+		readCL:            DefaultReadConsistency,
+		maxRequestTimeout: DefaultMaxRequestTimeout,
+		hints:             hintStore{policy: DefaultHintPolicy()},
+		latency:           latencyTracker{policy: DefaultSlowPeerPolicy()},
+		readiness:         ReadinessPolicy{MaxPendingHints: DefaultReadyMaxPendingHints},
+		jobs:              jobs.NewManager(),
+		watch:             ringWatch{wake: make(chan struct{}, 1)},
+		epoch:             epochState{wake: make(chan string, 1)},
 	}
+	protocol.partition = &r.partition
+	protocol.epoch = &r.epoch
+	r.replicaTimeout.Store(int64(DefaultReplicaTimeout))
+	local.SetReplaceHook(r.releaseSuperseded)
+	return r
+}
+
+// Jobs retorna o gerenciador dos jobs em background deste nó.
+func (r *Router) Jobs() *jobs.Manager {
+	return r.jobs
 }
 
 func (r *Router) isLocal(node hashring.NodeInfo) bool {
@@ -45,172 +117,875 @@ func (r *Router) isLocal(node hashring.NodeInfo) bool {
 }
 
 type replicaPutRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Writer    string `json:"writer,omitempty"`
 }
 
 type replicaDeleteRequest struct {
-	Key string `json:"key"`
+	Key       string `json:"key"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Writer    string `json:"writer,omitempty"`
 }
 
-// Put: grava em todos os nós de réplica (replicação síncrona simples).
+// Put: grava nos nós de réplica com a consistência padrão de escrita.
 func (r *Router) Put(key, value string) error {
-	replicas := r.ring.GetReplicasForKey(key, r.replicationFactor)
-	if len(replicas) == 0 {
-		return fmt.Errorf("no replicas for key")
+	return r.PutAt(key, value, kv.Now())
+}
+
+// PutAt: igual ao Put, mas com o timestamp da escrita definido pelo chamador
+// (usado por import e rebalance para preservar a versão original).
+func (r *Router) PutAt(key, value string, ts int64) error {
+	if err := r.checkWritable(); err != nil {
+		return err
+	}
+	_, err := r.replicate(context.Background(), kv.Mutation{Op: kv.OpPut, Key: key, Value: value, Timestamp: ts}, r.WriteConsistencyFor(key))
+	return err
+}
+
+// PutWith grava exigindo o nível de consistência cl. Com ttl > 0 a chave
+// expira depois desse tempo (o instante de expiração vai igual para todas as
+// réplicas). O deadline de ctx, se houver, limita a operação inteira.
+func (r *Router) PutWith(ctx context.Context, key, value string, cl Consistency, ttl time.Duration) error {
+	if err := r.checkWritable(); err != nil {
+		return err
+	}
+	if err := r.ValidateValue(key, value); err != nil {
+		return err
+	}
+	m := kv.Mutation{Op: kv.OpPut, Key: key, Value: value, Timestamp: kv.Now()}
+	if ttl > 0 {
+		m.ExpiresAt = m.Timestamp + ttl.Microseconds()
+	}
+	if r.shouldChunk(key, value) {
+		return r.putLarge(ctx, m, cl)
+	}
+	_, err := r.replicate(ctx, m, cl)
+	return err
+}
+
+// SetWriteConsistency define a consistência padrão das escritas (PUT e DELETE).
+func (r *Router) SetWriteConsistency(cl Consistency) {
+	r.writeCL = cl
+}
+
+// WriteConsistency retorna a consistência padrão das escritas.
+func (r *Router) WriteConsistency() Consistency {
+	return r.writeCL
+}
+
+// SetReadConsistency define a consistência padrão das leituras (GET e HEAD).
+func (r *Router) SetReadConsistency(cl Consistency) {
+	r.readCL = cl
+}
+
+// ReadConsistency retorna a consistência padrão das leituras.
+func (r *Router) ReadConsistency() Consistency {
+	return r.readCL
+}
+
+// SetKeyspaceConsistency define padrões de leitura e escrita por keyspace,
+// no lugar dos do nó (um keyspace ausente do mapa usa o do nó). Chamar antes
+// de servir requisições.
+func (r *Router) SetKeyspaceConsistency(read, write map[string]Consistency) {
+	r.readCLByKeyspace, r.writeCLByKeyspace = read, write
+}
+
+// WriteConsistencyFor retorna a consistência padrão das escritas de key: a
+// do keyspace dela, se houver, senão a do nó.
+func (r *Router) WriteConsistencyFor(key string) Consistency {
+	if cl, ok := r.writeCLByKeyspace[kv.KeyspaceOf(key)]; ok {
+		return cl
+	}
+	return r.writeCL
+}
+
+// ReadConsistencyFor retorna a consistência padrão das leituras de key.
+func (r *Router) ReadConsistencyFor(key string) Consistency {
+	if cl, ok := r.readCLByKeyspace[kv.KeyspaceOf(key)]; ok {
+		return cl
+	}
+	return r.readCL
+}
+
+// ConsistencyDefaults são os padrões de consistência do nó e dos keyspaces
+// (GET /admin/consistency).
+type ConsistencyDefaults struct {
+	Read      Consistency            `json:"read"`
+	Write     Consistency            `json:"write"`
+	ReadByKS  map[string]Consistency `json:"read_by_keyspace"`
+	WriteByKS map[string]Consistency `json:"write_by_keyspace"`
+}
+
+// ConsistencyDefaults retorna os padrões de consistência.
+func (r *Router) ConsistencyDefaults() ConsistencyDefaults {
+	d := ConsistencyDefaults{Read: r.readCL, Write: r.writeCL, ReadByKS: map[string]Consistency{}, WriteByKS: map[string]Consistency{}}
+	for ks, cl := range r.readCLByKeyspace {
+		d.ReadByKS[ks] = cl
+	}
+	for ks, cl := range r.writeCLByKeyspace {
+		d.WriteByKS[ks] = cl
 	}
+	return d
+}
 
-	var errs []error
+// replicate envia a mutação (put ou delete) para todas as réplicas da chave
+// em paralelo e exige cl confirmações. Réplicas que falharam ganham um hint,
+// inclusive quando a escrita é recusada por falta de confirmações.
+// Num delete, existed diz se alguma réplica que confirmou tinha a chave.
+func (r *Router) replicate(ctx context.Context, m kv.Mutation, cl Consistency) (existed bool, err error) {
+	if err := r.gate.enter(); err != nil {
+		return false, err
+	}
+	defer r.gate.leave()
 
+	replicas := r.replicasForKey(m.Key)
+	if len(replicas) == 0 {
+		return false, fmt.Errorf("no replicas for key")
+	}
+	if m.Writer == "" {
+		m.Writer = string(r.nodeID)
+	}
+	counts, need, err := r.acksFor(ctx, cl, replicas)
+	if err != nil {
+		return false, err
+	}
+	tr := traceFrom(ctx)
+	tr.add(r.nodeID, "replicas", 0, "%s of key=%s to %v, %s needs %d acks", m.Op, m.Key, nodeIDStrings(replicas), cl, need)
+	// réplicas pendentes (mudança de topologia em andamento) também recebem
+	// a escrita, sem contar para o nível de consistência
+	if pending := r.pendingReplicas(m.Key, replicas); len(pending) > 0 {
+		tr.add(r.nodeID, "pending replicas", 0, "%v", nodeIDStrings(pending))
+		metrics.Add("pending_ranges.writes", int64(len(pending)))
+		replicas = append(replicas[:len(replicas):len(replicas)], pending...)
+		counts = append(counts[:len(counts):len(counts)], make([]bool, len(pending))...)
+	}
+	var encoded *replicaRequest
 	for _, node := range replicas {
+		if !r.isLocal(node) {
+			encoded = newReplicaRequest(m)
+			break
+		}
+	}
+	defer encoded.release()
+
+	errs := make([]error, len(replicas))
+	had := make([]bool, len(replicas))
+	var wg sync.WaitGroup
+	for i, node := range replicas {
 		if r.isLocal(node) {
-			r.localStore.Put(key, value)
+			// descartada por ser mais antiga também conta: a réplica já tem
+			// uma versão igual ou mais nova
+			if m.Op == kv.OpDelete {
+				_, had[i] = r.localStore.ApplyDelete(m)
+			} else {
+				r.localStore.Apply(m)
+			}
+			tr.add(node.ID, "applied locally", 0, "")
 			continue
 		}
+		tr.add(node.ID, "queued", 0, "")
+		wg.Add(1)
+		go func(i int, node hashring.NodeInfo) {
+			defer wg.Done()
+			tr.add(node.ID, "sent", 0, "")
+			start := time.Now()
+			had[i], errs[i] = r.sendReplicaRequest(ctx, node, encoded)
+			tr.replicaCall(node.ID, start, errs[i], "")
+		}(i, node)
+	}
+	wg.Wait()
+	// a cópia dos observadores vai mesmo se a escrita for recusada: as
+	// réplicas que falharam ganham hints e acabam recebendo a mutação
+	r.observe(m)
 
-		body, _ := json.Marshal(replicaPutRequest{Key: key, Value: value})
-		url := fmt.Sprintf("http://%s/internal/replica/put", node.Host)
-
-		resp, err := r.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
-		if err != nil {
-			errs = append(errs, fmt.Errorf("remote PUT to %s failed: %w", node.Host, err))
+	acks := 0
+	var failed []error
+	contacted := make([]hashring.NodeID, len(replicas))
+	var acked []hashring.NodeID
+	var failures []ReplicaFailure
+	for i, node := range replicas {
+		contacted[i] = node.ID
+		if errs[i] == nil {
+			if counts[i] {
+				acks++
+			}
+			acked = append(acked, node.ID)
+			existed = existed || had[i]
+			if !r.isLocal(node) {
+				r.noteUp(node)
+			}
 			continue
 		}
-		io.ReadAll(resp.Body)
-		resp.Body.Close()
+		failed = append(failed, errs[i])
+		failures = append(failures, ReplicaFailure{Node: string(node.ID), Error: errs[i].Error()})
+		metrics.Inc("replication.failures")
+		r.storeHint(node, Hint{Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt, Delete: m.Op == kv.OpDelete})
+		tr.add(node.ID, "hint stored", 0, "")
+	}
 
-		if resp.StatusCode >= 300 {
-			errs = append(errs, fmt.Errorf("remote PUT to %s status=%d", node.Host, resp.StatusCode))
+	outcome := outcomeFrom(ctx)
+	outcome.record(cl, contacted, acked, r.nodeID, acks >= need)
+	outcome.recordWrite(need, acks, failures)
+	if acks < need {
+		tr.add(r.nodeID, "write failed", 0, "%d of %d required acks", acks, need)
+		metrics.Inc("writes.unavailable")
+		return false, &WriteError{Consistency: cl, Required: need, Acks: acks, Alive: liveCount(counts, errs), Errs: failed}
+	}
+	if len(failed) > 0 {
+		log.Printf("[WRITE] %s key=%s accepted at %s with %d/%d acks: %v", m.Op, m.Key, cl, acks, len(replicas), failed)
+	}
+	tr.add(r.nodeID, "write accepted", 0, "%d acks of %d replicas (%d required)", acks, len(replicas), need)
+	return existed, nil
+}
+
+// sendMutation aplica a mutação numa réplica remota. Num delete, existed
+// diz se a réplica tinha a chave (ExistedHeader; nós antigos não informam).
+func (r *Router) sendMutation(ctx context.Context, node hashring.NodeInfo, m kv.Mutation) (existed bool, err error) {
+	rr := newReplicaRequest(m)
+	defer rr.release()
+	return r.sendReplicaRequest(ctx, node, rr)
+}
+
+// replicaRequest é uma mutação já codificada para as réplicas: o corpo é
+// montado uma vez por escrita e por codec (ver codec.go), num buffer do pool,
+// e compartilhado (só leitura) pelos envios, em vez de uma cópia do valor por
+// réplica.
+type replicaRequest struct {
+	op, path string
+	m        kv.Mutation
+
+	mu     sync.Mutex
+	bodies [len(codecs)]*bufpool.Shared
+}
+
+func newReplicaRequest(m kv.Mutation) *replicaRequest {
+	rr := &replicaRequest{op: "PUT", path: "/internal/replica/put", m: m}
+	if m.Op == kv.OpDelete {
+		rr.op, rr.path = "DELETE", "/internal/replica/delete"
+	}
+	return rr
+}
+
+// body é o corpo no codec de índice c, codificado no primeiro envio com ele.
+func (rr *replicaRequest) body(c int) (*bufpool.Shared, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.bodies[c] == nil {
+		buf := bufpool.Get()
+		if err := codecs[c].EncodeMutation(buf, rr.m); err != nil {
+			bufpool.Put(buf)
+			return nil, err
 		}
+		metrics.CountWith("internode.codec.encoded_bytes", metrics.Labels{"codec", codecs[c].Name()}, int64(buf.Len()))
+		rr.bodies[c] = bufpool.NewShared(buf)
 	}
+	return rr.bodies[c], nil
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("replication errors: %v", errs)
+// release devolve os corpos ao pool depois que os envios os fecharem.
+func (rr *replicaRequest) release() {
+	if rr == nil {
+		return
+	}
+	for _, b := range rr.bodies {
+		if b != nil {
+			b.Release()
+		}
 	}
-	return nil
 }
 
-// Get: tenta ler dos nós de réplica na ordem.
-// Retorna no primeiro nó que responder com sucesso.
+func (r *Router) sendReplicaRequest(ctx context.Context, node hashring.NodeInfo, rr *replicaRequest) (existed bool, err error) {
+	op := rr.op
+	url := fmt.Sprintf("http://%s%s", node.Host, rr.path)
+
+	c := r.protocol.codecFor(node.Host)
+	body, err := rr.body(c)
+	if err != nil {
+		return false, fmt.Errorf("remote %s to %s failed: %w", op, node.Host, err)
+	}
+	ctx, cancel := r.replicaContext(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body.Reader())
+	if err != nil {
+		return false, fmt.Errorf("remote %s to %s failed: %w", op, node.Host, err)
+	}
+	// sem GetBody: o corpo é lido uma vez só (um POST não é repetido pelo
+	// transporte)
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("Content-Type", codecs[c].ContentType())
+
+	start := time.Now()
+	resp, err := r.httpClient.Do(req)
+	r.observeLatency(node, time.Since(start), err)
+	if err != nil {
+		return false, fmt.Errorf("remote %s to %s failed: %w", op, node.Host, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusMisdirectedRequest {
+		return false, fmt.Errorf("remote %s to %s: %w (ring epoch %s at the replica)", op, node.Host, ErrStaleRingEpoch, resp.Header.Get(RingEpochHeader))
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("remote %s to %s status=%d", op, node.Host, resp.StatusCode)
+	}
+	return resp.Header.Get(ExistedHeader) == "true", nil
+}
+
+// TombstoneHeader vem no 404 de /internal/replica/get quando a réplica tem
+// um tombstone para a chave (com o timestamp do delete).
+const TombstoneHeader = "X-Tombstone"
+
+// ExistedHeader vem na resposta de /internal/replica/delete: "true" se o
+// delete apagou um valor vivo da réplica (não conta se ele perdeu para uma
+// escrita mais nova).
+const ExistedHeader = "X-Existed"
+
+// TimestampHeader e ExpiresAtHeader vêm no 200 de /internal/replica/get com
+// a versão do valor (timestamp da escrita e fim do TTL, 0 = nunca).
+// Num digest read (?digest=true) o valor não vem, só o tamanho dele em
+// ValueLengthHeader e os metadados (kv.ValueMeta, em JSON) em MetaHeader.
+const (
+	TimestampHeader   = "X-Timestamp"
+	ExpiresAtHeader   = "X-Expires-At"
+	ValueLengthHeader = "X-Value-Length"
+	MetaHeader        = "X-Value-Meta"
+)
+
+// Get: tenta ler dos nós de réplica, a local primeiro e depois da mais
+// rápida para a mais lenta (ver latency.go). Retorna no primeiro nó que
+// responder com sucesso (ou que tiver um tombstone da chave).
 func (r *Router) Get(key string) (string, bool, error) {
-	replicas := r.ring.GetReplicasForKey(key, r.replicationFactor)
+	e, ok, err := r.GetEntry(context.Background(), key)
+	return e.Value, ok, err
+}
+
+// GetEntry é o Get com a versão lida: o valor vem com o timestamp da escrita
+// (0 se a réplica que respondeu não informar) e o ExpiresAt. O deadline de
+// ctx, se houver, vale para todas as réplicas tentadas.
+func (r *Router) GetEntry(ctx context.Context, key string) (kv.Entry, bool, error) {
+	return r.GetWith(ctx, key, r.ReadConsistencyFor(key))
+}
+
+// GetWith lê exigindo o nível de consistência cl: com ONE responde a
+// primeira réplica que tiver a chave; nos outros níveis, a versão mais nova
+// entre as réplicas exigidas. Um objeto grande volta inteiro (os chunks são
+// lidos com o mesmo cl).
+func (r *Router) GetWith(ctx context.Context, key string, cl Consistency) (kv.Entry, bool, error) {
+	e, _, ok, err := r.read(ctx, key, false, cl)
+	if err != nil || !ok {
+		return e, ok, err
+	}
+	if man, large := parseManifest(e.Value); large {
+		e, err = r.readLarge(ctx, key, e, man, cl)
+		return e, err == nil, err
+	}
+	return e, true, nil
+}
+
+// Digest é a versão de uma chave sem o valor (HEAD /kv/{key}).
+type Digest struct {
+	Length    int
+	Timestamp int64
+	ExpiresAt int64
+	Meta      kv.ValueMeta
+}
+
+// GetDigest diz se a chave existe e retorna o tamanho e a versão do valor
+// sem transferi-lo: as réplicas remotas respondem só os headers (digest read).
+func (r *Router) GetDigest(ctx context.Context, key string, cl Consistency) (Digest, bool, error) {
+	e, length, ok, err := r.read(ctx, key, true, cl)
+	_, meta := kv.SplitMeta(e.Value)
+	return Digest{Length: length, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt, Meta: meta}, ok, err
+}
+
+// read lê a chave das réplicas exigindo cl. Com digest, as réplicas remotas
+// não mandam o valor (Value fica vazio), só seu tamanho. Se o prazo de ctx
+// acabar antes de alguma réplica responder, retorna o erro do contexto.
+func (r *Router) read(ctx context.Context, key string, digest bool, cl Consistency) (kv.Entry, int, bool, error) {
+	if cl == One && r.observers.self {
+		return r.readObserved(ctx, key, digest)
+	}
+	replicas := r.replicasForKey(key)
 	if len(replicas) == 0 {
-		return "", false, fmt.Errorf("no replicas for key")
+		return kv.Entry{}, 0, false, fmt.Errorf("no replicas for key")
+	}
+	r.maybeReadRepair(key, replicas)
+	tr := traceFrom(ctx)
+	if cl != One {
+		return r.readQuorum(ctx, key, digest, cl, replicas)
 	}
 
-	for _, node := range replicas {
-		if r.isLocal(node) {
-			val, ok := r.localStore.Get(key)
-			if ok {
-				return val, true, nil
-			}
+	order := r.orderForRead(replicas)
+	tr.add(r.nodeID, "replicas", 0, "read of key=%s at ONE, trying %v in order", key, nodeIDStrings(order))
+	outcome := outcomeFrom(ctx)
+	var contacted, responded []hashring.NodeID
+	var failed, errs []error
+	for _, node := range order {
+		rr, err := r.tracedReadReplica(ctx, node, key, digest)
+		contacted = append(contacted, node.ID)
+		errs = append(errs, err)
+		if err == nil {
+			responded = append(responded, node.ID)
+		} else {
+			failed = append(failed, err)
+		}
+		if err != nil || rr.missing() {
+			// falha ou não tem nesse nó: tenta o próximo
 			continue
 		}
+		outcome.record(cl, contacted, responded, node.ID, true)
+		outcome.recordRead(r.isLocal(node))
+		if rr.tombstone {
+			// a chave foi apagada, não procura nas outras réplicas
+			return kv.Entry{}, 0, false, nil
+		}
+		return rr.entry, rr.length, true, nil
+	}
+	outcome.record(cl, contacted, responded, "", len(responded) > 0)
 
-		// GET interno: lê direto do store do nó alvo
-		baseURL := fmt.Sprintf("http://%s/internal/replica/get", node.Host)
-		reqURL, err := url.Parse(baseURL)
-		if err != nil {
-			continue
+	if err := ctx.Err(); err != nil {
+		return kv.Entry{}, 0, false, fmt.Errorf("read key=%s: %w", key, err)
+	}
+	if len(responded) == 0 {
+		// nenhuma réplica respondeu: não dá para dizer que a chave não existe
+		tr.add(r.nodeID, "read failed", 0, "no replica responded")
+		metrics.Inc("reads.unavailable")
+		counts := make([]bool, len(errs))
+		for i := range counts {
+			counts[i] = true
 		}
-		q := reqURL.Query()
-		q.Set("key", key)
-		reqURL.RawQuery = q.Encode()
-		resp, err := r.httpClient.Get(reqURL.String())
+		return kv.Entry{}, 0, false, &ReadError{Consistency: cl, Required: 1, Alive: liveCount(counts, errs), Errs: failed}
+	}
+	// se nenhum tiver a chave
+	return kv.Entry{}, 0, false, nil
+}
+
+// replicaRead é a resposta de uma réplica numa leitura.
+type replicaRead struct {
+	entry     kv.Entry
+	length    int
+	found     bool
+	tombstone bool // entry.Timestamp é o do delete
+}
+
+func (rr replicaRead) missing() bool {
+	return !rr.found && !rr.tombstone
+}
+
+// newer diz se rr é uma versão mais nova que o atual (um tombstone ganha de
+// um valor com o mesmo timestamp, como no store).
+func (rr replicaRead) newer(cur replicaRead) bool {
+	if cur.missing() {
+		return !rr.missing()
+	}
+	if rr.missing() {
+		return false
+	}
+	if rr.entry.Timestamp != cur.entry.Timestamp {
+		return rr.entry.Timestamp > cur.entry.Timestamp
+	}
+	return rr.tombstone && !cur.tombstone
+}
+
+// describe resume a resposta para o trace.
+func (rr replicaRead) describe() string {
+	switch {
+	case rr.tombstone:
+		return fmt.Sprintf("tombstone ts=%d", rr.entry.Timestamp)
+	case rr.found:
+		return fmt.Sprintf("value ts=%d (%d bytes)", rr.entry.Timestamp, rr.length)
+	default:
+		return "not found"
+	}
+}
+
+// tracedReadReplica é o readReplica com os eventos do trace da requisição.
+func (r *Router) tracedReadReplica(ctx context.Context, node hashring.NodeInfo, key string, digest bool) (replicaRead, error) {
+	tr := traceFrom(ctx)
+	if tr == nil {
+		return r.readReplica(ctx, node, key, digest)
+	}
+	start := time.Now()
+	if r.isLocal(node) {
+		rr, err := r.readReplica(ctx, node, key, digest)
 		if err != nil {
-			// falha de rede: tenta próximo nó
-			continue
+			tr.add(node.ID, "local read failed", time.Since(start), "%v", err)
+		} else {
+			tr.add(node.ID, "read locally", time.Since(start), "%s", rr.describe())
 		}
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		return rr, err
+	}
+	tr.add(node.ID, "sent", 0, "")
+	rr, err := r.readReplica(ctx, node, key, digest)
+	tr.replicaCall(node.ID, start, err, "%s", rr.describe())
+	return rr, err
+}
 
-		if resp.StatusCode == http.StatusNotFound {
-			// não tem nesse nó, tenta o próximo
-			continue
+// readReplica lê a versão da chave em uma réplica (local ou remota). Se a
+// cópia local falhar no checksum, serve e restaura a das outras réplicas.
+func (r *Router) readReplica(ctx context.Context, node hashring.NodeInfo, key string, digest bool) (replicaRead, error) {
+	if r.isLocal(node) {
+		e, ok, err := r.localStore.CheckedVersion(key)
+		if errors.Is(err, kv.ErrCorrupt) {
+			rr, err := r.RecoverCorrupt(ctx, key)
+			if err != nil {
+				return replicaRead{}, err
+			}
+			if digest {
+				_, meta := kv.SplitMeta(rr.entry.Value)
+				rr.entry.Value = kv.WithMeta("", meta)
+			}
+			return rr, nil
 		}
-		if resp.StatusCode >= 300 {
-			// erro genérico, tenta próximo
-			continue
+		if !ok {
+			return replicaRead{}, nil
+		}
+		if e.Deleted {
+			return replicaRead{entry: kv.Entry{Timestamp: e.Timestamp}, tombstone: true}, nil
 		}
+		return replicaRead{entry: e, length: ValueLength(e.Value), found: true}, nil
+	}
 
-		return string(body), true, nil
+	// GET interno: lê direto do store do nó alvo
+	reqURL, err := url.Parse(fmt.Sprintf("http://%s/internal/replica/get", node.Host))
+	if err != nil {
+		return replicaRead{}, err
+	}
+	q := reqURL.Query()
+	q.Set("key", key)
+	if digest {
+		q.Set("digest", "true")
+	}
+	reqURL.RawQuery = q.Encode()
+	start := time.Now()
+	resp, body, err := r.replicaGet(ctx, reqURL.String())
+	r.observeLatency(node, time.Since(start), err)
+	if err != nil {
+		return replicaRead{}, err
 	}
 
-	// se nenhum tiver a chave
-	return "", false, nil
+	if resp.StatusCode == http.StatusNotFound {
+		if ts := resp.Header.Get(TombstoneHeader); ts != "" {
+			rr := replicaRead{tombstone: true}
+			rr.entry.Timestamp, _ = strconv.ParseInt(ts, 10, 64)
+			return rr, nil
+		}
+		return replicaRead{}, nil
+	}
+	if resp.Header.Get(CorruptHeader) == "true" {
+		return replicaRead{}, fmt.Errorf("remote GET to %s: %w", node.Host, kv.ErrCorrupt)
+	}
+	if resp.StatusCode >= 300 {
+		return replicaRead{}, fmt.Errorf("remote GET to %s status=%d", node.Host, resp.StatusCode)
+	}
+
+	e := kv.Entry{Value: body}
+	e.Timestamp, _ = strconv.ParseInt(resp.Header.Get(TimestampHeader), 10, 64)
+	e.ExpiresAt, _ = strconv.ParseInt(resp.Header.Get(ExpiresAtHeader), 10, 64)
+	length := ValueLength(body)
+	if digest {
+		// o valor fica só com o envelope dos metadados
+		var meta kv.ValueMeta
+		if n, err := strconv.Atoi(resp.Header.Get(ValueLengthHeader)); err == nil {
+			length = n
+			if h := resp.Header.Get(MetaHeader); h != "" {
+				json.Unmarshal([]byte(h), &meta)
+			}
+		} else {
+			// réplica sem digest read: mandou o valor inteiro
+			_, meta = kv.SplitMeta(body)
+		}
+		e.Value = kv.WithMeta("", meta)
+	}
+	return replicaRead{entry: e, length: length, found: true}, nil
 }
 
-// Delete: envia DELETE para todos os nós de réplica.
-func (r *Router) Delete(key string) error {
-	replicas := r.ring.GetReplicasForKey(key, r.replicationFactor)
-	if len(replicas) == 0 {
-		return fmt.Errorf("no replicas for key")
+// readQuorum consulta as réplicas em paralelo até ter as respostas exigidas
+// por cl e retorna a versão mais nova entre as recebidas (um tombstone mais
+// novo vira "não encontrada"), ou o merge delas se o keyspace tiver uma
+// estratégia de merge (ver merge.go; não vale em digest reads).
+func (r *Router) readQuorum(ctx context.Context, key string, digest bool, cl Consistency, replicas []hashring.NodeInfo) (kv.Entry, int, bool, error) {
+	newest, reads, err := r.replicaVersions(ctx, key, digest, cl, replicas)
+	if err != nil || !newest.found {
+		return kv.Entry{}, 0, false, err
+	}
+	if !digest {
+		if e, ok := r.mergeVersions(ctx, key, newest, reads); ok {
+			return e, len(e.Value), true, nil
+		}
 	}
+	return newest.entry, newest.length, true, nil
+}
 
-	var errs []error
+// newestVersion é a versão mais nova (valor, tombstone ou nenhuma) entre as
+// respostas exigidas por cl.
+func (r *Router) newestVersion(ctx context.Context, key string, digest bool, cl Consistency, replicas []hashring.NodeInfo) (replicaRead, error) {
+	newest, _, err := r.replicaVersions(ctx, key, digest, cl, replicas)
+	return newest, err
+}
 
-	for _, node := range replicas {
-		if r.isLocal(node) {
-			r.localStore.Delete(key)
-			continue
-		}
+// replicaVersions é o newestVersion com todas as respostas recebidas.
+func (r *Router) replicaVersions(ctx context.Context, key string, digest bool, cl Consistency, replicas []hashring.NodeInfo) (replicaRead, []replicaRead, error) {
+	counts, need, err := r.acksFor(ctx, cl, replicas)
+	if err != nil {
+		return replicaRead{}, nil, err
+	}
+	tr := traceFrom(ctx)
+	tr.add(r.nodeID, "replicas", 0, "read of key=%s from %v, %s needs %d responses", key, nodeIDStrings(replicas), cl, need)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		body, _ := json.Marshal(replicaDeleteRequest{Key: key})
-		url := fmt.Sprintf("http://%s/internal/replica/delete", node.Host)
+	type result struct {
+		i   int
+		rr  replicaRead
+		err error
+	}
+	results := make(chan result, len(replicas))
+	for i, node := range replicas {
+		go func(i int, node hashring.NodeInfo) {
+			rr, err := r.tracedReadReplica(ctx, node, key, digest)
+			results <- result{i, rr, err}
+		}(i, node)
+	}
 
-		resp, err := r.httpClient.Post(url, "application/json", bytes.NewBuffer(body))
-		if err != nil {
-			errs = append(errs, fmt.Errorf("remote DELETE to %s failed: %w", node.Host, err))
+	var newest replicaRead
+	var reads []replicaRead
+	var failed []error
+	errs := make([]error, len(replicas))
+	var responded []hashring.NodeID
+	var servedBy hashring.NodeID
+	responses := 0
+	for range replicas {
+		res := <-results
+		if res.err != nil {
+			failed = append(failed, res.err)
+			errs[res.i] = res.err
 			continue
 		}
-		io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode >= 300 {
-			errs = append(errs, fmt.Errorf("remote DELETE to %s status=%d", node.Host, resp.StatusCode))
+		reads = append(reads, res.rr)
+		responded = append(responded, replicas[res.i].ID)
+		if res.rr.newer(newest) {
+			newest = res.rr
+			servedBy = replicas[res.i].ID
+		}
+		if counts[res.i] {
+			responses++
+			if responses >= need {
+				break
+			}
 		}
 	}
+	contacted := make([]hashring.NodeID, len(replicas))
+	for i, n := range replicas {
+		contacted[i] = n.ID
+	}
+	outcomeFrom(ctx).record(cl, contacted, responded, servedBy, responses >= need)
+	outcomeFrom(ctx).recordRead(servedBy == r.nodeID)
+	if responses < need {
+		tr.add(r.nodeID, "read failed", 0, "%d of %d required responses", responses, need)
+		metrics.Inc("reads.unavailable")
+		return replicaRead{}, nil, &ReadError{Consistency: cl, Required: need, Responses: responses, Alive: liveCount(counts, errs), Errs: failed}
+	}
+	tr.add(r.nodeID, "read complete", 0, "%d responses, newest: %s", len(reads), newest.describe())
+	return newest, reads, nil
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("delete replication errors: %v", errs)
+// replicaGet faz o GET de uma leitura de réplica, com o prazo de
+// replicaContext; o corpo é lido antes de o contexto ser cancelado, direto
+// para a string do valor (ver readString).
+func (r *Router) replicaGet(ctx context.Context, url string) (*http.Response, string, error) {
+	ctx, cancel := r.replicaContext(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
 	}
-	return nil
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := readString(resp.Body, resp.ContentLength)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, body, nil
+}
+
+// readString lê rd inteiro para uma string, alocando size bytes de uma vez
+// quando o tamanho é conhecido (size < 0: desconhecido), sem o []byte
+// intermediário do io.ReadAll e a cópia da conversão.
+func readString(rd io.Reader, size int64) (string, error) {
+	var sb strings.Builder
+	if size > 0 {
+		sb.Grow(int(size))
+	}
+	_, err := io.Copy(&sb, rd)
+	return sb.String(), err
+}
+
+// Delete: grava um tombstone nas réplicas, com o mesmo timestamp em todas
+// e a mesma exigência de confirmações do Put. existed diz se alguma das
+// réplicas que confirmaram tinha a chave (o tombstone é gravado de qualquer
+// jeito, para o caso de uma réplica fora do ar ter uma versão).
+func (r *Router) Delete(key string) (existed bool, err error) {
+	return r.DeleteWith(context.Background(), key, r.WriteConsistencyFor(key))
+}
+
+// DeleteWith apaga exigindo o nível de consistência cl.
+func (r *Router) DeleteWith(ctx context.Context, key string, cl Consistency) (existed bool, err error) {
+	if err := r.checkWritable(); err != nil {
+		return false, err
+	}
+	return r.replicate(ctx, kv.Mutation{Op: kv.OpDelete, Key: key, Timestamp: kv.Now()}, cl)
 }
 
 // 🔥 Rebalanceia todas as chaves locais com base no ring atual.
 // Ideia: para cada key local, checar se este nó ainda é uma réplica;
 // se não for, envia para os novos donos e remove localmente.
 func (r *Router) RebalanceLocalKeys(ctx context.Context) error {
+	return r.rebalanceLocalKeys(ctx, nil)
+}
+
+// rebalanceKind é o tipo dos jobs de rebalance em /admin/jobs.
+const rebalanceKind = "rebalance"
+
+// RebalanceDetail é o progresso de um rebalance além das chaves examinadas
+// (done) e dos bytes enviados do job.
+type RebalanceDetail struct {
+	Examined int64 `json:"examined"`
+	Kept     int64 `json:"kept"`
+	Moved    int64 `json:"moved"`
+	Failed   int64 `json:"failed"`
+	// envios a réplicas: feitos e evitados porque a réplica já tinha a versão
+	Sends        int64 `json:"replica_sends"`
+	SendsSkipped int64 `json:"replica_sends_skipped"`
+}
+
+// StartRebalance roda o RebalanceLocalKeys como job em background (ou
+// retorna o que já está rodando).
+func (r *Router) StartRebalance(timeout time.Duration) *jobs.Job {
+	if running, ok := r.jobs.Running(rebalanceKind); ok {
+		return running
+	}
+	return r.jobs.Start(rebalanceKind, "keys", func(ctx context.Context, job *jobs.Job) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return r.rebalanceLocalKeys(ctx, job)
+	})
+}
+
+// Rebalance retorna o rebalance em andamento ou, sem nenhum, o último.
+func (r *Router) Rebalance() (jobs.Info, bool) {
+	if running, ok := r.jobs.Running(rebalanceKind); ok {
+		return running.Info(), true
+	}
+	if list := r.jobs.List(rebalanceKind); len(list) > 0 {
+		return list[0], true
+	}
+	return jobs.Info{}, false
+}
+
+// CancelRebalance cancela o rebalance em andamento: as chaves já movidas
+// ficam nos novos donos e o resto continua aqui até o próximo.
+func (r *Router) CancelRebalance() (jobs.Info, error) {
+	running, ok := r.jobs.Running(rebalanceKind)
+	if !ok {
+		return jobs.Info{}, ErrNoRebalance
+	}
+	if err := r.jobs.Cancel(running.ID()); err != nil {
+		return jobs.Info{}, err
+	}
+	return running.Info(), nil
+}
+
+// ErrNoRebalance: não há rebalance em andamento para cancelar.
+var ErrNoRebalance = errors.New("no rebalance running")
+
+func (r *Router) rebalanceLocalKeys(ctx context.Context, job *jobs.Job) error {
+	if r.observers.self {
+		// o observador guarda cópias de chaves das quais não é réplica:
+		// não há nada a mandar para os donos
+		log.Printf("[REBALANCE] observer node, nothing to rebalance")
+		return nil
+	}
 	log.Printf("[REBALANCE] Starting rebalance for node=%s", r.nodeID)
 
-	keys := r.localStore.Keys()
-	moved := 0
-	kept := 0
+	// tombstones também mudam de dono, senão o delete se perde
+	var d RebalanceDetail
+	job.SetDetail(d)
+	job.SetTotal(int64(r.localStore.Len() + r.localStore.Tombstones()))
 
-	for _, key := range keys {
-		select {
-		case <-ctx.Done():
-			log.Printf("[REBALANCE] cancelled")
-			return ctx.Err()
-		default:
+	// as chaves que saem deste nó vão para os novos donos em lotes (RPC
+	// multi-chave) e só são removidas daqui depois de aceitas. Cada lote só
+	// vai às réplicas novas que ainda não têm a versão (as que já eram
+	// réplicas no ring antigo, em geral, já têm)
+	var pending []kv.Mutation
+	flush := func() {
+		if len(pending) == 0 {
+			return
 		}
+		held := r.heldVersions(ctx, pending)
+		var sent, skipped, bytes int64
+		skip := func(i int, node hashring.NodeID) bool {
+			if ts, ok := held[node][pending[i].Key]; ok && ts >= pending[i].Timestamp {
+				skipped++
+				return true
+			}
+			sent++
+			bytes += int64(len(pending[i].Key) + len(pending[i].Value))
+			return false
+		}
+		// cada chave com a consistência de escrita do keyspace dela
+		results := r.byWriteConsistency(pending, func(sub []kv.Mutation, idx []int, cl Consistency) []BatchResult {
+			return r.replicateBatchSkip(ctx, sub, cl, func(j int, node hashring.NodeID) bool {
+				return skip(idx[j], node)
+			})
+		})
+		metrics.Add("rebalance.sends", sent)
+		metrics.Add("rebalance.sends_skipped", skipped)
+		d.Sends += sent
+		d.SendsSkipped += skipped
+		job.Add(0, bytes)
+		for i, res := range results {
+			if res.Err != nil {
+				log.Printf("[REBALANCE] failed to move key=%s: %v", pending[i].Key, res.Err)
+				// por segurança, não apagar local em caso de erro
+				d.Failed++
+				continue
+			}
+			// agora pode remover local (sem deixar tombstone)
+			r.localStore.Purge(pending[i].Key)
+			d.Moved++
+		}
+		pending = pending[:0]
+		job.SetDetail(d)
+	}
 
-		// valor atual
-		val, ok := r.localStore.Get(key)
-		if !ok {
-			continue
+	r.localStore.IterateVersions("", "", func(key string, entry kv.Entry) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		job.Add(1, 0)
+		if d.Examined++; d.Examined%1000 == 0 {
+			job.SetDetail(d)
 		}
 
 		// quem são as réplicas para essa chave no ring novo?
-		replicas := r.ring.GetReplicasForKey(key, r.replicationFactor)
+		replicas := r.replicasForKey(key)
 		if len(replicas) == 0 {
 			// ring vazio? estranho, mas não mexe
-			kept++
-			continue
+			d.Kept++
+			return true
 		}
 
-		// este nó ainda está na lista de réplicas?
-		stillReplica := false
+		// este nó ainda está na lista de réplicas (ou vai estar, numa
+		// mudança de topologia em andamento)?
+		stillReplica := r.isPendingReplica(key)
 		for _, n := range replicas {
 			if r.isLocal(n) {
 				stillReplica = true
@@ -219,23 +994,71 @@ func (r *Router) RebalanceLocalKeys(ctx context.Context) error {
 		}
 
 		if stillReplica {
-			kept++
-			continue
+			d.Kept++
+			return true
 		}
 
 		// 👉 este nó NÃO deveria mais guardar essa chave
-		// então manda pro cluster (Router.Put já grava nos novos donos)
-		if err := r.Put(key, val); err != nil {
-			log.Printf("[REBALANCE] failed to move key=%s: %v", key, err)
-			// por segurança, não apagar local em caso de erro
-			continue
+		// então manda pro cluster (replicateBatch já grava nos novos donos)
+		m := kv.Mutation{Op: kv.OpPut, Key: key, Value: entry.Value, Timestamp: entry.Timestamp, ExpiresAt: entry.ExpiresAt}
+		if entry.Deleted {
+			m.Op = kv.OpDelete
 		}
-
-		// agora pode remover local
-		r.localStore.Delete(key)
-		moved++
+		pending = append(pending, m)
+		if len(pending) >= ReplicaBatchMaxItems {
+			flush()
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		job.SetDetail(d)
+		log.Printf("[REBALANCE] cancelled after %d keys: moved=%d kept=%d failed=%d", d.Examined, d.Moved, d.Kept, d.Failed)
+		return err
 	}
+	flush()
 
-	log.Printf("[REBALANCE] finished for node=%s: moved=%d kept=%d", r.nodeID, moved, kept)
+	log.Printf("[REBALANCE] finished for node=%s: moved=%d kept=%d failed=%d", r.nodeID, d.Moved, d.Kept, d.Failed)
 	return nil
 }
+
+// heldVersions pergunta a cada réplica remota das mutações de ms (uma
+// chamada por nó) a versão que ela já tem de cada chave. Um nó que não
+// responde (ou antigo, sem POST /internal/repair/versions) fica de fora e
+// recebe tudo, como antes.
+func (r *Router) heldVersions(ctx context.Context, ms []kv.Mutation) map[hashring.NodeID]map[string]int64 {
+	keysByNode := make(map[hashring.NodeID][]string)
+	nodes := make(map[hashring.NodeID]hashring.NodeInfo)
+	for _, m := range ms {
+		for _, node := range r.replicasForKey(m.Key) {
+			if r.isLocal(node) {
+				continue
+			}
+			nodes[node.ID] = node
+			keysByNode[node.ID] = append(keysByNode[node.ID], m.Key)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	held := make(map[hashring.NodeID]map[string]int64, len(nodes))
+	for id, node := range nodes {
+		wg.Add(1)
+		go func(node hashring.NodeInfo, keys []string) {
+			defer wg.Done()
+			versions, err := r.keyVersionsFrom(ctx, node, keys)
+			if err != nil {
+				metrics.Inc("rebalance.version_check_failures")
+				return
+			}
+			have := make(map[string]int64, len(versions))
+			for _, v := range versions {
+				have[v.Key] = v.Timestamp
+			}
+			mu.Lock()
+			held[node.ID] = have
+			mu.Unlock()
+		}(node, keysByNode[id])
+	}
+	wg.Wait()
+	return held
+}
@@ -0,0 +1,191 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/kv"
+)
+
+func TestMain(m *testing.M) {
+	// o router loga cada falha de réplica
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakePeer é uma réplica remota mínima: responde o handshake e aplica os
+// lotes de /internal/replica/batch em store.
+func fakePeer(t *testing.T, id string, store *kv.Store) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HandshakePath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Handshake{NodeID: id, ClusterName: DefaultClusterName, ProtocolVersion: ProtocolVersion, MinProtocolVersion: MinProtocolVersion})
+	})
+	mux.HandleFunc("/internal/repair/versions", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	mux.HandleFunc(ReplicaBatchPath, func(w http.ResponseWriter, r *http.Request) {
+		var ms []kv.Mutation
+		if err := json.NewDecoder(r.Body).Decode(&ms); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, m := range ms {
+			store.Apply(m)
+		}
+		json.NewEncoder(w).Encode(ReplicaBatchResult{Applied: len(ms)})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestRebalanceUsesKeyspaceConsistency: o rebalance move cada chave com a
+// consistência de escrita do keyspace dela. Com uma das duas réplicas novas
+// fora do ar, as chaves de um keyspace em ONE saem deste nó e as de um em
+// ALL ficam (a escrita não atingiu o nível).
+func TestRebalanceUsesKeyspaceConsistency(t *testing.T) {
+	peer := kv.NewStore()
+	up := fakePeer(t, "node2", peer)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// node1 saiu do ring: todas as chaves locais vão para node2 e node3
+	ring := hashring.New([]hashring.NodeInfo{
+		{ID: "node2", Host: strings.TrimPrefix(up.URL, "http://")},
+		{ID: "node3", Host: strings.TrimPrefix(down.URL, "http://")},
+	})
+	local := kv.NewStore()
+	r := NewRouter(local, "node1", "node1", ring, 2)
+	r.SetWriteConsistency(Quorum)
+	r.SetKeyspaceConsistency(nil, map[string]Consistency{"fast": One, "safe": All})
+	for i := 0; i < 20; i++ {
+		local.PutAt(fmt.Sprintf("fast:%d", i), "v", 100)
+		local.PutAt(fmt.Sprintf("safe:%d", i), "v", 100)
+	}
+
+	if err := r.RebalanceLocalKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		fast, safe := fmt.Sprintf("fast:%d", i), fmt.Sprintf("safe:%d", i)
+		if _, ok := local.Version(fast); ok {
+			t.Errorf("%s still on node1: ONE should accept the move with node2 alone", fast)
+		}
+		if _, ok := peer.GetEntry(fast); !ok {
+			t.Errorf("%s missing on node2", fast)
+		}
+		if _, ok := local.GetEntry(safe); !ok {
+			t.Errorf("%s left node1: ALL can't be met with node3 down", safe)
+		}
+	}
+}
+
+// BenchmarkReplicaRequestBody mede a codificação de uma escrita para as
+// réplicas (newReplicaRequest + body + release, como no replicate), em cada
+// codec.
+func BenchmarkReplicaRequestBody(b *testing.B) {
+	for c, codec := range codecs {
+		for _, size := range bufpool.BenchSizes {
+			m := kv.Mutation{Op: kv.OpPut, Key: "user:42", Value: strings.Repeat("x", size), Timestamp: kv.Now(), Writer: "node1"}
+			b.Run(fmt.Sprintf("%s/%dB", codec.Name(), size), func(b *testing.B) {
+				bufpool.WithPooling(b, func(b *testing.B) {
+					b.ReportAllocs()
+					b.SetBytes(int64(size))
+					for i := 0; i < b.N; i++ {
+						rr := newReplicaRequest(m)
+						body, err := rr.body(c)
+						if err != nil {
+							b.Fatal(err)
+						}
+						// o envio abre e fecha um leitor por réplica
+						body.Reader().Close()
+						rr.release()
+					}
+				})
+			})
+		}
+	}
+}
+
+// largeRouter é um router de um nó só com objetos grandes acima de 1KB, em
+// chunks de 256 bytes.
+func largeRouter() *Router {
+	ring := hashring.New([]hashring.NodeInfo{{ID: "node1", Host: "node1"}})
+	r := NewRouter(kv.NewStore(), "node1", "node1", ring, 1)
+	r.SetLargeObjects(LargeObjectConfig{Threshold: 1 << 10, ChunkSize: 256})
+	return r
+}
+
+// checkLarge confere que key guarda um manifesto (o valor está em chunks) e
+// que a leitura devolve want inteiro.
+func checkLarge(t *testing.T, r *Router, key, want string) {
+	t.Helper()
+	e, ok := r.localStore.GetEntry(key)
+	if !ok {
+		t.Fatalf("%s not found", key)
+	}
+	man, large := parseManifest(e.Value)
+	if !large {
+		t.Fatalf("%s holds a %d byte value, want a manifest", key, len(e.Value))
+	}
+	if man.Chunks != (len(want)+255)/256 {
+		t.Errorf("%s: %d chunks, want %d", key, man.Chunks, (len(want)+255)/256)
+	}
+	got, ok, err := r.GetWith(context.Background(), key, One)
+	if err != nil || !ok || got.Value != want {
+		t.Fatalf("%s: read %d bytes (found=%v, err=%v), want %d", key, len(got.Value), ok, err, len(want))
+	}
+}
+
+func TestGetSetChunksLargeValue(t *testing.T) {
+	r := largeRouter()
+	big := strings.Repeat("g", 3000)
+	if _, err := r.GetSet(context.Background(), "user:1", big, Quorum, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkLarge(t, r, "user:1", big)
+}
+
+func TestCopyAndRenameChunkLargeValue(t *testing.T) {
+	r := largeRouter()
+	ctx := context.Background()
+	big := strings.Repeat("c", 3000)
+	if err := r.PutWith(ctx, "user:src", big, Quorum, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Copy(ctx, "user:src", "user:copy", Quorum, false); err != nil {
+		t.Fatal(err)
+	}
+	checkLarge(t, r, "user:copy", big)
+	if _, err := r.Rename(ctx, "user:src", "user:moved", Quorum, false); err != nil {
+		t.Fatal(err)
+	}
+	checkLarge(t, r, "user:moved", big)
+	if _, ok := r.localStore.GetEntry("user:src"); ok {
+		t.Error("user:src still exists after the rename")
+	}
+}
+
+func TestPutBatchChunksLargeValue(t *testing.T) {
+	r := largeRouter()
+	big := strings.Repeat("b", 3000)
+	for i, res := range r.PutBatch([]Record{{Key: "user:big", Value: big}, {Key: "user:small", Value: "v"}}) {
+		if res.Err != nil {
+			t.Fatalf("record %d: %v", i, res.Err)
+		}
+	}
+	checkLarge(t, r, "user:big", big)
+	if e, _ := r.localStore.GetEntry("user:small"); e.Value != "v" {
+		t.Errorf("user:small = %q, want %q", e.Value, "v")
+	}
+}
@@ -0,0 +1,307 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// ScanPath é o endpoint interno que devolve, em NDJSON, as chaves de um
+// conjunto de intervalos de tokens (o lado da réplica do scan do cluster).
+const ScanPath = "/internal/scan"
+
+// Paralelismo do scan do cluster: quantos nós são lidos ao mesmo tempo.
+const (
+	DefaultScanParallelism = 4
+	MaxScanParallelism     = 32
+)
+
+// ScanRequest é o corpo de POST /internal/scan.
+type ScanRequest struct {
+	Prefix string                `json:"prefix,omitempty"`
+	Ranges []hashring.TokenRange `json:"ranges"`
+}
+
+// ScanOptions são os parâmetros de um scan do cluster.
+type ScanOptions struct {
+	// Prefix filtra as chaves (vazio = todas)
+	Prefix string
+	// Limit para o scan depois de tantas chaves (0 = sem limite)
+	Limit int
+	// Parallelism: nós lidos ao mesmo tempo (0 = DefaultScanParallelism)
+	Parallelism int
+}
+
+// ScanRangeFailure é um intervalo que nenhuma réplica conseguiu entregar.
+type ScanRangeFailure struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+	Error string `json:"error"`
+}
+
+// ScanStats é o resumo de um scan do cluster.
+type ScanStats struct {
+	Keys   int `json:"keys"`
+	Ranges int `json:"ranges"`
+	// Requests: leituras de réplica feitas (uma por nó, mais as repetições)
+	Requests int `json:"requests"`
+	// Retried: intervalos lidos de outra réplica depois de uma falha
+	Retried int `json:"retried"`
+	// Duplicates: chaves recebidas de novo (de uma repetição depois de uma
+	// falha no meio, ou de um intervalo que mudou de dono) e descartadas
+	Duplicates int `json:"duplicates"`
+	// Limited: o scan parou em Limit
+	Limited bool               `json:"limited,omitempty"`
+	Failed  []ScanRangeFailure `json:"failed"`
+}
+
+// Scan percorre o cluster inteiro e chama emit para cada chave viva (sem
+// tombstones nem expiradas), uma vez só. Cada nó recebe um pedido só, com
+// todos os intervalos dos quais é réplica primária, lido em streaming (ver
+// fanOutRanges). Se um nó falhar (antes ou no meio), os intervalos dele são
+// pedidos à réplica seguinte; as chaves que já tinham chegado são
+// descartadas na repetição. Um intervalo sem nenhuma réplica que responda
+// entra em Failed e o scan continua (resultado parcial). A ordem das chaves
+// não é definida; emit nunca é chamado por duas leituras ao mesmo tempo. Um
+// erro de emit (cliente foi embora) para tudo.
+func (r *Router) Scan(ctx context.Context, opts ScanOptions, emit func(Record) error) (ScanStats, error) {
+	defer metrics.Since("scan.cluster", time.Now())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		stats   ScanStats
+		stopErr error
+		seen    = make(map[string]struct{})
+	)
+	fo, err := r.fanOutRanges(ctx, opts.Parallelism, func(ctx context.Context, node hashring.NodeInfo, ranges []hashring.TokenRange) error {
+		return r.scanFrom(ctx, node, ScanRequest{Prefix: opts.Prefix, Ranges: ranges}, func(rec Record) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, dup := seen[rec.Key]; dup {
+				stats.Duplicates++
+				return nil
+			}
+			seen[rec.Key] = struct{}{}
+			if err := emit(rec); err != nil {
+				stopErr = err
+				cancel()
+				return err
+			}
+			stats.Keys++
+			if opts.Limit > 0 && stats.Keys >= opts.Limit {
+				stats.Limited = true
+				cancel()
+				return context.Canceled
+			}
+			return nil
+		})
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	stats.Ranges, stats.Requests, stats.Retried, stats.Failed = fo.ranges, fo.requests, fo.retried, fo.failed
+	switch {
+	case stopErr != nil:
+		return stats, stopErr
+	case stats.Limited:
+		return stats, nil
+	}
+	return stats, err
+}
+
+// fanOutStats é o resumo de um fanOutRanges.
+type fanOutStats struct {
+	ranges, requests, retried int
+	failed                    []ScanRangeFailure
+}
+
+// fanOutRanges divide o ring entre os nós: os intervalos são agrupados pela
+// réplica primária e fetch é chamado uma vez por nó, com todos os intervalos
+// dele (até parallel chamadas ao mesmo tempo). Se fetch falhar, os intervalos
+// do grupo são agrupados de novo pela réplica seguinte; os que ficam sem
+// réplicas entram em failed e o resto segue. Com ctx cancelado, para e
+// retorna ctx.Err().
+func (r *Router) fanOutRanges(ctx context.Context, parallel int, fetch func(ctx context.Context, node hashring.NodeInfo, ranges []hashring.TokenRange) error) (fanOutStats, error) {
+	if parallel <= 0 {
+		parallel = DefaultScanParallelism
+	}
+	if parallel > MaxScanParallelism {
+		parallel = MaxScanParallelism
+	}
+	ranges := r.ring.Ranges()
+	stats := fanOutStats{ranges: len(ranges), failed: []ScanRangeFailure{}}
+	replicas := make(map[uint32][]hashring.NodeInfo, len(ranges))
+	for _, t := range ranges {
+		replicas[t.End] = r.ReplicasForRange(t)
+	}
+
+	type group struct {
+		node    hashring.NodeInfo
+		ranges  []hashring.TokenRange
+		attempt int
+		err     error
+	}
+	done := make(chan *group)
+	slots := make(chan struct{}, parallel)
+	running := 0
+	// launch agrupa os intervalos pela réplica da tentativa attempt e
+	// dispara um fetch por nó; os que não têm mais réplicas falham
+	launch := func(todo []hashring.TokenRange, attempt int, cause error) {
+		groups := make(map[hashring.NodeID]*group)
+		var order []*group
+		for _, t := range todo {
+			reps := replicas[t.End]
+			if attempt >= len(reps) {
+				msg := "no replicas for range"
+				if cause != nil {
+					msg = cause.Error()
+				}
+				stats.failed = append(stats.failed, ScanRangeFailure{Start: t.Start, End: t.End, Error: msg})
+				metrics.Inc("scan.range_failures")
+				continue
+			}
+			if attempt > 0 {
+				stats.retried++
+			}
+			node := reps[attempt]
+			g, ok := groups[node.ID]
+			if !ok {
+				g = &group{node: node, attempt: attempt}
+				groups[node.ID] = g
+				order = append(order, g)
+			}
+			g.ranges = append(g.ranges, t)
+		}
+		for _, g := range order {
+			running++
+			stats.requests++
+			go func(g *group) {
+				select {
+				case slots <- struct{}{}:
+					g.err = fetch(ctx, g.node, g.ranges)
+					<-slots
+				case <-ctx.Done():
+					g.err = ctx.Err()
+				}
+				done <- g
+			}(g)
+		}
+	}
+	launch(ranges, 0, nil)
+
+	for running > 0 {
+		g := <-done
+		running--
+		if g.err == nil || ctx.Err() != nil {
+			continue
+		}
+		log.Printf("[SCAN] %d ranges from %s failed (attempt %d): %v", len(g.ranges), g.node.ID, g.attempt+1, g.err)
+		launch(g.ranges, g.attempt+1, g.err)
+	}
+	return stats, ctx.Err()
+}
+
+// scanFrom lê de node as chaves vivas dos intervalos de sr, chamando fn para
+// cada uma conforme chegam.
+func (r *Router) scanFrom(ctx context.Context, node hashring.NodeInfo, sr ScanRequest, fn func(Record) error) error {
+	if r.isLocal(node) {
+		return r.LocalScan(sr, fn)
+	}
+	body, _ := json.Marshal(sr)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s%s", node.Host, ScanPath), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.adminClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("scan on %s failed: %w", node.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("scan on %s: status=%d %s", node.Host, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("scan on %s: %w", node.Host, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// LocalScan chama fn para cada chave viva do store local com o prefixo de sr
+// e o token em algum dos intervalos de sr, em ordem de chave.
+func (r *Router) LocalScan(sr ScanRequest, fn func(Record) error) error {
+	ranges := newTokenRangeSet(sr.Ranges)
+	now := kv.Now()
+	var err error
+	r.localStore.IterateVersions(sr.Prefix, "", func(key string, e kv.Entry) bool {
+		if !strings.HasPrefix(key, sr.Prefix) {
+			return false
+		}
+		if e.Deleted || e.Expired(now) || !ranges.contains(r.ring.Hash(key)) {
+			return true
+		}
+		err = fn(Record{Key: key, Value: e.Value, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt})
+		return err == nil
+	})
+	return err
+}
+
+// tokenRangeSet testa se um token está em algum de vários intervalos sem
+// percorrer todos: os intervalos do ring não se sobrepõem, então basta o
+// primeiro que termina em ou depois do token (e o que dá a volta no anel).
+type tokenRangeSet struct {
+	sorted []hashring.TokenRange
+	wrap   []hashring.TokenRange
+}
+
+func newTokenRangeSet(ranges []hashring.TokenRange) tokenRangeSet {
+	var s tokenRangeSet
+	for _, t := range ranges {
+		if t.Start < t.End {
+			s.sorted = append(s.sorted, t)
+		} else {
+			s.wrap = append(s.wrap, t)
+		}
+	}
+	sort.Slice(s.sorted, func(i, j int) bool { return s.sorted[i].End < s.sorted[j].End })
+	return s
+}
+
+func (s tokenRangeSet) contains(h uint32) bool {
+	i := sort.Search(len(s.sorted), func(i int) bool { return s.sorted[i].End >= h })
+	if i < len(s.sorted) && s.sorted[i].Contains(h) {
+		return true
+	}
+	for _, t := range s.wrap {
+		if t.Contains(h) {
+			return true
+		}
+	}
+	return false
+}
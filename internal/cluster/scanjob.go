@@ -0,0 +1,332 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// ScanJobPath é o endpoint interno que processa, no nó, a parte de um job de
+// scan: filtra e transforma as chaves dos intervalos pedidos.
+const ScanJobPath = "/internal/scanjob"
+
+// scanJobKind é o job de scan/transformação pedido por um cliente.
+const scanJobKind = "scan"
+
+// Limites dos resultados guardados de um job de scan sem keyspace de saída.
+const (
+	DefaultScanJobMaxResults = 10000
+	MaxScanJobMaxResults     = 100000
+	// scanJobsKept: quantos jobs de scan têm os resultados guardados na
+	// memória (os mais antigos são descartados)
+	scanJobsKept = 16
+)
+
+// Erros de ScanJobResults.
+var (
+	ErrScanJobNotFound   = errors.New("scan job not found")
+	ErrScanJobRunning    = errors.New("scan job still running")
+	ErrScanJobNoResults  = errors.New("scan job results not available")
+	errScanJobBadOutput  = errors.New("invalid output keyspace")
+	errScanJobMaxResults = fmt.Errorf("max_results must be between 0 and %d", MaxScanJobMaxResults)
+)
+
+// ScanJobSpec é o que o cliente pede num job de scan: as chaves vivas com o
+// prefixo e que passam em match/where (como no /debug/keys), com o valor
+// reduzido aos campos Fields, gravadas no keyspace Output ou guardadas no
+// coordenador para o cliente buscar.
+type ScanJobSpec struct {
+	Prefix string   `json:"prefix,omitempty"`
+	Match  string   `json:"match,omitempty"`
+	Where  []string `json:"where,omitempty"`
+	// Fields: projeção do valor JSON (só esses campos); vazio = valor inteiro
+	Fields []string `json:"fields,omitempty"`
+	// Output: keyspace em que cada resultado é gravado, como
+	// <output>:<chave sem o keyspace>; vazio = resultados guardados no
+	// coordenador
+	Output string `json:"output,omitempty"`
+	// MaxResults guardados sem Output (0 = DefaultScanJobMaxResults)
+	MaxResults int `json:"max_results,omitempty"`
+	// Parallelism: nós processando ao mesmo tempo (0 = DefaultScanParallelism)
+	Parallelism int `json:"parallel,omitempty"`
+}
+
+// ScanJobRequest é o corpo de POST /internal/scanjob.
+type ScanJobRequest struct {
+	ScanJobSpec
+	Ranges []hashring.TokenRange `json:"ranges"`
+}
+
+// ScanJobPart é o resultado de um nó para os intervalos de um ScanJobRequest.
+type ScanJobPart struct {
+	// Scanned: chaves vivas dos intervalos; Matched: as que passaram no filtro
+	Scanned int64 `json:"scanned"`
+	Matched int64 `json:"matched"`
+	// Written no keyspace de saída (com Output)
+	Written int64 `json:"written"`
+	// Bytes dos valores produzidos (depois da projeção)
+	Bytes int64 `json:"bytes"`
+	// Records: os resultados (sem Output), até MaxResults; Truncated se havia
+	// mais
+	Records   []Record `json:"records,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// ScanJobDetail é o detail do job scan.
+type ScanJobDetail struct {
+	Spec    ScanJobSpec `json:"spec"`
+	Scanned int64       `json:"scanned"`
+	Matched int64       `json:"matched"`
+	Written int64       `json:"written"`
+	// Results guardados no coordenador (GET /v1/scan/jobs/{id}/results)
+	Results   int  `json:"results"`
+	Truncated bool `json:"truncated"`
+	// Requests, Retried e Failed como no resumo do scan
+	Requests int                `json:"requests"`
+	Retried  int                `json:"retried"`
+	Failed   []ScanRangeFailure `json:"failed"`
+}
+
+// scanJobStore guarda os resultados dos últimos jobs de scan.
+type scanJobStore struct {
+	mu      sync.Mutex
+	results map[string][]Record
+	order   []string
+}
+
+func (s *scanJobStore) put(id string, recs []Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.results == nil {
+		s.results = make(map[string][]Record)
+	}
+	s.results[id] = recs
+	s.order = append(s.order, id)
+	for len(s.order) > scanJobsKept {
+		delete(s.results, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *scanJobStore) get(id string) ([]Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recs, ok := s.results[id]
+	return recs, ok
+}
+
+// StartScanJob inicia um job de scan (job scan em /admin/jobs): um
+// map-reduce simples sobre o cluster. Cada nó recebe, como no scan, os
+// intervalos dos quais é réplica primária e filtra e transforma as chaves
+// deles localmente; com spec.Output, grava os resultados no keyspace de saída
+// ele mesmo (com o writeCL) e só as contagens voltam. Um nó que falhar tem os
+// intervalos dele processados na réplica seguinte (as escritas já feitas são
+// refeitas, com o mesmo valor); o parcial de um nó só conta inteiro. Sem
+// Output, até spec.MaxResults resultados ficam guardados neste nó para
+// ScanJobResults.
+func (r *Router) StartScanJob(spec ScanJobSpec) (string, error) {
+	if _, err := kv.ParseFilter(spec.Match, spec.Where); err != nil {
+		return "", err
+	}
+	if spec.Output != "" && strings.Contains(spec.Output, kv.KeyspaceSep) {
+		return "", errScanJobBadOutput
+	}
+	if spec.MaxResults < 0 || spec.MaxResults > MaxScanJobMaxResults {
+		return "", errScanJobMaxResults
+	}
+	if spec.Output == "" && spec.MaxResults == 0 {
+		spec.MaxResults = DefaultScanJobMaxResults
+	}
+	metrics.Inc("scan.jobs")
+
+	job := r.jobs.Start(scanJobKind, "ranges", func(ctx context.Context, job *jobs.Job) error {
+		var (
+			mu      sync.Mutex
+			results []Record
+			detail  = ScanJobDetail{Spec: spec, Failed: []ScanRangeFailure{}}
+		)
+		job.SetTotal(int64(len(r.ring.Ranges())))
+		job.SetDetail(detail)
+		fo, err := r.fanOutRanges(ctx, spec.Parallelism, func(ctx context.Context, node hashring.NodeInfo, ranges []hashring.TokenRange) error {
+			part, err := r.scanJobFrom(ctx, node, ScanJobRequest{ScanJobSpec: spec, Ranges: ranges})
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			detail.Scanned += part.Scanned
+			detail.Matched += part.Matched
+			detail.Written += part.Written
+			for _, rec := range part.Records {
+				if len(results) >= spec.MaxResults {
+					detail.Truncated = true
+					break
+				}
+				results = append(results, rec)
+			}
+			detail.Truncated = detail.Truncated || part.Truncated
+			detail.Results = len(results)
+			job.Add(int64(len(ranges)), part.Bytes)
+			job.SetDetail(detail)
+			return nil
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		detail.Requests, detail.Retried, detail.Failed = fo.requests, fo.retried, fo.failed
+		job.SetDetail(detail)
+		if spec.Output == "" {
+			r.scanJobs.put(job.ID(), results)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("[SCAN] job %s: %d keys scanned, %d matched, %d written, %d ranges failed", job.ID(), detail.Scanned, detail.Matched, detail.Written, len(fo.failed))
+		if len(fo.failed) > 0 {
+			return fmt.Errorf("%d of %d ranges failed (first error: %s)", len(fo.failed), fo.ranges, fo.failed[0].Error)
+		}
+		return nil
+	})
+	return job.ID(), nil
+}
+
+// ScanJobResults retorna os resultados guardados do job de scan id, já
+// terminado (também os parciais de um job que falhou ou foi cancelado).
+func (r *Router) ScanJobResults(id string) ([]Record, error) {
+	info, ok := r.jobs.Get(id)
+	if !ok || info.Kind != scanJobKind {
+		return nil, ErrScanJobNotFound
+	}
+	if info.Status == jobs.Running {
+		return nil, ErrScanJobRunning
+	}
+	recs, ok := r.scanJobs.get(id)
+	if !ok {
+		return nil, ErrScanJobNoResults
+	}
+	return recs, nil
+}
+
+// ScanJobs lista os jobs de scan deste nó (como /admin/jobs?kind=scan).
+func (r *Router) ScanJobs() []jobs.Info {
+	return r.jobs.List(scanJobKind)
+}
+
+// ScanJob retorna o job de scan id.
+func (r *Router) ScanJob(id string) (jobs.Info, bool) {
+	info, ok := r.jobs.Get(id)
+	if !ok || info.Kind != scanJobKind {
+		return jobs.Info{}, false
+	}
+	return info, true
+}
+
+func (r *Router) scanJobFrom(ctx context.Context, node hashring.NodeInfo, sr ScanJobRequest) (ScanJobPart, error) {
+	if r.isLocal(node) {
+		return r.LocalScanJob(ctx, sr)
+	}
+	body, _ := json.Marshal(sr)
+	res := r.call(ctx, node, "POST", ScanJobPath, body)
+	if !res.OK() {
+		return ScanJobPart{}, fmt.Errorf("scan job on %s: %s", node.ID, res.Error())
+	}
+	var part ScanJobPart
+	if err := json.Unmarshal(res.Body, &part); err != nil {
+		return ScanJobPart{}, fmt.Errorf("scan job on %s: %w", node.ID, err)
+	}
+	return part, nil
+}
+
+// LocalScanJob processa sr sobre o store local: filtra as chaves vivas dos
+// intervalos, aplica a projeção e grava os resultados no keyspace de saída
+// (em lotes, passando pelo ring) ou os devolve. Com Output, as chaves que já
+// estão no keyspace de saída são ignoradas (o job não lê o que ele mesmo
+// gravou). Qualquer escrita que falhar faz a parte inteira falhar, para o
+// coordenador refazê-la em outra réplica.
+func (r *Router) LocalScanJob(ctx context.Context, sr ScanJobRequest) (ScanJobPart, error) {
+	filter, err := kv.ParseFilter(sr.Match, sr.Where)
+	if err != nil {
+		return ScanJobPart{}, err
+	}
+	ranges := newTokenRangeSet(sr.Ranges)
+	now := kv.Now()
+	var (
+		part  ScanJobPart
+		batch []Record
+		werr  error
+	)
+	flush := func() {
+		for i, res := range r.PutBatch(batch) {
+			if res.Err != nil {
+				if werr == nil {
+					werr = fmt.Errorf("writing %s: %w", batch[i].Key, res.Err)
+				}
+				continue
+			}
+			part.Written++
+		}
+		batch = batch[:0]
+	}
+	r.localStore.IterateVersions(sr.Prefix, "", func(key string, e kv.Entry) bool {
+		if !strings.HasPrefix(key, sr.Prefix) || ctx.Err() != nil || werr != nil {
+			return false
+		}
+		if e.Deleted || e.Expired(now) || !ranges.contains(r.ring.Hash(key)) {
+			return true
+		}
+		if sr.Output != "" && kv.KeyspaceOf(key) == sr.Output {
+			return true
+		}
+		part.Scanned++
+		if !filter.Match(key, e) {
+			return true
+		}
+		value := e.Value
+		if len(sr.Fields) > 0 {
+			v, ok := kv.Project(e.Value, sr.Fields)
+			if !ok {
+				// sem JSON não há o que projetar
+				return true
+			}
+			value = v
+		}
+		part.Matched++
+		part.Bytes += int64(len(value))
+		if sr.Output == "" {
+			if len(part.Records) >= sr.MaxResults {
+				part.Truncated = true
+				return true
+			}
+			part.Records = append(part.Records, Record{Key: key, Value: value, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt})
+			return true
+		}
+		out := key
+		if i := strings.Index(key, kv.KeyspaceSep); i > 0 {
+			out = key[i+len(kv.KeyspaceSep):]
+		}
+		batch = append(batch, Record{Key: sr.Output + kv.KeyspaceSep + out, Value: value, ExpiresAt: e.ExpiresAt})
+		if len(batch) >= streamBatchSize {
+			flush()
+		}
+		return true
+	})
+	if len(batch) > 0 && werr == nil {
+		flush()
+	}
+	if err := ctx.Err(); err != nil {
+		return ScanJobPart{}, err
+	}
+	if werr != nil {
+		return ScanJobPart{}, werr
+	}
+	return part, nil
+}
@@ -0,0 +1,359 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/jsonschema"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// SchemasPath é o endpoint interno das restrições de valor por keyspace:
+// GET lista as definições do nó (com as removidas), POST aplica uma.
+const SchemasPath = "/internal/schemas"
+
+// DefaultSchemaSyncInterval é de quanto em quanto tempo o nó busca as
+// definições dos outros (para coordenadores, observadores e nós que estavam
+// fora do ar quando uma definição mudou).
+const DefaultSchemaSyncInterval = 30 * time.Second
+
+// ErrSchemaViolation: o valor não respeita as restrições do keyspace
+// (a escrita é recusada no coordenador, antes de ir às réplicas).
+var ErrSchemaViolation = errors.New("value violates the keyspace schema")
+
+// ErrSchemaNotFound: o keyspace não tem restrições registradas.
+var ErrSchemaNotFound = errors.New("keyspace has no schema")
+
+// KeyspaceSchema são as restrições dos valores de um keyspace. Schema é um
+// JSON Schema (ver o pacote jsonschema para o subconjunto aceito); com ele,
+// os valores precisam ser documentos JSON. As definições são versionadas
+// por UpdatedAt (a mais nova ganha) e Deleted é a remoção, que também se
+// propaga.
+type KeyspaceSchema struct {
+	Keyspace string          `json:"keyspace"`
+	Schema   json.RawMessage `json:"schema,omitempty"`
+	// MinBytes/MaxBytes: tamanho do valor (0 = sem limite)
+	MinBytes int `json:"min_bytes,omitempty"`
+	MaxBytes int `json:"max_bytes,omitempty"`
+	// ContentTypes aceitos no PUT (Content-Type sem parâmetros; vazio =
+	// qualquer um)
+	ContentTypes []string `json:"content_types,omitempty"`
+	UpdatedAt    int64    `json:"updated_at"`
+	Deleted      bool     `json:"deleted,omitempty"`
+}
+
+// SchemaError é a recusa de um valor, com as violações encontradas.
+type SchemaError struct {
+	Keyspace string   `json:"keyspace"`
+	Errors   []string `json:"errors"`
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%v (keyspace %q): %s", ErrSchemaViolation, e.Keyspace, strings.Join(e.Errors, "; "))
+}
+
+func (e *SchemaError) Is(target error) bool { return target == ErrSchemaViolation }
+
+type compiledSchema struct {
+	def    KeyspaceSchema
+	schema *jsonschema.Schema
+	types  map[string]bool
+}
+
+// schemaState são as definições deste nó, gravadas em path para voltarem no
+// boot.
+type schemaState struct {
+	mu   sync.RWMutex
+	path string
+	defs map[string]*compiledSchema
+}
+
+// compileSchema confere a definição e prepara a validação.
+func compileSchema(def KeyspaceSchema) (*compiledSchema, error) {
+	if strings.Contains(def.Keyspace, kv.KeyspaceSep) {
+		return nil, fmt.Errorf("invalid keyspace %q", def.Keyspace)
+	}
+	if def.Keyspace == "" {
+		def.Keyspace = kv.DefaultKeyspace
+	}
+	if def.MinBytes < 0 || def.MaxBytes < 0 || (def.MaxBytes > 0 && def.MinBytes > def.MaxBytes) {
+		return nil, fmt.Errorf("invalid size limits (min_bytes=%d max_bytes=%d)", def.MinBytes, def.MaxBytes)
+	}
+	c := &compiledSchema{def: def}
+	if len(def.Schema) > 0 && string(def.Schema) != "null" {
+		s, err := jsonschema.Compile(def.Schema)
+		if err != nil {
+			return nil, err
+		}
+		c.schema = s
+	} else {
+		c.def.Schema = nil
+	}
+	if len(def.ContentTypes) > 0 {
+		c.types = make(map[string]bool, len(def.ContentTypes))
+		c.def.ContentTypes = make([]string, len(def.ContentTypes))
+		for i, ct := range def.ContentTypes {
+			mt, _, err := mime.ParseMediaType(ct)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content type %q", ct)
+			}
+			c.def.ContentTypes[i] = mt
+			c.types[mt] = true
+		}
+	}
+	return c, nil
+}
+
+// ValidateSchema confere uma definição sem aplicá-la.
+func ValidateSchema(def KeyspaceSchema) error {
+	_, err := compileSchema(def)
+	return err
+}
+
+// LoadSchemas recria as definições gravadas em path (e passa a gravar nele).
+func (r *Router) LoadSchemas(path string) error {
+	r.schemas.mu.Lock()
+	defer r.schemas.mu.Unlock()
+	r.schemas.path = path
+	r.schemas.defs = make(map[string]*compiledSchema)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var defs []KeyspaceSchema
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("schema state %s: %w", path, err)
+	}
+	live := 0
+	for _, def := range defs {
+		c, err := compileSchema(def)
+		if err != nil {
+			return fmt.Errorf("schema state %s: keyspace %q: %w", path, def.Keyspace, err)
+		}
+		r.schemas.defs[c.def.Keyspace] = c
+		if !def.Deleted {
+			live++
+		}
+	}
+	if live > 0 {
+		log.Printf("[SCHEMA] loaded %d keyspace schemas from %s", live, path)
+	}
+	return nil
+}
+
+func (r *Router) saveSchemasLocked() error {
+	if r.schemas.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.schemaDefsLocked(true), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.schemas.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.schemas.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.schemas.path)
+}
+
+func (r *Router) schemaDefsLocked(deleted bool) []KeyspaceSchema {
+	defs := make([]KeyspaceSchema, 0, len(r.schemas.defs))
+	for _, c := range r.schemas.defs {
+		if deleted || !c.def.Deleted {
+			defs = append(defs, c.def)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Keyspace < defs[j].Keyspace })
+	return defs
+}
+
+// ApplyLocalSchema aplica a definição neste nó se ela for mais nova que a
+// atual do keyspace; false se foi ignorada.
+func (r *Router) ApplyLocalSchema(def KeyspaceSchema) (bool, error) {
+	c, err := compileSchema(def)
+	if err != nil {
+		return false, err
+	}
+	r.schemas.mu.Lock()
+	defer r.schemas.mu.Unlock()
+	if r.schemas.defs == nil {
+		r.schemas.defs = make(map[string]*compiledSchema)
+	}
+	if cur, ok := r.schemas.defs[c.def.Keyspace]; ok && cur.def.UpdatedAt >= c.def.UpdatedAt {
+		return false, nil
+	}
+	r.schemas.defs[c.def.Keyspace] = c
+	if c.def.Deleted {
+		log.Printf("[SCHEMA] schema of keyspace %s dropped on node %s", c.def.Keyspace, r.nodeID)
+	} else {
+		log.Printf("[SCHEMA] schema of keyspace %s updated on node %s (json_schema=%t max_bytes=%d content_types=%v)", c.def.Keyspace, r.nodeID, c.schema != nil, c.def.MaxBytes, c.def.ContentTypes)
+	}
+	return true, r.saveSchemasLocked()
+}
+
+// SetSchema registra (ou substitui) as restrições do keyspace em todos os
+// nós do ring; este nó aplica antes, mesmo fora do ring. Um nó fora do ar
+// recebe a definição pelo RunSchemaSync quando voltar.
+func (r *Router) SetSchema(ctx context.Context, def KeyspaceSchema) (KeyspaceSchema, []NodeResult, error) {
+	def.UpdatedAt, def.Deleted = kv.Now(), false
+	return r.publishSchema(ctx, def)
+}
+
+// DropSchema remove as restrições do keyspace de todos os nós.
+func (r *Router) DropSchema(ctx context.Context, keyspace string) ([]NodeResult, error) {
+	if keyspace == "" {
+		keyspace = kv.DefaultKeyspace
+	}
+	if _, ok := r.Schema(keyspace); !ok {
+		return nil, ErrSchemaNotFound
+	}
+	_, results, err := r.publishSchema(ctx, KeyspaceSchema{Keyspace: keyspace, UpdatedAt: kv.Now(), Deleted: true})
+	return results, err
+}
+
+func (r *Router) publishSchema(ctx context.Context, def KeyspaceSchema) (KeyspaceSchema, []NodeResult, error) {
+	c, err := compileSchema(def)
+	if err != nil {
+		return def, nil, err
+	}
+	if _, err := r.ApplyLocalSchema(c.def); err != nil {
+		return c.def, nil, err
+	}
+	body, _ := json.Marshal(c.def)
+	return c.def, r.Broadcast(ctx, "POST", SchemasPath, body), nil
+}
+
+// Schema retorna as restrições do keyspace neste nó.
+func (r *Router) Schema(keyspace string) (KeyspaceSchema, bool) {
+	r.schemas.mu.RLock()
+	defer r.schemas.mu.RUnlock()
+	c, ok := r.schemas.defs[keyspace]
+	if !ok || c.def.Deleted {
+		return KeyspaceSchema{}, false
+	}
+	return c.def, true
+}
+
+// Schemas retorna as definições deste nó (deleted=true inclui as removidas,
+// para a sincronização).
+func (r *Router) Schemas(deleted bool) []KeyspaceSchema {
+	r.schemas.mu.RLock()
+	defer r.schemas.mu.RUnlock()
+	return r.schemaDefsLocked(deleted)
+}
+
+// SyncSchemas busca as definições dos nós do ring e aplica as mais novas.
+func (r *Router) SyncSchemas(ctx context.Context) int {
+	applied := 0
+	for _, res := range r.Broadcast(ctx, "GET", SchemasPath, nil) {
+		if r.isLocal(res.Node) || !res.OK() {
+			continue
+		}
+		var defs []KeyspaceSchema
+		if err := json.Unmarshal(res.Body, &defs); err != nil {
+			continue
+		}
+		for _, def := range defs {
+			if ok, err := r.ApplyLocalSchema(def); err != nil {
+				log.Printf("[SCHEMA] ignoring schema of keyspace %q from %s: %v", def.Keyspace, res.Node.ID, err)
+			} else if ok {
+				applied++
+			}
+		}
+	}
+	return applied
+}
+
+// RunSchemaSync roda SyncSchemas a cada interval até ctx terminar (a
+// primeira logo no boot).
+func (r *Router) RunSchemaSync(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSchemaSyncInterval
+	}
+	for {
+		cctx, cancel := context.WithTimeout(ctx, interval)
+		r.SyncSchemas(cctx)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *Router) compiledSchemaFor(key string) *compiledSchema {
+	r.schemas.mu.RLock()
+	defer r.schemas.mu.RUnlock()
+	if len(r.schemas.defs) == 0 {
+		return nil
+	}
+	c := r.schemas.defs[kv.KeyspaceOf(key)]
+	if c == nil || c.def.Deleted {
+		return nil
+	}
+	return c
+}
+
+// ValidateValue confere value com as restrições do keyspace da chave;
+// *SchemaError (ErrSchemaViolation) se não passar.
+func (r *Router) ValidateValue(key, value string) error {
+	c := r.compiledSchemaFor(key)
+	if c == nil {
+		return nil
+	}
+	value = kv.ValueBody(value)
+	var errs []string
+	if c.def.MaxBytes > 0 && len(value) > c.def.MaxBytes {
+		errs = append(errs, fmt.Sprintf("value has %d bytes, max_bytes is %d", len(value), c.def.MaxBytes))
+	}
+	if len(value) < c.def.MinBytes {
+		errs = append(errs, fmt.Sprintf("value has %d bytes, min_bytes is %d", len(value), c.def.MinBytes))
+	}
+	if c.schema != nil && len(errs) == 0 {
+		errs = append(errs, c.schema.ValidateJSON([]byte(value))...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	metrics.Inc("schema.rejected")
+	return &SchemaError{Keyspace: c.def.Keyspace, Errors: errs}
+}
+
+// CheckContentType confere o Content-Type de um PUT com os aceitos pelo
+// keyspace da chave.
+func (r *Router) CheckContentType(key, contentType string) error {
+	c := r.compiledSchemaFor(key)
+	if c == nil || c.types == nil {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil && c.types[mt] {
+		return nil
+	}
+	metrics.Inc("schema.rejected")
+	if contentType == "" {
+		contentType = "none"
+	}
+	return &SchemaError{Keyspace: c.def.Keyspace, Errors: []string{fmt.Sprintf("content type %s is not allowed (want %s)", contentType, strings.Join(c.def.ContentTypes, " or "))}}
+}
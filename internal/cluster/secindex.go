@@ -0,0 +1,318 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"mini-cassandra/internal/kv"
+)
+
+// Rotas internas dos índices secundários.
+const (
+	IndexesPath     = "/internal/indexes"
+	IndexQueryPath  = "/internal/index/query"
+	indexVerifyPool = 16
+)
+
+var indexNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// indexState são as definições de índices deste nó, gravadas em path para
+// voltarem no boot.
+type indexState struct {
+	mu   sync.Mutex
+	path string
+	defs map[string]kv.IndexDef
+}
+
+// ParseIndexStatement lê uma declaração de índice:
+//
+//	index <nome> on [<keyspace>] field <campo>
+//
+// (palavras-chave sem diferença de maiúsculas; "create" no início é aceito).
+func ParseIndexStatement(stmt string) (kv.IndexDef, error) {
+	f := strings.Fields(stmt)
+	if len(f) > 0 && strings.EqualFold(f[0], "create") {
+		f = f[1:]
+	}
+	usage := fmt.Errorf("invalid index statement %q (want: index <name> on [<keyspace>] field <field>)", stmt)
+	if len(f) < 5 || !strings.EqualFold(f[0], "index") || !strings.EqualFold(f[2], "on") {
+		return kv.IndexDef{}, usage
+	}
+	def := kv.IndexDef{Name: f[1]}
+	switch {
+	case len(f) == 5 && strings.EqualFold(f[3], "field"):
+		def.Field = f[4]
+	case len(f) == 6 && strings.EqualFold(f[4], "field"):
+		def.Keyspace, def.Field = f[3], f[5]
+	default:
+		return kv.IndexDef{}, usage
+	}
+	return def, ValidateIndex(def)
+}
+
+// ValidateIndex confere nome e campo de uma definição.
+func ValidateIndex(def kv.IndexDef) error {
+	if !indexNameRe.MatchString(def.Name) {
+		return fmt.Errorf("invalid index name %q (letters, digits, _ and -)", def.Name)
+	}
+	if def.Field == "" || strings.HasPrefix(def.Field, ".") || strings.HasSuffix(def.Field, ".") || strings.Contains(def.Field, "..") {
+		return fmt.Errorf("invalid index field %q", def.Field)
+	}
+	return nil
+}
+
+// LoadIndexes recria os índices gravados em path (e passa a gravar nele).
+// Chamar depois da recuperação do store, para os índices cobrirem os dados.
+func (r *Router) LoadIndexes(path string) error {
+	r.indexes.mu.Lock()
+	defer r.indexes.mu.Unlock()
+	r.indexes.path = path
+	r.indexes.defs = make(map[string]kv.IndexDef)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var defs []kv.IndexDef
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("index state %s: %w", path, err)
+	}
+	for _, def := range defs {
+		r.localStore.CreateIndex(def)
+		r.indexes.defs[def.Name] = def
+	}
+	if len(defs) > 0 {
+		log.Printf("[INDEX] loaded %d secondary indexes from %s", len(defs), path)
+	}
+	return nil
+}
+
+func (r *Router) saveIndexesLocked() error {
+	if r.indexes.path == "" {
+		return nil
+	}
+	defs := make([]kv.IndexDef, 0, len(r.indexes.defs))
+	for _, d := range r.indexes.defs {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	data, err := json.MarshalIndent(defs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.indexes.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.indexes.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.indexes.path)
+}
+
+// CreateLocalIndex cria o índice neste nó (preenchendo com os dados locais)
+// e grava a definição.
+func (r *Router) CreateLocalIndex(def kv.IndexDef) error {
+	if err := ValidateIndex(def); err != nil {
+		return err
+	}
+	r.indexes.mu.Lock()
+	defer r.indexes.mu.Unlock()
+	r.localStore.CreateIndex(def)
+	if r.indexes.defs == nil {
+		r.indexes.defs = make(map[string]kv.IndexDef)
+	}
+	r.indexes.defs[def.Name] = def
+	log.Printf("[INDEX] index %s on keyspace=%q field=%s ready on node %s", def.Name, def.Keyspace, def.Field, r.nodeID)
+	return r.saveIndexesLocked()
+}
+
+// DropLocalIndex remove o índice deste nó; false se ele não existia.
+func (r *Router) DropLocalIndex(name string) (bool, error) {
+	r.indexes.mu.Lock()
+	defer r.indexes.mu.Unlock()
+	delete(r.indexes.defs, name)
+	if !r.localStore.DropIndex(name) {
+		return false, nil
+	}
+	log.Printf("[INDEX] index %s dropped on node %s", name, r.nodeID)
+	return true, r.saveIndexesLocked()
+}
+
+// CreateIndex declara o índice em todos os nós do ring. Um nó fora do ar não
+// recebe a declaração (repita quando ele voltar).
+func (r *Router) CreateIndex(ctx context.Context, def kv.IndexDef) []NodeResult {
+	body, _ := json.Marshal(def)
+	return r.Broadcast(ctx, "POST", IndexesPath, body)
+}
+
+// DropIndex remove o índice de todos os nós do ring.
+func (r *Router) DropIndex(ctx context.Context, name string) []NodeResult {
+	return r.Broadcast(ctx, "DELETE", IndexesPath+"/"+url.PathEscape(name), nil)
+}
+
+// IndexPage é a resposta de /internal/index/query: as chaves locais com o
+// termo, em ordem, e a definição do índice (para o coordenador conferir).
+type IndexPage struct {
+	Index kv.IndexDef `json:"index"`
+	Keys  []string    `json:"keys"`
+	More  bool        `json:"more"`
+}
+
+// IndexMatch é uma chave encontrada por uma consulta de índice.
+type IndexMatch struct {
+	Key       string  `json:"key"`
+	Value     *string `json:"value,omitempty"`
+	Timestamp int64   `json:"ts"`
+}
+
+// IndexResult é o resultado de QueryIndex. After é a última chave
+// candidata da página (cursor da próxima, se More); Partial indica que
+// algum nó não respondeu.
+type IndexResult struct {
+	Matches []IndexMatch `json:"matches"`
+	More    bool         `json:"more"`
+	After   string       `json:"-"`
+	Partial bool         `json:"partial,omitempty"`
+}
+
+// QueryIndex procura as chaves com value no campo do índice: consulta o
+// índice local de todos os nós (scatter-gather), junta as chaves candidatas
+// em ordem (até limit, depois de after) e confere cada uma com uma leitura
+// normal (consistência de leitura padrão), porque o índice de uma réplica
+// atrasada pode apontar para uma versão velha. values=true inclui os valores.
+func (r *Router) QueryIndex(ctx context.Context, name, value, after string, limit int, values bool) (IndexResult, error) {
+	term := kv.QueryTerm(value)
+	q := url.Values{}
+	q.Set("name", name)
+	q.Set("term", term)
+	q.Set("after", after)
+	q.Set("limit", strconv.Itoa(limit))
+
+	var res IndexResult
+	var def *kv.IndexDef
+	seen := make(map[string]struct{})
+	missing := 0
+	results := r.Broadcast(ctx, "GET", IndexQueryPath+"?"+q.Encode(), nil)
+	for _, nr := range results {
+		var page IndexPage
+		if nr.Status == 404 && nr.Err == nil {
+			missing++
+			continue
+		}
+		if !nr.OK() || json.Unmarshal(nr.Body, &page) != nil {
+			log.Printf("[INDEX] query %s on %s failed: %s", name, nr.Node.ID, nr.Error())
+			res.Partial = true
+			continue
+		}
+		if def == nil {
+			d := page.Index
+			def = &d
+		}
+		res.More = res.More || page.More
+		for _, k := range page.Keys {
+			seen[k] = struct{}{}
+		}
+	}
+	if def == nil {
+		if missing > 0 {
+			return res, kv.ErrNoIndex
+		}
+		return res, fmt.Errorf("index %s: no node answered", name)
+	}
+	if missing > 0 {
+		// nó sem o índice (não estava no ar na declaração): as chaves dele
+		// costumam vir por outras réplicas
+		res.Partial = true
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys, res.More = keys[:limit], true
+	}
+	if len(keys) > 0 {
+		res.After = keys[len(keys)-1]
+	}
+
+	// confere as candidatas em paralelo, mantendo a ordem
+	found := make([]*IndexMatch, len(keys))
+	var readFailed atomic.Bool
+	sem := make(chan struct{}, indexVerifyPool)
+	var wg sync.WaitGroup
+	for i, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, k string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			e, ok, err := r.GetWith(ctx, k, r.ReadConsistencyFor(k))
+			if err != nil {
+				log.Printf("[INDEX] query %s: read key=%s failed: %v", name, k, err)
+				readFailed.Store(true)
+				return
+			}
+			if !ok || !def.Matches(k, e, term) {
+				return
+			}
+			m := &IndexMatch{Key: k, Timestamp: e.Timestamp}
+			if values {
+				v := kv.ValueBody(e.Value)
+				m.Value = &v
+			}
+			found[i] = m
+		}(i, k)
+	}
+	wg.Wait()
+	res.Partial = res.Partial || readFailed.Load()
+	res.Matches = make([]IndexMatch, 0, len(keys))
+	for _, m := range found {
+		if m != nil {
+			res.Matches = append(res.Matches, *m)
+		}
+	}
+	return res, nil
+}
+
+// LocalIndexQuery consulta o índice local (a parte deste nó de um
+// QueryIndex). kv.ErrNoIndex se o índice não existe aqui.
+func (r *Router) LocalIndexQuery(name, term, after string, limit int) (IndexPage, error) {
+	r.indexes.mu.Lock()
+	def, ok := r.indexes.defs[name]
+	r.indexes.mu.Unlock()
+	if !ok {
+		return IndexPage{}, kv.ErrNoIndex
+	}
+	keys, more, err := r.localStore.IndexLookup(name, term, after, limit)
+	if err != nil {
+		return IndexPage{}, err
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	return IndexPage{Index: def, Keys: keys, More: more}, nil
+}
+
+// Indexes são os índices deste nó (com o tamanho local), por nome.
+func (r *Router) Indexes() []kv.IndexInfo {
+	return r.localStore.Indexes()
+}
@@ -0,0 +1,226 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+)
+
+// Entrada pelos seeds (SEEDS): em vez de todo nó listar todos os nós em
+// CLUSTER_NODES, um nó novo só conhece alguns seeds. No boot ele busca o ring
+// num seed (LearnRing) e, se ainda não é membro, entra no cluster
+// (JoinCluster): recebe das réplicas atuais os trechos que passam a ser dele,
+// se anuncia a todos os nós (/internal/ring/join), reenvia os trechos e os
+// nós que deixaram de ser réplica limpam as chaves. Os membros ficam no
+// RING_STATE_FILE de cada nó, então um restart sem seeds no ar usa o último
+// ring conhecido.
+
+// RingJoinPath anuncia um nó novo (o corpo é o hashring.NodeInfo dele).
+const RingJoinPath = "/internal/ring/join"
+
+// JoinResult descreve a entrada deste nó no cluster.
+type JoinResult struct {
+	Node     string      `json:"node"`
+	Ranges   int         `json:"ranges"`
+	Streamed StreamStats `json:"streamed"`
+	CatchUp  StreamStats `json:"catch_up"`
+	Cleaned  int         `json:"cleaned"`
+	// Unavailable: trechos que nenhuma réplica conseguiu enviar
+	Unavailable []string `json:"unavailable,omitempty"`
+	Duration    string   `json:"duration"`
+}
+
+// LearnRing troca o ring local pelo do primeiro seed (host:port) que
+// responder. member diz se este nó já está nele (senão falta o
+// JoinCluster); found=false: nenhum seed respondeu e o ring local (de
+// CLUSTER_NODES e do RING_STATE_FILE) fica como está.
+func (r *Router) LearnRing(ctx context.Context, seeds []string) (member, found bool, err error) {
+	var nodes []hashring.NodeInfo
+	for _, s := range seeds {
+		if s != r.selfHost {
+			nodes = append(nodes, hashring.NodeInfo{ID: hashring.NodeID(s), Host: s})
+		}
+	}
+	snap, found := r.fetchRing(ctx, nodes)
+	if !found {
+		return false, false, nil
+	}
+
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+
+	// número de vnodes de cada nó segundo o seed (SetTokens abaixo troca os
+	// tokens, aqui só vale para NodeTokens achar os vnodes de cada um)
+	r.topo.vnodes = make(map[hashring.NodeID]int)
+	for id, n := range snap.VNodes {
+		if n >= 1 && n <= MaxNodeVNodes {
+			r.setVNodesLocked(id, n)
+		}
+	}
+
+	// membros são os nós cujos vnodes estão no ring; os outros donos (ex: um
+	// nó que substituiu outro) só têm tokens movidos
+	members := make(map[hashring.NodeID]string)
+	vnodeOf := make(map[uint32]hashring.NodeID)
+	for id, host := range snap.Hosts {
+		if id == r.nodeID {
+			host = r.selfHost
+		}
+		toks := r.ring.NodeTokens(id)
+		for _, t := range toks {
+			if _, ok := snap.Tokens[t]; ok {
+				members[id] = host
+				break
+			}
+		}
+		if _, ok := members[id]; ok {
+			for _, t := range toks {
+				vnodeOf[t] = id
+			}
+		}
+	}
+	_, member = members[r.nodeID]
+
+	tokens := make(map[uint32]hashring.NodeInfo, len(snap.Tokens))
+	r.topo.tokens = make(map[uint32]hashring.NodeID)
+	r.topo.added = make(map[uint32]hashring.NodeID)
+	r.topo.hosts = make(map[hashring.NodeID]string)
+	for t, id := range snap.Tokens {
+		host := snap.Hosts[id]
+		if id == r.nodeID {
+			host = r.selfHost
+		}
+		tokens[t] = hashring.NodeInfo{ID: id, Host: host}
+		switch base, ok := vnodeOf[t]; {
+		case !ok:
+			r.topo.added[t] = id
+			r.topo.hosts[id] = host
+		case base != id:
+			r.topo.tokens[t] = id
+			r.topo.hosts[id] = host
+		}
+	}
+	r.topo.members = members
+	r.ring.SetTokens(tokens)
+	r.adoptRingEpochLocked(snap.Epoch)
+	r.ringChanged()
+	log.Printf("[RING] learned ring from seed %s: %d members, %d tokens (member=%v)", snap.Node, len(members), len(tokens), member)
+	return member, true, r.saveRingStateLocked()
+}
+
+// AddMember coloca um nó anunciado por /internal/ring/join no ring local e
+// grava o estado. Repetir o anúncio não muda nada.
+func (r *Router) AddMember(node hashring.NodeInfo) error {
+	if node.ID == "" || node.Host == "" {
+		return fmt.Errorf("node to join needs id and host")
+	}
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	if _, ok := r.topo.members[node.ID]; ok {
+		return nil
+	}
+	for _, t := range r.ring.NodeTokens(node.ID) {
+		if _, ok := r.ring.TokenOwner(t); ok {
+			// os vnodes dele já estão no ring (ex: veio de CLUSTER_NODES)
+			return nil
+		}
+	}
+	r.ring.AddNode(node)
+	if r.topo.members == nil {
+		r.topo.members = make(map[hashring.NodeID]string)
+	}
+	r.topo.members[node.ID] = node.Host
+	r.ringChanged()
+	log.Printf("[RING] node %s (%s) joined the cluster", node.ID, node.Host)
+	return r.saveRingStateLocked()
+}
+
+// JoinCluster faz este nó (que ainda não está no ring) entrar no cluster:
+//  1. recebe das réplicas atuais os trechos dos quais passa a ser réplica;
+//  2. entra no ring local e se anuncia a todos os nós;
+//  3. reenvia os trechos (pega escritas feitas durante o passo 1);
+//  4. cada nó apaga as chaves das quais deixou de ser réplica.
+//
+// Se o nó cair no meio, basta subi-lo de novo: enquanto os seeds não o
+// conhecem ele refaz a entrada (o streaming é idempotente).
+func (r *Router) JoinCluster(ctx context.Context, job *jobs.Job) (*JoinResult, error) {
+	r.topo.moveMu.Lock()
+	defer r.topo.moveMu.Unlock()
+	start := time.Now()
+
+	self := hashring.NodeInfo{ID: r.nodeID, Host: r.selfHost}
+	res := &JoinResult{Node: string(self.ID)}
+	before := r.ring.Clone()
+	after := r.ring.Clone()
+	after.AddNode(self)
+	moves := r.rangeMoves(before, after)
+	res.Ranges = len(moves)
+	job.SetTotal(int64(2 * len(moves)))
+	// os outros nós mandam para cá as escritas dos trechos que passam a ser
+	// deste nó desde já, e não só depois do anúncio
+	defer r.announcePending(ctx, "join-"+string(self.ID), after)()
+	log.Printf("[JOIN] %s joining: streaming %d ranges", self.ID, len(moves))
+
+	streamed, failed := r.streamMoves(ctx, moves, after, "", nil, job)
+	res.Streamed = streamed
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	for _, err := range failed {
+		log.Printf("[JOIN] %v", err)
+		res.Unavailable = append(res.Unavailable, err.Error())
+	}
+
+	if err := r.AddMember(self); err != nil {
+		return res, fmt.Errorf("local ring change: %w", err)
+	}
+	body, _ := json.Marshal(self)
+	r.advanceRingEpoch()
+	for _, nr := range r.Broadcast(ctx, "POST", RingJoinPath, body) {
+		if !nr.OK() {
+			// o nó fica fora do ring de quem não recebeu o anúncio até ele
+			// reiniciar (e buscar o ring nos peers)
+			return res, fmt.Errorf("join not applied on %s: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	catchUp, _ := r.streamMoves(ctx, moves, after, "", nil, job)
+	res.CatchUp = catchUp
+	for _, nr := range r.Broadcast(ctx, "POST", "/internal/cleanup", nil) {
+		var out struct {
+			Removed int `json:"removed"`
+		}
+		if nr.OK() && json.Unmarshal(nr.Body, &out) == nil {
+			res.Cleaned += out.Removed
+		} else {
+			log.Printf("[JOIN] cleanup on %s failed: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	res.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("[JOIN] %s joined: streamed=%d keys catch_up=%d cleaned=%d unavailable=%d in %s",
+		self.ID, res.Streamed.Keys, res.CatchUp.Keys, res.Cleaned, len(res.Unavailable), res.Duration)
+	return res, nil
+}
+
+// StartJoin roda o JoinCluster como job em background ("bootstrap").
+func (r *Router) StartJoin() *jobs.Job {
+	r.SetBootstrapping(true)
+	return r.jobs.Start("bootstrap", "ranges", func(ctx context.Context, job *jobs.Job) error {
+		res, err := r.JoinCluster(ctx, job)
+		if res != nil {
+			job.SetDetail(*res)
+		}
+		// se falhar, o nó fica fora da readiness até um restart refazer o
+		// bootstrap
+		if err == nil {
+			r.SetBootstrapping(false)
+		}
+		return err
+	})
+}
@@ -0,0 +1,423 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/kv"
+)
+
+// Configurações de runtime do cluster: alguns ajustes que antes só mudavam
+// editando o env de cada nó e reiniciando (chance de read repair, taxas do
+// replay de hints e do anti-entropy, timeout de réplica, rebaixamento de nós
+// lentos) mudam no cluster inteiro com uma chamada a /admin/settings. Como
+// os schemas, cada configuração é versionada por UpdatedAt (a mais nova
+// ganha), vai por broadcast para os nós do ring e chega aos que estavam fora
+// do ar pelo RunSettingsSync. O valor do env é o de boot: o do cluster vale
+// mais que ele e o reset (DELETE) volta cada nó ao seu.
+
+// SettingsPath é o endpoint interno das configurações de runtime: GET lista
+// as definições do nó (com os resets), POST aplica uma.
+const SettingsPath = "/internal/settings"
+
+// DefaultSettingsSyncInterval é de quanto em quanto tempo o nó busca as
+// configurações dos outros.
+const DefaultSettingsSyncInterval = 30 * time.Second
+
+// ErrUnknownSetting: não há configuração de runtime com esse nome.
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// ErrInvalidSetting: o valor não serve para a configuração.
+var ErrInvalidSetting = errors.New("invalid setting value")
+
+// ClusterSetting é o valor de uma configuração definido para o cluster.
+// Deleted é o reset (cada nó volta ao valor do env), que também se propaga.
+type ClusterSetting struct {
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	UpdatedAt int64  `json:"updated_at"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// SettingInfo é uma configuração de runtime como este nó a vê (GET
+// /admin/settings).
+type SettingInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Value é o valor em vigor; BootValue, o que veio do env
+	Value     string `json:"value"`
+	BootValue string `json:"boot_value"`
+	// Source: "env" ou "cluster" (definido por /admin/settings)
+	Source    string `json:"source"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+}
+
+// runtimeSetting é uma configuração que muda com o nó servindo.
+type runtimeSetting struct {
+	name        string
+	description string
+	// parse confere o valor e devolve a forma normalizada (a que é gravada
+	// e propagada)
+	parse func(value string) (string, error)
+	get   func(r *Router) string
+	// set aplica um valor já normalizado por parse
+	set func(r *Router, value string)
+}
+
+var runtimeSettings = []runtimeSetting{
+	{
+		name:        "read_repair_chance",
+		description: "fraction of reads that compare all replicas in the background (READ_REPAIR_CHANCE)",
+		parse:       floatSetting(0, 1),
+		get:         func(r *Router) string { return formatFloat(r.ReadRepairChance()) },
+		set:         func(r *Router, v string) { r.SetReadRepairChance(mustFloat(v)) },
+	},
+	{
+		name:        "replica_timeout",
+		description: "default deadline of each replica call (REPLICA_TIMEOUT)",
+		parse:       durationSetting(time.Millisecond),
+		get:         func(r *Router) string { return r.ReplicaTimeout().String() },
+		set: func(r *Router, v string) {
+			d, _ := time.ParseDuration(v)
+			r.SetRequestTimeouts(d, 0)
+		},
+	},
+	{
+		name:        "hint_replay_rate",
+		description: "hints replayed per second to each node, 0 = unlimited (HINT_REPLAY_RATE); applies from the next replay",
+		parse:       floatSetting(0, math.Inf(1)),
+		get: func(r *Router) string {
+			r.hints.mu.Lock()
+			defer r.hints.mu.Unlock()
+			return formatFloat(r.hints.policy.Rate)
+		},
+		set: func(r *Router, v string) {
+			r.hints.mu.Lock()
+			defer r.hints.mu.Unlock()
+			r.hints.policy.Rate = mustFloat(v)
+		},
+	},
+	{
+		name:        "anti_entropy_max_kb_per_sec",
+		description: "average KB/s of repairs sent by continuous anti-entropy, 0 = unlimited (ANTI_ENTROPY_MAX_KB_PER_SEC)",
+		parse:       intSetting(0),
+		get:         func(r *Router) string { return strconv.FormatInt(r.AntiEntropyMaxRate()>>10, 10) },
+		set: func(r *Router, v string) {
+			kb, _ := strconv.ParseInt(v, 10, 64)
+			r.SetAntiEntropyMaxRate(kb << 10)
+		},
+	},
+	{
+		name:        "slow_peer_latency_factor",
+		description: "demote a node whose latency is this many times the median of the others, 0 = off (SLOW_PEER_LATENCY_FACTOR)",
+		parse:       floatSetting(0, math.Inf(1)),
+		get: func(r *Router) string {
+			r.latency.mu.Lock()
+			defer r.latency.mu.Unlock()
+			return formatFloat(r.latency.policy.LatencyFactor)
+		},
+		set: func(r *Router, v string) {
+			r.latency.mu.Lock()
+			defer r.latency.mu.Unlock()
+			r.latency.policy.LatencyFactor = mustFloat(v)
+		},
+	},
+	{
+		name:        "slow_peer_error_rate",
+		description: "demote a node whose error rate is above this fraction, 0 = off (SLOW_PEER_ERROR_RATE)",
+		parse:       floatSetting(0, 1),
+		get: func(r *Router) string {
+			r.latency.mu.Lock()
+			defer r.latency.mu.Unlock()
+			return formatFloat(r.latency.policy.ErrorRate)
+		},
+		set: func(r *Router, v string) {
+			r.latency.mu.Lock()
+			defer r.latency.mu.Unlock()
+			r.latency.policy.ErrorRate = mustFloat(v)
+		},
+	},
+}
+
+func lookupSetting(name string) (*runtimeSetting, bool) {
+	for i := range runtimeSettings {
+		if runtimeSettings[i].name == name {
+			return &runtimeSettings[i], true
+		}
+	}
+	return nil, false
+}
+
+func floatSetting(min, max float64) func(string) (string, error) {
+	return func(v string) (string, error) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || f < min || f > max {
+			if math.IsInf(max, 1) {
+				return "", fmt.Errorf("%w: %q (want a number >= %s)", ErrInvalidSetting, v, formatFloat(min))
+			}
+			return "", fmt.Errorf("%w: %q (want a number from %s to %s)", ErrInvalidSetting, v, formatFloat(min), formatFloat(max))
+		}
+		return formatFloat(f), nil
+	}
+}
+
+func intSetting(min int64) func(string) (string, error) {
+	return func(v string) (string, error) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < min {
+			return "", fmt.Errorf("%w: %q (want an integer >= %d)", ErrInvalidSetting, v, min)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+func durationSetting(min time.Duration) func(string) (string, error) {
+	return func(v string) (string, error) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < min {
+			return "", fmt.Errorf("%w: %q (want a duration >= %s, like 500ms)", ErrInvalidSetting, v, min)
+		}
+		return d.String(), nil
+	}
+}
+
+func formatFloat(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+func mustFloat(v string) float64 {
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
+
+// settingsState são as definições do cluster que este nó conhece, gravadas
+// em path para voltarem no boot; boot guarda os valores do env.
+type settingsState struct {
+	mu   sync.Mutex
+	path string
+	boot map[string]string
+	defs map[string]ClusterSetting
+}
+
+// LoadSettings guarda os valores atuais (os do env) como os de boot e aplica
+// as definições do cluster gravadas em path. Chamar depois de configurar o
+// router com o env.
+func (r *Router) LoadSettings(path string) error {
+	s := &r.settings
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.boot = make(map[string]string, len(runtimeSettings))
+	s.defs = make(map[string]ClusterSetting)
+	for _, rs := range runtimeSettings {
+		s.boot[rs.name] = rs.get(r)
+	}
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var defs []ClusterSetting
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("settings state %s: %w", path, err)
+	}
+	for _, def := range defs {
+		rs, ok := lookupSetting(def.Name)
+		if !ok {
+			log.Printf("[SETTINGS] ignoring unknown setting %q in %s", def.Name, path)
+			continue
+		}
+		if !def.Deleted {
+			v, err := rs.parse(def.Value)
+			if err != nil {
+				log.Printf("[SETTINGS] ignoring setting %s in %s: %v", def.Name, path, err)
+				continue
+			}
+			def.Value = v
+			rs.set(r, v)
+			log.Printf("[SETTINGS] %s = %s (cluster setting, env value %s)", def.Name, v, s.boot[def.Name])
+		}
+		s.defs[def.Name] = def
+	}
+	return nil
+}
+
+// normalizeSetting confere a definição e normaliza o valor.
+func normalizeSetting(def ClusterSetting) (ClusterSetting, *runtimeSetting, error) {
+	rs, ok := lookupSetting(def.Name)
+	if !ok {
+		return def, nil, fmt.Errorf("%w %q", ErrUnknownSetting, def.Name)
+	}
+	if def.Deleted {
+		def.Value = ""
+		return def, rs, nil
+	}
+	v, err := rs.parse(def.Value)
+	if err != nil {
+		return def, nil, fmt.Errorf("%s: %w", def.Name, err)
+	}
+	def.Value = v
+	return def, rs, nil
+}
+
+// ApplyLocalSetting aplica a definição neste nó se ela for mais nova que a
+// atual da configuração; false se foi ignorada.
+func (r *Router) ApplyLocalSetting(def ClusterSetting) (bool, error) {
+	def, rs, err := normalizeSetting(def)
+	if err != nil {
+		return false, err
+	}
+	s := &r.settings
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.defs == nil {
+		s.defs = make(map[string]ClusterSetting)
+	}
+	if cur, ok := s.defs[def.Name]; ok && cur.UpdatedAt >= def.UpdatedAt {
+		return false, nil
+	}
+	s.defs[def.Name] = def
+	if def.Deleted {
+		if boot, ok := s.boot[def.Name]; ok {
+			rs.set(r, boot)
+		}
+		log.Printf("[SETTINGS] %s reset to the env value %s on node %s", def.Name, rs.get(r), r.nodeID)
+	} else {
+		rs.set(r, def.Value)
+		log.Printf("[SETTINGS] %s = %s on node %s", def.Name, def.Value, r.nodeID)
+	}
+	return true, r.saveSettingsLocked()
+}
+
+// SetSetting muda a configuração em todos os nós do ring; este nó aplica
+// antes, mesmo fora do ring. Um nó fora do ar recebe o valor pelo
+// RunSettingsSync quando voltar.
+func (r *Router) SetSetting(ctx context.Context, name, value string) (ClusterSetting, []NodeResult, error) {
+	return r.publishSetting(ctx, ClusterSetting{Name: name, Value: value, UpdatedAt: kv.Now()})
+}
+
+// ResetSetting volta a configuração ao valor do env em todos os nós.
+func (r *Router) ResetSetting(ctx context.Context, name string) (ClusterSetting, []NodeResult, error) {
+	return r.publishSetting(ctx, ClusterSetting{Name: name, UpdatedAt: kv.Now(), Deleted: true})
+}
+
+func (r *Router) publishSetting(ctx context.Context, def ClusterSetting) (ClusterSetting, []NodeResult, error) {
+	def, _, err := normalizeSetting(def)
+	if err != nil {
+		return def, nil, err
+	}
+	if _, err := r.ApplyLocalSetting(def); err != nil {
+		return def, nil, err
+	}
+	body, _ := json.Marshal(def)
+	return def, r.Broadcast(ctx, "POST", SettingsPath, body), nil
+}
+
+// Settings retorna as configurações de runtime como este nó as vê.
+func (r *Router) Settings() []SettingInfo {
+	s := &r.settings
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]SettingInfo, 0, len(runtimeSettings))
+	for _, rs := range runtimeSettings {
+		info := SettingInfo{Name: rs.name, Description: rs.description, Value: rs.get(r), BootValue: s.boot[rs.name], Source: "env"}
+		if def, ok := s.defs[rs.name]; ok {
+			info.UpdatedAt = def.UpdatedAt
+			if !def.Deleted {
+				info.Source = "cluster"
+			}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// ClusterSettings retorna as definições do cluster deste nó, com os resets
+// (a fonte da sincronização).
+func (r *Router) ClusterSettings() []ClusterSetting {
+	s := &r.settings
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.defsLocked()
+}
+
+func (s *settingsState) defsLocked() []ClusterSetting {
+	defs := make([]ClusterSetting, 0, len(s.defs))
+	for _, def := range s.defs {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+func (r *Router) saveSettingsLocked() error {
+	s := &r.settings
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.defsLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// SyncSettings busca as definições dos nós do ring e aplica as mais novas.
+func (r *Router) SyncSettings(ctx context.Context) int {
+	applied := 0
+	for _, res := range r.Broadcast(ctx, "GET", SettingsPath, nil) {
+		if r.isLocal(res.Node) || !res.OK() {
+			continue
+		}
+		var defs []ClusterSetting
+		if err := json.Unmarshal(res.Body, &defs); err != nil {
+			continue
+		}
+		for _, def := range defs {
+			if ok, err := r.ApplyLocalSetting(def); err != nil {
+				log.Printf("[SETTINGS] ignoring setting %q from %s: %v", def.Name, res.Node.ID, err)
+			} else if ok {
+				applied++
+			}
+		}
+	}
+	return applied
+}
+
+// RunSettingsSync roda SyncSettings a cada interval até ctx terminar (a
+// primeira logo no boot).
+func (r *Router) RunSettingsSync(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSettingsSyncInterval
+	}
+	for {
+		cctx, cancel := context.WithTimeout(ctx, interval)
+		r.SyncSettings(cctx)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/kv"
+)
+
+// streamBatchSize é quantas entradas vão em cada requisição de streaming.
+const streamBatchSize = 500
+
+// StreamStats conta o que foi transferido num streaming.
+type StreamStats struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (s *StreamStats) add(o StreamStats) {
+	s.Keys += o.Keys
+	s.Bytes += o.Bytes
+}
+
+// StreamRangeRequest pede a um nó que envie suas entradas de um intervalo
+// de tokens para Target (corpo de /internal/stream/range).
+type StreamRangeRequest struct {
+	Start  uint32            `json:"start"`
+	End    uint32            `json:"end"`
+	Target hashring.NodeInfo `json:"target"`
+	// MinRF e MaxRF limitam o envio às chaves cujo fator de replicação (o do
+	// keyspace) está entre os dois (0: sem limite): Target só passa a ser
+	// réplica das chaves com esses fatores.
+	MinRF int `json:"min_rf,omitempty"`
+	MaxRF int `json:"max_rf,omitempty"`
+}
+
+// wants diz se as chaves com fator de replicação rf vão no streaming.
+func (q StreamRangeRequest) wants(rf int) bool {
+	return rf >= q.MinRF && (q.MaxRF == 0 || rf <= q.MaxRF)
+}
+
+// localRecords retorna as entradas locais (e tombstones) do intervalo de q
+// com os fatores de replicação dele, todas do mesmo instante (snapshot do
+// store).
+func (r *Router) localRecords(q StreamRangeRequest) []Record {
+	rng := hashring.TokenRange{Start: q.Start, End: q.End}
+	filter := q.MinRF > 0 || q.MaxRF > 0
+	var out []Record
+	snap := r.localStore.Snapshot()
+	defer snap.Close()
+	snap.Iterate("", "", func(key string, e kv.Entry) bool {
+		if rng.Contains(r.ring.Hash(key)) && (!filter || q.wants(r.rfFor(key))) {
+			out = append(out, Record{Key: key, Value: e.Value, Timestamp: e.Timestamp, Deleted: e.Deleted, ExpiresAt: e.ExpiresAt})
+		}
+		return true
+	})
+	return out
+}
+
+// StreamRangeTo envia as entradas locais do intervalo de q para q.Target, em
+// lotes, preservando os timestamps (o destino aplica com last-write-wins).
+func (r *Router) StreamRangeTo(ctx context.Context, q StreamRangeRequest) (StreamStats, error) {
+	var stats StreamStats
+	target := q.Target
+	records := r.localRecords(q)
+	for len(records) > 0 {
+		n := streamBatchSize
+		if n > len(records) {
+			n = len(records)
+		}
+		batch := records[:n]
+		records = records[n:]
+
+		body, _ := json.Marshal(batch)
+		res := r.call(ctx, target, "POST", "/internal/stream/apply", body)
+		if !res.OK() {
+			return stats, fmt.Errorf("stream to %s: %s", target.ID, res.Error())
+		}
+		for _, rec := range batch {
+			stats.Keys++
+			stats.Bytes += int64(len(rec.Key) + len(rec.Value) + 8)
+		}
+	}
+	return stats, nil
+}
+
+// ApplyStream grava localmente as entradas recebidas por streaming e retorna
+// quantas foram aplicadas (as mais antigas que a versão local são ignoradas).
+func (r *Router) ApplyStream(records []Record) int {
+	applied := 0
+	for _, rec := range records {
+		ok := false
+		if rec.Deleted {
+			// tombstone de TTL mantém o ExpiresAt (conta para o gc_grace)
+			ok = r.localStore.Apply(kv.Mutation{Op: kv.OpDelete, Key: rec.Key, Timestamp: rec.Timestamp, ExpiresAt: rec.ExpiresAt})
+		} else {
+			ok = r.localStore.PutExpiring(rec.Key, rec.Value, rec.Timestamp, rec.ExpiresAt)
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied
+}
+
+// requestStream faz source enviar o intervalo de q para q.Target.
+func (r *Router) requestStream(ctx context.Context, source hashring.NodeInfo, q StreamRangeRequest) (StreamStats, error) {
+	if r.isLocal(source) {
+		return r.StreamRangeTo(ctx, q)
+	}
+	body, _ := json.Marshal(q)
+	res := r.call(ctx, source, "POST", "/internal/stream/range", body)
+	if !res.OK() {
+		return StreamStats{}, fmt.Errorf("stream request to %s: %s", source.ID, res.Error())
+	}
+	var stats StreamStats
+	if err := json.Unmarshal(bytes.TrimSpace(res.Body), &stats); err != nil {
+		return StreamStats{}, fmt.Errorf("stream request to %s: %w", source.ID, err)
+	}
+	return stats, nil
+}
+
+// streamFromAny busca o intervalo na primeira das fontes que conseguir enviar.
+func (r *Router) streamFromAny(ctx context.Context, sources []hashring.NodeInfo, q StreamRangeRequest) (StreamStats, error) {
+	rng := hashring.TokenRange{Start: q.Start, End: q.End}
+	target := q.Target
+	var lastErr error
+	for _, src := range sources {
+		if src.ID == target.ID {
+			continue
+		}
+		stats, err := r.requestStream(ctx, src, q)
+		if err == nil {
+			return stats, nil
+		}
+		log.Printf("[STREAM] range (%d,%d] from %s to %s failed: %v", rng.Start, rng.End, src.ID, target.ID, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no source for range (%d,%d]", rng.Start, rng.End)
+	}
+	return StreamStats{}, lastErr
+}
+
+// CleanupLocal remove do store local as chaves (e tombstones) das quais este
+// nó não é mais réplica no ring atual (depois que os novos donos já
+// receberam os dados). As chaves das quais ele é réplica pendente ficam.
+func (r *Router) CleanupLocal() int {
+	removed := 0
+	r.localStore.IterateVersions("", "", func(key string, _ kv.Entry) bool {
+		for _, n := range r.replicasForKey(key) {
+			if r.isLocal(n) {
+				return true
+			}
+		}
+		if r.isPendingReplica(key) {
+			return true
+		}
+		r.localStore.Purge(key)
+		removed++
+		return true
+	})
+	if removed > 0 {
+		log.Printf("[CLEANUP] removed %d keys no longer replicated by %s", removed, r.nodeID)
+	}
+	return removed
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts das chamadas de réplica. Sem prazo do cliente, cada chamada a uma
+// réplica tem até replicaTimeout; com prazo (X-Timeout / ?timeout=), o
+// contexto da requisição carrega o deadline e todas as chamadas da operação
+// dividem esse orçamento, limitado a maxRequestTimeout.
+const (
+	DefaultReplicaTimeout    = 2 * time.Second
+	DefaultMaxRequestTimeout = 30 * time.Second
+)
+
+// SetRequestTimeouts define o timeout padrão de cada chamada de réplica e o
+// maior prazo que um cliente pode pedir (valores <= 0 mantêm o atual).
+// Chamar antes de servir requisições; o timeout de réplica também muda em
+// runtime (replica_timeout em /admin/settings).
+func (r *Router) SetRequestTimeouts(replica, max time.Duration) {
+	if replica > 0 {
+		r.replicaTimeout.Store(int64(replica))
+	}
+	if max > 0 {
+		r.maxRequestTimeout = max
+	}
+}
+
+// ReplicaTimeout retorna o timeout padrão de cada chamada de réplica.
+func (r *Router) ReplicaTimeout() time.Duration {
+	return time.Duration(r.replicaTimeout.Load())
+}
+
+// MaxRequestTimeout retorna o maior prazo aceito de um cliente.
+func (r *Router) MaxRequestTimeout() time.Duration {
+	return r.maxRequestTimeout
+}
+
+// replicaContext é o contexto de uma chamada de réplica: o próprio ctx se
+// ele já tiver deadline, senão ctx com o timeout padrão.
+func (r *Router) replicaContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.ReplicaTimeout())
+}
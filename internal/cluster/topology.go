@@ -0,0 +1,387 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+)
+
+// ringState são as mudanças feitas no ring em runtime (tokens movidos),
+// gravadas em disco para sobreviver a restarts. O ring base continua vindo
+// de CLUSTER_NODES; o estado só diz quem é o dono de cada token movido.
+// Hosts guarda o endereço de nós que não estão em CLUSTER_NODES (ex: um nó
+// que substituiu outro com REPLACE_NODE). Added
+// são tokens aprendidos dos outros nós no boot (ReconcileRing) que não vêm de
+// nenhum nó de CLUSTER_NODES. Members são os nós (com os vnodes deles)
+// aprendidos dos seeds ou que entraram no cluster por eles (SEEDS). VNodes
+// é o número de vnodes dos nós que não têm o padrão (VNODES vezes o peso).
+// Epoch é a época do ring (ver epoch.go).
+type ringState struct {
+	Epoch   uint64                     `json:"epoch,omitempty"`
+	Tokens  map[uint32]hashring.NodeID `json:"tokens"`
+	Hosts   map[hashring.NodeID]string `json:"hosts,omitempty"`
+	Added   map[uint32]hashring.NodeID `json:"added,omitempty"`
+	Members map[hashring.NodeID]string `json:"members,omitempty"`
+	VNodes  map[hashring.NodeID]int    `json:"vnodes,omitempty"`
+}
+
+type topology struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[uint32]hashring.NodeID
+	hosts  map[hashring.NodeID]string
+	added  map[uint32]hashring.NodeID
+	// members: nós que entraram pelos seeds (ver seeds.go)
+	members map[hashring.NodeID]string
+	// vnodes: nós com outro número de vnodes (ver vnodes.go)
+	vnodes map[hashring.NodeID]int
+
+	// progressPath guarda o progresso do streaming de bootstrap
+	progressPath string
+
+	// moveMu garante uma mudança de topologia por vez neste coordenador
+	moveMu sync.Mutex
+}
+
+// nodeByID procura um nó do ring pelo ID.
+func (r *Router) nodeByID(id hashring.NodeID) (hashring.NodeInfo, bool) {
+	for _, n := range r.ring.Nodes() {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return hashring.NodeInfo{}, false
+}
+
+// LoadRingState aplica ao ring os tokens movidos gravados em path.
+// path vazio desliga a persistência; arquivo inexistente não é erro.
+func (r *Router) LoadRingState(path string) error {
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	r.topo.path = path
+	r.topo.tokens = make(map[uint32]hashring.NodeID)
+	r.topo.hosts = make(map[hashring.NodeID]string)
+	r.topo.added = make(map[uint32]hashring.NodeID)
+	r.topo.members = make(map[hashring.NodeID]string)
+	r.topo.vnodes = make(map[hashring.NodeID]int)
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st ringState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("ring state %s: %w", path, err)
+	}
+	r.adoptRingEpochLocked(st.Epoch)
+
+	nodes := make(map[hashring.NodeID]hashring.NodeInfo)
+	for _, n := range r.ring.Nodes() {
+		nodes[n.ID] = n
+	}
+	for id, host := range st.Members {
+		if _, ok := nodes[id]; !ok {
+			n := hashring.NodeInfo{ID: id, Host: host}
+			if id == r.nodeID {
+				n.Host = r.selfHost
+			}
+			r.ring.AddNode(n)
+			nodes[id] = n
+		}
+		r.topo.members[id] = host
+	}
+	for id, n := range st.VNodes {
+		if n < 1 || n > MaxNodeVNodes {
+			log.Printf("[RING] ignoring vnodes of %s: %d out of range", id, n)
+			continue
+		}
+		r.setVNodesLocked(id, n)
+	}
+	for id, host := range st.Hosts {
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = hashring.NodeInfo{ID: id, Host: host}
+		}
+		r.topo.hosts[id] = host
+	}
+	for token, id := range st.Added {
+		n, ok := nodes[id]
+		if !ok {
+			continue
+		}
+		if err := r.ring.AddToken(token, n); err != nil {
+			// o token já veio de CLUSTER_NODES
+			continue
+		}
+		r.topo.added[token] = id
+	}
+	for token, id := range st.Tokens {
+		n, ok := nodes[id]
+		if !ok {
+			log.Printf("[RING] ignoring moved token %d: node %s not in cluster", token, id)
+			continue
+		}
+		if _, err := r.ring.MoveToken(token, n); err != nil {
+			log.Printf("[RING] ignoring moved token %d: %v", token, err)
+			continue
+		}
+		r.topo.tokens[token] = id
+	}
+	if len(r.topo.tokens) > 0 || len(r.topo.added) > 0 || len(r.topo.members) > 0 || len(r.topo.vnodes) > 0 {
+		log.Printf("[RING] loaded %d moved and %d added tokens, %d members and %d vnode counts from %s",
+			len(r.topo.tokens), len(r.topo.added), len(r.topo.members), len(r.topo.vnodes), path)
+	}
+	return nil
+}
+
+// SetStreamProgressFile define onde o progresso do streaming de bootstrap
+// (REPLACE_NODE) é gravado. Vazio desliga a retomada.
+func (r *Router) SetStreamProgressFile(path string) {
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	r.topo.progressPath = path
+}
+
+// saveRingStateLocked grava o estado do ring (chamar com topo.mu).
+func (r *Router) saveRingStateLocked() error {
+	if r.topo.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ringState{Epoch: r.RingEpoch(), Tokens: r.topo.tokens, Hosts: r.topo.hosts, Added: r.topo.added, Members: r.topo.members, VNodes: r.topo.vnodes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.topo.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.topo.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.topo.path)
+}
+
+// resolveNode completa o host de um nó pelo ring (se Host vier vazio).
+func (r *Router) resolveNode(node hashring.NodeInfo) (hashring.NodeInfo, error) {
+	if node.Host != "" {
+		return node, nil
+	}
+	if n, ok := r.nodeByID(node.ID); ok {
+		return n, nil
+	}
+	return node, fmt.Errorf("node %s not in ring", node.ID)
+}
+
+// setOwnerLocked muda o dono de um token (chamar com topo.mu).
+func (r *Router) setOwnerLocked(token uint32, node hashring.NodeInfo) error {
+	if _, err := r.ring.MoveToken(token, node); err != nil {
+		return err
+	}
+	if r.topo.tokens == nil {
+		r.topo.tokens = make(map[uint32]hashring.NodeID)
+		r.topo.hosts = make(map[hashring.NodeID]string)
+	}
+	r.topo.tokens[token] = node.ID
+	r.topo.hosts[node.ID] = node.Host
+	r.ringChanged()
+	return nil
+}
+
+// SetTokenOwner muda localmente o dono de um token e grava o estado.
+// Se node.Host vier vazio, o nó precisa já estar no ring.
+func (r *Router) SetTokenOwner(token uint32, node hashring.NodeInfo) error {
+	node, err := r.resolveNode(node)
+	if err != nil {
+		return err
+	}
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	if err := r.setOwnerLocked(token, node); err != nil {
+		return err
+	}
+	log.Printf("[RING] token %d now owned by %s", token, node.ID)
+	return r.saveRingStateLocked()
+}
+
+// ReplaceTokens passa todos os tokens de dead para node e grava o estado.
+// Retorna quantos tokens mudaram de dono.
+func (r *Router) ReplaceTokens(dead hashring.NodeID, node hashring.NodeInfo) (int, error) {
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	moved := 0
+	for _, t := range r.ring.Ranges() {
+		if t.Owner.ID != dead {
+			continue
+		}
+		if err := r.setOwnerLocked(t.End, node); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("[RING] %d tokens of %s now owned by %s (%s)", moved, dead, node.ID, node.Host)
+	}
+	return moved, r.saveRingStateLocked()
+}
+
+// TokenOwnerRequest é o corpo de /internal/ring/token.
+type TokenOwnerRequest struct {
+	Token uint32          `json:"token"`
+	Node  hashring.NodeID `json:"node"`
+	Host  string          `json:"host,omitempty"`
+}
+
+// MoveResult descreve um move-token concluído.
+type MoveResult struct {
+	Token    uint32      `json:"token"`
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Ranges   []RangeMove `json:"ranges"`
+	Streamed StreamStats `json:"streamed"`
+	CatchUp  StreamStats `json:"catch_up"`
+	Cleaned  int         `json:"cleaned"`
+	Duration string      `json:"duration"`
+}
+
+// streamMoves envia cada trecho que muda de réplicas para os nós que passam
+// a ser réplica dele, buscando os dados em quem já era réplica (menos skip,
+// um nó morto). Os nós são resolvidos nos rings antes e depois da mudança.
+// Com progress, trechos já concluídos são pulados e cada trecho concluído
+// fica registrado. Retorna os trechos que não puderam ser enviados.
+func (r *Router) streamMoves(ctx context.Context, moves []RangeMove, after *hashring.Ring, skip hashring.NodeID, progress *streamProgress, job *jobs.Job) (StreamStats, []error) {
+	nodes := make(map[string]hashring.NodeInfo)
+	for _, ring := range []*hashring.Ring{r.ring, after} {
+		for _, n := range ring.Nodes() {
+			nodes[string(n.ID)] = n
+		}
+	}
+
+	rfs := r.replicationFactors()
+	var total StreamStats
+	var failed []error
+	for _, mv := range moves {
+		if err := ctx.Err(); err != nil {
+			return total, append(failed, err)
+		}
+		if progress.isDone(mv.End) {
+			job.Add(1, 0)
+			continue
+		}
+		ok := true
+		var bytes int64
+		for _, id := range mv.After {
+			q, need := mv.streamFor(id, rfs)
+			if need == 0 {
+				continue
+			}
+			q.Target = nodes[id]
+			// as fontes guardam todas as chaves pedidas: só as réplicas
+			// anteriores com posição menor que o menor fator pedido
+			var sources []hashring.NodeInfo
+			for _, sid := range mv.Before[:min(need, len(mv.Before))] {
+				if n, ok := nodes[sid]; ok && n.ID != skip {
+					sources = append(sources, n)
+				}
+			}
+			stats, err := r.streamFromAny(ctx, sources, q)
+			if err != nil {
+				failed = append(failed, fmt.Errorf("range (%d,%d] to %s: %w", mv.Start, mv.End, id, err))
+				ok = false
+				continue
+			}
+			total.add(stats)
+			bytes += stats.Bytes
+		}
+		job.Add(1, bytes)
+		if ok {
+			if err := progress.markDone(mv.End); err != nil {
+				log.Printf("[STREAM] saving progress failed: %v", err)
+			}
+		}
+	}
+	return total, failed
+}
+
+// MoveToken passa um token de um nó para outro em runtime (enquanto isso as
+// escritas dos trechos afetados também vão para as novas réplicas, ver
+// pending.go):
+//  1. envia os trechos afetados para as novas réplicas;
+//  2. muda o dono do token em todos os nós (e grava o estado);
+//  3. reenvia os trechos (pega escritas feitas durante o passo 1);
+//  4. cada nó apaga as chaves das quais deixou de ser réplica.
+func (r *Router) MoveToken(ctx context.Context, token uint32, to hashring.NodeID) (*MoveResult, error) {
+	r.topo.moveMu.Lock()
+	defer r.topo.moveMu.Unlock()
+	start := time.Now()
+
+	from, ok := r.ring.TokenOwner(token)
+	if !ok {
+		return nil, fmt.Errorf("token %d not in ring", token)
+	}
+	target, ok := r.nodeByID(to)
+	if !ok {
+		return nil, fmt.Errorf("node %s not in ring", to)
+	}
+	if from.ID == target.ID {
+		return nil, fmt.Errorf("token %d is already owned by %s", token, to)
+	}
+
+	after := r.ring.Clone()
+	after.MoveToken(token, target)
+	moves := r.rangeMoves(r.ring, after)
+
+	res := &MoveResult{Token: token, From: string(from.ID), To: string(to), Ranges: moves}
+	log.Printf("[MOVE] token %d: %s -> %s (%d ranges change replicas)", token, from.ID, to, len(moves))
+	defer r.announcePending(ctx, fmt.Sprintf("move-token-%d", token), after)()
+
+	streamed, failed := r.streamMoves(ctx, moves, after, "", nil, nil)
+	res.Streamed = streamed
+	if len(failed) > 0 {
+		return res, fmt.Errorf("streaming before ring change: %v", failed)
+	}
+
+	body, _ := json.Marshal(TokenOwnerRequest{Token: token, Node: to, Host: target.Host})
+	r.advanceRingEpoch()
+	for _, nr := range r.Broadcast(ctx, "POST", "/internal/ring/token", body) {
+		if !nr.OK() {
+			// o ring fica inconsistente até o nó receber a mudança: repetir o
+			// move-token (ou reiniciar o nó depois de corrigir) resolve
+			return res, fmt.Errorf("ring change not applied on %s: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	catchUp, failed := r.streamMoves(ctx, moves, after, "", nil, nil)
+	res.CatchUp = catchUp
+	if len(failed) > 0 {
+		return res, fmt.Errorf("catch-up streaming: %v", failed)
+	}
+
+	for _, nr := range r.Broadcast(ctx, "POST", "/internal/cleanup", nil) {
+		var out struct {
+			Removed int `json:"removed"`
+		}
+		if nr.OK() && json.Unmarshal(nr.Body, &out) == nil {
+			res.Cleaned += out.Removed
+		} else {
+			log.Printf("[MOVE] cleanup on %s failed: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	res.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("[MOVE] token %d moved to %s: streamed=%d keys catch_up=%d cleaned=%d in %s",
+		token, to, res.Streamed.Keys, res.CatchUp.Keys, res.Cleaned, res.Duration)
+	return res, nil
+}
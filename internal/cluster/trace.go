@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"mini-cassandra/hashring"
+)
+
+// Tracing sob demanda (como o "tracing on" do Cassandra): uma requisição de
+// cliente com ?trace=true leva um Trace no contexto e o coordenador registra
+// nele a linha do tempo de cada réplica (enviada, confirmada ou falhou, com a
+// duração). Os últimos traces ficam em memória no coordenador
+// (GET /admin/traces/{id}).
+
+// TraceIDHeader vem na resposta de uma requisição com ?trace=true.
+const TraceIDHeader = "X-MC-Trace-Id"
+
+// maxTraces é quantos traces o coordenador guarda (os mais antigos saem).
+const maxTraces = 100
+
+// TraceEvent é um evento de um trace. Elapsed é o tempo desde o início da
+// requisição; Duration, quando há, é o da chamada à réplica.
+type TraceEvent struct {
+	Elapsed  string `json:"elapsed"`
+	Node     string `json:"node"`
+	Event    string `json:"event"`
+	Duration string `json:"duration,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+
+	at time.Duration
+}
+
+// Trace é a linha do tempo de uma requisição.
+type Trace struct {
+	ID          string       `json:"id"`
+	Coordinator string       `json:"coordinator"`
+	Request     string       `json:"request"`
+	Key         string       `json:"key,omitempty"`
+	Started     time.Time    `json:"started"`
+	Duration    string       `json:"duration,omitempty"`
+	Status      int          `json:"status,omitempty"`
+	Events      []TraceEvent `json:"events"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+type traceKey struct{}
+
+type traceStore struct {
+	mu     sync.Mutex
+	seq    int64
+	traces []*Trace // do mais antigo para o mais novo
+}
+
+// StartTrace cria um trace para a requisição (ex: "PUT /v1/kv/{key}") e o
+// coloca em ctx; FinishTrace o guarda.
+func (r *Router) StartTrace(ctx context.Context, request, key string) (context.Context, *Trace) {
+	r.traces.mu.Lock()
+	r.traces.seq++
+	id := fmt.Sprintf("%s-%d-%d", r.nodeID, time.Now().UnixMilli(), r.traces.seq)
+	r.traces.mu.Unlock()
+
+	now := time.Now()
+	t := &Trace{ID: id, Coordinator: string(r.nodeID), Request: request, Key: key, Started: now.UTC(), Events: []TraceEvent{}, start: now}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// FinishTrace fecha o trace com o status da resposta e o guarda entre os
+// últimos traces do nó.
+func (r *Router) FinishTrace(t *Trace, status int) {
+	t.mu.Lock()
+	t.Status = status
+	t.Duration = time.Since(t.start).String()
+	t.mu.Unlock()
+
+	r.traces.mu.Lock()
+	defer r.traces.mu.Unlock()
+	r.traces.traces = append(r.traces.traces, t)
+	if len(r.traces.traces) > maxTraces {
+		r.traces.traces = r.traces.traces[len(r.traces.traces)-maxTraces:]
+	}
+}
+
+// Trace retorna um trace guardado.
+func (r *Router) Trace(id string) (*Trace, bool) {
+	r.traces.mu.Lock()
+	defer r.traces.mu.Unlock()
+	for _, t := range r.traces.traces {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Traces retorna os traces guardados, do mais novo para o mais antigo.
+func (r *Router) Traces() []*Trace {
+	r.traces.mu.Lock()
+	defer r.traces.mu.Unlock()
+	out := make([]*Trace, 0, len(r.traces.traces))
+	for i := len(r.traces.traces) - 1; i >= 0; i-- {
+		out = append(out, r.traces.traces[i])
+	}
+	return out
+}
+
+// traceFrom retorna o trace da requisição (nil sem ?trace=true; os métodos
+// de *Trace aceitam nil).
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// add registra um evento de node.
+func (t *Trace) add(node hashring.NodeID, event string, d time.Duration, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	at := time.Since(t.start)
+	e := TraceEvent{Elapsed: at.String(), Node: string(node), Event: event, at: at}
+	if d > 0 {
+		e.Duration = d.String()
+	}
+	if format != "" {
+		e.Detail = fmt.Sprintf(format, args...)
+	}
+	t.mu.Lock()
+	t.Events = append(t.Events, e)
+	t.mu.Unlock()
+}
+
+// replicaCall registra o resultado de uma chamada a uma réplica que começou
+// em start.
+func (t *Trace) replicaCall(node hashring.NodeID, start time.Time, err error, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		// a leitura já tinha as respostas exigidas e desistiu desta
+		t.add(node, "cancelled", time.Since(start), "")
+		return
+	case err != nil:
+		t.add(node, "failed", time.Since(start), "%v", err)
+		return
+	}
+	t.add(node, "acked", time.Since(start), format, args...)
+}
+
+// Snapshot copia o trace com os eventos em ordem (para serializar sem
+// corrida com réplicas que respondem depois do fim da requisição).
+func (t *Trace) Snapshot() *Trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := &Trace{ID: t.ID, Coordinator: t.Coordinator, Request: t.Request, Key: t.Key, Started: t.Started, Duration: t.Duration, Status: t.Status}
+	out.Events = append([]TraceEvent{}, t.Events...)
+	sort.SliceStable(out.Events, func(i, j int) bool { return out.Events[i].at < out.Events[j].at })
+	return out
+}
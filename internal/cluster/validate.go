@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"mini-cassandra/hashring"
+)
+
+// TopologyIssue é uma divergência entre a configuração deste nó e o que os
+// peers anunciam. Fatal: servir assim corromperia o roteamento (dois nós com
+// o mesmo ID, réplicas calculadas com outro RF ou outros vnodes).
+type TopologyIssue struct {
+	Node    string `json:"node,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Problem string `json:"problem"`
+	Fatal   bool   `json:"fatal"`
+}
+
+func (i TopologyIssue) String() string {
+	return fmt.Sprintf("%s (%s): %s", i.Node, i.Host, i.Problem)
+}
+
+// TopologyReport é o resultado de ValidateTopology.
+type TopologyReport struct {
+	Checked     int             `json:"checked"`
+	Unreachable []string        `json:"unreachable,omitempty"`
+	Issues      []TopologyIssue `json:"issues,omitempty"`
+}
+
+// Fatal retorna só as divergências que impedem o nó de servir.
+func (rep TopologyReport) Fatal() []TopologyIssue {
+	var out []TopologyIssue
+	for _, i := range rep.Issues {
+		if i.Fatal {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// ValidateTopology confere, pelo handshake de cada nó do ring, que o ID,
+// o endereço, os vnodes e o fator de replicação deste nó batem com o que os
+// peers anunciam, e pelo ring de um peer que nenhum outro nó já está no ar
+// com o ID deste. Nós fora do ar não são conferidos; peers de uma versão que
+// não anuncia endereço, vnodes ou RF no handshake só têm o ID conferido.
+func (r *Router) ValidateTopology(ctx context.Context) TopologyReport {
+	var rep TopologyReport
+	var mu sync.Mutex
+	add := func(node hashring.NodeInfo, fatal bool, format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		rep.Issues = append(rep.Issues, TopologyIssue{Node: string(node.ID), Host: node.Host, Problem: fmt.Sprintf(format, args...), Fatal: fatal})
+	}
+
+	var peers []hashring.NodeInfo
+	for _, n := range r.ring.Nodes() {
+		switch {
+		case n.ID == r.nodeID:
+		case n.Host == r.selfHost:
+			add(n, true, "the ring has %s at this node's address too", n.ID)
+		default:
+			peers = append(peers, n)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range peers {
+		wg.Add(1)
+		go func(node hashring.NodeInfo) {
+			defer wg.Done()
+			hs, err := r.fetchHandshake(ctx, node)
+			mu.Lock()
+			if err != nil {
+				rep.Unreachable = append(rep.Unreachable, string(node.ID))
+				mu.Unlock()
+				return
+			}
+			rep.Checked++
+			mu.Unlock()
+
+			switch {
+			case hs.NodeID == string(r.nodeID):
+				add(node, true, "node there also claims ID %s (two nodes with the same NODE_ID)", r.nodeID)
+			case hs.NodeID != string(node.ID):
+				add(node, true, "node there says it is %s", hs.NodeID)
+			}
+			if hs.Host != "" && hs.Host != node.Host {
+				if hs.Host == r.selfHost {
+					add(node, true, "node advertises this node's address %s", hs.Host)
+				} else {
+					add(node, false, "node advertises address %s", hs.Host)
+				}
+			}
+			if hs.VNodes != 0 && hs.VNodes != r.ring.VNodes() {
+				add(node, true, "node uses %d vnodes, this node %d", hs.VNodes, r.ring.VNodes())
+			}
+			if hs.ReplicationFactor != 0 && hs.ReplicationFactor != r.replicationFactor {
+				add(node, true, "node uses REPLICATION_FACTOR=%d, this node %d", hs.ReplicationFactor, r.replicationFactor)
+			}
+		}(node)
+	}
+	wg.Wait()
+	sort.Strings(rep.Unreachable)
+	sort.SliceStable(rep.Issues, func(i, j int) bool { return rep.Issues[i].Node < rep.Issues[j].Node })
+
+	// um nó com o mesmo ID em outro endereço só aparece no ring dos peers
+	// (no ring local o ID é sempre deste nó)
+	if snap, ok := r.fetchRing(ctx, peers); ok {
+		if host := snap.Hosts[r.nodeID]; host != "" && host != r.selfHost {
+			other := hashring.NodeInfo{ID: r.nodeID, Host: host}
+			if hs, err := r.fetchHandshake(ctx, other); err == nil && hs.NodeID == string(r.nodeID) {
+				add(other, true, "node %s is already running there", r.nodeID)
+			} else {
+				add(other, false, "%s knows this node at %s (not answering as %s)", snap.Node, host, r.nodeID)
+			}
+		}
+	}
+	return rep
+}
+
+// fetchHandshake busca o handshake de um nó.
+func (r *Router) fetchHandshake(ctx context.Context, node hashring.NodeInfo) (Handshake, error) {
+	cctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	var hs Handshake
+	nr := r.call(cctx, node, "GET", HandshakePath, nil)
+	if !nr.OK() {
+		return hs, fmt.Errorf("%s", nr.Error())
+	}
+	if err := json.Unmarshal(nr.Body, &hs); err != nil {
+		return hs, fmt.Errorf("invalid handshake from %s: %w", node.Host, err)
+	}
+	return hs, nil
+}
+
+// LogTopologyReport registra o resultado de ValidateTopology e retorna um
+// erro que lista as divergências fatais.
+func LogTopologyReport(rep TopologyReport) error {
+	for _, i := range rep.Issues {
+		if !i.Fatal {
+			log.Printf("[TOPOLOGY] warning: %s", i)
+		}
+	}
+	fatal := rep.Fatal()
+	if len(fatal) == 0 {
+		log.Printf("[TOPOLOGY] configuration matches %d peers (%d unreachable)", rep.Checked, len(rep.Unreachable))
+		return nil
+	}
+	msgs := make([]string, len(fatal))
+	for i, f := range fatal {
+		msgs[i] = f.String()
+	}
+	return fmt.Errorf("topology mismatch: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/jobs"
+)
+
+// VNodesPath recebe a mudança do número de vnodes de um nó.
+const VNodesPath = "/internal/ring/vnodes"
+
+// MaxNodeVNodes é o maior número de vnodes que um nó pode ter.
+const MaxNodeVNodes = 4096
+
+const vnodesKind = "vnodes"
+
+var (
+	// ErrInvalidVNodes: nó fora do ring ou número de vnodes fora do limite.
+	ErrInvalidVNodes = errors.New("invalid vnodes change")
+	// ErrVNodesRunning: outra mudança de vnodes em andamento neste nó.
+	ErrVNodesRunning = errors.New("a vnodes change is already running")
+)
+
+// VNodesRequest é o corpo de /internal/ring/vnodes.
+type VNodesRequest struct {
+	Node   hashring.NodeID `json:"node"`
+	VNodes int             `json:"vnodes"`
+}
+
+// VNodesDetail é o detalhe do job "vnodes".
+type VNodesDetail struct {
+	Node     string      `json:"node"`
+	From     int         `json:"from"`
+	To       int         `json:"to"`
+	Ranges   int         `json:"ranges"`
+	Streamed StreamStats `json:"streamed"`
+	CatchUp  StreamStats `json:"catch_up"`
+	Cleaned  int         `json:"cleaned"`
+}
+
+// NodeVNodesInfo é o número de vnodes de um nó do ring.
+type NodeVNodesInfo struct {
+	Node   string `json:"node"`
+	VNodes int    `json:"vnodes"`
+	// Tokens: tokens do nó no anel agora (inclui os movidos para ele e
+	// exclui os dele movidos para outros)
+	Tokens int `json:"tokens"`
+}
+
+// NodeVNodes retorna o número de vnodes de cada nó do ring, ordenado por ID.
+func (r *Router) NodeVNodes() []NodeVNodesInfo {
+	tokens := make(map[hashring.NodeID]int)
+	for _, t := range r.ring.Ranges() {
+		tokens[t.Owner.ID]++
+	}
+	nodes := r.ring.Nodes()
+	out := make([]NodeVNodesInfo, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, NodeVNodesInfo{Node: string(n.ID), VNodes: r.ring.NodeVNodes(n.ID), Tokens: tokens[n.ID]})
+	}
+	return out
+}
+
+// setVNodesLocked muda o número de vnodes de um nó no ring local e o guarda
+// no estado (chamar com topo.mu). Quem tem o número padrão sai do estado.
+func (r *Router) setVNodesLocked(id hashring.NodeID, n int) (added, removed int) {
+	added, removed = r.ring.SetNodeVNodes(id, n)
+	if r.topo.vnodes == nil {
+		r.topo.vnodes = make(map[hashring.NodeID]int)
+	}
+	if n == r.ring.VNodes()*r.ring.Weight(id) {
+		delete(r.topo.vnodes, id)
+	} else {
+		r.topo.vnodes[id] = n
+	}
+	if added > 0 || removed > 0 {
+		r.ringChanged()
+	}
+	return added, removed
+}
+
+// SetNodeVNodes muda localmente o número de vnodes de um nó e grava o
+// estado do ring.
+func (r *Router) SetNodeVNodes(id hashring.NodeID, n int) error {
+	if n < 1 || n > MaxNodeVNodes {
+		return fmt.Errorf("%w: vnodes must be between 1 and %d", ErrInvalidVNodes, MaxNodeVNodes)
+	}
+	r.topo.mu.Lock()
+	defer r.topo.mu.Unlock()
+	from := r.ring.NodeVNodes(id)
+	added, removed := r.setVNodesLocked(id, n)
+	log.Printf("[RING] node %s now has %d vnodes (was %d): %d tokens added, %d removed", id, n, from, added, removed)
+	return r.saveRingStateLocked()
+}
+
+// StartVNodesChange inicia o job que muda o número de vnodes de um nó em
+// todo o cluster, como um move-token dos tokens ganhos ou perdidos:
+//  1. anuncia o ring novo como intervalos pendentes (as escritas também vão
+//     para as novas réplicas) e envia os trechos que mudam de réplicas;
+//  2. muda os vnodes do nó em todos os nós (e grava o estado);
+//  3. reenvia os trechos (pega escritas que não chegaram às novas réplicas);
+//  4. cada nó apaga as chaves das quais deixou de ser réplica e os
+//     intervalos pendentes saem.
+//
+// Só os tokens do fim ("<id>#<i>" com i entre os dois números) entram ou
+// saem do anel, então o resto dos intervalos não muda de dono.
+func (r *Router) StartVNodesChange(id hashring.NodeID, n int) (jobs.Info, error) {
+	if n < 1 || n > MaxNodeVNodes {
+		return jobs.Info{}, fmt.Errorf("%w: vnodes must be between 1 and %d", ErrInvalidVNodes, MaxNodeVNodes)
+	}
+	if _, ok := r.nodeByID(id); !ok {
+		return jobs.Info{}, fmt.Errorf("%w: node %s not in ring", ErrInvalidVNodes, id)
+	}
+	from := r.ring.NodeVNodes(id)
+	if from == n {
+		return jobs.Info{}, fmt.Errorf("%w: node %s already has %d vnodes", ErrInvalidVNodes, id, n)
+	}
+	if running, ok := r.jobs.Running(vnodesKind); ok {
+		return jobs.Info{}, fmt.Errorf("%w (%s)", ErrVNodesRunning, running.ID())
+	}
+	detail := VNodesDetail{Node: string(id), From: from, To: n}
+	job := r.jobs.Start(vnodesKind, "ranges", func(ctx context.Context, job *jobs.Job) error {
+		return r.changeVNodes(ctx, job, id, detail)
+	})
+	job.SetDetail(detail)
+	return job.Info(), nil
+}
+
+func (r *Router) changeVNodes(ctx context.Context, job *jobs.Job, id hashring.NodeID, detail VNodesDetail) error {
+	r.topo.moveMu.Lock()
+	defer r.topo.moveMu.Unlock()
+	start := time.Now()
+
+	after, err := r.applyChange(TopologyChange{VNodes: map[hashring.NodeID]int{id: detail.To}})
+	if err != nil {
+		return err
+	}
+	moves := r.rangeMoves(r.ring, after)
+	detail.Ranges = len(moves)
+	job.SetTotal(int64(2 * len(moves)))
+	job.SetDetail(detail)
+	log.Printf("[VNODES] %s: node %s from %d to %d vnodes (%d ranges change replicas)", job.ID(), id, detail.From, detail.To, len(moves))
+	defer r.announcePending(ctx, job.ID(), after)()
+
+	streamed, failed := r.streamMoves(ctx, moves, after, "", nil, job)
+	detail.Streamed = streamed
+	job.SetDetail(detail)
+	if len(failed) > 0 {
+		return fmt.Errorf("streaming before ring change: %v", failed)
+	}
+
+	body, _ := json.Marshal(VNodesRequest{Node: id, VNodes: detail.To})
+	r.advanceRingEpoch()
+	for _, nr := range r.Broadcast(ctx, "POST", VNodesPath, body) {
+		if !nr.OK() {
+			// como no move-token: o nó que não recebeu a mudança a aprende
+			// do ring de um peer no próximo boot (RING_SYNC_ON_START), ou
+			// repetir a mudança resolve
+			return fmt.Errorf("ring change not applied on %s: %s", nr.Node.ID, nr.Error())
+		}
+	}
+
+	catchUp, failed := r.streamMoves(ctx, moves, after, "", nil, job)
+	detail.CatchUp = catchUp
+	job.SetDetail(detail)
+	if len(failed) > 0 {
+		return fmt.Errorf("catch-up streaming: %v", failed)
+	}
+
+	for _, nr := range r.Broadcast(ctx, "POST", "/internal/cleanup", nil) {
+		var out struct {
+			Removed int `json:"removed"`
+		}
+		if nr.OK() && json.Unmarshal(nr.Body, &out) == nil {
+			detail.Cleaned += out.Removed
+		} else {
+			log.Printf("[VNODES] cleanup on %s failed: %s", nr.Node.ID, nr.Error())
+		}
+	}
+	job.SetDetail(detail)
+	log.Printf("[VNODES] %s finished: node %s has %d vnodes: streamed=%d keys catch_up=%d cleaned=%d in %s",
+		job.ID(), id, detail.To, detail.Streamed.Keys, detail.CatchUp.Keys, detail.Cleaned, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,120 @@
+package hll
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Precision define 2^Precision registradores (16384, ~16KB por sketch),
+// com erro padrão de ~0.8%.
+const Precision = 14
+
+const numRegisters = 1 << Precision
+
+// Sketch é um HyperLogLog: estima quantos elementos distintos foram
+// adicionados usando memória fixa. Seguro para uso concorrente.
+type Sketch struct {
+	mu  sync.Mutex
+	reg []uint8
+}
+
+func New() *Sketch {
+	return &Sketch{reg: make([]uint8, numRegisters)}
+}
+
+// hash64 é FNV-1a de 64 bits seguido do finalizador do murmur3, pra espalhar
+// bem os bits de chaves curtas e parecidas.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Add registra um elemento.
+func (s *Sketch) Add(item string) {
+	x := hash64(item)
+	idx := x >> (64 - Precision)
+	// posição do primeiro bit 1 nos bits restantes (1-based)
+	rank := uint8(bits.LeadingZeros64(x<<Precision|1<<(Precision-1)) + 1)
+
+	s.mu.Lock()
+	if rank > s.reg[idx] {
+		s.reg[idx] = rank
+	}
+	s.mu.Unlock()
+}
+
+// Merge une outro sketch a este (o resultado estima a união dos dois).
+func (s *Sketch) Merge(other *Sketch) {
+	other.mu.Lock()
+	regs := append([]uint8(nil), other.reg...)
+	other.mu.Unlock()
+	s.mergeRegisters(regs)
+}
+
+func (s *Sketch) mergeRegisters(regs []uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range regs {
+		if r > s.reg[i] {
+			s.reg[i] = r
+		}
+	}
+}
+
+// Registers retorna uma cópia dos registradores (para enviar a outro nó).
+func (s *Sketch) Registers() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.reg...)
+}
+
+// MergeRegisters une ao sketch registradores recebidos de outro nó.
+func (s *Sketch) MergeRegisters(regs []byte) error {
+	if len(regs) != numRegisters {
+		return fmt.Errorf("hll: expected %d registers, got %d", numRegisters, len(regs))
+	}
+	s.mergeRegisters(regs)
+	return nil
+}
+
+// Reset zera o sketch.
+func (s *Sketch) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reg = make([]uint8, numRegisters)
+}
+
+// Estimate retorna o número estimado de elementos distintos.
+func (s *Sketch) Estimate() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := float64(numRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+
+	// cardinalidades pequenas: linear counting é bem mais preciso
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
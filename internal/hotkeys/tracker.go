@@ -0,0 +1,166 @@
+package hotkeys
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Op é o tipo de acesso registrado.
+type Op int
+
+const (
+	Read Op = iota
+	Write
+)
+
+// Key é uma chave quente com suas taxas estimadas (acessos por segundo).
+type Key struct {
+	Key          string  `json:"key"`
+	ReadsPerSec  float64 `json:"reads_per_sec"`
+	WritesPerSec float64 `json:"writes_per_sec"`
+	TotalPerSec  float64 `json:"total_per_sec"`
+}
+
+type counter struct {
+	reads, writes float64
+}
+
+// Tracker amostra acessos por chave e mantém só as chaves mais acessadas
+// (Space-Saving: quando a tabela enche, a chave menos acessada é descartada
+// e a nova herda a contagem dela, então memória é fixa e as quentes ficam).
+// As taxas são calculadas sobre a janela anterior mais a atual.
+type Tracker struct {
+	mu       sync.Mutex
+	rate     float64 // fração dos acessos amostrados (0 < rate <= 1)
+	capacity int
+	window   time.Duration
+
+	start   time.Time
+	cur     map[string]*counter
+	prev    map[string]*counter
+	prevDur time.Duration
+}
+
+// New cria um tracker que amostra a fração rate dos acessos e guarda até
+// capacity chaves por janela.
+func New(rate float64, capacity int, window time.Duration) *Tracker {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	if capacity < 1 {
+		capacity = 1024
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &Tracker{
+		rate:     rate,
+		capacity: capacity,
+		window:   window,
+		start:    time.Now(),
+		cur:      make(map[string]*counter),
+		prev:     make(map[string]*counter),
+	}
+}
+
+// Record registra um acesso (se cair na amostra).
+func (t *Tracker) Record(key string, op Op) {
+	if t.rate < 1 && rand.Float64() >= t.rate {
+		return
+	}
+	weight := 1 / t.rate
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotateLocked(time.Now())
+
+	c, ok := t.cur[key]
+	if !ok {
+		c = t.admitLocked(key)
+	}
+	if op == Read {
+		c.reads += weight
+	} else {
+		c.writes += weight
+	}
+}
+
+// admitLocked abre espaço para uma chave nova, descartando a menos acessada
+// quando a tabela está cheia.
+func (t *Tracker) admitLocked(key string) *counter {
+	c := &counter{}
+	if len(t.cur) >= t.capacity {
+		var minKey string
+		var min *counter
+		for k, v := range t.cur {
+			if min == nil || v.reads+v.writes < min.reads+min.writes {
+				minKey, min = k, v
+			}
+		}
+		delete(t.cur, minKey)
+		*c = *min
+	}
+	t.cur[key] = c
+	return c
+}
+
+func (t *Tracker) rotateLocked(now time.Time) {
+	elapsed := now.Sub(t.start)
+	if elapsed < t.window {
+		return
+	}
+	if elapsed >= 2*t.window {
+		// ficou uma janela inteira sem acessos: nada a aproveitar
+		t.prev = make(map[string]*counter)
+	} else {
+		t.prev = t.cur
+	}
+	t.prevDur = elapsed
+	t.cur = make(map[string]*counter)
+	t.start = now
+}
+
+// Top retorna as n chaves com mais acessos por segundo.
+func (t *Tracker) Top(n int) []Key {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.rotateLocked(now)
+
+	secs := (t.prevDur + now.Sub(t.start)).Seconds()
+	if secs < 1 {
+		secs = 1
+	}
+
+	sums := make(map[string]counter, len(t.cur)+len(t.prev))
+	for _, m := range []map[string]*counter{t.prev, t.cur} {
+		for k, c := range m {
+			s := sums[k]
+			s.reads += c.reads
+			s.writes += c.writes
+			sums[k] = s
+		}
+	}
+
+	out := make([]Key, 0, len(sums))
+	for k, c := range sums {
+		out = append(out, Key{
+			Key:          k,
+			ReadsPerSec:  c.reads / secs,
+			WritesPerSec: c.writes / secs,
+			TotalPerSec:  (c.reads + c.writes) / secs,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TotalPerSec != out[j].TotalPerSec {
+			return out[i].TotalPerSec > out[j].TotalPerSec
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
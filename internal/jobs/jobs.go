@@ -0,0 +1,273 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status de um job.
+const (
+	Running   = "running"
+	Done      = "done"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// maxFinished limita quantos jobs terminados ficam guardados.
+const maxFinished = 100
+
+// ErrNotFound é retornado para IDs de job desconhecidos.
+var ErrNotFound = errors.New("job not found")
+
+// Info é o estado de um job como aparece em /admin/jobs.
+type Info struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Unit       string     `json:"unit"`
+	Done       int64      `json:"done"`
+	Total      int64      `json:"total"`
+	Bytes      int64      `json:"bytes"`
+	// Throughput em unidades e bytes por segundo desde o início.
+	Rate        float64     `json:"rate"`
+	BytesPerSec float64     `json:"bytes_per_sec"`
+	ETASecs     *float64    `json:"eta_secs,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Detail      interface{} `json:"detail,omitempty"`
+}
+
+// Job é um trabalho em background registrado no Manager. Os métodos de
+// progresso podem ser chamados num *Job nil (não fazem nada), para que o
+// mesmo código rode com ou sem registro.
+type Job struct {
+	mu       sync.Mutex
+	id, kind string
+	unit     string
+	status   string
+	started  time.Time
+	finished time.Time
+	done     int64
+	total    int64
+	bytes    int64
+	err      error
+	detail   interface{}
+	cancel   context.CancelFunc
+}
+
+// ID retorna o ID do job.
+func (j *Job) ID() string {
+	if j == nil {
+		return ""
+	}
+	return j.id
+}
+
+// SetTotal define quantas unidades o job tem ao todo.
+func (j *Job) SetTotal(n int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.total = n
+	j.mu.Unlock()
+}
+
+// Add registra unidades e bytes concluídos.
+func (j *Job) Add(units, bytes int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.done += units
+	j.bytes += bytes
+	j.mu.Unlock()
+}
+
+// SetDetail guarda informações específicas do tipo de job (o valor é
+// exposto como está, então passe uma cópia).
+func (j *Job) SetDetail(v interface{}) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.detail = v
+	j.mu.Unlock()
+}
+
+// Info retorna o estado atual do job.
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := Info{
+		ID:        j.id,
+		Kind:      j.kind,
+		Status:    j.status,
+		StartedAt: j.started,
+		Unit:      j.unit,
+		Done:      j.done,
+		Total:     j.total,
+		Bytes:     j.bytes,
+		Detail:    j.detail,
+	}
+	end := time.Now()
+	if j.status != Running {
+		f := j.finished
+		info.FinishedAt = &f
+		end = f
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if secs := end.Sub(j.started).Seconds(); secs > 0 {
+		info.Rate = float64(j.done) / secs
+		info.BytesPerSec = float64(j.bytes) / secs
+	}
+	if j.status == Running && info.Rate > 0 && j.total > j.done {
+		eta := float64(j.total-j.done) / info.Rate
+		info.ETASecs = &eta
+	}
+	return info
+}
+
+// Manager registra os jobs de um nó.
+type Manager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager() *Manager {
+	return &Manager{jobs: make(map[string]*Job)}
+}
+
+// Start registra e roda fn em background. unit descreve o que Done/Total
+// contam (ex: "ranges", "keys"). Cancelar o job cancela o ctx de fn.
+func (m *Manager) Start(kind, unit string, fn func(ctx context.Context, j *Job) error) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		id:      fmt.Sprintf("%s-%d", kind, time.Now().UnixMicro()),
+		kind:    kind,
+		unit:    unit,
+		status:  Running,
+		started: time.Now().UTC(),
+		cancel:  cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[j.id] = j
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	log.Printf("[JOBS] %s started", j.id)
+	go func() {
+		err := fn(ctx, j)
+		j.mu.Lock()
+		j.finished = time.Now().UTC()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			j.status = Cancelled
+			j.err = err
+		case err != nil:
+			j.status = Failed
+			j.err = err
+		default:
+			j.status = Done
+		}
+		status := j.status
+		j.mu.Unlock()
+		cancel()
+		log.Printf("[JOBS] %s %s (err=%v)", j.id, status, err)
+	}()
+	return j
+}
+
+// pruneLocked descarta os jobs terminados mais antigos além de maxFinished.
+func (m *Manager) pruneLocked() {
+	var finished []*Job
+	for _, j := range m.jobs {
+		j.mu.Lock()
+		if j.status != Running {
+			finished = append(finished, j)
+		}
+		j.mu.Unlock()
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].started.Before(finished[b].started) })
+	for _, j := range finished[:len(finished)-maxFinished] {
+		delete(m.jobs, j.id)
+	}
+}
+
+// Running retorna o job em andamento do tipo kind, se houver.
+func (m *Manager) Running(kind string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range m.jobs {
+		j.mu.Lock()
+		running := j.kind == kind && j.status == Running
+		j.mu.Unlock()
+		if running {
+			return j, true
+		}
+	}
+	return nil, false
+}
+
+// Get retorna o estado de um job.
+func (m *Manager) Get(id string) (Info, bool) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Info{}, false
+	}
+	return j.Info(), true
+}
+
+// List retorna os jobs (opcionalmente só de um tipo), do mais recente para o
+// mais antigo.
+func (m *Manager) List(kind string) []Info {
+	m.mu.Lock()
+	all := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		all = append(all, j)
+	}
+	m.mu.Unlock()
+
+	out := make([]Info, 0, len(all))
+	for _, j := range all {
+		info := j.Info()
+		if kind == "" || info.Kind == kind {
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].StartedAt.After(out[b].StartedAt) })
+	return out
+}
+
+// Cancel pede o cancelamento de um job em andamento.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	j.mu.Lock()
+	running := j.status == Running
+	j.mu.Unlock()
+	if !running {
+		return fmt.Errorf("job %s is not running", id)
+	}
+	log.Printf("[JOBS] cancelling %s", id)
+	j.cancel()
+	return nil
+}
@@ -0,0 +1,455 @@
+// Package jsonschema valida documentos JSON contra um subconjunto do JSON
+// Schema (draft 2020-12): type, enum, const, properties, required,
+// additionalProperties, items, minItems/maxItems, minLength/maxLength,
+// pattern, minimum/maximum, exclusiveMinimum/exclusiveMaximum,
+// minProperties/maxProperties, allOf, anyOf, oneOf e not. Palavras-chave
+// desconhecidas são ignoradas, como manda a especificação; $ref é recusado
+// na compilação, para um schema que dependa dele não passar a aceitar tudo
+// em silêncio.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors é quantas violações Validate relata no máximo.
+const maxErrors = 20
+
+// Schema é um schema compilado.
+type Schema struct {
+	// always: true = aceita tudo, false = recusa tudo (schemas booleanos);
+	// nil = as regras abaixo
+	always *bool
+
+	types      []string
+	enum       []interface{}
+	constant   interface{}
+	hasConst   bool
+	properties map[string]*Schema
+	required   []string
+	// additional: nil = qualquer propriedade a mais
+	additional *Schema
+	items      *Schema
+
+	minItems, maxItems           *int
+	minLength, maxLength         *int
+	minProperties, maxProperties *int
+	pattern                      *regexp.Regexp
+	minimum, maximum             *big.Rat
+	exclusiveMin, exclusiveMax   *big.Rat
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+var validTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile lê e confere o schema.
+func Compile(raw []byte) (*Schema, error) {
+	doc, err := decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compile(doc, "#")
+}
+
+func compile(doc interface{}, at string) (*Schema, error) {
+	if b, ok := doc.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", at)
+	}
+	s := &Schema{}
+	if _, ok := m["$ref"]; ok {
+		return nil, fmt.Errorf("%s: $ref is not supported", at)
+	}
+
+	if t, ok := m["type"]; ok {
+		switch t := t.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, x := range t {
+				name, ok := x.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s/type: must be a string or a list of strings", at)
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return nil, fmt.Errorf("%s/type: must be a string or a list of strings", at)
+		}
+		for _, name := range s.types {
+			if !validTypes[name] {
+				return nil, fmt.Errorf("%s/type: unknown type %q", at, name)
+			}
+		}
+	}
+	if e, ok := m["enum"]; ok {
+		list, ok := e.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be a list", at)
+		}
+		s.enum = list
+	}
+	if c, ok := m["const"]; ok {
+		s.constant, s.hasConst = c, true
+	}
+
+	if p, ok := m["properties"]; ok {
+		props, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", at)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			c, err := compile(sub, at+"/properties/"+name)
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = c
+		}
+	}
+	if r, ok := m["required"]; ok {
+		list, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be a list of strings", at)
+		}
+		for _, x := range list {
+			name, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must be a list of strings", at)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  **Schema
+	}{{"additionalProperties", &s.additional}, {"items", &s.items}, {"not", &s.not}} {
+		if sub, ok := m[kw.name]; ok {
+			c, err := compile(sub, at+"/"+kw.name)
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = c
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  *[]*Schema
+	}{{"allOf", &s.allOf}, {"anyOf", &s.anyOf}, {"oneOf", &s.oneOf}} {
+		sub, ok := m[kw.name]
+		if !ok {
+			continue
+		}
+		list, ok := sub.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty list of schemas", at, kw.name)
+		}
+		for i, x := range list {
+			c, err := compile(x, fmt.Sprintf("%s/%s/%d", at, kw.name, i))
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = append(*kw.dst, c)
+		}
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  **int
+	}{
+		{"minItems", &s.minItems}, {"maxItems", &s.maxItems},
+		{"minLength", &s.minLength}, {"maxLength", &s.maxLength},
+		{"minProperties", &s.minProperties}, {"maxProperties", &s.maxProperties},
+	} {
+		v, ok := m[kw.name]
+		if !ok {
+			continue
+		}
+		n, ok := v.(json.Number)
+		i, err := n.Int64()
+		if !ok || err != nil || i < 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-negative integer", at, kw.name)
+		}
+		k := int(i)
+		*kw.dst = &k
+	}
+	for _, kw := range []struct {
+		name string
+		dst  **big.Rat
+	}{
+		{"minimum", &s.minimum}, {"maximum", &s.maximum},
+		{"exclusiveMinimum", &s.exclusiveMin}, {"exclusiveMaximum", &s.exclusiveMax},
+	} {
+		v, ok := m[kw.name]
+		if !ok {
+			continue
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: must be a number", at, kw.name)
+		}
+		r, ok := new(big.Rat).SetString(n.String())
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: must be a number", at, kw.name)
+		}
+		*kw.dst = r
+	}
+	if p, ok := m["pattern"]; ok {
+		expr, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", at)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+		s.pattern = re
+	}
+	return s, nil
+}
+
+// ValidateJSON valida o documento JSON data e retorna as violações (no
+// máximo maxErrors), cada uma com o caminho ($.a.b[2]) do valor.
+func (s *Schema) ValidateJSON(data []byte) []string {
+	doc, err := decode(data)
+	if err != nil {
+		return []string{"$: value is not valid JSON"}
+	}
+	var errs []string
+	s.validate(doc, "$", &errs)
+	return errs
+}
+
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after the document")
+	}
+	return doc, nil
+}
+
+func (s *Schema) validate(v interface{}, at string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		if len(*errs) < maxErrors {
+			*errs = append(*errs, at+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	if s.always != nil {
+		if !*s.always {
+			fail("not allowed")
+		}
+		return
+	}
+
+	if len(s.types) > 0 && !hasType(v, s.types) {
+		fail("must be %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equal(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of the enum values")
+		}
+	}
+	if s.hasConst && !equal(v, s.constant) {
+		fail("must be the const value")
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := x[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		if s.minProperties != nil && len(x) < *s.minProperties {
+			fail("must have at least %d properties", *s.minProperties)
+		}
+		if s.maxProperties != nil && len(x) > *s.maxProperties {
+			fail("must have at most %d properties", *s.maxProperties)
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.properties[name]; ok {
+				sub.validate(x[name], at+"."+name, errs)
+			} else if s.additional != nil {
+				if s.additional.always != nil && !*s.additional.always {
+					fail("property %q is not allowed", name)
+					continue
+				}
+				s.additional.validate(x[name], at+"."+name, errs)
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(x) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(x) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range x {
+				s.items.validate(item, fmt.Sprintf("%s[%d]", at, i), errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			fail("must match pattern %q", s.pattern.String())
+		}
+	case json.Number:
+		r, ok := new(big.Rat).SetString(x.String())
+		if !ok {
+			break
+		}
+		if s.minimum != nil && r.Cmp(s.minimum) < 0 {
+			fail("must be >= %s", s.minimum.RatString())
+		}
+		if s.maximum != nil && r.Cmp(s.maximum) > 0 {
+			fail("must be <= %s", s.maximum.RatString())
+		}
+		if s.exclusiveMin != nil && r.Cmp(s.exclusiveMin) <= 0 {
+			fail("must be > %s", s.exclusiveMin.RatString())
+		}
+		if s.exclusiveMax != nil && r.Cmp(s.exclusiveMax) >= 0 {
+			fail("must be < %s", s.exclusiveMax.RatString())
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, at, errs)
+	}
+	if len(s.anyOf) > 0 && countValid(s.anyOf, v) == 0 {
+		fail("must match at least one schema in anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countValid(s.oneOf, v); n != 1 {
+			fail("must match exactly one schema in oneOf (matched %d)", n)
+		}
+	}
+	if s.not != nil && countValid([]*Schema{s.not}, v) == 1 {
+		fail("must not match the schema in not")
+	}
+}
+
+func countValid(schemas []*Schema, v interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		var errs []string
+		sub.validate(v, "$", &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func typeOf(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if isInteger(x) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func hasType(v interface{}, types []string) bool {
+	got := typeOf(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// isInteger: 1, 1.0 e 1e3 são inteiros (o valor conta, não a grafia).
+func isInteger(n json.Number) bool {
+	r, ok := new(big.Rat).SetString(n.String())
+	return ok && r.IsInt()
+}
+
+// equal compara dois valores JSON decodificados (números pelo valor).
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ra, ok1 := new(big.Rat).SetString(x.String())
+		rb, ok2 := new(big.Rat).SetString(y.String())
+		return ok1 && ok2 && ra.Cmp(rb) == 0
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,68 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ErrCorrupt é retornado por CheckedVersion quando a versão guardada de uma
+// chave não bate mais com o checksum calculado na escrita.
+var ErrCorrupt = errors.New("entry failed checksum validation")
+
+// entrySum é o CRC32 de uma versão (chave, timestamp, flags e valor),
+// calculado quando ela entra no store.
+func entrySum(key string, e Entry) uint32 {
+	var hdr [25]byte
+	binary.LittleEndian.PutUint64(hdr[0:], uint64(e.Timestamp))
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(e.ExpiresAt))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(len(key)))
+	if e.Deleted {
+		hdr[24] = 1
+	}
+	h := crc32.NewIEEE()
+	h.Write(hdr[:])
+	h.Write([]byte(key))
+	h.Write([]byte(e.Value))
+	return h.Sum32()
+}
+
+// CheckedVersion é o Version conferindo o checksum da versão: se ela foi
+// corrompida desde a escrita, retorna ErrCorrupt (e a versão não deve ser
+// servida; ver Restore).
+func (s *Store) CheckedVersion(key string) (Entry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.data[key]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	if sum, has := s.sums[key]; has && sum != entrySum(key, e) {
+		return Entry{}, false, ErrCorrupt
+	}
+	if e.Expired(Now()) {
+		return Entry{}, false, nil
+	}
+	return e, true, nil
+}
+
+// Restore troca a versão local de key por e (ok=false: remove a chave), sem
+// comparar timestamps — o timestamp da versão corrompida não é confiável.
+// Passa pelo log como um purge seguido da escrita.
+func (s *Store) Restore(key string, e Entry, ok bool) {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, exists := s.data[key]; exists {
+		s.applyLocked(Mutation{Op: OpPurge, Key: key, Timestamp: cur.Timestamp})
+	}
+	if !ok {
+		return
+	}
+	m := Mutation{Op: OpPut, Key: key, Value: e.Value, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt}
+	if e.Deleted {
+		m = Mutation{Op: OpDelete, Key: key, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt}
+	}
+	s.applyLocked(m)
+}
@@ -0,0 +1,184 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Sets e maps observed-remove (CRDT): o valor da chave é o estado inteiro da
+// coleção. Cada add (ou put de um campo) ganha uma tag única; um remove
+// apaga as tags que o coordenador viu naquele momento, então um add
+// concorrente (com uma tag que ele não viu) sobrevive. O estado de duas
+// réplicas é combinado pela união das tags, em qualquer ordem: por isso um
+// put de CRDT sobre outro CRDT do mesmo tipo é combinado no store em vez de
+// passar pelo last-write-wins, e as coleções convergem sem coordenação.
+//
+// As tags removidas ficam guardadas enquanto a chave existir (senão uma
+// réplica atrasada traria o elemento de volta).
+
+// Tipos de CRDT.
+const (
+	CRDTSet = "orset"
+	CRDTMap = "ormap"
+)
+
+// ErrNotCRDT: a chave guarda um valor que não é um CRDT (ou é de outro tipo).
+var ErrNotCRDT = errors.New("value is not a CRDT of this type")
+
+const crdtPrefix = `{"crdt":"`
+
+// CRDTState é o estado de um set ou map. Adds: elemento (ou campo) -> tag ->
+// valor (vazio num set). Removed: as tags removidas, em ordem.
+type CRDTState struct {
+	Type    string                       `json:"crdt"`
+	Adds    map[string]map[string]string `json:"adds,omitempty"`
+	Removed []string                     `json:"removed,omitempty"`
+}
+
+// IsCRDT diz se value é o estado de um CRDT (sem decodificar o JSON).
+func IsCRDT(value string) bool {
+	return strings.HasPrefix(value, crdtPrefix)
+}
+
+// ParseCRDT decodifica o estado de um CRDT do tipo typ.
+func ParseCRDT(value, typ string) (CRDTState, error) {
+	var st CRDTState
+	if !IsCRDT(value) || json.Unmarshal([]byte(value), &st) != nil || st.Type != typ {
+		return CRDTState{}, ErrNotCRDT
+	}
+	return st, nil
+}
+
+// NewCRDT é um estado vazio do tipo typ.
+func NewCRDT(typ string) CRDTState {
+	return CRDTState{Type: typ, Adds: make(map[string]map[string]string)}
+}
+
+// Encode é o valor gravado (determinístico: o mesmo estado dá o mesmo texto).
+func (st CRDTState) Encode() string {
+	b, _ := json.Marshal(st)
+	return string(b)
+}
+
+var crdtSeq atomic.Uint64
+
+// NewTag gera uma tag única para um add feito por node no instante ts. Tags
+// em ordem de texto seguem a ordem dos instantes.
+func NewTag(node string, ts int64) string {
+	return fmt.Sprintf("%016x-%s-%x", ts, node, crdtSeq.Add(1))
+}
+
+// Add registra elem (com value, nos maps) sob uma tag nova.
+func (st *CRDTState) Add(elem, tag, value string) {
+	if st.Adds == nil {
+		st.Adds = make(map[string]map[string]string)
+	}
+	if st.Adds[elem] == nil {
+		st.Adds[elem] = make(map[string]string)
+	}
+	st.Adds[elem][tag] = value
+}
+
+// Remove marca como removidas as tags vistas nos elementos (as de other, o
+// estado lido). Retorna quantos elementos estavam presentes.
+func (st *CRDTState) Remove(other CRDTState, elems []string) int {
+	removed := 0
+	for _, elem := range elems {
+		tags := other.liveTags(elem)
+		if len(tags) > 0 {
+			removed++
+		}
+		st.Removed = append(st.Removed, tags...)
+	}
+	sort.Strings(st.Removed)
+	return removed
+}
+
+func (st CRDTState) liveTags(elem string) []string {
+	var tags []string
+	for tag := range st.Adds[elem] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Elements são os elementos presentes no set, em ordem.
+func (st CRDTState) Elements() []string {
+	out := make([]string, 0, len(st.Adds))
+	for elem, tags := range st.Adds {
+		if len(tags) > 0 {
+			out = append(out, elem)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Fields são os campos presentes no map. Com puts concorrentes no mesmo
+// campo, vale o da maior tag (o mais recente).
+func (st CRDTState) Fields() map[string]string {
+	out := make(map[string]string, len(st.Adds))
+	for field := range st.Adds {
+		if tags := st.liveTags(field); len(tags) > 0 {
+			out[field] = st.Adds[field][tags[len(tags)-1]]
+		}
+	}
+	return out
+}
+
+// MergeCRDT combina dois estados do mesmo tipo: união das tags adicionadas e
+// das removidas (as removidas saem dos adds).
+func MergeCRDT(a, b CRDTState) (CRDTState, error) {
+	if a.Type != b.Type {
+		return CRDTState{}, ErrNotCRDT
+	}
+	removed := make(map[string]bool, len(a.Removed)+len(b.Removed))
+	for _, t := range a.Removed {
+		removed[t] = true
+	}
+	for _, t := range b.Removed {
+		removed[t] = true
+	}
+	out := NewCRDT(a.Type)
+	for _, st := range []CRDTState{a, b} {
+		for elem, tags := range st.Adds {
+			for tag, v := range tags {
+				if !removed[tag] {
+					out.Add(elem, tag, v)
+				}
+			}
+		}
+	}
+	for t := range removed {
+		out.Removed = append(out.Removed, t)
+	}
+	sort.Strings(out.Removed)
+	return out, nil
+}
+
+// mergeCRDTMutation troca o put m (um CRDT sobre a versão cur, outro CRDT)
+// pelo put do estado combinado. apply=false: o estado não muda. Com tipos
+// diferentes, m segue no last-write-wins.
+func mergeCRDTMutation(cur Entry, m Mutation) (Mutation, bool) {
+	var a, b CRDTState
+	if json.Unmarshal([]byte(cur.Value), &a) != nil || json.Unmarshal([]byte(m.Value), &b) != nil || a.Type != b.Type {
+		return m, true
+	}
+	merged, _ := MergeCRDT(a, b)
+	value := merged.Encode()
+	if value == cur.Value {
+		return m, false
+	}
+	m.Value = value
+	// a versão combinada tem que ganhar da atual
+	if m.Timestamp <= cur.Timestamp {
+		m.Timestamp = cur.Timestamp + 1
+		m.ExpiresAt = cur.ExpiresAt
+	}
+	return m, true
+}
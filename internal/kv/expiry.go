@@ -0,0 +1,205 @@
+package kv
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// Expiração por TTL: as leituras já ignoram entradas expiradas (expiração
+// preguiçosa), mas elas só saem da memória quando o sweeper passa. Para não
+// varrer o store inteiro, as entradas com TTL ficam num heap ordenado pelo
+// instante de expiração; uma entrada sobrescrita deixa o item antigo no heap,
+// que é descartado quando chega a vez dele. O sweeper dorme até o primeiro
+// item do heap vencer (e é acordado se um item novo passar à frente), em vez
+// de acordar a cada intervalo para olhar um heap sem nada vencido.
+//
+// Uma entrada vencida não é removida direto: ela vira tombstone, para que uma
+// réplica com uma versão mais antiga (que perdeu a escrita com TTL) não a
+// ressuscite num repair. Tombstones (de deletes ou de TTL) entram no heap com
+// o fim do gc_grace_seconds do keyspace e só então são removidos.
+
+type expiryItem struct {
+	key string
+	at  int64 // quando agir (Unix em microssegundos)
+	exp int64 // ExpiresAt da entrada quando o item foi criado
+}
+
+type expiryIndex []expiryItem
+
+func (h expiryIndex) Len() int            { return len(h) }
+func (h expiryIndex) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expiryIndex) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryIndex) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+func (h *expiryIndex) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// add indexa a entrada; retorna true se ela passou a ser a primeira a vencer.
+func (h *expiryIndex) add(key string, at, exp int64) bool {
+	first := len(*h) == 0 || at < (*h)[0].at
+	heap.Push(h, expiryItem{key: key, at: at, exp: exp})
+	return first
+}
+
+// addExpiry indexa a entrada e, se ela vence antes de todas as outras, acorda
+// o sweeper para reprogramar o timer (chamado com s.mu).
+func (s *Store) addExpiry(key string, at, exp int64) {
+	if s.expiry.add(key, at, exp) {
+		select {
+		case s.expiryWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// untilNextExpiry é quanto falta para o primeiro item do índice vencer, no
+// máximo limit (também com o índice vazio).
+func (s *Store) untilNextExpiry(limit time.Duration) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.expiry.Len() == 0 {
+		return limit
+	}
+	d := time.Duration(s.expiry[0].at-Now()) * time.Microsecond
+	return max(0, min(d, limit))
+}
+
+// sweepCoalesce é o intervalo mínimo entre duas varreduras.
+const sweepCoalesce = 100 * time.Millisecond
+
+type sweepCounters struct {
+	runs, reclaimed, purged atomic.Int64
+	lastRun                 atomic.Int64 // Unix em microssegundos
+}
+
+// SweepStats são as métricas do sweeper de entradas expiradas.
+type SweepStats struct {
+	Runs             int64      `json:"runs"`
+	Reclaimed        int64      `json:"reclaimed_keys"`
+	TombstonesPurged int64      `json:"tombstones_purged"`
+	Pending          int        `json:"pending_entries"`
+	LastRun          *time.Time `json:"last_run,omitempty"`
+	// NextDue: quando vence o primeiro item do índice (o próximo despertar
+	// do sweeper)
+	NextDue *time.Time `json:"next_due,omitempty"`
+}
+
+// SweepStats retorna as métricas do sweeper. Pending conta os itens do
+// índice de expiração (inclui os de entradas já sobrescritas).
+func (s *Store) SweepStats() SweepStats {
+	s.mu.RLock()
+	pending := s.expiry.Len()
+	var next int64
+	if pending > 0 {
+		next = s.expiry[0].at
+	}
+	s.mu.RUnlock()
+	out := SweepStats{
+		Runs:             s.sweep.runs.Load(),
+		Reclaimed:        s.sweep.reclaimed.Load(),
+		TombstonesPurged: s.sweep.purged.Load(),
+		Pending:          pending,
+	}
+	if next > 0 {
+		t := time.UnixMicro(next).UTC()
+		out.NextDue = &t
+	}
+	if us := s.sweep.lastRun.Load(); us > 0 {
+		t := time.UnixMicro(us).UTC()
+		out.LastRun = &t
+	}
+	return out
+}
+
+// SweepExpired processa até max itens vencidos do índice: entradas expiradas
+// viram tombstones e tombstones com o gc_grace vencido são removidos. Retorna
+// quantas entradas expiraram e quantos tombstones saíram (tudo vai para o WAL).
+func (s *Store) SweepExpired(max int) (expired, purged int) {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := Now()
+	for expired+purged < max && s.expiry.Len() > 0 && s.expiry[0].at <= now {
+		it := heap.Pop(&s.expiry).(expiryItem)
+		e, ok := s.data[it.key]
+		switch {
+		case !ok || e.ExpiresAt != it.exp:
+			// sobrescrita ou removida depois do item: item velho
+		case !e.Deleted:
+			if !e.Expired(now) {
+				continue
+			}
+			s.applyLocked(Mutation{Op: OpDelete, Key: it.key, Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt})
+			expired++
+		case e.DeletionTime()+s.gcGrace.For(KeyspaceOf(it.key)).Microseconds() <= now:
+			s.applyLocked(Mutation{Op: OpPurge, Key: it.key, Timestamp: now})
+			purged++
+		}
+	}
+	if expired+purged > 0 {
+		metrics.Add("ttl.expired", int64(expired))
+		metrics.Add("tombstones.purged", int64(purged))
+	}
+	return expired, purged
+}
+
+// RunExpirySweeper remove as entradas expiradas quando o primeiro item do
+// índice vence, em lotes de batch com uma pausa entre eles para não segurar o
+// lock do store por muito tempo, até ctx terminar. interval é a espera máxima
+// sem nada vencendo (o relógio pode ter mudado); entre duas varreduras há
+// pelo menos sweepCoalesce, para juntar as chaves que vencem quase juntas.
+func (s *Store) RunExpirySweeper(ctx context.Context, interval time.Duration, batch int) {
+	const pause = 10 * time.Millisecond
+	timer := time.NewTimer(s.untilNextExpiry(interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.expiryWake:
+			// um item novo vence antes do que o timer esperava
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.untilNextExpiry(interval))
+			continue
+		case <-timer.C:
+		}
+
+		total, gone := 0, 0
+		for {
+			n, p := s.SweepExpired(batch)
+			total += n
+			gone += p
+			if n+p < batch {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+		s.sweep.runs.Add(1)
+		s.sweep.lastRun.Store(Now())
+		s.sweep.reclaimed.Add(int64(total))
+		s.sweep.purged.Add(int64(gone))
+		if total > 0 || gone > 0 {
+			log.Printf("[TTL] swept %d expired keys, purged %d tombstones past gc_grace", total, gone)
+		}
+		timer.Reset(max(s.untilNextExpiry(interval), sweepCoalesce))
+	}
+}
@@ -0,0 +1,203 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter seleciona entradas numa listagem: regex na chave e predicados em
+// campos do valor JSON (todos precisam valer). Avaliado no nó, antes de a
+// página ser montada.
+type Filter struct {
+	Key   *regexp.Regexp
+	Where []Predicate
+}
+
+// Predicate compara um campo do documento JSON (caminho com pontos, ex:
+// "endereco.cidade") com um valor: Op é =, !=, >, >=, < ou <=.
+type Predicate struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// ops em ordem de tentativa (os de dois caracteres antes)
+var predicateOps = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// ParseFilter monta o filtro de match (regex na chave, vazio = qualquer) e
+// where (predicados "campo<op>valor"). Retorna nil sem nenhum critério.
+func ParseFilter(match string, where []string) (*Filter, error) {
+	if match == "" && len(where) == 0 {
+		return nil, nil
+	}
+	f := &Filter{}
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match: %w", err)
+		}
+		f.Key = re
+	}
+	for _, w := range where {
+		p, err := parsePredicate(w)
+		if err != nil {
+			return nil, err
+		}
+		f.Where = append(f.Where, p)
+	}
+	return f, nil
+}
+
+func parsePredicate(s string) (Predicate, error) {
+	best, at := "", -1
+	for _, op := range predicateOps {
+		if i := strings.Index(s, op); i > 0 && (at < 0 || i < at) {
+			best, at = op, i
+		}
+	}
+	if at < 0 {
+		return Predicate{}, fmt.Errorf("invalid where %q (want field<op>value, op one of = != > >= < <=)", s)
+	}
+	return Predicate{Field: strings.TrimSpace(s[:at]), Op: best, Value: strings.TrimSpace(s[at+len(best):])}, nil
+}
+
+// Match diz se a entrada (não tombstone) passa no filtro. Valores que não
+// são JSON, ou sem o campo, não passam nos predicados.
+func (f *Filter) Match(key string, e Entry) bool {
+	if f == nil {
+		return true
+	}
+	if f.Key != nil && !f.Key.MatchString(key) {
+		return false
+	}
+	if len(f.Where) == 0 {
+		return true
+	}
+	dec := json.NewDecoder(strings.NewReader(ValueBody(e.Value)))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) != nil {
+		return false
+	}
+	for _, p := range f.Where {
+		v, ok := lookupField(doc, p.Field)
+		if !ok || !p.holds(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// NumberField retorna o campo numérico path (caminho com pontos) do valor
+// JSON; ok false se o valor não é JSON ou o campo não existe ou não é número.
+func NumberField(value, path string) (float64, bool) {
+	dec := json.NewDecoder(strings.NewReader(ValueBody(value)))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) != nil {
+		return 0, false
+	}
+	v, ok := lookupField(doc, path)
+	if !ok {
+		return 0, false
+	}
+	n, isNum := v.(json.Number)
+	if !isNum {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// Project monta, com os campos fields (caminhos com pontos) do valor JSON, um
+// objeto novo só com eles, cada um sob o próprio caminho ({"a.b": ...}); os
+// que não existem ficam de fora. ok false se o valor não é JSON.
+func Project(value string, fields []string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(ValueBody(value)))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) != nil {
+		return "", false
+	}
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := lookupField(doc, f); ok {
+			out[f] = v
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+func lookupField(doc interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// holds compara v com p.Value: números como números, true/false/null como
+// os literais JSON e o resto como texto (aspas em volta são opcionais).
+func (p Predicate) holds(v interface{}) bool {
+	var c int
+	switch x := v.(type) {
+	case json.Number:
+		a, err1 := x.Float64()
+		b, err2 := strconv.ParseFloat(p.Value, 64)
+		if err1 != nil || err2 != nil {
+			return p.Op == "!="
+		}
+		c = compareFloat(a, b)
+	case string:
+		c = strings.Compare(x, strings.Trim(p.Value, `"`))
+	case bool, nil:
+		lit := "null"
+		if b, ok := x.(bool); ok {
+			lit = strconv.FormatBool(b)
+		}
+		if p.Op != "=" && p.Op != "!=" {
+			return false
+		}
+		return (lit == p.Value) == (p.Op == "=")
+	default:
+		// objetos e listas só valem no !=
+		return p.Op == "!="
+	}
+	switch p.Op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
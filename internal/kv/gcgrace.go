@@ -0,0 +1,83 @@
+package kv
+
+import "time"
+
+// DefaultGCGrace é o gc_grace_seconds padrão (10 dias, como no Cassandra).
+const DefaultGCGrace = 10 * 24 * time.Hour
+
+// GCGrace é por quanto tempo os tombstones de cada keyspace são guardados
+// antes de poderem ser removidos (sweeper e compactação). Um nó que fica fora
+// mais que isso pode voltar com dados que os outros já apagaram e cujos
+// tombstones não existem mais: ele precisa de um repair antes de voltar.
+type GCGrace struct {
+	Default   time.Duration
+	Keyspaces map[string]time.Duration
+}
+
+// For retorna o gc_grace de um keyspace.
+func (g GCGrace) For(keyspace string) time.Duration {
+	if d, ok := g.Keyspaces[keyspace]; ok {
+		return d
+	}
+	return g.Default
+}
+
+// Min retorna o menor gc_grace configurado (o que limita por quanto tempo um
+// nó pode ficar fora sem risco de ressuscitar dados).
+func (g GCGrace) Min() time.Duration {
+	min := g.Default
+	for _, d := range g.Keyspaces {
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// SetGCGrace configura o gc_grace dos keyspaces. Chamar antes de carregar os
+// dados do disco, para que os tombstones recuperados usem o valor certo.
+func (s *Store) SetGCGrace(g GCGrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcGrace = g
+}
+
+// GCGrace retorna a configuração de gc_grace do store.
+func (s *Store) GCGrace() GCGrace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gcGrace
+}
+
+// PurgeTombstones remove os tombstones do keyspace cujo gc_grace já passou
+// e retorna quantos saíram (usado pela compactação).
+func (s *Store) PurgeTombstones(keyspace string) int {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := Now()
+	grace := s.gcGrace.For(keyspace).Microseconds()
+	purged := 0
+	for k, e := range s.data {
+		if e.Deleted && KeyspaceOf(k) == keyspace && e.DeletionTime()+grace <= now {
+			s.applyLocked(Mutation{Op: OpPurge, Key: k, Timestamp: now})
+			purged++
+		}
+	}
+	return purged
+}
+
+// TombstonesByKeyspace conta os tombstones guardados em cada keyspace.
+func (s *Store) TombstonesByKeyspace() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]int)
+	for k, e := range s.data {
+		if e.Deleted {
+			out[KeyspaceOf(k)]++
+		}
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package kv
+
+import "sort"
+
+// Histórico de versões por chave: com SetHistoryDepth(n), o store guarda as
+// últimas n escritas (puts e deletes) que recebeu de cada chave, inclusive
+// as descartadas por chegarem depois de uma versão mais nova — são elas que
+// mostram escritores em conflito. O histórico fica só em memória (no boot,
+// volta o que estiver no WAL) e sai junto com a chave num purge ou clear.
+
+// HistoryVersion é uma escrita recebida para a chave.
+type HistoryVersion struct {
+	Timestamp int64  `json:"ts"`
+	Value     string `json:"value,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Writer    string `json:"writer,omitempty"`
+	// Discarded: a réplica já tinha uma versão mais nova quando ela chegou
+	Discarded bool `json:"discarded,omitempty"`
+}
+
+// SetHistoryDepth define quantas versões de cada chave são guardadas (0
+// desliga e descarta o histórico atual).
+func (s *Store) SetHistoryDepth(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	s.historyDepth = n
+	if n == 0 {
+		s.history = nil
+	}
+}
+
+// HistoryDepth retorna quantas versões de cada chave são guardadas.
+func (s *Store) HistoryDepth() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.historyDepth
+}
+
+// History retorna as versões guardadas da chave, da mais nova para a mais
+// antiga.
+func (s *Store) History(key string) []HistoryVersion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]HistoryVersion(nil), s.history[key]...)
+}
+
+// recordHistory guarda a escrita m (applied=false: descartada). Chamar com o
+// lock de escrita.
+func (s *Store) recordHistory(m Mutation, applied bool) {
+	if s.historyDepth == 0 {
+		return
+	}
+	switch m.Op {
+	case OpPut, OpDelete:
+	case OpPurge:
+		if applied {
+			delete(s.history, m.Key)
+		}
+		return
+	case OpClear:
+		for k := range s.history {
+			if m.Key == "" || KeyspaceOf(k) == m.Key {
+				delete(s.history, k)
+			}
+		}
+		return
+	default:
+		return
+	}
+
+	v := HistoryVersion{Timestamp: m.Timestamp, Value: m.Value, Deleted: m.Op == OpDelete, ExpiresAt: m.ExpiresAt, Writer: m.Writer, Discarded: !applied}
+	versions := s.history[m.Key]
+	for _, old := range versions {
+		// a mesma versão reenviada (repair, hint): já está no histórico
+		if old.Timestamp == v.Timestamp && old.Deleted == v.Deleted && old.Value == v.Value {
+			return
+		}
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Timestamp < v.Timestamp })
+	if i >= s.historyDepth {
+		return
+	}
+	versions = append(versions, HistoryVersion{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = v
+	if len(versions) > s.historyDepth {
+		versions = versions[:s.historyDepth]
+	}
+	if s.history == nil {
+		s.history = make(map[string][]HistoryVersion)
+	}
+	s.history[m.Key] = versions
+}
@@ -0,0 +1,124 @@
+package kv
+
+import "sort"
+
+// Índice ordenado das chaves: sorted é uma lista ordenada que nunca é
+// alterada depois de pronta (uma junção cria outra), então um iterador pode
+// percorrê-la sem segurar o lock do store. As chaves novas vão para added e
+// só entram em sorted na próxima iteração; as removidas continuam em sorted
+// até lá (o iterador pula as que não estão mais no store).
+type keyIndex struct {
+	sorted  []string
+	added   []string
+	removed int
+}
+
+// iterateChunk é quantas chaves o iterador lê do store por vez (com o lock
+// de leitura), antes de chamar fn fora do lock.
+const iterateChunk = 256
+
+func (x *keyIndex) add(key string) { x.added = append(x.added, key) }
+func (x *keyIndex) remove()        { x.removed++ }
+func (x *keyIndex) reset()         { *x = keyIndex{} }
+
+// stale diz se a lista ordenada precisa ser refeita.
+func (x *keyIndex) stale() bool {
+	return len(x.added) > 0 || x.removed > len(x.sorted)/4
+}
+
+// merge junta added em sorted, tirando as chaves que não estão mais em data.
+// Custa O(n + a·log a) para a chaves novas. Chamar com o lock de escrita.
+func (x *keyIndex) merge(data map[string]Entry) []string {
+	sort.Strings(x.added)
+	out := make([]string, 0, len(data))
+	i, j := 0, 0
+	for i < len(x.sorted) || j < len(x.added) {
+		var k string
+		if j == len(x.added) || (i < len(x.sorted) && x.sorted[i] <= x.added[j]) {
+			k = x.sorted[i]
+			i++
+		} else {
+			k = x.added[j]
+			j++
+		}
+		// a mesma chave pode aparecer nas duas listas (removida e gravada de
+		// novo) ou repetida em added
+		if n := len(out); n > 0 && out[n-1] == k {
+			continue
+		}
+		if _, ok := data[k]; ok {
+			out = append(out, k)
+		}
+	}
+	x.sorted, x.added, x.removed = out, nil, 0
+	return out
+}
+
+// orderedKeys retorna a lista ordenada de chaves atual (imutável).
+func (s *Store) orderedKeys() []string {
+	s.mu.RLock()
+	if !s.index.stale() {
+		keys := s.index.sorted
+		s.mu.RUnlock()
+		return keys
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.index.stale() {
+		return s.index.sorted
+	}
+	return s.index.merge(s.data)
+}
+
+// Iterate chama fn, em ordem, para cada chave em [start, end) (end vazio =
+// até o fim) que não é tombstone nem expirada, até fn retornar false. Não
+// copia o conjunto de chaves: percorre o índice ordenado lendo o store aos
+// poucos, sem segurar o lock enquanto fn roda (fn pode gravar no store).
+// Chaves gravadas depois do início podem não aparecer.
+func (s *Store) Iterate(start, end string, fn func(k, v string) bool) {
+	s.iterate(start, end, false, func(k string, e Entry) bool { return fn(k, e.Value) })
+}
+
+// IterateVersions é o Iterate com as entradas inteiras, inclusive os
+// tombstones (repair, streaming e rebalance).
+func (s *Store) IterateVersions(start, end string, fn func(k string, e Entry) bool) {
+	s.iterate(start, end, true, fn)
+}
+
+func (s *Store) iterate(start, end string, tombstones bool, fn func(k string, e Entry) bool) {
+	keys := s.orderedKeys()
+	i := sort.SearchStrings(keys, start)
+	type item struct {
+		key string
+		e   Entry
+	}
+	chunk := make([]item, 0, iterateChunk)
+	for i < len(keys) {
+		chunk = chunk[:0]
+		done := false
+		s.mu.RLock()
+		now := Now()
+		for ; i < len(keys) && len(chunk) < iterateChunk; i++ {
+			k := keys[i]
+			if end != "" && k >= end {
+				done = true
+				break
+			}
+			e, ok := s.data[k]
+			if !ok || e.Expired(now) || (e.Deleted && !tombstones) {
+				continue
+			}
+			chunk = append(chunk, item{k, e})
+		}
+		s.mu.RUnlock()
+		for _, it := range chunk {
+			if !fn(it.key, it.e) {
+				return
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
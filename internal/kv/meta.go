@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Metadados de valor: o Content-Type e os headers X-Meta-* de um PUT vão
+// junto com o valor, num envelope no começo dele (como o manifesto de um
+// objeto grande). Réplicas, WAL, hints e streaming guardam e transferem o
+// envelope sem saber dele; quem interpreta o valor (filtros, índices,
+// incremento, merge-patch, merges) usa ValueBody.
+
+// metaPrefix marca um valor com metadados: metaPrefix + JSON de ValueMeta +
+// "\x00" + valor. O JSON nunca tem um \x00 cru (o encoder escapa caracteres
+// de controle), então o primeiro depois do prefixo separa o valor.
+const metaPrefix = "\x00mc-meta\x00"
+
+// MaxMetaBytes é o tamanho máximo dos metadados (JSON) de um valor.
+const MaxMetaBytes = 8 << 10
+
+// ValueMeta são os metadados guardados com um valor.
+type ValueMeta struct {
+	ContentType string `json:"content_type,omitempty"`
+	// Headers: os headers X-Meta-<nome> do PUT, com o nome em minúsculas
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Empty diz se não há metadados.
+func (m ValueMeta) Empty() bool {
+	return m.ContentType == "" && len(m.Headers) == 0
+}
+
+// Size é o tamanho do envelope que WithMeta põe na frente do valor.
+func (m ValueMeta) Size() int {
+	b, _ := json.Marshal(m)
+	return len(metaPrefix) + len(b) + 1
+}
+
+// HeaderNames retorna os nomes dos headers em ordem.
+func (m ValueMeta) HeaderNames() []string {
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithMeta monta o valor guardado: value com os metadados na frente. Sem
+// metadados, value fica como está, a não ser que ele mesmo comece com o
+// prefixo do envelope (aí vai num envelope vazio, para não ser confundido).
+func WithMeta(value string, meta ValueMeta) string {
+	if meta.Empty() && !strings.HasPrefix(value, metaPrefix) {
+		return value
+	}
+	b, _ := json.Marshal(meta)
+	return metaPrefix + string(b) + "\x00" + value
+}
+
+// SplitMeta separa um valor guardado no valor do cliente e nos metadados.
+func SplitMeta(stored string) (string, ValueMeta) {
+	rest, ok := strings.CutPrefix(stored, metaPrefix)
+	if !ok {
+		return stored, ValueMeta{}
+	}
+	i := strings.IndexByte(rest, 0)
+	if i < 0 {
+		return stored, ValueMeta{}
+	}
+	var meta ValueMeta
+	if err := json.Unmarshal([]byte(rest[:i]), &meta); err != nil {
+		return stored, ValueMeta{}
+	}
+	return rest[i+1:], meta
+}
+
+// ValueBody é o valor do cliente, sem os metadados.
+func ValueBody(stored string) string {
+	if !strings.HasPrefix(stored, metaPrefix) {
+		return stored
+	}
+	body, _ := SplitMeta(stored)
+	return body
+}
@@ -0,0 +1,44 @@
+package kv
+
+import "strings"
+
+// KeyInfo é uma chave com o tamanho e a versão do valor (listagens).
+type KeyInfo struct {
+	Key       string `json:"key"`
+	Size      int    `json:"size"`
+	Timestamp int64  `json:"ts"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// ScanKeys retorna, em ordem, até limit chaves (sem tombstones nem
+// expiradas) maiores que after e com o prefixo informado, e se há mais
+// depois delas. Percorre o índice ordenado a partir do prefixo e para no
+// fim dele, sem copiar a lista de chaves.
+func (s *Store) ScanKeys(prefix, after string, limit int) (keys []KeyInfo, more bool) {
+	return s.ScanKeysMatching(prefix, after, limit, nil)
+}
+
+// ScanKeysMatching é o ScanKeys só com as entradas que passam em f (nil =
+// todas): as que não passam são puladas sem contar no limit.
+func (s *Store) ScanKeysMatching(prefix, after string, limit int, f *Filter) (keys []KeyInfo, more bool) {
+	start := prefix
+	if after >= start {
+		start = after
+	}
+	keys = make([]KeyInfo, 0)
+	s.IterateVersions(start, "", func(k string, e Entry) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return false
+		}
+		if k == after || e.Deleted || !f.Match(k, e) {
+			return true
+		}
+		if len(keys) == limit {
+			more = true
+			return false
+		}
+		keys = append(keys, KeyInfo{Key: k, Size: len(e.Value), Timestamp: e.Timestamp, ExpiresAt: e.ExpiresAt})
+		return true
+	})
+	return keys, more
+}
@@ -0,0 +1,241 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// ErrNoIndex: o índice secundário não existe neste nó.
+var ErrNoIndex = errors.New("no such index")
+
+// IndexDef declara um índice secundário: as chaves (do Keyspace, ou de
+// todos se vazio) cujo valor é um documento JSON são indexadas pelo valor de
+// Field (caminho com pontos). Só valores escalares entram no índice; numa
+// lista, cada elemento escalar entra.
+type IndexDef struct {
+	Name     string `json:"name"`
+	Keyspace string `json:"keyspace,omitempty"`
+	Field    string `json:"field"`
+}
+
+// IndexInfo é um índice com o tamanho local.
+type IndexInfo struct {
+	IndexDef
+	Terms int `json:"terms"`
+	Keys  int `json:"keys"`
+}
+
+// secondaryIndex é o índice invertido local: termo -> chaves.
+type secondaryIndex struct {
+	def   IndexDef
+	terms map[string]map[string]struct{}
+	keys  int
+}
+
+func (ix *secondaryIndex) covers(key string) bool {
+	return ix.def.Keyspace == "" || KeyspaceOf(key) == ix.def.Keyspace
+}
+
+func (ix *secondaryIndex) add(key string, e Entry) {
+	terms := indexTerms(e.Value, ix.def.Field)
+	if len(terms) > 0 {
+		ix.keys++
+	}
+	for _, t := range terms {
+		set := ix.terms[t]
+		if set == nil {
+			set = make(map[string]struct{})
+			ix.terms[t] = set
+		}
+		set[key] = struct{}{}
+	}
+}
+
+func (ix *secondaryIndex) remove(key string, e Entry) {
+	terms := indexTerms(e.Value, ix.def.Field)
+	if len(terms) > 0 {
+		ix.keys--
+	}
+	for _, t := range terms {
+		if set := ix.terms[t]; set != nil {
+			delete(set, key)
+			if len(set) == 0 {
+				delete(ix.terms, t)
+			}
+		}
+	}
+}
+
+// indexTerms são os termos de value no campo field (nenhum se value não for
+// JSON ou não tiver o campo).
+func indexTerms(value, field string) []string {
+	// só documentos (objetos) têm campos: evita decodificar o resto
+	data := bytes.TrimLeft([]byte(ValueBody(value)), " \t\r\n")
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) != nil {
+		return nil
+	}
+	v, ok := lookupField(doc, field)
+	if !ok {
+		return nil
+	}
+	if list, ok := v.([]interface{}); ok {
+		seen := make(map[string]bool)
+		var out []string
+		for _, item := range list {
+			if t, ok := IndexTerm(item); ok && !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	if t, ok := IndexTerm(v); ok {
+		return []string{t}
+	}
+	return nil
+}
+
+// IndexTerm é o termo de um valor JSON escalar: texto como está, números
+// normalizados (10 e 10.0 são o mesmo termo) e true/false.
+func IndexTerm(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case json.Number:
+		if f, err := x.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64), true
+		}
+		return x.String(), true
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(x), true
+	}
+	return "", false
+}
+
+// QueryTerm é o termo de um valor vindo de uma consulta (texto): números
+// viram o mesmo termo da indexação.
+func QueryTerm(value string) string {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return value
+}
+
+// reindex atualiza os índices secundários depois de key mudar de old (ok =
+// existia) para a versão atual em data. Chamar com o lock de escrita.
+func (s *Store) reindex(key string, old Entry, ok bool) {
+	if len(s.secondary) == 0 {
+		return
+	}
+	cur, exists := s.data[key]
+	for _, ix := range s.secondary {
+		if !ix.covers(key) {
+			continue
+		}
+		if ok && !old.Deleted {
+			ix.remove(key, old)
+		}
+		if exists && !cur.Deleted {
+			ix.add(key, cur)
+		}
+	}
+}
+
+// CreateIndex cria (ou recria, se a definição mudou) um índice secundário e
+// o preenche com as entradas atuais. As escritas esperam o preenchimento.
+func (s *Store) CreateIndex(def IndexDef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ix, ok := s.secondary[def.Name]; ok && ix.def == def {
+		return
+	}
+	ix := &secondaryIndex{def: def, terms: make(map[string]map[string]struct{})}
+	for k, e := range s.data {
+		if !e.Deleted && ix.covers(k) {
+			ix.add(k, e)
+		}
+	}
+	if s.secondary == nil {
+		s.secondary = make(map[string]*secondaryIndex)
+	}
+	s.secondary[def.Name] = ix
+}
+
+// DropIndex remove um índice secundário; false se ele não existia.
+func (s *Store) DropIndex(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secondary[name]; !ok {
+		return false
+	}
+	delete(s.secondary, name)
+	return true
+}
+
+// Indexes lista os índices secundários locais, por nome.
+func (s *Store) Indexes() []IndexInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]IndexInfo, 0, len(s.secondary))
+	for _, ix := range s.secondary {
+		out = append(out, IndexInfo{IndexDef: ix.def, Terms: len(ix.terms), Keys: ix.keys})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// IndexLookup retorna, em ordem, até limit chaves locais (maiores que
+// after) com o termo no índice e se há mais.
+func (s *Store) IndexLookup(name, term, after string, limit int) (keys []string, more bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ix, ok := s.secondary[name]
+	if !ok {
+		return nil, false, ErrNoIndex
+	}
+	set := ix.terms[term]
+	all := make([]string, 0, len(set))
+	for k := range set {
+		if k > after {
+			all = append(all, k)
+		}
+	}
+	sort.Strings(all)
+	now := Now()
+	for _, k := range all {
+		if s.data[k].Expired(now) {
+			continue
+		}
+		if len(keys) == limit {
+			more = true
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys, more, nil
+}
+
+// Matches diz se a versão e da chave está no termo pelo índice d (a
+// conferência do coordenador, com a versão lida das réplicas).
+func (d IndexDef) Matches(key string, e Entry, term string) bool {
+	if e.Deleted || (d.Keyspace != "" && KeyspaceOf(key) != d.Keyspace) {
+		return false
+	}
+	for _, t := range indexTerms(e.Value, d.Field) {
+		if t == term {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package kv
+
+import "sort"
+
+// Snapshot é uma visão imutável do store no instante em que foi criada: as
+// escritas continuam normalmente e não aparecem nela. Não copia os dados —
+// enquanto o snapshot está aberto, a primeira mudança em cada chave guarda a
+// versão anterior nele (copy-on-write), então o custo é proporcional ao que
+// muda durante o uso, não ao tamanho do store. Feche com Close.
+type Snapshot struct {
+	s    *Store
+	keys []string // chaves do store no instante do snapshot, em ordem
+	at   int64    // instante do snapshot (expiração é avaliada nele)
+	// before guarda, para as chaves que mudaram depois do snapshot, a versão
+	// de antes (ok=false: a chave não existia). Protegido por s.mu.
+	before map[string]snapEntry
+}
+
+type snapEntry struct {
+	e  Entry
+	ok bool
+}
+
+// Snapshot abre uma visão consistente do store. As mutações ficam um pouco
+// mais caras enquanto ela estiver aberta.
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.index.sorted
+	if s.index.stale() {
+		keys = s.index.merge(s.data)
+	}
+	snap := &Snapshot{s: s, keys: keys, at: Now(), before: make(map[string]snapEntry)}
+	if s.snapshots == nil {
+		s.snapshots = make(map[*Snapshot]struct{})
+	}
+	s.snapshots[snap] = struct{}{}
+	return snap
+}
+
+// preserve registra nos snapshots abertos a versão atual de key antes de ela
+// mudar. Chamar com o lock de escrita.
+func (s *Store) preserve(key string) {
+	if len(s.snapshots) == 0 {
+		return
+	}
+	cur, ok := s.data[key]
+	for snap := range s.snapshots {
+		if _, seen := snap.before[key]; !seen {
+			snap.before[key] = snapEntry{cur, ok}
+		}
+	}
+}
+
+// Close libera o snapshot (as mutações param de guardar versões para ele).
+func (snap *Snapshot) Close() {
+	snap.s.mu.Lock()
+	defer snap.s.mu.Unlock()
+	delete(snap.s.snapshots, snap)
+	snap.before = nil
+}
+
+// Len é quantas chaves (inclusive tombstones) havia no snapshot.
+func (snap *Snapshot) Len() int {
+	return len(snap.keys)
+}
+
+// lookup retorna a versão de key no snapshot. Chamar com s.mu (leitura).
+func (snap *Snapshot) lookup(key string) (Entry, bool) {
+	if b, ok := snap.before[key]; ok {
+		return b.e, b.ok
+	}
+	e, ok := snap.s.data[key]
+	return e, ok
+}
+
+// Get retorna a versão de key no snapshot (inclusive tombstone; expirada no
+// instante do snapshot = não existe).
+func (snap *Snapshot) Get(key string) (Entry, bool) {
+	i := sort.SearchStrings(snap.keys, key)
+	if i == len(snap.keys) || snap.keys[i] != key {
+		return Entry{}, false
+	}
+	snap.s.mu.RLock()
+	e, ok := snap.lookup(key)
+	snap.s.mu.RUnlock()
+	if !ok || e.Expired(snap.at) {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Iterate chama fn, em ordem, para cada entrada do snapshot (inclusive
+// tombstones) em [start, end) (end vazio = até o fim), até fn retornar
+// false. Como no Store.Iterate, fn roda fora do lock e pode gravar no store.
+func (snap *Snapshot) Iterate(start, end string, fn func(k string, e Entry) bool) {
+	keys := snap.keys
+	i := sort.SearchStrings(keys, start)
+	type item struct {
+		key string
+		e   Entry
+	}
+	chunk := make([]item, 0, iterateChunk)
+	for i < len(keys) {
+		chunk = chunk[:0]
+		done := false
+		snap.s.mu.RLock()
+		for ; i < len(keys) && len(chunk) < iterateChunk; i++ {
+			k := keys[i]
+			if end != "" && k >= end {
+				done = true
+				break
+			}
+			if e, ok := snap.lookup(k); ok && !e.Expired(snap.at) {
+				chunk = append(chunk, item{k, e})
+			}
+		}
+		snap.s.mu.RUnlock()
+		for _, it := range chunk {
+			if !fn(it.key, it.e) {
+				return
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
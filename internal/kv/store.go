@@ -1,45 +1,558 @@
 package kv
 
 import (
+	"log"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/hll"
+)
+
+// Keyspaces: o keyspace de uma chave é o prefixo antes do primeiro ":"
+// (ex: "users:42" pertence a "users"). Chaves sem ":" ficam em DefaultKeyspace.
+const (
+	KeyspaceSep     = ":"
+	DefaultKeyspace = "default"
 )
 
+// KeyspaceOf retorna o keyspace de uma chave.
+func KeyspaceOf(key string) string {
+	if i := strings.Index(key, KeyspaceSep); i > 0 {
+		return key[:i]
+	}
+	return DefaultKeyspace
+}
+
+// Entry é o valor guardado para uma chave, junto com o timestamp da escrita
+// (Unix em microssegundos, como no Cassandra). Um delete é guardado como
+// tombstone (Deleted, sem valor) para não ser desfeito por réplicas que ainda
+// têm a versão antiga.
+type Entry struct {
+	Value     string
+	Timestamp int64
+	Deleted   bool `json:",omitempty"`
+	// ExpiresAt é quando a entrada expira pelo TTL (Unix em microssegundos,
+	// 0 = nunca). Entradas expiradas somem das leituras na hora e são
+	// removidas do store pelo sweeper (ver expiry.go).
+	ExpiresAt int64 `json:",omitempty"`
+}
+
+// Expired diz se a entrada já expirou no instante now (Unix em microssegundos).
+// Tombstones não expiram: uma entrada vencida vira tombstone (com o mesmo
+// ExpiresAt) e fica até passar o gc_grace_seconds do keyspace.
+func (e Entry) Expired(now int64) bool {
+	return !e.Deleted && e.ExpiresAt > 0 && e.ExpiresAt <= now
+}
+
+// DeletionTime é quando um tombstone passou a valer: o fim do TTL, se veio
+// da expiração, ou o timestamp do delete.
+func (e Entry) DeletionTime() int64 {
+	if e.ExpiresAt > 0 {
+		return e.ExpiresAt
+	}
+	return e.Timestamp
+}
+
+// Operações registradas no log de mutações. OpPurge remove a chave sem
+// deixar tombstone (dados que deixaram de pertencer ao nó, tombstones velhos).
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+	OpPurge  = "purge"
+	OpClear  = "clear"
+)
+
+// Mutation descreve uma alteração no store, no formato gravado pelo WAL.
+// Num OpClear, Key é o keyspace a limpar (vazio = todos).
+type Mutation struct {
+	Op        string `json:"op"`
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Timestamp int64  `json:"ts"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Writer    string `json:"writer,omitempty"` // nó coordenador da escrita
+}
+
+// Log recebe cada mutação antes de ela ser aplicada em memória (WAL).
+type Log interface {
+	Append(m Mutation) error
+}
+
+// SyncLog é um Log cuja durabilidade vem depois do Append (fsync em grupo):
+// Sync espera até que o que já foi gravado esteja em disco. O store chama
+// Sync depois de soltar o lock, para escritas concorrentes dividirem o fsync.
+type SyncLog interface {
+	Log
+	Sync() error
+}
+
 type Store struct {
 	mu   sync.RWMutex
-	data map[string]string
+	data map[string]Entry
+	log  Log
+
+	// sums guarda o checksum de cada versão de data (ver checksum.go)
+	sums map[string]uint32
+
+	// secondary são os índices secundários por nome (ver secondary.go)
+	secondary map[string]*secondaryIndex
+
+	// history guarda as últimas historyDepth escritas de cada chave (ver
+	// history.go)
+	history      map[string][]HistoryVersion
+	historyDepth int
+
+	// gate permite congelar as mutações (ex: snapshot coordenado do cluster)
+	gate sync.RWMutex
+
+	// written estima quantas chaves distintas já foram gravadas neste nó
+	written *hll.Sketch
+
+	// tombstones conta as entradas de data que são deletes
+	tombstones int
+
+	// index mantém as chaves de data em ordem (Iterate)
+	index keyIndex
+
+	// snapshots abertos, que recebem a versão anterior de cada chave alterada
+	snapshots map[*Snapshot]struct{}
+
+	// expiry indexa as entradas com TTL pelo instante de expiração e os
+	// tombstones pelo fim do gc_grace
+	expiry expiryIndex
+	sweep  sweepCounters
+	// expiryWake acorda o sweeper quando uma entrada passa a ser a primeira
+	// a vencer
+	expiryWake chan struct{}
+	gcGrace    GCGrace
+
+	// onReplace é avisado quando uma escrita substitui um valor vivo (ver
+	// SetReplaceHook)
+	onReplace func(key string, prev Entry, m Mutation)
 }
 
 func NewStore() *Store {
 	return &Store{
-		data: make(map[string]string),
+		data:       make(map[string]Entry),
+		sums:       make(map[string]uint32),
+		written:    hll.New(),
+		gcGrace:    GCGrace{Default: DefaultGCGrace},
+		expiryWake: make(chan struct{}, 1),
 	}
 }
 
-func (s *Store) Put(key, value string) {
+// WrittenKeys retorna o sketch HyperLogLog das chaves gravadas neste nó
+// (desde o início dos dados em disco; deletes não são descontados).
+func (s *Store) WrittenKeys() *hll.Sketch {
+	return s.written
+}
+
+// SetLog liga o log de mutações. Deve ser chamado depois da recuperação
+// (replay), pra não gravar de novo o que acabou de ser lido.
+func (s *Store) SetLog(l Log) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = value
+	s.log = l
+}
+
+// SetReplaceHook registra fn para ser chamada quando um put ou delete mais
+// novo substitui um valor vivo da chave (prev). fn roda com o lock do store:
+// não pode chamar o store nem demorar.
+func (s *Store) SetReplaceHook(fn func(key string, prev Entry, m Mutation)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReplace = fn
+}
+
+// replaced avisa o hook se m substitui a versão viva cur.
+func (s *Store) replaced(cur Entry, exists bool, m Mutation) {
+	if s.onReplace != nil && exists && !cur.Deleted && (cur.Timestamp < m.Timestamp || cur.Timestamp == m.Timestamp && m.Op == OpDelete) {
+		s.onReplace(m.Key, cur, m)
+	}
+}
+
+// Now retorna o timestamp atual no formato usado pelo store.
+func Now() int64 {
+	return clock.Load().(func() int64)()
+}
+
+// clock é a fonte do Now; SetClock troca.
+var clock atomic.Value
+
+func init() {
+	SetClock(nil)
+}
+
+// SetClock troca o relógio dos timestamps de escrita (e de TTL) de todo o
+// processo: o simulador (pkg/sim) usa um relógio lógico para que a mesma
+// seed produza as mesmas versões. nil volta para time.Now.
+func SetClock(now func() int64) {
+	if now == nil {
+		now = func() int64 { return time.Now().UnixMicro() }
+	}
+	clock.Store(now)
+}
+
+func (s *Store) append(m Mutation) {
+	if s.log == nil {
+		return
+	}
+	if err := s.log.Append(m); err != nil {
+		log.Printf("[WAL] append %s key=%s failed: %v", m.Op, m.Key, err)
+	}
+}
+
+// sync espera o log deixar m em disco, se ele for um SyncLog.
+func (s *Store) sync(l Log, m Mutation) {
+	sl, ok := l.(SyncLog)
+	if !ok {
+		return
+	}
+	if err := sl.Sync(); err != nil {
+		log.Printf("[WAL] sync of %s key=%s failed: %v", m.Op, m.Key, err)
+	}
+}
+
+func (s *Store) Put(key, value string) {
+	s.PutAt(key, value, Now())
+}
+
+// PutAt grava o valor com o timestamp informado (last-write-wins):
+// se já existir uma versão mais nova (ou um tombstone com o mesmo
+// timestamp), a escrita é ignorada e retorna false.
+func (s *Store) PutAt(key, value string, ts int64) bool {
+	return s.Apply(Mutation{Op: OpPut, Key: key, Value: value, Timestamp: ts})
+}
+
+// PutExpiring é o PutAt de uma entrada que expira em expiresAt (0 = nunca).
+func (s *Store) PutExpiring(key, value string, ts, expiresAt int64) bool {
+	return s.Apply(Mutation{Op: OpPut, Key: key, Value: value, Timestamp: ts, ExpiresAt: expiresAt})
 }
 
 func (s *Store) Get(key string) (string, bool) {
+	e, ok := s.GetEntry(key)
+	return e.Value, ok
+}
+
+// GetEntry retorna o valor junto com o timestamp (chaves apagadas ou
+// expiradas não existem).
+func (s *Store) GetEntry(key string) (Entry, bool) {
+	e, ok := s.Version(key)
+	if !ok || e.Deleted {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Version retorna a versão atual da chave, inclusive se for um tombstone
+// (entradas expiradas não existem).
+func (s *Store) Version(key string) (Entry, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, ok := s.data[key]
-	return val, ok
+	e, ok := s.data[key]
+	if !ok || e.Expired(Now()) {
+		return Entry{}, false
+	}
+	return e, true
 }
 
 func (s *Store) Delete(key string) {
+	s.DeleteAt(key, Now())
+}
+
+// DeleteAt grava um tombstone para a chave com o timestamp informado: se
+// houver uma escrita mais nova, o delete é ignorado e retorna false.
+func (s *Store) DeleteAt(key string, ts int64) bool {
+	return s.Apply(Mutation{Op: OpDelete, Key: key, Timestamp: ts})
+}
+
+// Purge remove a chave (valor ou tombstone) sem deixar tombstone.
+func (s *Store) Purge(key string) bool {
+	return s.Apply(Mutation{Op: OpPurge, Key: key, Timestamp: Now()})
+}
+
+// Apply aplica uma mutação (registrando no log, se houver).
+// Put e delete só valem se não houver versão mais nova da chave.
+// Retorna false quando a mutação foi descartada por ser mais antiga.
+func (s *Store) Apply(m Mutation) bool {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.data, key)
+	applied, l := s.applyLocked(m), s.log
+	s.mu.Unlock()
+	if applied {
+		s.sync(l, m)
+	}
+	return applied
 }
 
-func (s *Store) Keys() []string {
+// ApplyDelete é o Apply de um delete que diz também se ele apagou uma entrada
+// viva: existed é falso se a chave não existia, já era um tombstone, tinha
+// expirado ou se o delete foi descartado por uma escrita mais nova. A decisão
+// é tomada sob o mesmo lock da aplicação.
+func (s *Store) ApplyDelete(m Mutation) (applied, existed bool) {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+	s.mu.Lock()
+	cur, ok := s.data[m.Key]
+	live := ok && !cur.Deleted && !cur.Expired(Now())
+	applied, l := s.applyLocked(m), s.log
+	s.mu.Unlock()
+	if applied {
+		s.sync(l, m)
+	}
+	return applied, applied && live
+}
+
+// ApplyIf aplica m só se a versão atual da chave (valor ou tombstone) não for
+// mais nova que maxTS, de forma atômica (base do compare-and-set). Retorna se
+// aplicou e a versão atual quando recusa.
+func (s *Store) ApplyIf(m Mutation, maxTS int64) (bool, Entry) {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+	s.mu.Lock()
+	if cur, ok := s.data[m.Key]; ok && !cur.Expired(Now()) && cur.Timestamp > maxTS {
+		s.mu.Unlock()
+		return false, cur
+	}
+	applied, l := s.applyLocked(m), s.log
+	s.mu.Unlock()
+	if applied {
+		s.sync(l, m)
+	}
+	return applied, Entry{}
+}
+
+func (s *Store) applyLocked(m Mutation) bool {
+	applied := s.applyMutationLocked(m)
+	s.recordHistory(m, applied)
+	return applied
+}
+
+func (s *Store) applyMutationLocked(m Mutation) bool {
+	cur, exists := s.data[m.Key]
+	switch m.Op {
+	case OpPut:
+		if exists && !cur.Deleted && !cur.Expired(Now()) && IsCRDT(cur.Value) && IsCRDT(m.Value) {
+			// CRDTs se combinam em qualquer ordem (ver crdt.go); o log
+			// guarda o estado combinado
+			var apply bool
+			if m, apply = mergeCRDTMutation(cur, m); !apply {
+				return false
+			}
+		}
+		// com o mesmo timestamp o tombstone ganha, em qualquer ordem de
+		// chegada (como na leitura do coordenador)
+		if exists && (cur.Timestamp > m.Timestamp || cur.Timestamp == m.Timestamp && cur.Deleted) {
+			return false
+		}
+		s.append(m)
+		s.preserve(m.Key)
+		if cur.Deleted {
+			s.tombstones--
+		}
+		if !exists {
+			s.index.add(m.Key)
+		}
+		e := Entry{Value: m.Value, Timestamp: m.Timestamp, ExpiresAt: m.ExpiresAt}
+		s.data[m.Key] = e
+		s.sums[m.Key] = entrySum(m.Key, e)
+		s.reindex(m.Key, cur, exists)
+		s.replaced(cur, exists, m)
+		s.written.Add(m.Key)
+		if m.ExpiresAt > 0 {
+			s.addExpiry(m.Key, m.ExpiresAt, m.ExpiresAt)
+		}
+	case OpDelete:
+		if exists && cur.Timestamp > m.Timestamp {
+			return false
+		}
+		s.append(m)
+		s.preserve(m.Key)
+		if !cur.Deleted {
+			s.tombstones++
+		}
+		if !exists {
+			s.index.add(m.Key)
+		}
+		e := Entry{Timestamp: m.Timestamp, Deleted: true, ExpiresAt: m.ExpiresAt}
+		s.data[m.Key] = e
+		s.sums[m.Key] = entrySum(m.Key, e)
+		s.reindex(m.Key, cur, exists)
+		s.replaced(cur, exists, m)
+		s.addExpiry(m.Key, e.DeletionTime()+s.gcGrace.For(KeyspaceOf(m.Key)).Microseconds(), e.ExpiresAt)
+	case OpPurge:
+		if !exists || cur.Timestamp > m.Timestamp {
+			return false
+		}
+		s.append(m)
+		s.preserve(m.Key)
+		if cur.Deleted {
+			s.tombstones--
+		}
+		delete(s.data, m.Key)
+		delete(s.sums, m.Key)
+		s.reindex(m.Key, cur, exists)
+		s.index.remove()
+	case OpClear:
+		s.append(m)
+		if m.Key == "" {
+			if len(s.snapshots) > 0 {
+				for k := range s.data {
+					s.preserve(k)
+				}
+			}
+			s.data = make(map[string]Entry)
+			s.sums = make(map[string]uint32)
+			for _, ix := range s.secondary {
+				ix.terms, ix.keys = make(map[string]map[string]struct{}), 0
+			}
+			s.tombstones = 0
+			s.expiry = expiryIndex{}
+			s.index.reset()
+			break
+		}
+		for k, e := range s.data {
+			if KeyspaceOf(k) == m.Key {
+				s.preserve(k)
+				if e.Deleted {
+					s.tombstones--
+				}
+				delete(s.data, k)
+				delete(s.sums, k)
+				s.reindex(k, e, true)
+				s.index.remove()
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Freeze bloqueia novas mutações (elas esperam) até release ser chamado.
+// Leituras continuam funcionando normalmente.
+func (s *Store) Freeze() (release func()) {
+	s.gate.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(s.gate.Unlock)
+	}
+}
+
+// Entries retorna uma cópia de todas as entradas, sem os tombstones (e sem
+// as expiradas, como todas as leituras).
+func (s *Store) Entries() map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := Now()
+	out := make(map[string]Entry, len(s.data)-s.tombstones)
+	for k, e := range s.data {
+		if !e.Deleted && !e.Expired(now) {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+// Versions retorna uma cópia de todas as entradas, inclusive os tombstones
+// (usada por snapshots, streaming e repair).
+func (s *Store) Versions() map[string]Entry {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	keys := make([]string, 0, len(s.data))
+	now := Now()
+	out := make(map[string]Entry, len(s.data))
+	for k, e := range s.data {
+		if !e.Expired(now) {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+// Clear remove todas as entradas.
+func (s *Store) Clear() {
+	s.Apply(Mutation{Op: OpClear, Timestamp: Now()})
+}
+
+// ClearKeyspace remove todas as entradas de um keyspace.
+func (s *Store) ClearKeyspace(keyspace string) {
+	s.Apply(Mutation{Op: OpClear, Key: keyspace, Timestamp: Now()})
+}
+
+// EntriesIn retorna uma cópia das entradas de um keyspace, sem os tombstones.
+func (s *Store) EntriesIn(keyspace string) map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := Now()
+	out := make(map[string]Entry)
+	for k, e := range s.data {
+		if !e.Deleted && !e.Expired(now) && KeyspaceOf(k) == keyspace {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+// VersionsIn retorna uma cópia das entradas de um keyspace, inclusive os
+// tombstones (usada pelos checkpoints).
+func (s *Store) VersionsIn(keyspace string) map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := Now()
+	out := make(map[string]Entry)
+	for k, e := range s.data {
+		if !e.Expired(now) && KeyspaceOf(k) == keyspace {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+// Keyspaces retorna os keyspaces que têm ao menos uma chave (ou tombstone),
+// ordenados.
+func (s *Store) Keyspaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]struct{})
 	for k := range s.data {
-		keys = append(keys, k)
+		seen[KeyspaceOf(k)] = struct{}{}
+	}
+	out := make([]string, 0, len(seen))
+	for ks := range seen {
+		out = append(out, ks)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Len retorna quantas chaves o store tem (sem contar tombstones; expiradas
+// ainda não varridas pelo sweeper contam).
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data) - s.tombstones
+}
+
+// Tombstones retorna quantos deletes o store está guardando.
+func (s *Store) Tombstones() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tombstones
+}
+
+func (s *Store) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := Now()
+	keys := make([]string, 0, len(s.data)-s.tombstones)
+	for k, e := range s.data {
+		if !e.Deleted && !e.Expired(now) {
+			keys = append(keys, k)
+		}
 	}
 	return keys
 }
@@ -0,0 +1,402 @@
+// Package memcache expõe o cluster no protocolo texto do memcached (get,
+// gets, set, add, replace, cas, delete, touch), para clientes de memcached
+// usarem o mini-cassandra como cache sem mudar de biblioteca.
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+// Version é o que o comando "version" responde.
+const Version = "mini-cassandra"
+
+// Limites do protocolo.
+const (
+	maxKeyLength = 250
+	// DefaultMaxItemBytes é o tamanho máximo de um valor (o padrão do memcached)
+	DefaultMaxItemBytes = 1 << 20
+	// exptime acima disso (30 dias) é um Unix timestamp absoluto
+	relativeExptimeMax = 60 * 60 * 24 * 30
+)
+
+// Config do listener.
+type Config struct {
+	// Keyspace, se não vazio, prefixa as chaves ("<keyspace>:<chave>")
+	Keyspace     string
+	MaxItemBytes int
+	// IdleTimeout fecha conexões sem comandos por esse tempo (0 = nunca)
+	IdleTimeout time.Duration
+}
+
+// Server atende o protocolo do memcached traduzindo cada comando para o
+// Router (com as consistências padrão de leitura e escrita do nó).
+type Server struct {
+	router *cluster.Router
+	hot    *hotkeys.Tracker
+	cfg    Config
+}
+
+func New(r *cluster.Router, hot *hotkeys.Tracker, cfg Config) *Server {
+	if cfg.MaxItemBytes <= 0 {
+		cfg.MaxItemBytes = DefaultMaxItemBytes
+	}
+	return &Server{router: r, hot: hot, cfg: cfg}
+}
+
+// ListenAndServe aceita conexões em addr até o listener falhar.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("[MEMCACHE] Listening on %s (keyspace=%q)", ln.Addr(), s.cfg.Keyspace)
+	return s.Serve(ln)
+}
+
+// Serve atende as conexões de ln.
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// clientError é um erro do comando enviado (CLIENT_ERROR); os demais
+// erros viram SERVER_ERROR.
+type clientError string
+
+func (e clientError) Error() string { return string(e) }
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	metrics.Inc("memcache.connections")
+	rd := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		if s.cfg.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+		}
+		line, err := readLine(rd)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					log.Printf("[MEMCACHE] %s: %v", conn.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		cmd := strings.ToLower(fields[0])
+		if cmd == "quit" {
+			return
+		}
+		reply, err := s.handle(cmd, fields[1:], rd)
+		switch {
+		case errors.As(err, new(clientError)):
+			reply = "CLIENT_ERROR " + err.Error() + "\r\n"
+		case err != nil:
+			log.Printf("[MEMCACHE] %s failed: %v", cmd, err)
+			reply = "SERVER_ERROR " + strings.ReplaceAll(err.Error(), "\r\n", " ") + "\r\n"
+		}
+		if reply != "" {
+			w.WriteString(reply)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readLine lê uma linha de comando (terminada em \r\n ou \n).
+func readLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// handle executa um comando e retorna a resposta ("" com noreply).
+func (s *Server) handle(cmd string, args []string, rd *bufio.Reader) (string, error) {
+	ctx := context.Background()
+	switch cmd {
+	case "get", "gets":
+		return s.get(ctx, args, cmd == "gets")
+	case "set", "add", "replace", "cas":
+		return s.store(ctx, cmd, args, rd)
+	case "delete":
+		return s.delete(ctx, args)
+	case "touch":
+		return s.touch(ctx, args)
+	case "version":
+		return "VERSION " + Version + "\r\n", nil
+	}
+	return "ERROR\r\n", nil
+}
+
+// key valida uma chave do memcached e aplica o prefixo do keyspace.
+func (s *Server) key(k string) (string, error) {
+	if len(k) > maxKeyLength {
+		return "", clientError("key too long")
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return "", clientError("invalid key")
+		}
+	}
+	if s.cfg.Keyspace != "" {
+		return s.cfg.Keyspace + ":" + k, nil
+	}
+	return k, nil
+}
+
+func (s *Server) get(ctx context.Context, keys []string, withCAS bool) (string, error) {
+	if len(keys) == 0 {
+		return "ERROR\r\n", nil
+	}
+	var b strings.Builder
+	for _, k := range keys {
+		key, err := s.key(k)
+		if err != nil {
+			return "", err
+		}
+		metrics.Inc("memcache.get")
+		s.hot.Record(key, hotkeys.Read)
+		e, ok, err := s.router.GetWith(ctx, key, s.router.ReadConsistencyFor(key))
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			metrics.Inc("memcache.get_misses")
+			continue
+		}
+		// flags não são guardadas: sempre 0 (e os metadados de um valor
+		// gravado pela API HTTP ficam de fora)
+		value := kv.ValueBody(e.Value)
+		if withCAS {
+			fmt.Fprintf(&b, "VALUE %s 0 %d %d\r\n", k, len(value), e.Timestamp)
+		} else {
+			fmt.Fprintf(&b, "VALUE %s 0 %d\r\n", k, len(value))
+		}
+		b.WriteString(value)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("END\r\n")
+	return b.String(), nil
+}
+
+// store trata set/add/replace/cas:
+//
+//	<cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]\r\n<data>\r\n
+func (s *Server) store(ctx context.Context, cmd string, args []string, rd *bufio.Reader) (string, error) {
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) < n || len(args) > n+1 {
+		return "ERROR\r\n", nil
+	}
+	noreply := len(args) == n+1 && args[n] == "noreply"
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return "", clientError("bad data chunk")
+	}
+	// o corpo é lido antes de qualquer validação, para não dessincronizar
+	// a conexão
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rd, data); err != nil {
+		return "", err
+	}
+	if string(data[size:]) != "\r\n" {
+		return "", clientError("bad data chunk")
+	}
+	if size > s.cfg.MaxItemBytes {
+		return reply(noreply, "SERVER_ERROR object too large for cache\r\n"), nil
+	}
+	key, err := s.key(args[0])
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(args[1], 10, 32); err != nil {
+		return "", clientError("bad command line format")
+	}
+	ttl, expired, err := parseExptime(args[2])
+	if err != nil {
+		return "", err
+	}
+	value := string(data[:size])
+	metrics.Inc("memcache." + cmd)
+	s.hot.Record(key, hotkeys.Write)
+
+	if cmd == "set" {
+		if expired {
+			_, err = s.router.DeleteWith(ctx, key, s.router.WriteConsistencyFor(key))
+		} else {
+			err = s.router.PutWith(ctx, key, value, s.router.WriteConsistencyFor(key), ttl)
+		}
+		if err != nil {
+			return "", err
+		}
+		return reply(noreply, "STORED\r\n"), nil
+	}
+
+	op := cluster.CASOp{Op: kv.OpPut, Value: value, TTL: ttl.Microseconds()}
+	if expired {
+		op = cluster.CASOp{Op: kv.OpDelete}
+	}
+	switch cmd {
+	case "add":
+		op.IfNotExists = true
+	case "replace":
+		op.IfExists = true
+	case "cas":
+		unique, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil || unique <= 0 {
+			return "", clientError("bad command line format")
+		}
+		op.IfVersion = unique
+	}
+	res, err := s.router.CompareAndSet(ctx, key, s.router.WriteConsistencyFor(key), op)
+	switch {
+	case errors.Is(err, cluster.ErrPreconditionFailed):
+		if cmd == "cas" {
+			if !res.Previous.Found {
+				return reply(noreply, "NOT_FOUND\r\n"), nil
+			}
+			return reply(noreply, "EXISTS\r\n"), nil
+		}
+		return reply(noreply, "NOT_STORED\r\n"), nil
+	case errors.Is(err, cluster.ErrCASConflict):
+		// outra escrita condicional ganhou: para o cliente, a versão mudou
+		return reply(noreply, "EXISTS\r\n"), nil
+	case err != nil:
+		return "", err
+	}
+	return reply(noreply, "STORED\r\n"), nil
+}
+
+// delete <key> [noreply]
+func (s *Server) delete(ctx context.Context, args []string) (string, error) {
+	// clientes antigos mandam "delete <key> 0"
+	if len(args) == 3 || (len(args) == 2 && args[1] != "noreply") {
+		if args[1] != "0" {
+			return "", clientError("bad command line format. Usage: delete <key> [noreply]")
+		}
+		args = append(args[:1], args[2:]...)
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return "ERROR\r\n", nil
+	}
+	noreply := len(args) == 2 && args[1] == "noreply"
+	key, err := s.key(args[0])
+	if err != nil {
+		return "", err
+	}
+	metrics.Inc("memcache.delete")
+	s.hot.Record(key, hotkeys.Write)
+	existed, err := s.router.DeleteWith(ctx, key, s.router.WriteConsistencyFor(key))
+	if err != nil {
+		return "", err
+	}
+	if !existed {
+		return reply(noreply, "NOT_FOUND\r\n"), nil
+	}
+	return reply(noreply, "DELETED\r\n"), nil
+}
+
+// touch <key> <exptime> [noreply]
+func (s *Server) touch(ctx context.Context, args []string) (string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "ERROR\r\n", nil
+	}
+	noreply := len(args) == 3 && args[2] == "noreply"
+	key, err := s.key(args[0])
+	if err != nil {
+		return "", err
+	}
+	ttl, expired, err := parseExptime(args[1])
+	if err != nil {
+		return "", err
+	}
+	metrics.Inc("memcache.touch")
+	s.hot.Record(key, hotkeys.Write)
+	if expired {
+		existed, err := s.router.DeleteWith(ctx, key, s.router.WriteConsistencyFor(key))
+		if err != nil {
+			return "", err
+		}
+		if !existed {
+			return reply(noreply, "NOT_FOUND\r\n"), nil
+		}
+		return reply(noreply, "TOUCHED\r\n"), nil
+	}
+	if _, err := s.router.Expire(ctx, key, ttl, s.router.WriteConsistencyFor(key)); err != nil {
+		if errors.Is(err, cluster.ErrPreconditionFailed) {
+			return reply(noreply, "NOT_FOUND\r\n"), nil
+		}
+		return "", err
+	}
+	return reply(noreply, "TOUCHED\r\n"), nil
+}
+
+// parseExptime converte o exptime do memcached: 0 = não expira, até 30 dias
+// = segundos a partir de agora, acima disso = Unix timestamp; negativo ou
+// no passado = já expirado.
+func parseExptime(v string) (ttl time.Duration, expired bool, err error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false, clientError("bad command line format")
+	}
+	switch {
+	case n == 0:
+		return 0, false, nil
+	case n < 0:
+		return 0, true, nil
+	case n <= relativeExptimeMax:
+		return time.Duration(n) * time.Second, false, nil
+	}
+	ttl = time.Until(time.Unix(n, 0))
+	if ttl <= 0 {
+		return 0, true, nil
+	}
+	return ttl, false, nil
+}
+
+func reply(noreply bool, s string) string {
+	if noreply {
+		return ""
+	}
+	return s
+}
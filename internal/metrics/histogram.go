@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// LatencyBuckets são os limites superiores (em segundos) dos buckets dos
+// histogramas de latência.
+var LatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Labels são pares chave, valor de uma série (ex: "route", "/v1/kv/{key}").
+type Labels []string
+
+// key é a forma canônica das labels, usada como chave da série e na
+// exposição (k="v",k2="v2"; a ordem é a da chamada).
+func (l Labels) key() string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(l); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(l[i+1]))
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// series identifica uma métrica com labels.
+type series struct {
+	name   string
+	labels string
+}
+
+// Histogram conta medições por bucket (Counts[i]: medições <= Bounds[i]; o
+// último bucket é o +Inf).
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Quantile estima o quantil q (0..1) interpolando dentro do bucket, como o
+// histogram_quantile do Prometheus; acima do último limite retorna o limite.
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var seen int64
+	for i, c := range h.Counts {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+		if i == len(h.Bounds) {
+			return h.Bounds[len(h.Bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(seen))/float64(c)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Observe registra uma medição de tempo no histograma name com labels.
+// Diferente de Timing, não vai para os sinks (as labels não cabem nos nomes
+// do StatsD); fica em /metrics.
+func (r *Registry) Observe(name string, labels Labels, d time.Duration) {
+	k := series{name, labels.key()}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[k]
+	if !ok {
+		h = newHistogram(LatencyBuckets)
+		r.histograms[k] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// CountWith soma n ao contador name com labels (só em /metrics).
+func (r *Registry) CountWith(name string, labels Labels, n int64) {
+	k := series{name, labels.key()}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.labeled[k] += n
+}
+
+// Observe registra uma medição no histograma name do registro padrão.
+func Observe(name string, labels Labels, d time.Duration) {
+	Default.Observe(name, labels, d)
+}
+
+// CountWith soma n ao contador name com labels do registro padrão.
+func CountWith(name string, labels Labels, n int64) {
+	Default.CountWith(name, labels, n)
+}
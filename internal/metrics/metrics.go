@@ -0,0 +1,145 @@
+// Package metrics guarda os contadores e tempos do nó e os entrega aos
+// emissores configurados: expvar (GET /debug/vars) e o formato de texto do
+// Prometheus (GET /metrics) sempre, e StatsD/Datadog quando STATSD_ADDR está
+// definido.
+package metrics
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Sink recebe cada contagem e cada tempo medido (um emissor, como o StatsD).
+type Sink interface {
+	Count(name string, n int64)
+	Timing(name string, d time.Duration)
+}
+
+// TimerStats resume as medições de um tempo desde o início do processo.
+type TimerStats struct {
+	Count   int64   `json:"count"`
+	TotalMs float64 `json:"total_ms"`
+	MeanMs  float64 `json:"mean_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// Snapshot são os valores acumulados de todas as métricas.
+type Snapshot struct {
+	Counters map[string]int64      `json:"counters"`
+	Timers   map[string]TimerStats `json:"timers"`
+}
+
+// Registry acumula as métricas em memória e repassa cada evento aos sinks.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	timers   map[string]*TimerStats
+	sinks    []Sink
+	// séries com labels (histogram.go), só expostas em /metrics
+	labeled    map[series]int64
+	histograms map[series]*Histogram
+}
+
+// NewRegistry cria um registro vazio.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]int64),
+		timers:     make(map[string]*TimerStats),
+		labeled:    make(map[series]int64),
+		histograms: make(map[series]*Histogram),
+	}
+}
+
+// AddSink passa a enviar as métricas também para s.
+func (r *Registry) AddSink(s Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks = append(r.sinks, s)
+}
+
+// RemoveSink para de enviar as métricas para s.
+func (r *Registry) RemoveSink(s Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := make([]Sink, 0, len(r.sinks))
+	for _, cur := range r.sinks {
+		if cur != s {
+			kept = append(kept, cur)
+		}
+	}
+	r.sinks = kept
+}
+
+// Count soma n ao contador name.
+func (r *Registry) Count(name string, n int64) {
+	r.mu.Lock()
+	r.counters[name] += n
+	sinks := r.sinks
+	r.mu.Unlock()
+	for _, s := range sinks {
+		s.Count(name, n)
+	}
+}
+
+// Timing registra uma medição de tempo.
+func (r *Registry) Timing(name string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	r.mu.Lock()
+	t, ok := r.timers[name]
+	if !ok {
+		t = &TimerStats{}
+		r.timers[name] = t
+	}
+	t.Count++
+	t.TotalMs += ms
+	if ms > t.MaxMs {
+		t.MaxMs = ms
+	}
+	sinks := r.sinks
+	r.mu.Unlock()
+	for _, s := range sinks {
+		s.Timing(name, d)
+	}
+}
+
+// Snapshot copia os valores acumulados.
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := Snapshot{Counters: make(map[string]int64, len(r.counters)), Timers: make(map[string]TimerStats, len(r.timers))}
+	for k, v := range r.counters {
+		out.Counters[k] = v
+	}
+	for k, t := range r.timers {
+		s := *t
+		s.MeanMs = s.TotalMs / float64(s.Count)
+		out.Timers[k] = s
+	}
+	return out
+}
+
+// Default é o registro usado pelo nó (publicado no expvar como "mini_cassandra").
+// É um só por processo: vários nós embutidos no mesmo processo (pkg/node)
+// somam as métricas aqui.
+var Default = NewRegistry()
+
+func init() {
+	expvar.Publish("mini_cassandra", expvar.Func(func() any { return Default.Snapshot() }))
+}
+
+// Inc soma 1 ao contador name do registro padrão.
+func Inc(name string) {
+	Default.Count(name, 1)
+}
+
+// Add soma n ao contador name do registro padrão.
+func Add(name string, n int64) {
+	Default.Count(name, n)
+}
+
+// Since registra no registro padrão o tempo decorrido desde start
+// (uso: defer metrics.Since("client.get", time.Now())).
+func Since(name string, start time.Time) {
+	Default.Timing(name, time.Since(start))
+}
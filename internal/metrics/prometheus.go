@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Quantiles são os quantis publicados de cada histograma em /metrics (além
+// dos buckets, para quem agrega no Prometheus).
+var Quantiles = []float64{0.5, 0.95, 0.99}
+
+var nameSanitizer = strings.NewReplacer(".", "_", "-", "_", "/", "_")
+
+// WritePrometheus escreve todas as métricas no formato de texto do
+// Prometheus, com prefix nos nomes: contadores, tempos (como summary sem
+// quantis), contadores com labels e histogramas, com os quantis de
+// Quantiles numa série <nome>_quantile.
+func (r *Registry) WritePrometheus(out io.Writer, prefix string) error {
+	r.mu.Lock()
+	counters := make(map[string]int64, len(r.counters))
+	for k, v := range r.counters {
+		counters[k] = v
+	}
+	timers := make(map[string]TimerStats, len(r.timers))
+	for k, t := range r.timers {
+		timers[k] = *t
+	}
+	labeled := make(map[series]int64, len(r.labeled))
+	for k, v := range r.labeled {
+		labeled[k] = v
+	}
+	histograms := make(map[series]Histogram, len(r.histograms))
+	for k, h := range r.histograms {
+		c := *h
+		c.Counts = append([]int64(nil), h.Counts...)
+		histograms[k] = c
+	}
+	r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	name := func(n string) string { return prefix + "_" + nameSanitizer.Replace(n) }
+
+	for _, k := range sortedKeys(counters) {
+		n := name(k) + "_total"
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", n, n, counters[k])
+	}
+	for _, k := range sortedKeys(timers) {
+		n := name(k) + "_seconds"
+		t := timers[k]
+		fmt.Fprintf(w, "# TYPE %s summary\n%s_sum %g\n%s_count %d\n", n, n, t.TotalMs/1000, n, t.Count)
+	}
+
+	for _, group := range groupSeries(labeled) {
+		n := name(group[0].name) + "_total"
+		fmt.Fprintf(w, "# TYPE %s counter\n", n)
+		for _, s := range group {
+			fmt.Fprintf(w, "%s{%s} %d\n", n, s.labels, labeled[s])
+		}
+	}
+
+	for _, group := range groupSeries(histograms) {
+		n := name(group[0].name)
+		fmt.Fprintf(w, "# TYPE %s histogram\n", n)
+		for _, s := range group {
+			h := histograms[s]
+			var cum int64
+			for i, c := range h.Counts {
+				cum += c
+				le := "+Inf"
+				if i < len(h.Bounds) {
+					le = fmt.Sprintf("%g", h.Bounds[i])
+				}
+				fmt.Fprintf(w, "%s_bucket{%s} %d\n", n, joinLabels(s.labels, `le="`+le+`"`), cum)
+			}
+			fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", n, s.labels, h.Sum, n, s.labels, h.Count)
+		}
+		fmt.Fprintf(w, "# TYPE %s_quantile gauge\n", n)
+		for _, s := range group {
+			h := histograms[s]
+			for _, q := range Quantiles {
+				fmt.Fprintf(w, "%s_quantile{%s} %g\n", n, joinLabels(s.labels, fmt.Sprintf(`quantile="%g"`, q)), h.Quantile(q))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// groupSeries agrupa as séries por nome, em ordem de nome e de labels.
+func groupSeries[V any](m map[series]V) [][]series {
+	all := make([]series, 0, len(m))
+	for s := range m {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+	var out [][]series
+	for i, s := range all {
+		if i == 0 || s.name != all[i-1].name {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], s)
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPacket mantém cada datagrama UDP abaixo do MTU comum.
+const maxPacket = 1432
+
+// StatsD envia as métricas para um agente StatsD (ou DogStatsD, com tags)
+// por UDP. As linhas são agrupadas e enviadas a cada intervalo; se o agente
+// não acompanhar, as métricas excedentes são descartadas (o nó nunca espera
+// pelo emissor).
+type StatsD struct {
+	prefix string
+	tags   string
+	lines  chan string
+	conn   net.Conn
+}
+
+// NewStatsD conecta ao agente em addr (host:porta). prefix vai antes de todo
+// nome de métrica e tags (ex: "env:prod,dc:us1") são anexadas no formato do
+// DogStatsD.
+func NewStatsD(addr, prefix string, tags []string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd %s: %w", addr, err)
+	}
+	s := &StatsD{prefix: prefix, lines: make(chan string, 4096), conn: conn}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		s.prefix += "."
+	}
+	if len(tags) > 0 {
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	return s, nil
+}
+
+func (s *StatsD) emit(line string) {
+	select {
+	case s.lines <- line:
+	default:
+	}
+}
+
+// Count implementa Sink.
+func (s *StatsD) Count(name string, n int64) {
+	s.emit(s.prefix + name + ":" + strconv.FormatInt(n, 10) + "|c" + s.tags)
+}
+
+// Timing implementa Sink.
+func (s *StatsD) Timing(name string, d time.Duration) {
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	s.emit(s.prefix + name + ":" + ms + "|ms" + s.tags)
+}
+
+// Run envia as linhas acumuladas a cada interval até ctx terminar.
+func (s *StatsD) Run(ctx context.Context, interval time.Duration) {
+	defer s.conn.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var buf []byte
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(buf); err != nil {
+			log.Printf("[METRICS] statsd write failed: %v", err)
+		}
+		buf = buf[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case line := <-s.lines:
+			if len(buf) > 0 && len(buf)+1+len(line) > maxPacket {
+				flush()
+			}
+			if len(buf) > 0 {
+				buf = append(buf, '\n')
+			}
+			buf = append(buf, line...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
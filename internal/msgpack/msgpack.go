@@ -0,0 +1,268 @@
+// Package msgpack codifica e decodifica o subconjunto do MessagePack
+// (https://msgpack.org) usado nas chamadas entre nós: mapas, strings,
+// inteiros, binários, nil e booleanos. Não usa reflexão: quem chama escreve
+// e lê cada campo, e os campos desconhecidos são pulados com Skip.
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrShort: os dados acabaram no meio de um valor.
+var ErrShort = errors.New("msgpack: unexpected end of data")
+
+// AppendMapHeader escreve o cabeçalho de um mapa de n pares.
+func AppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// AppendString escreve s como str.
+func AppendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// AppendInt escreve i no menor formato que o comporta.
+func AppendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+// Reader lê valores de um buffer MessagePack, em ordem.
+type Reader struct {
+	b []byte
+}
+
+// NewReader cria um Reader sobre b (que não pode mudar durante a leitura).
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b}
+}
+
+// Len é quantos bytes faltam ler.
+func (r *Reader) Len() int {
+	return len(r.b)
+}
+
+func (r *Reader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.b) < n {
+		return nil, ErrShort
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *Reader) uint(size int) (uint64, error) {
+	v, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(v[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(v)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(v)), nil
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// ReadMapHeader lê o cabeçalho de um mapa e retorna quantos pares ele tem.
+func (r *Reader) ReadMapHeader() (int, error) {
+	t, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch c := t[0]; {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	case c == 0xde:
+		n, err := r.uint(2)
+		return int(n), err
+	case c == 0xdf:
+		n, err := r.uint(4)
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("msgpack: expected map, got 0x%02x", c)
+	}
+}
+
+// ReadString lê uma str (ou bin) como string.
+func (r *Reader) ReadString() (string, error) {
+	v, err := r.ReadStringBytes()
+	return string(v), err
+}
+
+// ReadStringBytes é o ReadString sem copiar: os bytes são do buffer do
+// Reader (para nomes de campo, comparados e descartados).
+func (r *Reader) ReadStringBytes() ([]byte, error) {
+	t, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch c := t[0]; {
+	case c&0xe0 == 0xa0:
+		n = uint64(c & 0x1f)
+	case c == 0xd9 || c == 0xc4:
+		n, err = r.uint(1)
+	case c == 0xda || c == 0xc5:
+		n, err = r.uint(2)
+	case c == 0xdb || c == 0xc6:
+		n, err = r.uint(4)
+	case c == 0xc0:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("msgpack: expected string, got 0x%02x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.next(int(n))
+}
+
+// ReadInt lê um inteiro (com ou sem sinal) como int64.
+func (r *Reader) ReadInt() (int64, error) {
+	t, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	c := t[0]
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c == 0xc0:
+		return 0, nil
+	}
+	var size int
+	switch c {
+	case 0xcc, 0xd0:
+		size = 1
+	case 0xcd, 0xd1:
+		size = 2
+	case 0xce, 0xd2:
+		size = 4
+	case 0xcf, 0xd3:
+		size = 8
+	default:
+		return 0, fmt.Errorf("msgpack: expected integer, got 0x%02x", c)
+	}
+	u, err := r.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if c >= 0xd0 {
+		// com sinal: estende o bit de sinal do tamanho lido
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, nil
+	}
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("msgpack: integer %d overflows int64", u)
+	}
+	return int64(u), nil
+}
+
+// Skip pula o próximo valor, qualquer que seja o tipo (campos que esta versão
+// não conhece).
+func (r *Reader) Skip() error {
+	t, err := r.next(1)
+	if err != nil {
+		return err
+	}
+	c := t[0]
+	var n uint64
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return nil
+	case c&0xf0 == 0x80:
+		return r.skipN(2 * int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return r.skipN(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		_, err = r.next(int(c & 0x1f))
+		return err
+	}
+	switch c {
+	case 0xcc, 0xd0:
+		_, err = r.next(1)
+	case 0xcd, 0xd1:
+		_, err = r.next(2)
+	case 0xce, 0xd2, 0xca:
+		_, err = r.next(4)
+	case 0xcf, 0xd3, 0xcb:
+		_, err = r.next(8)
+	case 0xd9, 0xc4:
+		if n, err = r.uint(1); err == nil {
+			_, err = r.next(int(n))
+		}
+	case 0xda, 0xc5:
+		if n, err = r.uint(2); err == nil {
+			_, err = r.next(int(n))
+		}
+	case 0xdb, 0xc6:
+		if n, err = r.uint(4); err == nil {
+			_, err = r.next(int(n))
+		}
+	case 0xdc:
+		if n, err = r.uint(2); err == nil {
+			err = r.skipN(int(n))
+		}
+	case 0xdd:
+		if n, err = r.uint(4); err == nil {
+			err = r.skipN(int(n))
+		}
+	case 0xde:
+		if n, err = r.uint(2); err == nil {
+			err = r.skipN(2 * int(n))
+		}
+	case 0xdf:
+		if n, err = r.uint(4); err == nil {
+			err = r.skipN(2 * int(n))
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+	}
+	return err
+}
+
+func (r *Reader) skipN(n int) error {
+	for i := 0; i < n; i++ {
+		if err := r.Skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/kv"
+)
+
+// FlushPolicy diz quando o Engine grava checkpoints sozinho. Zero em
+// qualquer campo desliga aquele gatilho.
+type FlushPolicy struct {
+	// MaxBytes e MaxEntries: flush de um keyspace quando as mutações desde o
+	// último flush dele passam desse volume (bytes aproximados de chave+valor)
+	MaxBytes   int64
+	MaxEntries int
+	// Interval: flush de todos os keyspaces com mutações a cada Interval
+	Interval time.Duration
+	// Concurrency: quantos keyspaces são gravados em paralelo num flush
+	// (manual ou automático; <= 1 = um de cada vez)
+	Concurrency int
+	// PurgeWAL: depois de cada flush, apaga os segmentos do WAL já cobertos
+	// por todos os checkpoints (já arquivados, se o arquivamento estiver
+	// ligado), sem esperar uma compactação
+	PurgeWAL bool
+}
+
+// autoFlushCheck é de quanto em quanto tempo os limites de bytes/entradas
+// são conferidos.
+const autoFlushCheck = time.Second
+
+// dirtyCounter é quanto um keyspace mudou desde o último flush.
+type dirtyCounter struct {
+	bytes   int64
+	entries int
+}
+
+// countingLog fica entre o store e o WAL contando as mutações por keyspace
+// (os gatilhos de flush). inner nil = sem WAL.
+type countingLog struct {
+	inner kv.Log
+	mu    sync.Mutex
+	dirty map[string]dirtyCounter
+	// logical soma os bytes de chave+valor de todas as mutações (base do
+	// write amplification)
+	logical int64
+}
+
+func newCountingLog(inner kv.Log) *countingLog {
+	return &countingLog{inner: inner, dirty: make(map[string]dirtyCounter)}
+}
+
+func (c *countingLog) Append(m kv.Mutation) error {
+	ks := kv.KeyspaceOf(m.Key)
+	if m.Op == kv.OpClear {
+		ks = m.Key
+	}
+	c.mu.Lock()
+	d := c.dirty[ks]
+	n := int64(len(m.Key) + len(m.Value) + 16)
+	d.bytes += n
+	d.entries++
+	c.dirty[ks] = d
+	c.logical += n
+	c.mu.Unlock()
+	if c.inner == nil {
+		return nil
+	}
+	return c.inner.Append(m)
+}
+
+// Sync repassa o Sync do WAL (kv.SyncLog).
+func (c *countingLog) Sync() error {
+	if sl, ok := c.inner.(kv.SyncLog); ok {
+		return sl.Sync()
+	}
+	return nil
+}
+
+// take zera e retorna os contadores dos keyspaces (todos, se nil).
+func (c *countingLog) take(keyspaces []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if keyspaces == nil {
+		c.dirty = make(map[string]dirtyCounter)
+		return
+	}
+	for _, ks := range keyspaces {
+		delete(c.dirty, ks)
+	}
+}
+
+// due retorna os keyspaces que passaram dos limites da política (all=true:
+// todos os que têm mutações).
+func (c *countingLog) due(p FlushPolicy, all bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for ks, d := range c.dirty {
+		// OpClear de todos os keyspaces chega com keyspace vazio
+		if ks == "" {
+			continue
+		}
+		if all || (p.MaxBytes > 0 && d.bytes >= p.MaxBytes) || (p.MaxEntries > 0 && d.entries >= p.MaxEntries) {
+			out = append(out, ks)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SetFlushPolicy troca a política de flush (vale para o próximo ciclo do
+// RunAutoFlush e para o próximo flush manual).
+func (e *Engine) SetFlushPolicy(p FlushPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = p
+}
+
+// FlushPolicy retorna a política de flush atual.
+func (e *Engine) FlushPolicy() FlushPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.policy
+}
+
+// RunAutoFlush grava os checkpoints conforme a política até ctx terminar.
+// Sem checkpoint dir ou sem nenhum gatilho ligado, não faz nada.
+func (e *Engine) RunAutoFlush(ctx context.Context) {
+	if e.checkpointDir == "" {
+		return
+	}
+	ticker := time.NewTicker(autoFlushCheck)
+	defer ticker.Stop()
+	lastInterval := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p := e.FlushPolicy()
+		all := p.Interval > 0 && time.Since(lastInterval) >= p.Interval
+		if all {
+			lastInterval = time.Now()
+		}
+		if !all && p.MaxBytes <= 0 && p.MaxEntries <= 0 {
+			continue
+		}
+		keyspaces := e.counter.due(p, all)
+		if len(keyspaces) == 0 {
+			continue
+		}
+		res, err := e.Flush(keyspaces)
+		if err != nil {
+			log.Printf("[FLUSH] automatic flush of %v failed: %v", keyspaces, err)
+			continue
+		}
+		log.Printf("[FLUSH] automatic flush: %d keyspaces (interval=%v)", len(res), all)
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// CompactResult resume uma compactação.
+type CompactResult struct {
+	Keyspaces         []string `json:"keyspaces"`
+	CheckpointsPurged int      `json:"checkpoints_purged"`
+	SegmentsRemoved   int      `json:"segments_removed"`
+	BytesBefore       int64    `json:"bytes_before"`
+	BytesAfter        int64    `json:"bytes_after"`
+	ReclaimedBytes    int64    `json:"reclaimed_bytes"`
+	TombstonesPurged  int      `json:"tombstones_purged"`
+}
+
+// DiskUsage retorna quantos bytes os checkpoints e o WAL ocupam em disco.
+func (e *Engine) DiskUsage() (checkpoints, walBytes int64) {
+	if e.checkpointDir != "" {
+		files, _ := filepath.Glob(filepath.Join(e.checkpointDir, "*.json"))
+		for _, f := range files {
+			if info, err := os.Stat(f); err == nil {
+				checkpoints += info.Size()
+			}
+		}
+	}
+	if e.wal != nil {
+		walBytes, _ = e.wal.Size()
+	}
+	return checkpoints, walBytes
+}
+
+// Compact faz uma compactação major: remove os tombstones cujo
+// gc_grace_seconds já passou, reescreve os checkpoints a partir do estado
+// atual (descartando versões sobrescritas), remove checkpoints de keyspaces
+// vazios e apaga os segmentos do WAL que já estão cobertos por todos os
+// checkpoints. Tombstones dentro do gc_grace continuam nos checkpoints.
+//
+// Com keyspace != "" só o checkpoint desse keyspace é reescrito; segmentos
+// só saem quando nenhum outro keyspace ainda depende deles.
+func (e *Engine) Compact(keyspace string) (*CompactResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cpBefore, walBefore := e.DiskUsage()
+	res := &CompactResult{BytesBefore: cpBefore + walBefore}
+
+	var targets []string
+	if keyspace != "" {
+		targets = []string{keyspace}
+	}
+	purgeFrom := targets
+	if purgeFrom == nil {
+		purgeFrom = e.store.Keyspaces()
+	}
+	for _, ks := range purgeFrom {
+		res.TombstonesPurged += e.store.PurgeTombstones(ks)
+	}
+	flushed, err := e.flushLocked(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range flushed {
+		res.Keyspaces = append(res.Keyspaces, f.Keyspace)
+		if f.Entries > 0 {
+			continue
+		}
+		// keyspace vazio: o checkpoint não guarda nada, pode sair
+		p := filepath.Join(e.checkpointDir, checkpointFile(f.Keyspace))
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		delete(e.checkpoints, f.Keyspace)
+		res.CheckpointsPurged++
+	}
+
+	if e.wal != nil {
+		if minSeq, ok := e.minCoveredSeq(); ok {
+			n, _, err := e.wal.RemoveBefore(minSeq)
+			if err != nil {
+				return nil, err
+			}
+			res.SegmentsRemoved = n
+		}
+	}
+
+	cpAfter, walAfter := e.DiskUsage()
+	res.BytesAfter = cpAfter + walAfter
+	res.ReclaimedBytes = res.BytesBefore - res.BytesAfter
+
+	e.compactions.Add(1)
+	for _, f := range flushed {
+		e.bytesCompacted.Add(int64(f.Bytes))
+	}
+	if res.ReclaimedBytes > 0 {
+		e.bytesReclaimed.Add(res.ReclaimedBytes)
+	}
+
+	log.Printf("[COMPACT] keyspaces=%v segments_removed=%d tombstones_purged=%d reclaimed=%d bytes", res.Keyspaces, res.SegmentsRemoved, res.TombstonesPurged, res.ReclaimedBytes)
+	return res, nil
+}
+
+// minCoveredSeq retorna o menor WALSeq entre os checkpoints: segmentos
+// anteriores a ele estão cobertos por todos. Se algum keyspace com dados
+// não tiver checkpoint, nenhum segmento está coberto.
+func (e *Engine) minCoveredSeq() (uint64, bool) {
+	for _, ks := range e.store.Keyspaces() {
+		if _, ok := e.checkpoints[ks]; !ok {
+			return 0, false
+		}
+	}
+	var min uint64
+	first := true
+	for _, seq := range e.checkpoints {
+		if first || seq < min {
+			min, first = seq, false
+		}
+	}
+	if first {
+		// nenhum checkpoint e store vazio: só o segmento atual importa
+		_, cur, err := e.wal.Segments()
+		return cur, err == nil
+	}
+	return min, true
+}
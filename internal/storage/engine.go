@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+	"mini-cassandra/internal/wal"
+)
+
+// Checkpoint é o conteúdo de um keyspace gravado em disco por um flush,
+// inclusive os tombstones. Tudo que está em segmentos do WAL anteriores a
+// WALSeq já está nele.
+type Checkpoint struct {
+	Keyspace  string              `json:"keyspace"`
+	WALSeq    uint64              `json:"wal_seq"`
+	CreatedAt time.Time           `json:"created_at"`
+	Entries   map[string]kv.Entry `json:"entries"`
+}
+
+// checkpointData é o formato do checkpoint em disco: as entradas vão como
+// JSON cru junto com o CRC32 desses bytes, conferido no boot. Checkpoints
+// sem CRC32 (gravados antes dele) são aceitos como estão.
+type checkpointData struct {
+	Keyspace  string          `json:"keyspace"`
+	WALSeq    uint64          `json:"wal_seq"`
+	CreatedAt time.Time       `json:"created_at"`
+	CRC32     *uint32         `json:"crc32,omitempty"`
+	Entries   json.RawMessage `json:"entries"`
+}
+
+// verify confere o CRC32 das entradas (checkpoints sem CRC32 passam).
+func (c *checkpointData) verify() error {
+	if c.CRC32 == nil {
+		return nil
+	}
+	if got := crc32.ChecksumIEEE(c.Entries); got != *c.CRC32 {
+		return fmt.Errorf("checksum mismatch (stored %08x, computed %08x)", *c.CRC32, got)
+	}
+	return nil
+}
+
+// QuarantineDir é o subdiretório (do CHECKPOINT_DIR) para onde vão os
+// checkpoints com checksum inválido.
+const QuarantineDir = "quarantine"
+
+// FlushResult resume o flush de um keyspace.
+type FlushResult struct {
+	Keyspace string `json:"keyspace"`
+	Entries  int    `json:"entries"`
+	Bytes    int    `json:"bytes"`
+	WALSeq   uint64 `json:"wal_seq"`
+}
+
+// Engine junta o store em memória (o "memtable"), o WAL e os checkpoints
+// por keyspace em disco. Um flush grava o checkpoint do keyspace e registra
+// a posição do WAL, então a recuperação só precisa reaplicar o que veio depois.
+//
+// Os dados continuam todos em memória: o flush não libera o store, ele
+// garante uma cópia consistente em disco (ex: antes de um snapshot do
+// filesystem) e encurta o replay no restart.
+type Engine struct {
+	store         *kv.Store
+	wal           *wal.Log
+	checkpointDir string
+
+	mu          sync.Mutex // serializa flushes
+	checkpoints map[string]uint64
+	policy      FlushPolicy
+
+	// counter conta as mutações por keyspace desde o último flush (fica
+	// entre o store e o WAL)
+	counter *countingLog
+
+	// lastActivity é a última modificação dos dados em disco antes do boot
+	lastActivity time.Time
+
+	// contadores de /admin/storage desde o boot
+	checkpointBytes atomic.Int64 // gravados por flushes e compactações
+	compactions     atomic.Int64
+	bytesCompacted  atomic.Int64 // reescritos pelas compactações
+	bytesReclaimed  atomic.Int64
+}
+
+// Open recupera o estado do disco (checkpoints + WAL) para o store e liga o
+// WAL para as próximas mutações. walDir vazio desliga o WAL.
+func Open(store *kv.Store, walDir, archiveDir, checkpointDir string) (*Engine, error) {
+	e := &Engine{
+		store:         store,
+		checkpointDir: checkpointDir,
+		checkpoints:   make(map[string]uint64),
+	}
+
+	var dataFiles []string
+	if walDir != "" {
+		dataFiles = append(dataFiles, filepath.Join(walDir, "*"))
+	}
+	if checkpointDir != "" {
+		dataFiles = append(dataFiles, filepath.Join(checkpointDir, "*.json"))
+	}
+	e.lastActivity = newestModTime(dataFiles...)
+
+	if checkpointDir != "" {
+		if err := os.MkdirAll(checkpointDir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := e.loadCheckpoints(); err != nil {
+			return nil, err
+		}
+	}
+
+	if walDir == "" {
+		e.counter = newCountingLog(nil)
+		store.SetLog(e.counter)
+		return e, nil
+	}
+
+	// mutações que já estão no checkpoint do keyspace (segmento anterior ao
+	// WALSeq dele) são puladas
+	covered := func(seq uint64, keyspace string) bool {
+		return seq < e.checkpoints[keyspace]
+	}
+	n, err := wal.Replay(walDir, func(seq uint64, m kv.Mutation) {
+		switch {
+		case m.Op == kv.OpClear && m.Key == "":
+			for _, ks := range store.Keyspaces() {
+				if !covered(seq, ks) {
+					store.Apply(kv.Mutation{Op: kv.OpClear, Key: ks, Timestamp: m.Timestamp})
+				}
+			}
+		case m.Op == kv.OpClear:
+			if !covered(seq, m.Key) {
+				store.Apply(m)
+			}
+		default:
+			if !covered(seq, kv.KeyspaceOf(m.Key)) {
+				store.Apply(m)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("wal replay: %w", err)
+	}
+	log.Printf("[WAL] replayed %d records from %s", n, walDir)
+
+	e.wal, err = wal.Open(walDir, archiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("wal open: %w", err)
+	}
+	e.counter = newCountingLog(e.wal)
+	store.SetLog(e.counter)
+	return e, nil
+}
+
+// LastActivity retorna quando os dados em disco foram modificados pela última
+// vez antes deste boot (zero se não havia dados): uma aproximação de quando
+// o nó parou.
+func (e *Engine) LastActivity() time.Time {
+	return e.lastActivity
+}
+
+func newestModTime(patterns ...string) time.Time {
+	var newest time.Time
+	for _, pattern := range patterns {
+		files, _ := filepath.Glob(pattern)
+		for _, f := range files {
+			if info, err := os.Stat(f); err == nil && info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+		}
+	}
+	return newest
+}
+
+// WAL retorna o log de mutações (nil se desligado).
+func (e *Engine) WAL() *wal.Log {
+	return e.wal
+}
+
+func checkpointFile(keyspace string) string {
+	return url.PathEscape(keyspace) + ".json"
+}
+
+func (e *Engine) loadCheckpoints() error {
+	files, err := filepath.Glob(filepath.Join(e.checkpointDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		var raw checkpointData
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("checkpoint %s: %w", filepath.Base(f), err)
+		}
+		if err := raw.verify(); err != nil {
+			// sem o checkpoint, o keyspace volta do WAL que restou e do
+			// repair com as outras réplicas
+			metrics.Inc("checkpoint.corrupt")
+			log.Printf("[FLUSH] CORRUPT checkpoint %s: %v; moved to %s, keyspace=%s must be repaired from replicas",
+				filepath.Base(f), err, QuarantineDir, raw.Keyspace)
+			if err := e.quarantine(f); err != nil {
+				return fmt.Errorf("checkpoint %s: quarantine: %w", filepath.Base(f), err)
+			}
+			continue
+		}
+		cp := Checkpoint{Keyspace: raw.Keyspace, WALSeq: raw.WALSeq, CreatedAt: raw.CreatedAt}
+		if err := json.Unmarshal(raw.Entries, &cp.Entries); err != nil {
+			return fmt.Errorf("checkpoint %s: %w", filepath.Base(f), err)
+		}
+		for k, entry := range cp.Entries {
+			op := kv.OpPut
+			if entry.Deleted {
+				op = kv.OpDelete
+			}
+			e.store.Apply(kv.Mutation{Op: op, Key: k, Value: entry.Value, Timestamp: entry.Timestamp, ExpiresAt: entry.ExpiresAt})
+		}
+		e.checkpoints[cp.Keyspace] = cp.WALSeq
+		log.Printf("[FLUSH] loaded checkpoint keyspace=%s entries=%d wal_seq=%d", cp.Keyspace, len(cp.Entries), cp.WALSeq)
+	}
+	return nil
+}
+
+// quarantine move um arquivo de checkpoint para o subdiretório de quarentena.
+func (e *Engine) quarantine(path string) error {
+	dir := filepath.Join(e.checkpointDir, QuarantineDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+}
+
+// Flush grava em disco o checkpoint dos keyspaces pedidos (vazio = todos,
+// inclusive os que já tinham checkpoint e ficaram sem chaves).
+func (e *Engine) Flush(keyspaces []string) ([]FlushResult, error) {
+	if e.checkpointDir == "" {
+		return nil, fmt.Errorf("flush disabled (no checkpoint dir)")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res, err := e.flushLocked(keyspaces)
+	if err == nil && e.policy.PurgeWAL && e.wal != nil {
+		if minSeq, ok := e.minCoveredSeq(); ok {
+			n, bytes, rerr := e.wal.RemoveBefore(minSeq)
+			if rerr != nil {
+				log.Printf("[FLUSH] removing flushed wal segments failed: %v", rerr)
+			} else if n > 0 {
+				log.Printf("[FLUSH] removed %d wal segments (%d bytes) covered by checkpoints", n, bytes)
+			}
+		}
+	}
+	return res, err
+}
+
+func (e *Engine) flushLocked(keyspaces []string) ([]FlushResult, error) {
+	if len(keyspaces) == 0 {
+		seen := make(map[string]struct{})
+		for _, ks := range e.store.Keyspaces() {
+			seen[ks] = struct{}{}
+		}
+		for ks := range e.checkpoints {
+			seen[ks] = struct{}{}
+		}
+		for ks := range seen {
+			keyspaces = append(keyspaces, ks)
+		}
+		sort.Strings(keyspaces)
+	}
+
+	// depois da rotação, tudo que está nos segmentos anteriores entra no checkpoint
+	var seq uint64
+	if e.wal != nil {
+		if err := e.wal.Rotate(); err != nil {
+			return nil, fmt.Errorf("wal rotate: %w", err)
+		}
+		_, seq, _ = e.wal.Segments()
+	}
+
+	clean := keyspaces[:0:0]
+	for _, ks := range keyspaces {
+		if ks = strings.TrimSpace(ks); ks != "" {
+			clean = append(clean, ks)
+		}
+	}
+	// o que chegar daqui em diante conta para o próximo flush
+	e.counter.take(clean)
+
+	workers := e.policy.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]FlushResult, len(clean))
+	errs := make([]error, len(clean))
+	var cpMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, ks := range clean {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ks string) {
+			defer func() { <-sem; wg.Done() }()
+			results[i], errs[i] = e.writeCheckpoint(ks, seq)
+			if errs[i] == nil {
+				cpMu.Lock()
+				e.checkpoints[ks] = seq
+				cpMu.Unlock()
+			}
+		}(i, ks)
+	}
+	wg.Wait()
+
+	done := results[:0]
+	for i, err := range errs {
+		if err != nil {
+			return done, err
+		}
+		done = append(done, results[i])
+	}
+	return done, nil
+}
+
+// writeCheckpoint grava o checkpoint de um keyspace com a posição seq do WAL.
+func (e *Engine) writeCheckpoint(ks string, seq uint64) (FlushResult, error) {
+	cp := Checkpoint{
+		Keyspace:  ks,
+		WALSeq:    seq,
+		CreatedAt: time.Now().UTC(),
+		Entries:   e.store.VersionsIn(ks),
+	}
+	entries, err := json.Marshal(cp.Entries)
+	if err != nil {
+		return FlushResult{}, err
+	}
+	sum := crc32.ChecksumIEEE(entries)
+	data, err := json.Marshal(checkpointData{Keyspace: cp.Keyspace, WALSeq: cp.WALSeq, CreatedAt: cp.CreatedAt, CRC32: &sum, Entries: entries})
+	if err != nil {
+		return FlushResult{}, err
+	}
+	p := filepath.Join(e.checkpointDir, checkpointFile(ks))
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return FlushResult{}, err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return FlushResult{}, err
+	}
+	e.checkpointBytes.Add(int64(len(data)))
+	log.Printf("[FLUSH] keyspace=%s entries=%d bytes=%d wal_seq=%d", ks, len(cp.Entries), len(data), seq)
+	return FlushResult{Keyspace: ks, Entries: len(cp.Entries), Bytes: len(data), WALSeq: seq}, nil
+
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// FilesystemUsage não é suportado fora de sistemas unix.
+func FilesystemUsage(path string) (total, free uint64, err error) {
+	return 0, 0, errors.New("filesystem usage not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package storage
+
+import "syscall"
+
+// FilesystemUsage retorna o tamanho total e o espaço livre (para processos
+// sem privilégio) do filesystem que contém path.
+func FilesystemUsage(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
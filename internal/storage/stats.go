@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"mini-cassandra/internal/wal"
+)
+
+// KeyspaceStorage é o estado em disco de um keyspace.
+type KeyspaceStorage struct {
+	Keyspace string `json:"keyspace"`
+	// DataFiles é quantos arquivos de dados o keyspace tem em disco: o
+	// checkpoint (0 ou 1; não há SSTables)
+	DataFiles       int        `json:"data_files"`
+	CheckpointBytes int64      `json:"checkpoint_bytes"`
+	WALSeq          uint64     `json:"wal_seq,omitempty"`
+	LastFlush       *time.Time `json:"last_flush,omitempty"`
+	// mutações desde o último flush (ainda só no WAL)
+	UnflushedEntries int   `json:"unflushed_entries"`
+	UnflushedBytes   int64 `json:"unflushed_bytes"`
+}
+
+// StorageStats são as métricas de disco do nó (/admin/storage).
+type StorageStats struct {
+	Keyspaces       []KeyspaceStorage `json:"keyspaces"`
+	CheckpointBytes int64             `json:"checkpoint_bytes"`
+	WALBytes        int64             `json:"wal_bytes"`
+	DiskBytes       int64             `json:"disk_bytes"`
+	WALSegments     int               `json:"wal_segments"`
+	// WALSync é o modo de fsync do WAL (WAL_SYNC) e WALSyncWindow a janela
+	// do modo group
+	WALSync       string `json:"wal_sync,omitempty"`
+	WALSyncWindow string `json:"wal_sync_window,omitempty"`
+	// PendingCompaction: segmentos do WAL já cobertos por todos os
+	// checkpoints, que a próxima compactação (ou WAL_PURGE_FLUSHED) apaga
+	PendingCompactionSegments int   `json:"pending_compaction_segments"`
+	PendingCompactionBytes    int64 `json:"pending_compaction_bytes"`
+	// desde o boot
+	Compactions    int64 `json:"compactions"`
+	BytesCompacted int64 `json:"bytes_compacted"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+	LogicalBytes   int64 `json:"logical_bytes_written"`
+	PhysicalBytes  int64 `json:"physical_bytes_written"`
+	// WriteAmplification = bytes gravados em disco (WAL + checkpoints) /
+	// bytes de chave+valor das mutações, desde o boot
+	WriteAmplification float64 `json:"write_amplification"`
+}
+
+// Stats coleta as métricas de disco: por keyspace, do WAL e da compactação.
+func (e *Engine) Stats() StorageStats {
+	e.mu.Lock()
+	checkpoints := make(map[string]uint64, len(e.checkpoints))
+	for ks, seq := range e.checkpoints {
+		checkpoints[ks] = seq
+	}
+	minSeq, covered := e.minCoveredSeq()
+	e.mu.Unlock()
+
+	st := StorageStats{
+		Compactions:    e.compactions.Load(),
+		BytesCompacted: e.bytesCompacted.Load(),
+		BytesReclaimed: e.bytesReclaimed.Load(),
+	}
+	st.CheckpointBytes, st.WALBytes = e.DiskUsage()
+	st.DiskBytes = st.CheckpointBytes + st.WALBytes
+
+	seen := make(map[string]*KeyspaceStorage)
+	ks := func(name string) *KeyspaceStorage {
+		if seen[name] == nil {
+			seen[name] = &KeyspaceStorage{Keyspace: name}
+		}
+		return seen[name]
+	}
+	for _, name := range e.store.Keyspaces() {
+		ks(name)
+	}
+	for name, seq := range checkpoints {
+		k := ks(name)
+		k.WALSeq = seq
+		if info, err := os.Stat(filepath.Join(e.checkpointDir, checkpointFile(name))); err == nil {
+			k.DataFiles = 1
+			k.CheckpointBytes = info.Size()
+			t := info.ModTime().UTC()
+			k.LastFlush = &t
+		}
+	}
+	if e.counter != nil {
+		e.counter.mu.Lock()
+		for name, d := range e.counter.dirty {
+			if name == "" {
+				continue
+			}
+			k := ks(name)
+			k.UnflushedEntries, k.UnflushedBytes = d.entries, d.bytes
+		}
+		st.LogicalBytes = e.counter.logical
+		e.counter.mu.Unlock()
+	}
+	st.Keyspaces = make([]KeyspaceStorage, 0, len(seen))
+	for _, k := range seen {
+		st.Keyspaces = append(st.Keyspaces, *k)
+	}
+	sort.Slice(st.Keyspaces, func(i, j int) bool { return st.Keyspaces[i].Keyspace < st.Keyspaces[j].Keyspace })
+
+	st.PhysicalBytes = e.checkpointBytes.Load()
+	if e.wal != nil {
+		st.PhysicalBytes += e.wal.BytesWritten()
+		mode, window := e.wal.SyncMode()
+		st.WALSync = string(mode)
+		if mode == wal.SyncGroup {
+			st.WALSyncWindow = window.String()
+		}
+		closed, _, _ := e.wal.Segments()
+		st.WALSegments = len(closed) + 1
+		for _, s := range closed {
+			if !covered || s.Seq >= minSeq {
+				break
+			}
+			st.PendingCompactionSegments++
+			if info, err := os.Stat(s.Path); err == nil {
+				st.PendingCompactionBytes += info.Size()
+			}
+		}
+	}
+	if st.LogicalBytes > 0 {
+		st.WriteAmplification = float64(st.PhysicalBytes) / float64(st.LogicalBytes)
+	}
+	return st
+}
@@ -0,0 +1,185 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"mini-cassandra/internal/metrics"
+)
+
+// SyncMode é quando o WAL faz fsync dos registros (WAL_SYNC).
+type SyncMode string
+
+const (
+	// SyncNone só entrega os registros ao sistema operacional (um crash do
+	// processo não perde nada; uma queda da máquina perde o que o SO ainda não
+	// gravou)
+	SyncNone SyncMode = "none"
+	// SyncAlways: cada escrita espera o fsync do seu registro. Escritas
+	// concorrentes dividem o mesmo fsync (group commit)
+	SyncAlways SyncMode = "always"
+	// SyncGroup: um fsync a cada intervalo, e cada escrita espera o próximo;
+	// uma escrita demora até o intervalo a mais, mas todas as da janela saem
+	// num fsync só
+	SyncGroup SyncMode = "group"
+)
+
+// DefaultSyncInterval é a janela padrão do SyncGroup.
+const DefaultSyncInterval = 10 * time.Millisecond
+
+// ParseSyncMode valida o valor de WAL_SYNC.
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch m := SyncMode(s); m {
+	case SyncNone, SyncAlways, SyncGroup:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid wal sync mode %q (none, always or group)", s)
+}
+
+// syncState é o group commit: appended conta os registros escritos desde o
+// boot e synced até qual deles o fsync já garantiu. Quem espera um fsync dorme
+// em cond; failed é o maior alvo de um fsync que falhou.
+type syncState struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	appended uint64 // sob Log.mu
+	synced   uint64
+	failed   uint64
+	err      error
+	syncing  bool
+	stop     chan struct{}
+}
+
+// SetSync configura o fsync do WAL (chame no boot, antes das escritas).
+// interval só vale para SyncGroup (<= 0 usa DefaultSyncInterval).
+func (l *Log) SetSync(mode SyncMode, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sync.stop != nil {
+		close(l.sync.stop)
+		l.sync.stop = nil
+	}
+	l.syncMode, l.syncInterval = mode, interval
+	if mode == SyncGroup {
+		l.sync.stop = make(chan struct{})
+		go l.runGroupSync(interval, l.sync.stop)
+	}
+	if mode == SyncNone {
+		log.Printf("[WAL] fsync: none (records only reach the OS page cache)")
+	} else {
+		log.Printf("[WAL] fsync: %s (group window %s)", mode, interval)
+	}
+}
+
+// SyncMode retorna o modo de fsync e a janela do SyncGroup.
+func (l *Log) SyncMode() (SyncMode, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncMode, l.syncInterval
+}
+
+// Sync espera até que todos os registros já escritos estejam em disco, de
+// acordo com o modo (com SyncNone retorna na hora). O store chama depois de
+// soltar o lock dele, então escritas concorrentes se juntam no mesmo fsync.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	mode, target := l.syncMode, l.sync.appended
+	l.mu.Unlock()
+	if mode == SyncNone || mode == "" {
+		return nil
+	}
+
+	s := &l.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.synced < target {
+		if s.failed >= target {
+			return s.err
+		}
+		if mode == SyncAlways && !s.syncing {
+			// ninguém está no fsync: este vira o líder e leva junto tudo o que
+			// foi escrito até agora
+			s.syncing = true
+			s.mu.Unlock()
+			l.fsync()
+			s.mu.Lock()
+			s.syncing = false
+			continue
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+// runGroupSync faz o fsync do SyncGroup a cada interval, se houver registros
+// novos.
+func (l *Log) runGroupSync(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		pending := l.sync.appended
+		l.mu.Unlock()
+		l.sync.mu.Lock()
+		idle := l.sync.synced >= pending
+		l.sync.mu.Unlock()
+		if !idle {
+			l.fsync()
+		}
+	}
+}
+
+// fsync grava em disco o segmento atual e acorda quem esperava. O fsync roda
+// fora de Log.mu: as escritas seguem enquanto ele não termina (e entram no
+// próximo).
+func (l *Log) fsync() {
+	l.mu.Lock()
+	target, f := l.sync.appended, l.f
+	l.mu.Unlock()
+
+	start := time.Now()
+	err := f.Sync()
+	if errors.Is(err, os.ErrClosed) {
+		// o segmento foi trocado no meio: closeCurrent já fez o fsync dele
+		err = nil
+	}
+	metrics.Since("wal.fsync", start)
+
+	s := &l.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		metrics.Inc("wal.fsync_errors")
+		log.Printf("[WAL] fsync of %s failed: %v", f.Name(), err)
+		s.failed, s.err = max(s.failed, target), err
+	} else if target > s.synced {
+		metrics.Inc("wal.fsyncs")
+		metrics.Add("wal.fsync_records", int64(target-s.synced))
+		s.synced = target
+	}
+	s.cond.Broadcast()
+}
+
+// markSynced registra que tudo o que foi escrito está em disco (chamado com
+// Log.mu, depois do fsync de um segmento que vai ser fechado).
+func (l *Log) markSynced() {
+	s := &l.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.appended > s.synced {
+		s.synced = s.appended
+	}
+	s.cond.Broadcast()
+}
@@ -0,0 +1,551 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/bufpool"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/metrics"
+)
+
+const segmentExt = ".wal"
+
+// QuarantineDir é o subdiretório (do diretório do WAL) onde o replay guarda
+// os registros com checksum inválido, um arquivo por segmento.
+const QuarantineDir = "quarantine"
+
+// Log é o write-ahead log do nó: cada mutação do store vira uma linha JSON
+// no segmento atual, precedida do CRC32 da linha (ver ReadSegment). O
+// segmento é trocado num flush ou quando passa de SetMaxSegmentBytes.
+// Segmentos fechados podem ser copiados para um diretório de arquivo
+// (archiveDir) e/ou para um arquivo remoto (SetRemoteArchive), usados no
+// restore point-in-time.
+type Log struct {
+	mu         sync.Mutex
+	dir        string
+	archiveDir string
+	seq        uint64
+	f          *os.File
+	w          *bufio.Writer
+
+	size     int64 // bytes do segmento atual
+	maxBytes int64 // 0 = só troca de segmento no Rotate
+
+	upload  func(name string, data []byte) error
+	uploads sync.WaitGroup
+
+	// written conta os bytes gravados pelo Append desde o boot
+	written atomic.Int64
+
+	// fsync dos registros (ver SetSync)
+	syncMode     SyncMode
+	syncInterval time.Duration
+	sync         syncState
+}
+
+// DefaultMaxSegmentBytes é o tamanho a partir do qual o segmento atual é
+// fechado e um novo é aberto.
+const DefaultMaxSegmentBytes = 64 << 20
+
+// Open abre o WAL em dir, sempre começando um segmento novo (o último
+// segmento da execução anterior pode ter ficado pela metade).
+// archiveDir vazio desliga o arquivamento.
+func Open(dir, archiveDir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if archiveDir != "" {
+		if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	segs, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, archiveDir: archiveDir, syncMode: SyncNone}
+	l.sync.cond = sync.NewCond(&l.sync.mu)
+	for _, s := range segs {
+		// segmentos da execução anterior já estão fechados
+		if err := l.archive(s.Path); err != nil {
+			return nil, err
+		}
+		l.seq = s.Seq
+	}
+
+	if err := l.openNext(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%016d%s", seq, segmentExt)
+}
+
+func (l *Log) openNext() error {
+	l.seq++
+	f, err := os.OpenFile(filepath.Join(l.dir, segmentName(l.seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.size = 0
+	return nil
+}
+
+// SetMaxSegmentBytes faz o Append trocar de segmento quando o atual passa de
+// n bytes (0 = só no Rotate).
+func (l *Log) SetMaxSegmentBytes(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxBytes = n
+}
+
+// SetRemoteArchive liga o envio de cada segmento fechado para upload (ex: um
+// bucket), em background. Os segmentos já fechados que não estão em uploaded
+// (os nomes que o destino já tem) são enviados agora.
+func (l *Log) SetRemoteArchive(upload func(name string, data []byte) error, uploaded []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.upload = upload
+	have := make(map[string]bool, len(uploaded))
+	for _, name := range uploaded {
+		have[name] = true
+	}
+	segs, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segs {
+		if s.Seq < l.seq && !have[s.Name()] {
+			if err := l.uploadSegment(s.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uploadSegment lê um segmento fechado e o envia em background.
+func (l *Log) uploadSegment(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	l.uploads.Add(1)
+	go func() {
+		defer l.uploads.Done()
+		if err := l.upload(name, data); err != nil {
+			metrics.Inc("wal.archive_upload_errors")
+			log.Printf("[WAL] remote archive of segment %s failed: %v", name, err)
+			return
+		}
+		log.Printf("[WAL] segment %s sent to remote archive", name)
+	}()
+	return nil
+}
+
+// BytesWritten retorna quantos bytes o Append gravou desde o boot.
+func (l *Log) BytesWritten() int64 {
+	return l.written.Load()
+}
+
+// RemoteArchive diz se o arquivamento remoto está ligado.
+func (l *Log) RemoteArchive() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.upload != nil
+}
+
+// WaitArchived espera os envios para o arquivo remoto em andamento.
+func (l *Log) WaitArchived() {
+	l.uploads.Wait()
+}
+
+// ArchiveDir retorna o diretório de arquivo ("" se desligado).
+func (l *Log) ArchiveDir() string {
+	return l.archiveDir
+}
+
+// Append grava a mutação no segmento atual. Ela só está garantida em disco
+// depois do Sync (em SyncNone, nunca: fica com o SO).
+func (l *Log) Append(m kv.Mutation) error {
+	// a linha é "<crc> <json>\n", montada num buffer do pool: o JSON é
+	// codificado depois dos 9 bytes do crc, preenchidos no fim
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	buf.WriteString("00000000 ")
+	if err := json.NewEncoder(buf).Encode(m); err != nil {
+		return err
+	}
+	line := buf.Bytes()
+	rec := line[9 : len(line)-1]
+	// escreve o crc por cima do placeholder (cabe na capacidade de line)
+	_ = fmt.Appendf(line[:0], "%08x", crc32.ChecksumIEEE(rec))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		return err
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	l.size += int64(len(line))
+	l.written.Add(int64(len(line)))
+	l.sync.appended++
+	if l.maxBytes > 0 && l.size >= l.maxBytes {
+		// a mutação já está no disco; falhar a troca não a desfaz
+		if err := l.rotateLocked(); err != nil {
+			log.Printf("[WAL] size-based rotation failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Rotate fecha o segmento atual (arquivando-o) e abre o próximo.
+func (l *Log) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotateLocked()
+}
+
+func (l *Log) rotateLocked() error {
+	if err := l.closeCurrent(); err != nil {
+		return err
+	}
+	if err := l.archive(l.f.Name()); err != nil {
+		return err
+	}
+	return l.openNext()
+}
+
+func (l *Log) closeCurrent() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if l.syncMode != SyncNone {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+		l.markSynced()
+	}
+	return l.f.Close()
+}
+
+// Close fecha o segmento atual.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sync.stop != nil {
+		close(l.sync.stop)
+		l.sync.stop = nil
+	}
+	return l.closeCurrent()
+}
+
+// archive copia um segmento fechado para o diretório de arquivo (se ainda não
+// estiver lá) e o envia para o arquivo remoto, se ligado.
+func (l *Log) archive(path string) error {
+	if l.upload != nil {
+		if err := l.uploadSegment(path); err != nil {
+			return err
+		}
+	}
+	if l.archiveDir == "" {
+		return nil
+	}
+	dst := filepath.Join(l.archiveDir, filepath.Base(path))
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Printf("[WAL] archived segment %s", filepath.Base(path))
+	return os.Rename(tmp, dst)
+}
+
+// Segment é um arquivo de segmento do WAL.
+type Segment struct {
+	Seq  uint64
+	Path string
+}
+
+// Name retorna o nome do arquivo do segmento.
+func (s Segment) Name() string {
+	return filepath.Base(s.Path)
+}
+
+// Segments retorna os segmentos já fechados (em ordem) e o número do segmento atual.
+func (l *Log) Segments() ([]Segment, uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segs, err := listSegments(l.dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	closed := segs[:0]
+	for _, s := range segs {
+		if s.Seq < l.seq {
+			closed = append(closed, s)
+		}
+	}
+	return closed, l.seq, nil
+}
+
+// RemoveBefore apaga os segmentos fechados com número menor que seq
+// (já arquivados, se o arquivamento estiver ligado). Retorna quantos
+// segmentos e bytes foram removidos.
+func (l *Log) RemoveBefore(seq uint64) (int, int64, error) {
+	closed, _, err := l.Segments()
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, bytes := 0, int64(0)
+	for _, s := range closed {
+		if s.Seq >= seq {
+			break
+		}
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return removed, bytes, err
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return removed, bytes, err
+		}
+		removed++
+		bytes += info.Size()
+	}
+	return removed, bytes, nil
+}
+
+// Size retorna quantos bytes os segmentos ocupam em disco.
+func (l *Log) Size() (int64, error) {
+	segs, err := listSegments(l.dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, s := range segs {
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// SegmentSeq extrai o número de sequência do nome de um segmento.
+func SegmentSeq(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+	return seq, err == nil
+}
+
+func listSegments(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segs []Segment
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		seq, ok := SegmentSeq(e.Name())
+		if !ok {
+			continue
+		}
+		segs = append(segs, Segment{Seq: seq, Path: filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Seq < segs[j].Seq })
+	return segs, nil
+}
+
+// Replay lê todos os segmentos de dir em ordem e chama fn para cada mutação,
+// junto com o número do segmento de onde ela veio.
+func Replay(dir string, fn func(seq uint64, m kv.Mutation)) (int, error) {
+	segs, err := listSegments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range segs {
+		f, err := os.Open(s.Path)
+		if err != nil {
+			return n, err
+		}
+		read, err := readSegment(f, s.Name(), func(m kv.Mutation) { fn(s.Seq, m) }, func(line []byte) {
+			quarantine(dir, s.Name(), line)
+		})
+		f.Close()
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadSegment lê as mutações de um segmento (de disco ou baixado de um backup).
+// Uma linha incompleta no fim do segmento (escrita interrompida) é ignorada.
+// Cada registro é "<crc32 em hex> <json>"; um registro com checksum errado é
+// descartado com um erro no log em vez de ser aplicado. Linhas só com o JSON
+// (segmentos gravados antes do checksum) são aceitas como estão.
+func ReadSegment(r io.Reader, name string, fn func(kv.Mutation)) (int, error) {
+	return readSegment(r, name, fn, nil)
+}
+
+// readSegment é o ReadSegment com corrupt recebendo cada registro descartado
+// por checksum inválido.
+func readSegment(r io.Reader, name string, fn func(kv.Mutation), corrupt func(line []byte)) (int, error) {
+	n, record := 0, 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF {
+				return n, err
+			}
+			if len(strings.TrimSpace(string(line))) > 0 {
+				log.Printf("[WAL] ignoring torn record at end of %s", name)
+			}
+			return n, nil
+		}
+		record++
+		rec, err := verifyRecord(bytes.TrimRight(line, "\r\n"))
+		if err != nil {
+			metrics.Inc("wal.corrupt_records")
+			log.Printf("[WAL] CORRUPT record %d in %s: %v (skipped)", record, name, err)
+			if corrupt != nil {
+				corrupt(line)
+			}
+			continue
+		}
+		var m kv.Mutation
+		if err := json.Unmarshal(rec, &m); err != nil {
+			log.Printf("[WAL] ignoring invalid record in %s: %v", name, err)
+			continue
+		}
+		fn(m)
+		n++
+	}
+}
+
+// verifyRecord confere o checksum de uma linha do WAL e retorna o JSON.
+func verifyRecord(line []byte) ([]byte, error) {
+	if len(line) > 0 && line[0] == '{' {
+		return line, nil // registro sem checksum (formato antigo)
+	}
+	sum, rec, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(sum) != 8 {
+		return nil, fmt.Errorf("malformed record header")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed checksum %q", sum)
+	}
+	if got := crc32.ChecksumIEEE(rec); got != uint32(want) {
+		return nil, fmt.Errorf("checksum mismatch (stored %08x, computed %08x)", want, got)
+	}
+	return rec, nil
+}
+
+// quarantine guarda um registro corrompido em dir/quarantine/<segmento>,
+// para inspeção.
+func quarantine(dir, segment string, line []byte) {
+	qdir := filepath.Join(dir, QuarantineDir)
+	if err := os.MkdirAll(qdir, 0o755); err != nil {
+		log.Printf("[WAL] quarantine of record from %s failed: %v", segment, err)
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(qdir, segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("[WAL] quarantine of record from %s failed: %v", segment, err)
+		return
+	}
+	defer f.Close()
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	f.Write(line)
+}
+
+// ScrubSegment confere o checksum de todos os registros de um segmento
+// fechado. Os corrompidos vão para a quarentena e o segmento é reescrito sem
+// eles (de forma atômica). Retorna quantos registros foram lidos e quantos
+// foram descartados.
+func ScrubSegment(path string) (records, corrupt int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var good bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		records++
+		rec, verr := verifyRecord(bytes.TrimRight(line, "\r\n"))
+		if verr == nil && line[len(line)-1] == '\n' && json.Valid(rec) {
+			good.Write(line)
+			continue
+		}
+		corrupt++
+		metrics.Inc("wal.corrupt_records")
+		log.Printf("[SCRUB] CORRUPT record %d in %s (discarded)", records, filepath.Base(path))
+		quarantine(filepath.Dir(path), filepath.Base(path), line)
+	}
+	if corrupt == 0 {
+		return records, 0, nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, good.Bytes(), 0o644); err != nil {
+		return records, corrupt, err
+	}
+	return records, corrupt, os.Rename(tmp, path)
+}
@@ -0,0 +1,307 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/api"
+	"mini-cassandra/internal/backup"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/kv"
+)
+
+// env lê a configuração do nó pelos nomes das variáveis de ambiente do
+// binário; lookup é o os.Getenv ou o Config.Env de quem embute o nó.
+type env struct {
+	lookup  func(string) string
+	dataDir string
+}
+
+func (e env) get(key, def string) string {
+	if v := e.lookup(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (e env) int(key string, def int) int {
+	if v := e.lookup(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func (e env) float(key string, def float64) float64 {
+	if v := e.lookup(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// path lê um caminho de arquivo; o padrão def vai para dentro do
+// Config.DataDir, se houver.
+func (e env) path(key, def string) string {
+	if e.dataDir != "" {
+		def = filepath.Join(e.dataDir, filepath.Base(def))
+	}
+	return e.get(key, def)
+}
+
+// list lê uma lista separada por vírgulas.
+func (e env) list(key string, def []string) []string {
+	v := e.lookup(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (e env) duration(key string, def time.Duration) time.Duration {
+	if v := e.lookup(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// GC_GRACE_SECONDS_BY_KEYSPACE: "users=3600,sessions=600"
+func parseGCGrace(defSecs int, perKeyspace string) (kv.GCGrace, error) {
+	g := kv.GCGrace{Default: time.Duration(defSecs) * time.Second, Keyspaces: make(map[string]time.Duration)}
+	for _, p := range strings.Split(perKeyspace, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pair := strings.SplitN(p, "=", 2)
+		if len(pair) != 2 {
+			return g, fmt.Errorf("invalid entry %q (want keyspace=seconds)", p)
+		}
+		secs, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || secs < 0 {
+			return g, fmt.Errorf("invalid seconds in %q", p)
+		}
+		g.Keyspaces[strings.TrimSpace(pair[0])] = time.Duration(secs) * time.Second
+	}
+	return g, nil
+}
+
+// MERGE_STRATEGY_BY_KEYSPACE: "counters=max,tags=union,carts=webhook:http://merger:9000/merge"
+func parseKeyspacePairs(env string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, p := range strings.Split(env, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pair := strings.SplitN(p, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid entry %q (want name=value)", p)
+		}
+		out[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return out, nil
+}
+
+// READ_CONSISTENCY_BY_KEYSPACE: "sessions=ONE,billing=QUORUM"
+func parseKeyspaceConsistency(env string) (map[string]cluster.Consistency, error) {
+	pairs, err := parseKeyspacePairs(env)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]cluster.Consistency, len(pairs))
+	for ks, v := range pairs {
+		cl, err := cluster.ParseConsistency(v)
+		if err != nil {
+			return nil, fmt.Errorf("keyspace %s: %w", ks, err)
+		}
+		out[ks] = cl
+	}
+	return out, nil
+}
+
+// ACL_RULES: "team-a:rw:orders:,team-b:r:orders:,*:r:public:" (identidade,
+// permissão r, w ou rw e prefixo, que pode ter ":"; prefixo vazio = tudo)
+func parseACLRules(env string) ([]api.ACLRule, error) {
+	var out []api.ACLRule
+	for _, p := range strings.Split(env, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		parts := strings.SplitN(p, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid rule %q (want identity:r|w|rw:prefix)", p)
+		}
+		rule := api.ACLRule{Identity: parts[0], Prefix: parts[2]}
+		switch parts[1] {
+		case "r":
+			rule.Read = true
+		case "w":
+			rule.Write = true
+		case "rw":
+			rule.Read, rule.Write = true, true
+		default:
+			return nil, fmt.Errorf("invalid permission %q in rule %q (want r, w or rw)", parts[1], p)
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// CLUSTER_NODES: "node1=localhost:8081,node2=localhost:8082,node3=localhost:8083"
+func parseClusterNodes(env string) []hashring.NodeInfo {
+	if env == "" {
+		return nil
+	}
+	parts := strings.Split(env, ",")
+	nodes := make([]hashring.NodeInfo, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pair := strings.SplitN(p, "=", 2)
+		if len(pair) != 2 {
+			log.Printf("[WARN] invalid CLUSTER_NODES entry: %s", p)
+			continue
+		}
+		id := pair[0]
+		host := pair[1]
+		nodes = append(nodes, hashring.NodeInfo{
+			ID:   hashring.NodeID(id),
+			Host: host,
+		})
+	}
+	return nodes
+}
+
+// OBSERVER_NODES: "obs1=localhost:8091,obs2=localhost:8092/analytics+events"
+func parseObserverNodes(env string) ([]cluster.Observer, error) {
+	var out []cluster.Observer
+	for _, p := range strings.Split(env, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pair := strings.SplitN(p, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("invalid entry %q (want id=host:port[/keyspace+...])", p)
+		}
+		host, keyspaces, _ := strings.Cut(pair[1], "/")
+		o := cluster.Observer{Node: hashring.NodeInfo{ID: hashring.NodeID(pair[0]), Host: host}}
+		for _, ks := range strings.Split(keyspaces, "+") {
+			if ks = strings.TrimSpace(ks); ks != "" {
+				o.Keyspaces = append(o.Keyspaces, ks)
+			}
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+func withoutNode(nodes []hashring.NodeInfo, id string) []hashring.NodeInfo {
+	out := make([]hashring.NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		if string(n.ID) != id {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func findSelfHost(nodes []hashring.NodeInfo, nodeID string, listenAddr string) string {
+	for _, n := range nodes {
+		if string(n.ID) == nodeID {
+			return n.Host
+		}
+	}
+	// fallback: monta algo a partir do listenAddr
+	// Ex: LISTEN_ADDR=":8080" -> "localhost:8080"
+	addr := strings.TrimPrefix(listenAddr, ":")
+	if addr == "" {
+		addr = "8080"
+	}
+	return "localhost:" + addr
+}
+
+// k8sDiscovery é o que DISCOVERY=k8s deriva do pod de um StatefulSet atrás
+// de um headless service: o pod <sts>-<n> é o nó node<n>, com o nome DNS
+// estável do pod como endereço (continua valendo quando o IP muda num
+// restart), e os primeiros pods são os seeds.
+type k8sDiscovery struct {
+	nodeID string
+	host   string
+	seeds  []string
+}
+
+func (e env) discoverK8s(listenAddr string) (k8sDiscovery, error) {
+	pod := e.get("POD_NAME", e.get("HOSTNAME", ""))
+	i := strings.LastIndex(pod, "-")
+	if i <= 0 {
+		return k8sDiscovery{}, fmt.Errorf("pod name %q is not <statefulset>-<ordinal> (set POD_NAME)", pod)
+	}
+	ordinal, err := strconv.Atoi(pod[i+1:])
+	if err != nil || ordinal < 0 {
+		return k8sDiscovery{}, fmt.Errorf("pod name %q has no ordinal", pod)
+	}
+	sts := pod[:i]
+	namespace := e.get("K8S_NAMESPACE", "")
+	if namespace == "" {
+		// namespace do service account montado no pod
+		if b, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+			namespace = strings.TrimSpace(string(b))
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	service := e.get("K8S_SERVICE", sts)
+	domain := e.get("K8S_CLUSTER_DOMAIN", "cluster.local")
+	port := listenAddr[strings.LastIndex(listenAddr, ":")+1:]
+	podHost := func(n int) string {
+		return fmt.Sprintf("%s-%d.%s.%s.svc.%s:%s", sts, n, service, namespace, domain, port)
+	}
+
+	d := k8sDiscovery{nodeID: fmt.Sprintf("node%d", ordinal), host: podHost(ordinal)}
+	for n := 0; n < e.int("K8S_SEEDS", 3); n++ {
+		d.seeds = append(d.seeds, podHost(n))
+	}
+	return d, nil
+}
+
+// BACKUP_TARGET: "local" (padrão, diretório BACKUP_DIR) ou "s3"
+func (e env) newBackupTarget() (backup.Target, error) {
+	switch kind := e.get("BACKUP_TARGET", "local"); kind {
+	case "local":
+		return backup.NewLocalTarget(e.path("BACKUP_DIR", "backups")), nil
+	case "s3":
+		return backup.NewS3Target(backup.S3Config{
+			Endpoint:     e.get("S3_ENDPOINT", ""),
+			Region:       e.get("S3_REGION", "us-east-1"),
+			Bucket:       e.get("S3_BUCKET", ""),
+			Prefix:       e.get("S3_PREFIX", ""),
+			AccessKey:    e.get("S3_ACCESS_KEY", ""),
+			SecretKey:    e.get("S3_SECRET_KEY", ""),
+			SessionToken: e.get("S3_SESSION_TOKEN", ""),
+		})
+	default:
+		return nil, fmt.Errorf("unknown BACKUP_TARGET %q", kind)
+	}
+}
@@ -0,0 +1,204 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"mini-cassandra/internal/cluster"
+)
+
+// Network é uma rede em memória entre nós do mesmo processo: um
+// http.RoundTripper que entrega cada requisição direto ao handler do nó
+// registrado com aquele host. Um host sem nó (parado, ou ainda não iniciado)
+// recusa a conexão, como um nó fora do ar.
+type Network struct {
+	mu     sync.RWMutex
+	nodes  map[string]http.Handler
+	faults func(from, to string, req *http.Request) Fault
+}
+
+// Fault é o que a Network faz com uma chamada (ver SetFaults).
+type Fault int
+
+const (
+	// Deliver entrega a chamada normalmente
+	Deliver Fault = iota
+	// Drop perde a chamada antes de chegar ao nó: quem chamou vê a conexão
+	// recusada, como com um nó fora do ar
+	Drop
+	// DropResponse entrega a chamada mas perde a resposta: o handler roda
+	// até o fim e quem chamou vê a conexão cair
+	DropResponse
+)
+
+// NewNetwork cria uma rede vazia; os nós entram nela no Start.
+func NewNetwork() *Network {
+	return &Network{nodes: make(map[string]http.Handler)}
+}
+
+// Register liga host a h (Start faz isso com o Host do nó).
+func (nw *Network) Register(host string, h http.Handler) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.nodes[host] = h
+}
+
+// Unregister tira host da rede: as próximas chamadas a ele são recusadas.
+func (nw *Network) Unregister(host string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.nodes, host)
+}
+
+// SetFaults instala fn para decidir o que acontece com cada chamada: from é
+// o NODE_ID de quem chamou (o NodeHeader das chamadas entre nós; vazio para
+// clientes) e to o host de destino. nil volta a entregar tudo. É como o
+// simulador (pkg/sim) perde mensagens.
+func (nw *Network) SetFaults(fn func(from, to string, req *http.Request) Fault) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.faults = fn
+}
+
+// Client é um http.Client que fala com os nós da rede
+// (http://<host do nó>/v1/kv/...).
+func (nw *Network) Client() *http.Client {
+	return &http.Client{Transport: nw}
+}
+
+// RoundTrip roda o handler do nó numa goroutine e devolve a resposta assim
+// que ele escreve os headers; o corpo chega por um pipe, então respostas em
+// streaming (stream de ranges, repair) não ficam inteiras na memória.
+func (nw *Network) RoundTrip(req *http.Request) (*http.Response, error) {
+	nw.mu.RLock()
+	h, ok := nw.nodes[req.URL.Host]
+	faults := nw.faults
+	nw.mu.RUnlock()
+	fault := Deliver
+	if ok && faults != nil {
+		fault = faults(req.Header.Get(cluster.NodeHeader), req.URL.Host, req)
+	}
+	if !ok || fault == Drop {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(req.URL.Host), Err: syscall.ECONNREFUSED}
+	}
+
+	// a requisição como o servidor HTTP a entregaria ao handler
+	sreq := req.Clone(req.Context())
+	sreq.RequestURI = req.URL.RequestURI()
+	sreq.RemoteAddr = "memory"
+	sreq.Host = req.URL.Host
+	if sreq.Body == nil {
+		sreq.Body = http.NoBody
+	}
+	if fault == DropResponse {
+		serveDiscarded(h, sreq)
+		return nil, &net.OpError{Op: "read", Net: "memory", Addr: memoryAddr(req.URL.Host), Err: syscall.ECONNRESET}
+	}
+
+	pr, pw := io.Pipe()
+	w := &memoryResponse{req: req, header: make(http.Header), body: pw, ready: make(chan *http.Response, 1)}
+	w.resp.Body = pr
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				pw.CloseWithError(fmt.Errorf("handler panic: %v", p))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			pw.Close()
+		}()
+		h.ServeHTTP(w, sreq)
+	}()
+
+	select {
+	case resp := <-w.ready:
+		return resp, nil
+	case <-req.Context().Done():
+		pr.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+}
+
+// serveDiscarded roda o handler até o fim e joga a resposta fora.
+func serveDiscarded(h http.Handler, req *http.Request) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("[HTTP] handler panic on %s: %v", req.URL.Path, p)
+		}
+	}()
+	h.ServeHTTP(discardResponse{header: make(http.Header)}, req)
+}
+
+type discardResponse struct {
+	header http.Header
+}
+
+func (w discardResponse) Header() http.Header         { return w.header }
+func (w discardResponse) WriteHeader(int)             {}
+func (w discardResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (w discardResponse) Flush()                      {}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryResponse é o http.ResponseWriter do lado do handler: os headers vão
+// na resposta entregue ao cliente no primeiro WriteHeader, o corpo pelo pipe.
+type memoryResponse struct {
+	req    *http.Request
+	header http.Header
+	body   *io.PipeWriter
+	ready  chan *http.Response
+
+	once sync.Once
+	resp http.Response
+}
+
+func (w *memoryResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *memoryResponse) WriteHeader(code int) {
+	w.once.Do(func() {
+		r := &w.resp
+		r.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+		r.StatusCode = code
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+		r.Header = w.header.Clone()
+		r.Request = w.req
+		r.ContentLength = -1
+		if cl, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64); err == nil {
+			r.ContentLength = cl
+		}
+		if w.req.Method == http.MethodHead {
+			r.Body.Close()
+			r.Body = http.NoBody
+		}
+		w.ready <- r
+	})
+}
+
+func (w *memoryResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.req.Method == http.MethodHead {
+		return len(p), nil
+	}
+	return w.body.Write(p)
+}
+
+// Flush existe para os handlers de streaming: a escrita no pipe já só
+// retorna quando o cliente leu.
+func (w *memoryResponse) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
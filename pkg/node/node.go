@@ -0,0 +1,1064 @@
+// Package node monta um nó completo do mini-cassandra (store, WAL, router,
+// handlers HTTP e as tarefas de background) para embutir o banco em outro
+// processo Go, ou subir clusters em testes e demos. O binário cmd/node é só
+// um New com as variáveis de ambiente do processo.
+//
+//	network := node.NewNetwork()
+//	for _, id := range []string{"node1", "node2", "node3"} {
+//		n, err := node.New(node.Config{
+//			Env: node.MapEnv(map[string]string{
+//				"NODE_ID":       id,
+//				"CLUSTER_NODES": "node1=node1,node2=node2,node3=node3",
+//			}),
+//			DataDir:  filepath.Join(dir, id),
+//			Network:  network,
+//			NoListen: true,
+//		})
+//		...
+//		n.Start()
+//		defer n.Stop(context.Background())
+//	}
+//	resp, err := network.Client().Get("http://node1/v1/kv/user:1")
+//
+// As métricas ficam num registro só por processo (metrics.Default), não por
+// nó: com vários nós no mesmo processo, o /metrics e o /debug/vars de
+// qualquer um deles mostram a soma de todos, e o STATSD_ADDR deve ficar em
+// um nó só (cada emissor recebe os eventos de todos os nós, com a tag do seu).
+package node
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mini-cassandra/hashring"
+	"mini-cassandra/internal/api"
+	"mini-cassandra/internal/backup"
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/hotkeys"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/internal/memcache"
+	"mini-cassandra/internal/metrics"
+	"mini-cassandra/internal/storage"
+	"mini-cassandra/internal/wal"
+)
+
+// Config diz de onde vem a configuração do nó e como ele fala com os outros.
+type Config struct {
+	// Env lê as mesmas variáveis do binário (NODE_ID, CLUSTER_NODES,
+	// WAL_DIR...); nil usa o ambiente do processo
+	Env func(key string) string
+	// Network liga o nó a uma rede em memória: as chamadas entre nós vão
+	// direto para o handler dos nós da mesma Network, pelo host de cada um
+	// no ring (CLUSTER_NODES), sem sockets
+	Network *Network
+	// DataDir troca o "data/" dos arquivos do nó (WAL, checkpoints, ring,
+	// hints, backups locais) quando as variáveis não dizem outro caminho;
+	// vários nós no mesmo processo precisam de um cada
+	DataDir string
+	// NoListen: Start não abre LISTEN_ADDR; quem embute serve Handler() no
+	// próprio servidor (ou só pela Network)
+	NoListen bool
+}
+
+// MapEnv é um Config.Env com valores fixos, para testes e demos.
+func MapEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// Node é um nó do cluster. Tudo é montado em New; Start abre o servidor e
+// inicia as tarefas de background, e Stop encerra os dois.
+type Node struct {
+	cfg        Config
+	id         string
+	host       string
+	listenAddr string
+	router     *cluster.Router
+	engine     *storage.Engine
+	handler    http.Handler
+
+	tasks        []func(ctx context.Context)
+	memcache     *memcache.Server
+	memcacheAddr string
+	// public é o handler do LISTEN_ADDR (sem /admin e /debug com
+	// ADMIN_LISTEN_ADDR)
+	public    http.Handler
+	adminAddr string
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	server  *http.Server
+	admin   *http.Server
+	mcLn    net.Listener
+	served  chan error
+	stopped bool
+}
+
+// New monta o nó: recupera os dados do WAL e dos checkpoints, aprende o ring
+// dos peers (SEEDS, RING_SYNC_ON_START) e confere a topologia. Os erros de
+// configuração voltam como erro, nada é iniciado antes do Start.
+func New(cfg Config) (*Node, error) {
+	lookup := cfg.Env
+	if lookup == nil {
+		lookup = os.Getenv
+	}
+	e := env{lookup: lookup, dataDir: cfg.DataDir}
+	n := &Node{cfg: cfg}
+
+	nodeID := e.get("NODE_ID", "node1")
+	listenAddr := e.get("LISTEN_ADDR", ":8081")
+	clusterEnv := e.get("CLUSTER_NODES", "")
+	// DISCOVERY=k8s: NODE_ID, endereço e SEEDS vêm do pod do StatefulSet
+	var k8s *k8sDiscovery
+	switch mode := e.get("DISCOVERY", "static"); mode {
+	case "static":
+	case "k8s":
+		d, err := e.discoverK8s(listenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("DISCOVERY=k8s: %w", err)
+		}
+		k8s = &d
+		nodeID = e.get("NODE_ID", d.nodeID)
+		log.Printf("[BOOT] Kubernetes discovery: node %s at %s", nodeID, d.host)
+	default:
+		return nil, fmt.Errorf("unknown DISCOVERY %q (use static or k8s)", mode)
+	}
+	// VNODES: vnodes por nó, igual em todos os nós (conferido no handshake);
+	// NODE_VNODES muda só o deste nó (ver mais abaixo)
+	vNodes := e.int("VNODES", 100)
+	if vNodes < 1 || vNodes > cluster.MaxNodeVNodes {
+		return nil, fmt.Errorf("VNODES must be between 1 and %d", cluster.MaxNodeVNodes)
+	}
+	nodeVNodes := e.int("NODE_VNODES", 0)
+	if nodeVNodes < 0 || nodeVNodes > cluster.MaxNodeVNodes {
+		return nil, fmt.Errorf("NODE_VNODES must be between 1 and %d", cluster.MaxNodeVNodes)
+	}
+	repFactor := e.int("REPLICATION_FACTOR", 3)
+
+	log.Printf("[BOOT] Starting node %s on %s", nodeID, listenAddr)
+
+	store := kv.NewStore()
+	// gc_grace_seconds: por quanto tempo os tombstones são guardados
+	gcGrace, err := parseGCGrace(e.int("GC_GRACE_SECONDS", int(kv.DefaultGCGrace/time.Second)), e.get("GC_GRACE_SECONDS_BY_KEYSPACE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("GC_GRACE_SECONDS_BY_KEYSPACE: %w", err)
+	}
+	store.SetGCGrace(gcGrace)
+	// últimas versões guardadas de cada chave (GET /kv/{key}/history); antes
+	// do replay do WAL, para que ele preencha o histórico
+	store.SetHistoryDepth(e.int("KEY_HISTORY_VERSIONS", 0))
+
+	// recupera o que foi gravado antes de um restart (checkpoints + WAL) e
+	// passa a registrar toda mutação do store. WAL_DIR vazio desliga o WAL.
+	engine, err := storage.Open(store,
+		e.path("WAL_DIR", "data/wal"),
+		e.get("WAL_ARCHIVE_DIR", ""),
+		e.path("CHECKPOINT_DIR", "data/checkpoints"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	if last := engine.LastActivity(); !last.IsZero() && time.Since(last) > gcGrace.Min() {
+		log.Printf("[WARN] node was down for %s, longer than gc_grace (%s): tombstones may already be purged elsewhere; run a full repair before serving, or deleted data may come back",
+			time.Since(last).Round(time.Second), gcGrace.Min())
+	}
+
+	// SEEDS=host:port,...: o ring vem de um seed no boot e um nó novo entra
+	// no cluster sozinho (CLUSTER_NODES vira opcional)
+	seeds := e.list("SEEDS", nil)
+	if k8s != nil && len(seeds) == 0 {
+		seeds = k8s.seeds
+	}
+	nodes := parseClusterNodes(clusterEnv)
+	if len(nodes) == 0 && len(seeds) > 0 {
+		log.Printf("[RING] No CLUSTER_NODES set, learning the ring from SEEDS %v", seeds)
+		selfHost := findSelfHost(nil, nodeID, listenAddr)
+		if k8s != nil {
+			selfHost = k8s.host
+		}
+		nodes = []hashring.NodeInfo{
+			{ID: hashring.NodeID(nodeID), Host: selfHost},
+		}
+	} else if len(nodes) == 0 {
+		log.Printf("[RING] No CLUSTER_NODES set, using single-node ring")
+		selfHost := findSelfHost(nil, nodeID, listenAddr)
+		nodes = []hashring.NodeInfo{
+			{ID: hashring.NodeID(nodeID), Host: selfHost},
+		}
+	} else {
+		log.Printf("[RING] Loaded %d nodes from CLUSTER_NODES", len(nodes))
+	}
+
+	selfHost := findSelfHost(nodes, nodeID, listenAddr)
+
+	// REPLACE_NODE=node3: este nó assume os tokens exatos do nó morto, em vez
+	// de entrar no anel com tokens próprios
+	replaceNode := e.get("REPLACE_NODE", "")
+	if replaceNode == nodeID {
+		return nil, fmt.Errorf("REPLACE_NODE must name the dead node, not this node (%s)", nodeID)
+	}
+	if replaceNode != "" {
+		nodes = withoutNode(nodes, nodeID)
+	}
+
+	// NODE_MODE=coordinator: nó sem tokens (não guarda dados), só roda o
+	// Router e encaminha as requisições — uma camada de coordenadores/load
+	// balancer na frente dos nós de dados. NODE_MODE=observer: também sem
+	// tokens, mas guarda a cópia assíncrona das escritas (OBSERVER_NODES nos
+	// outros nós) e responde as leituras ONE com ela
+	nodeMode := e.get("NODE_MODE", "storage")
+	coordinatorOnly, observer := false, false
+	switch nodeMode {
+	case "storage":
+	case "coordinator":
+		if replaceNode != "" {
+			return nil, fmt.Errorf("REPLACE_NODE cannot be used with NODE_MODE=coordinator")
+		}
+		coordinatorOnly = true
+		nodes = withoutNode(nodes, nodeID)
+		if len(nodes) == 0 && len(seeds) == 0 {
+			return nil, fmt.Errorf("NODE_MODE=coordinator needs the storage nodes in CLUSTER_NODES or SEEDS")
+		}
+		log.Printf("[NODE] Coordinator-only mode: owning no tokens, routing to %d storage nodes", len(nodes))
+	case "observer":
+		if replaceNode != "" {
+			return nil, fmt.Errorf("REPLACE_NODE cannot be used with NODE_MODE=observer")
+		}
+		observer = true
+		nodes = withoutNode(nodes, nodeID)
+		if len(nodes) == 0 && len(seeds) == 0 {
+			return nil, fmt.Errorf("NODE_MODE=observer needs the storage nodes in CLUSTER_NODES or SEEDS")
+		}
+		log.Printf("[NODE] Observer mode: owning no tokens, receiving a copy of the writes")
+	default:
+		return nil, fmt.Errorf("unknown NODE_MODE %q (use storage, coordinator or observer)", nodeMode)
+	}
+
+	ring := hashring.NewRing(nodes, vNodes)
+
+	log.Printf("[NODE] Self host resolved as %s", selfHost)
+	log.Printf("[REPL] Replication factor = %d", repFactor)
+
+	router := cluster.NewRouter(store, hashring.NodeID(nodeID), selfHost, ring, repFactor)
+	router.SetCoordinatorOnly(coordinatorOnly)
+	router.SetObserver(observer)
+	// OBSERVER_NODES=obs1=host:port/analytics+events: observadores que
+	// recebem a cópia das escritas coordenadas aqui (sem /... = todos os
+	// keyspaces); mesma lista em todos os nós
+	observers, err := parseObserverNodes(e.get("OBSERVER_NODES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("OBSERVER_NODES: %w", err)
+	}
+	router.SetObservers(observers, e.int("OBSERVER_QUEUE", cluster.DefaultObserverQueue))
+	// rede em memória: as chamadas aos outros nós não abrem sockets
+	if cfg.Network != nil {
+		router.SetTransport(cfg.Network)
+	}
+	// tokens movidos em runtime (move-token) sobrevivem a restarts
+	if err := router.LoadRingState(e.path("RING_STATE_FILE", "data/ring.json")); err != nil {
+		return nil, fmt.Errorf("ring state: %w", err)
+	}
+	// índices secundários declarados em /admin/indexes (reconstruídos a
+	// partir dos dados já recuperados)
+	if err := router.LoadIndexes(e.path("INDEX_STATE_FILE", "data/indexes.json")); err != nil {
+		return nil, fmt.Errorf("index state: %w", err)
+	}
+	// restrições de valor por keyspace (/admin/schemas), conferidas pelo
+	// coordenador antes de replicar; a sincronização periódica traz as que
+	// mudaram enquanto o nó estava fora do ar (e as de coordenadores e
+	// observadores, que ficam fora do broadcast)
+	if err := router.LoadSchemas(e.path("SCHEMA_STATE_FILE", "data/schemas.json")); err != nil {
+		return nil, fmt.Errorf("schema state: %w", err)
+	}
+	schemaSync := e.duration("SCHEMA_SYNC_INTERVAL", cluster.DefaultSchemaSyncInterval)
+	n.background(func(ctx context.Context) { router.RunSchemaSync(ctx, schemaSync) })
+	// fator de replicação por keyspace (/admin/replication), que sobrepõe o
+	// REPLICATION_FACTOR e se propaga como os schemas
+	if err := router.LoadReplication(e.path("REPLICATION_STATE_FILE", "data/replication.json")); err != nil {
+		return nil, fmt.Errorf("replication state: %w", err)
+	}
+	replicationSync := e.duration("REPLICATION_SYNC_INTERVAL", cluster.DefaultReplicationSyncInterval)
+	n.background(func(ctx context.Context) { router.RunReplicationSync(ctx, replicationSync) })
+	// progresso do streaming de bootstrap, para retomar depois de um restart
+	router.SetStreamProgressFile(e.path("BOOTSTRAP_STATE_FILE", "data/bootstrap.json"))
+	// marcadores do repair incremental (até onde cada intervalo foi reparado)
+	if err := router.LoadRepairState(e.path("REPAIR_STATE_FILE", "data/repair.json")); err != nil {
+		return nil, fmt.Errorf("repair state: %w", err)
+	}
+
+	// remoção em background das chaves com TTL vencido
+	sweepInterval, sweepBatch := e.duration("TTL_SWEEP_INTERVAL", 10*time.Second), e.int("TTL_SWEEP_BATCH", 500)
+	n.background(func(ctx context.Context) { store.RunExpirySweeper(ctx, sweepInterval, sweepBatch) })
+
+	// flush automático dos checkpoints: por volume de mutações desde o
+	// último flush de cada keyspace e/ou por intervalo (0 desliga)
+	engine.SetFlushPolicy(storage.FlushPolicy{
+		MaxBytes:    int64(e.int("MEMTABLE_FLUSH_BYTES", 0)),
+		MaxEntries:  e.int("MEMTABLE_FLUSH_ENTRIES", 0),
+		Interval:    e.duration("FLUSH_INTERVAL", 0),
+		Concurrency: e.int("FLUSH_CONCURRENCY", 1),
+		PurgeWAL:    e.get("WAL_PURGE_FLUSHED", "false") == "true",
+	})
+	n.background(engine.RunAutoFlush)
+
+	// hinted handoff: taxa do replay por nó, janela máxima e limites da fila
+	router.SetHintPolicy(cluster.HintPolicy{
+		Rate:     e.float("HINT_REPLAY_RATE", cluster.DefaultHintReplayRate),
+		Window:   e.duration("MAX_HINT_WINDOW", cluster.DefaultMaxHintWindow),
+		MaxBytes: int64(e.int("HINT_MAX_MB_PER_NODE", cluster.DefaultHintMaxBytes>>20)) << 20,
+		TTL:      e.duration("HINT_TTL", cluster.DefaultHintTTL),
+	})
+	// hints pendentes sobrevivem a restarts do coordenador
+	if err := router.LoadHints(e.path("HINTS_DIR", "data/hints")); err != nil {
+		return nil, fmt.Errorf("hints: %w", err)
+	}
+	n.background(router.RunHintReplay)
+	n.background(router.RunObserverFeeds)
+	// nós cronicamente lentos ou falhando ficam por último nas leituras
+	// (continuam recebendo as escritas) até as medidas melhorarem
+	slowPeers := cluster.DefaultSlowPeerPolicy()
+	router.SetSlowPeerPolicy(cluster.SlowPeerPolicy{
+		LatencyFactor: e.float("SLOW_PEER_LATENCY_FACTOR", slowPeers.LatencyFactor),
+		MinLatency:    e.duration("SLOW_PEER_MIN_LATENCY", slowPeers.MinLatency),
+		ErrorRate:     e.float("SLOW_PEER_ERROR_RATE", slowPeers.ErrorRate),
+		DemoteAfter:   e.duration("SLOW_PEER_DEMOTE_AFTER", slowPeers.DemoteAfter),
+		RecoverAfter:  e.duration("SLOW_PEER_RECOVER_AFTER", slowPeers.RecoverAfter),
+	})
+	n.background(router.RunSlowPeerDetector)
+	// confirmações exigidas por PUT e DELETE (sobrescrevível com ?consistency=)
+	writeCL, err := cluster.ParseConsistency(e.get("WRITE_CONSISTENCY", string(cluster.DefaultWriteConsistency)))
+	if err != nil {
+		return nil, fmt.Errorf("WRITE_CONSISTENCY: %w", err)
+	}
+	router.SetWriteConsistency(writeCL)
+	readCL, err := cluster.ParseConsistency(e.get("READ_CONSISTENCY", string(cluster.DefaultReadConsistency)))
+	if err != nil {
+		return nil, fmt.Errorf("READ_CONSISTENCY: %w", err)
+	}
+	router.SetReadConsistency(readCL)
+	// padrões por keyspace: "sessions=ONE,billing=QUORUM" (o resto usa os
+	// do nó; ?consistency= continua valendo)
+	readByKS, err := parseKeyspaceConsistency(e.get("READ_CONSISTENCY_BY_KEYSPACE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("READ_CONSISTENCY_BY_KEYSPACE: %w", err)
+	}
+	writeByKS, err := parseKeyspaceConsistency(e.get("WRITE_CONSISTENCY_BY_KEYSPACE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("WRITE_CONSISTENCY_BY_KEYSPACE: %w", err)
+	}
+	router.SetKeyspaceConsistency(readByKS, writeByKS)
+	router.SetClusterName(e.get("CLUSTER_NAME", cluster.DefaultClusterName))
+	// segredo compartilhado das chamadas /internal/* (vazio: qualquer um que
+	// alcance a porta fala com as réplicas)
+	router.SetClusterSecret(e.get("CLUSTER_SECRET", ""))
+	// compressão dos corpos entre nós (replicação, streaming, repair)
+	compression, err := cluster.ParseCompression(e.get("INTERNODE_COMPRESSION", cluster.CompressionNone))
+	if err != nil {
+		return nil, fmt.Errorf("INTERNODE_COMPRESSION: %w", err)
+	}
+	router.SetInternodeCompression(compression, e.int("INTERNODE_COMPRESSION_MIN_BYTES", cluster.DefaultCompressionMinBytes))
+	// formato das mutações enviadas às réplicas (json ou msgpack), combinado
+	// com cada nó no handshake
+	codec, err := cluster.ParseCodec(e.get("INTERNODE_CODEC", cluster.CodecJSON))
+	if err != nil {
+		return nil, fmt.Errorf("INTERNODE_CODEC: %w", err)
+	}
+	router.SetInternodeCodec(codec)
+	// metadados anunciados aos outros nós (GET /cluster/status)
+	router.SetNodeMeta(e.get("DATACENTER", ""), e.get("RACK", ""), int64(e.int("NODE_CAPACITY_GB", 0))<<30)
+	// metadados de aplicação (zona, build, manutenção) anunciados no
+	// handshake; PATCH /admin/metadata muda em runtime
+	appMeta, err := parseKeyspacePairs(e.get("NODE_METADATA", ""))
+	if err != nil {
+		return nil, fmt.Errorf("NODE_METADATA: %w", err)
+	}
+	if err := router.LoadAppMetadata(appMeta, e.path("NODE_METADATA_FILE", "data/node_metadata.json")); err != nil {
+		return nil, fmt.Errorf("NODE_METADATA: %w", err)
+	}
+	// ring atual de um peer: corrige um CLUSTER_NODES desatualizado (tokens
+	// movidos, nós que faltam, endereços) antes de aceitar requisições
+	joining := false
+	if len(seeds) > 0 {
+		member, found, err := router.LearnRing(context.Background(), seeds)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("SEEDS: %w", err)
+		case !found:
+			log.Printf("[RING] no seed reachable, using the local ring")
+		default:
+			joining = !member && !coordinatorOnly && !observer && replaceNode == ""
+		}
+		if len(router.Nodes()) == 0 {
+			return nil, fmt.Errorf("SEEDS: no seed reachable and no local ring to route with")
+		}
+	} else if e.get("RING_SYNC_ON_START", "true") == "true" && len(nodes) > 1 {
+		if _, found, err := router.ReconcileRing(context.Background()); err != nil {
+			return nil, fmt.Errorf("ring sync: %w", err)
+		} else if !found {
+			log.Printf("[RING] no peer reachable, using the local ring")
+		}
+	}
+	// confere ID, endereço, vnodes e RF com os peers antes de servir: com
+	// dois nós com o mesmo NODE_ID (ou outro RF) as réplicas divergem em
+	// silêncio. TOPOLOGY_CHECK=warn só registra, off desliga
+	switch mode := e.get("TOPOLOGY_CHECK", "strict"); mode {
+	case "strict", "warn":
+		if err := cluster.LogTopologyReport(router.ValidateTopology(context.Background())); err != nil {
+			if mode == "strict" {
+				return nil, fmt.Errorf("%v (fix the configuration, or set TOPOLOGY_CHECK=warn to start anyway)", err)
+			}
+			log.Printf("[TOPOLOGY] %v", err)
+		}
+	case "off":
+	default:
+		return nil, fmt.Errorf("unknown TOPOLOGY_CHECK %q (use strict, warn or off)", mode)
+	}
+	// STATSD_ADDR=host:8125 envia as métricas também para um agente
+	// StatsD/DogStatsD (além do expvar em /debug/vars). O emissor entra no
+	// registro do processo só enquanto o nó roda: um nó parado (ou
+	// reiniciado no mesmo processo) não deixa o dele para trás
+	if addr := e.get("STATSD_ADDR", ""); addr != "" {
+		tags := append(e.list("STATSD_TAGS", nil), "node:"+nodeID)
+		sd, err := metrics.NewStatsD(addr, e.get("STATSD_PREFIX", "mini_cassandra"), tags)
+		if err != nil {
+			return nil, fmt.Errorf("STATSD_ADDR: %w", err)
+		}
+		flush := e.duration("STATSD_FLUSH_INTERVAL", time.Second)
+		n.background(func(ctx context.Context) {
+			metrics.Default.AddSink(sd)
+			defer metrics.Default.RemoveSink(sd)
+			sd.Run(ctx, flush)
+		})
+		log.Printf("[METRICS] sending metrics to statsd at %s", addr)
+	}
+
+	// fração das leituras que comparam todas as réplicas em background
+	router.SetReadRepairChance(e.float("READ_REPAIR_CHANCE", cluster.DefaultReadRepairChance))
+	// resolução de conflitos nas leituras: last-write-wins, a não ser nos
+	// keyspaces com uma estratégia de merge
+	strategies, err := parseKeyspacePairs(e.get("MERGE_STRATEGY_BY_KEYSPACE", ""))
+	if err == nil {
+		err = router.SetMergeStrategies(strategies)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("MERGE_STRATEGY_BY_KEYSPACE: %w", err)
+	}
+	for ks, name := range router.MergeStrategies() {
+		log.Printf("[MERGE] keyspace %s resolves conflicting replicas with %s", ks, name)
+	}
+	// prazo de cada chamada de réplica e o máximo que um cliente pode pedir
+	// com X-Timeout
+	router.SetRequestTimeouts(
+		e.duration("REPLICA_TIMEOUT", cluster.DefaultReplicaTimeout),
+		e.duration("MAX_REQUEST_TIMEOUT", cluster.DefaultMaxRequestTimeout),
+	)
+
+	// guard de disco: somente leitura quando o filesystem de algum diretório
+	// de dados passa de DISK_MAX_USED_PERCENT (0 desliga)
+	var dataDirs []string
+	for _, dir := range []string{e.path("WAL_DIR", "data/wal"), e.path("CHECKPOINT_DIR", "data/checkpoints")} {
+		if dir != "" {
+			dataDirs = append(dataDirs, dir)
+		}
+	}
+	diskPolicy := cluster.DiskGuardPolicy{
+		Paths:          dataDirs,
+		MaxUsedPercent: e.float("DISK_MAX_USED_PERCENT", cluster.DefaultDiskMaxUsedPercent),
+		ResumePercent:  e.float("DISK_RESUME_PERCENT", cluster.DefaultDiskResumePercent),
+		Interval:       e.duration("DISK_CHECK_INTERVAL", cluster.DefaultDiskCheckInterval),
+	}
+	n.background(func(ctx context.Context) { router.RunDiskGuard(ctx, diskPolicy, storage.FilesystemUsage) })
+
+	backupTarget, err := e.newBackupTarget()
+	if err != nil {
+		return nil, fmt.Errorf("backup target: %w", err)
+	}
+	backups := backup.NewManager(store, backupTarget, engine.WAL(), nodeID)
+
+	if walLog := engine.WAL(); walLog != nil {
+		// troca de segmento por tamanho, além da troca em cada flush
+		walLog.SetMaxSegmentBytes(int64(e.int("WAL_SEGMENT_BYTES", wal.DefaultMaxSegmentBytes)))
+		// WAL_SYNC: none (só o SO), always (fsync em cada escrita, em grupo
+		// com as concorrentes) ou group (um fsync a cada WAL_SYNC_INTERVAL)
+		syncMode, err := wal.ParseSyncMode(e.get("WAL_SYNC", string(wal.SyncNone)))
+		if err != nil {
+			return nil, fmt.Errorf("WAL_SYNC: %w", err)
+		}
+		walLog.SetSync(syncMode, e.duration("WAL_SYNC_INTERVAL", wal.DefaultSyncInterval))
+		// WAL_ARCHIVE_REMOTE=true: segmentos fechados também vão para o
+		// BACKUP_TARGET (ex: bucket S3), para restore point-in-time sem disco local
+		if e.get("WAL_ARCHIVE_REMOTE", "false") == "true" {
+			upload, uploaded, err := backup.WALArchive(context.Background(), backupTarget, nodeID)
+			if err != nil {
+				return nil, fmt.Errorf("wal remote archive: %w", err)
+			}
+			if err := walLog.SetRemoteArchive(upload, uploaded); err != nil {
+				return nil, fmt.Errorf("wal remote archive: %w", err)
+			}
+		}
+	}
+
+	// amostragem de acessos por chave para /admin/hotkeys
+	hot := hotkeys.New(e.float("HOTKEYS_SAMPLE_RATE", 0.1), 1024, time.Minute)
+
+	// listener no protocolo texto do memcached (MEMCACHED_ADDR vazio desliga)
+	if addr := e.get("MEMCACHED_ADDR", ""); addr != "" {
+		n.memcacheAddr = addr
+		n.memcache = memcache.New(router, hot, memcache.Config{
+			Keyspace:     e.get("MEMCACHED_KEYSPACE", ""),
+			MaxItemBytes: e.int("MEMCACHED_MAX_ITEM_BYTES", memcache.DefaultMaxItemBytes),
+			IdleTimeout:  e.duration("MEMCACHED_IDLE_TIMEOUT", 0),
+		})
+	}
+
+	if replaceNode != "" {
+		// substituição: busca os dados do nó morto nas réplicas vivas (o
+		// servidor HTTP precisa estar no ar para receber o streaming).
+		// Roda como job: acompanhe em /admin/jobs.
+		router.SetBootstrapping(true)
+		n.after(2*time.Second, func() { router.StartReplace(hashring.NodeID(replaceNode)) })
+	} else if joining {
+		router.SetBootstrapping(true)
+		// nó novo entrando pelos seeds: busca os trechos que passam a ser
+		// dele e se anuncia (job "bootstrap" em /admin/jobs)
+		n.after(2*time.Second, func() { router.StartJoin() })
+	} else if !coordinatorOnly && !observer {
+		// 🔥 iniciar rebalance em background (job "rebalance" em /admin/jobs)
+		// pequeno delay pra todo mundo subir (ajuste se quiser)
+		n.after(5*time.Second, func() { router.StartRebalance(30 * time.Second) })
+	}
+	// NODE_VNODES diferente do número deste nó no ring: migra em runtime
+	// (job "vnodes"), como um POST /admin/vnodes feito por este nó
+	if from := ring.NodeVNodes(hashring.NodeID(nodeID)); nodeVNodes > 0 && nodeVNodes != from {
+		if replaceNode != "" || joining || coordinatorOnly || observer {
+			log.Printf("[VNODES] ignoring NODE_VNODES=%d: only a storage node already in the ring can change its vnodes (use POST /admin/vnodes later)", nodeVNodes)
+		} else {
+			n.after(10*time.Second, func() {
+				if job, err := router.StartVNodesChange(hashring.NodeID(nodeID), nodeVNodes); err != nil {
+					log.Printf("[VNODES] NODE_VNODES=%d: %v", nodeVNodes, err)
+				} else {
+					log.Printf("[VNODES] NODE_VNODES=%d: migrating from %d vnodes (%s)", nodeVNodes, from, job.ID)
+				}
+			})
+		}
+	}
+
+	// manutenção periódica: rebalance + repair dos intervalos primários a
+	// cada MAINTENANCE_INTERVAL (0 desliga), só dentro de MAINTENANCE_WINDOWS
+	maintWindows, err := cluster.ParseTimeWindows(e.get("MAINTENANCE_WINDOWS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("MAINTENANCE_WINDOWS: %w", err)
+	}
+	maintRepair, err := cluster.ParseMaintenanceRepair(e.get("MAINTENANCE_REPAIR", cluster.MaintenanceRepairIncremental))
+	if err != nil {
+		return nil, fmt.Errorf("MAINTENANCE_REPAIR: %w", err)
+	}
+	maintPolicy := cluster.MaintenancePolicy{
+		Interval:  e.duration("MAINTENANCE_INTERVAL", 0),
+		Windows:   maintWindows,
+		Rebalance: e.get("MAINTENANCE_REBALANCE", "true") == "true",
+		Repair:    maintRepair,
+	}
+	n.background(func(ctx context.Context) { router.RunMaintenance(ctx, maintPolicy) })
+
+	// anti-entropy contínuo: a cada ANTI_ENTROPY_INTERVAL (0 desliga), um
+	// pedaço sorteado de um intervalo é comparado e reparado com as réplicas
+	aePolicy := cluster.AntiEntropyPolicy{
+		Interval:    e.duration("ANTI_ENTROPY_INTERVAL", 0),
+		Splits:      e.int("ANTI_ENTROPY_SPLITS", cluster.DefaultAntiEntropySplits),
+		BytesPerSec: int64(e.int("ANTI_ENTROPY_MAX_KB_PER_SEC", cluster.DefaultAntiEntropyBytesPerSec>>10)) << 10,
+	}
+	router.SetAntiEntropyMaxRate(aePolicy.BytesPerSec)
+	n.background(func(ctx context.Context) { router.RunAntiEntropy(ctx, aePolicy) })
+
+	// configurações de runtime do cluster (/admin/settings): os valores do
+	// env acima são os de boot; os definidos para o cluster valem mais e
+	// voltam do arquivo de estado
+	if err := router.LoadSettings(e.path("SETTINGS_STATE_FILE", "data/settings.json")); err != nil {
+		return nil, fmt.Errorf("settings state: %w", err)
+	}
+	settingsSync := e.duration("SETTINGS_SYNC_INTERVAL", cluster.DefaultSettingsSyncInterval)
+	n.background(func(ctx context.Context) { router.RunSettingsSync(ctx, settingsSync) })
+
+	// mudanças do ring anunciadas pelos outros nós: RING_CHANGE_DEBOUNCE sem
+	// mudanças (no máximo RING_CHANGE_MAX_DELAY) e o nó busca os trechos
+	// novos e roda o rebalance (0 desliga)
+	watchPolicy := cluster.RingWatchPolicy{
+		Debounce: e.duration("RING_CHANGE_DEBOUNCE", cluster.DefaultRingChangeDebounce),
+		MaxDelay: e.duration("RING_CHANGE_MAX_DELAY", cluster.DefaultRingChangeMaxDelay),
+	}
+	n.background(func(ctx context.Context) { router.RunRingWatch(ctx, watchPolicy) })
+	// época do ring mais nova vista num peer: busca o ring dele (um
+	// coordenador com o ring velho tem as mutações recusadas pelas réplicas)
+	n.background(router.RunRingEpochSync)
+
+	// tamanho máximo de chaves e valores (API de cliente, import e réplicas)
+	limits := api.Limits{
+		MaxKeyLength:  e.int("MAX_KEY_LENGTH", api.DefaultMaxKeyLength),
+		MaxValueBytes: int64(e.int("MAX_VALUE_BYTES", api.DefaultMaxValueBytes)),
+	}
+	// valores acima de LARGE_OBJECT_THRESHOLD vão em chunks espalhados pelo
+	// ring (desligado por padrão: ligue com todos os nós atualizados)
+	router.SetLargeObjects(cluster.LargeObjectConfig{
+		Threshold: e.int("LARGE_OBJECT_THRESHOLD", 0),
+		ChunkSize: e.int("LARGE_OBJECT_CHUNK_BYTES", cluster.DefaultLargeObjectChunkSize),
+	})
+	if lo := router.LargeObjects(); lo.Threshold > 0 {
+		if limits.MaxValueBytes > 0 && int64(lo.ChunkSize) > limits.MaxValueBytes {
+			return nil, fmt.Errorf("LARGE_OBJECT_CHUNK_BYTES=%d is above MAX_VALUE_BYTES=%d: replicas would refuse the chunks", lo.ChunkSize, limits.MaxValueBytes)
+		}
+		log.Printf("[LARGE] values above %d bytes are stored in %d-byte chunks", lo.Threshold, lo.ChunkSize)
+	}
+
+	r := mux.NewRouter()
+	// middlewares de todas as rotas, na ordem (os mesmos no HTTP_ROUTER=fast)
+	var mws []mux.MiddlewareFunc
+	// latência e status de cada rota (GET /metrics); antes dos outros
+	// middlewares, para medir também o que eles recusam
+	mws = append(mws, api.RequestMetricsMiddleware)
+	// versão do protocolo interno e CLUSTER_NAME em toda chamada /internal/*
+	mws = append(mws, api.ProtocolMiddleware(router))
+	// controle de acesso da API de cliente: tokens de AUTH_TOKENS e regras
+	// de ACL_RULES por prefixo de chave (sem tokens, desligado)
+	tokens, err := parseKeyspacePairs(e.get("AUTH_TOKENS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_TOKENS: %w", err)
+	}
+	aclRules, err := parseACLRules(e.get("ACL_RULES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("ACL_RULES: %w", err)
+	}
+	acl, err := api.NewACL(api.ACLConfig{Tokens: tokens, Rules: aclRules})
+	if err != nil {
+		return nil, fmt.Errorf("ACL_RULES: %w", err)
+	}
+	if acl != nil {
+		log.Printf("[ACL] client API requires a token: %d identities, %d rules", len(tokens), len(aclRules))
+		if e.get("MEMCACHED_ADDR", "") != "" {
+			log.Printf("[WARN] MEMCACHED_ADDR is set: the memcached listener does not authenticate and bypasses ACL_RULES")
+		}
+		if e.get("CLUSTER_SECRET", "") == "" {
+			log.Printf("[WARN] CLUSTER_SECRET is empty: clients can bypass ACL_RULES through /internal/*")
+		}
+	}
+	mws = append(mws, api.ACLMiddleware(acl))
+	// ADMIN_TOKEN em /admin/* e /debug/* (vazio: sem checagem)
+	mws = append(mws, api.AdminTokenMiddleware(e.get("ADMIN_TOKEN", "")))
+	// controle de admissão por memória: perto de MEMORY_LIMIT_MB (ou do
+	// GOMEMLIMIT), escritas grandes e lotes recebem 503
+	memLimit := int64(e.int("MEMORY_LIMIT_MB", 0)) << 20
+	if l := debug.SetMemoryLimit(-1); memLimit == 0 && l < math.MaxInt64 {
+		memLimit = l
+	}
+	memGuard := api.NewMemoryGuard(api.MemoryGuardConfig{
+		LimitBytes: memLimit,
+		HighWater:  e.float("MEMORY_HIGH_WATER", api.DefaultMemoryHighWater),
+		LargeBytes: int64(e.int("MEMORY_LARGE_WRITE_BYTES", api.DefaultMemoryLargeBytes)),
+	})
+	n.background(memGuard.Run)
+	mws = append(mws, memGuard.Middleware)
+	// latência e erros injetados nas chamadas de réplica (só em testes)
+	if e.get("FAULT_INJECTION", "false") == "true" {
+		latency, err := api.ParseLatencyDist(e.get("FAULT_LATENCY", ""))
+		if err != nil {
+			return nil, fmt.Errorf("FAULT_LATENCY: %w", err)
+		}
+		mws = append(mws, api.FaultMiddleware(api.FaultConfig{
+			Latency:     latency,
+			ErrorRate:   e.float("FAULT_ERROR_RATE", 0),
+			ErrorStatus: e.int("FAULT_ERROR_STATUS", http.StatusServiceUnavailable),
+			PathPrefix:  e.get("FAULT_PATH_PREFIX", api.DefaultFaultPathPrefix),
+		}))
+	}
+	// ?trace=true: linha do tempo das réplicas em GET /admin/traces/{id}
+	mws = append(mws, api.TraceMiddleware(router))
+	// X-Coordinator, X-Replicas-Contacted/-Acked, X-Served-By e
+	// X-Consistency-* em toda resposta de cliente
+	mws = append(mws, api.OutcomeMiddleware(router))
+	// gzip nas respostas de cliente maiores que GZIP_MIN_BYTES (0 desliga)
+	mws = append(mws, api.GzipMiddleware(e.int("GZIP_MIN_BYTES", api.DefaultGzipMinBytes)))
+	r.Use(mws...)
+
+	// rotas quentes: as mesmas instâncias no mux e no HTTP_ROUTER=fast
+	kvHot := map[string]http.HandlerFunc{
+		http.MethodPut:    api.HandlePutDistributed(router, hot, limits),
+		http.MethodGet:    api.HandleGetDistributed(router, hot),
+		http.MethodHead:   api.HandleHeadDistributed(router, hot),
+		http.MethodDelete: api.HandleDeleteDistributed(router, hot),
+	}
+	replicaPut := api.HandleReplicaPut(store, hot, limits)
+	replicaGet := api.HandleReplicaGet(router, store, hot)
+	replicaDelete := api.HandleReplicaDelete(store, hot, limits)
+
+	// externos (cliente): /v1/kv/{key}; o caminho antigo /kv/{key} continua
+	// funcionando como alias deprecado da v1
+	kvRoutes := func(sr *mux.Router, wrap func(http.HandlerFunc) http.HandlerFunc) {
+		sr.HandleFunc("/kv/_mdelete", wrap(api.HandleMultiDelete(router, hot, limits))).Methods("POST")
+		for method, h := range kvHot {
+			sr.HandleFunc("/kv/{key}", wrap(h)).Methods(method)
+		}
+		sr.HandleFunc("/kv/{key}", wrap(api.HandlePatch(router, hot, limits))).Methods("PATCH")
+		sr.HandleFunc("/kv/{key}/getset", wrap(api.HandleGetSet(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/incr", wrap(api.HandleIncr(router, hot))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/expire", wrap(api.HandleExpire(router, hot, false))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/persist", wrap(api.HandleExpire(router, hot, true))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/rename", wrap(api.HandleRename(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/copy", wrap(api.HandleCopy(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/history", wrap(api.HandleHistory(router, store))).Methods("GET")
+		sr.HandleFunc("/kv/{key}/set", wrap(api.HandleCRDTGet(router, hot, kv.CRDTSet))).Methods("GET")
+		sr.HandleFunc("/kv/{key}/set/add", wrap(api.HandleSetAdd(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/set/remove", wrap(api.HandleSetRemove(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/map", wrap(api.HandleCRDTGet(router, hot, kv.CRDTMap))).Methods("GET")
+		sr.HandleFunc("/kv/{key}/map/put", wrap(api.HandleMapPut(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/kv/{key}/map/remove", wrap(api.HandleMapRemove(router, hot, limits))).Methods("POST")
+		sr.HandleFunc("/index/{name}", wrap(api.HandleIndexQuery(router))).Methods("GET")
+	}
+	kvRoutes(r.PathPrefix(api.APIVersion).Subrouter(), func(h http.HandlerFunc) http.HandlerFunc { return h })
+	r.HandleFunc(api.APIVersion+"/scan", api.HandleScan(router)).Methods("GET")
+	r.HandleFunc(api.APIVersion+"/aggregate", api.HandleAggregate(router)).Methods("GET")
+	r.HandleFunc(api.APIVersion+"/scan/jobs", api.HandleStartScanJob(router)).Methods("POST")
+	r.HandleFunc(api.APIVersion+"/scan/jobs", api.HandleScanJobs(router)).Methods("GET")
+	r.HandleFunc(api.APIVersion+"/scan/jobs/{id}", api.HandleScanJob(router)).Methods("GET")
+	r.HandleFunc(api.APIVersion+"/scan/jobs/{id}", api.HandleCancelScanJob(router)).Methods("DELETE")
+	r.HandleFunc(api.APIVersion+"/scan/jobs/{id}/results", api.HandleScanJobResults(router)).Methods("GET")
+	kvRoutes(r, api.LegacyPath)
+
+	// internos (replicação)
+	r.HandleFunc(cluster.HandshakePath, api.HandleInternalHandshake(router)).Methods("GET")
+	r.HandleFunc("/internal/replica/put", replicaPut).Methods("POST")
+	r.HandleFunc("/internal/replica/get", replicaGet).Methods("GET")
+	r.HandleFunc(cluster.ReplicaBatchPath, api.HandleReplicaBatch(store, hot, limits)).Methods("POST")
+	r.HandleFunc(cluster.ReplicaHistoryPath, api.HandleReplicaHistory(store)).Methods("GET")
+	r.HandleFunc("/internal/replica/delete", replicaDelete).Methods("POST")
+	r.HandleFunc(cluster.ReplicaCASPath, api.HandleReplicaCAS(store, hot, limits)).Methods("POST")
+	r.HandleFunc(cluster.CASPath, api.HandleInternalCAS(router)).Methods("POST")
+	r.HandleFunc("/internal/stats/hll", api.HandleInternalHLL(router, store)).Methods("GET")
+	r.HandleFunc("/internal/hotkeys", api.HandleInternalHotKeys(router, hot)).Methods("GET")
+	r.HandleFunc("/internal/ranges/sizes", api.HandleInternalRangeSizes(router)).Methods("GET")
+	r.HandleFunc("/internal/rebalance/plan", api.HandleInternalRebalancePlan(router)).Methods("POST")
+	r.HandleFunc(cluster.RingPath, api.HandleInternalRing(router)).Methods("GET")
+	r.HandleFunc(cluster.RingJoinPath, api.HandleInternalRingJoin(router)).Methods("POST")
+	r.HandleFunc("/internal/ring/token", api.HandleInternalRingToken(router)).Methods("POST")
+	r.HandleFunc(cluster.VNodesPath, api.HandleInternalRingVNodes(router)).Methods("POST")
+	r.HandleFunc(cluster.PendingRangesPath, api.HandleInternalSetPendingRanges(router)).Methods("POST")
+	r.HandleFunc(cluster.PendingRangesPath, api.HandleInternalClearPendingRanges(router)).Methods("DELETE")
+	r.HandleFunc("/internal/ring/replace", api.HandleInternalRingReplace(router)).Methods("POST")
+	r.HandleFunc(cluster.ScanPath, api.HandleInternalScan(router)).Methods("POST")
+	r.HandleFunc(cluster.AggregatePath, api.HandleInternalAggregate(router)).Methods("POST")
+	r.HandleFunc(cluster.ScanJobPath, api.HandleInternalScanJob(router)).Methods("POST")
+	r.HandleFunc("/internal/stream/range", api.HandleInternalStreamRange(router)).Methods("POST")
+	r.HandleFunc("/internal/stream/apply", api.HandleInternalStreamApply(router)).Methods("POST")
+	r.HandleFunc("/internal/cleanup", api.HandleInternalCleanup(router)).Methods("POST")
+	r.HandleFunc("/internal/repair/versions", api.HandleInternalRepairVersions(router)).Methods("GET")
+	r.HandleFunc("/internal/repair/versions", api.HandleInternalKeyVersions(router)).Methods("POST")
+	r.HandleFunc("/internal/repair/fetch", api.HandleInternalRepairFetch(router)).Methods("POST")
+	r.HandleFunc("/internal/count", api.HandleInternalCount(router)).Methods("GET")
+	r.HandleFunc("/internal/keys", api.HandleInternalKeys(store)).Methods("GET")
+	r.HandleFunc("/internal/truncate", api.HandleInternalTruncate(store)).Methods("POST")
+	r.HandleFunc(cluster.IndexesPath, api.HandleInternalCreateIndex(router)).Methods("POST")
+	r.HandleFunc(cluster.IndexesPath+"/{name}", api.HandleInternalDropIndex(router)).Methods("DELETE")
+	r.HandleFunc(cluster.IndexQueryPath, api.HandleInternalIndexQuery(router)).Methods("GET")
+	r.HandleFunc(cluster.SchemasPath, api.HandleInternalSchemas(router)).Methods("GET")
+	r.HandleFunc(cluster.SchemasPath, api.HandleInternalApplySchema(router)).Methods("POST")
+	r.HandleFunc(cluster.SettingsPath, api.HandleInternalSettings(router)).Methods("GET")
+	r.HandleFunc(cluster.SettingsPath, api.HandleInternalApplySetting(router)).Methods("POST")
+	r.HandleFunc(cluster.ReplicationPath, api.HandleInternalReplication(router)).Methods("GET")
+	r.HandleFunc(cluster.ReplicationPath, api.HandleInternalApplyReplication(router)).Methods("POST")
+	r.HandleFunc("/internal/snapshot/prepare", api.HandleSnapshotPrepare(backups)).Methods("POST")
+	r.HandleFunc("/internal/snapshot/commit", api.HandleSnapshotCommit(backups)).Methods("POST")
+	r.HandleFunc("/internal/snapshot/abort", api.HandleSnapshotAbort(backups)).Methods("POST")
+
+	// administração
+	r.HandleFunc("/admin/import", api.HandleImport(router, limits)).Methods("POST")
+	r.HandleFunc("/admin/backup", api.HandleBackup(backups)).Methods("POST")
+	r.HandleFunc("/admin/backups", api.HandleListBackups(backups)).Methods("GET")
+	r.HandleFunc("/admin/restore", api.HandleRestore(backups)).Methods("POST")
+	r.HandleFunc("/admin/flush", api.HandleFlush(engine)).Methods("POST")
+	r.HandleFunc("/admin/compact", api.HandleCompact(engine)).Methods("POST")
+	r.HandleFunc("/admin/storage", api.HandleStorageStats(engine)).Methods("GET")
+	r.HandleFunc("/admin/scrub", api.HandleScrub(engine)).Methods("POST")
+	r.HandleFunc("/admin/drain", api.HandleDrain(router, engine)).Methods("POST")
+	r.HandleFunc("/admin/readonly", api.HandleReadOnly(router)).Methods("POST")
+	r.HandleFunc("/admin/readonly", api.HandleReadOnlyStatus(router)).Methods("GET")
+	r.HandleFunc("/admin/consistency", api.HandleConsistency(router)).Methods("GET")
+	r.HandleFunc("/admin/disk", api.HandleDiskStatus(router)).Methods("GET")
+	r.HandleFunc("/admin/memory", api.HandleMemoryStatus(memGuard)).Methods("GET")
+	r.HandleFunc("/admin/stats", api.HandleStats(router, store)).Methods("GET")
+	r.HandleFunc("/admin/hotkeys", api.HandleHotKeys(router)).Methods("GET")
+	r.HandleFunc("/admin/ownership", api.HandleOwnership(router)).Methods("GET")
+	r.HandleFunc("/admin/ranges", api.HandleRanges(router)).Methods("GET")
+	r.HandleFunc("/admin/rebalance/plan", api.HandleRebalancePlan(router)).Methods("GET")
+	r.HandleFunc("/admin/balance", api.HandleBalancePlan(router)).Methods("GET")
+	r.HandleFunc("/admin/balance", api.HandleBalance(router)).Methods("POST")
+	r.HandleFunc("/admin/rebalance", api.HandleRebalance(router)).Methods("GET")
+	r.HandleFunc("/admin/rebalance/cancel", api.HandleCancelRebalance(router)).Methods("POST")
+	r.HandleFunc("/admin/maintenance", api.HandleMaintenanceStatus(router)).Methods("GET")
+	r.HandleFunc("/admin/antientropy", api.HandleAntiEntropyStatus(router)).Methods("GET")
+	r.HandleFunc("/admin/tokens", api.HandleTokens(router)).Methods("GET")
+	r.HandleFunc("/admin/move-token", api.HandleMoveToken(router)).Methods("POST")
+	r.HandleFunc("/admin/vnodes", api.HandleVNodes(router)).Methods("GET")
+	r.HandleFunc("/admin/vnodes", api.HandleSetVNodes(router)).Methods("POST")
+	r.HandleFunc("/admin/pending-ranges", api.HandlePendingRanges(router)).Methods("GET")
+	r.HandleFunc("/admin/repair", api.HandleRepair(router)).Methods("POST")
+	r.HandleFunc("/admin/repair", api.HandleRepairJobs(router)).Methods("GET")
+	r.HandleFunc("/admin/hints", api.HandleHints(router)).Methods("GET")
+	r.HandleFunc("/admin/observers", api.HandleObservers(router)).Methods("GET")
+	r.HandleFunc("/admin/hints/replay", api.HandleReplayHints(router)).Methods("POST")
+	r.HandleFunc("/admin/hints/drop", api.HandleDropHints(router)).Methods("POST")
+	r.HandleFunc("/admin/protocol", api.HandleProtocol(router)).Methods("GET")
+	r.HandleFunc("/admin/latency", api.HandleLatency(router)).Methods("GET")
+	r.HandleFunc("/admin/metadata", api.HandleAppMetadata(router)).Methods("GET")
+	r.HandleFunc("/admin/metadata", api.HandlePatchAppMetadata(router)).Methods("PATCH")
+	r.HandleFunc("/admin/gc-grace", api.HandleGCGrace(router, store)).Methods("GET")
+	r.HandleFunc("/admin/traces", api.HandleTraces(router)).Methods("GET")
+	r.HandleFunc("/admin/traces/{id}", api.HandleTrace(router)).Methods("GET")
+	r.HandleFunc("/admin/jobs", api.HandleJobs(router)).Methods("GET")
+	r.HandleFunc("/admin/jobs/{id}", api.HandleJob(router)).Methods("GET")
+	r.HandleFunc("/admin/jobs/{id}/cancel", api.HandleCancelJob(router)).Methods("POST")
+	r.HandleFunc("/admin/repair/{id}", api.HandleRepairJob(router)).Methods("GET")
+	r.HandleFunc("/admin/count", api.HandleClusterCount(router)).Methods("GET")
+	r.HandleFunc("/admin/truncate", api.HandleTruncate(router)).Methods("POST")
+	r.HandleFunc("/admin/indexes", api.HandleCreateIndex(router)).Methods("POST")
+	r.HandleFunc("/admin/indexes", api.HandleListIndexes(router)).Methods("GET")
+	r.HandleFunc("/admin/indexes/{name}", api.HandleDropIndex(router)).Methods("DELETE")
+	r.HandleFunc("/admin/schemas", api.HandleListSchemas(router)).Methods("GET")
+	r.HandleFunc("/admin/schemas/{keyspace}", api.HandleSetSchema(router)).Methods("PUT")
+	r.HandleFunc("/admin/schemas/{keyspace}", api.HandleGetSchema(router)).Methods("GET")
+	r.HandleFunc("/admin/schemas/{keyspace}", api.HandleDropSchema(router)).Methods("DELETE")
+	r.HandleFunc("/admin/replication", api.HandleListReplication(router)).Methods("GET")
+	r.HandleFunc("/admin/replication/{keyspace}", api.HandleSetReplication(router)).Methods("PUT")
+	r.HandleFunc("/admin/replication/{keyspace}", api.HandleGetReplication(router)).Methods("GET")
+	r.HandleFunc("/admin/replication/{keyspace}", api.HandleResetReplication(router)).Methods("DELETE")
+	r.HandleFunc("/admin/settings", api.HandleListSettings(router)).Methods("GET")
+	r.HandleFunc("/admin/settings/{name}", api.HandleSetSetting(router)).Methods("PUT")
+	r.HandleFunc("/admin/settings/{name}", api.HandleResetSetting(router)).Methods("DELETE")
+	r.HandleFunc("/admin/snapshot", api.HandleClusterSnapshot(router, backups)).Methods("POST")
+	// partição de rede injetada: só em clusters de teste
+	if e.get("ENABLE_PARTITION_API", "false") == "true" {
+		log.Printf("[PARTITION] /admin/partition enabled (test clusters only)")
+		r.HandleFunc("/admin/partition", api.HandlePartition(router)).Methods("POST")
+		r.HandleFunc("/admin/partition", api.HandleHealPartition(router)).Methods("DELETE")
+		r.HandleFunc("/admin/partition", api.HandlePartitionStatus(router)).Methods("GET")
+	}
+
+	r.HandleFunc("/cluster/status", api.HandleClusterStatus(router)).Methods("GET")
+
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if router.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "DRAINING")
+			return
+		}
+		fmt.Fprintf(w, "OK")
+	})
+	// readiness: 503 também durante o bootstrap (SEEDS, REPLACE_NODE), para
+	// o nó só receber tráfego de cliente depois de ter os dados dele; o corpo
+	// diz se ele está degradado e por quê
+	router.SetReadinessPolicy(cluster.ReadinessPolicy{
+		MaxPendingHints:     e.int("READY_MAX_PENDING_HINTS", cluster.DefaultReadyMaxPendingHints),
+		RepairMaxAge:        e.duration("READY_REPAIR_MAX_AGE", 0),
+		MaxUnrepairedRanges: e.int("READY_MAX_UNREPAIRED_RANGES", 0),
+	})
+	r.HandleFunc("/health/ready", api.HandleReady(router, e.get("READY_FAIL_ON_DEGRADED", "false") == "true")).Methods("GET")
+
+	// métricas (contadores e tempos) no formato do expvar
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	r.HandleFunc("/metrics", api.HandlePrometheus()).Methods("GET")
+	r.HandleFunc("/debug/keys", api.HandleDebugKeys(store)).Methods("GET")
+
+	// HTTP_ROUTER=fast: as rotas quentes (kv por chave e réplica) casam por
+	// prefixo, sem o mux; o resto cai no mux
+	var root http.Handler = r
+	switch mode := e.get("HTTP_ROUTER", "mux"); mode {
+	case "mux":
+	case "fast":
+		fast := api.NewFastRouter(r, mws...)
+		for method, h := range kvHot {
+			fast.HandleKey(method, api.APIVersion+"/kv/", h)
+			fast.HandleKey(method, "/kv/", api.LegacyPath(h))
+		}
+		fast.Handle(http.MethodPost, "/internal/replica/put", replicaPut)
+		fast.Handle(http.MethodGet, "/internal/replica/get", replicaGet)
+		fast.Handle(http.MethodPost, "/internal/replica/delete", replicaDelete)
+		root = fast
+		log.Printf("[HTTP] fast router enabled for kv and replica hot paths")
+	default:
+		return nil, fmt.Errorf("invalid HTTP_ROUTER=%q (mux or fast)", mode)
+	}
+
+	// CORS na API de cliente para dashboards e apps no navegador
+	// (CORS_ALLOWED_ORIGINS vazio desliga)
+	handler := api.CORS(api.CORSConfig{
+		AllowedOrigins: e.list("CORS_ALLOWED_ORIGINS", nil),
+		AllowedMethods: e.list("CORS_ALLOWED_METHODS", api.DefaultCORSMethods),
+		AllowedHeaders: e.list("CORS_ALLOWED_HEADERS", api.DefaultCORSHeaders),
+		MaxAge:         e.int("CORS_MAX_AGE", 600),
+	}, root)
+
+	n.id, n.host, n.listenAddr = nodeID, selfHost, listenAddr
+	n.router, n.engine, n.handler = router, engine, handler
+	// ADMIN_LISTEN_ADDR: /admin/* e /debug/* só nessa porta (que serve a API
+	// inteira); o LISTEN_ADDR responde 404 a eles
+	n.public = handler
+	if n.adminAddr = e.get("ADMIN_LISTEN_ADDR", ""); n.adminAddr != "" {
+		n.public = api.WithoutOperatorPaths(handler)
+	}
+	return n, nil
+}
+
+// background registra uma tarefa que roda do Start até o Stop.
+func (n *Node) background(task func(ctx context.Context)) {
+	n.tasks = append(n.tasks, task)
+}
+
+// after registra fn para rodar d depois do Start (se o nó não parar antes).
+func (n *Node) after(d time.Duration, fn func()) {
+	n.background(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+			fn()
+		}
+	})
+}
+
+// ID é o NODE_ID do nó.
+func (n *Node) ID() string { return n.id }
+
+// Host é o endereço do nó no ring (o host das chamadas pela Network).
+func (n *Node) Host() string { return n.host }
+
+// Handler é a API HTTP inteira do nó (cliente, /internal/* e /admin/*), com
+// os middlewares e o CORS. As rotas são absolutas, então ela vai na raiz do
+// servidor de quem embute: mux.Handle("/", n.Handler()), ou como última rota
+// de um gorilla/mux com r.PathPrefix("/").Handler(n.Handler()).
+func (n *Node) Handler() http.Handler {
+	return n.handler
+}
+
+// Start registra o nó na Network, abre LISTEN_ADDR e ADMIN_LISTEN_ADDR (a não
+// ser com NoListen) e o MEMCACHED_ADDR, e inicia as tarefas de background.
+func (n *Node) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cancel != nil || n.stopped {
+		return errors.New("node already started")
+	}
+	var ln, adminLn net.Listener
+	if !n.cfg.NoListen {
+		var err error
+		if ln, err = net.Listen("tcp", n.listenAddr); err != nil {
+			return fmt.Errorf("listen %s: %w", n.listenAddr, err)
+		}
+		if n.adminAddr != "" {
+			if adminLn, err = net.Listen("tcp", n.adminAddr); err != nil {
+				ln.Close()
+				return fmt.Errorf("admin listener %s: %w", n.adminAddr, err)
+			}
+		}
+	}
+	if n.memcache != nil {
+		mcLn, err := net.Listen("tcp", n.memcacheAddr)
+		if err != nil {
+			if ln != nil {
+				ln.Close()
+			}
+			if adminLn != nil {
+				adminLn.Close()
+			}
+			return fmt.Errorf("memcached listener: %w", err)
+		}
+		n.mcLn = mcLn
+		go n.memcache.Serve(mcLn)
+	}
+	if n.cfg.Network != nil {
+		n.cfg.Network.Register(n.host, n.handler)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	for _, task := range n.tasks {
+		go task(ctx)
+	}
+	n.served = make(chan error, 1)
+	if ln != nil {
+		n.server = &http.Server{Handler: n.public}
+		log.Printf("[HTTP] Listening on %s", n.listenAddr)
+		go func() {
+			err := n.server.Serve(ln)
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			n.served <- err
+		}()
+	}
+	if adminLn != nil {
+		n.admin = &http.Server{Handler: n.handler}
+		log.Printf("[HTTP] Admin endpoints on %s", n.adminAddr)
+		go func() {
+			if err := n.admin.Serve(adminLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("[ERROR] admin listener: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Wait bloqueia até o servidor HTTP parar (Stop ou erro do listener).
+func (n *Node) Wait() error {
+	n.mu.Lock()
+	served := n.served
+	n.mu.Unlock()
+	if served == nil {
+		return errors.New("node not started")
+	}
+	err := <-served
+	served <- err
+	return err
+}
+
+// Stop para de aceitar requisições (esperando as em andamento até ctx
+// terminar), encerra as tarefas de background e fecha o WAL.
+func (n *Node) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil
+	}
+	n.stopped = true
+	if n.cfg.Network != nil {
+		n.cfg.Network.Unregister(n.host)
+	}
+	var err error
+	if n.server != nil {
+		err = n.server.Shutdown(ctx)
+	} else if n.served != nil {
+		n.served <- nil
+	}
+	if n.admin != nil {
+		n.admin.Shutdown(ctx)
+	}
+	if n.mcLn != nil {
+		n.mcLn.Close()
+	}
+	if n.cancel != nil {
+		n.cancel()
+	}
+	if l := n.engine.WAL(); l != nil {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,621 @@
+// Package sim roda um cluster inteiro num processo (pkg/node sobre uma
+// node.Network) sob um scheduler com seed, para reproduzir bugs de
+// consistência do Router e da replicação. A seed decide tudo o que o
+// scheduler controla: a sequência de operações (PUT, DELETE e GET em
+// QUORUM, crash e volta de nós), quais mensagens entre réplicas se perdem em
+// cada passo (node.Drop e node.DropResponse) e o relógio dos timestamps
+// (kv.SetClock avança um passo por vez). Os passos rodam um de cada vez, e o
+// tráfego de background que mexe nos dados (replay de hints, read repair)
+// fica parado até o heal do fim, então rodar a mesma seed de novo repete a
+// mesma execução.
+//
+// O que sobra de não determinístico é a ordem em que as réplicas respondem
+// dentro de um passo: uma leitura em QUORUM fica com as primeiras respostas,
+// e com réplicas divergentes duas execuções podem ver versões diferentes. O
+// verificador aceita qualquer resposta permitida, então isso muda o trace,
+// não o veredito.
+//
+// O verificador checa, a cada leitura, que uma escrita confirmada em QUORUM
+// nunca é "desfeita" (a leitura devolve essa versão ou uma escrita mais nova,
+// confirmada ou não) e, no fim, depois de subir todos os nós, entregar os
+// hints e rodar o repair em todos, que cada chave está igual nas RF réplicas.
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mini-cassandra/internal/cluster"
+	"mini-cassandra/internal/jobs"
+	"mini-cassandra/internal/kv"
+	"mini-cassandra/pkg/node"
+)
+
+// Config é uma execução do simulador. Os zeros viram os padrões, menos Drop
+// e DropResponse (zero é uma rede sem perdas).
+type Config struct {
+	Seed  int64
+	Nodes int // nós no cluster (5)
+	RF    int // fator de replicação (3)
+	Steps int // operações sorteadas (500)
+	Keys  int // chaves distintas da carga (8)
+	// Drop e DropResponse são as chances de cada mensagem entre réplicas
+	// se perder antes de chegar ou depois de aplicada
+	Drop         float64
+	DropResponse float64
+	// MaxDown limita os nós fora do ar ao mesmo tempo (RF/2: um QUORUM
+	// sempre tem réplicas vivas para chegar)
+	MaxDown int
+	// Dir guarda os dados dos nós (vazio: temporário, apagado no fim)
+	Dir string
+	// Trace recebe uma linha por passo (nil descarta)
+	Trace io.Writer
+}
+
+func (c *Config) defaults() {
+	if c.Nodes <= 0 {
+		c.Nodes = 5
+	}
+	if c.RF <= 0 {
+		c.RF = 3
+	}
+	if c.RF > c.Nodes {
+		c.RF = c.Nodes
+	}
+	if c.Steps <= 0 {
+		c.Steps = 500
+	}
+	if c.Keys <= 0 {
+		c.Keys = 8
+	}
+	if c.MaxDown <= 0 {
+		c.MaxDown = c.RF / 2
+	}
+}
+
+// Result é o que uma execução encontrou.
+type Result struct {
+	Seed int64 `json:"seed"`
+	// Violations são as quebras de consistência, com o passo de cada uma
+	Violations []string `json:"violations"`
+	Reads      int      `json:"reads"`
+	Writes     int      `json:"writes"`
+	Failed     int      `json:"failed"` // operações que não atingiram o QUORUM
+	Crashes    int      `json:"crashes"`
+}
+
+// stepKey marca o contexto das operações do scheduler; as chamadas entre
+// nós herdam o contexto da requisição do cliente, então o filtro da rede
+// sabe de qual passo cada mensagem é.
+type stepKey struct{}
+
+// write é uma escrita da carga: o passo que a emitiu (o valor é "v<passo>")
+// e se o QUORUM confirmou.
+type write struct {
+	step    int
+	deleted bool
+	acked   bool
+}
+
+type sim struct {
+	cfg     Config
+	rng     *rand.Rand
+	network *node.Network
+	client  *http.Client
+	nodes   []*node.Node // nil: fora do ar
+	configs []node.Config
+	step    atomic.Int64
+	healing atomic.Bool
+	history map[string][]write
+	res     *Result
+}
+
+// Run executa a simulação de cfg. O erro é da infraestrutura (um nó que não
+// sobe, o heal que não termina); as quebras de consistência vão em
+// Result.Violations.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	cfg.defaults()
+	if cfg.Trace == nil {
+		cfg.Trace = io.Discard
+	}
+	if cfg.Dir == "" {
+		dir, err := os.MkdirTemp("", "mc-sim-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		cfg.Dir = dir
+	}
+	s := &sim{
+		cfg:     cfg,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		network: node.NewNetwork(),
+		history: make(map[string][]write),
+		res:     &Result{Seed: cfg.Seed},
+	}
+	s.client = s.network.Client()
+	s.network.SetFaults(s.fault)
+
+	// relógio lógico: um milissegundo por passo a partir do início, para as
+	// versões seguirem a ordem dos passos (e o gc_grace não ver tombstones
+	// do passado)
+	base := time.Now().UnixMicro()
+	kv.SetClock(func() int64 { return base + s.step.Load()*1000 })
+	defer kv.SetClock(nil)
+
+	members := make([]string, cfg.Nodes)
+	for i := range members {
+		members[i] = fmt.Sprintf("node%d=node%d", i+1, i+1)
+	}
+	s.nodes = make([]*node.Node, cfg.Nodes)
+	s.configs = make([]node.Config, cfg.Nodes)
+	for i := range s.nodes {
+		id := fmt.Sprintf("node%d", i+1)
+		s.configs[i] = node.Config{
+			Env: node.MapEnv(map[string]string{
+				"NODE_ID":            id,
+				"CLUSTER_NODES":      strings.Join(members, ","),
+				"REPLICATION_FACTOR": fmt.Sprint(cfg.RF),
+				"RING_SYNC_ON_START": "false",
+				// só o scheduler mexe nos dados entre os passos
+				"READ_REPAIR_CHANCE":    "0",
+				"ANTI_ENTROPY_INTERVAL": "0",
+				"MAINTENANCE_INTERVAL":  "0",
+			}),
+			Network:  s.network,
+			DataDir:  filepath.Join(cfg.Dir, id),
+			NoListen: true,
+		}
+		if err := s.start(i); err != nil {
+			return nil, err
+		}
+	}
+	defer s.stopAll()
+
+	for step := 1; step <= cfg.Steps; step++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.step.Store(int64(step))
+		if err := s.runStep(ctx, step); err != nil {
+			return nil, fmt.Errorf("step %d: %w", step, err)
+		}
+	}
+	if err := s.heal(ctx); err != nil {
+		return nil, fmt.Errorf("heal: %w", err)
+	}
+	s.checkConverged(ctx)
+	return s.res, nil
+}
+
+// fault decide o destino de uma mensagem entre réplicas. A decisão é um hash
+// de (seed, passo, origem, destino, caminho), não um sorteio na hora, para
+// não depender da ordem em que as goroutines do coordenador enviam.
+func (s *sim) fault(from, to string, req *http.Request) node.Fault {
+	if !strings.HasPrefix(req.URL.Path, "/internal/replica/") {
+		return node.Deliver
+	}
+	step, ok := req.Context().Value(stepKey{}).(int)
+	if !ok {
+		// background (replay de hints, read repair): espera o heal
+		if s.healing.Load() {
+			return node.Deliver
+		}
+		return node.Drop
+	}
+	if s.healing.Load() {
+		return node.Deliver
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d/%s/%s/%s?%s", s.cfg.Seed, step, from, to, req.URL.Path, req.URL.RawQuery)
+	x := float64(h.Sum64()>>11) / (1 << 53)
+	switch {
+	case x < s.cfg.Drop:
+		return node.Drop
+	case x < s.cfg.Drop+s.cfg.DropResponse:
+		return node.DropResponse
+	}
+	return node.Deliver
+}
+
+func (s *sim) start(i int) error {
+	n, err := node.New(s.configs[i])
+	if err == nil {
+		err = n.Start()
+	}
+	if err != nil {
+		return fmt.Errorf("node%d: %w", i+1, err)
+	}
+	s.nodes[i] = n
+	return nil
+}
+
+func (s *sim) stop(i int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.nodes[i].Stop(ctx)
+	s.nodes[i] = nil
+}
+
+func (s *sim) stopAll() {
+	for i, n := range s.nodes {
+		if n != nil {
+			s.stop(i)
+		}
+	}
+}
+
+func (s *sim) tracef(step int, format string, args ...interface{}) {
+	fmt.Fprintf(s.cfg.Trace, "%04d "+format+"\n", append([]interface{}{step}, args...)...)
+}
+
+func (s *sim) violation(step int, format string, args ...interface{}) {
+	v := fmt.Sprintf("step %d: "+format, append([]interface{}{step}, args...)...)
+	s.res.Violations = append(s.res.Violations, v)
+	s.tracef(step, "VIOLATION "+format, args...)
+}
+
+// up e down são os índices dos nós no ar e fora dele, em ordem.
+func (s *sim) up() []int {
+	var out []int
+	for i, n := range s.nodes {
+		if n != nil {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func (s *sim) down() []int {
+	var out []int
+	for i, n := range s.nodes {
+		if n == nil {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// runStep sorteia e executa a operação do passo. Todos os sorteios saem de
+// s.rng, na mesma ordem, para a seed fixar a sequência.
+func (s *sim) runStep(ctx context.Context, step int) error {
+	action := s.rng.Intn(100)
+	key := fmt.Sprintf("k%d", s.rng.Intn(s.cfg.Keys))
+	up, down := s.up(), s.down()
+	coord := up[s.rng.Intn(len(up))]
+
+	switch {
+	case action < 4 && len(down) < s.cfg.MaxDown && len(up) > 1:
+		i := up[s.rng.Intn(len(up))]
+		s.stop(i)
+		s.res.Crashes++
+		s.tracef(step, "crash node%d", i+1)
+	case action < 8 && len(down) > 0:
+		i := down[s.rng.Intn(len(down))]
+		if err := s.start(i); err != nil {
+			return err
+		}
+		s.tracef(step, "restart node%d", i+1)
+	case action < 50:
+		s.put(ctx, step, coord, key)
+	case action < 60:
+		s.del(ctx, step, coord, key)
+	default:
+		s.get(ctx, step, coord, key)
+	}
+	return nil
+}
+
+// request faz uma operação de cliente no coordenador, com o contexto do passo.
+func (s *sim) request(ctx context.Context, step, coord int, method, key, body string) (int, string, error) {
+	ctx = context.WithValue(ctx, stepKey{}, step)
+	u := fmt.Sprintf("http://%s/v1/kv/%s?consistency=QUORUM", s.nodes[coord].Host(), key)
+	req, err := http.NewRequestWithContext(ctx, method, u, strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), err
+}
+
+func (s *sim) put(ctx context.Context, step, coord int, key string) {
+	value := fmt.Sprintf("v%d", step)
+	code, _, err := s.request(ctx, step, coord, http.MethodPut, key, value)
+	w := write{step: step, acked: err == nil && code/100 == 2}
+	s.history[key] = append(s.history[key], w)
+	s.res.Writes++
+	if !w.acked {
+		s.res.Failed++
+	}
+	s.tracef(step, "put %s=%s via node%d: %s", key, value, coord+1, outcome(w.acked, code, err))
+}
+
+func (s *sim) del(ctx context.Context, step, coord int, key string) {
+	code, _, err := s.request(ctx, step, coord, http.MethodDelete, key, "")
+	// 404 num delete: o tombstone foi gravado, só ninguém tinha a chave
+	w := write{step: step, deleted: true, acked: err == nil && (code/100 == 2 || code == http.StatusNotFound)}
+	s.history[key] = append(s.history[key], w)
+	s.res.Writes++
+	if !w.acked {
+		s.res.Failed++
+	}
+	s.tracef(step, "delete %s via node%d: %s", key, coord+1, outcome(w.acked, code, err))
+}
+
+func (s *sim) get(ctx context.Context, step, coord int, key string) {
+	code, body, err := s.request(ctx, step, coord, http.MethodGet, key, "")
+	switch {
+	case err == nil && code == http.StatusOK:
+		s.res.Reads++
+		s.tracef(step, "get %s via node%d: %s", key, coord+1, body)
+		s.checkRead(step, key, body, true)
+	case err == nil && code == http.StatusNotFound:
+		s.res.Reads++
+		s.tracef(step, "get %s via node%d: not found", key, coord+1)
+		s.checkRead(step, key, "", false)
+	default:
+		s.res.Failed++
+		s.tracef(step, "get %s via node%d: %s", key, coord+1, outcome(false, code, err))
+	}
+}
+
+func outcome(ok bool, code int, err error) string {
+	switch {
+	case ok:
+		return "ok"
+	case err != nil:
+		return "failed: " + err.Error()
+	default:
+		return fmt.Sprintf("failed: status %d", code)
+	}
+}
+
+// allowed diz se uma leitura de key pode ter devolvido a escrita do passo
+// got (ou "não encontrada", com found falso): vale a última escrita
+// confirmada ou qualquer escrita posterior a ela, confirmada ou não (uma
+// escrita que falhou pode ter chegado em algumas réplicas).
+func (s *sim) allowed(key string, got int, found bool) (bool, *write) {
+	hist := s.history[key]
+	var last *write
+	from := 0
+	for i := range hist {
+		if hist[i].acked {
+			last, from = &hist[i], i
+		}
+	}
+	if last == nil && !found {
+		return true, nil
+	}
+	for _, w := range hist[from:] {
+		if found && !w.deleted && w.step == got || !found && w.deleted {
+			return true, last
+		}
+	}
+	return false, last
+}
+
+func (s *sim) checkRead(step int, key, body string, found bool) {
+	got := 0
+	if found {
+		if _, err := fmt.Sscanf(body, "v%d", &got); err != nil {
+			s.violation(step, "get %s returned %q, a value nobody wrote", key, body)
+			return
+		}
+	}
+	if ok, last := s.allowed(key, got, found); !ok {
+		s.violation(step, "get %s returned %s, but %s was acknowledged", key, describe(got, found), describeWrite(last))
+	}
+}
+
+func describe(step int, found bool) string {
+	if !found {
+		return "not found"
+	}
+	return fmt.Sprintf("v%d", step)
+}
+
+func describeWrite(w *write) string {
+	if w == nil {
+		return "nothing"
+	}
+	if w.deleted {
+		return fmt.Sprintf("the delete of step %d", w.step)
+	}
+	return fmt.Sprintf("v%d", w.step)
+}
+
+// heal sobe os nós que estão fora, libera a rede, entrega os hints e roda o
+// repair em cada nó, um de cada vez.
+func (s *sim) heal(ctx context.Context) error {
+	s.healing.Store(true)
+	s.step.Add(1)
+	for _, i := range s.down() {
+		if err := s.start(i); err != nil {
+			return err
+		}
+	}
+	for _, n := range s.nodes {
+		if err := s.admin(ctx, http.MethodPost, n, "/admin/hints/replay", nil); err != nil {
+			return err
+		}
+	}
+	if err := s.waitHints(ctx); err != nil {
+		return err
+	}
+	for _, n := range s.nodes {
+		var job jobs.Info
+		if err := s.admin(ctx, http.MethodPost, n, "/admin/repair", &job); err != nil {
+			return err
+		}
+		if err := s.waitJob(ctx, n, job.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heal espera sem limite fixo; um prazo evita travar a simulação num bug
+// de replay ou de repair.
+const healTimeout = time.Minute
+
+func (s *sim) waitHints(ctx context.Context) error {
+	deadline := time.Now().Add(healTimeout)
+	for {
+		pending := 0
+		for _, n := range s.nodes {
+			var qs []cluster.HintStatus
+			if err := s.admin(ctx, http.MethodGet, n, "/admin/hints", &qs); err != nil {
+				return err
+			}
+			for _, q := range qs {
+				pending += q.Pending
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d hints still pending after %s", pending, healTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *sim) waitJob(ctx context.Context, n *node.Node, id string) error {
+	deadline := time.Now().Add(healTimeout)
+	for {
+		var job jobs.Info
+		if err := s.admin(ctx, http.MethodGet, n, "/admin/repair/"+id, &job); err != nil {
+			return err
+		}
+		switch job.Status {
+		case jobs.Done:
+			return nil
+		case jobs.Running:
+		default:
+			return fmt.Errorf("repair %s on %s: %s %s", id, n.ID(), job.Status, job.Error)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("repair %s on %s still running after %s", id, n.ID(), healTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// admin chama um endpoint de administração e decodifica a resposta em out.
+func (s *sim) admin(ctx context.Context, method string, n *node.Node, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+n.Host()+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s on %s: status %d: %s", method, path, n.ID(), resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// version é a cópia de uma chave numa réplica.
+type version struct {
+	node      string
+	timestamp string
+	value     string // "" num tombstone
+}
+
+// checkConverged confere, depois do heal, que cada chave da carga está em RF
+// nós com a mesma versão, e que essa versão é uma das permitidas.
+func (s *sim) checkConverged(ctx context.Context) {
+	step := int(s.step.Load())
+	for k := 0; k < s.cfg.Keys; k++ {
+		key := fmt.Sprintf("k%d", k)
+		if len(s.history[key]) == 0 {
+			continue
+		}
+		var copies []version
+		for _, n := range s.nodes {
+			v, ok, err := s.replicaVersion(ctx, n, key)
+			if err != nil {
+				s.violation(step, "reading %s on %s after heal: %v", key, n.ID(), err)
+				continue
+			}
+			if ok {
+				copies = append(copies, v)
+			}
+		}
+		if len(copies) == 0 {
+			// nenhuma escrita chegou a nenhuma réplica
+			if ok, last := s.allowed(key, 0, false); !ok {
+				s.violation(step, "%s is on no replica after heal, but %s was acknowledged", key, describeWrite(last))
+			}
+			continue
+		}
+		if len(copies) != s.cfg.RF {
+			s.violation(step, "%s is on %d nodes after heal, want %d: %v", key, len(copies), s.cfg.RF, copies)
+		}
+		for _, c := range copies[1:] {
+			if c.timestamp != copies[0].timestamp {
+				s.violation(step, "replicas of %s disagree after heal: %v", key, copies)
+				break
+			}
+		}
+		got := 0
+		found := copies[0].value != ""
+		if found {
+			fmt.Sscanf(copies[0].value, "v%d", &got)
+		}
+		if ok, last := s.allowed(key, got, found); !ok {
+			s.violation(step, "%s converged to %s, but %s was acknowledged", key, describe(got, found), describeWrite(last))
+		}
+		s.tracef(step, "converged %s: %s on %d nodes", key, describe(got, found), len(copies))
+	}
+}
+
+// replicaVersion lê a cópia local de key em n (GET /internal/replica/get).
+func (s *sim) replicaVersion(ctx context.Context, n *node.Node, key string) (version, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+n.Host()+"/internal/replica/get?key="+key, nil)
+	if err != nil {
+		return version{}, false, err
+	}
+	req.Header.Set(cluster.ProtocolHeader, fmt.Sprint(cluster.ProtocolVersion))
+	req.Header.Set(cluster.ClusterNameHeader, cluster.DefaultClusterName)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return version{}, false, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return version{}, false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		ts := resp.Header.Get(cluster.TombstoneHeader)
+		return version{node: n.ID(), timestamp: ts}, ts != "", nil
+	case resp.StatusCode != http.StatusOK:
+		return version{}, false, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return version{node: n.ID(), timestamp: resp.Header.Get(cluster.TimestampHeader), value: string(b)}, true, nil
+}